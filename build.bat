@@ -12,8 +12,19 @@ go mod download
 go mod tidy
 echo.
 
+REM Stamp the binaries with the git tag, commit and commit time
+set VERSION=dev
+set COMMIT=dev
+set DATE=dev
+for /f %%i in ('git describe --tags --dirty 2^>nul') do set VERSION=%%i
+for /f %%i in ('git rev-parse --short HEAD 2^>nul') do set COMMIT=%%i
+for /f %%i in ('git log -1 --format^=%%cI 2^>nul') do set DATE=%%i
+set LDFLAGS=-w -s -X github.com/vpn/wireguard-mesh/pkg/version.Version=%VERSION% -X github.com/vpn/wireguard-mesh/pkg/version.Commit=%COMMIT% -X github.com/vpn/wireguard-mesh/pkg/version.Date=%DATE%
+echo Version %VERSION% (commit %COMMIT%)
+echo.
+
 echo Building server...
-go build -ldflags "-w -s" -o bin\vpn-server.exe .\cmd\server
+go build -trimpath -ldflags "%LDFLAGS%" -o bin\vpn-server.exe .\cmd\server
 if %errorlevel% neq 0 (
     echo Failed to build server
     exit /b %errorlevel%
@@ -22,7 +33,7 @@ echo Server built successfully: bin\vpn-server.exe
 echo.
 
 echo Building client...
-go build -ldflags "-w -s" -o bin\vpn-client.exe .\cmd\client
+go build -trimpath -ldflags "%LDFLAGS%" -o bin\vpn-client.exe .\cmd\client
 if %errorlevel% neq 0 (
     echo Failed to build client
     exit /b %errorlevel%
@@ -13,8 +13,20 @@ go mod download
 go mod tidy
 Write-Host ""
 
+# Stamp the binaries with the git tag, commit and commit time
+$Version = git describe --tags --dirty 2>$null
+if (-not $Version) { $Version = "dev" }
+$Commit = git rev-parse --short HEAD 2>$null
+if (-not $Commit) { $Commit = "dev" }
+$Date = git log -1 --format=%cI 2>$null
+if (-not $Date) { $Date = "dev" }
+$VersionPkg = "github.com/vpn/wireguard-mesh/pkg/version"
+$LdFlags = "-w -s -X $VersionPkg.Version=$Version -X $VersionPkg.Commit=$Commit -X $VersionPkg.Date=$Date"
+Write-Host "Version $Version (commit $Commit)"
+Write-Host ""
+
 Write-Host "Building server..." -ForegroundColor Yellow
-go build -ldflags "-w -s" -o bin\vpn-server.exe .\cmd\server
+go build -trimpath -ldflags $LdFlags -o bin\vpn-server.exe .\cmd\server
 if ($LASTEXITCODE -ne 0) {
     Write-Host "Failed to build server" -ForegroundColor Red
     exit $LASTEXITCODE
@@ -23,7 +35,7 @@ Write-Host "Server built successfully: bin\vpn-server.exe" -ForegroundColor Gree
 Write-Host ""
 
 Write-Host "Building client..." -ForegroundColor Yellow
-go build -ldflags "-w -s" -o bin\vpn-client.exe .\cmd\client
+go build -trimpath -ldflags $LdFlags -o bin\vpn-client.exe .\cmd\client
 if ($LASTEXITCODE -ne 0) {
     Write-Host "Failed to build client" -ForegroundColor Red
     exit $LASTEXITCODE
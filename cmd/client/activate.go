@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runActivate handles the "activate" subcommand: it brings up an on-demand
+// mesh without waiting for traffic
+func runActivate(args []string) {
+	fs := flag.NewFlagSet("activate", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	peer := fs.String("peer", "", "Peer about to be used, by ID, hostname, virtual IP or public key (optional)")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	req := client.ControlRequest{Command: "activate"}
+	if *peer != "" {
+		req.Args = map[string]string{"peer": *peer}
+	}
+	if err := client.Control(cfg, req, nil); err != nil {
+		log.Fatalf("Failed to activate the mesh: %v", err)
+	}
+	if cfg.ActivationMode != config.ActivationOnDemand {
+		fmt.Println("The mesh is always active (activation_mode is not on_demand)")
+		return
+	}
+	fmt.Println("Mesh activated")
+}
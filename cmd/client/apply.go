@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runApplyNow handles the "apply-now" subcommand: it programs the peer
+// list changes held for the maintenance window without waiting for it
+func runApplyNow(args []string) {
+	fs := flag.NewFlagSet("apply-now", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if err := client.Control(cfg, client.ControlRequest{Command: "apply-now"}, nil); err != nil {
+		log.Fatalf("Failed to apply the peer list: %v", err)
+	}
+	fmt.Println("Peer list applied")
+}
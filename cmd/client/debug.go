@@ -0,0 +1,145 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+// runDebug handles the "debug" subcommand
+func runDebug(args []string) {
+	if len(args) == 0 || args[0] != "bundle" {
+		fmt.Fprintf(os.Stderr, "Usage: %s debug bundle -output <file.zip>\n", os.Args[0])
+		os.Exit(2)
+	}
+	runDebugBundle(args[1:])
+}
+
+// runDebugBundle writes a zip archive for bug reports: the redacted config,
+// the pre-flight report, the state file and a snapshot of the host's
+// network, plus the running client's status, logs, peers, events and
+// interface statistics when it is up. Secrets are redacted by the types the
+// data is marshaled through, so the bundle can be attached to an issue.
+func runDebugBundle(args []string) {
+	fs := flag.NewFlagSet("debug bundle", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	output := fs.String("output", "", "Zip file to write (default wgmesh-debug-<time>.zip)")
+	fs.Parse(args)
+
+	if *output == "" {
+		*output = "wgmesh-debug-" + time.Now().UTC().Format("20060102-150405") + ".zip"
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Failed to create bundle: %v", err)
+	}
+	bundle := &debugBundle{zip: zip.NewWriter(f)}
+
+	// The static part needs nothing but the config file and the host
+	var info strings.Builder
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&info, "vpn-client %s\n", version.Get())
+	fmt.Fprintf(&info, "os: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&info, "hostname: %s\n", hostname)
+	fmt.Fprintf(&info, "time: %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&info, "config: %s\n", *configPath)
+
+	bundle.writeJSON("config.json", cfg.Redacted())
+	bundle.writeText("doctor.txt", client.Preflight(cfg, *configPath).String())
+	if data, err := os.ReadFile(client.StatePath(cfg)); err == nil {
+		var state client.State
+		if err := json.Unmarshal(data, &state); err == nil {
+			bundle.writeJSON("state.json", state)
+		}
+	}
+	snapshot := wireguard.NetworkSnapshot()
+	commands := make([]string, 0, len(snapshot))
+	for command := range snapshot {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	var network strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&network, "$ %s\n%s\n", command, snapshot[command])
+	}
+	bundle.writeText("network.txt", network.String())
+
+	// The rest comes from the running client, if there is one
+	var debug client.DebugInfo
+	if err := client.Control(cfg, client.ControlRequest{Command: "debug"}, &debug); err != nil {
+		fmt.Fprintf(&info, "client: %v\n", err)
+		log.Printf("Client is not running; the bundle holds only the static checks")
+	} else {
+		fmt.Fprintf(&info, "client: running\n")
+		bundle.writeJSON("effective-config.json", debug.Config)
+		bundle.writeJSON("status.json", debug.Status)
+		bundle.writeText("client.log", strings.Join(debug.Logs, "\n")+"\n")
+		bundle.writeJSON("peers.json", map[string]interface{}{
+			"peer_list_version": debug.PeerListVersion,
+			"peer_list":         debug.PeerList,
+			"programmed":        debug.Peers,
+		})
+		bundle.writeJSON("events.json", debug.Events)
+		if debug.InterfaceError != "" {
+			bundle.writeText("interface.txt", debug.InterfaceError+"\n")
+		} else {
+			bundle.writeJSON("interface.json", debug.Interface)
+		}
+	}
+	bundle.writeText("info.txt", info.String())
+
+	err = errors.Join(bundle.err, bundle.zip.Close(), f.Close())
+	if err != nil {
+		os.Remove(*output)
+		log.Fatalf("Failed to write bundle: %v", err)
+	}
+	fmt.Printf("Wrote debug bundle to %s\n", *output)
+}
+
+// debugBundle adds files to a zip archive, keeping the first error
+type debugBundle struct {
+	zip *zip.Writer
+	err error
+}
+
+// writeText adds a text file
+func (b *debugBundle) writeText(name, text string) {
+	if b.err != nil {
+		return
+	}
+	w, err := b.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		b.err = err
+		return
+	}
+	_, b.err = w.Write([]byte(text))
+}
+
+// writeJSON adds v as an indented JSON file
+func (b *debugBundle) writeJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.writeText(name+".error", err.Error()+"\n")
+		return
+	}
+	b.writeText(name, string(data)+"\n")
+}
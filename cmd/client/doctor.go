@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runDoctor handles the "doctor" subcommand: it runs the pre-flight checks
+// and prints a pass/fail report, exiting non-zero if anything failed
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	report := client.Preflight(cfg, *configPath)
+	fmt.Print(report)
+
+	if report.Failed() {
+		os.Exit(1)
+	}
+	fmt.Println("All checks passed")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runEvents handles the "events" subcommand: it prints the most recent
+// events recorded by the running client
+func runEvents(args []string) {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	last := fs.Int("last", 50, "Number of events to show")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	req := client.ControlRequest{
+		Command: "events",
+		Args:    map[string]string{"last": strconv.Itoa(*last)},
+	}
+	var events []client.Event
+	if err := client.Control(cfg, req, &events); err != nil {
+		log.Fatalf("Failed to get events: %v", err)
+	}
+
+	for _, event := range events {
+		fmt.Println(formatEvent(event))
+	}
+}
+
+// formatEvent renders an event as a single line
+func formatEvent(event client.Event) string {
+	fields := []string{event.Time.Local().Format(time.RFC3339), string(event.Type)}
+
+	peer := event.Hostname
+	if peer == "" {
+		peer = event.PeerID
+	}
+	if peer == "" {
+		peer = event.PublicKey
+	}
+	if peer != "" {
+		fields = append(fields, "peer="+peer)
+	}
+	if event.VirtualIP != "" {
+		fields = append(fields, "ip="+event.VirtualIP)
+	}
+	if event.Endpoint != "" {
+		fields = append(fields, "endpoint="+event.Endpoint)
+	}
+	if event.Error != "" {
+		fields = append(fields, fmt.Sprintf("error=%q", event.Error))
+	}
+
+	return strings.Join(fields, " ")
+}
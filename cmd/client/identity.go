@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// identityCheckTimeout bounds the dry-run registration after an import
+const identityCheckTimeout = 30 * time.Second
+
+// runIdentity handles the "identity" subcommand: it backs up the client's
+// identity to a passphrase-encrypted file, or restores one from it
+func runIdentity(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s identity export -output <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s identity import <file>\n", os.Args[0])
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	switch args[0] {
+	case "export":
+		runIdentityExport(args[1:])
+	case "import":
+		runIdentityImport(args[1:])
+	default:
+		usage()
+	}
+}
+
+// runIdentityExport writes the identity in the client configuration to an
+// encrypted backup file
+func runIdentityExport(args []string) {
+	fs := flag.NewFlagSet("identity export", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	output := fs.String("output", "", "Backup file to write")
+	passphraseFile := fs.String("passphrase-file", "", "Read the passphrase from this file (default WGMESH_IDENTITY_PASSPHRASE or a prompt)")
+	fs.Parse(args)
+
+	if *output == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s identity export -output <file>\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.Static() {
+		log.Fatalf("%s is in static mode; there is no server identity to back up", *configPath)
+	}
+	identity, err := client.ExportIdentity(cfg)
+	if err != nil {
+		log.Fatalf("Failed to export identity: %v", err)
+	}
+	if identity.PeerID == "" {
+		log.Printf("Warning: the client has not registered yet; the backup holds only its key pair")
+	}
+
+	passphrase, err := readPassphrase(*passphraseFile, true)
+	if err != nil {
+		log.Fatalf("Failed to read passphrase: %v", err)
+	}
+	defer crypto.Zero(passphrase)
+	sealed, err := client.SealIdentity(identity, passphrase)
+	if err != nil {
+		log.Fatalf("Failed to encrypt identity: %v", err)
+	}
+
+	// O_EXCL: never overwrite an earlier backup by accident
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Fatalf("Failed to create backup: %v", err)
+	}
+	if _, err := f.Write(sealed); err != nil {
+		f.Close()
+		os.Remove(*output)
+		log.Fatalf("Failed to write backup: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(*output)
+		log.Fatalf("Failed to write backup: %v", err)
+	}
+
+	fmt.Printf("Exported identity of peer %s (%s) to %s\n", orNone(identity.PeerID), crypto.Fingerprint(identity.PublicKey), *output)
+}
+
+// runIdentityImport restores an identity from a backup file into the client
+// configuration, then checks with the server that the peer is still known.
+// Stop the client first.
+func runIdentityImport(args []string) {
+	fs := flag.NewFlagSet("identity import", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	passphraseFile := fs.String("passphrase-file", "", "Read the passphrase from this file (default WGMESH_IDENTITY_PASSPHRASE or a prompt)")
+	force := fs.Bool("force", false, "Replace an identity the configuration already has")
+	skipCheck := fs.Bool("skip-check", false, "Do not ask the server whether it still knows the peer")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s identity import [flags] <file>\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read backup: %v", err)
+	}
+	passphrase, err := readPassphrase(*passphraseFile, false)
+	if err != nil {
+		log.Fatalf("Failed to read passphrase: %v", err)
+	}
+	identity, err := client.OpenIdentity(data, passphrase)
+	crypto.Zero(passphrase)
+	if err != nil {
+		log.Fatalf("Failed to open backup: %v", err)
+	}
+
+	// The identity goes into the existing configuration, keeping its other
+	// settings; on a fresh machine that is the default one
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.PublicKey != "" && cfg.PublicKey != identity.PublicKey && !*force {
+		log.Fatalf("%s already has the identity %s; pass -force to replace it", *configPath, crypto.Fingerprint(cfg.PublicKey))
+	}
+
+	if err := client.RestoreIdentity(cfg, identity); err != nil {
+		log.Fatalf("Failed to restore identity: %v", err)
+	}
+	if err := config.SaveClientConfig(*configPath, cfg); err != nil {
+		log.Fatalf("Failed to save configuration: %v", err)
+	}
+	fmt.Printf("Restored identity of peer %s (%s) into %s\n", orNone(identity.PeerID), crypto.Fingerprint(identity.PublicKey), *configPath)
+
+	if *skipCheck {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), identityCheckTimeout)
+	defer cancel()
+	status, err := client.CheckIdentity(ctx, cfg)
+	switch {
+	case errors.Is(err, client.ErrPeerUnknown):
+		log.Fatalf("%v. Starting the client will register it as a new peer with a new address, which may need an auth key.", err)
+	case errors.Is(err, client.ErrPeerSuspended):
+		log.Fatalf("%v. Ask an administrator to reinstate peer %s.", err, orNone(identity.PeerID))
+	case err != nil:
+		log.Fatalf("Failed to check the identity with the server: %v", err)
+	}
+
+	fmt.Printf("The server recognizes the key of peer %s\n", orNone(identity.PeerID))
+	if status.ServerKeyChanged {
+		fmt.Println("Warning: the server presents other keys than the backup pinned; the client will check the change when it starts")
+	}
+}
+
+// identityPassphraseEnv holds the backup passphrase for unattended use
+const identityPassphraseEnv = "WGMESH_IDENTITY_PASSPHRASE"
+
+// readPassphrase reads the backup passphrase from a file, the environment or
+// a prompt on the terminal, asking twice when it protects a new backup. The
+// prompt echoes what is typed.
+func readPassphrase(path string, confirm bool) ([]byte, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := bytes.TrimRight(data, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("%s is empty", path)
+		}
+		return passphrase, nil
+	}
+	if env := os.Getenv(identityPassphraseEnv); env != "" {
+		return []byte(env), nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	prompt := func(text string) (string, error) {
+		fmt.Fprint(os.Stderr, text)
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	passphrase, err := prompt("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+	if confirm {
+		again, err := prompt("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if again != passphrase {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return []byte(passphrase), nil
+}
+
+// orNone returns s, or "(none)" when it is empty
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
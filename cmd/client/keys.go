@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runMigrateKeys handles the "migrate-keys" subcommand: it moves the private
+// key between the config file and the OS keychain. Stop the client first.
+func runMigrateKeys(args []string) {
+	fs := flag.NewFlagSet("migrate-keys", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	to := fs.String("to", config.KeyStorageKeychain, `Key storage to move to: "keychain" or "file"`)
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	from := cfg.KeyStorage
+	if from == "" {
+		from = config.KeyStorageFile
+	}
+	if *to != config.KeyStorageFile && *to != config.KeyStorageKeychain {
+		log.Fatalf("Unknown key storage %q", *to)
+	}
+	if from == *to {
+		log.Printf("Keys are already stored in %s storage", *to)
+		return
+	}
+
+	// A key left in the file while key_storage says keychain comes from an
+	// interrupted migration; it is the one to move
+	source, err := config.NewSecretStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", from, err)
+	}
+	if from == config.KeyStorageKeychain && cfg.HasFileSecrets() {
+		fileCfg := *cfg
+		fileCfg.KeyStorage = config.KeyStorageFile
+		source, _ = config.NewSecretStore(&fileCfg)
+	}
+
+	privateKey, err := source.Get(config.SecretPrivateKey)
+	if errors.Is(err, config.ErrSecretNotFound) {
+		log.Fatalf("No private key found in %s storage; nothing to migrate", from)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read private key: %v", err)
+	}
+
+	// Write and verify the new copy before touching the old one
+	cfg.KeyStorage = *to
+	cfg.PrivateKey = ""
+	target, err := config.NewSecretStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open %s storage: %v", *to, err)
+	}
+	if err := target.Set(config.SecretPrivateKey, privateKey); err != nil {
+		log.Fatalf("Failed to store private key: %v", err)
+	}
+	if stored, err := target.Get(config.SecretPrivateKey); err != nil || stored != privateKey {
+		log.Fatalf("Private key did not read back from %s storage: %v", *to, err)
+	}
+
+	if err := config.SaveClientConfig(*configPath, cfg); err != nil {
+		log.Fatalf("Failed to save configuration: %v", err)
+	}
+
+	if from == config.KeyStorageKeychain {
+		if err := source.Delete(config.SecretPrivateKey); err != nil {
+			log.Printf("Warning: failed to remove the old copy of the private key: %v", err)
+		}
+	}
+
+	log.Printf("Moved private key from %s to %s storage", from, *to)
+}
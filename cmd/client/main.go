@@ -1,26 +1,112 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/vpn/wireguard-mesh/pkg/client"
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/internal/systemd"
 	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/version"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "up":
+			// The same as no subcommand, for "up --dry-run"
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "service":
+			runService(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "netcheck":
+			runNetCheck(os.Args[2:])
+			return
+		case "events":
+			runEvents(os.Args[2:])
+			return
+		case "ping":
+			runPing(os.Args[2:])
+			return
+		case "peers":
+			runPeers(os.Args[2:])
+			return
+		case "migrate-keys":
+			runMigrateKeys(os.Args[2:])
+			return
+		case "debug":
+			runDebug(os.Args[2:])
+			return
+		case "identity":
+			runIdentity(os.Args[2:])
+			return
+		case "accept-server-key":
+			runAcceptServerKey(os.Args[2:])
+			return
+		case "activate":
+			runActivate(os.Args[2:])
+			return
+		case "apply-now":
+			runApplyNow(os.Args[2:])
+			return
+		case "plan":
+			runPlan(os.Args[2:])
+			return
+		case "proxy":
+			runProxy(os.Args[2:])
+			return
+		case "version":
+			fmt.Println("vpn-client", version.Get())
+			return
+		}
+	}
+
 	configPath := flag.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
 	serverAddr := flag.String("server", "", "Server address (overrides config)")
 	exitNode := flag.Bool("exit-node", false, "Run as exit node (overrides config)")
+	ephemeral := flag.Bool("ephemeral", false, "Register as an ephemeral peer, removed when the client stops (overrides config)")
+	hidden := flag.Bool("hidden", false, "Register as a hidden peer, left out of other peers' lists (overrides config)")
 	statusCmd := flag.Bool("status", false, "Show client status and exit")
+	versionCmd := flag.Bool("version", false, "Print the version and exit")
+	acceptKeyChange := flag.Bool("accept-server-key-change", false, "Accept and pin the server's keys if they changed since the first registration")
+	authKey := flag.String("auth-key", "", "Pre-auth key for registering as a new peer (overrides WGMESH_AUTH_KEY and config)")
+	dryRun := flag.Bool("dry-run", false, "Show what the client would set up, without changing anything, and exit")
+	asJSON := flag.Bool("json", false, "Print the -dry-run plan as JSON")
 	flag.Parse()
 
-	log.Printf("WireGuard Mesh VPN Client")
+	if *versionCmd {
+		fmt.Println("vpn-client", version.Get())
+		return
+	}
+	if *authKey == "" {
+		*authKey = os.Getenv("WGMESH_AUTH_KEY")
+	}
+
+	// A Windows service has no console, so log to the event log and a file
+	asService := runningAsService()
+	if asService {
+		closeLog, err := setupServiceLogging()
+		if err != nil {
+			log.Fatalf("Failed to set up service logging: %v", err)
+		}
+		defer closeLog()
+	}
+
+	// Keep recent log lines for debug bundles
+	logs := client.NewLogBuffer(client.DefaultLogBufferLines)
+	log.SetOutput(io.MultiWriter(log.Writer(), logs))
+
+	log.Printf("WireGuard Mesh VPN Client %s", version.Get().Version)
 	log.Printf("=========================")
 
 	// Load configuration
@@ -36,39 +122,80 @@ func main() {
 	if *exitNode {
 		cfg.ExitNode = true
 	}
-
-	// Create client
-	c, err := client.NewClient(cfg)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+	if *ephemeral {
+		cfg.Ephemeral = true
+	}
+	if *hidden {
+		cfg.Hidden = true
 	}
 
-	// Handle status command
+	// Handle status command by asking the running client
 	if *statusCmd {
-		status, err := c.Status()
-		if err != nil {
+		var status map[string]interface{}
+		if err := client.Control(cfg, client.ControlRequest{Command: "status"}, &status); err != nil {
 			log.Fatalf("Failed to get status: %v", err)
 		}
 		data, _ := json.MarshalIndent(status, "", "  ")
 		fmt.Println(string(data))
+		if udp, ok := status["udp"].(map[string]interface{}); ok && udp["verdict"] != client.UDPOK && udp["verdict"] != client.UDPUnknown {
+			fmt.Fprintf(os.Stderr, "WARNING: UDP check: %v: %v\n", udp["verdict"], udp["detail"])
+		}
 		return
 	}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	if *dryRun {
+		plan, err := client.DryRun(context.Background(), cfg)
+		if err != nil {
+			log.Fatalf("Dry run failed: %v", err)
+		}
+		printPlan(os.Stdout, plan, *asJSON)
+		return
+	}
 
-	go func() {
-		<-sigChan
-		log.Println("Shutting down client...")
-		if err := c.Stop(); err != nil {
-			log.Printf("Error during shutdown: %v", err)
+	// Create client, persisting generated keys and assignments back to the config file
+	opts := []client.Option{
+		client.WithSaveState(func(cfg *config.ClientConfig) error {
+			return config.SaveClientConfig(*configPath, cfg)
+		}),
+		client.WithLogBuffer(logs),
+	}
+	if *authKey != "" {
+		opts = append(opts, client.WithAuthKey(*authKey))
+	}
+	if *acceptKeyChange {
+		opts = append(opts, client.WithAcceptServerKeyChange())
+	}
+	c, err := client.NewClient(cfg, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+	go watchReload(c, *configPath)
+
+	if asService {
+		if err := runAsService(c); err != nil {
+			log.Fatalf("Service error: %v", err)
 		}
-		os.Exit(0)
-	}()
+		return
+	}
+
+	// Stop the client on interrupt or termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start client
-	if err := c.Start(); err != nil {
+	if err := c.Start(ctx); err != nil {
 		log.Fatalf("Client error: %v", err)
 	}
+
+	// Interface is up and the first sync completed
+	if _, err := systemd.Ready(); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	go systemd.RunWatchdog(c.Healthy, ctx.Done())
+	go func() {
+		<-ctx.Done()
+		systemd.Stopping()
+	}()
+
+	c.Wait()
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runNetCheck handles the "netcheck" subcommand: it shows how the client
+// classifies this host's network interfaces, which endpoints it would
+// advertise, best first, and whether UDP gets through
+func runNetCheck(args []string) {
+	fs := flag.NewFlagSet("netcheck", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	report, err := client.NetCheck(context.Background(), cfg)
+	if err != nil {
+		log.Fatalf("Failed to inspect the network: %v", err)
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	fmt.Println("Interfaces")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  NAME\tLINK\tSTATE\tADDRESSES")
+	for _, iface := range report.Interfaces {
+		state := "up"
+		if iface.Skipped != "" {
+			state = "skipped: " + iface.Skipped
+		}
+		if iface.Preferred {
+			state += ", preferred"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", iface.Name, iface.Link, state, dash(strings.Join(iface.Addresses, ", ")))
+	}
+	tw.Flush()
+
+	fmt.Println("\nEndpoint candidates, best first")
+	if len(report.Candidates) == 0 {
+		fmt.Println("  none")
+	}
+	for i, candidate := range report.Candidates {
+		fmt.Printf("  %d. %s (%s, %s)\n", i+1, candidate.Address, candidate.Interface, candidate.Link)
+	}
+
+	fmt.Println("\nAdvertised endpoints")
+	if len(report.Advertised) == 0 {
+		fmt.Println("  none")
+	}
+	for i, endpoint := range report.Advertised {
+		if i == 0 {
+			fmt.Printf("  %s (primary)\n", endpoint)
+			continue
+		}
+		fmt.Printf("  %s\n", endpoint)
+	}
+
+	fmt.Println("\nUDP")
+	if report.UDP == nil && report.RunningUDP == nil {
+		fmt.Println("  not checked: no stun_servers configured and no client running")
+	}
+	if report.UDP != nil {
+		printUDPStatus("stun_servers", report.UDP)
+	}
+	if report.RunningUDP != nil {
+		printUDPStatus("running client", report.RunningUDP)
+	}
+}
+
+// printUDPStatus prints a UDP check and its probes
+func printUDPStatus(source string, status *client.UDPStatus) {
+	fmt.Printf("  %s: %s", source, strings.ToUpper(status.Verdict))
+	if status.Detail != "" {
+		fmt.Printf(", %s", status.Detail)
+	}
+	fmt.Println()
+	if status.ListenPort != 0 {
+		fmt.Printf("    listen port %d", status.ListenPort)
+		if status.WorkingPort != 0 && status.WorkingPort != status.ListenPort {
+			fmt.Printf(", last worked on %d", status.WorkingPort)
+		}
+		fmt.Println()
+	}
+	for _, probe := range status.Probes {
+		result := "answered, mapped to " + probe.Mapped
+		if !probe.OK {
+			result = "no answer: " + probe.Error
+		}
+		port := ""
+		if probe.WireGuardPort {
+			port = " (WireGuard port)"
+		}
+		fmt.Printf("    %s%s: %s\n", probe.Server, port, result)
+	}
+}
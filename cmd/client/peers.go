@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runPeers handles the "peers" subcommand: it lists the peers the running
+// client has programmed, marking those from the local config
+func runPeers(args []string) {
+	fs := flag.NewFlagSet("peers", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var peers []client.PeerStatus
+	if err := client.Control(cfg, client.ControlRequest{Command: "peers"}, &peers); err != nil {
+		log.Fatalf("Failed to list peers: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tENDPOINT\tALLOWED IPS\tSOURCE")
+	for _, peer := range peers {
+		name := peer.Hostname
+		if name == "" {
+			name = peer.ID
+		}
+		endpoint := peer.Endpoint
+		if peer.ResolvedEndpoint != "" {
+			endpoint += " (" + peer.ResolvedEndpoint + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, dash(peer.VirtualIP), dash(endpoint), strings.Join(peer.AllowedIPs, ","), peer.Source)
+	}
+	w.Flush()
+}
+
+// dash stands in for an empty column
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
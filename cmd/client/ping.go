@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runPing handles the "ping" subcommand: it probes a peer through the mesh
+// and, when the peer does not answer, explains the likely cause. It exits
+// non-zero when the peer is unreachable.
+func runPing(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	count := fs.Int("count", 3, "Number of probes to send")
+	timeout := fs.Duration("timeout", 2*time.Second, "How long to wait for each reply")
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ping [flags] <peer>\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "The peer is a hostname, peer ID, virtual IP or public key.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	result, err := client.Ping(ctx, cfg, fs.Arg(0), client.PingOptions{Count: *count, Timeout: *timeout})
+	if err != nil {
+		log.Fatalf("Failed to ping %s: %v", fs.Arg(0), err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	} else {
+		printPing(result)
+	}
+
+	if !result.Reachable {
+		os.Exit(1)
+	}
+}
+
+// printPing renders a ping result as plain text
+func printPing(result *client.PingResult) {
+	peer := result.Peer
+	fmt.Printf("%s (%s, %s): %d sent, %d received", peer.Hostname, peer.ID, peer.VirtualIP, result.Sent, result.Received)
+	if len(result.RTTs) > 0 {
+		rtts := make([]string, len(result.RTTs))
+		for i, rtt := range result.RTTs {
+			rtts[i] = fmt.Sprintf("%.1f", rtt)
+		}
+		fmt.Printf(", rtt %s ms", strings.Join(rtts, "/"))
+	}
+	fmt.Println()
+
+	if result.Reachable {
+		return
+	}
+
+	fmt.Printf("Unreachable: %s\n", result.Detail)
+	online := "online"
+	if !peer.Online {
+		online = "offline"
+	}
+	handshake := "never"
+	if peer.LastHandshake != nil {
+		handshake = time.Since(*peer.LastHandshake).Round(time.Second).String() + " ago"
+	}
+	endpoint := peer.Endpoint
+	if endpoint == "" {
+		endpoint = "unknown"
+	}
+	fmt.Printf("  server status:  %s\n", online)
+	fmt.Printf("  on interface:   %t\n", peer.Configured)
+	fmt.Printf("  last handshake: %s\n", handshake)
+	fmt.Printf("  endpoint:       %s\n", endpoint)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runPlan handles the "plan" subcommand: it shows what the running client
+// would set up if it brought the mesh up from scratch now
+func runPlan(args []string) {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	asJSON := fs.Bool("json", false, "Print the plan as JSON")
+	fs.Parse(args)
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var plan client.Plan
+	if err := client.Control(cfg, client.ControlRequest{Command: "plan"}, &plan); err != nil {
+		log.Fatalf("Failed to get the plan: %v", err)
+	}
+	printPlan(os.Stdout, &plan, *asJSON)
+}
+
+// printPlan writes a plan as text or JSON
+func printPlan(w io.Writer, plan *client.Plan, asJSON bool) {
+	if asJSON {
+		data, _ := json.MarshalIndent(plan, "", "  ")
+		fmt.Fprintln(w, string(data))
+		return
+	}
+
+	iface := plan.Interface
+	fmt.Fprintf(w, "Interface %s (%s backend)\n", iface.Name, iface.Backend)
+	fmt.Fprintf(w, "  address      %s\n", dash(iface.Address))
+	fmt.Fprintf(w, "  listen port  %d\n", iface.ListenPort)
+	fmt.Fprintf(w, "  mtu          %d\n", iface.MTU)
+
+	fmt.Fprintln(w, "\nRoutes")
+	if len(plan.Routes) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, route := range plan.Routes {
+		line := fmt.Sprintf("  %s dev %s", route.Destination, route.Device)
+		if route.Table != 0 {
+			line += fmt.Sprintf(" table %d", route.Table)
+		}
+		if route.Note != "" {
+			line += " (" + route.Note + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+
+	fmt.Fprintln(w, "\nDNS")
+	if len(plan.DNS) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+	for _, change := range plan.DNS {
+		fmt.Fprintf(w, "  %s\n", change)
+	}
+
+	fmt.Fprintln(w, "\nFirewall")
+	if len(plan.Firewall) == 0 {
+		fmt.Fprintln(w, "  no changes")
+	}
+	for _, rule := range plan.Firewall {
+		fmt.Fprintf(w, "  %s: %s\n", rule.Name, rule.Action)
+	}
+
+	fmt.Fprintf(w, "\nPeers (%d, from %s)\n", len(plan.Peers), plan.PeerSource)
+	if len(plan.Peers) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  NAME\tADDRESS\tENDPOINT\tALLOWED IPS\tSOURCE")
+		for _, peer := range plan.Peers {
+			name := peer.Hostname
+			if name == "" {
+				name = peer.ID
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", name, dash(peer.VirtualIP), dash(peer.Endpoint), dash(strings.Join(peer.AllowedIPs, ",")), peer.Source)
+		}
+		tw.Flush()
+	}
+
+	if len(plan.Preflight) > 0 {
+		fmt.Fprintln(w, "\nPre-flight checks")
+		for _, check := range plan.Preflight {
+			if check.OK {
+				fmt.Fprintf(w, "  [PASS] %s\n", check.Name)
+				continue
+			}
+			fmt.Fprintf(w, "  [FAIL] %s: %s\n", check.Name, check.Error)
+			if check.Hint != "" {
+				fmt.Fprintf(w, "         hint: %s\n", check.Hint)
+			}
+		}
+	}
+
+	if len(plan.Warnings) > 0 {
+		fmt.Fprintln(w, "\nWarnings")
+		for _, warning := range plan.Warnings {
+			fmt.Fprintf(w, "  %s\n", warning)
+		}
+	}
+}
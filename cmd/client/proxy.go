@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runProxy handles the "proxy" subcommand: it joins the mesh as an
+// ephemeral peer on an in-process network stack, without creating an OS
+// interface, and forwards TCP connections from a local listener to a port
+// on one peer. It runs until interrupted.
+func runProxy(args []string) {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	peer := fs.String("peer", "", "Peer to forward to: hostname, peer ID, virtual IP or public key")
+	port := fs.Int("port", 0, "TCP port on the peer")
+	listen := fs.String("listen", "", "Local address to listen on (default 127.0.0.1:<port>)")
+	serverAddr := fs.String("server", "", "Server address (overrides config)")
+	authKey := fs.String("auth-key", "", "Pre-auth key for registering (overrides WGMESH_AUTH_KEY and config)")
+	fs.Parse(args)
+
+	if *peer == "" || *port <= 0 || *port > 65535 {
+		fmt.Fprintf(os.Stderr, "Usage: %s proxy -peer <peer> -port <port> [-listen <addr>]\n", os.Args[0])
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	if *listen == "" {
+		*listen = net.JoinHostPort("127.0.0.1", strconv.Itoa(*port))
+	}
+	if *authKey == "" {
+		*authKey = os.Getenv("WGMESH_AUTH_KEY")
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *serverAddr != "" {
+		cfg.ServerAddr = *serverAddr
+	}
+	if cfg.Static() {
+		log.Fatalf("The proxy needs a coordination server; %s is in static mode", *configPath)
+	}
+
+	// The proxy is a peer of its own, next to any client already running
+	// with this config, so it gets a throwaway identity and state directory
+	stateDir, err := os.MkdirTemp("", "vpn-proxy-")
+	if err != nil {
+		log.Fatalf("Failed to create state directory: %v", err)
+	}
+	defer os.RemoveAll(stateDir)
+	proxyCfg := proxyConfig(cfg, stateDir)
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", *listen, err)
+	}
+	defer ln.Close()
+
+	var opts []client.Option
+	if *authKey != "" {
+		opts = append(opts, client.WithAuthKey(*authKey))
+	}
+	c, err := client.NewClient(proxyCfg, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := c.Start(ctx); err != nil {
+		log.Fatalf("Client error: %v", err)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	log.Printf("Forwarding %s to %s port %d", ln.Addr(), *peer, *port)
+	for {
+		local, err := ln.Accept()
+		if err != nil {
+			break
+		}
+		go forward(ctx, c, local, *peer, *port)
+	}
+
+	stop()
+	c.Wait()
+}
+
+// proxyConfig derives the configuration of an ephemeral proxy peer from the
+// client configuration: the same server and pinned keys, but a new identity
+// and nothing that touches the host
+func proxyConfig(cfg *config.ClientConfig, stateDir string) *config.ClientConfig {
+	proxyCfg := *cfg
+	proxyCfg.PrivateKey = ""
+	proxyCfg.PublicKey = ""
+	proxyCfg.PeerID = ""
+	proxyCfg.AssignedIP = ""
+	proxyCfg.Backend = config.BackendNetstack
+	proxyCfg.SOCKS5Listen = ""
+	proxyCfg.HTTPProxyListen = ""
+	proxyCfg.KeyStorage = config.KeyStorageFile
+	proxyCfg.StateDir = stateDir
+	proxyCfg.ListenPort = 0
+	proxyCfg.ChosenListenPort = 0
+	proxyCfg.FallbackListenPorts = nil
+	proxyCfg.Ephemeral = true
+	proxyCfg.ExitNode = false
+	proxyCfg.ActivationMode = ""
+	proxyCfg.ManageFirewall = false
+	proxyCfg.EnforceACLs = false
+	return &proxyCfg
+}
+
+// forward copies one local connection to and from the peer until either
+// side closes
+func forward(ctx context.Context, c *client.Client, local net.Conn, peer string, port int) {
+	defer local.Close()
+
+	remote, err := c.DialPeer(ctx, "tcp", peer, port)
+	if err != nil {
+		log.Printf("Failed to connect to %s port %d: %v", peer, port, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
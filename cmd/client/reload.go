@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// watchReload re-reads the config file on SIGHUP and applies what can
+// change while the client runs
+func watchReload(c *client.Client, configPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := config.LoadClientConfig(configPath)
+		if err != nil {
+			log.Printf("Reload failed: %v", err)
+			continue
+		}
+		if err := c.Reload(cfg); err != nil {
+			log.Printf("Reload failed: %v", err)
+			continue
+		}
+		log.Printf("Reloaded configuration from %s", configPath)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runAcceptServerKey handles the "accept-server-key" subcommand: it approves
+// the changed server key the running client is waiting on
+func runAcceptServerKey(args []string) {
+	fs := flag.NewFlagSet("accept-server-key", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fingerprint := fs.String("fingerprint", "", "Fingerprint of the new server key, as logged by the client")
+	fs.Parse(args)
+
+	if *fingerprint == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s accept-server-key -fingerprint SHA256:...\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	req := client.ControlRequest{
+		Command: "accept-server-key",
+		Args:    map[string]string{"fingerprint": *fingerprint},
+	}
+	if err := client.Control(cfg, req, nil); err != nil {
+		log.Fatalf("Failed to accept server key: %v", err)
+	}
+	fmt.Println("Accepted the new server key")
+}
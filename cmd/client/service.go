@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const serviceName = "wireguard-mesh-client"
+
+// runService handles the "service" subcommand
+func runService(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s service install|uninstall|start|stop [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = startService()
+	case "stop":
+		err = stopService()
+	default:
+		log.Fatalf("Unknown service command %q", args[0])
+	}
+
+	if err != nil {
+		log.Fatalf("Service %s failed: %v", args[0], err)
+	}
+}
+
+// servicePaths resolves the absolute paths of this binary and the config file
+func servicePaths(configPath string) (string, string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	configFile, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve config path: %w", err)
+	}
+
+	return exe, configFile, nil
+}
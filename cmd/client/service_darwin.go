@@ -0,0 +1,146 @@
+// +build darwin
+
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+const (
+	launchdLabel = "com.wireguard-mesh.client"
+	plistPath    = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	logDir       = "/Library/Logs/wireguard-mesh"
+
+	// launchdPath is the PATH given to the daemon; launchd's default omits
+	// the Homebrew prefixes where wireguard-go is usually installed
+	launchdPath = "/opt/homebrew/bin:/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin"
+)
+
+// installService writes a LaunchDaemon plist and loads it, replacing any
+// previously installed version
+func installService(args []string) error {
+	fs := flag.NewFlagSet("service install", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fs.Parse(args)
+
+	exe, configFile, err := servicePaths(*configPath)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	// Unload an older version first so the new plist takes effect
+	if _, err := os.Stat(plistPath); err == nil {
+		launchctl("bootout", "system/"+launchdLabel)
+	}
+
+	plist := renderPlist([]string{exe, "-config", configFile}, filepath.Join(logDir, "client.log"))
+	if err := os.WriteFile(plistPath, []byte(plist), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", plistPath, err)
+	}
+
+	if err := launchctl("bootstrap", "system", plistPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed and loaded %s\n", plistPath)
+	return nil
+}
+
+// uninstallService unloads the daemon and removes its plist
+func uninstallService() error {
+	if _, err := os.Stat(plistPath); os.IsNotExist(err) {
+		return fmt.Errorf("service %s is not installed", launchdLabel)
+	}
+
+	// Ignore the error, the daemon may not be loaded
+	launchctl("bootout", "system/"+launchdLabel)
+
+	if err := os.Remove(plistPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", plistPath, err)
+	}
+	return nil
+}
+
+// startService loads the daemon, or restarts it if it is already loaded
+func startService() error {
+	if launchctl("print", "system/"+launchdLabel) == nil {
+		return launchctl("kickstart", "-k", "system/"+launchdLabel)
+	}
+	return launchctl("bootstrap", "system", plistPath)
+}
+
+// stopService unloads the daemon; with KeepAlive set, merely killing it
+// would have launchd start it again
+func stopService() error {
+	return launchctl("bootout", "system/"+launchdLabel)
+}
+
+// launchctl runs launchctl with the given arguments
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl %s failed: %w, output: %s", strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
+
+// renderPlist returns the LaunchDaemon property list
+func renderPlist(programArgs []string, logFile string) string {
+	var b strings.Builder
+
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	fmt.Fprintf(&b, "\t<key>Label</key>\n\t<string>%s</string>\n", xmlEscape(launchdLabel))
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range programArgs {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n")
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<true/>\n")
+	fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", xmlEscape(logFile))
+	fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", xmlEscape(logFile))
+	b.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+	fmt.Fprintf(&b, "\t\t<key>PATH</key>\n\t\t<string>%s</string>\n", xmlEscape(launchdPath))
+	b.WriteString("\t</dict>\n")
+	b.WriteString("</dict>\n</plist>\n")
+
+	return b.String()
+}
+
+// xmlEscape escapes s for use as XML character data
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// runningAsService reports whether a service manager that needs a dedicated
+// control loop started this process; launchd does not
+func runningAsService() bool {
+	return false
+}
+
+// setupServiceLogging is only needed on Windows
+func setupServiceLogging() (func(), error) {
+	return func() {}, nil
+}
+
+// runAsService is only needed on Windows
+func runAsService(c *client.Client) error {
+	return fmt.Errorf("not running under a service manager")
+}
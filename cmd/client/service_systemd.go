@@ -0,0 +1,74 @@
+// +build !windows,!darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/internal/systemd"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// installService installs the client as a systemd unit
+func installService(args []string) error {
+	fs := flag.NewFlagSet("service install", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	user := fs.String("user", "root", "User the service runs as")
+	fs.Parse(args)
+
+	exe, configFile, err := servicePaths(*configPath)
+	if err != nil {
+		return err
+	}
+
+	unit := systemd.Unit{
+		Name:         serviceName,
+		Description:  "WireGuard Mesh VPN Client",
+		ExecStart:    []string{exe, "-config", configFile},
+		User:         *user,
+		Capabilities: []string{"CAP_NET_ADMIN"},
+		WatchdogSec:  60,
+	}
+
+	path, err := unit.Install()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s\n", path)
+	fmt.Printf("Start it with: systemctl start %s\n", serviceName)
+	return nil
+}
+
+// uninstallService stops and removes the systemd unit
+func uninstallService() error {
+	return systemd.Uninstall(serviceName)
+}
+
+// startService starts the systemd unit
+func startService() error {
+	return systemd.Start(serviceName)
+}
+
+// stopService stops the systemd unit
+func stopService() error {
+	return systemd.Stop(serviceName)
+}
+
+// runningAsService reports whether a service manager that needs a dedicated
+// control loop started this process; systemd does not
+func runningAsService() bool {
+	return false
+}
+
+// setupServiceLogging is only needed on Windows
+func setupServiceLogging() (func(), error) {
+	return func() {}, nil
+}
+
+// runAsService is only needed on Windows
+func runAsService(c *client.Client) error {
+	return fmt.Errorf("not running under a service manager")
+}
@@ -0,0 +1,279 @@
+// +build windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// serviceStopTimeout bounds how long `service stop` waits for the client to exit
+const serviceStopTimeout = 30 * time.Second
+
+// installService registers the client with the service control manager
+func installService(args []string) error {
+	fs := flag.NewFlagSet("service install", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultClientConfigPath(), "Path to client configuration file")
+	fs.Parse(args)
+
+	exe, configFile, err := servicePaths(*configPath)
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+
+	// The TUN device is created in-process, which requires LocalSystem
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName:      "WireGuard Mesh VPN Client",
+		Description:      "Connects this machine to the WireGuard mesh network",
+		StartType:        mgr.StartAutomatic,
+		ServiceStartName: "LocalSystem",
+	}, "-config", configFile)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	// Restart after 5s, then 30s, then every minute; reset after a day
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to set recovery policy: %w", err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register event log source: %w", err)
+	}
+
+	fmt.Printf("Installed service %s\n", serviceName)
+	fmt.Printf("Start it with: %s service start\n", filepath.Base(exe))
+	return nil
+}
+
+// uninstallService stops and removes the service
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	// Ignore the error, the service may not be running
+	controlAndWait(s, svc.Stop, svc.Stopped)
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := eventlog.Remove(serviceName); err != nil {
+		log.Printf("Warning: failed to remove event log source: %v", err)
+	}
+
+	return nil
+}
+
+// startService asks the service manager to start the client
+func startService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+	return nil
+}
+
+// stopService asks the service manager to stop the client and waits for it
+func stopService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+
+	return controlAndWait(s, svc.Stop, svc.Stopped)
+}
+
+// controlAndWait sends a control request and waits for the service to reach
+// the given state
+func controlAndWait(s *mgr.Service, cmd svc.Cmd, want svc.State) error {
+	status, err := s.Control(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to send control request: %w", err)
+	}
+
+	deadline := time.Now().Add(serviceStopTimeout)
+	for status.State != want {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for service to reach state %d", want)
+		}
+		time.Sleep(300 * time.Millisecond)
+
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service status: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// runningAsService reports whether the service control manager started us
+func runningAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Printf("Warning: failed to detect service mode: %v", err)
+		return false
+	}
+	return isService
+}
+
+// setupServiceLogging sends log output to the Windows event log and to a
+// file under ProgramData, since a service has no console
+func setupServiceLogging() (func(), error) {
+	dir := filepath.Join(os.Getenv("ProgramData"), "wireguard-mesh")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, "client.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+
+	// The default slog handler writes through the log package, so this
+	// covers the client's structured logs too
+	log.SetOutput(io.MultiWriter(file, &eventLogWriter{elog: elog}))
+
+	return func() {
+		elog.Close()
+		file.Close()
+	}, nil
+}
+
+// eventLogWriter adapts the event log to an io.Writer, picking the event
+// type from the log level
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+
+	var err error
+	switch {
+	case strings.Contains(msg, " ERROR "):
+		err = w.elog.Error(1, msg)
+	case strings.Contains(msg, " WARN "), strings.Contains(msg, "Warning:"):
+		err = w.elog.Warning(1, msg)
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// runAsService runs the client under the service control manager until it
+// is asked to stop
+func runAsService(c *client.Client) error {
+	return svc.Run(serviceName, &clientService{client: c})
+}
+
+// clientService implements svc.Handler
+type clientService struct {
+	client *client.Client
+}
+
+// Execute starts the client and translates service control requests
+func (s *clientService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	if err := s.client.Start(context.Background()); err != nil {
+		log.Printf("Client error: %v", err)
+		return true, 1
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.client.Wait()
+		close(stopped)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				s.client.Stop()
+				return false, 0
+			default:
+				log.Printf("Warning: unexpected service control request %d", req.Cmd)
+			}
+		case <-stopped:
+			// Report failure so the recovery policy restarts us
+			log.Printf("Client stopped unexpectedly")
+			return true, 1
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+)
+
+// handleDrainSignals drains the server on SIGUSR1 and ends the drain on
+// SIGUSR2, for maintenance scripts without the admin token
+func handleDrainSignals(srv *server.Server) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range sigChan {
+			if sig == syscall.SIGUSR2 {
+				srv.Undrain()
+				continue
+			}
+			if err := srv.Drain(); err != nil {
+				log.Printf("Error while draining: %v", err)
+			}
+		}
+	}()
+}
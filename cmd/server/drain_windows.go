@@ -0,0 +1,9 @@
+// +build windows
+
+package main
+
+import "github.com/vpn/wireguard-mesh/internal/server"
+
+// handleDrainSignals does nothing: Windows has no SIGUSR1 or SIGUSR2, so the
+// server is drained through /admin/drain
+func handleDrainSignals(srv *server.Server) {}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runInvite handles the "invite" subcommand, managing the time-limited
+// guest invites of the running server
+func runInvite(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s invite create|list|revoke [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "create":
+		createInvite(args[1:])
+	case "list":
+		listInvites(args[1:])
+	case "revoke":
+		revokeInvite(args[1:])
+	default:
+		log.Fatalf("Unknown invite command %q", args[0])
+	}
+}
+
+// createInvite issues an invite and prints its key, and optionally the
+// command the guest runs to join. The key is shown only this once.
+func createInvite(args []string) {
+	fs := flag.NewFlagSet("invite create", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	expires := fs.Duration("expires", 24*time.Hour, "How long each guest peer keeps access after joining")
+	valid := fs.Duration("valid", 0, "How long the invite may be used to join (default -expires)")
+	maxUses := fs.Int("max-uses", 1, "How many devices may join with the invite; 0 is unlimited")
+	tags := fs.String("tags", server.GuestTag, "Comma-separated tags applied to guest peers")
+	useExit := fs.String("use-exit", "false", "Whether guest peers may route through exit nodes: true or false")
+	destinations := fs.String("destinations", "", "Comma-separated selectors (hostnames or tag:<name>) of the only peers guests may reach")
+	note := fs.String("note", "", "Who the invite is for")
+	serverURL := fs.String("server-url", "", "Server address guests connect to; prints a ready-to-send join command")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		log.Fatalf("Unexpected argument %q", fs.Arg(0))
+	}
+	if *expires <= 0 {
+		log.Fatalf("-expires must be positive")
+	}
+	exitNodes, err := strconv.ParseBool(*useExit)
+	if err != nil {
+		log.Fatalf("Invalid -use-exit %q: must be true or false", *useExit)
+	}
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	req := map[string]interface{}{
+		"expires_in":     int(expires.Seconds()),
+		"valid_for":      int(valid.Seconds()),
+		"max_uses":       *maxUses,
+		"tags":           splitTags(*tags),
+		"use_exit_nodes": exitNodes,
+		"destinations":   splitTags(*destinations),
+		"note":           *note,
+	}
+	var resp struct {
+		Key    string        `json:"key"`
+		Invite server.Invite `json:"invite"`
+	}
+	if err := adminRequest(cfg, http.MethodPost, "/admin/invites", req, &resp); err != nil {
+		log.Fatalf("Failed to create invite: %v", err)
+	}
+
+	fmt.Println(resp.Key)
+	if *serverURL != "" {
+		fmt.Fprintf(os.Stderr, "Join with:\n  vpn-client -server %s -auth-key %s\n", *serverURL, resp.Key)
+	}
+	fmt.Fprintf(os.Stderr, "Created invite %s (%s); the key will not be shown again\n", resp.Invite.ID, describeInvite(&resp.Invite))
+}
+
+// listInvites prints the invites known to the server and their peers
+func listInvites(args []string) {
+	fs := flag.NewFlagSet("invite list", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var resp struct {
+		Invites []server.Invite `json:"invites"`
+	}
+	if err := adminRequest(cfg, http.MethodGet, "/admin/invites", nil, &resp); err != nil {
+		log.Fatalf("Failed to list invites: %v", err)
+	}
+	if len(resp.Invites) == 0 {
+		fmt.Println("No invites")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tUSES\tACCESS\tPEERS\tNOTE")
+	for _, invite := range resp.Invites {
+		state := "valid"
+		switch {
+		case invite.RevokedAt != nil:
+			state = "revoked"
+		case invite.ExpiresAt != nil && !time.Now().Before(*invite.ExpiresAt):
+			state = "expired"
+		case invite.MaxUses > 0 && invite.Uses >= invite.MaxUses:
+			state = "used up"
+		}
+		uses := fmt.Sprintf("%d", invite.Uses)
+		if invite.MaxUses > 0 {
+			uses = fmt.Sprintf("%d/%d", invite.Uses, invite.MaxUses)
+		}
+		peers := strings.Join(invite.Peers, ",")
+		if peers == "" {
+			peers = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", invite.ID, state, uses,
+			time.Duration(invite.Guest.Duration)*time.Second, peers, invite.Guest.Note)
+	}
+	tw.Flush()
+}
+
+// revokeInvite stops an invite from being used and removes the guest peers
+// that joined with it
+func revokeInvite(args []string) {
+	fs := flag.NewFlagSet("invite revoke", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s invite revoke [flags] <id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if err := adminRequest(cfg, http.MethodDelete, "/admin/invites/"+url.PathEscape(fs.Arg(0)), nil, nil); err != nil {
+		log.Fatalf("Failed to revoke invite: %v", err)
+	}
+	fmt.Printf("Revoked invite %s and removed its guest peers\n", fs.Arg(0))
+}
+
+// describeInvite summarizes the terms of an invite
+func describeInvite(invite *server.Invite) string {
+	parts := []string{
+		fmt.Sprintf("%s of access", time.Duration(invite.Guest.Duration)*time.Second),
+		describeToken(&invite.AuthKey),
+	}
+	if !invite.Guest.UseExitNodes {
+		parts = append(parts, "no exit nodes")
+	}
+	if len(invite.Guest.Destinations) > 0 {
+		parts = append(parts, "destinations "+strings.Join(invite.Guest.Destinations, ","))
+	}
+	return strings.Join(parts, ", ")
+}
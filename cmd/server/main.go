@@ -1,23 +1,70 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/internal/systemd"
 	"github.com/vpn/wireguard-mesh/pkg/config"
-	"github.com/vpn/wireguard-mesh/pkg/server"
+	"github.com/vpn/wireguard-mesh/pkg/version"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "service":
+			runService(os.Args[2:])
+			return
+		case "export":
+			runExport(os.Args[2:])
+			return
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "tokens":
+			runTokens(os.Args[2:])
+			return
+		case "invite":
+			runInvite(os.Args[2:])
+			return
+		case "peers":
+			runPeers(os.Args[2:])
+			return
+		case "rotate-key":
+			runRotateKey(os.Args[2:])
+			return
+		case "audit":
+			runAudit(os.Args[2:])
+			return
+		case "version":
+			fmt.Println("vpn-server", version.Get())
+			return
+		}
+	}
+
 	configPath := flag.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
 	listenAddr := flag.String("listen", "", "Server listen address (overrides config)")
 	networkCIDR := flag.String("network", "", "VPN network CIDR (overrides config)")
+	migrateNetwork := flag.Bool("migrate-network", false, "Renumber stored peers that lie outside the network CIDR")
+	versionCmd := flag.Bool("version", false, "Print the version and exit")
 	flag.Parse()
 
-	log.Printf("WireGuard Mesh VPN Server")
+	if *versionCmd {
+		fmt.Println("vpn-server", version.Get())
+		return
+	}
+
+	log.Printf("WireGuard Mesh VPN Server %s", version.Get().Version)
 	log.Printf("=========================")
 
 	// Load configuration
@@ -33,6 +80,7 @@ func main() {
 	if *networkCIDR != "" {
 		cfg.NetworkCIDR = *networkCIDR
 	}
+	cfg.MigrateNetwork = *migrateNetwork
 
 	// Create server
 	srv, err := server.NewServer(cfg)
@@ -43,11 +91,32 @@ func main() {
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	handleDrainSignals(srv)
+
+	// Tell systemd we are up once the listener is bound
+	go func() {
+		select {
+		case <-srv.Ready():
+		case <-stopping:
+			return
+		}
+		if _, err := systemd.Ready(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		systemd.RunWatchdog(srv.Healthy, stopping)
+	}()
 
 	go func() {
 		<-sigChan
+		close(stopping)
+		systemd.Stopping()
 		log.Println("Shutting down server...")
-		os.Exit(0)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error during shutdown: %v", err)
+		}
 	}()
 
 	// Start server
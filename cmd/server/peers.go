@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// runPeers handles the "peers" subcommand, showing and managing the peers
+// of the running server
+func runPeers(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s peers list|approve|suspend|remove [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		listPeers(args[1:])
+	case "approve":
+		managePeer("approve", http.MethodPost, "/approve", "Approved", args[1:])
+	case "suspend":
+		managePeer("suspend", http.MethodPost, "/suspend", "Suspended", args[1:])
+	case "remove":
+		managePeer("remove", http.MethodDelete, "", "Removed", args[1:])
+	default:
+		log.Fatalf("Unknown peers command %q", args[0])
+	}
+}
+
+// listPeers prints every peer with its state and, for offline peers, why
+// they are offline and since when
+func listPeers(args []string) {
+	fs := flag.NewFlagSet("peers list", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	peers, err := fetchPeers(cfg)
+	if err != nil {
+		log.Fatalf("Failed to list peers: %v", err)
+	}
+	if len(peers) == 0 {
+		fmt.Println("No peers")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tHOSTNAME\tADDRESS\tFINGERPRINT\tSTATE\tSTATUS\tLAST ONLINE")
+	for _, peer := range peers {
+		state := "online"
+		lastOnline := "-"
+		if !peer.Online {
+			state = "offline"
+			if peer.OfflineReason != "" {
+				state = "offline: " + peer.OfflineReason
+			}
+			if peer.LastOnlineAt != nil {
+				lastOnline = peer.LastOnlineAt.Local().Format(time.DateTime)
+			}
+		}
+		status := peer.Status
+		if status == server.PeerStatusActive {
+			status = "active"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", peer.ID, peer.Hostname, peer.VirtualIP, peer.Fingerprint, state, status, lastOnline)
+	}
+	tw.Flush()
+}
+
+// managePeer approves, suspends or removes the peer a selector picks
+func managePeer(name, method, action, done string, args []string) {
+	fs := flag.NewFlagSet("peers "+name, flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s peers %s [flags] <peer ID, public key or fingerprint>\n", os.Args[0], name)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	peers, err := fetchPeers(cfg)
+	if err != nil {
+		log.Fatalf("Failed to list peers: %v", err)
+	}
+	peer, err := selectPeer(peers, fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to select peer: %v", err)
+	}
+
+	if err := adminRequest(cfg, method, "/admin/peers/"+url.PathEscape(peer.ID)+action, nil, nil); err != nil {
+		log.Fatalf("Failed to %s peer %s: %v", name, peer.ID, err)
+	}
+	fmt.Printf("%s peer %s (%s, %s)\n", done, peer.ID, peer.Hostname, peer.Fingerprint)
+}
+
+// fetchPeers lists the peers of the running server
+func fetchPeers(cfg *config.ServerConfig) ([]server.AdminPeer, error) {
+	var resp struct {
+		Peers []server.AdminPeer `json:"peers"`
+	}
+	if err := adminRequest(cfg, http.MethodGet, "/admin/peers", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Peers, nil
+}
+
+// selectPeer finds the one peer a selector names: its peer ID, its public
+// key, or its key fingerprint or a prefix of it. A prefix matching several
+// peers is refused with the matches, so more characters can be given.
+func selectPeer(peers []server.AdminPeer, selector string) (*server.AdminPeer, error) {
+	for i := range peers {
+		if peers[i].ID == selector || peers[i].PublicKey == selector {
+			return &peers[i], nil
+		}
+	}
+
+	var matches []*server.AdminPeer
+	for i := range peers {
+		if crypto.MatchesFingerprint(peers[i].PublicKey, selector) {
+			matches = append(matches, &peers[i])
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no peer matches %q; give a peer ID, a public key or at least %d characters of a fingerprint",
+			selector, crypto.MinFingerprintPrefix)
+	case 1:
+		return matches[0], nil
+	}
+
+	var list strings.Builder
+	for _, peer := range matches {
+		fmt.Fprintf(&list, "\n  %s  %s (%s)", peer.Fingerprint, peer.ID, peer.Hostname)
+	}
+	return nil, fmt.Errorf("fingerprint %q matches %d peers; give more characters:%s", selector, len(matches), list.String())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runRestore handles the "restore" subcommand, asking the running server to
+// swap in a snapshot
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	snapshot := fs.String("snapshot", "", "Timestamp of the snapshot to restore")
+	list := fs.Bool("list", false, "List available snapshots")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if *list || *snapshot == "" {
+		var resp struct {
+			Snapshots []string `json:"snapshots"`
+		}
+		if err := adminRequest(cfg, http.MethodGet, "/admin/snapshots", nil, &resp); err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		if len(resp.Snapshots) == 0 {
+			fmt.Println("No snapshots")
+		}
+		for _, timestamp := range resp.Snapshots {
+			fmt.Println(timestamp)
+		}
+		if !*list {
+			fmt.Fprintln(os.Stderr, "Pass --snapshot <timestamp> to restore one")
+			os.Exit(2)
+		}
+		return
+	}
+
+	if err := adminRequest(cfg, http.MethodPost, "/admin/restore?snapshot="+url.QueryEscape(*snapshot), nil, nil); err != nil {
+		log.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	fmt.Printf("Restored snapshot %s\n", *snapshot)
+}
+
+// adminRequest calls the admin API of the server running with cfg, over its
+// unix socket or on the loopback address of its TCP port. A non-nil in is
+// sent as the JSON request body.
+func adminRequest(cfg *config.ServerConfig, method, path string, in, out interface{}) error {
+	if cfg.AdminToken == "" {
+		return fmt.Errorf("admin_token is not set in the server configuration")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	base := "http://unix"
+	if cfg.ListenNetwork == "unix" {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", cfg.ListenAddr)
+			},
+		}
+	} else {
+		_, port, err := net.SplitHostPort(cfg.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", cfg.ListenAddr, err)
+		}
+		base = "http://" + net.JoinHostPort("127.0.0.1", port)
+	}
+	base += cfg.URLBasePath()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Admin-Token", cfg.AdminToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("server not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// runRotateKey handles the "rotate-key" subcommand, giving the server a new
+// key pair endorsed by the old one. The server must be stopped.
+func runRotateKey(args []string) {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s rotate-key [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if cfg.PrivateKey == "" {
+		log.Fatalf("The server has no key to rotate yet; start it once to generate one")
+	}
+
+	// The running server would keep using, and later save, the old key
+	lock, err := server.LockStore(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer lock.Release()
+
+	oldPublicKey := cfg.PublicKey
+	if err := server.RotateKey(cfg, time.Now()); err != nil {
+		log.Fatalf("Failed to rotate key: %v", err)
+	}
+	if err := config.SaveServerConfig(*configPath, cfg); err != nil {
+		log.Fatalf("Failed to save configuration: %v", err)
+	}
+
+	fmt.Printf("Rotated server key\n")
+	fmt.Printf("  old: %s\n", crypto.Fingerprint(oldPublicKey))
+	fmt.Printf("  new: %s\n", crypto.Fingerprint(cfg.PublicKey))
+	fmt.Printf("Start the server; clients move to the new key when they next register.\n")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/vpn/wireguard-mesh/internal/systemd"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+const serviceName = "wireguard-mesh-server"
+
+// runService handles the "service" subcommand
+func runService(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s service install [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "install":
+		installService(args[1:])
+	default:
+		log.Fatalf("Unknown service command %q", args[0])
+	}
+}
+
+// installService installs the server as a systemd unit
+func installService(args []string) {
+	fs := flag.NewFlagSet("service install", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	user := fs.String("user", "root", "User the service runs as (must be able to write the config and database)")
+	fs.Parse(args)
+
+	exe, configFile := servicePaths(*configPath)
+
+	unit := systemd.Unit{
+		Name:        serviceName,
+		Description: "WireGuard Mesh VPN Server",
+		ExecStart:   []string{exe, "-config", configFile},
+		User:        *user,
+		WatchdogSec: 60,
+	}
+
+	path, err := unit.Install()
+	if err != nil {
+		log.Fatalf("Failed to install service: %v", err)
+	}
+
+	fmt.Printf("Installed %s\n", path)
+	fmt.Printf("Start it with: systemctl start %s\n", serviceName)
+}
+
+// servicePaths resolves the absolute paths of this binary and the config file
+func servicePaths(configPath string) (string, string) {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Failed to locate executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	configFile, err := filepath.Abs(configPath)
+	if err != nil {
+		log.Fatalf("Failed to resolve config path: %v", err)
+	}
+
+	return exe, configFile
+}
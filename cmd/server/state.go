@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runExport handles the "export" subcommand, archiving the server state for
+// migration or backup
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	output := fs.String("output", "state.tar.gz", "Archive to write")
+	includeKeys := fs.Bool("include-keys", false, "Include the server private key and admin token")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	store, err := server.NewPeerStore(cfg.DBPath)
+	if err != nil {
+		log.Fatalf("Failed to open peer store: %v", err)
+	}
+	peers, err := store.LoadPeers()
+	store.Close()
+	if err != nil {
+		log.Fatalf("Failed to read peer store: %v", err)
+	}
+
+	allocationStore, err := server.NewAllocationStore(server.AllocationsPath(cfg))
+	if err != nil {
+		log.Fatalf("Failed to open allocation table: %v", err)
+	}
+	allocations := allocationStore.List()
+	allocationStore.Close()
+
+	authKeyStore, err := server.NewAuthKeyStore(server.AuthKeysPath(cfg))
+	if err != nil {
+		log.Fatalf("Failed to open auth keys: %v", err)
+	}
+	authKeys := authKeyStore.List()
+	authKeyStore.Close()
+
+	// Archives may carry keys, so they are never readable by others
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatalf("Failed to create archive: %v", err)
+	}
+	if err := f.Chmod(0600); err != nil {
+		log.Fatalf("Failed to restrict archive permissions: %v", err)
+	}
+
+	if err := server.ExportState(f, cfg, peers, allocations, authKeys, *includeKeys); err != nil {
+		f.Close()
+		os.Remove(*output)
+		log.Fatalf("Failed to export state: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Failed to write archive: %v", err)
+	}
+
+	fmt.Printf("Exported %d peers to %s\n", len(peers), *output)
+	if !*includeKeys {
+		fmt.Println("Keys were not included; the new host keeps or generates its own")
+	}
+}
+
+// runImport handles the "import" subcommand, restoring an archive written by
+// export into the configured paths
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	force := fs.Bool("force", false, "Replace an existing non-empty peer store")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s import [flags] <archive>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open archive: %v", err)
+	}
+	defer f.Close()
+
+	manifest, err := server.ImportState(f, *configPath, *force)
+	if err != nil {
+		log.Fatalf("Failed to import state: %v", err)
+	}
+
+	fmt.Printf("Imported %d peers (network %s) into %s\n", manifest.Peers, manifest.NetworkCIDR, *configPath)
+}
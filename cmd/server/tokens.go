@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// runTokens handles the "tokens" subcommand, managing the pre-auth keys of
+// the running server
+func runTokens(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s tokens create|list|revoke [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "create":
+		createToken(args[1:])
+	case "list":
+		listTokens(args[1:])
+	case "revoke":
+		revokeToken(args[1:])
+	default:
+		log.Fatalf("Unknown tokens command %q", args[0])
+	}
+}
+
+// createToken issues a pre-auth key and prints it. The key is shown only
+// this once.
+func createToken(args []string) {
+	fs := flag.NewFlagSet("tokens create", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	expires := fs.Duration("expires", 24*time.Hour, "How long the key stays valid; 0 never expires")
+	maxUses := fs.Int("max-uses", 1, "How many peers may register with the key; 0 is unlimited")
+	tags := fs.String("tags", "", "Comma-separated tags applied to peers registering with the key")
+	ephemeral := fs.Bool("ephemeral", false, "Remove peers registered with the key once they go offline")
+	maxActivePeers := fs.Int("max-active-peers", 0, "How many peers registered with the key may exist at once; 0 is unlimited")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	req := map[string]interface{}{
+		"expires_in": int(expires.Seconds()),
+		"max_uses":   *maxUses,
+		"tags":       splitTags(*tags),
+		"ephemeral":  *ephemeral,
+
+		"max_active_peers": *maxActivePeers,
+	}
+	var resp struct {
+		Key     string         `json:"key"`
+		AuthKey server.AuthKey `json:"auth_key"`
+	}
+	if err := adminRequest(cfg, http.MethodPost, "/admin/authkeys", req, &resp); err != nil {
+		log.Fatalf("Failed to create auth key: %v", err)
+	}
+
+	fmt.Println(resp.Key)
+	fmt.Fprintf(os.Stderr, "Created auth key %s (%s); it will not be shown again\n", resp.AuthKey.ID, describeToken(&resp.AuthKey))
+}
+
+// listTokens prints the pre-auth keys known to the server
+func listTokens(args []string) {
+	fs := flag.NewFlagSet("tokens list", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	var resp struct {
+		AuthKeys []server.AuthKey `json:"auth_keys"`
+	}
+	if err := adminRequest(cfg, http.MethodGet, "/admin/authkeys", nil, &resp); err != nil {
+		log.Fatalf("Failed to list auth keys: %v", err)
+	}
+	if len(resp.AuthKeys) == 0 {
+		fmt.Println("No auth keys")
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tUSES\tEXPIRES\tTAGS\tEPHEMERAL")
+	for _, key := range resp.AuthKeys {
+		state := "valid"
+		switch {
+		case key.RevokedAt != nil:
+			state = "revoked"
+		case key.ExpiresAt != nil && !time.Now().Before(*key.ExpiresAt):
+			state = "expired"
+		case key.MaxUses > 0 && key.Uses >= key.MaxUses:
+			state = "used up"
+		}
+		uses := fmt.Sprintf("%d", key.Uses)
+		if key.MaxUses > 0 {
+			uses = fmt.Sprintf("%d/%d", key.Uses, key.MaxUses)
+		}
+		expires := "never"
+		if key.ExpiresAt != nil {
+			expires = key.ExpiresAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\n", key.ID, state, uses, expires, strings.Join(key.Tags, ","), key.Ephemeral)
+	}
+	tw.Flush()
+}
+
+// revokeToken stops a pre-auth key from registering further peers
+func revokeToken(args []string) {
+	fs := flag.NewFlagSet("tokens revoke", flag.ExitOnError)
+	configPath := fs.String("config", config.GetDefaultServerConfigPath(), "Path to server configuration file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s tokens revoke [flags] <id>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadServerConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if err := adminRequest(cfg, http.MethodDelete, "/admin/authkeys/"+url.PathEscape(fs.Arg(0)), nil, nil); err != nil {
+		log.Fatalf("Failed to revoke auth key: %v", err)
+	}
+	fmt.Printf("Revoked auth key %s\n", fs.Arg(0))
+}
+
+// splitTags parses a comma-separated tag list
+func splitTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// describeToken summarizes the constraints of a key
+func describeToken(key *server.AuthKey) string {
+	parts := []string{"expires never"}
+	if key.ExpiresAt != nil {
+		parts[0] = "expires " + key.ExpiresAt.Local().Format(time.RFC3339)
+	}
+	if key.MaxUses > 0 {
+		parts = append(parts, fmt.Sprintf("%d uses", key.MaxUses))
+	} else {
+		parts = append(parts, "unlimited uses")
+	}
+	if key.MaxActivePeers > 0 {
+		parts = append(parts, fmt.Sprintf("at most %d active peers", key.MaxActivePeers))
+	}
+	if len(key.Tags) > 0 {
+		parts = append(parts, "tags "+strings.Join(key.Tags, ","))
+	}
+	if key.Ephemeral {
+		parts = append(parts, "ephemeral")
+	}
+	return strings.Join(parts, ", ")
+}
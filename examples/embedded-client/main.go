@@ -0,0 +1,71 @@
+// Command embedded-client joins this host to a mesh from inside another
+// program, printing the peers as they come and go:
+//
+//	sudo go run ./examples/embedded-client -server http://vpn.example.com:8080
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/vpn/wireguard-mesh/meshvpn"
+)
+
+func main() {
+	configPath := flag.String("config", "client.json", "Configuration file, created if missing")
+	serverAddr := flag.String("server", "", "Coordination server URL (overrides the config)")
+	flag.Parse()
+
+	cfg, err := meshvpn.LoadClientConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if *serverAddr != "" {
+		cfg.ServerAddr = *serverAddr
+	}
+
+	// Keep the generated keys and the assigned address across runs
+	c, err := meshvpn.NewClient(cfg, meshvpn.WithSaveState(func(cfg *meshvpn.ClientConfig) error {
+		return meshvpn.SaveClientConfig(*configPath, cfg)
+	}))
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := c.Start(ctx); err != nil {
+		log.Fatalf("Failed to start client: %v", err)
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		log.Fatalf("Failed to get status: %v", err)
+	}
+	fmt.Printf("Joined as %s on %s (%s)\n", status.AssignedIP, status.InterfaceName, status.Fingerprint)
+
+	for {
+		select {
+		case event, ok := <-c.Events():
+			if !ok {
+				return
+			}
+			switch event.Type {
+			case meshvpn.EventPeerAdded:
+				fmt.Printf("+ %s %s\n", event.Hostname, event.VirtualIP)
+			case meshvpn.EventPeerRemoved:
+				fmt.Printf("- %s %s\n", event.Hostname, event.VirtualIP)
+			}
+		case <-ctx.Done():
+			if err := c.Stop(); err != nil {
+				log.Printf("Failed to stop client: %v", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Command embedded-server runs a coordination server behind an HTTP server
+// of its own and uses the admin API to approve new peers whose hostnames
+// start with a given prefix, leaving the others pending:
+//
+//	go run ./examples/embedded-server -addr :8080 -approve build-
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/meshvpn"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "Address to serve the API on")
+	dir := flag.String("dir", "server-state", "Directory for the peer store")
+	prefix := flag.String("approve", "", "Approve pending peers whose hostname starts with this")
+	flag.Parse()
+
+	cfg := meshvpn.DefaultServerConfig()
+	cfg.ListenAddr = *addr
+	cfg.NetworkCIDR = "10.100.0.0/16"
+	cfg.DBPath = filepath.Join(*dir, "peers.json")
+	cfg.RequireApproval = true
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if cfg.AdminToken == "" {
+		log.Fatalf("Set ADMIN_TOKEN")
+	}
+
+	srv, err := meshvpn.NewServer(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	if err := srv.StartBackground(); err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	httpServer := &http.Server{Addr: *addr, Handler: srv.Handler()}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Failed to serve: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	admin, err := meshvpn.NewAdmin("http://"+*addr, cfg.AdminToken, nil)
+	if err != nil {
+		log.Fatalf("Failed to create admin client: %v", err)
+	}
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if *prefix != "" {
+				approvePending(ctx, admin, *prefix)
+			}
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			httpServer.Shutdown(shutdownCtx)
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Failed to shut down: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// approvePending approves the pending peers whose hostname has the prefix
+func approvePending(ctx context.Context, admin *meshvpn.Admin, prefix string) {
+	peers, _, err := admin.Peers(ctx)
+	if err != nil {
+		log.Printf("Failed to list peers: %v", err)
+		return
+	}
+	for _, peer := range peers {
+		if peer.Status != meshvpn.PeerStatusPending || !strings.HasPrefix(peer.Hostname, prefix) {
+			continue
+		}
+		if err := admin.ApprovePeer(ctx, peer.ID); err != nil {
+			log.Printf("Failed to approve %s: %v", peer.ID, err)
+			continue
+		}
+		log.Printf("Approved %s (%s), key fingerprint %s", peer.ID, peer.Hostname, peer.Fingerprint)
+	}
+}
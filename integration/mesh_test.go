@@ -0,0 +1,347 @@
+//go:build integration && linux
+// +build integration,linux
+
+// Package integration runs a real coordination server and two real clients
+// with kernel WireGuard in network namespaces on this machine, and checks
+// that the clients register, sync and ping each other across the tunnel,
+// and that stopping them leaves no links, routes or processes behind. It
+// needs root and the wireguard kernel module, and is left out of normal
+// test runs:
+//
+//	sudo go test -tags integration ./integration/...
+//	sudo go test -tags integration ./integration/... -integration.bin ./bin -integration.keep
+package integration
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/internal/testutil/netns"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+var (
+	prebuilt    = flag.String("integration.bin", "", "Directory holding vpn-server and vpn-client (default: build them)")
+	meshTimeout = flag.Duration("integration.timeout", 60*time.Second, "How long the mesh may take to come up")
+	keep        = flag.Bool("integration.keep", false, "Keep the working directory with configs and logs")
+)
+
+const (
+	serverPort      = "18080"
+	meshCIDR        = "10.99.0.0/24"
+	meshInterface   = "wgit0"
+	stopTimeout     = 10 * time.Second
+	namespacePrefix = "wgmesh-it-"
+)
+
+// node is a namespace running one of the binaries
+type node struct {
+	name    string
+	ns      *netns.Namespace
+	cmd     *exec.Cmd
+	exited  chan error
+	config  string // Path of its configuration file
+	logPath string
+}
+
+func TestKernelMesh(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root to create namespaces and interfaces")
+	}
+	if err := netns.SupportsWireGuard(); err != nil {
+		t.Skipf("cannot run: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "wgmesh-integration-")
+	if err != nil {
+		t.Fatalf("Failed to create working directory: %v", err)
+	}
+	if *keep {
+		t.Logf("Working directory: %s", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	bin := *prebuilt
+	if bin == "" {
+		bin = filepath.Join(dir, "bin")
+		if err := build(bin); err != nil {
+			t.Fatalf("Failed to build binaries: %v", err)
+		}
+	}
+
+	if err := run(t, dir, bin, *meshTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// build compiles the server and client into dir
+func build(dir string) error {
+	for name, pkg := range map[string]string{
+		"vpn-server": "github.com/vpn/wireguard-mesh/cmd/server",
+		"vpn-client": "github.com/vpn/wireguard-mesh/cmd/client",
+	} {
+		cmd := exec.Command("go", "build", "-o", filepath.Join(dir, name), pkg)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", pkg, err)
+		}
+	}
+	return nil
+}
+
+// run sets up the namespaces, brings up the mesh and tears it down again
+func run(t *testing.T, dir, binDir string, timeout time.Duration) (err error) {
+	// The server's namespace routes between the clients' two networks, so
+	// the clients reach each other through it as through the internet
+	hub, err := netns.New(namespacePrefix + "server")
+	if err != nil {
+		return err
+	}
+	defer hub.Close()
+	if err := hub.EnableForwarding(); err != nil {
+		return err
+	}
+
+	var clients []*node
+	for i := 1; i <= 2; i++ {
+		ns, err := netns.New(fmt.Sprintf("%sclient%d", namespacePrefix, i))
+		if err != nil {
+			return err
+		}
+		defer ns.Close()
+
+		upstream := netns.Link{Namespace: hub, Name: fmt.Sprintf("veth%d", i), Address: fmt.Sprintf("10.201.%d.1/24", i)}
+		local := netns.Link{Namespace: ns, Name: "eth0", Address: fmt.Sprintf("10.201.%d.2/24", i)}
+		if err := netns.Connect(upstream, local); err != nil {
+			return err
+		}
+		if err := ns.Run("ip", "route", "add", "default", "via", upstream.IP()); err != nil {
+			return err
+		}
+		clients = append(clients, &node{name: fmt.Sprintf("client%d", i), ns: ns})
+	}
+
+	server := &node{name: "server", ns: hub}
+	if err := writeServerConfig(dir, server); err != nil {
+		return err
+	}
+	if err := server.start(binDir, dir, "vpn-server"); err != nil {
+		return err
+	}
+	defer server.stop()
+
+	for i, c := range clients {
+		serverAddr := fmt.Sprintf("http://10.201.%d.1:%s", i+1, serverPort)
+		if err := writeClientConfig(dir, c, serverAddr); err != nil {
+			return err
+		}
+		if err := c.start(binDir, dir, "vpn-client"); err != nil {
+			return err
+		}
+		defer c.stop()
+	}
+	defer func() {
+		if err != nil {
+			for _, n := range append([]*node{server}, clients...) {
+				n.dumpLog(t)
+			}
+		}
+	}()
+
+	// Each client must be registered and have the other programmed
+	addresses := make([]string, len(clients))
+	deadline := time.Now().Add(timeout)
+	for i, c := range clients {
+		address, err := waitForMesh(c, len(clients)-1, deadline)
+		if err != nil {
+			return err
+		}
+		addresses[i] = address
+		t.Logf("%s is up as %s", c.name, address)
+	}
+
+	// Handshakes take a moment, so the first pings may be lost
+	for i, c := range clients {
+		peer := addresses[(i+1)%len(addresses)]
+		if err := c.ns.Run("ping", "-c", "3", "-W", "2", peer); err != nil {
+			return fmt.Errorf("%s cannot reach %s across the tunnel: %w", c.name, peer, err)
+		}
+		t.Logf("%s pinged %s", c.name, peer)
+	}
+
+	// A stopped client takes down its interface and routes with it
+	for _, c := range clients {
+		if err := c.stop(); err != nil {
+			return err
+		}
+		if err := checkClean(c.ns); err != nil {
+			return fmt.Errorf("%s left state behind: %w", c.name, err)
+		}
+		t.Logf("%s stopped cleanly", c.name)
+	}
+	return server.stop()
+}
+
+// writeServerConfig writes a server configuration listening on every
+// address of its namespace
+func writeServerConfig(dir string, n *node) error {
+	cfg := config.DefaultServerConfig()
+	cfg.ListenAddr = "0.0.0.0:" + serverPort
+	cfg.NetworkCIDR = meshCIDR
+	cfg.DBPath = filepath.Join(dir, n.name, "peers.json")
+	return writeConfig(dir, n, cfg)
+}
+
+// writeClientConfig writes a client configuration for the kernel backend.
+// The network address mode gives the interface a connected route to the
+// whole mesh.
+func writeClientConfig(dir string, n *node, serverAddr string) error {
+	cfg := config.DefaultClientConfig()
+	cfg.ServerAddr = serverAddr
+	cfg.InterfaceName = meshInterface
+	cfg.StateDir = filepath.Join(dir, n.name)
+	cfg.AddressMode = config.AddressModeNetwork
+	return writeConfig(dir, n, cfg)
+}
+
+func writeConfig(dir string, n *node, cfg interface{}) error {
+	if err := os.MkdirAll(filepath.Join(dir, n.name), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	n.config = filepath.Join(dir, n.name, "config.json")
+	return os.WriteFile(n.config, data, 0600)
+}
+
+// start runs a binary in the node's namespace, logging to a file
+func (n *node) start(binDir, dir, binary string) error {
+	n.logPath = filepath.Join(dir, n.name, "log.txt")
+	logFile, err := os.Create(n.logPath)
+	if err != nil {
+		return err
+	}
+
+	n.cmd = n.ns.Command(filepath.Join(binDir, binary), "-config", n.config)
+	n.cmd.Stdout, n.cmd.Stderr = logFile, logFile
+	if err := n.cmd.Start(); err != nil {
+		logFile.Close()
+		return fmt.Errorf("failed to start %s: %w", n.name, err)
+	}
+	n.exited = make(chan error, 1)
+	go func() {
+		n.exited <- n.cmd.Wait()
+		logFile.Close()
+	}()
+	return nil
+}
+
+// stop asks the process to shut down and waits for it. It is safe to call
+// more than once.
+func (n *node) stop() error {
+	if n.cmd == nil || n.exited == nil {
+		return nil
+	}
+	defer func() { n.exited = nil }()
+
+	n.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-n.exited:
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return fmt.Errorf("%s: %w", n.name, err)
+		}
+		return nil
+	case <-time.After(stopTimeout):
+		n.cmd.Process.Kill()
+		<-n.exited
+		return fmt.Errorf("%s did not stop within %s", n.name, stopTimeout)
+	}
+}
+
+// dumpLog logs the node's log, for a failed run
+func (n *node) dumpLog(t *testing.T) {
+	data, err := os.ReadFile(n.logPath)
+	if err != nil {
+		return
+	}
+	t.Logf("--- %s log ---\n%s", n.name, data)
+}
+
+// waitForMesh waits until a client has an address and the given number of
+// peers programmed, returning the address
+func waitForMesh(n *node, peers int, deadline time.Time) (string, error) {
+	cfg, err := config.LoadClientConfig(n.config)
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-n.exited:
+			n.exited = nil
+			return "", fmt.Errorf("%s exited: %v", n.name, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		var status client.Status
+		if lastErr = client.Control(cfg, client.ControlRequest{Command: "status"}, &status); lastErr != nil {
+			continue
+		}
+		var programmed []client.PeerStatus
+		if lastErr = client.Control(cfg, client.ControlRequest{Command: "peers"}, &programmed); lastErr != nil {
+			continue
+		}
+		address := status.AssignedIP
+		if address != "" && len(programmed) >= peers {
+			return address, nil
+		}
+		lastErr = fmt.Errorf("address %q, %d of %d peers programmed", address, len(programmed), peers)
+	}
+	return "", fmt.Errorf("%s did not join the mesh: %v", n.name, lastErr)
+}
+
+// checkClean verifies that a namespace holds no mesh interface and no route
+// through it or into the mesh
+func checkClean(ns *netns.Namespace) error {
+	links, err := ns.Links()
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link == meshInterface {
+			return fmt.Errorf("interface %s still exists", link)
+		}
+	}
+
+	routes, err := ns.Routes()
+	if err != nil {
+		return err
+	}
+	meshPrefix := strings.TrimSuffix(meshCIDR, ".0/24")
+	for _, route := range routes {
+		if strings.Contains(route, "dev "+meshInterface) || strings.HasPrefix(route, meshPrefix) {
+			return fmt.Errorf("route %q still exists", route)
+		}
+	}
+
+	// The kernel backend needs no helper process
+	if output, err := exec.Command("pgrep", "-f", "wireguard-go.*"+meshInterface).Output(); err == nil {
+		return fmt.Errorf("wireguard-go still running: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+// enforceACL installs, or refreshes when they changed, the firewall rules
+// letting only the allowed sources in on the interface. Until the first
+// peer list arrives the set is empty, so nothing but replies gets in.
+// Failure is logged and returned; the peer list still limits who is
+// configured. Callers must hold c.mu.
+func (c *Client) enforceACL(sources []string) error {
+	if !c.config.EnforceACLs || c.interfaceName == "" {
+		return nil
+	}
+
+	sorted := append([]string(nil), sources...)
+	sort.Strings(sorted)
+	if c.aclRules != nil && sameStrings(sorted, c.aclSources) {
+		return nil
+	}
+
+	rules, err := wireguard.ApplyACL(c.interfaceName, c.assignedIP, sorted)
+	if err != nil {
+		c.logger.Warn("Failed to enforce ACLs on the interface", "interface", c.interfaceName, "error", err)
+		return err
+	}
+	if err := c.state.Update(func(s *State) { s.ACL = rules }); err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+	c.aclRules = rules
+	c.aclSources = sorted
+	c.logger.Info("Enforcing ACLs on the interface", "interface", rules.Interface, "tool", rules.Tool, "sources", len(sorted))
+	return nil
+}
+
+// removeACL takes out the rules installed by enforceACL. It returns false
+// when they are still in place, leaving them recorded for the next start.
+func (c *Client) removeACL() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.aclRules == nil {
+		return true
+	}
+	if err := c.cleaner.RemoveACL(*c.aclRules); err != nil {
+		c.logger.Warn("Failed to remove ACL", "interface", c.aclRules.Interface, "error", err)
+		return false
+	}
+
+	c.aclRules = nil
+	c.aclSources = nil
+	if err := c.state.Update(func(s *State) { s.ACL = nil }); err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+func TestInterfaceAddress(t *testing.T) {
+	tests := []struct {
+		ip, networkCIDR, mode string
+		want                  string
+	}{
+		{"10.100.0.7", "10.100.0.0/16", config.AddressModeHost, "10.100.0.7/32"},
+		{"10.100.0.7", "10.100.0.0/16", "", "10.100.0.7/32"},
+		{"10.100.0.7", "10.100.0.0/16", config.AddressModeNetwork, "10.100.0.7/16"},
+		{"10.100.0.7", "10.100.0.0/24", config.AddressModeNetwork, "10.100.0.7/24"},
+		{"fd00::7", "fd00::/64", config.AddressModeNetwork, "fd00::7/64"},
+		// Outside the network, or with no network known, the address stays a host
+		{"10.200.0.7", "10.100.0.0/16", config.AddressModeNetwork, "10.200.0.7/32"},
+		{"10.100.0.7", "", config.AddressModeNetwork, "10.100.0.7/32"},
+		{"10.100.0.7", "garbage", config.AddressModeNetwork, "10.100.0.7/32"},
+	}
+	for _, tt := range tests {
+		if got := interfaceAddress(tt.ip, tt.networkCIDR, tt.mode); got != tt.want {
+			t.Errorf("interfaceAddress(%s, %s, %q) = %s, want %s", tt.ip, tt.networkCIDR, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestAddressModeOnInterface(t *testing.T) {
+	for _, tt := range []struct {
+		mode          string
+		first, second string
+	}{
+		{config.AddressModeHost, "10.200.0.2/32", "10.200.0.9/32"},
+		{config.AddressModeNetwork, "10.200.0.2/24", "10.200.0.9/24"},
+	} {
+		t.Run(tt.mode, func(t *testing.T) {
+			srv, _ := hangingServer(t, true)
+			c, backend := newServerClient(t, srv, func(cfg *config.ClientConfig) {
+				cfg.AddressMode = tt.mode
+				cfg.RequestTimeout = 1 // The first peer sync hangs until it times out
+			})
+			if err := c.Start(context.Background()); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer waitStopped(t, c)
+			defer c.Stop()
+
+			if got := backend.Config().Address; got != tt.first {
+				t.Errorf("interface created with %s, want %s", got, tt.first)
+			}
+
+			// A new assignment changes the address in place, in the same mode
+			if err := c.setAssignedIP("10.200.0.9", "10.200.0.0/24"); err != nil {
+				t.Fatalf("setAssignedIP: %v", err)
+			}
+			if got := backend.Config().Address; got != tt.second {
+				t.Errorf("interface moved to %s, want %s", got, tt.second)
+			}
+		})
+	}
+}
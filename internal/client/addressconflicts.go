@@ -0,0 +1,60 @@
+package client
+
+import (
+	"bytes"
+	"net"
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// findAddressConflicts returns every virtual IP held by more than one of the
+// peers, counting ourselves as holding selfIP, ordered by address. Such
+// peers cannot all be routed to, so none of them are programmed.
+func findAddressConflicts(selfID, selfIP string, peers []protocol.PeerInfo) []protocol.AddressConflict {
+	holders := make(map[string][]string)
+	if ip := net.ParseIP(selfIP); ip != nil {
+		holders[ip.String()] = []string{selfID}
+	}
+	for _, peer := range peers {
+		ip := net.ParseIP(peer.VirtualIP)
+		if ip == nil || peer.ID == selfID {
+			continue
+		}
+		key := ip.String()
+		if !containsString(holders[key], peer.ID) {
+			holders[key] = append(holders[key], peer.ID)
+		}
+	}
+
+	var conflicts []protocol.AddressConflict
+	for ip, ids := range holders {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		conflicts = append(conflicts, protocol.AddressConflict{IP: ip, PeerIDs: ids})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(conflicts[i].IP).To16(), net.ParseIP(conflicts[j].IP).To16()) < 0
+	})
+	return conflicts
+}
+
+// setAddressConflicts records the conflicts found in the last peer list for
+// the next heartbeat, logging those that are new. Callers must hold c.mu.
+func (c *Client) setAddressConflicts(conflicts []protocol.AddressConflict) {
+	known := make(map[string]bool, len(c.addressConflicts))
+	for _, conflict := range c.addressConflicts {
+		known[conflict.IP] = true
+	}
+	for _, conflict := range conflicts {
+		if !known[conflict.IP] {
+			c.logger.Warn("Virtual IP held by more than one peer, not programming them", "virtual_ip", conflict.IP, "peer_ids", conflict.PeerIDs)
+		}
+	}
+	if len(conflicts) == 0 && len(c.addressConflicts) > 0 {
+		c.logger.Info("Virtual IP conflicts resolved")
+	}
+	c.addressConflicts = conflicts
+}
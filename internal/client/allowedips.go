@@ -0,0 +1,211 @@
+package client
+
+import (
+	"net"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// AllowedIPs policies
+const (
+	// AllowedIPsStrict drops dangerous AllowedIPs received from the server
+	AllowedIPsStrict = "strict"
+	// AllowedIPsPermissive programs everything but still logs the problems
+	AllowedIPsPermissive = "permissive"
+)
+
+// allowedIPsFilter vets the AllowedIPs the server hands out, so that a
+// malicious or compromised coordination server cannot silently hijack
+// traffic
+type allowedIPsFilter struct {
+	strict   bool
+	exitNode string
+	local    []*net.IPNet
+	server   []net.IP
+}
+
+// newAllowedIPsFilter snapshots the local subnets and the coordination
+// server's addresses
+func (c *Client) newAllowedIPsFilter() *allowedIPsFilter {
+	f := &allowedIPsFilter{
+		strict:   c.config.AllowedIPsPolicy != AllowedIPsPermissive,
+		exitNode: c.config.UseExitNode,
+	}
+
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			// Our own tunnel addresses are expected to overlap the mesh
+			if iface.Name == c.interfaceName {
+				continue
+			}
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok {
+					f.local = append(f.local, &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask})
+				}
+			}
+		}
+	}
+
+	if c.servers != nil {
+		for _, u := range c.servers.urls {
+			if ips, err := net.LookupIP(u.Hostname()); err == nil {
+				f.server = append(f.server, ips...)
+			}
+		}
+	}
+
+	return f
+}
+
+// filterAllowedIPs returns the AllowedIPs of peer that may be programmed, logging every
+// entry that violates the policy
+func (c *Client) filterAllowedIPs(f *allowedIPsFilter, peer protocol.PeerInfo) []string {
+	allowed := make([]string, 0, len(peer.AllowedIPs))
+	for _, entry := range peer.AllowedIPs {
+		reason := f.violation(peer, entry)
+		if reason == "" {
+			allowed = append(allowed, entry)
+			continue
+		}
+
+		if f.strict {
+			c.logger.Warn("Dropping AllowedIPs entry from peer", "peer_id", peer.ID, "hostname", peer.Hostname, "allowed_ip", entry, "reason", reason)
+			continue
+		}
+
+		c.logger.Warn("Programming unsafe AllowedIPs entry (permissive policy)", "peer_id", peer.ID, "hostname", peer.Hostname, "allowed_ip", entry, "reason", reason)
+		allowed = append(allowed, entry)
+	}
+
+	return allowed
+}
+
+// violation explains why an AllowedIPs entry is unsafe, or returns ""
+func (f *allowedIPsFilter) violation(peer protocol.PeerInfo, entry string) string {
+	ipNet := parsePrefix(entry)
+	if ipNet == nil {
+		return "not a valid IP or CIDR"
+	}
+
+	if ones, _ := ipNet.Mask.Size(); ones == 0 {
+		if f.exitNode != "" && (f.exitNode == peer.ID || f.exitNode == peer.Hostname || f.exitNode == peer.PublicKey) {
+			return ""
+		}
+		return "default route from an exit node that was not selected"
+	}
+
+	for _, local := range f.local {
+		if prefixesOverlap(ipNet, local) {
+			return "overlaps local subnet " + local.String()
+		}
+	}
+
+	for _, ip := range f.server {
+		if ipNet.Contains(ip) {
+			return "covers the coordination server address " + ip.String()
+		}
+	}
+
+	return ""
+}
+
+// warnAllowedIPsOverlaps logs AllowedIPs claimed by more than one peer;
+// WireGuard resolves these silently with last-write-wins
+func (c *Client) warnAllowedIPsOverlaps(peers []protocol.PeerInfo) {
+	type claim struct {
+		peer   protocol.PeerInfo
+		prefix *net.IPNet
+	}
+
+	var claims []claim
+	for _, peer := range peers {
+		for _, entry := range peer.AllowedIPs {
+			if prefix := parsePrefix(entry); prefix != nil {
+				claims = append(claims, claim{peer: peer, prefix: prefix})
+			}
+		}
+	}
+
+	for i := range claims {
+		for j := i + 1; j < len(claims); j++ {
+			a, b := claims[i], claims[j]
+			if a.peer.PublicKey == b.peer.PublicKey || !prefixesOverlap(a.prefix, b.prefix) {
+				continue
+			}
+			c.logger.Warn("AllowedIPs overlap between peers",
+				"peer_id", a.peer.ID, "allowed_ip", a.prefix.String(),
+				"other_peer_id", b.peer.ID, "other_allowed_ip", b.prefix.String())
+		}
+	}
+}
+
+// applyConflictPreferences withdraws contested prefixes from every peer but
+// the server's preferred owner, so all clients route them the same way.
+// Broader prefixes that merely contain a contested one are kept.
+func (c *Client) applyConflictPreferences(peers []protocol.PeerInfo, conflicts []protocol.AllowedIPsConflict) {
+	for i := range peers {
+		peer := &peers[i]
+		for _, conflict := range conflicts {
+			if conflict.Preferred == peer.ID || !containsString(conflict.PeerIDs, peer.ID) {
+				continue
+			}
+			contested := parsePrefix(conflict.Prefix)
+			if contested == nil {
+				continue
+			}
+
+			kept := peer.AllowedIPs[:0]
+			for _, entry := range peer.AllowedIPs {
+				prefix := parsePrefix(entry)
+				if prefix != nil && prefixWithin(prefix, contested) {
+					c.logger.Info("Deferring contested prefix to preferred peer", "peer_id", peer.ID, "allowed_ip", entry, "preferred", conflict.Preferred)
+					continue
+				}
+				kept = append(kept, entry)
+			}
+			peer.AllowedIPs = kept
+		}
+	}
+}
+
+// prefixWithin reports whether inner lies entirely inside outer
+func prefixWithin(inner, outer *net.IPNet) bool {
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefix parses a CIDR or a bare IP address as a host prefix
+func parsePrefix(entry string) *net.IPNet {
+	if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+		return ipNet
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// prefixesOverlap reports whether two prefixes share any address
+func prefixesOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
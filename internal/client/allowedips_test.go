@@ -0,0 +1,79 @@
+package client
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestFilterAllowedIPsByTopology(t *testing.T) {
+	hub := protocol.PeerInfo{ID: "hub", Hostname: "hub", PublicKey: "hub-key"}
+	spoke := protocol.PeerInfo{ID: "spoke-b", Hostname: "spoke-b", PublicKey: "spoke-key"}
+	_, lan, _ := net.ParseCIDR("192.168.1.0/24")
+
+	tests := []struct {
+		name     string
+		topology string
+		peer     protocol.PeerInfo
+		received []string
+		exitNode string
+		want     []string
+	}{
+		// In a mesh every peer routes only its own prefixes
+		{"mesh peer", "mesh", spoke,
+			[]string{"10.100.0.3/32", "192.168.5.0/24"}, "",
+			[]string{"10.100.0.3/32", "192.168.5.0/24"}},
+		{"mesh peer claiming the LAN", "mesh", spoke,
+			[]string{"10.100.0.3/32", "192.168.1.0/25"}, "",
+			[]string{"10.100.0.3/32"}},
+
+		// In hub-and-spoke the hub carries the whole network
+		{"hub", "hub", hub,
+			[]string{"10.100.0.1/32", "10.100.0.0/16"}, "",
+			[]string{"10.100.0.1/32", "10.100.0.0/16"}},
+		{"hub as unselected exit node", "hub", hub,
+			[]string{"10.100.0.1/32", "0.0.0.0/0", "10.100.0.0/16"}, "",
+			[]string{"10.100.0.1/32", "10.100.0.0/16"}},
+		{"hub as selected exit node", "hub", hub,
+			[]string{"10.100.0.1/32", "0.0.0.0/0", "10.100.0.0/16"}, "hub",
+			[]string{"10.100.0.1/32", "0.0.0.0/0", "10.100.0.0/16"}},
+		{"hub selected by key", "hub", hub,
+			[]string{"::/0"}, "hub-key",
+			[]string{"::/0"}},
+		{"spoke claiming a default route", "hub", spoke,
+			[]string{"10.100.0.3/32", "0.0.0.0/0"}, "hub",
+			[]string{"10.100.0.3/32"}},
+		{"garbage", "mesh", spoke,
+			[]string{"10.100.0.3/32", "not-a-prefix"}, "",
+			[]string{"10.100.0.3/32"}},
+	}
+
+	c, _ := newStaticClient(t)
+	for _, tt := range tests {
+		t.Run(tt.topology+"/"+tt.name, func(t *testing.T) {
+			f := &allowedIPsFilter{strict: true, exitNode: tt.exitNode, local: []*net.IPNet{lan}}
+			peer := tt.peer
+			peer.AllowedIPs = tt.received
+			if got := c.filterAllowedIPs(f, peer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			// Permissive programs everything
+			f.strict = false
+			if got := c.filterAllowedIPs(f, peer); !reflect.DeepEqual(got, tt.received) {
+				t.Errorf("permissive: got %v, want %v", got, tt.received)
+			}
+		})
+	}
+}
+
+func TestFilterAllowedIPsServerAddress(t *testing.T) {
+	c, _ := newStaticClient(t)
+	f := &allowedIPsFilter{strict: true, server: []net.IP{net.ParseIP("203.0.113.10")}}
+	peer := protocol.PeerInfo{ID: "peer", AllowedIPs: []string{"10.100.0.3/32", "203.0.113.0/24", "203.0.113.10"}}
+	if got, want := c.filterAllowedIPs(f, peer), []string{"10.100.0.3/32"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
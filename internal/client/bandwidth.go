@@ -0,0 +1,190 @@
+package client
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// StatsSampleInterval is the time between interface counter samples
+	StatsSampleInterval = 5 * time.Second
+
+	// rateSmoothing is the weight of the newest sample in the moving average
+	rateSmoothing = 0.3
+)
+
+// PeerRate is the smoothed throughput of a peer
+type PeerRate struct {
+	ReceiveBytesPerSec  float64 `json:"receive_bytes_per_sec"`
+	TransmitBytesPerSec float64 `json:"transmit_bytes_per_sec"`
+}
+
+// peerSample holds a peer's cumulative counters and handshake state at one
+// point in time
+type peerSample struct {
+	receive       int64
+	transmit      int64
+	lastHandshake time.Time
+	endpoint      string
+	at            time.Time
+}
+
+// statsRoutine periodically samples the interface counters
+func (c *Client) statsRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(StatsSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.sampleStats(now)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// sampleStats polls the interface and updates the per-peer rates. The device
+// is queried without holding mu, so the sampler never holds up a restart or
+// shutdown; a poll that races with Destroy simply fails.
+func (c *Client) sampleStats(now time.Time) {
+	c.mu.Lock()
+	backend := c.wgInterface
+	c.mu.Unlock()
+
+	if backend == nil {
+		return
+	}
+
+	stats, err := backend.GetStats()
+	if err != nil {
+		c.logger.Debug("Failed to sample interface stats", "error", err)
+		return
+	}
+
+	samples := peerSamples(stats)
+
+	c.mu.Lock()
+	c.updateRates(samples, now)
+	events := c.updateHandshakes(samples, now)
+	var lostDNSPeer bool
+	for _, event := range events {
+		if _, ok := c.dnsEndpoints[event.PublicKey]; ok && event.Type == EventPeerLost {
+			lostDNSPeer = true
+		}
+	}
+	c.mu.Unlock()
+
+	// The address behind a DNS name may have moved
+	if lostDNSPeer {
+		c.requestResolve()
+	}
+	for _, event := range events {
+		c.emit(event)
+	}
+	c.runPeerHooks(events)
+}
+
+// updateRates folds a new set of counters into the smoothed rates and the
+// traffic totals. Caller must hold mu.
+func (c *Client) updateRates(counters map[string]peerSample, now time.Time) {
+	for key, sample := range counters {
+		sample.at = now
+		prev, ok := c.samples[key]
+		c.samples[key] = sample
+		if !ok {
+			// A peer new to the interface has counted from zero
+			c.totalReceive += uint64(sample.receive)
+			c.totalTransmit += uint64(sample.transmit)
+			continue
+		}
+		c.totalReceive += uint64(counterDelta(prev.receive, sample.receive))
+		c.totalTransmit += uint64(counterDelta(prev.transmit, sample.transmit))
+
+		elapsed := now.Sub(prev.at).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		current := PeerRate{
+			ReceiveBytesPerSec:  counterDelta(prev.receive, sample.receive) / elapsed,
+			TransmitBytesPerSec: counterDelta(prev.transmit, sample.transmit) / elapsed,
+		}
+
+		if rate, ok := c.rates[key]; ok {
+			current.ReceiveBytesPerSec = smooth(rate.ReceiveBytesPerSec, current.ReceiveBytesPerSec)
+			current.TransmitBytesPerSec = smooth(rate.TransmitBytesPerSec, current.TransmitBytesPerSec)
+		}
+		c.rates[key] = current
+	}
+
+	// Forget peers that are no longer configured
+	for key := range c.samples {
+		if _, ok := counters[key]; !ok {
+			delete(c.samples, key)
+			delete(c.rates, key)
+		}
+	}
+}
+
+// counterDelta returns how far a cumulative counter advanced. A counter that
+// went backwards was reset by the interface being recreated and has counted
+// up from zero since.
+func counterDelta(prev, current int64) float64 {
+	if current < prev {
+		return float64(current)
+	}
+	return float64(current - prev)
+}
+
+// smooth applies an exponentially weighted moving average
+func smooth(previous, current float64) float64 {
+	return previous + rateSmoothing*(current-previous)
+}
+
+// peerSamples extracts the per-peer counters and handshake state from
+// GetStats output, keyed by public key
+func peerSamples(stats map[string]interface{}) map[string]peerSample {
+	counters := make(map[string]peerSample)
+
+	peers, _ := stats["peers"].([]map[string]interface{})
+	for _, peer := range peers {
+		key, _ := peer["public_key"].(string)
+		if key == "" {
+			continue
+		}
+		sample := peerSample{
+			receive:  toInt64(peer["receive_bytes"]),
+			transmit: toInt64(peer["transmit_bytes"]),
+		}
+		sample.lastHandshake, _ = peer["last_handshake"].(time.Time)
+		switch endpoint := peer["endpoint"].(type) {
+		case *net.UDPAddr:
+			if endpoint != nil {
+				sample.endpoint = endpoint.String()
+			}
+		case string:
+			sample.endpoint = endpoint
+		}
+		counters[key] = sample
+	}
+
+	return counters
+}
+
+// toInt64 converts a numeric stats value; missing values count as zero
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case float64:
+		return int64(n)
+	default:
+		return 0
+	}
+}
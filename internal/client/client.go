@@ -0,0 +1,1444 @@
+package client
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/lockfile"
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+const (
+	HeartbeatInterval = 30 * time.Second
+	PeerSyncInterval  = 60 * time.Second
+	RetryInterval     = 10 * time.Second
+	RequestTimeout    = 10 * time.Second // Per call to the server, unless configured
+
+	// Backoff between registration attempts while the server is unreachable
+	registerInitialBackoff = time.Second
+	registerMaxBackoff     = time.Minute
+
+	// Backoff between registration attempts while the server has no address
+	// for us. The maximum keeps our place on the server's waitlist.
+	poolInitialBackoff = 30 * time.Second
+	poolMaxBackoff     = 5 * time.Minute
+)
+
+// errRegistrationRejected is returned when the server refuses to register us
+var errRegistrationRejected = errors.New("registration rejected")
+
+// errPoolExhausted is returned when the server has no address for us yet
+var errPoolExhausted = errors.New("server address pool exhausted")
+
+// errLimitExceeded is returned when the network or our auth key has as many
+// peers as it may
+var errLimitExceeded = errors.New("peer limit reached")
+
+// ErrAlreadyStarted is returned by Start on a client that was started
+// before. A Client cannot be started again once it has stopped.
+var ErrAlreadyStarted = errors.New("client already started")
+
+// ErrNotRunning is returned by Stop on a client that was never started or
+// has already stopped
+var ErrNotRunning = errors.New("client not running")
+
+// lifecycleState is where a Client is between NewClient and Stop
+type lifecycleState int
+
+const (
+	stateCreated  lifecycleState = iota // Not started, or the last Start failed
+	stateStarting                       // Start is in progress
+	stateRunning                        // Started; routines running
+	stateStopping                       // Stop requested; tearing down
+	stateStopped                        // Torn down; Wait returns
+)
+
+// Client represents the VPN client
+type Client struct {
+	config          *config.ClientConfig
+	wgInterface     wireguard.Backend
+	newBackend      wireguard.BackendFactory
+	customBackend   bool // Skip OS pre-flight checks for injected backends
+	dryRun          bool // Store and save nothing, see DryRun
+	httpClient      *http.Client
+	servers         *serverAddrs // Parsed ServerAddr and ServerAddrs
+	logger          *slog.Logger
+	logs            *LogBuffer // Recent log output for debug bundles, set with WithLogBuffer
+	saveState       func(*config.ClientConfig) error
+	authKey         crypto.Redacted // Pre-auth key presented on registration
+	state           *stateFile
+	lock            *lockfile.Lock // Held on the state directory while running
+	cleaner         cleaner
+	privateKey      crypto.Redacted
+	publicKey       string
+	peerID          string
+	assignedIP      string // Guarded by mu once running; the server may renumber us
+	networkCIDR     string // Guarded by mu once running
+	serverPublicKey string
+	serverKeepalive int
+	serverMTU       int
+	dnsServers      []string // Resolvers the server hands out for the mesh
+	stunPorts       []int    // UDP ports the server answers STUN on
+
+	heartbeatInterval time.Duration            // HeartbeatInterval unless set with WithIntervals
+	peerSyncInterval  time.Duration            // PeerSyncInterval unless set with WithIntervals
+	detectEndpointsFn func() ([]string, error) // Set with WithEndpointDetector
+
+	// Lifecycle
+	lifecycleMu sync.Mutex     // Guards lifecycle, starting, ctx and cancel
+	lifecycle   lifecycleState // Created → Starting → Running → Stopping → Stopped
+	starting    chan struct{}  // Closed when the current Start returns
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	stopOnce    sync.Once
+	done        chan struct{}
+	rebuild     sync.Mutex // Serializes interface rebuilds
+	events      chan Event
+	history     eventRing   // Recent events for the control socket
+	meshProxies []meshProxy // SOCKS5 and HTTP proxies into a netstack mesh, bound by Start
+	hooks       hookRunner  // Serializes runs of each configured hook
+
+	// mu guards the peer and endpoint state below
+	mu            sync.Mutex
+	activePeers   map[string]protocol.PeerInfo // keyed by public key
+	localPeers    map[string]bool              // Active peers from the local config, keyed by public key
+	extraPeers    []config.StaticPeer          // Locally pinned peers, replaced on reload
+	peers         []protocol.PeerInfo          // Last synced peer list, offline peers included
+	peerList      *protocol.PeerListResponse   // Last verified peer list, reapplied by on-demand activation
+	endpoints     []string                     // Detected local endpoints, IPv4 first
+	dnsEndpoints  map[string]*dnsEndpoint      // Peer endpoints given as DNS names, keyed by public key
+	resolveNow    chan struct{}                // Asks resolveRoutine for an immediate lookup
+	hasIPv6       bool                         // A global IPv6 address was detected
+	behindNAT     bool
+	watchdog      WatchdogStatus
+	udp           UDPStatus               // Outcome of the last UDP check, see udpCheckRoutine
+	udpTried      []int                   // Listen ports found blocked since one last worked
+	interfaceName string                  // Actual device name, e.g. the utun picked on macOS
+	activePort    int                     // Listen port in use, a fallback one while UDP is blocked; see udpcheck.go
+	backendKind   string                  // WireGuard implementation in use, e.g. wireguard.KindKernel
+	firewallRule  *wireguard.FirewallRule // Inbound rule opened for the listen port, with manage_firewall
+	aclRules      *wireguard.ACLRules     // Source filter on the interface, with enforce_acls
+	aclSources    []string                // Sources aclRules lets in, sorted
+	samples       map[string]peerSample   // Last counters, keyed by public key
+	rates         map[string]PeerRate     // Smoothed throughput, keyed by public key
+	connected     map[string]bool         // Recent handshake seen, keyed by public key
+	version       uint64                  // Peer list version of the last sync
+	peerListETag  string                  // Content hash of peerList, sent back to skip unchanged lists
+
+	// peerListVouchedAt is when a signed 304 last said peerList is still
+	// current, in Unix seconds; see cachedPeerListStaleLocked
+	peerListVouchedAt int64
+
+	// Coalescing of reconfigurations, see reconfigureLocked
+	reconfigureInterval time.Duration // Set with reconfigure_interval or WithReconfigureInterval
+	reconfigureTimer    *time.Timer   // Programs the last list once the interval ends
+	lastReconfigure     time.Time
+	reconfigurations    uint64 // Peer lists programmed
+	coalescedReconfigs  uint64 // Peer lists left for a later reconfiguration
+
+	// Maintenance window, with maintenance_window; see reconfigureLocked
+	maintenance   *config.MaintenanceSchedule
+	deferredSince time.Time // First peer list held for the window, zero when none is
+	deferredLists uint64    // Peer lists held since deferredSince
+
+	setupSteps []SetupStep // Outcome of each step of the last interface setup
+
+	// On-demand activation, with activation_mode "on_demand"
+	onDemandState  string    // OnDemandArmed, OnDemandActive or OnDemandIdle
+	onDemandForced string    // Why the mesh is kept active regardless of traffic
+	lastActivity   time.Time // Last time the interface counters moved
+
+	// Traffic totals since the client started, reported with heartbeats
+	// under counterSession so the server can tell a restart from a reset
+	counterSession string
+	totalReceive   uint64
+	totalTransmit  uint64
+
+	addressConflicts []protocol.AddressConflict // Reported with the next heartbeat
+
+	// Outage of the coordination server, see heartbeatFailed
+	outage       outage
+	heartbeatNow chan struct{} // Asks heartbeatRoutine for an immediate heartbeat
+
+	// Server key pinning: a changed key waits for the operator's approval
+	acceptKeyChange  bool           // Accept the next changed key (-accept-server-key-change)
+	pendingIdentity  serverIdentity // Changed keys awaiting approval
+	approvedIdentity serverIdentity // Changed keys the operator approved
+	keyApproved      chan struct{}  // Signalled by AcceptServerKey
+}
+
+// NewClient creates a new VPN client
+func NewClient(cfg *config.ClientConfig, opts ...Option) (*Client, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		config:     cfg,
+		newBackend: wireguard.NewBackend,
+
+		heartbeatInterval:   HeartbeatInterval,
+		peerSyncInterval:    PeerSyncInterval,
+		reconfigureInterval: reconfigureInterval(cfg),
+
+		logger:      slog.Default(),
+		state:       &stateFile{path: StatePath(cfg)},
+		cleaner:     systemCleaner{},
+		done:        make(chan struct{}),
+		events:      make(chan Event, eventBufferSize),
+		activePeers: make(map[string]protocol.PeerInfo),
+		localPeers:  make(map[string]bool),
+		extraPeers:  cfg.ExtraPeers,
+		samples:     make(map[string]peerSample),
+		rates:       make(map[string]PeerRate),
+		connected:   make(map[string]bool),
+		activePort:  listenPortOf(cfg),
+
+		onDemandState:  OnDemandArmed,
+		dnsEndpoints:   make(map[string]*dnsEndpoint),
+		resolveNow:     make(chan struct{}, 1),
+		heartbeatNow:   make(chan struct{}, 1),
+		counterSession: newIdempotencyKey(),
+		keyApproved:    make(chan struct{}, 1),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	if cfg.Netstack() && !c.customBackend {
+		if !wireguard.NetstackSupported {
+			return nil, fmt.Errorf("backend %q is not built in; rebuild the client with -tags netstack", config.BackendNetstack)
+		}
+		c.newBackend = wireguard.NewNetstackBackend
+		c.customBackend = true
+	}
+	if c.authKey == "" {
+		c.authKey = crypto.Redacted(cfg.AuthKey)
+	}
+
+	if cfg.MaintenanceWindow != nil {
+		schedule, err := cfg.MaintenanceWindow.Schedule()
+		if err != nil {
+			return nil, err
+		}
+		c.maintenance = schedule
+	}
+
+	if !cfg.Static() {
+		servers, err := parseServerAddrs(cfg.ServerAddr, cfg.ServerAddrs)
+		if err != nil {
+			return nil, err
+		}
+		c.servers = servers
+	}
+
+	if c.httpClient == nil {
+		httpClient, err := newHTTPClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.httpClient = httpClient
+	}
+
+	if err := c.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// loadKeys loads the private key from the configured key storage,
+// generating and storing one on first run. A store that cannot be reached is
+// an error: regenerating the key would give the client a new identity.
+func (c *Client) loadKeys() error {
+	store, err := config.NewSecretStore(c.config)
+	if err != nil {
+		return err
+	}
+	privateKey, err := store.Get(config.SecretPrivateKey)
+	switch {
+	case err == nil:
+		c.privateKey = crypto.Redacted(privateKey)
+		c.publicKey = c.config.PublicKey
+		if c.publicKey == "" {
+			public, err := crypto.DerivePublicKeyString(privateKey)
+			if err != nil {
+				return fmt.Errorf("stored private key is invalid: %w", err)
+			}
+			c.publicKey = public
+			c.config.PublicKey = c.publicKey
+			c.saveConfig()
+		} else if err := crypto.ValidateKeyPair(privateKey, c.publicKey); err != nil {
+			return fmt.Errorf("stored private key does not match public_key: %w", err)
+		}
+		return nil
+	case !errors.Is(err, config.ErrSecretNotFound):
+		return fmt.Errorf("failed to load private key: %w", err)
+	}
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate client keys: %w", err)
+	}
+	c.privateKey = crypto.Redacted(keyPair.PrivateKeyToString())
+	c.publicKey = keyPair.PublicKeyToString()
+	if c.dryRun {
+		// A throwaway key; the real one is generated on the first start
+		return nil
+	}
+
+	if err := store.Set(config.SecretPrivateKey, c.privateKey.Reveal()); err != nil {
+		return fmt.Errorf("failed to store private key: %w", err)
+	}
+	c.config.PublicKey = c.publicKey
+	c.saveConfig()
+
+	return nil
+}
+
+// saveConfig hands the current configuration to the embedder's save hook
+func (c *Client) saveConfig() {
+	if c.saveState == nil || c.dryRun {
+		return
+	}
+	if err := c.saveState(c.config); err != nil {
+		c.logger.Warn("Failed to save client config", "error", err)
+	}
+}
+
+// Start registers with the server, brings up the WireGuard interface and
+// starts the background routines, then returns. The routines run until ctx
+// is cancelled or Stop is called; use Wait to block until the client stops.
+// A failed Start may be retried; Start on a client that started before
+// returns ErrAlreadyStarted.
+func (c *Client) Start(ctx context.Context) (err error) {
+	c.lifecycleMu.Lock()
+	if c.lifecycle != stateCreated {
+		c.lifecycleMu.Unlock()
+		return ErrAlreadyStarted
+	}
+	c.lifecycle = stateStarting
+	c.starting = make(chan struct{})
+	// Created up front so that a Stop during Start can cancel it
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.lifecycleMu.Unlock()
+
+	defer func() {
+		c.lifecycleMu.Lock()
+		defer c.lifecycleMu.Unlock()
+		// A Stop that arrived meanwhile has set stateStopping and tears
+		// down whatever came up once starting is closed
+		if c.lifecycle == stateStarting {
+			if err != nil {
+				c.lifecycle = stateCreated
+			} else {
+				c.lifecycle = stateRunning
+			}
+		}
+		close(c.starting)
+	}()
+
+	c.logger.Info("Starting VPN client", "public_key", c.publicKey, "fingerprint", crypto.Fingerprint(c.publicKey))
+	if c.config.ProxyURL != "" {
+		c.logger.Info("Using proxy for the coordination server only; WireGuard traffic is UDP and is always sent directly, not through the proxy")
+	}
+
+	// Keep a second client with this profile from fighting over the
+	// interface and state file
+	if err := c.acquireLock(); err != nil {
+		c.cancel()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.releaseLock()
+		}
+	}()
+
+	// Clean up after a previous run that did not shut down cleanly
+	if err := c.recoverState(); err != nil {
+		c.cancel()
+		return err
+	}
+
+	// Fail with an actionable report rather than on the first exec
+	if !c.customBackend {
+		if err := Preflight(c.config, "").Err(); err != nil {
+			c.cancel()
+			return err
+		}
+	}
+
+	if err := c.startControl(); err != nil {
+		c.cancel()
+		return err
+	}
+
+	// A netstack mesh is reached only through the client's own proxies.
+	// Their ports are taken before registering so that a clash fails fast.
+	if err := c.listenMeshProxies(); err != nil {
+		c.cancel()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.closeMeshProxies()
+		}
+	}()
+
+	if c.config.Static() {
+		// No server: the address and peers come from the config
+		if err := c.useStaticAddress(); err != nil {
+			c.cancel()
+			return err
+		}
+	} else if err := c.registerWithRetry(); err != nil {
+		// Register with server, waiting for the network if needed
+		c.cancel()
+		return fmt.Errorf("failed to register with server: %w", err)
+	}
+
+	// Create and configure WireGuard interface
+	if err := c.setupInterface(); err != nil {
+		c.cancel()
+		return fmt.Errorf("failed to setup interface: %w", err)
+	}
+	if err := c.openFirewall(); c.config.ManageFirewall && c.setupStep("open_firewall", err, false) {
+		c.mu.Lock()
+		wgInterface := c.wgInterface
+		c.mu.Unlock()
+		c.abandonInterface(wgInterface)
+		c.cancel()
+		return fmt.Errorf("failed to open the listen port in the firewall: %w", err)
+	}
+
+	if err := c.runPostUpHook(); err != nil {
+		c.mu.Lock()
+		wgInterface := c.wgInterface
+		c.mu.Unlock()
+		c.closeFirewall()
+		c.abandonInterface(wgInterface)
+		c.cancel()
+		return err
+	}
+
+	// Start background routines; without a server there is nothing to
+	// report to or sync from
+	c.wg.Add(3)
+	go c.watchdogRoutine()
+	go c.statsRoutine()
+	go c.resolveRoutine()
+	c.serveMeshProxies()
+	if !c.config.Static() {
+		c.wg.Add(2)
+		go c.heartbeatRoutine()
+		go c.peerSyncRoutine()
+		if c.onDemand() {
+			c.wg.Add(1)
+			go c.onDemandRoutine()
+		}
+		if len(c.servers.urls) > 1 {
+			c.wg.Add(1)
+			go c.serverProbeRoutine()
+		}
+		if c.udpCheckEnabled() {
+			c.wg.Add(1)
+			go c.udpCheckRoutine()
+		}
+	}
+
+	// Tear down once the caller's context is cancelled
+	go func() {
+		<-c.ctx.Done()
+		c.shutdown()
+	}()
+
+	c.logger.Info("VPN client started", "virtual_ip", c.assignedIP, "network", c.networkCIDR)
+
+	return nil
+}
+
+// Wait blocks until the client has fully stopped
+func (c *Client) Wait() {
+	<-c.done
+}
+
+// Stop stops the VPN client and returns once the routines have exited and
+// the interface is gone. A Start in progress is cancelled and waited for
+// first. Stop returns ErrNotRunning if the client was never started or has
+// already stopped, and is safe to call more than once and concurrently.
+func (c *Client) Stop() error {
+	c.lifecycleMu.Lock()
+	switch c.lifecycle {
+	case stateCreated:
+		// Nothing to tear down, but Wait returns and Start is refused
+		c.lifecycle = stateStopping
+		c.lifecycleMu.Unlock()
+		c.shutdown()
+		return ErrNotRunning
+	case stateStarting:
+		c.lifecycle = stateStopping
+		c.cancel()
+		starting := c.starting
+		c.lifecycleMu.Unlock()
+		<-starting
+	case stateRunning:
+		c.lifecycle = stateStopping
+		c.lifecycleMu.Unlock()
+	default:
+		// Stopping or stopped: wait for the other Stop to finish
+		c.lifecycleMu.Unlock()
+		c.Wait()
+		return ErrNotRunning
+	}
+
+	c.shutdown()
+	return nil
+}
+
+// shutdown cancels the background routines, waits for them to exit and
+// destroys the interface, exactly once
+func (c *Client) shutdown() {
+	c.stopOnce.Do(func() {
+		c.lifecycleMu.Lock()
+		c.lifecycle = stateStopping
+		cancel := c.cancel
+		c.lifecycleMu.Unlock()
+
+		c.logger.Info("Stopping VPN client")
+
+		if cancel != nil {
+			cancel()
+		}
+		c.wg.Wait()
+
+		c.mu.Lock()
+		up := c.wgInterface != nil
+		c.mu.Unlock()
+		if up {
+			c.runHook(hookPreDown, nil)
+		}
+
+		// An ephemeral peer leaves at once rather than waiting out its
+		// grace period on the server. The root context is gone by now.
+		if c.config.Ephemeral && c.peerID != "" {
+			if err := c.unregister(context.Background()); err != nil {
+				c.logger.Warn("Failed to unregister", "error", err)
+			}
+		}
+
+		firewallClosed := c.closeFirewall()
+		aclRemoved := c.removeACL()
+
+		c.mu.Lock()
+		wgInterface := c.wgInterface
+		c.mu.Unlock()
+
+		if wgInterface != nil {
+			if err := wgInterface.Destroy(); err != nil {
+				// Leave the state file behind so the next start cleans up
+				c.logger.Warn("Failed to destroy interface", "error", err)
+			} else if !firewallClosed || !aclRemoved {
+				c.logger.Warn("Keeping state file so the next start removes the firewall rules")
+			} else if err := c.state.Remove(); err != nil {
+				c.logger.Warn("Failed to remove state file", "error", err)
+			}
+			wgInterface.Close()
+		}
+
+		c.releaseLock()
+
+		// Kept-alive connections each hold reader and writer goroutines
+		// until the idle timeout, long after the client is gone
+		c.httpClient.CloseIdleConnections()
+
+		c.lifecycleMu.Lock()
+		c.lifecycle = stateStopped
+		c.lifecycleMu.Unlock()
+
+		close(c.events)
+		close(c.done)
+
+		c.logger.Info("VPN client stopped")
+	})
+}
+
+// register registers the client with the server. Retries of one attempt
+// share its idempotency key, so a retry after a lost response is answered
+// with the original registration.
+func (c *Client) register(ctx context.Context, idempotencyKey string) error {
+	hostname, _ := os.Hostname()
+	if err := protocol.ValidateName(hostname); hostname != "" && err != nil {
+		// The server would refuse the registration outright
+		c.logger.Warn("Registering without a hostname", "hostname", hostname, "reason", err)
+		hostname = ""
+	}
+
+	req := protocol.RegisterRequest{
+		PublicKey: c.publicKey,
+		Hostname:  hostname,
+		OS:        runtime.GOOS,
+		RequestIP: true,
+		ExitNode:  c.config.ExitNode,
+		AuthKey:   c.authKey.Reveal(),
+		Ephemeral: c.config.Ephemeral,
+		Hidden:    c.config.Hidden,
+
+		ClientVersion:  version.Get().Version,
+		IdempotencyKey: idempotencyKey,
+	}
+	// Known once the interface exists, i.e. when registering again
+	c.mu.Lock()
+	req.Backend = c.backendKind
+	c.mu.Unlock()
+
+	// Try to detect our external endpoints
+	endpoints, err := c.detectEndpoints()
+	if err == nil {
+		req.Endpoint = endpoints[0]
+		req.Endpoints = endpoints
+		c.mu.Lock()
+		c.endpoints = endpoints
+		c.mu.Unlock()
+	}
+
+	var resp protocol.RegisterResponse
+	if err := c.sendRequest(ctx, "/register", req, &resp); err != nil {
+		return err
+	}
+
+	if resp.Code == protocol.ErrorCodePoolExhausted {
+		return fmt.Errorf("%w: %s", errPoolExhausted, resp.Error)
+	}
+	if resp.Code == protocol.ErrorCodeLimitExceeded {
+		return fmt.Errorf("%w: %s", errLimitExceeded, resp.Error)
+	}
+	if !resp.Success {
+		return fmt.Errorf("%w: %s", errRegistrationRejected, resp.Error)
+	}
+	if err := c.checkServerIdentity(&resp); err != nil {
+		return err
+	}
+
+	c.peerID = resp.PeerID
+	c.serverPublicKey = resp.ServerPublicKey
+	c.serverKeepalive = resp.Keepalive
+	c.serverMTU = resp.MTU
+	c.dnsServers = resp.DNSServers
+	c.stunPorts = resp.STUNPorts
+
+	c.mu.Lock()
+	c.behindNAT = detectNAT(req.Endpoints, resp.ObservedIP)
+	c.mu.Unlock()
+
+	// Update config
+	c.config.PeerID = c.peerID
+	if err := c.setAssignedIP(resp.AssignedIP, resp.NetworkCIDR); err != nil {
+		return err
+	}
+	c.saveConfig()
+
+	c.logger.Info("Registered with server", "peer_id", c.peerID, "ip", resp.AssignedIP, "topology", resp.Topology)
+	c.emit(Event{Type: EventRegistered, PeerID: c.peerID, VirtualIP: resp.AssignedIP, Endpoint: req.Endpoint})
+
+	return nil
+}
+
+// unregister tells the server the client is leaving the mesh
+func (c *Client) unregister(ctx context.Context) error {
+	req := protocol.UnregisterRequest{
+		PeerID:    c.peerID,
+		PublicKey: c.publicKey,
+	}
+
+	var resp protocol.UnregisterResponse
+	if err := c.sendRequest(ctx, "/unregister", req, &resp); err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("unregister failed: %s", resp.Error)
+	}
+
+	c.logger.Info("Unregistered from server", "peer_id", c.peerID)
+	return nil
+}
+
+// registerWithRetry registers with the server, retrying with backoff while
+// the server is unreachable. Services are often started before the network
+// is up. An explicit rejection from the server is returned immediately.
+func (c *Client) registerWithRetry() error {
+	idempotencyKey := newIdempotencyKey()
+	delay := registerInitialBackoff
+	poolDelay := poolInitialBackoff
+	for {
+		err := c.register(c.ctx, idempotencyKey)
+		if err == nil || errors.Is(err, errRegistrationRejected) {
+			return err
+		}
+
+		// Only the operator can vouch for a changed server key
+		if errors.Is(err, errServerKeyChanged) {
+			c.logger.Error("Refusing to use the coordination server", "error", err)
+			select {
+			case <-c.ctx.Done():
+				return err
+			case <-c.keyApproved:
+				continue
+			}
+		}
+
+		// A draining server says how long to wait; that is expected
+		// during maintenance and not worth a warning on every attempt
+		var retryLater *retryLaterError
+		if errors.As(err, &retryLater) {
+			c.logger.Info("Coordination server is draining, waiting to register", "retry_in", retryLater.after)
+			select {
+			case <-c.ctx.Done():
+				return err
+			case <-time.After(retryLater.after):
+			}
+			continue
+		}
+
+		// Addresses and places under a peer limit free up only as peers
+		// leave, so wait patiently
+		wait := delay
+		if errors.Is(err, errPoolExhausted) || errors.Is(err, errLimitExceeded) {
+			wait = poolDelay
+			poolDelay = min(poolDelay*2, poolMaxBackoff)
+		} else {
+			delay = min(delay*2, registerMaxBackoff)
+		}
+
+		c.logger.Warn("Registration failed, retrying", "error", err, "retry_in", wait)
+
+		select {
+		case <-c.ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// newIdempotencyKey returns a random (version 4) UUID identifying one
+// registration attempt. Without one the server handles each retry afresh.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := crand.Read(b); err != nil {
+		return ""
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// setupInterface sets up the WireGuard interface
+func (c *Client) setupInterface() error {
+	c.mu.Lock()
+	c.setupSteps = nil
+	address := interfaceAddress(c.assignedIP, c.networkCIDR, c.config.AddressMode)
+	if c.config.Static() {
+		address = c.config.Address
+	}
+	wgConfig := c.interfaceConfig(address)
+	c.mu.Unlock()
+
+	name, err := c.chooseInterfaceName()
+	if c.setupStep("interface_name", err, true) {
+		return err
+	}
+	wgConfig.InterfaceName = name
+	wgInterface, err := c.newBackend(wgConfig)
+	if c.setupStep("backend", err, true) {
+		return err
+	}
+
+	// Record the interface before creating it so a partial setup is
+	// recoverable. A netstack device leaves nothing on the host, and an
+	// interface of the same name may belong to another client.
+	err = c.state.Update(func(s *State) {
+		s.PID = os.Getpid()
+		if !c.config.Netstack() {
+			s.InterfaceName = wgConfig.InterfaceName
+			s.Addresses = []string{wgConfig.Address}
+		}
+	})
+	if c.setupStep("record_state", err, false) {
+		wgInterface.Close()
+		return fmt.Errorf("failed to record client state: %w", err)
+	}
+	if err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+
+	createErr := wgInterface.Create()
+
+	if p, ok := wgInterface.(interface{ Processes() []int }); ok {
+		if pids := p.Processes(); len(pids) > 0 {
+			if err := c.state.Update(func(s *State) { s.ProcessPIDs = pids }); err != nil {
+				c.logger.Warn("Failed to record client state", "error", err)
+			}
+		}
+	}
+
+	if c.setupStep("create_interface", createErr, true) {
+		c.abandonInterface(wgInterface)
+		return fmt.Errorf("failed to create interface: %w", createErr)
+	}
+	if err := c.backendSetupSteps(wgInterface); err != nil {
+		c.abandonInterface(wgInterface)
+		return err
+	}
+
+	// The device may have been given a different name than configured
+	interfaceName := wgConfig.InterfaceName
+	if n, ok := wgInterface.(interface{ DeviceName() string }); ok && n.DeviceName() != interfaceName {
+		interfaceName = n.DeviceName()
+		if err := c.state.Update(func(s *State) { s.InterfaceName = interfaceName }); err != nil {
+			c.logger.Warn("Failed to record client state", "error", err)
+		}
+	}
+
+	// Which implementation runs it matters when chasing performance
+	var backendKind string
+	if k, ok := wgInterface.(interface{ Kind() string }); ok {
+		backendKind = k.Kind()
+		if err := c.state.Update(func(s *State) { s.Backend = backendKind }); err != nil {
+			c.logger.Warn("Failed to record client state", "error", err)
+		}
+		c.logger.Info("WireGuard interface created", "interface", interfaceName, "backend", backendKind)
+	}
+
+	err = wgInterface.Configure()
+	if c.setupStep("configure_interface", err, true) {
+		c.abandonInterface(wgInterface)
+		return fmt.Errorf("failed to configure interface: %w", err)
+	}
+
+	c.mu.Lock()
+	c.wgInterface = wgInterface
+	c.interfaceName = interfaceName
+	c.backendKind = backendKind
+	// Closed until the first peer list says otherwise
+	err = c.enforceACL(c.aclSources)
+	c.mu.Unlock()
+	if c.config.EnforceACLs && c.setupStep("enforce_acl", err, false) {
+		c.abandonInterface(wgInterface)
+		return fmt.Errorf("failed to enforce ACLs: %w", err)
+	}
+
+	if c.config.Static() {
+		err := c.applyStaticPeers()
+		if c.setupStep("apply_static_peers", err, false) {
+			c.abandonInterface(wgInterface)
+			return fmt.Errorf("failed to apply static peers: %w", err)
+		}
+		if err != nil {
+			c.logger.Warn("Failed to apply static peers", "error", err)
+		}
+		return nil
+	}
+
+	// Initial peer sync
+	err = c.syncPeers(c.ctx)
+	if c.setupStep("sync_peers", err, false) {
+		c.abandonInterface(wgInterface)
+		return fmt.Errorf("initial peer sync failed: %w", err)
+	}
+	if err != nil {
+		c.logger.Warn("Initial peer sync failed", "error", err)
+	}
+
+	return nil
+}
+
+// interfaceConfig returns the configuration of the WireGuard interface
+// with the given address. Callers must hold c.mu.
+func (c *Client) interfaceConfig(address string) wireguard.Config {
+	return wireguard.Config{
+		InterfaceName: c.requestedInterfaceName(),
+		PrivateKey:    c.privateKey.Reveal(),
+		ListenPort:    c.activePort,
+		Address:       address,
+
+		UseSystemWireGuardGo: c.config.UseSystemWireGuardGo,
+		WireGuardGoPath:      c.config.WireGuardGoPath,
+		WindowsDriver:        c.config.WindowsDriver,
+		MTU:                  c.mtu(),
+		DNSServers:           c.dnsServers,
+		PeerBatchSize:        c.config.PeerBatchSize,
+		FirewallMark:         c.config.FirewallMark,
+		BindInterface:        c.config.BindInterface,
+	}
+}
+
+// mtu returns the configured tunnel MTU, or else the server's; zero leaves
+// the backend's default
+func (c *Client) mtu() int {
+	if c.config.MTU != 0 {
+		return c.config.MTU
+	}
+	return c.serverMTU
+}
+
+// heartbeatRoutine sends periodic heartbeats to the server, backing off
+// while it is unreachable
+func (c *Client) heartbeatRoutine() {
+	defer c.wg.Done()
+
+	timer := time.NewTimer(c.heartbeatInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-c.heartbeatNow:
+			timer.Stop()
+		case <-c.ctx.Done():
+			return
+		}
+
+		delay := c.heartbeatInterval
+		err := c.sendHeartbeat(c.ctx)
+		switch {
+		case c.ctx.Err() != nil:
+			return
+		case errors.Is(err, errServerReadOnly):
+			// Peer lists still come from the replica
+			c.logger.Info("Heartbeat deferred until the primary server is back", "error", err)
+		case err != nil:
+			c.emit(Event{Type: EventHeartbeatFailed, PeerID: c.peerID, Error: err.Error()})
+			delay = c.heartbeatFailed(err)
+		default:
+			c.heartbeatDelivered()
+		}
+		timer.Reset(delay)
+	}
+}
+
+// sendHeartbeat sends a heartbeat to the server
+func (c *Client) sendHeartbeat(ctx context.Context) error {
+	endpoints, _ := c.detectEndpoints()
+	var endpoint string
+	if len(endpoints) > 0 {
+		endpoint = endpoints[0]
+	}
+
+	c.mu.Lock()
+	previous := c.endpoints
+	c.endpoints = endpoints
+	c.mu.Unlock()
+	if len(previous) > 0 && !sameStrings(endpoints, previous) {
+		c.logger.Info("Local endpoint changed", "old", previous, "new", endpoints)
+		c.emit(Event{Type: EventEndpointChanged, PeerID: c.peerID, Endpoint: endpoint})
+	}
+
+	c.mu.Lock()
+	conflicts := c.addressConflicts
+	receive, transmit := c.totalReceive, c.totalTransmit
+	backendKind := c.backendKind
+	missed := c.outage.missed
+	c.mu.Unlock()
+
+	req := protocol.HeartbeatRequest{
+		PeerID:           c.peerID,
+		Endpoint:         endpoint,
+		Endpoints:        endpoints,
+		AddressConflicts: conflicts,
+		ClientVersion:    version.Get().Version,
+		CounterSession:   c.counterSession,
+		ReceiveBytes:     receive,
+		TransmitBytes:    transmit,
+		Backend:          backendKind,
+		MissedHeartbeats: missed,
+		Handshakes:       c.handshakeReports(time.Now()),
+	}
+
+	var resp protocol.HeartbeatResponse
+	if err := c.sendRequest(ctx, "/heartbeat", req, &resp); err != nil {
+		return err
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("heartbeat failed: %s", resp.Error)
+	}
+
+	c.mu.Lock()
+	assignedIP := c.assignedIP
+	version := c.version
+	c.mu.Unlock()
+
+	if resp.AssignedIP != "" && resp.AssignedIP != assignedIP {
+		if err := c.setAssignedIP(resp.AssignedIP, resp.NetworkCIDR); err != nil {
+			return err
+		}
+		c.saveConfig()
+	}
+
+	// Pick up peer changes now rather than at the next scheduled sync
+	if resp.Version != 0 && resp.Version != version {
+		if err := c.syncPeers(ctx); err != nil {
+			c.logger.Warn("Peer sync failed", "error", err)
+		}
+	}
+
+	return nil
+}
+
+// setAssignedIP records the address the server assigns us. When it differs
+// from the address of the running interface, because the network was
+// renumbered, the server lost its store or an administrator moved us, the
+// address is swapped in place so that peer sessions survive; backends that
+// cannot do that are rebuilt. Before the interface exists, as on first boot,
+// the new address is simply used when it is created. The caller saves the
+// config.
+func (c *Client) setAssignedIP(ip, networkCIDR string) error {
+	// The assigned address ends up in ip/ifconfig/netsh arguments
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("server assigned an invalid IP %q", ip)
+	}
+
+	c.mu.Lock()
+	previous := c.assignedIP
+	c.assignedIP = ip
+	if networkCIDR != "" {
+		c.networkCIDR = networkCIDR
+	}
+	c.mu.Unlock()
+
+	if cached := c.config.AssignedIP; previous == "" && cached != "" && cached != ip {
+		c.logger.Info("Server assigned a different address than last run", "old", cached, "new", ip)
+	}
+	c.config.AssignedIP = ip
+
+	if previous == "" || previous == ip {
+		return nil
+	}
+
+	c.logger.Info("Server assigned a new address", "old", previous, "new", ip, "network", networkCIDR)
+	c.emit(Event{Type: EventAddressChanged, PeerID: c.peerID, VirtualIP: ip})
+
+	c.mu.Lock()
+	address := interfaceAddress(ip, c.networkCIDR, c.config.AddressMode)
+	c.mu.Unlock()
+	done, err := c.setInterfaceAddress(address)
+	if done {
+		return nil
+	}
+	if err != nil {
+		c.logger.Warn("Failed to change interface address in place, recreating interface", "error", err)
+	}
+
+	if err := c.restartInterface(); err != nil {
+		return fmt.Errorf("failed to move interface to %s: %w", ip, err)
+	}
+	return nil
+}
+
+// interfaceAddress returns the interface address for an assigned IP: a /32
+// in host mode, or with the prefix length of the mesh network in network
+// mode. The kernel then adds the connected route for the mesh itself, so no
+// route is installed for it separately. An IP outside networkCIDR keeps /32.
+func interfaceAddress(ip, networkCIDR, mode string) string {
+	if mode == config.AddressModeNetwork {
+		if _, network, err := net.ParseCIDR(networkCIDR); err == nil && network.Contains(net.ParseIP(ip)) {
+			ones, _ := network.Mask.Size()
+			return fmt.Sprintf("%s/%d", ip, ones)
+		}
+	}
+	return ip + "/32"
+}
+
+// setInterfaceAddress changes the address of the running interface in place.
+// It reports whether the interface is taken care of: either the address was
+// changed or there is no interface yet, and it picks up the new address when
+// it is created.
+func (c *Client) setInterfaceAddress(address string) (bool, error) {
+	c.rebuild.Lock()
+	defer c.rebuild.Unlock()
+
+	c.mu.Lock()
+	wgInterface := c.wgInterface
+	c.mu.Unlock()
+
+	if wgInterface == nil {
+		return true, nil
+	}
+	r, ok := wgInterface.(interface{ SetAddress(string) error })
+	if !ok {
+		return false, nil
+	}
+	if err := r.SetAddress(address); err != nil {
+		return false, err
+	}
+
+	if err := c.state.Update(func(s *State) { s.Addresses = []string{address} }); err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+	return true, nil
+}
+
+// peerSyncRoutine periodically syncs peers from the server
+func (c *Client) peerSyncRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.peerSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.syncPeers(c.ctx)
+			switch {
+			case err == nil:
+				c.serverAnswered()
+			case c.disconnected():
+				// The outage is already logged by the heartbeats
+				c.logger.Debug("Peer sync failed", "error", err)
+			default:
+				c.logger.Warn("Peer sync failed", "error", err)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// syncPeers synchronizes peer list from the server
+func (c *Client) syncPeers(ctx context.Context) error {
+	return c.fetchPeers(ctx, false)
+}
+
+// errRefetchPeerList is returned by requestPeerList when the 304 answering a
+// conditional request cannot be trusted, and the whole list is needed
+var errRefetchPeerList = errors.New("whole peer list needed")
+
+// fetchPeers fetches and verifies the peer list and programs it, at once
+// if force or else as reconfigureLocked allows. The server answers 304 when
+// the list we hold is still current; a 304 that cannot be trusted is
+// followed by one request for the whole list, never more.
+func (c *Client) fetchPeers(ctx context.Context, force bool) error {
+	c.mu.Lock()
+	etag := c.peerListETag
+	c.mu.Unlock()
+
+	err := c.requestPeerList(ctx, force, etag)
+	if errors.Is(err, errRefetchPeerList) {
+		c.mu.Lock()
+		c.peerListETag = ""
+		c.mu.Unlock()
+		return c.requestPeerList(ctx, force, "")
+	}
+	return err
+}
+
+// requestPeerList makes one request for the peer list, conditional on etag
+// when set. It returns errRefetchPeerList for a 304 that is not signed
+// properly or leaves the list we hold too old.
+func (c *Client) requestPeerList(ctx context.Context, force bool, etag string) error {
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-None-Match": {etag}}
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/peers", url.Values{"peer_id": {c.peerID}}, header, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch peers: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if resp.StatusCode == http.StatusNotModified {
+		if etag == "" {
+			// Nothing was cached to be current, so this is no answer
+			return errors.New("server answered 304 to a request without If-None-Match")
+		}
+		vouchedAt, err := c.verifyNotModified(resp.Header, etag, time.Now())
+		if err != nil {
+			// Ask for the list itself, which is verified on its own
+			c.logger.Warn("Rejected peer list answer from server, fetching the whole list", "error", err)
+			return errRefetchPeerList
+		}
+
+		c.mu.Lock()
+		c.peerListVouchedAt = max(c.peerListVouchedAt, vouchedAt)
+		if err := c.cachedPeerListStaleLocked(time.Now()); err != nil {
+			// Have the server sign the list afresh instead
+			c.logger.Debug("Fetching the whole peer list again", "reason", err)
+			c.mu.Unlock()
+			return errRefetchPeerList
+		}
+		defer c.mu.Unlock()
+		if version, err := strconv.ParseUint(resp.Header.Get(protocol.PeerListVersionHeader), 10, 64); err == nil {
+			c.version = version
+		}
+		if force && c.peerList != nil {
+			return c.reconfigureNowLocked()
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	var peerList protocol.PeerListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peerList); err != nil {
+		return fmt.Errorf("failed to decode peer list: %w", err)
+	}
+
+	// A tampered or replayed list must not touch the interface
+	if err := c.verifyPeerList(&peerList, time.Now()); err != nil {
+		c.logger.Error("Rejected peer list from server, leaving WireGuard unchanged", "error", err)
+		return fmt.Errorf("rejected peer list: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	urgent := c.urgentPeerListLocked(&peerList)
+	c.peerList = &peerList
+	c.peerListETag = resp.Header.Get("ETag")
+	c.peerListVouchedAt = 0
+	c.version = peerList.Version
+	c.peers = peerList.Peers
+	if force {
+		return c.reconfigureNowLocked()
+	}
+	return c.reconfigureLocked(urgent)
+}
+
+// applyPeerListLocked programs a verified peer list on the interface. It is
+// also used to reapply the last list when on-demand activation turns the
+// data plane on or off. Callers must hold c.mu.
+func (c *Client) applyPeerListLocked(peerList *protocol.PeerListResponse) error {
+	if c.wgInterface == nil {
+		return fmt.Errorf("interface is not available")
+	}
+
+	// Vet what the server asked us to program
+	conflicts := findAddressConflicts(c.peerID, c.assignedIP, peerList.Peers)
+	c.setAddressConflicts(conflicts)
+	online := c.programmablePeersLocked(peerList, conflicts)
+	c.warnAllowedIPsOverlaps(online)
+
+	// With on-demand activation, mesh peers are only programmed while the
+	// mesh is in use
+	if !c.dataPlaneActiveLocked() {
+		online = nil
+	}
+
+	// Update WireGuard peers in as few device updates as possible
+	peerConfigs := c.peerConfigsLocked(online)
+	failed := make(map[string]error)
+	if err := c.wgInterface.AddPeers(peerConfigs); err != nil {
+		var batchErr *wireguard.PeerBatchError
+		if !errors.As(err, &batchErr) {
+			return fmt.Errorf("failed to add peers: %w", err)
+		}
+		failed = batchErr.Failed
+	}
+
+	seen := make(map[string]bool, len(online))
+	for _, peer := range online {
+		if err, ok := failed[peer.PublicKey]; ok {
+			c.logger.Warn("Failed to add peer", "peer_id", peer.ID, "error", err)
+			continue
+		}
+		seen[peer.PublicKey] = true
+
+		if _, known := c.activePeers[peer.PublicKey]; !known {
+			c.logger.Info("Added peer", "peer_id", peer.ID, "hostname", peer.Hostname, "virtual_ip", peer.VirtualIP)
+			c.emit(Event{Type: EventPeerAdded, PeerID: peer.ID, Hostname: peer.Hostname, PublicKey: peer.PublicKey, VirtualIP: peer.VirtualIP, Endpoint: peer.Endpoint})
+		}
+		c.activePeers[peer.PublicKey] = peer
+	}
+	c.applyExtraPeersLocked(online, seen)
+	c.enforceACL(peerList.AllowedSources)
+
+	// Remove peers that went offline or left the network
+	for publicKey, peer := range c.activePeers {
+		if !seen[publicKey] {
+			c.dropPeerLocked(publicKey, peer)
+		}
+	}
+
+	return nil
+}
+
+// programmablePeersLocked returns the online peers of a verified list that
+// may be programmed, leaving out those holding a contested address, with
+// only the AllowedIPs that pass the policy. Callers must hold c.mu.
+func (c *Client) programmablePeersLocked(peerList *protocol.PeerListResponse, conflicts []protocol.AddressConflict) []protocol.PeerInfo {
+	contested := make(map[string]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		contested[conflict.IP] = true
+	}
+	c.applyConflictPreferences(peerList.Peers, peerList.Conflicts)
+	filter := c.newAllowedIPsFilter()
+	online := make([]protocol.PeerInfo, 0, len(peerList.Peers))
+	for _, peer := range peerList.Peers {
+		if !peer.Online {
+			continue
+		}
+		if ip := net.ParseIP(peer.VirtualIP); ip != nil && contested[ip.String()] {
+			continue
+		}
+		peer.AllowedIPs = c.filterAllowedIPs(filter, peer)
+		online = append(online, peer)
+	}
+	return online
+}
+
+// peerConfigsLocked returns the WireGuard configuration of the given peers.
+// Callers must hold c.mu.
+func (c *Client) peerConfigsLocked(peers []protocol.PeerInfo) []wireguard.PeerConfig {
+	peerConfigs := make([]wireguard.PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		peerConfigs = append(peerConfigs, wireguard.PeerConfig{
+			PublicKey:  peer.PublicKey,
+			Endpoint:   c.peerEndpoint(peer.PublicKey, c.selectEndpoint(peer)),
+			AllowedIPs: peer.AllowedIPs,
+			KeepAlive:  c.peerKeepalive(),
+		})
+	}
+	return peerConfigs
+}
+
+// dropPeerLocked removes a programmed peer from the interface, reporting
+// whether it is gone. Callers must hold c.mu.
+func (c *Client) dropPeerLocked(publicKey string, peer protocol.PeerInfo) bool {
+	if err := c.wgInterface.RemovePeer(publicKey); err != nil {
+		c.logger.Warn("Failed to remove peer", "peer_id", peer.ID, "error", err)
+		return false
+	}
+	delete(c.activePeers, publicKey)
+	delete(c.localPeers, publicKey)
+
+	c.logger.Info("Removed peer", "peer_id", peer.ID, "hostname", peer.Hostname)
+	c.emit(Event{Type: EventPeerRemoved, PeerID: peer.ID, Hostname: peer.Hostname, PublicKey: publicKey, VirtualIP: peer.VirtualIP})
+	return true
+}
+
+// requestTimeout returns how long a single call to the server may take
+func (c *Client) requestTimeout() time.Duration {
+	if c.config.RequestTimeout > 0 {
+		return time.Duration(c.config.RequestTimeout) * time.Second
+	}
+	return RequestTimeout
+}
+
+// sendRequest sends a JSON request to the server, failing over between the
+// configured addresses. The call is abandoned when ctx is cancelled or the
+// request timeout passes on every address.
+func (c *Client) sendRequest(ctx context.Context, path string, req interface{}, resp interface{}) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpResp, err := c.do(ctx, http.MethodPost, path, nil, nil, data)
+	if err != nil {
+		return err
+	}
+	defer drainAndClose(httpResp.Body)
+
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", httpResp.StatusCode)
+	}
+
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// Status is a snapshot of a running client, as served to "vpn-client
+// -status" by the control socket
+type Status struct {
+	Mode           string                 `json:"mode"`
+	PeerID         string                 `json:"peer_id"`
+	PublicKey      string                 `json:"public_key"`
+	Fingerprint    string                 `json:"fingerprint"`
+	AssignedIP     string                 `json:"assigned_ip"`
+	Network        string                 `json:"network"`
+	InterfaceName  string                 `json:"interface_name"`
+	Backend        string                 `json:"backend"`
+	UDP            *UDPStatus             `json:"udp,omitempty"` // Unless udp_check_window is negative
+	Setup          []SetupStep            `json:"setup"`
+	OnDemand       *OnDemandStatus        `json:"on_demand,omitempty"`       // With activation_mode "on_demand"
+	ControlChannel *ControlChannelStatus  `json:"control_channel,omitempty"` // Once started
+	ControlPlane   ControlPlaneStatus     `json:"control_plane"`
+	Watchdog       WatchdogStatus         `json:"watchdog"`
+	Reconfigure    ReconfigureStatus      `json:"reconfigure"`
+	Throughput     map[string]PeerRate    `json:"throughput"`          // Keyed by public key
+	Interface      map[string]interface{} `json:"interface,omitempty"` // Statistics reported by the WireGuard backend
+}
+
+// Status returns the client's current state
+func (c *Client) Status() (*Status, error) {
+	mode := c.config.Mode
+	if mode == "" {
+		mode = config.ModeManaged
+	}
+	status := &Status{
+		Mode:        mode,
+		PeerID:      c.peerID,
+		PublicKey:   c.publicKey,
+		Fingerprint: crypto.Fingerprint(c.publicKey),
+	}
+
+	c.mu.Lock()
+	status.AssignedIP = c.assignedIP
+	status.Network = c.networkCIDR
+	status.InterfaceName = c.interfaceName
+	status.Backend = c.backendKind
+	if c.udpCheckEnabled() {
+		udp := c.udp
+		udp.ListenPort = c.activePort
+		status.UDP = &udp
+	}
+	status.Setup = c.setupStatusLocked()
+	if c.onDemand() {
+		onDemand := c.onDemandStatusLocked()
+		status.OnDemand = &onDemand
+	}
+	if c.servers != nil {
+		channel := c.servers.status()
+		status.ControlChannel = &channel
+	}
+	wgInterface := c.wgInterface
+	status.Watchdog = c.watchdog
+	status.Reconfigure = c.reconfigureStatusLocked()
+	status.ControlPlane = c.controlPlaneStatusLocked()
+	status.Throughput = make(map[string]PeerRate, len(c.rates))
+	for key, rate := range c.rates {
+		status.Throughput[key] = rate
+	}
+	c.mu.Unlock()
+
+	if wgInterface != nil {
+		stats, err := wgInterface.GetStats()
+		if err == nil {
+			status.Interface = stats
+		}
+	}
+
+	return status, nil
+}
@@ -0,0 +1,363 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/testutil"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// stopTimeout bounds how long a test waits for a client to stop
+const stopTimeout = 5 * time.Second
+
+// newStaticClient returns a client in static mode on a fake backend, so that
+// Start needs neither a server nor root privileges
+func newStaticClient(t *testing.T) (*Client, *testutil.FakeBackend) {
+	t.Helper()
+
+	cfg := config.DefaultClientConfig()
+	cfg.Mode = config.ModeStatic
+	cfg.Address = "10.200.0.2/24"
+	cfg.InterfaceName = "wgtest0"
+	cfg.StateDir = t.TempDir()
+
+	backend := testutil.NewFakeBackend()
+	c, err := NewClient(cfg,
+		WithBackend(backend.Factory()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c, backend
+}
+
+// waitStopped fails the test unless c stops within stopTimeout
+func waitStopped(t *testing.T, c *Client) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(stopTimeout):
+		t.Fatalf("client did not stop within %s", stopTimeout)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	c, backend := newStaticClient(t)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	if err := c.Stop(); err != nil {
+		t.Fatalf("first Stop: %v", err)
+	}
+	if err := c.Stop(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("second Stop = %v, want ErrNotRunning", err)
+	}
+	waitStopped(t, c)
+
+	if _, _, destroyed := backend.State(); !destroyed {
+		t.Error("interface was not destroyed")
+	}
+	if err := c.Start(context.Background()); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("Start after Stop = %v, want ErrAlreadyStarted", err)
+	}
+}
+
+func TestConcurrentStop(t *testing.T) {
+	c, _ := newStaticClient(t)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	const stoppers = 8
+	errs := make(chan error, stoppers)
+	for i := 0; i < stoppers; i++ {
+		go func() { errs <- c.Stop() }()
+	}
+
+	succeeded := 0
+	for i := 0; i < stoppers; i++ {
+		err := <-errs
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrNotRunning):
+			t.Errorf("Stop: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d Stops succeeded, want exactly 1", succeeded)
+	}
+	waitStopped(t, c)
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	c, backend := newStaticClient(t)
+
+	if err := c.Stop(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("Stop = %v, want ErrNotRunning", err)
+	}
+	waitStopped(t, c)
+
+	if err := c.Start(context.Background()); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("Start after Stop = %v, want ErrAlreadyStarted", err)
+	}
+	if created, _, _ := backend.State(); created {
+		t.Error("interface was created after Stop")
+	}
+}
+
+func TestContextCancellationStops(t *testing.T) {
+	c, backend := newStaticClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	cancel()
+	waitStopped(t, c)
+
+	if _, _, destroyed := backend.State(); !destroyed {
+		t.Error("interface was not destroyed")
+	}
+	// The events channel is closed once the client has stopped
+	for range c.Events() {
+	}
+	if err := c.Stop(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Stop after cancellation = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestStartCancelledContext(t *testing.T) {
+	c, backend := newStaticClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Start may get the interface up before it sees the cancellation, but
+	// the client must end up stopped with nothing left behind either way
+	if err := c.Start(ctx); err != nil {
+		c.Stop()
+	}
+	waitStopped(t, c)
+
+	if created, _, destroyed := backend.State(); created && !destroyed {
+		t.Error("interface was left behind")
+	}
+}
+
+// TestStartStopRace races Stop against Start; run it with -race. Whichever
+// wins, the client must end up stopped without leaving the interface behind.
+func TestStartStopRace(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		c, backend := newStaticClient(t)
+
+		begin := make(chan struct{})
+		startErr := make(chan error, 1)
+		stopErr := make(chan error, 1)
+		go func() {
+			<-begin
+			startErr <- c.Start(context.Background())
+		}()
+		go func() {
+			<-begin
+			stopErr <- c.Stop()
+		}()
+		close(begin)
+
+		if err := <-stopErr; err != nil && !errors.Is(err, ErrNotRunning) {
+			t.Errorf("Stop: %v", err)
+		}
+		// Start either ran first, and the Stop tears it down, or was
+		// refused or cancelled; all that matters is what is left after
+		<-startErr
+		waitStopped(t, c)
+
+		if created, _, destroyed := backend.State(); created && !destroyed {
+			t.Fatalf("iteration %d: interface was left behind", i)
+		}
+		if err := c.Start(context.Background()); !errors.Is(err, ErrAlreadyStarted) {
+			t.Fatalf("iteration %d: Start after Stop = %v, want ErrAlreadyStarted", i, err)
+		}
+	}
+}
+
+// hangingServer returns a coordination server that answers /register with
+// a fixed address and holds every other request until the test ends. The
+// paths it is asked for are sent on the returned channel.
+func hangingServer(t *testing.T, registers bool) (*httptest.Server, <-chan string) {
+	t.Helper()
+
+	requests := make(chan string, 64)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- r.URL.Path:
+		default:
+		}
+		if registers && r.URL.Path == "/register" {
+			json.NewEncoder(w).Encode(protocol.RegisterResponse{
+				Success:          true,
+				PeerID:           "peer-hanging",
+				AssignedIP:       "10.200.0.2",
+				NetworkCIDR:      "10.200.0.0/24",
+				ServerPublicKey:  "c2VydmVyLXB1YmxpYy1rZXktMDEyMzQ1Njc4OTAxMjM=",
+				ServerSigningKey: "c2VydmVyLXNpZ25pbmcta2V5LTAxMjM0NTY3ODkwMTI=",
+			})
+			return
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	return srv, requests
+}
+
+// newServerClient returns a client of srv on a fake backend
+func newServerClient(t *testing.T, srv *httptest.Server, configure func(*config.ClientConfig)) (*Client, *testutil.FakeBackend) {
+	t.Helper()
+
+	cfg := config.DefaultClientConfig()
+	cfg.ServerAddr = srv.URL
+	cfg.InterfaceName = "wgtest0"
+	cfg.StateDir = t.TempDir()
+	configure(cfg)
+
+	backend := testutil.NewFakeBackend()
+	c, err := NewClient(cfg,
+		WithBackend(backend.Factory()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithEndpointDetector(func() ([]string, error) { return []string{"192.0.2.1:51820"}, nil }),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return c, backend
+}
+
+// stopWithin fails the test unless Stop returns within limit
+func stopWithin(t *testing.T, c *Client, limit time.Duration) {
+	t.Helper()
+
+	done := make(chan struct{})
+	began := time.Now()
+	go func() {
+		c.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(limit):
+		t.Fatalf("Stop did not return within %s", limit)
+	}
+	t.Logf("Stop took %s", time.Since(began))
+}
+
+func TestStopDuringHangingRegistration(t *testing.T) {
+	srv, requests := hangingServer(t, false)
+	// Far longer than the test may take: Stop must abort the call
+	c, _ := newServerClient(t, srv, func(cfg *config.ClientConfig) { cfg.RequestTimeout = 60 })
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- c.Start(context.Background()) }()
+	select {
+	case <-requests:
+	case <-time.After(stopTimeout):
+		t.Fatal("client never called the server")
+	}
+
+	stopWithin(t, c, 2*time.Second)
+	if err := <-startErr; err == nil {
+		t.Error("Start succeeded against a hanging server")
+	}
+	waitStopped(t, c)
+}
+
+func TestStopWithHangingUnregister(t *testing.T) {
+	srv, requests := hangingServer(t, true)
+	// An ephemeral client unregisters on Stop, after its context is gone,
+	// so only the request timeout bounds that call
+	c, _ := newServerClient(t, srv, func(cfg *config.ClientConfig) {
+		cfg.Ephemeral = true
+		cfg.RequestTimeout = 1
+	})
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	stopWithin(t, c, time.Second+stopTimeout/2)
+	waitStopped(t, c)
+
+	unregistered := false
+	for len(requests) > 0 {
+		if <-requests == "/unregister" {
+			unregistered = true
+		}
+	}
+	if !unregistered {
+		t.Error("ephemeral client did not try to unregister")
+	}
+}
+
+func TestBindSettingsReachBackend(t *testing.T) {
+	c, backend := newStaticClient(t)
+	c.config.BindInterface = "eth1"
+	c.config.FirewallMark = 100
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { c.Stop() })
+
+	cfg := backend.Config()
+	if cfg.BindInterface != "eth1" || cfg.FirewallMark != 100 {
+		t.Errorf("backend got bind interface %q and mark %d, want eth1 and 100", cfg.BindInterface, cfg.FirewallMark)
+	}
+}
+
+func TestBindSettingsValidation(t *testing.T) {
+	tests := []struct {
+		iface string
+		mark  int
+		want  string // Part of the error, or "" when valid
+	}{
+		{"", 0, ""},
+		{"eth1", 0, ""},
+		{"eth1", 1<<31 - 1, ""},
+		{"eth1", -1, "firewall_mark"},
+		{"eth1; reboot", 0, "bind_interface"},
+		{"an-interface-name-too-long", 0, "bind_interface"},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultClientConfig()
+		cfg.ServerAddr = "http://vpn.example.com:8080"
+		cfg.BindInterface = tt.iface
+		cfg.FirewallMark = tt.mark
+		err := cfg.Validate()
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q mark %d: %v", tt.iface, tt.mark, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q mark %d: got %v, want an error naming %s", tt.iface, tt.mark, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+const controlTimeout = 10 * time.Second
+
+// defaultEventCount is how many events the "events" command returns by default
+const defaultEventCount = 50
+
+// ControlRequest is a command sent to a running client over its control socket
+type ControlRequest struct {
+	Command string            `json:"command"`
+	Args    map[string]string `json:"args,omitempty"`
+}
+
+// ControlResponse is the reply to a ControlRequest
+type ControlResponse struct {
+	Success bool            `json:"success"`
+	Error   string          `json:"error,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// ControlSocketPath returns the path of the control socket for a client
+// running with the given configuration
+func ControlSocketPath(cfg *config.ClientConfig) string {
+	return filepath.Join(StateDir(cfg), "client.sock")
+}
+
+// Control sends a command to the client running with the given configuration
+// and decodes the reply data into out
+func Control(cfg *config.ClientConfig, req ControlRequest, out interface{}) error {
+	path := ControlSocketPath(cfg)
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return fmt.Errorf("client is not running (control socket %s): %w", path, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send command: %w", err)
+	}
+
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if !resp.Success {
+		return fmt.Errorf("%s failed: %s", req.Command, resp.Error)
+	}
+
+	if out != nil && len(resp.Data) > 0 {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// controlSocketLive reports whether a client is accepting connections on path
+func controlSocketLive(path string) bool {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// startControl listens on the control socket until the client stops
+func (c *Client) startControl() error {
+	path := ControlSocketPath(c.config)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// A socket file left by a crashed client; recoverState has already
+	// verified nobody is listening on it
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+
+	c.wg.Add(1)
+	go c.serveControl(listener)
+
+	return nil
+}
+
+// serveControl accepts control connections until the client context ends
+func (c *Client) serveControl(listener net.Listener) {
+	defer c.wg.Done()
+
+	go func() {
+		<-c.ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go c.handleControlConn(conn)
+	}
+}
+
+// handleControlConn serves a single control request
+func (c *Client) handleControlConn(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req ControlRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+
+	var resp ControlResponse
+	data, err := c.handleControl(req)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Success = true
+		if resp.Data, err = json.Marshal(data); err != nil {
+			resp.Success = false
+			resp.Error = fmt.Sprintf("failed to marshal response: %v", err)
+		}
+	}
+
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// handleControl dispatches a control command
+func (c *Client) handleControl(req ControlRequest) (interface{}, error) {
+	switch req.Command {
+	case "status":
+		return c.Status()
+	case "events":
+		last := defaultEventCount
+		if value, ok := req.Args["last"]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid event count %q", value)
+			}
+			last = n
+		}
+		return c.RecentEvents(last), nil
+	case "peers":
+		return c.Peers(), nil
+	case "debug":
+		return c.Debug()
+	case "diagnose":
+		return c.DiagnosePeer(req.Args["peer"])
+	case "accept-server-key":
+		return nil, c.AcceptServerKey(req.Args["fingerprint"])
+	case "activate":
+		return nil, c.Activate(req.Args["peer"])
+	case "apply-now":
+		return nil, c.ApplyNow()
+	case "plan":
+		return c.Plan()
+	default:
+		return nil, fmt.Errorf("unknown command %q", req.Command)
+	}
+}
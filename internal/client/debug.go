@@ -0,0 +1,59 @@
+package client
+
+import (
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// debugEventCount is how many recent events go into debug output
+const debugEventCount = 200
+
+// DebugInfo is the state of a running client gathered for a bug report.
+// Secrets never leave the client: the configuration is the redacted view,
+// and nothing else carries a private or pre-auth key.
+type DebugInfo struct {
+	Config          config.RedactedClientConfig `json:"config"`
+	Status          *Status                     `json:"status"`
+	Logs            []string                    `json:"logs"`
+	PeerListVersion uint64                      `json:"peer_list_version"`
+	PeerList        []protocol.PeerInfo         `json:"peer_list"` // Last synced list, offline peers included
+	Peers           []PeerStatus                `json:"peers"`     // Peers programmed on the interface
+	Events          []Event                     `json:"events"`
+	Interface       map[string]interface{}      `json:"interface,omitempty"`
+	InterfaceError  string                      `json:"interface_error,omitempty"`
+}
+
+// Debug gathers the client's configuration, status, recent logs and events,
+// peers and interface statistics for a debug bundle. Logs are only kept
+// when the client was given a LogBuffer.
+func (c *Client) Debug() (*DebugInfo, error) {
+	status, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &DebugInfo{
+		Config: c.config.Redacted(),
+		Status: status,
+		Peers:  c.Peers(),
+		Events: c.RecentEvents(debugEventCount),
+	}
+	if c.logs != nil {
+		info.Logs = c.logs.Lines()
+	}
+
+	c.mu.Lock()
+	info.PeerListVersion = c.version
+	info.PeerList = append([]protocol.PeerInfo(nil), c.peers...)
+	backend := c.wgInterface
+	c.mu.Unlock()
+
+	if backend == nil {
+		info.InterfaceError = "no interface"
+	} else if stats, err := backend.GetStats(); err != nil {
+		info.InterfaceError = err.Error()
+	} else {
+		info.Interface = stats
+	}
+	return info, nil
+}
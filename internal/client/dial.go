@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// errNoDialer is returned by Dial when the backend is an OS interface,
+// whose traffic is routed by the OS instead
+var errNoDialer = errors.New("the WireGuard backend does not dial; connect to the peer's virtual IP directly")
+
+// dialer is implemented by backends that carry their own network stack,
+// such as wireguard.Netstack
+type dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Dial connects to host:port on the mesh through the backend's own network
+// stack. The host is a virtual IP, or a peer's hostname or ID, resolved
+// from the last synced peer list. It only works with backends that have a
+// network stack; see wireguard.NewNetstackBackend.
+func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		if err := c.Activate(host); err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		peer, err := findPeer(c.peers, host)
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		address = net.JoinHostPort(peer.VirtualIP, port)
+	}
+
+	c.mu.Lock()
+	backend := c.wgInterface
+	c.mu.Unlock()
+	d, ok := backend.(dialer)
+	if !ok {
+		return nil, errNoDialer
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// DialPeer connects to a port on a peer, found by ID, hostname, virtual IP
+// or public key, like Dial
+func (c *Client) DialPeer(ctx context.Context, network, query string, port int) (net.Conn, error) {
+	if err := c.Activate(query); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	peer, err := findPeer(c.peers, query)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return c.Dial(ctx, network, net.JoinHostPort(peer.VirtualIP, strconv.Itoa(port)))
+}
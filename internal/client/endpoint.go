@@ -0,0 +1,236 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// maxAdvertisedEndpoints is the most endpoints the server takes from a peer
+const maxAdvertisedEndpoints = 4
+
+// EndpointCandidate is a local address the client may advertise
+type EndpointCandidate struct {
+	Address   string `json:"address"`
+	Interface string `json:"interface"`
+	Link      string `json:"link"`                // wireguard.LinkWired, LinkWireless, ...
+	Preferred bool   `json:"preferred,omitempty"` // Its interface is in endpoint_interfaces
+}
+
+// detectEndpoints finds the endpoints the client advertises: the configured
+// advertise_endpoint if any, then the addresses of the interfaces that are
+// up, best first (see endpointCandidates). With bind_interface only that
+// interface's addresses are considered. It also records whether this host
+// has global IPv6, which decides the endpoint preference in auto mode.
+func (c *Client) detectEndpoints() ([]string, error) {
+	if c.detectEndpointsFn != nil {
+		return c.detectEndpointsFn()
+	}
+
+	// A random port is only known to WireGuard, so there is nothing to advertise
+	port := c.listenPort()
+	if port == 0 {
+		if c.config.AdvertiseEndpoint != "" {
+			return []string{c.config.AdvertiseEndpoint}, nil
+		}
+		return nil, fmt.Errorf("no listen port configured")
+	}
+
+	ifaces, err := endpointInterfaces(c.config)
+	if err != nil {
+		return nil, err
+	}
+	candidates := endpointCandidates(c.config, ifaces, wireguard.LinkTypes(interfaceNames(ifaces)))
+
+	hasIPv6 := false
+	for _, candidate := range candidates {
+		hasIPv6 = hasIPv6 || net.ParseIP(candidate.Address).To4() == nil
+	}
+	c.mu.Lock()
+	c.hasIPv6 = hasIPv6
+	c.mu.Unlock()
+
+	endpoints := advertisedEndpoints(c.config, port, candidates)
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no suitable endpoint found")
+	}
+	return endpoints, nil
+}
+
+// endpointInterfaces returns the interfaces whose addresses may be
+// advertised: those that are up, other than loopback, and only
+// bind_interface when it is set
+func endpointInterfaces(cfg *config.ClientConfig) ([]net.Interface, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ifaces []net.Interface
+	for _, iface := range all {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if cfg.BindInterface != "" && iface.Name != cfg.BindInterface {
+			continue
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+func interfaceNames(ifaces []net.Interface) []string {
+	names := make([]string, len(ifaces))
+	for i, iface := range ifaces {
+		names[i] = iface.Name
+	}
+	return names
+}
+
+// endpointCandidates returns the addresses of ifaces, best first: those of
+// endpoint_interfaces in the order listed, then by the kind of link in
+// links, wired before wireless, cellular and virtual ones. IPv4 comes before
+// IPv6 on links of the same rank, and the order the system lists them in
+// is kept otherwise. Of IPv6, only global addresses are candidates.
+func endpointCandidates(cfg *config.ClientConfig, ifaces []net.Interface, links map[string]string) []EndpointCandidate {
+	preferred := make(map[string]int, len(cfg.EndpointInterfaces))
+	for i, name := range cfg.EndpointInterfaces {
+		if _, ok := preferred[name]; !ok {
+			preferred[name] = i
+		}
+	}
+	rank := func(candidate EndpointCandidate) int {
+		if i, ok := preferred[candidate.Interface]; ok {
+			return i
+		}
+		return len(preferred) + wireguard.LinkRank(candidate.Link)
+	}
+
+	var candidates []EndpointCandidate
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		_, isPreferred := preferred[iface.Name]
+		for _, addr := range addrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+
+			if ip == nil || ip.IsLoopback() || (ip.To4() == nil && !isGlobalIPv6(ip)) {
+				continue
+			}
+			candidates = append(candidates, EndpointCandidate{
+				Address:   ip.String(),
+				Interface: iface.Name,
+				Link:      links[iface.Name],
+				Preferred: isPreferred,
+			})
+		}
+	}
+
+	family := func(candidate EndpointCandidate) int {
+		if net.ParseIP(candidate.Address).To4() != nil {
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if ri, rj := rank(candidates[i]), rank(candidates[j]); ri != rj {
+			return ri < rj
+		}
+		return family(candidates[i]) < family(candidates[j])
+	})
+	return candidates
+}
+
+// advertisedEndpoints returns the endpoints to advertise for candidates
+// listening on port: advertise_endpoint first, then the candidates in
+// order, as many as the server takes. The best IPv6 candidate is kept in place of the last IPv4
+// one if it would not fit otherwise, so that peers can pick either family.
+func advertisedEndpoints(cfg *config.ClientConfig, port int, candidates []EndpointCandidate) []string {
+	var endpoints []string
+	if cfg.AdvertiseEndpoint != "" {
+		endpoints = append(endpoints, cfg.AdvertiseEndpoint)
+	}
+
+	var bestIPv6 string
+	haveIPv6 := false
+	seen := make(map[string]bool)
+	for _, candidate := range candidates {
+		ip := net.ParseIP(candidate.Address)
+		endpoint := protocol.FormatEndpoint(ip, port)
+		if seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		if ip.To4() == nil && bestIPv6 == "" {
+			bestIPv6 = endpoint
+		}
+		if len(endpoints) < maxAdvertisedEndpoints {
+			endpoints = append(endpoints, endpoint)
+			haveIPv6 = haveIPv6 || ip.To4() == nil
+		}
+	}
+	if bestIPv6 != "" && !haveIPv6 && len(endpoints) > 1 {
+		endpoints[len(endpoints)-1] = bestIPv6
+	}
+	return endpoints
+}
+
+// isGlobalIPv6 reports whether ip is an IPv6 address other peers could
+// reach: not link-local, and not a unique local (fc00::/7) address
+func isGlobalIPv6(ip net.IP) bool {
+	return ip.To4() == nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// selectEndpoint picks the endpoint to program for a peer that may have
+// advertised both an IPv4 and an IPv6 one. In auto mode IPv6 is preferred
+// when this host has a global IPv6 address. A peer with endpoints of only
+// one family, or whose primary endpoint is a DNS name it chose to
+// advertise, gets its primary endpoint. Callers must hold c.mu.
+func (c *Client) selectEndpoint(peer protocol.PeerInfo) string {
+	if protocol.IsHostnameEndpoint(peer.Endpoint) {
+		return peer.Endpoint
+	}
+
+	var preferIPv6 bool
+	switch c.config.EndpointPreference {
+	case config.EndpointPreferIPv4:
+	case config.EndpointPreferIPv6:
+		preferIPv6 = true
+	default:
+		preferIPv6 = c.hasIPv6
+	}
+
+	for _, endpoint := range peer.Endpoints {
+		if protocol.IsIPv6Endpoint(endpoint) == preferIPv6 {
+			return endpoint
+		}
+	}
+	return peer.Endpoint
+}
+
+// sameStrings reports whether two lists hold the same strings in the same
+// order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
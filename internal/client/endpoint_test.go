@@ -0,0 +1,94 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestAdvertisedEndpoints(t *testing.T) {
+	candidate := func(address string) EndpointCandidate {
+		return EndpointCandidate{Address: address, Interface: "eth0"}
+	}
+
+	tests := []struct {
+		name       string
+		advertise  string
+		candidates []EndpointCandidate
+		want       []string
+	}{
+		{"ipv4 and ipv6", "",
+			[]EndpointCandidate{candidate("192.0.2.1"), candidate("2001:db8::1")},
+			[]string{"192.0.2.1:51820", "[2001:db8::1]:51820"}},
+		{"ipv6 only", "",
+			[]EndpointCandidate{candidate("2001:db8::1"), candidate("2001:db8::2")},
+			[]string{"[2001:db8::1]:51820", "[2001:db8::2]:51820"}},
+		{"mapped ipv4 deduplicated", "",
+			[]EndpointCandidate{candidate("192.0.2.1"), candidate("::ffff:192.0.2.1")},
+			[]string{"192.0.2.1:51820"}},
+		{"ipv6 kept in the last place", "",
+			[]EndpointCandidate{candidate("192.0.2.1"), candidate("192.0.2.2"), candidate("192.0.2.3"), candidate("192.0.2.4"), candidate("192.0.2.5"), candidate("2001:db8::1")},
+			[]string{"192.0.2.1:51820", "192.0.2.2:51820", "192.0.2.3:51820", "[2001:db8::1]:51820"}},
+		{"advertised first", "[2001:db8::9]:4500",
+			[]EndpointCandidate{candidate("192.0.2.1")},
+			[]string{"[2001:db8::9]:4500", "192.0.2.1:51820"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultClientConfig()
+			cfg.AdvertiseEndpoint = tt.advertise
+			got := advertisedEndpoints(cfg, 51820, tt.candidates)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// Everything advertised is what the server accepts unchanged
+			for _, endpoint := range got {
+				if canonical, err := protocol.CanonicalEndpoint(endpoint); err != nil || canonical != endpoint {
+					t.Errorf("%s canonicalizes to %q: %v", endpoint, canonical, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectEndpoint(t *testing.T) {
+	dual := protocol.PeerInfo{
+		Endpoint:  "192.0.2.1:51820",
+		Endpoints: []string{"192.0.2.1:51820", "[2001:db8::1]:51820"},
+	}
+	v6First := protocol.PeerInfo{
+		Endpoint:  "[2001:db8::1]:51820",
+		Endpoints: []string{"[2001:db8::1]:51820", "192.0.2.1:51820"},
+	}
+	v4Only := protocol.PeerInfo{Endpoint: "192.0.2.1:51820", Endpoints: []string{"192.0.2.1:51820"}}
+	named := protocol.PeerInfo{Endpoint: "home.example.com:51820", Endpoints: []string{"home.example.com:51820", "[2001:db8::1]:51820"}}
+
+	tests := []struct {
+		name       string
+		preference string
+		hasIPv6    bool
+		peer       protocol.PeerInfo
+		want       string
+	}{
+		{"auto without ipv6", config.EndpointPreferAuto, false, dual, "192.0.2.1:51820"},
+		{"auto with ipv6", config.EndpointPreferAuto, true, dual, "[2001:db8::1]:51820"},
+		{"auto with ipv6, v6 first", "", true, v6First, "[2001:db8::1]:51820"},
+		{"ipv4", config.EndpointPreferIPv4, true, v6First, "192.0.2.1:51820"},
+		{"ipv6", config.EndpointPreferIPv6, false, dual, "[2001:db8::1]:51820"},
+		{"ipv6 from an ipv4-only peer", config.EndpointPreferIPv6, true, v4Only, "192.0.2.1:51820"},
+		{"dns name", config.EndpointPreferIPv6, true, named, "home.example.com:51820"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{config: config.DefaultClientConfig(), hasIPv6: tt.hasIPv6}
+			c.config.EndpointPreference = tt.preference
+			if got := c.selectEndpoint(tt.peer); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
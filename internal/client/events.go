@@ -0,0 +1,101 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of client event
+type EventType string
+
+const (
+	EventRegistered      EventType = "registered"
+	EventPeerAdded       EventType = "peer_added"
+	EventPeerRemoved     EventType = "peer_removed"
+	EventHeartbeatFailed EventType = "heartbeat_failed"
+	EventEndpointChanged EventType = "endpoint_changed"
+	EventAddressChanged  EventType = "address_changed"
+	EventPeerConnected   EventType = "peer_connected"
+	EventPeerLost        EventType = "peer_lost"
+)
+
+const (
+	// eventBufferSize bounds how many events are queued for a slow consumer
+	// before new ones are dropped
+	eventBufferSize = 64
+
+	// eventHistorySize is how many recent events are kept for the control
+	// socket
+	eventHistorySize = 256
+)
+
+// Event describes something that happened in the client, for driving UIs
+type Event struct {
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	PeerID    string    `json:"peer_id,omitempty"`
+	Hostname  string    `json:"hostname,omitempty"`
+	PublicKey string    `json:"public_key,omitempty"`
+	VirtualIP string    `json:"virtual_ip,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Events returns the channel on which client events are delivered. The
+// channel is closed once the client has stopped. Events are dropped rather
+// than blocking the client when the consumer falls behind.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// RecentEvents returns up to n of the most recent events, oldest first
+func (c *Client) RecentEvents(n int) []Event {
+	return c.history.last(n)
+}
+
+// emit records an event and queues it without blocking
+func (c *Client) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	c.history.add(event)
+
+	select {
+	case c.events <- event:
+	default:
+		c.logger.Debug("Dropping client event, consumer is not keeping up", "type", event.Type)
+	}
+}
+
+// eventRing keeps the most recent events
+type eventRing struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+}
+
+// add records an event, overwriting the oldest once full
+func (r *eventRing) add(event Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.events) < eventHistorySize {
+		r.events = append(r.events, event)
+		return
+	}
+	r.events[r.next] = event
+	r.next = (r.next + 1) % eventHistorySize
+}
+
+// last returns up to n of the most recent events, oldest first
+func (r *eventRing) last(n int) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ordered := append(append([]Event(nil), r.events[r.next:]...), r.events[:r.next]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// extraPeer is a locally pinned extra peer as it is programmed
+type extraPeer struct {
+	info   protocol.PeerInfo
+	config wireguard.PeerConfig
+}
+
+// applyExtraPeersLocked programs the locally pinned extra peers next to the
+// managed ones and marks them in seen. Must be called with c.mu held.
+func (c *Client) applyExtraPeersLocked(managed []protocol.PeerInfo, seen map[string]bool) {
+	for _, extra := range c.extraPeersLocked(managed) {
+		info := extra.info
+		if err := c.wgInterface.AddPeer(extra.config); err != nil {
+			c.logger.Warn("Failed to add extra peer", "peer_id", info.ID, "name", info.Hostname, "error", err)
+			continue
+		}
+		seen[info.PublicKey] = true
+
+		if _, known := c.activePeers[info.PublicKey]; !known {
+			c.logger.Info("Added extra peer", "peer_id", info.ID, "name", info.Hostname, "endpoint", info.Endpoint)
+			c.emit(Event{Type: EventPeerAdded, PeerID: info.ID, Hostname: info.Hostname, PublicKey: info.PublicKey, VirtualIP: info.VirtualIP, Endpoint: info.Endpoint})
+		}
+		c.activePeers[info.PublicKey] = info
+		c.localPeers[info.PublicKey] = true
+	}
+}
+
+// extraPeersLocked returns the extra peers to program next to the managed
+// ones. A managed peer wins every conflict: an extra peer with the same
+// public key is skipped, and AllowedIPs the server already gave to a
+// managed peer are dropped from it. Must be called with c.mu held.
+func (c *Client) extraPeersLocked(managed []protocol.PeerInfo) []extraPeer {
+	var extras []extraPeer
+	byKey := make(map[string]protocol.PeerInfo, len(managed))
+	for _, peer := range managed {
+		byKey[peer.PublicKey] = peer
+	}
+
+	for i, extra := range c.extraPeers {
+		info := staticPeerInfo(extra, fmt.Sprintf("local-%d", i+1))
+
+		if owner, ok := byKey[extra.PublicKey]; ok {
+			c.logger.Warn("Extra peer has the public key of a managed peer, using the server's configuration",
+				"peer_id", info.ID, "name", extra.Name, "managed_peer_id", owner.ID, "managed_hostname", owner.Hostname)
+			delete(c.localPeers, extra.PublicKey)
+			continue
+		}
+
+		allowed := make([]string, 0, len(extra.AllowedIPs))
+		for _, entry := range extra.AllowedIPs {
+			prefix := parsePrefix(entry)
+			taken := false
+			for _, peer := range managed {
+				for _, managedEntry := range peer.AllowedIPs {
+					managedPrefix := parsePrefix(managedEntry)
+					if prefix == nil || managedPrefix == nil || !prefixesOverlap(prefix, managedPrefix) {
+						continue
+					}
+					// WireGuard gives an identical prefix to a single peer
+					if prefix.String() == managedPrefix.String() {
+						taken = true
+						c.logger.Warn("Dropping AllowedIPs entry from extra peer, a managed peer has it",
+							"peer_id", info.ID, "name", extra.Name, "allowed_ip", entry, "managed_peer_id", peer.ID)
+					} else {
+						c.logger.Warn("AllowedIPs overlap between extra peer and managed peer",
+							"peer_id", info.ID, "name", extra.Name, "allowed_ip", entry,
+							"managed_peer_id", peer.ID, "managed_allowed_ip", managedEntry)
+					}
+				}
+			}
+			if !taken {
+				allowed = append(allowed, entry)
+			}
+		}
+		if len(allowed) < len(extra.AllowedIPs) {
+			trimmed := extra
+			trimmed.AllowedIPs = allowed
+			info = staticPeerInfo(trimmed, info.ID)
+		}
+
+		peerConfig := staticPeerConfig(extra)
+		peerConfig.Endpoint = c.peerEndpoint(extra.PublicKey, extra.Endpoint)
+		peerConfig.AllowedIPs = allowed
+		extras = append(extras, extraPeer{info: info, config: peerConfig})
+	}
+	return extras
+}
+
+// Reload applies the parts of a changed configuration that can take effect
+// while running. Currently that is the extra peers: added ones are
+// programmed and removed ones taken off the interface, without touching the
+// managed peers. Other changes need a restart.
+func (c *Client) Reload(cfg *config.ClientConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	if c.config.Static() {
+		// Static mode has no extra peers; its own peers need a restart
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config.ExtraPeers = cfg.ExtraPeers
+	c.extraPeers = cfg.ExtraPeers
+	if c.wgInterface == nil {
+		// The next setup or sync picks them up
+		return nil
+	}
+
+	managed := make([]protocol.PeerInfo, 0, len(c.activePeers))
+	for publicKey, peer := range c.activePeers {
+		if !c.localPeers[publicKey] {
+			managed = append(managed, peer)
+		}
+	}
+	seen := make(map[string]bool, len(c.extraPeers))
+	c.applyExtraPeersLocked(managed, seen)
+
+	for publicKey := range c.localPeers {
+		if seen[publicKey] {
+			continue
+		}
+		peer := c.activePeers[publicKey]
+		if err := c.wgInterface.RemovePeer(publicKey); err != nil {
+			c.logger.Warn("Failed to remove extra peer", "peer_id", peer.ID, "error", err)
+			continue
+		}
+		delete(c.activePeers, publicKey)
+		delete(c.localPeers, publicKey)
+
+		c.logger.Info("Removed extra peer", "peer_id", peer.ID, "name", peer.Hostname)
+		c.emit(Event{Type: EventPeerRemoved, PeerID: peer.ID, Hostname: peer.Hostname, PublicKey: publicKey, VirtualIP: peer.VirtualIP})
+	}
+
+	return nil
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// ServerProbeInterval is how often the preferred server address is tried
+// again while the client is failed over to another one
+const ServerProbeInterval = time.Minute
+
+// errServerReadOnly is returned for a write that only reached read-only
+// replicas. The primary is down; the write is retried on the next attempt.
+var errServerReadOnly = errors.New("coordination server is a read-only replica and no primary could be reached")
+
+// errServerDraining is returned for a registration refused by a draining
+// server. It is not a failure: the client waits as long as it was told to
+// and registers again.
+var errServerDraining = errors.New("coordination server is draining")
+
+// retryLaterError carries how long a draining server asked us to wait
+type retryLaterError struct {
+	after time.Duration
+}
+
+func (e *retryLaterError) Error() string {
+	return fmt.Sprintf("%v, retry in %v", errServerDraining, e.after)
+}
+
+func (e *retryLaterError) Unwrap() error { return errServerDraining }
+
+// parseRetryAfter reads a Retry-After header given in seconds, falling
+// back to def when it is missing or malformed
+func parseRetryAfter(header string, def time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(header)
+	if err != nil || seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ControlChannelStatus describes the connection to the coordination server
+type ControlChannelStatus struct {
+	Active              string   `json:"active"`    // Address calls currently go to
+	Preferred           bool     `json:"preferred"` // Active is the first configured address
+	Addresses           []string `json:"addresses"`
+	ConsecutiveFailures int      `json:"consecutive_failures"` // Calls that failed on every address
+	LastError           string   `json:"last_error,omitempty"`
+	LastFailover        string   `json:"last_failover,omitempty"`
+}
+
+// serverAddrs holds the coordination server addresses in order of
+// preference and which one calls currently go to. A call sticks to the
+// address that last worked and only moves on when it fails.
+type serverAddrs struct {
+	mu           sync.Mutex
+	urls         []*url.URL
+	active       int
+	failures     int
+	lastError    string
+	lastFailover time.Time
+}
+
+// parseServerAddrs parses server_addr followed by server_addrs
+func parseServerAddrs(primary string, more []string) (*serverAddrs, error) {
+	servers := &serverAddrs{}
+	seen := make(map[string]bool)
+	for _, raw := range append([]string{primary}, more...) {
+		if raw == "" && len(servers.urls) > 0 {
+			continue
+		}
+		serverURL, err := parseServerURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		if seen[serverURL.String()] {
+			continue
+		}
+		seen[serverURL.String()] = true
+		servers.urls = append(servers.urls, serverURL)
+	}
+	return servers, nil
+}
+
+// order returns the addresses to try for a call: the active one first, then
+// the others in order of preference
+func (s *serverAddrs) order() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order := make([]int, 0, len(s.urls))
+	order = append(order, s.active)
+	for i := range s.urls {
+		if i != s.active {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// activeHost returns the host name or IP of the address calls go to
+func (s *serverAddrs) activeHost() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.urls[s.active].Hostname()
+}
+
+// url returns the URL of path on address i
+func (s *serverAddrs) url(i int, path string, query url.Values) string {
+	u := s.urls[i].JoinPath(path)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// succeeded records a working call on address i, reporting whether calls
+// moved to it
+func (s *serverAddrs) succeeded(i int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = 0
+	s.lastError = ""
+	if s.active == i {
+		return false
+	}
+	s.active = i
+	s.lastFailover = time.Now()
+	return true
+}
+
+// failed records a call that failed on every address
+func (s *serverAddrs) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+	s.lastError = err.Error()
+}
+
+// status reports the control channel for Status
+func (s *serverAddrs) status() ControlChannelStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := ControlChannelStatus{
+		Active:              s.urls[s.active].String(),
+		Preferred:           s.active == 0,
+		ConsecutiveFailures: s.failures,
+		LastError:           s.lastError,
+	}
+	for _, u := range s.urls {
+		status.Addresses = append(status.Addresses, u.String())
+	}
+	if !s.lastFailover.IsZero() {
+		status.LastFailover = s.lastFailover.Format(time.RFC3339)
+	}
+	return status
+}
+
+// failoverStatus reports whether a response means this address cannot
+// serve the call right now, so that another address should be tried.
+// Other statuses are the server's answer and are returned to the caller.
+func failoverStatus(code int) bool {
+	switch code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a call to the coordination server. Each address is tried in turn,
+// starting with the one that last worked, and each attempt gets the full
+// request timeout. The response body must be closed by the caller.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	var lastErr error
+	readOnly := false
+	var draining error
+	for _, i := range c.servers.order() {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+		resp, err := c.attempt(attemptCtx, method, c.servers.url(i, path, query), header, body)
+		if err == nil {
+			if c.servers.succeeded(i) {
+				c.logger.Warn("Switched coordination server address", "server", c.servers.urls[i].String())
+			}
+			// The attempt's deadline covers reading the body too
+			resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		cancel()
+		lastErr = err
+		if errors.Is(err, errServerReadOnly) {
+			readOnly = true
+		}
+		if errors.Is(err, errServerDraining) {
+			draining = err
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+		c.logger.Debug("Coordination server unreachable", "server", c.servers.urls[i].String(), "error", err)
+	}
+	// A replica answering says more than a primary that did not
+	if readOnly {
+		lastErr = errServerReadOnly
+	}
+	if draining != nil {
+		lastErr = draining
+	}
+	c.servers.failed(lastErr)
+	return nil, lastErr
+}
+
+// attempt sends a single call to one address
+func (c *Client) attempt(ctx context.Context, method, target string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if failoverStatus(resp.StatusCode) {
+		var failure struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, maxDrainBytes)).Decode(&failure)
+		drainAndClose(resp.Body)
+		switch failure.Code {
+		case protocol.ErrorCodeReadOnly:
+			return nil, errServerReadOnly
+		case protocol.ErrorCodeRetryLater:
+			return nil, &retryLaterError{after: parseRetryAfter(resp.Header.Get("Retry-After"), RetryInterval)}
+		}
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// cancelOnClose releases an attempt's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// serverProbeRoutine fails back to the preferred address once it answers
+// again. Calls stick to the address that works, so without probing the
+// client would stay on a fallback address indefinitely.
+func (c *Client) serverProbeRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(ServerProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.probePreferredServer(c.ctx)
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// probePreferredServer moves calls back to the preferred address if the
+// client is failed over and that address answers again
+func (c *Client) probePreferredServer(ctx context.Context) {
+	if c.servers.order()[0] == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout())
+	defer cancel()
+	resp, err := c.attempt(ctx, http.MethodGet, c.servers.url(0, "/version", nil), nil, nil)
+	if err != nil {
+		return
+	}
+	drainAndClose(resp.Body)
+	if resp.StatusCode == http.StatusOK && c.servers.succeeded(0) {
+		c.logger.Info("Coordination server failed back to the preferred address", "server", c.servers.urls[0].String())
+	}
+}
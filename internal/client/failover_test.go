@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/internal/testutil"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// switchableServer is a coordination server address that can be taken
+// down, answering 503 as a load balancer with no backend would
+type switchableServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	down bool
+	hits int
+}
+
+func newSwitchableServer(t *testing.T) *switchableServer {
+	s := &switchableServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.hits++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version":"test"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *switchableServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// takeHits returns the calls the server saw since the last takeHits
+func (s *switchableServer) takeHits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hits := s.hits
+	s.hits = 0
+	return hits
+}
+
+func TestFailoverAndFailBack(t *testing.T) {
+	primary, secondary := newSwitchableServer(t), newSwitchableServer(t)
+
+	cfg := config.DefaultClientConfig()
+	cfg.ServerAddr = primary.URL
+	cfg.ServerAddrs = []string{secondary.URL}
+	cfg.InterfaceName = "wgtest0"
+	cfg.StateDir = t.TempDir()
+	c, err := NewClient(cfg,
+		WithBackend(testutil.NewFakeBackend().Factory()),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx := context.Background()
+
+	call := func() {
+		t.Helper()
+		resp, err := c.do(ctx, http.MethodGet, "/version", nil, nil, nil)
+		if err != nil {
+			t.Fatalf("call: %v", err)
+		}
+		drainAndClose(resp.Body)
+	}
+	expect := func(step string, primaryHits, secondaryHits int, active string) {
+		t.Helper()
+		if got := primary.takeHits(); got != primaryHits {
+			t.Errorf("%s: primary got %d calls, want %d", step, got, primaryHits)
+		}
+		if got := secondary.takeHits(); got != secondaryHits {
+			t.Errorf("%s: secondary got %d calls, want %d", step, got, secondaryHits)
+		}
+		if got := c.servers.status().Active; got != active {
+			t.Errorf("%s: active address %s, want %s", step, got, active)
+		}
+	}
+
+	call()
+	expect("both up", 1, 0, primary.URL)
+	if status := c.servers.status(); !status.Preferred || status.LastFailover != "" {
+		t.Errorf("status before failing over: %+v", status)
+	}
+
+	// The primary goes down: the call moves on, and later calls stick
+	primary.setDown(true)
+	call()
+	expect("failover", 1, 1, secondary.URL)
+	call()
+	expect("after failover", 0, 1, secondary.URL)
+	if status := c.servers.status(); status.Preferred || status.LastFailover == "" || status.ConsecutiveFailures != 0 {
+		t.Errorf("status after failing over: %+v", status)
+	}
+
+	// Probing a primary that is still down changes nothing
+	c.probePreferredServer(ctx)
+	expect("probe while down", 1, 0, secondary.URL)
+
+	// Once it is back, the probe fails back and calls follow
+	primary.setDown(false)
+	call()
+	expect("primary back, before probing", 0, 1, secondary.URL)
+	c.probePreferredServer(ctx)
+	expect("probe", 1, 0, primary.URL)
+	call()
+	expect("after failing back", 1, 0, primary.URL)
+
+	// With every address down the call fails and is counted
+	primary.setDown(true)
+	secondary.setDown(true)
+	if _, err := c.do(ctx, http.MethodGet, "/version", nil, nil, nil); err == nil {
+		t.Fatal("call succeeded with every address down")
+	}
+	expect("all down", 1, 1, primary.URL)
+	if status := c.servers.status(); status.ConsecutiveFailures != 1 || status.LastError == "" {
+		t.Errorf("status with every address down: %+v", status)
+	}
+
+	// A cancelled call does not try the other addresses
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.do(cancelled, http.MethodGet, "/version", nil, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled call: %v", err)
+	}
+	expect("cancelled", 0, 0, primary.URL)
+}
+
+func TestServerURLUnderBasePath(t *testing.T) {
+	query := url.Values{"peer_id": {"peer-a"}}
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"http://vpn.example.com:8080", "http://vpn.example.com:8080/peers?peer_id=peer-a"},
+		{"http://vpn.example.com:8080/", "http://vpn.example.com:8080/peers?peer_id=peer-a"},
+		{"https://ops.example.com/vpn", "https://ops.example.com/vpn/peers?peer_id=peer-a"},
+		{"https://ops.example.com/vpn/", "https://ops.example.com/vpn/peers?peer_id=peer-a"},
+		{"https://ops.example.com/tools/vpn/", "https://ops.example.com/tools/vpn/peers?peer_id=peer-a"},
+	}
+	for _, tt := range tests {
+		servers, err := parseServerAddrs(tt.addr, nil)
+		if err != nil {
+			t.Fatalf("parseServerAddrs(%q): %v", tt.addr, err)
+		}
+		if got := servers.url(0, "/peers", query); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+
+	// The same base path with and without its slash is one address
+	servers, err := parseServerAddrs("https://ops.example.com/vpn", []string{"https://ops.example.com/vpn/"})
+	if err != nil {
+		t.Fatalf("parseServerAddrs: %v", err)
+	}
+	if len(servers.urls) != 1 {
+		t.Errorf("got %d addresses, want 1", len(servers.urls))
+	}
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+// openFirewall opens the listen port in the host firewall when
+// manage_firewall is set, so that peers can reach this node first rather
+// than only being reached by it. Failure is returned, but only fatal under
+// the strict setup policy: the node still works, it just has to initiate.
+func (c *Client) openFirewall() error {
+	if !c.config.ManageFirewall {
+		return nil
+	}
+	c.mu.Lock()
+	port := c.activePort
+	name := wireguard.FirewallRuleName(c.interfaceName, port)
+	c.mu.Unlock()
+	if port == 0 {
+		c.logger.Warn("Not opening the firewall: no listen_port configured")
+		return nil
+	}
+
+	rule, err := wireguard.OpenFirewallPort(name, port)
+	if err != nil {
+		c.logger.Warn("Failed to open the listen port in the firewall; peers may be unable to initiate connections", "port", port, "error", err)
+		return err
+	}
+	if rule == nil {
+		c.logger.Info("No firewall rule needed for the listen port", "port", port)
+		return nil
+	}
+
+	// Recorded so that a crash leaves nothing behind after the next start
+	if err := c.state.Update(func(s *State) { s.Firewall = rule }); err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+	c.mu.Lock()
+	c.firewallRule = rule
+	c.mu.Unlock()
+	c.logger.Info("Opened listen port in the firewall", "port", rule.Port, "tool", rule.Tool, "rule", rule.Name)
+	return nil
+}
+
+// closeFirewall removes the rule added by openFirewall. It returns false
+// when the rule is still in place, leaving it recorded for the next start.
+func (c *Client) closeFirewall() bool {
+	c.mu.Lock()
+	rule := c.firewallRule
+	c.mu.Unlock()
+
+	if rule == nil {
+		return true
+	}
+	if err := c.cleaner.CloseFirewallPort(*rule); err != nil {
+		c.logger.Warn("Failed to remove firewall rule", "rule", rule.Name, "error", err)
+		return false
+	}
+
+	c.mu.Lock()
+	c.firewallRule = nil
+	c.mu.Unlock()
+	if err := c.state.Update(func(s *State) { s.Firewall = nil }); err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+	return true
+}
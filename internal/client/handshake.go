@@ -0,0 +1,85 @@
+package client
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// HandshakeTimeout is how recent a peer's last handshake must be for the
+// peer to count as connected. WireGuard rekeys every two minutes while
+// traffic flows.
+const HandshakeTimeout = 3 * time.Minute
+
+// updateHandshakes tracks each peer's connectivity across samples and
+// returns events for peers that connected or were lost. The first sample of
+// a peer only records its state, so startup does not produce a burst of
+// "connected" events. Caller must hold mu.
+func (c *Client) updateHandshakes(samples map[string]peerSample, now time.Time) []Event {
+	var events []Event
+
+	for key, sample := range samples {
+		connected := !sample.lastHandshake.IsZero() && now.Sub(sample.lastHandshake) < HandshakeTimeout
+
+		was, seen := c.connected[key]
+		c.connected[key] = connected
+		if !seen || was == connected {
+			continue
+		}
+
+		event := Event{Type: EventPeerLost, Time: now, PublicKey: key, Endpoint: sample.endpoint}
+		if connected {
+			event.Type = EventPeerConnected
+		}
+		if peer, ok := c.activePeers[key]; ok {
+			event.PeerID = peer.ID
+			event.Hostname = peer.Hostname
+			event.VirtualIP = peer.VirtualIP
+		}
+
+		c.logger.Info("Peer connectivity changed",
+			"event", event.Type,
+			"peer", event.Hostname,
+			"public_key", key,
+			"endpoint", sample.endpoint,
+			"last_handshake", sample.lastHandshake,
+		)
+		events = append(events, event)
+	}
+
+	// Forget peers that are no longer configured
+	for key := range c.connected {
+		if _, ok := samples[key]; !ok {
+			delete(c.connected, key)
+		}
+	}
+
+	return events
+}
+
+// handshakeReports returns where WireGuard heard each peer it recently
+// completed a handshake with from, for the heartbeat. The server takes such a
+// handshake as proof that a peer moved to a new endpoint.
+func (c *Client) handshakeReports(now time.Time) []protocol.HandshakeReport {
+	c.mu.Lock()
+	backend := c.wgInterface
+	c.mu.Unlock()
+	if backend == nil {
+		return nil
+	}
+	stats, err := backend.GetStats()
+	if err != nil {
+		return nil
+	}
+
+	var reports []protocol.HandshakeReport
+	for key, sample := range peerSamples(stats) {
+		if sample.endpoint == "" || sample.lastHandshake.IsZero() || now.Sub(sample.lastHandshake) >= HandshakeTimeout {
+			continue
+		}
+		reports = append(reports, protocol.HandshakeReport{PublicKey: key, Endpoint: sample.endpoint})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].PublicKey < reports[j].PublicKey })
+	return reports
+}
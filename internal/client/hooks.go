@@ -0,0 +1,169 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// DefaultHookTimeout is how long a hook may run unless configured
+const DefaultHookTimeout = 30 * time.Second
+
+// Hook names, passed to hooks as WGMESH_HOOK
+const (
+	hookPostUp           = "post_up"
+	hookPreDown          = "pre_down"
+	hookPeerConnected    = "peer_connected"
+	hookPeerDisconnected = "peer_disconnected"
+)
+
+// hookRunner serializes the runs of each hook, so that a slow script never
+// overlaps with itself
+type hookRunner struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock returns the mutex serializing runs of the named hook
+func (h *hookRunner) lock(name string) *sync.Mutex {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.locks == nil {
+		h.locks = make(map[string]*sync.Mutex)
+	}
+	if h.locks[name] == nil {
+		h.locks[name] = &sync.Mutex{}
+	}
+	return h.locks[name]
+}
+
+// hookPath returns the executable configured for a hook, or ""
+func (c *Client) hookPath(name string) string {
+	hooks := c.config.Hooks
+	switch name {
+	case hookPostUp:
+		return hooks.PostUp
+	case hookPreDown:
+		return hooks.PreDown
+	case hookPeerConnected:
+		return hooks.PeerConnected
+	case hookPeerDisconnected:
+		return hooks.PeerDisconnected
+	}
+	return ""
+}
+
+// hookTimeout returns how long a hook may run
+func (c *Client) hookTimeout() time.Duration {
+	if c.config.Hooks.Timeout > 0 {
+		return time.Duration(c.config.Hooks.Timeout) * time.Second
+	}
+	return DefaultHookTimeout
+}
+
+// runHook runs a hook to completion, logging its output, and returns its
+// failure. An unset hook does nothing. The environment carries the
+// interface and our address, plus the peer for peer events.
+func (c *Client) runHook(name string, peer *Event) error {
+	path := c.hookPath(name)
+	if path == "" {
+		return nil
+	}
+
+	lock := c.hooks.lock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.Lock()
+	env := []string{
+		"WGMESH_HOOK=" + name,
+		"WGMESH_INTERFACE=" + c.interfaceName,
+		"WGMESH_ASSIGNED_IP=" + c.assignedIP,
+		"WGMESH_NETWORK=" + c.networkCIDR,
+	}
+	c.mu.Unlock()
+	if peer != nil {
+		env = append(env,
+			"WGMESH_PEER_ID="+peer.PeerID,
+			"WGMESH_PEER_NAME="+peer.Hostname,
+			"WGMESH_PEER_IP="+peer.VirtualIP,
+			"WGMESH_PEER_PUBLIC_KEY="+peer.PublicKey,
+			"WGMESH_PEER_ENDPOINT="+peer.Endpoint,
+		)
+	}
+
+	// Not tied to the client's context: pre_down runs after it is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), c.hookTimeout())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), env...)
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		c.logger.Info("Hook output", "hook", name, "line", scanner.Text())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("hook %s timed out after %v", name, c.hookTimeout())
+	} else if err != nil {
+		err = fmt.Errorf("hook %s failed: %w", name, err)
+	}
+	if err != nil {
+		c.logger.Warn("Hook failed", "hook", name, "path", path, "error", err)
+		return err
+	}
+	c.logger.Debug("Hook ran", "hook", name, "path", path, "duration", time.Since(start))
+	return nil
+}
+
+// runPostUpHook runs the post_up hook as a setup step, returning its
+// failure if Start must fail: with post_up_failure "abort", or under the
+// strict setup policy
+func (c *Client) runPostUpHook() error {
+	if c.config.Hooks.PostUp == "" {
+		return nil
+	}
+	err := c.runHook(hookPostUp, nil)
+	abort := c.config.Hooks.PostUpFailure == config.HookFailureAbort
+	if c.setupStep(hookPostUp, err, abort) {
+		return err
+	}
+	return nil
+}
+
+// runPeerHooks runs the peer_connected and peer_disconnected hooks for
+// connectivity events in the background, so that a slow script does not
+// hold up stats sampling. Runs of one hook still happen one at a time.
+func (c *Client) runPeerHooks(events []Event) {
+	var hookEvents []Event
+	for _, event := range events {
+		if event.Type == EventPeerConnected && c.config.Hooks.PeerConnected != "" ||
+			event.Type == EventPeerLost && c.config.Hooks.PeerDisconnected != "" {
+			hookEvents = append(hookEvents, event)
+		}
+	}
+	if len(hookEvents) == 0 {
+		return
+	}
+
+	// Called from statsRoutine, which holds wg, so Add cannot race Wait
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for _, event := range hookEvents {
+			name := hookPeerConnected
+			if event.Type == EventPeerLost {
+				name = hookPeerDisconnected
+			}
+			c.runHook(name, &event)
+		}
+	}()
+}
@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+// ErrPeerUnknown is returned by CheckIdentity when the server has no peer
+// with the identity's key, e.g. because it expired or was removed. Starting
+// the client registers it as a new peer, with a new ID and address.
+var ErrPeerUnknown = errors.New("the server no longer knows this peer; it was removed or expired")
+
+// ErrPeerSuspended is returned by CheckIdentity when an administrator
+// suspended the peer
+var ErrPeerSuspended = errors.New("the peer is suspended on the server")
+
+// Identity is what makes a client the same peer to the server: its key
+// pair, the peer ID and address it was given, and the server it registered
+// with, along with the server keys it pinned
+type Identity struct {
+	PrivateKey       string    `json:"private_key"`
+	PublicKey        string    `json:"public_key"`
+	PeerID           string    `json:"peer_id,omitempty"`
+	AssignedIP       string    `json:"assigned_ip,omitempty"`
+	ServerAddr       string    `json:"server_addr"`
+	ServerAddrs      []string  `json:"server_addrs,omitempty"`
+	ServerPublicKey  string    `json:"server_public_key,omitempty"`
+	ServerSigningKey string    `json:"server_signing_key,omitempty"`
+	ExportedAt       time.Time `json:"exported_at"`
+}
+
+// IdentityStatus is the server's view of a restored identity. The server
+// only says whether it knows the key, not the peer's ID or address.
+type IdentityStatus struct {
+	// ServerKeyChanged is set when the server presents other keys than
+	// the identity pinned. The client checks the change when it starts.
+	ServerKeyChanged bool
+}
+
+// ExportIdentity reads the client's identity from its configuration and
+// key storage
+func ExportIdentity(cfg *config.ClientConfig) (*Identity, error) {
+	store, err := config.NewSecretStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := store.Get(config.SecretPrivateKey)
+	if errors.Is(err, config.ErrSecretNotFound) {
+		return nil, errors.New("the client has no private key yet")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	identity := &Identity{
+		PrivateKey:       privateKey,
+		PublicKey:        cfg.PublicKey,
+		PeerID:           cfg.PeerID,
+		AssignedIP:       cfg.AssignedIP,
+		ServerAddr:       cfg.ServerAddr,
+		ServerAddrs:      cfg.ServerAddrs,
+		ServerPublicKey:  cfg.ServerPublicKey,
+		ServerSigningKey: cfg.ServerSigningKey,
+		ExportedAt:       time.Now().UTC(),
+	}
+	if identity.PublicKey == "" {
+		if identity.PublicKey, err = crypto.DerivePublicKeyString(privateKey); err != nil {
+			return nil, err
+		}
+	}
+	if err := identity.Validate(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// Validate checks that the identity's keys form a pair and that it names a
+// server
+func (id *Identity) Validate() error {
+	if err := crypto.ValidateKeyPair(id.PrivateKey, id.PublicKey); err != nil {
+		return fmt.Errorf("invalid key pair: %w", err)
+	}
+	if id.ServerAddr == "" {
+		return errors.New("server_addr is required")
+	}
+	return nil
+}
+
+// SealIdentity encrypts an identity with a passphrase, for writing to a
+// backup file
+func SealIdentity(id *Identity, passphrase []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Zero(plaintext)
+	return crypto.SealWithPassphrase(plaintext, passphrase)
+}
+
+// OpenIdentity decrypts and validates a backup written by SealIdentity
+func OpenIdentity(data, passphrase []byte) (*Identity, error) {
+	plaintext, err := crypto.OpenWithPassphrase(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Zero(plaintext)
+
+	var id Identity
+	if err := json.Unmarshal(plaintext, &id); err != nil {
+		return nil, fmt.Errorf("failed to parse identity: %w", err)
+	}
+	if err := id.Validate(); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// RestoreIdentity writes an identity into a client configuration, storing
+// the private key in the configured key storage. The caller saves the
+// configuration.
+func RestoreIdentity(cfg *config.ClientConfig, id *Identity) error {
+	if err := id.Validate(); err != nil {
+		return err
+	}
+
+	cfg.PublicKey = id.PublicKey
+	cfg.PeerID = id.PeerID
+	cfg.AssignedIP = id.AssignedIP
+	cfg.ServerAddr = id.ServerAddr
+	cfg.ServerAddrs = id.ServerAddrs
+	cfg.ServerPublicKey = id.ServerPublicKey
+	cfg.ServerSigningKey = id.ServerSigningKey
+
+	store, err := config.NewSecretStore(cfg)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(config.SecretPrivateKey, id.PrivateKey); err != nil {
+		return fmt.Errorf("failed to store private key: %w", err)
+	}
+	return nil
+}
+
+// CheckIdentity asks the server, with a dry-run registration that changes
+// nothing, whether it still knows the configured key. It returns
+// ErrPeerUnknown or ErrPeerSuspended when the server would not take the
+// peer back as it was.
+func CheckIdentity(ctx context.Context, cfg *config.ClientConfig) (*IdentityStatus, error) {
+	servers, err := parseServerAddrs(cfg.ServerAddr, cfg.ServerAddrs)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		config:     cfg,
+		servers:    servers,
+		httpClient: httpClient,
+		logger:     slog.Default(),
+	}
+
+	req := protocol.RegisterRequest{
+		PublicKey:     cfg.PublicKey,
+		OS:            runtime.GOOS,
+		ClientVersion: version.Get().Version,
+		DryRun:        true,
+	}
+	var resp protocol.RegisterResponse
+	if err := c.sendRequest(ctx, "/register", req, &resp); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.Success:
+	case resp.Code == protocol.ErrorCodeUnknownPeer:
+		return nil, ErrPeerUnknown
+	case resp.Code == protocol.ErrorCodePeerSuspended:
+		return nil, ErrPeerSuspended
+	default:
+		return nil, fmt.Errorf("server refused the check: %s", resp.Error)
+	}
+
+	return &IdentityStatus{
+		ServerKeyChanged: cfg.ServerPublicKey != "" && resp.ServerPublicKey != cfg.ServerPublicKey ||
+			cfg.ServerSigningKey != "" && resp.ServerSigningKey != "" && resp.ServerSigningKey != cfg.ServerSigningKey,
+	}, nil
+}
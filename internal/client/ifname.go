@@ -0,0 +1,91 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// maxInterfaceNameTries is how many numbered names are tried after the
+// configured interface name is found taken
+const maxInterfaceNameTries = 32
+
+// interfaceExists reports whether the host has an interface called name
+func interfaceExists(name string) bool {
+	_, err := net.InterfaceByName(name)
+	return err == nil
+}
+
+// requestedInterfaceName returns the name the client asks for: the one it
+// picked on an earlier start, or else the configured one
+func (c *Client) requestedInterfaceName() string {
+	if c.config.ChosenInterfaceName != "" {
+		return c.config.ChosenInterfaceName
+	}
+	return c.config.InterfaceName
+}
+
+// chooseInterfaceName returns the name to create the interface with. A
+// name held by an interface the state file does not record as this
+// client's belongs to another client, such as a second profile, and is
+// skipped for the next free numbered one: wg1, wg2 and so on after wg0. The
+// pick is saved so later starts ask for it again. On macOS the kernel picks
+// the utun number, and injected backends create no host interface, so the
+// request is returned as it is.
+func (c *Client) chooseInterfaceName() (string, error) {
+	requested := c.requestedInterfaceName()
+	if c.customBackend || runtime.GOOS == "darwin" {
+		return requested, nil
+	}
+
+	owned := c.state.InterfaceName()
+	free := func(name string) bool {
+		return name == owned || !interfaceExists(name)
+	}
+
+	name := ""
+	for _, candidate := range []string{requested, c.config.InterfaceName} {
+		if free(candidate) {
+			name = candidate
+			break
+		}
+	}
+	for n := 1; name == "" && n <= maxInterfaceNameTries; n++ {
+		candidate := numberedInterfaceName(c.config.InterfaceName, n)
+		if network.ValidateInterfaceName(candidate) != nil {
+			break
+		}
+		if free(candidate) {
+			name = candidate
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("interface %s is held by another client, and so are the names after it", c.config.InterfaceName)
+	}
+
+	if name != requested {
+		c.logger.Warn("Interface name is held by another client, using another", "configured", c.config.InterfaceName, "interface", name)
+	}
+	chosen := name
+	if chosen == c.config.InterfaceName {
+		chosen = ""
+	}
+	if chosen != c.config.ChosenInterfaceName {
+		c.config.ChosenInterfaceName = chosen
+		c.saveConfig()
+	}
+	return name, nil
+}
+
+// numberedInterfaceName returns the nth name after name, counting up from
+// its trailing number: "wg0" gives "wg1", "wg2" and so on, and "mesh"
+// gives "mesh1", "mesh2"
+func numberedInterfaceName(name string, n int) string {
+	base := strings.TrimRight(name, "0123456789")
+	start, _ := strconv.Atoi(name[len(base):])
+	return base + strconv.Itoa(start+n)
+}
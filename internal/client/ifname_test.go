@@ -0,0 +1,38 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+func TestNumberedInterfaceName(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"wg0", 1, "wg1"},
+		{"wg0", 2, "wg2"},
+		{"wg9", 1, "wg10"},
+		{"mesh", 1, "mesh1"},
+		{"wg-mesh-07", 3, "wg-mesh-10"},
+	}
+	for _, tt := range tests {
+		if got := numberedInterfaceName(tt.name, tt.n); got != tt.want {
+			t.Errorf("numberedInterfaceName(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestNumberedInterfaceNameStaysValid(t *testing.T) {
+	// Counting up past the length limit gives a name that is rejected, which
+	// ends chooseInterfaceName's search instead of reaching a platform command
+	name := "wg-mesh-laptop9"
+	if err := network.ValidateInterfaceName(name); err != nil {
+		t.Fatalf("ValidateInterfaceName(%q): %v", name, err)
+	}
+	if next := numberedInterfaceName(name, 1); network.ValidateInterfaceName(next) == nil {
+		t.Errorf("numberedInterfaceName(%q, 1) = %q, which is accepted", name, next)
+	}
+}
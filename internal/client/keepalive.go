@@ -0,0 +1,51 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+// peerKeepalive returns the persistent keepalive to program for peers under
+// the configured policy. Callers must hold c.mu.
+func (c *Client) peerKeepalive() time.Duration {
+	seconds := c.config.PersistentKeepalive
+	if seconds < 0 {
+		return 0
+	}
+	// Peers cannot reach a hidden client they are not told about, so it
+	// keeps its tunnels up itself
+	if c.config.KeepaliveOnlyForNAT && !c.behindNAT && !c.config.Hidden {
+		return 0
+	}
+
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if c.serverKeepalive > 0 {
+		return time.Duration(c.serverKeepalive) * time.Second
+	}
+	return wireguard.DefaultKeepAlive
+}
+
+// detectNAT compares the locally detected endpoints with the address the
+// server observed; when none matches we are behind NAT. Without an observed
+// address we assume NAT, which errs on the side of keeping mappings alive.
+func detectNAT(localEndpoints []string, observedIP string) bool {
+	observed := net.ParseIP(observedIP)
+	if observed == nil {
+		return true
+	}
+
+	for _, endpoint := range localEndpoints {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			continue
+		}
+		if local := net.ParseIP(host); local != nil && local.Equal(observed) {
+			return false
+		}
+	}
+	return true
+}
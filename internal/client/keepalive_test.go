@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+func TestPeerKeepalivePolicy(t *testing.T) {
+	tests := []struct {
+		name            string
+		configured      int  // persistent_keepalive
+		onlyForNAT      bool // keepalive_only_for_nat
+		hidden          bool
+		behindNAT       bool
+		serverKeepalive int
+		want            time.Duration
+	}{
+		{name: "default", want: wireguard.DefaultKeepAlive},
+		{name: "server recommendation", serverKeepalive: 40, want: 40 * time.Second},
+		{name: "configured beats server", configured: 15, serverKeepalive: 40, want: 15 * time.Second},
+		{name: "disabled", configured: -1, serverKeepalive: 40, want: 0},
+		{name: "nat only, public address", onlyForNAT: true, configured: 15, want: 0},
+		{name: "nat only, behind nat", onlyForNAT: true, behindNAT: true, configured: 15, want: 15 * time.Second},
+		{name: "nat only, behind nat, default", onlyForNAT: true, behindNAT: true, want: wireguard.DefaultKeepAlive},
+		{name: "nat only, hidden", onlyForNAT: true, hidden: true, want: wireguard.DefaultKeepAlive},
+		{name: "disabled beats nat", onlyForNAT: true, behindNAT: true, configured: -1, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newStaticClient(t)
+			c.config.PersistentKeepalive = tt.configured
+			c.config.KeepaliveOnlyForNAT = tt.onlyForNAT
+			c.config.Hidden = tt.hidden
+			c.behindNAT = tt.behindNAT
+			c.serverKeepalive = tt.serverKeepalive
+
+			c.mu.Lock()
+			got := c.peerKeepalive()
+			c.mu.Unlock()
+			if got != tt.want {
+				t.Errorf("peerKeepalive() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectNAT(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    []string
+		observed string
+		want     bool
+	}{
+		{"observed matches", []string{"192.168.1.2:51820", "203.0.113.5:51820"}, "203.0.113.5", false},
+		{"observed differs", []string{"192.168.1.2:51820"}, "203.0.113.5", true},
+		{"ipv6 match", []string{"[2001:db8::5]:51820"}, "2001:db8::5", false},
+		{"nothing observed", []string{"203.0.113.5:51820"}, "", true},
+	}
+	for _, tt := range tests {
+		if got := detectNAT(tt.local, tt.observed); got != tt.want {
+			t.Errorf("%s: detectNAT = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bytes"
+	"sync"
+)
+
+// DefaultLogBufferLines is how many log lines a LogBuffer keeps by default
+const DefaultLogBufferLines = 1000
+
+// maxLogLineBytes caps one buffered line, so that a runaway message cannot
+// grow the buffer without bound
+const maxLogLineBytes = 4096
+
+// LogBuffer is an io.Writer keeping the last lines of log output in memory,
+// for debug bundles. Tee the log output into it and pass it to the client
+// with WithLogBuffer.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []string
+	next    int
+	full    bool
+	partial []byte
+}
+
+// NewLogBuffer returns a buffer keeping the last n lines
+func NewLogBuffer(n int) *LogBuffer {
+	if n <= 0 {
+		n = DefaultLogBufferLines
+	}
+	return &LogBuffer{lines: make([]string, n)}
+}
+
+// Write implements io.Writer, splitting p into lines. A line without its
+// newline yet is held until the rest arrives.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			b.partial = append(b.partial, data...)
+			if len(b.partial) > maxLogLineBytes {
+				b.partial = b.partial[:maxLogLineBytes]
+			}
+			break
+		}
+		line := append(b.partial, data[:i]...)
+		b.partial = nil
+		if len(line) > maxLogLineBytes {
+			line = line[:maxLogLineBytes]
+		}
+		b.add(string(line))
+		data = data[i+1:]
+	}
+	return len(p), nil
+}
+
+// add appends a line, overwriting the oldest once the buffer is full
+func (b *LogBuffer) add(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Lines returns the buffered lines, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+	lines := make([]string, 0, len(b.lines))
+	lines = append(lines, b.lines[b.next:]...)
+	return append(lines, b.lines[:b.next]...)
+}
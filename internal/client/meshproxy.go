@@ -0,0 +1,281 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// meshProxyDialTimeout bounds a connection attempt made for a proxy client
+const meshProxyDialTimeout = 30 * time.Second
+
+// meshProxy is a proxy listener into a netstack mesh
+type meshProxy struct {
+	name  string
+	ln    net.Listener
+	serve func(net.Listener)
+}
+
+// listenMeshProxies binds the SOCKS5 and HTTP proxy listeners of a netstack
+// client. They are served once the interface is up.
+func (c *Client) listenMeshProxies() error {
+	proxies := []meshProxy{
+		{name: "SOCKS5", serve: c.socks5Routine},
+		{name: "HTTP", serve: c.httpProxyRoutine},
+	}
+	for i, addr := range []string{c.config.SOCKS5Listen, c.config.HTTPProxyListen} {
+		if addr == "" {
+			continue
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			c.closeMeshProxies()
+			return fmt.Errorf("failed to listen for %s proxy: %w", proxies[i].name, err)
+		}
+		proxies[i].ln = ln
+		c.meshProxies = append(c.meshProxies, proxies[i])
+	}
+	return nil
+}
+
+// closeMeshProxies closes the proxy listeners, ending their routines
+func (c *Client) closeMeshProxies() {
+	for _, proxy := range c.meshProxies {
+		proxy.ln.Close()
+	}
+}
+
+// serveMeshProxies starts the proxy routines. They stop when the client
+// does, dropping the connections they carry along with the device.
+func (c *Client) serveMeshProxies() {
+	if len(c.meshProxies) == 0 {
+		return
+	}
+
+	for _, proxy := range c.meshProxies {
+		c.wg.Add(1)
+		go proxy.serve(proxy.ln)
+		c.logger.Info("Proxy into the mesh listening", "proxy", proxy.name, "addr", proxy.ln.Addr().String())
+	}
+
+	go func() {
+		<-c.ctx.Done()
+		c.closeMeshProxies()
+	}()
+}
+
+// socks5Routine accepts SOCKS5 clients until the listener is closed
+func (c *Client) socks5Routine(ln net.Listener) {
+	defer c.wg.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go c.serveSOCKS5(conn)
+	}
+}
+
+// SOCKS5 protocol values (RFC 1928) used by the proxy
+const (
+	socks5Version      = 5
+	socks5NoAuth       = 0
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 1
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+
+	socks5Succeeded          = 0
+	socks5HostUnreachable    = 4
+	socks5CommandUnsupported = 7
+	socks5AddrUnsupported    = 8
+)
+
+// serveSOCKS5 handles one SOCKS5 client. Only CONNECT without
+// authentication is supported: the listener is meant for localhost.
+func (c *Client) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(meshProxyDialTimeout))
+	r := bufio.NewReader(conn)
+
+	// Greeting: version, then the offered authentication methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil || header[0] != socks5Version {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	method := byte(socks5NoAcceptable)
+	for _, m := range methods {
+		if m == socks5NoAuth {
+			method = socks5NoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil || method != socks5NoAuth {
+		return
+	}
+
+	// Request: version, command, reserved, then the destination
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(r, request); err != nil || request[0] != socks5Version {
+		return
+	}
+	if request[1] != socks5Connect {
+		socks5Reply(conn, socks5CommandUnsupported)
+		return
+	}
+	var host string
+	switch request[3] {
+	case socks5AddrIPv4, socks5AddrIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socks5AddrIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		length, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		socks5Reply(conn, socks5AddrUnsupported)
+		return
+	}
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		return
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))))
+
+	ctx, cancel := context.WithTimeout(c.ctx, meshProxyDialTimeout)
+	remote, err := c.Dial(ctx, "tcp", address)
+	cancel()
+	if err != nil {
+		c.logger.Debug("SOCKS5 connection failed", "addr", address, "error", err)
+		socks5Reply(conn, socks5HostUnreachable)
+		return
+	}
+	defer remote.Close()
+	if err := socks5Reply(conn, socks5Succeeded); err != nil {
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+	pipe(conn, remote, r)
+}
+
+// socks5Reply answers a SOCKS5 request. The bound address is not
+// meaningful for a proxy into the mesh and is sent as zero.
+func socks5Reply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// httpProxyRoutine serves HTTP proxy clients until the listener is closed
+func (c *Client) httpProxyRoutine(ln net.Listener) {
+	defer c.wg.Done()
+
+	transport := &http.Transport{
+		DialContext:         c.Dial,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	defer transport.CloseIdleConnections()
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { c.serveHTTPProxy(w, r, transport) }),
+		ReadHeaderTimeout: meshProxyDialTimeout,
+	}
+	server.Serve(ln)
+}
+
+// serveHTTPProxy handles one HTTP proxy request: CONNECT is tunneled, any
+// other method is forwarded to the absolute URL it names
+func (c *Client) serveHTTPProxy(w http.ResponseWriter, r *http.Request, transport *http.Transport) {
+	if r.Method == http.MethodConnect {
+		c.tunnelHTTP(w, r)
+		return
+	}
+	if r.URL.Host == "" || r.URL.Scheme != "http" {
+		http.Error(w, "Only proxy requests for http:// URLs and CONNECT are supported", http.StatusBadRequest)
+		return
+	}
+
+	outbound := r.Clone(r.Context())
+	outbound.RequestURI = ""
+	outbound.Header.Del("Proxy-Connection")
+	outbound.Header.Del("Proxy-Authorization")
+	resp, err := transport.RoundTrip(outbound)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnelHTTP answers a CONNECT request by joining the client's connection
+// to the destination
+func (c *Client) tunnelHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), meshProxyDialTimeout)
+	remote, err := c.Dial(ctx, "tcp", r.Host)
+	cancel()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer remote.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+	pipe(conn, remote, buffered.Reader)
+}
+
+// pipe copies between a proxy client and the mesh until either side is
+// done. Bytes the client sent ahead, already buffered in r, go first.
+func pipe(conn, remote net.Conn, r io.Reader) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, r)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// NetCheckReport is how the client sees this host's network when choosing
+// the endpoints it advertises
+type NetCheckReport struct {
+	Interfaces []NetCheckInterface `json:"interfaces"`
+	Candidates []EndpointCandidate `json:"candidates"` // Best first
+	Advertised []string            `json:"advertised"` // The first is the primary endpoint
+	HasIPv6    bool                `json:"has_ipv6"`
+	UDP        *UDPStatus          `json:"udp,omitempty"`         // Probes of stun_servers, if any
+	RunningUDP *UDPStatus          `json:"running_udp,omitempty"` // The running client's last UDP check
+}
+
+// NetCheckInterface is a network interface of the host
+type NetCheckInterface struct {
+	Name      string   `json:"name"`
+	Link      string   `json:"link"` // wireguard.LinkWired, LinkWireless, ...
+	Up        bool     `json:"up"`
+	Preferred bool     `json:"preferred,omitempty"` // Listed in endpoint_interfaces
+	Skipped   string   `json:"skipped,omitempty"`   // Why its addresses are not candidates
+	Addresses []string `json:"addresses,omitempty"`
+}
+
+// NetCheck classifies the host's network interfaces and works out the
+// endpoints a client with cfg would advertise, best first. It probes
+// stun_servers to see whether UDP gets through, and adds the UDP check of
+// the client running with cfg, if one is. It changes nothing and needs no
+// running client.
+func NetCheck(ctx context.Context, cfg *config.ClientConfig) (*NetCheckReport, error) {
+	all, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	links := wireguard.LinkTypes(interfaceNames(all))
+	preferred := make(map[string]bool, len(cfg.EndpointInterfaces))
+	for _, name := range cfg.EndpointInterfaces {
+		preferred[name] = true
+	}
+
+	report := &NetCheckReport{Interfaces: []NetCheckInterface{}, Advertised: []string{}}
+	for _, iface := range all {
+		entry := NetCheckInterface{
+			Name:      iface.Name,
+			Link:      links[iface.Name],
+			Up:        iface.Flags&net.FlagUp != 0,
+			Preferred: preferred[iface.Name],
+		}
+		switch {
+		case iface.Flags&net.FlagLoopback != 0:
+			entry.Skipped = "loopback"
+		case !entry.Up:
+			entry.Skipped = "down"
+		case cfg.BindInterface != "" && iface.Name != cfg.BindInterface:
+			entry.Skipped = "not bind_interface"
+		}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				entry.Addresses = append(entry.Addresses, addr.String())
+			}
+		}
+		report.Interfaces = append(report.Interfaces, entry)
+	}
+
+	ifaces, err := endpointInterfaces(cfg)
+	if err != nil {
+		return nil, err
+	}
+	report.Candidates = endpointCandidates(cfg, ifaces, links)
+	if report.Candidates == nil {
+		report.Candidates = []EndpointCandidate{}
+	}
+	for _, candidate := range report.Candidates {
+		report.HasIPv6 = report.HasIPv6 || net.ParseIP(candidate.Address).To4() == nil
+	}
+	switch port := listenPortOf(cfg); {
+	case port != 0:
+		report.Advertised = append(report.Advertised, advertisedEndpoints(cfg, port, report.Candidates)...)
+	case cfg.AdvertiseEndpoint != "":
+		report.Advertised = []string{cfg.AdvertiseEndpoint}
+	}
+
+	if len(cfg.STUNServers) > 0 {
+		probes := runSTUNProbes(ctx, cfg.STUNServers, map[int]bool{listenPortOf(cfg): true})
+		verdict, detail := udpVerdict(probes)
+		now := time.Now()
+		report.UDP = &UDPStatus{Verdict: verdict, Detail: detail, CheckedAt: &now, Probes: probes, ListenPort: listenPortOf(cfg)}
+	}
+	var running struct {
+		UDP *UDPStatus `json:"udp"`
+	}
+	if err := Control(cfg, ControlRequest{Command: "status"}, &running); err == nil {
+		report.RunningUDP = running.UDP
+	}
+	return report, nil
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"time"
+)
+
+const (
+	// MaxHeartbeatBackoff caps the delay between heartbeats while the
+	// coordination server is unreachable. Failed heartbeats double the delay
+	// from the heartbeat interval up to this.
+	MaxHeartbeatBackoff = 5 * time.Minute
+
+	// DisconnectedReminderInterval is how often an ongoing outage of the
+	// coordination server is logged again
+	DisconnectedReminderInterval = 15 * time.Minute
+)
+
+// Control plane states in ControlPlaneStatus
+const (
+	ControlPlaneConnected    = "connected"
+	ControlPlaneDisconnected = "disconnected"
+)
+
+// ControlPlaneStatus reports in Status whether heartbeats reach the
+// coordination server
+type ControlPlaneStatus struct {
+	State             string `json:"state"`
+	DisconnectedSince string `json:"disconnected_since,omitempty"`
+	MissedHeartbeats  int    `json:"missed_heartbeats,omitempty"`
+	NextHeartbeat     string `json:"next_heartbeat,omitempty"`
+	LastError         string `json:"last_error,omitempty"`
+}
+
+// outage tracks the coordination server being unreachable. The data plane
+// carries on from the last verified peer list meanwhile. Nothing is queued
+// per heartbeat: the traffic counters are totals since the client started,
+// and the endpoints and address conflicts are current state, so the single
+// catch-up heartbeat sent when the server answers again carries everything
+// the missed ones would have, in bounded space. Guarded by c.mu.
+type outage struct {
+	since        time.Time // Zero while heartbeats get through
+	missed       int       // Heartbeats that failed since then
+	lastError    string
+	lastReminder time.Time
+	next         time.Time // When the next heartbeat is due
+}
+
+// heartbeatBackoff returns the delay before the next heartbeat after missed
+// consecutive failures
+func heartbeatBackoff(interval time.Duration, missed int) time.Duration {
+	limit := MaxHeartbeatBackoff
+	if interval > limit {
+		limit = interval
+	}
+	delay := interval
+	for i := 0; i < missed && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// heartbeatFailed records a failed heartbeat and returns the delay before
+// the next one. The start of an outage is logged, and then only a reminder
+// every DisconnectedReminderInterval.
+func (c *Client) heartbeatFailed(err error) time.Duration {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	started := c.outage.since.IsZero()
+	if started {
+		c.outage.since = now
+		c.outage.lastReminder = now
+	}
+	c.outage.missed++
+	c.outage.lastError = err.Error()
+	delay := heartbeatBackoff(c.heartbeatInterval, c.outage.missed)
+	c.outage.next = now.Add(delay)
+
+	switch {
+	case started:
+		c.logger.Warn("Coordination server unreachable; keeping the mesh up with the last peer list",
+			"error", err, "retry_in", delay)
+	case now.Sub(c.outage.lastReminder) >= DisconnectedReminderInterval:
+		c.outage.lastReminder = now
+		c.logger.Warn("Coordination server still unreachable",
+			"since", c.outage.since.Format(time.RFC3339), "missed_heartbeats", c.outage.missed,
+			"error", err, "retry_in", delay)
+	default:
+		c.logger.Debug("Heartbeat failed", "error", err, "retry_in", delay)
+	}
+	return delay
+}
+
+// heartbeatDelivered ends an outage, if there was one
+func (c *Client) heartbeatDelivered() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.outage.next = now.Add(c.heartbeatInterval)
+	if c.outage.since.IsZero() {
+		return
+	}
+	c.logger.Info("Coordination server reachable again",
+		"disconnected_for", now.Sub(c.outage.since).Round(time.Second), "missed_heartbeats", c.outage.missed)
+	c.outage = outage{next: c.outage.next}
+}
+
+// disconnected reports whether heartbeats are failing
+func (c *Client) disconnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.outage.since.IsZero()
+}
+
+// serverAnswered is called when another call to the server succeeds. During
+// an outage it sends the catch-up heartbeat at once rather than when the
+// backoff ends.
+func (c *Client) serverAnswered() {
+	if !c.disconnected() {
+		return
+	}
+	select {
+	case c.heartbeatNow <- struct{}{}:
+	default:
+	}
+}
+
+// controlPlaneStatusLocked reports the outage for Status. Callers must hold
+// c.mu.
+func (c *Client) controlPlaneStatusLocked() ControlPlaneStatus {
+	if c.outage.since.IsZero() {
+		return ControlPlaneStatus{State: ControlPlaneConnected}
+	}
+	return ControlPlaneStatus{
+		State:             ControlPlaneDisconnected,
+		DisconnectedSince: c.outage.since.Format(time.RFC3339),
+		MissedHeartbeats:  c.outage.missed,
+		NextHeartbeat:     c.outage.next.Format(time.RFC3339),
+		LastError:         c.outage.lastError,
+	}
+}
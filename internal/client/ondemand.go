@@ -0,0 +1,199 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// OnDemandIdleTimeout is how long the mesh may go unused before on-demand
+// activation removes the peers again, unless configured
+const OnDemandIdleTimeout = 5 * time.Minute
+
+// onDemandPollInterval is how often the interface counters are read. A
+// first packet to an armed mesh is dropped, so this bounds the delay an
+// application sees before its retry gets through.
+const onDemandPollInterval = time.Second
+
+// On-demand activation states
+const (
+	OnDemandArmed  = "armed"  // No mesh peers programmed; waiting for traffic
+	OnDemandActive = "active" // Peers programmed and traffic seen in the last poll
+	OnDemandIdle   = "idle"   // Peers programmed but no recent traffic; removed at the idle timeout
+)
+
+// Events for on-demand activation
+const (
+	EventActivated   EventType = "activated"
+	EventDeactivated EventType = "deactivated"
+)
+
+// OnDemandStatus reports on-demand activation in Status
+type OnDemandStatus struct {
+	State        string     `json:"state"`
+	Forced       string     `json:"forced,omitempty"` // Why the mesh is kept active regardless of traffic
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	IdleTimeout  int        `json:"idle_timeout"` // Seconds
+}
+
+// onDemand reports whether peers are only programmed while the mesh is used
+func (c *Client) onDemand() bool {
+	return c.config.ActivationMode == config.ActivationOnDemand
+}
+
+// dataPlaneActiveLocked reports whether mesh peers should be programmed.
+// Callers must hold c.mu.
+func (c *Client) dataPlaneActiveLocked() bool {
+	return !c.onDemand() || c.onDemandState != OnDemandArmed
+}
+
+// onDemandIdleTimeout returns how long the mesh may go unused
+func (c *Client) onDemandIdleTimeout() time.Duration {
+	if c.config.OnDemandIdleTimeout > 0 {
+		return time.Duration(c.config.OnDemandIdleTimeout) * time.Second
+	}
+	return OnDemandIdleTimeout
+}
+
+// onDemandForcedReason returns why the mesh must stay active whatever the
+// traffic, or "". An exit node carries other peers' traffic, and a client
+// routing through an exit node needs it for everything.
+func (c *Client) onDemandForcedReason(countersErr error) string {
+	switch {
+	case c.config.ExitNode:
+		return "exit node"
+	case c.config.UseExitNode != "":
+		return "using exit node " + c.config.UseExitNode
+	case countersErr != nil:
+		return fmt.Sprintf("interface counters unavailable: %v", countersErr)
+	}
+	return ""
+}
+
+// onDemandRoutine watches the interface's packet counters. Packets routed
+// to the mesh reach the interface even without peers, so a change in the
+// counters while armed means something wants the mesh: the peers are
+// programmed. Once the counters stop moving for the idle timeout, they are
+// removed again.
+func (c *Client) onDemandRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(onDemandPollInterval)
+	defer ticker.Stop()
+
+	var last uint64
+	valid := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+
+		c.mu.Lock()
+		name := c.interfaceName
+		c.mu.Unlock()
+		counters, err := wireguard.ReadTrafficCounters(name)
+		now := time.Now()
+
+		c.mu.Lock()
+		forced := c.onDemandForcedReason(err)
+		if forced != c.onDemandForced && forced != "" {
+			c.logger.Info("Keeping the mesh active", "reason", forced)
+		}
+		c.onDemandForced = forced
+
+		moved := err == nil && valid && counters.Total() != last
+		last, valid = counters.Total(), err == nil
+
+		previous := c.onDemandState
+		switch {
+		case forced != "" || moved:
+			c.lastActivity = now
+			c.onDemandState = OnDemandActive
+		case previous != OnDemandArmed && now.Sub(c.lastActivity) >= c.onDemandIdleTimeout():
+			c.onDemandState = OnDemandArmed
+		case previous == OnDemandActive:
+			c.onDemandState = OnDemandIdle
+		}
+		state := c.onDemandState
+		c.mu.Unlock()
+
+		switch {
+		case previous == OnDemandArmed && state != OnDemandArmed:
+			c.logger.Info("Mesh in use, programming peers")
+			c.emit(Event{Type: EventActivated})
+			c.reapplyPeers(true)
+		case previous != OnDemandArmed && state == OnDemandArmed:
+			c.logger.Info("Mesh idle, removing peers", "idle_timeout", c.onDemandIdleTimeout())
+			c.emit(Event{Type: EventDeactivated})
+			c.reapplyPeers(false)
+		}
+	}
+}
+
+// reapplyPeers programs the peer list again after the activation state
+// changed. Activating fetches a fresh list, falling back to the last one
+// when the server cannot be reached; deactivating needs no server.
+func (c *Client) reapplyPeers(fetch bool) {
+	if fetch {
+		err := c.fetchPeers(c.ctx, true)
+		if err == nil {
+			return
+		}
+		c.logger.Warn("Peer sync failed, using the last peer list", "error", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.peerList == nil {
+		return
+	}
+	if err := c.reconfigureNowLocked(); err != nil {
+		c.logger.Warn("Failed to apply peer list", "error", err)
+	}
+}
+
+// Activate brings up an armed mesh at once, as traffic would. With a query
+// it first checks that the peer (ID, hostname, public key or virtual IP) is
+// known. Without on-demand activation the mesh is always up.
+func (c *Client) Activate(query string) error {
+	if !c.onDemand() {
+		return nil
+	}
+
+	c.mu.Lock()
+	if query != "" {
+		if _, err := findPeer(c.peers, query); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	previous := c.onDemandState
+	c.lastActivity = time.Now()
+	c.onDemandState = OnDemandActive
+	c.mu.Unlock()
+
+	if previous == OnDemandArmed {
+		c.logger.Info("Mesh activated on request", "peer", query)
+		c.emit(Event{Type: EventActivated, PeerID: query})
+		c.reapplyPeers(true)
+	}
+	return nil
+}
+
+// onDemandStatus reports on-demand activation. Callers must hold c.mu.
+func (c *Client) onDemandStatusLocked() OnDemandStatus {
+	status := OnDemandStatus{
+		State:       c.onDemandState,
+		Forced:      c.onDemandForced,
+		IdleTimeout: int(c.onDemandIdleTimeout() / time.Second),
+	}
+	if !c.lastActivity.IsZero() {
+		lastActivity := c.lastActivity
+		status.LastActivity = &lastActivity
+	}
+	return status
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// Option customizes a Client created by NewClient
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used to talk to the coordination server,
+// e.g. to route through a proxy or pin TLS certificates
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the structured logger used by the client
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithLogBuffer sets the buffer holding the client's recent log output,
+// returned by the "debug" control command. The caller tees the log output
+// into it.
+func WithLogBuffer(logs *LogBuffer) Option {
+	return func(c *Client) {
+		c.logs = logs
+	}
+}
+
+// WithBackend sets the factory used to create the WireGuard device
+func WithBackend(factory wireguard.BackendFactory) Option {
+	return func(c *Client) {
+		c.newBackend = factory
+		c.customBackend = true
+	}
+}
+
+// WithSaveState registers a callback invoked whenever the client updates its
+// configuration (generated keys, assigned peer ID and IP). Without it the
+// client never persists anything on its own.
+func WithSaveState(saveState func(*config.ClientConfig) error) Option {
+	return func(c *Client) {
+		c.saveState = saveState
+	}
+}
+
+// WithAcceptServerKeyChange makes the client accept the server's keys if
+// they differ from the pinned ones, and pin the new keys instead
+func WithAcceptServerKeyChange() Option {
+	return func(c *Client) {
+		c.acceptKeyChange = true
+	}
+}
+
+// WithAuthKey sets the pre-auth key presented when registering, overriding
+// the one in the configuration. Unlike that one it is never saved.
+func WithAuthKey(authKey string) Option {
+	return func(c *Client) {
+		c.authKey = crypto.Redacted(authKey)
+	}
+}
+
+// WithIntervals sets how often the client sends heartbeats and syncs peers,
+// instead of HeartbeatInterval and PeerSyncInterval. Zero keeps the default.
+// Simulations use it to run the protocol loop in milliseconds.
+func WithIntervals(heartbeat, peerSync time.Duration) Option {
+	return func(c *Client) {
+		if heartbeat > 0 {
+			c.heartbeatInterval = heartbeat
+		}
+		if peerSync > 0 {
+			c.peerSyncInterval = peerSync
+		}
+	}
+}
+
+// WithReconfigureInterval sets the least time between two programmings of
+// the peer list on the interface, overriding reconfigure_interval. Zero
+// programs every list as it arrives.
+func WithReconfigureInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.reconfigureInterval = interval
+	}
+}
+
+// WithEndpointDetector sets the function that finds the endpoints the
+// client advertises, in place of scanning the local interfaces. It is
+// called before every registration and heartbeat.
+func WithEndpointDetector(detect func() ([]string, error)) Option {
+	return func(c *Client) {
+		c.detectEndpointsFn = detect
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"sort"
+)
+
+// Peer sources
+const (
+	PeerSourceServer = "server" // Handed out by the coordination server
+	PeerSourceLocal  = "local"  // From static_peers or extra_peers in the config
+)
+
+// PeerStatus describes a peer programmed on the interface
+type PeerStatus struct {
+	ID         string   `json:"id"`
+	Hostname   string   `json:"hostname,omitempty"`
+	PublicKey  string   `json:"public_key"`
+	VirtualIP  string   `json:"virtual_ip,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowed_ips"`
+	Source     string   `json:"source"`
+
+	// ResolvedEndpoint is the address last resolved for an endpoint given
+	// as a DNS name
+	ResolvedEndpoint string `json:"resolved_endpoint,omitempty"`
+}
+
+// Peers lists the peers currently programmed on the interface, sorted by
+// hostname
+func (c *Client) Peers() []PeerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	peers := make([]PeerStatus, 0, len(c.activePeers))
+	for publicKey, peer := range c.activePeers {
+		source := PeerSourceServer
+		if c.localPeers[publicKey] {
+			source = PeerSourceLocal
+		}
+		status := PeerStatus{
+			ID:         peer.ID,
+			Hostname:   peer.Hostname,
+			PublicKey:  publicKey,
+			VirtualIP:  peer.VirtualIP,
+			Endpoint:   peer.Endpoint,
+			AllowedIPs: peer.AllowedIPs,
+			Source:     source,
+		}
+		if entry, ok := c.dnsEndpoints[publicKey]; ok {
+			status.ResolvedEndpoint = entry.addr
+		}
+		peers = append(peers, status)
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].Hostname != peers[j].Hostname {
+			return peers[i].Hostname < peers[j].Hostname
+		}
+		return peers[i].ID < peers[j].ID
+	})
+	return peers
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Causes reported by Ping when a peer does not answer
+const (
+	CausePeerOffline   = "peer_offline"   // The server has marked the peer offline
+	CauseNotConfigured = "not_configured" // The peer is missing from the interface
+	CauseNoHandshake   = "no_handshake"   // No recent WireGuard handshake with the peer
+	CauseNoReply       = "no_reply"       // Handshake fine but no reply, e.g. a firewall
+)
+
+// PeerDiagnosis is what the running client knows about the path to a peer
+type PeerDiagnosis struct {
+	ID            string     `json:"id"`
+	Hostname      string     `json:"hostname"`
+	PublicKey     string     `json:"public_key"`
+	VirtualIP     string     `json:"virtual_ip"`
+	Online        bool       `json:"online"`             // As last reported by the server
+	Configured    bool       `json:"configured"`         // Present on the WireGuard interface
+	Endpoint      string     `json:"endpoint,omitempty"` // Where WireGuard sends the peer's packets
+	LastHandshake *time.Time `json:"last_handshake,omitempty"`
+	ReceiveBytes  int64      `json:"receive_bytes"`
+	TransmitBytes int64      `json:"transmit_bytes"`
+}
+
+// PingResult is the outcome of probing a peer through the mesh
+type PingResult struct {
+	Peer      PeerDiagnosis `json:"peer"`
+	Sent      int           `json:"sent"`
+	Received  int           `json:"received"`
+	RTTs      []float64     `json:"rtts_ms,omitempty"`
+	Reachable bool          `json:"reachable"`
+	Cause     string        `json:"cause,omitempty"`
+	Detail    string        `json:"detail,omitempty"`
+}
+
+// PingOptions controls the probes sent by Ping
+type PingOptions struct {
+	Count   int           // Probes to send (default 3)
+	Timeout time.Duration // Wait for each reply (default 2s)
+}
+
+// rttPattern finds the round trip time in the output of the system ping
+var rttPattern = regexp.MustCompile(`time[=<]\s*([0-9.]+)\s*ms`)
+
+// Ping probes a peer of the client running with cfg through the mesh. The
+// peer is looked up in the client's last synced peer list by ID, hostname,
+// virtual IP or public key, and probed with the system ping command. When
+// no probe is answered the result says why the peer is most likely
+// unreachable.
+func Ping(ctx context.Context, cfg *config.ClientConfig, target string, opts PingOptions) (*PingResult, error) {
+	if opts.Count <= 0 {
+		opts.Count = 3
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+
+	var peer PeerDiagnosis
+	if err := Control(cfg, diagnoseRequest(target), &peer); err != nil {
+		return nil, err
+	}
+
+	result := &PingResult{Peer: peer}
+	for i := 0; i < opts.Count; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		result.Sent++
+		rtt, ok := probe(ctx, peer.VirtualIP, opts.Timeout)
+		if ok {
+			result.Received++
+			result.RTTs = append(result.RTTs, rtt)
+		}
+	}
+	result.Reachable = result.Received > 0
+
+	// The probes themselves trigger a handshake, so look again
+	if err := Control(cfg, diagnoseRequest(peer.PublicKey), &result.Peer); err != nil {
+		return nil, err
+	}
+	if !result.Reachable {
+		result.Cause, result.Detail = diagnose(result.Peer, time.Now())
+	}
+
+	return result, nil
+}
+
+// diagnoseRequest asks the running client about a peer
+func diagnoseRequest(target string) ControlRequest {
+	return ControlRequest{Command: "diagnose", Args: map[string]string{"peer": target}}
+}
+
+// diagnose explains why a peer did not answer
+func diagnose(peer PeerDiagnosis, now time.Time) (string, string) {
+	switch {
+	case !peer.Online:
+		return CausePeerOffline, "the server has marked the peer offline; its client is not running or cannot reach the server"
+	case !peer.Configured:
+		return CauseNotConfigured, "the peer is not on the WireGuard interface; it may be held back by an address conflict, or the peer list has not synced yet"
+	case peer.LastHandshake == nil || now.Sub(*peer.LastHandshake) >= HandshakeTimeout:
+		last := "never"
+		if peer.LastHandshake != nil {
+			last = now.Sub(*peer.LastHandshake).Round(time.Second).String() + " ago"
+		}
+		endpoint := peer.Endpoint
+		if endpoint == "" {
+			endpoint = "none known"
+		}
+		return CauseNoHandshake, fmt.Sprintf("no WireGuard handshake completed (last handshake %s, endpoint %s); UDP between the peers is likely blocked or the endpoint is wrong", last, endpoint)
+	default:
+		return CauseNoReply, fmt.Sprintf("the WireGuard handshake succeeded %s ago but the peer did not reply; a firewall on the peer is likely dropping the probes", now.Sub(*peer.LastHandshake).Round(time.Second))
+	}
+}
+
+// probe sends one ICMP echo with the system ping command, returning the
+// round trip time in milliseconds when answered
+func probe(ctx context.Context, ip string, timeout time.Duration) (float64, bool) {
+	if net.ParseIP(ip) == nil {
+		return 0, false
+	}
+
+	var args []string
+	switch runtime.GOOS {
+	case "windows":
+		args = []string{"-n", "1", "-w", strconv.FormatInt(timeout.Milliseconds(), 10), ip}
+	case "linux":
+		args = []string{"-c", "1", "-W", strconv.Itoa(int((timeout + time.Second - 1) / time.Second)), ip}
+	default:
+		// macOS and FreeBSD take the wait in milliseconds
+		args = []string{"-c", "1", "-W", strconv.FormatInt(timeout.Milliseconds(), 10), ip}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+time.Second)
+	defer cancel()
+	start := time.Now()
+	output, err := exec.CommandContext(ctx, "ping", args...).Output()
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+
+	// Windows ping succeeds on "destination unreachable" replies from a
+	// router; only an echo reply carries a TTL
+	if err != nil || (runtime.GOOS == "windows" && !strings.Contains(string(output), "TTL=")) {
+		return 0, false
+	}
+	if match := rttPattern.FindSubmatch(output); match != nil {
+		if rtt, err := strconv.ParseFloat(string(match[1]), 64); err == nil {
+			return rtt, true
+		}
+	}
+	return elapsed, true
+}
+
+// DiagnosePeer reports what the client knows about the path to a peer,
+// found by ID, hostname, virtual IP or public key in the last synced peer
+// list. An armed on-demand mesh is activated first, since the caller is
+// about to send traffic to the peer.
+func (c *Client) DiagnosePeer(query string) (*PeerDiagnosis, error) {
+	if err := c.Activate(query); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	peer, err := findPeer(c.peers, query)
+	if err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	_, configured := c.activePeers[peer.PublicKey]
+	backend := c.wgInterface
+	c.mu.Unlock()
+
+	diagnosis := &PeerDiagnosis{
+		ID:         peer.ID,
+		Hostname:   peer.Hostname,
+		PublicKey:  peer.PublicKey,
+		VirtualIP:  peer.VirtualIP,
+		Online:     peer.Online,
+		Configured: configured,
+		Endpoint:   peer.Endpoint,
+	}
+
+	if backend != nil {
+		if stats, err := backend.GetStats(); err == nil {
+			if sample, ok := peerSamples(stats)[peer.PublicKey]; ok {
+				if sample.endpoint != "" {
+					diagnosis.Endpoint = sample.endpoint
+				}
+				if !sample.lastHandshake.IsZero() {
+					lastHandshake := sample.lastHandshake
+					diagnosis.LastHandshake = &lastHandshake
+				}
+				diagnosis.ReceiveBytes = sample.receive
+				diagnosis.TransmitBytes = sample.transmit
+			}
+		}
+	}
+
+	return diagnosis, nil
+}
+
+// findPeer looks a peer up by ID, virtual IP, public key or hostname.
+// Hostnames are matched case-insensitively and must be unique.
+func findPeer(peers []protocol.PeerInfo, query string) (protocol.PeerInfo, error) {
+	if query == "" {
+		return protocol.PeerInfo{}, errors.New("no peer given")
+	}
+
+	var byHostname []protocol.PeerInfo
+	for _, peer := range peers {
+		if peer.ID == query || peer.VirtualIP == query || peer.PublicKey == query {
+			return peer, nil
+		}
+		if strings.EqualFold(peer.Hostname, query) {
+			byHostname = append(byHostname, peer)
+		}
+	}
+
+	switch len(byHostname) {
+	case 0:
+		return protocol.PeerInfo{}, fmt.Errorf("no peer %q in the peer list", query)
+	case 1:
+		return byHostname[0], nil
+	default:
+		ids := make([]string, len(byHostname))
+		for i, peer := range byHostname {
+			ids[i] = peer.ID
+		}
+		return protocol.PeerInfo{}, fmt.Errorf("hostname %q is ambiguous; use one of the peer IDs %s", query, strings.Join(ids, ", "))
+	}
+}
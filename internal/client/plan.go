@@ -0,0 +1,328 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Where the peers of a Plan come from
+const (
+	PlanPeersServer  = "server"  // Fetched from the server just now
+	PlanPeersRunning = "running" // The last list the running client verified
+	PlanPeersConfig  = "config"  // Static and extra peers from the config
+	PlanPeersNone    = "none"    // No peer list could be had
+)
+
+// Plan is what the client would do to the system to bring the mesh up
+// from scratch: the interface it creates, the routes, DNS and firewall
+// changes that come with it, and the peers it programs. The backend
+// operations are recorded by a wireguard.Recorder, so working one out runs
+// no platform commands.
+type Plan struct {
+	Mode       string                `json:"mode"`
+	PeerSource string                `json:"peer_source"`
+	Interface  PlanInterface         `json:"interface"`
+	Routes     []PlanRoute           `json:"routes"`
+	DNS        []string              `json:"dns"` // Resolver changes, made on Windows only
+	Firewall   []PlanFirewallRule    `json:"firewall"`
+	Peers      []PlanPeer            `json:"peers"`
+	Operations []wireguard.Operation `json:"operations"`
+	Preflight  []PlanCheck           `json:"preflight,omitempty"` // Dry runs only
+	Warnings   []string              `json:"warnings,omitempty"`
+}
+
+// PlanInterface is the WireGuard interface of a Plan
+type PlanInterface struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	ListenPort int    `json:"listen_port"`
+	MTU        int    `json:"mtu"`
+	Backend    string `json:"backend"` // config.BackendOS or config.BackendNetstack
+}
+
+// PlanRoute is a route that comes with the interface
+type PlanRoute struct {
+	Destination string `json:"destination"`
+	Device      string `json:"device"`
+	Table       int    `json:"table,omitempty"` // Policy routing table, 0 for the main one
+	Note        string `json:"note,omitempty"`
+}
+
+// PlanFirewallRule is a host firewall change
+type PlanFirewallRule struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// PlanPeer is a peer the plan programs
+type PlanPeer struct {
+	ID         string   `json:"id"`
+	Hostname   string   `json:"hostname"`
+	PublicKey  string   `json:"public_key"`
+	VirtualIP  string   `json:"virtual_ip,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowed_ips"`
+	Source     string   `json:"source"` // PeerSourceServer or PeerSourceLocal
+}
+
+// PlanCheck is the outcome of a pre-flight check in a dry run
+type PlanCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+// DryRun works out what starting a client with cfg would do, without
+// changing anything: no key is stored, nothing is registered, and no
+// platform command is run. The configuration is validated and the
+// pre-flight checks run. A client that registered before fetches its peer
+// list with its saved peer ID; the server must still know it. A client
+// that never registered gets a plan without peers.
+func DryRun(ctx context.Context, cfg *config.ClientConfig, opts ...Option) (*Plan, error) {
+	opts = append([]Option{func(c *Client) { c.dryRun = true }}, opts...)
+	c, err := NewClient(cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx = ctx
+
+	var warnings []string
+	peerList := &protocol.PeerListResponse{}
+	source := PlanPeersNone
+	switch {
+	case cfg.Static():
+		if err := c.useStaticAddress(); err != nil {
+			return nil, err
+		}
+		source = PlanPeersConfig
+	case cfg.PeerID == "" || cfg.AssignedIP == "":
+		warnings = append(warnings, "not registered yet: the server assigns the address and peers on the first start")
+	default:
+		c.peerID = cfg.PeerID
+		c.assignedIP = cfg.AssignedIP
+		list, err := c.fetchPeerList(ctx)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("no peer list: %v", err))
+			break
+		}
+		peerList = list
+		source = PlanPeersServer
+		if cfg.AddressMode == config.AddressModeNetwork {
+			warnings = append(warnings, "the mesh prefix length is only known after registering, so the address is shown as a /32")
+		}
+	}
+
+	plan := c.plan(peerList, source)
+	if name := plan.Interface.Name; !c.customBackend && runtime.GOOS != "darwin" && interfaceExists(name) {
+		warnings = append(warnings, fmt.Sprintf("interface %s already exists; unless this client runs it, the next free name is used", name))
+	}
+	plan.Warnings = append(warnings, plan.Warnings...)
+	if !c.customBackend {
+		for _, check := range Preflight(cfg, "").Checks {
+			result := PlanCheck{Name: check.Name, OK: check.Passed()}
+			if check.Err != nil {
+				result.Error = check.Err.Error()
+				result.Hint = check.Hint
+			}
+			plan.Preflight = append(plan.Preflight, result)
+		}
+	}
+	return plan, nil
+}
+
+// fetchPeerList fetches and verifies the peer list without programming it
+func (c *Client) fetchPeerList(ctx context.Context) (*protocol.PeerListResponse, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/peers", url.Values{"peer_id": {c.peerID}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	var peerList protocol.PeerListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&peerList); err != nil {
+		return nil, fmt.Errorf("failed to decode peer list: %w", err)
+	}
+	if err := c.verifyPeerList(&peerList, time.Now()); err != nil {
+		return nil, fmt.Errorf("rejected peer list: %w", err)
+	}
+	return &peerList, nil
+}
+
+// Plan works out what the running client would do if it brought the mesh
+// up from scratch now, with the last peer list it verified, including one
+// held for the maintenance window. Nothing is changed.
+func (c *Client) Plan() (*Plan, error) {
+	c.mu.Lock()
+	peerList := c.peerList
+	c.mu.Unlock()
+
+	source := PlanPeersRunning
+	switch {
+	case c.config.Static():
+		source = PlanPeersConfig
+	case peerList == nil:
+		source = PlanPeersNone
+	}
+	if peerList == nil {
+		peerList = &protocol.PeerListResponse{}
+	}
+	return c.plan(peerList, source), nil
+}
+
+// plan records on a wireguard.Recorder what setting up the interface and
+// programming peerList would do, and describes the changes that come with it
+func (c *Client) plan(peerList *protocol.PeerListResponse, source string) *Plan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Unknown until the server assigns one
+	var address string
+	switch {
+	case c.config.Static():
+		address = c.config.Address
+	case c.assignedIP != "":
+		address = interfaceAddress(c.assignedIP, c.networkCIDR, c.config.AddressMode)
+	}
+	wgConfig := c.interfaceConfig(address)
+	if c.interfaceName != "" {
+		// The running interface's actual name
+		wgConfig.InterfaceName = c.interfaceName
+	}
+	recorder := wireguard.NewRecorder(wgConfig)
+	recorder.Create()
+	recorder.Configure()
+
+	mode := c.config.Mode
+	if mode == "" {
+		mode = config.ModeManaged
+	}
+	backendName := c.config.Backend
+	if backendName == "" {
+		backendName = config.BackendOS
+	}
+	// Only the Windows adapter takes the MTU and resolvers
+	windows := runtime.GOOS == "windows" && !c.config.Netstack()
+	mtu := wireguard.DefaultMTU
+	if windows && wgConfig.MTU != 0 {
+		mtu = wgConfig.MTU
+	}
+	plan := &Plan{
+		Mode:       mode,
+		PeerSource: source,
+		Interface: PlanInterface{
+			Name:       wgConfig.InterfaceName,
+			Address:    address,
+			ListenPort: wgConfig.ListenPort,
+			MTU:        mtu,
+			Backend:    backendName,
+		},
+		Routes:   []PlanRoute{},
+		DNS:      []string{},
+		Firewall: []PlanFirewallRule{},
+		Peers:    []PlanPeer{},
+	}
+
+	// The peers, as applyPeerListLocked or applyStaticPeers would program them
+	var peerConfigs []wireguard.PeerConfig
+	addPeer := func(peer protocol.PeerInfo, peerConfig wireguard.PeerConfig, source string) {
+		peerConfigs = append(peerConfigs, peerConfig)
+		plan.Peers = append(plan.Peers, PlanPeer{
+			ID:         peer.ID,
+			Hostname:   peer.Hostname,
+			PublicKey:  peer.PublicKey,
+			VirtualIP:  peer.VirtualIP,
+			Endpoint:   peerConfig.Endpoint,
+			AllowedIPs: append([]string{}, peerConfig.AllowedIPs...),
+			Source:     source,
+		})
+	}
+	if c.config.Static() {
+		for i, peer := range c.config.StaticPeers {
+			peerConfig := staticPeerConfig(peer)
+			peerConfig.Endpoint = c.peerEndpoint(peer.PublicKey, peer.Endpoint)
+			addPeer(staticPeerInfo(peer, fmt.Sprintf("static-%d", i+1)), peerConfig, PeerSourceLocal)
+		}
+	} else {
+		conflicts := findAddressConflicts(c.peerID, c.assignedIP, peerList.Peers)
+		for _, conflict := range conflicts {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("virtual IP %s is held by %v, which are left out", conflict.IP, conflict.PeerIDs))
+		}
+		var managed []protocol.PeerInfo
+		if c.dataPlaneActiveLocked() {
+			managed = c.programmablePeersLocked(peerList, conflicts)
+		} else {
+			plan.Warnings = append(plan.Warnings, "on-demand activation: mesh peers are programmed once the mesh is used")
+		}
+		for i, peerConfig := range c.peerConfigsLocked(managed) {
+			addPeer(managed[i], peerConfig, PeerSourceServer)
+		}
+		for _, extra := range c.extraPeersLocked(managed) {
+			addPeer(extra.info, extra.config, PeerSourceLocal)
+		}
+	}
+	recorder.AddPeers(peerConfigs)
+	plan.Operations = recorder.Operations()
+	sort.SliceStable(plan.Peers, func(i, j int) bool { return plan.Peers[i].Hostname < plan.Peers[j].Hostname })
+
+	if c.config.Netstack() {
+		// Nothing but the client's own proxies reaches a netstack mesh
+		return plan
+	}
+
+	// The address's prefix gives the interface a connected route; a /32
+	// gives it none
+	if prefix := parsePrefix(address); prefix != nil {
+		if ones, bits := prefix.Mask.Size(); ones < bits {
+			plan.Routes = append(plan.Routes, PlanRoute{Destination: prefix.String(), Device: wgConfig.InterfaceName, Note: "connected route of the interface address"})
+		}
+	}
+	if c.config.BindInterface != "" && runtime.GOOS == "linux" {
+		mark := c.config.FirewallMark
+		if mark == 0 {
+			mark = wireguard.DefaultFirewallMark
+		}
+		plan.Routes = append(plan.Routes, PlanRoute{
+			Destination: "default",
+			Device:      c.config.BindInterface,
+			Table:       mark,
+			Note:        fmt.Sprintf("for WireGuard's own packets, marked %d", mark),
+		})
+	}
+
+	if windows {
+		for _, server := range wgConfig.DNSServers {
+			plan.DNS = append(plan.DNS, fmt.Sprintf("resolve through %s on %s", server, wgConfig.InterfaceName))
+		}
+	}
+
+	if c.config.ManageFirewall && wgConfig.ListenPort != 0 {
+		plan.Firewall = append(plan.Firewall, PlanFirewallRule{
+			Name:   wireguard.FirewallRuleName(wgConfig.InterfaceName, wgConfig.ListenPort),
+			Action: fmt.Sprintf("allow inbound UDP port %d", wgConfig.ListenPort),
+		})
+	}
+	if c.config.EnforceACLs {
+		sources := append([]string(nil), peerList.AllowedSources...)
+		sort.Strings(sources)
+		action := "drop everything arriving on the interface but replies"
+		if len(sources) > 0 {
+			action = fmt.Sprintf("allow only %v in on the interface", sources)
+		}
+		plan.Firewall = append(plan.Firewall, PlanFirewallRule{Name: "acl", Action: action})
+	}
+	return plan
+}
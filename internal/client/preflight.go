@@ -0,0 +1,153 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// PreflightReport collects the results of the pre-flight checks
+type PreflightReport struct {
+	Checks []wireguard.PreflightCheck
+}
+
+// Preflight checks that this machine can run the client with cfg: privileges,
+// external tools and kernel support for the selected backend, and that the
+// listen port is free. configPath, when set, is checked for safe permissions.
+func Preflight(cfg *config.ClientConfig, configPath string) *PreflightReport {
+	report := &PreflightReport{}
+
+	// A netstack client needs neither privileges nor kernel support
+	if !cfg.Netstack() {
+		report.Checks = append(report.Checks, wireguard.Preflight(wireguard.Config{
+			InterfaceName:        cfg.InterfaceName,
+			ListenPort:           listenPortOf(cfg),
+			UseSystemWireGuardGo: cfg.UseSystemWireGuardGo,
+			WireGuardGoPath:      cfg.WireGuardGoPath,
+			WindowsDriver:        cfg.WindowsDriver,
+		})...)
+	}
+	report.Checks = append(report.Checks, udpPortCheck(listenPortOf(cfg)))
+	if cfg.BindInterface != "" {
+		report.Checks = append(report.Checks, bindInterfaceCheck(cfg.BindInterface))
+	}
+	if configPath != "" {
+		report.Checks = append(report.Checks, configPermissionsCheck(configPath))
+	}
+
+	return report
+}
+
+// Failed reports whether any check failed
+func (r *PreflightReport) Failed() bool {
+	for _, check := range r.Checks {
+		if !check.Passed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns an error carrying the whole report if any check failed
+func (r *PreflightReport) Err() error {
+	if !r.Failed() {
+		return nil
+	}
+	return fmt.Errorf("pre-flight checks failed:\n%s", r)
+}
+
+// String renders one pass/fail line per check, with a remediation hint
+// below each failure
+func (r *PreflightReport) String() string {
+	var b strings.Builder
+	for _, check := range r.Checks {
+		switch {
+		case !check.Passed():
+			fmt.Fprintf(&b, "[FAIL] %s: %v\n", check.Name, check.Err)
+			if check.Hint != "" {
+				fmt.Fprintf(&b, "       hint: %s\n", check.Hint)
+			}
+		case check.Detail != "":
+			fmt.Fprintf(&b, "[PASS] %s: %s\n", check.Name, check.Detail)
+		default:
+			fmt.Fprintf(&b, "[PASS] %s\n", check.Name)
+		}
+	}
+	return b.String()
+}
+
+// udpPortCheck verifies that the WireGuard listen port can be bound
+func udpPortCheck(port int) wireguard.PreflightCheck {
+	check := wireguard.PreflightCheck{Name: "UDP port"}
+	if port == 0 {
+		check.Detail = "random port"
+		return check
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		check.Err = fmt.Errorf("cannot bind UDP port %d: %w", port, err)
+		check.Hint = "stop whatever is using the port (e.g. another WireGuard interface) or change listen_port"
+		return check
+	}
+	conn.Close()
+
+	check.Detail = fmt.Sprintf("%d", port)
+	return check
+}
+
+// bindInterfaceCheck verifies that the interface WireGuard traffic is bound
+// to exists and is up
+func bindInterfaceCheck(name string) wireguard.PreflightCheck {
+	check := wireguard.PreflightCheck{Name: "bind interface"}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		check.Err = fmt.Errorf("interface %s not found: %w", name, err)
+		check.Hint = "check bind_interface against the host's interfaces"
+		return check
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		check.Err = fmt.Errorf("interface %s is down", name)
+		check.Hint = "bring the interface up or change bind_interface"
+		return check
+	}
+
+	check.Detail = name
+	if runtime.GOOS != "linux" {
+		check.Detail += "; only its addresses are advertised on " + runtime.GOOS
+	}
+	return check
+}
+
+// configPermissionsCheck verifies that the config file, which holds the
+// private key, is not readable by other users
+func configPermissionsCheck(path string) wireguard.PreflightCheck {
+	check := wireguard.PreflightCheck{Name: "config permissions"}
+	if runtime.GOOS == "windows" {
+		check.Detail = "not checked on Windows"
+		return check
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		check.Detail = "will be created"
+		return check
+	}
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		check.Err = fmt.Errorf("%s is accessible by other users (mode %04o)", path, perm)
+		check.Hint = fmt.Sprintf("it contains the private key; run: chmod 600 %s", path)
+		return check
+	}
+	check.Detail = path
+	return check
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// ReconfigureInterval is the least time between two programmings of the
+// peer list on the interface, unless configured
+const ReconfigureInterval = 2 * time.Second
+
+// reconfigureInterval returns the configured least time between
+// reconfigurations: reconfigure_interval seconds, ReconfigureInterval by
+// default, or none when negative
+func reconfigureInterval(cfg *config.ClientConfig) time.Duration {
+	switch {
+	case cfg.ReconfigureInterval > 0:
+		return time.Duration(cfg.ReconfigureInterval) * time.Second
+	case cfg.ReconfigureInterval < 0:
+		return 0
+	}
+	return ReconfigureInterval
+}
+
+// reconfigureLocked programs the last verified peer list on the interface.
+// Every device update disturbs the device, so while a peer flaps the
+// interface is reprogrammed at most once per reconfigure interval: a list
+// arriving sooner is only recorded, and the latest one is programmed when
+// the interval ends. An urgent change, such as a peer removed from the
+// network, is programmed at once.
+//
+// Outside the maintenance window lists are held until it opens. Only the
+// removals of an urgent change are programmed, so that routine changes
+// arriving with it keep waiting. A new interface gets its peers at once.
+// Callers must hold c.mu.
+func (c *Client) reconfigureLocked(urgent bool) error {
+	now := time.Now()
+	if c.maintenance != nil && !c.lastReconfigure.IsZero() && !c.maintenance.Open(now) {
+		if urgent {
+			c.dropUnlistedPeersLocked()
+		}
+		c.deferLocked(now)
+		return nil
+	}
+
+	wait := c.reconfigureInterval - now.Sub(c.lastReconfigure)
+	if urgent || wait <= 0 {
+		return c.reconfigureNowLocked()
+	}
+
+	c.coalescedReconfigs++
+	if c.reconfigureTimer == nil {
+		c.reconfigureTimer = time.AfterFunc(wait, c.reconfigureDeferred)
+	}
+	return nil
+}
+
+// reconfigureNowLocked programs the last verified peer list, dropping any
+// deferred reconfiguration. Callers must hold c.mu.
+func (c *Client) reconfigureNowLocked() error {
+	if c.reconfigureTimer != nil {
+		c.reconfigureTimer.Stop()
+		c.reconfigureTimer = nil
+	}
+	if !c.deferredSince.IsZero() {
+		c.logger.Info("Applying peer list changes held for the maintenance window",
+			"lists", c.deferredLists, "held", time.Since(c.deferredSince).Round(time.Second))
+		c.deferredSince = time.Time{}
+		c.deferredLists = 0
+	}
+	c.lastReconfigure = time.Now()
+	c.reconfigurations++
+	return c.applyPeerListLocked(c.peerList)
+}
+
+// reconfigureDeferred programs the peer list a reconfiguration was deferred
+// for, once the interval has passed or the maintenance window opened. A
+// list coalesced just before the window closed waits for the next one.
+func (c *Client) reconfigureDeferred() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reconfigureTimer = nil
+	if c.peerList == nil || c.ctx.Err() != nil {
+		return
+	}
+	if now := time.Now(); c.maintenance != nil && !c.maintenance.Open(now) {
+		c.reconfigureTimer = time.AfterFunc(c.maintenance.Next(now).Sub(now), c.reconfigureDeferred)
+		return
+	}
+	if err := c.reconfigureNowLocked(); err != nil {
+		c.logger.Warn("Failed to apply peer list", "error", err)
+	}
+}
+
+// deferLocked holds the last peer list until the maintenance window opens.
+// Callers must hold c.mu.
+func (c *Client) deferLocked(now time.Time) {
+	opens := c.maintenance.Next(now)
+	if c.deferredSince.IsZero() {
+		c.deferredSince = now
+		c.logger.Info("Holding peer list changes for the maintenance window", "window", c.maintenance.String(), "opens", opens)
+	}
+	c.deferredLists++
+	if c.reconfigureTimer == nil {
+		c.reconfigureTimer = time.AfterFunc(opens.Sub(now), c.reconfigureDeferred)
+	}
+}
+
+// dropUnlistedPeersLocked removes the programmed peers missing from the
+// last peer list, leaving the rest of the interface as it is, and stops
+// accepting traffic from their addresses. Callers must hold c.mu.
+func (c *Client) dropUnlistedPeersLocked() {
+	if c.wgInterface == nil {
+		return
+	}
+	listed := make(map[string]bool, len(c.peerList.Peers))
+	for _, peer := range c.peerList.Peers {
+		listed[peer.PublicKey] = true
+	}
+
+	dropped := make(map[string]bool)
+	for publicKey, peer := range c.activePeers {
+		if !listed[publicKey] && !c.localPeers[publicKey] && c.dropPeerLocked(publicKey, peer) {
+			for _, prefix := range peer.AllowedIPs {
+				dropped[prefix] = true
+			}
+		}
+	}
+	if len(dropped) > 0 && c.aclRules != nil {
+		sources := make([]string, 0, len(c.aclSources))
+		for _, source := range c.aclSources {
+			if !dropped[source] {
+				sources = append(sources, source)
+			}
+		}
+		c.enforceACL(sources)
+	}
+}
+
+// ApplyNow programs the last peer list at once, including changes held for
+// the maintenance window
+func (c *Client) ApplyNow() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.peerList == nil {
+		return fmt.Errorf("no peer list has been synced yet")
+	}
+	return c.reconfigureNowLocked()
+}
+
+// urgentPeerListLocked reports whether a new peer list must be programmed
+// without waiting out the reconfigure interval: it drops a programmed peer
+// altogether, as when the peer is suspended or removed, rather than only
+// marking it offline. Callers must hold c.mu.
+func (c *Client) urgentPeerListLocked(peerList *protocol.PeerListResponse) bool {
+	listed := make(map[string]bool, len(peerList.Peers))
+	for _, peer := range peerList.Peers {
+		listed[peer.PublicKey] = true
+	}
+	for publicKey := range c.activePeers {
+		if !listed[publicKey] && !c.localPeers[publicKey] {
+			return true
+		}
+	}
+	return false
+}
+
+// ReconfigureStatus reports in Status how often the interface was
+// reprogrammed and how many peer lists were coalesced into a later
+// reconfiguration
+type ReconfigureStatus struct {
+	Interval         string             `json:"interval"`
+	Reconfigurations uint64             `json:"reconfigurations"`
+	Coalesced        uint64             `json:"coalesced"`
+	Pending          bool               `json:"pending"`
+	Last             *time.Time         `json:"last,omitempty"`
+	Maintenance      *MaintenanceStatus `json:"maintenance,omitempty"` // With maintenance_window
+}
+
+// MaintenanceStatus reports the maintenance window and the peer lists held
+// for it
+type MaintenanceStatus struct {
+	Window        string     `json:"window"`
+	Open          bool       `json:"open"`
+	Next          time.Time  `json:"next"`
+	Deferred      uint64     `json:"deferred"`
+	DeferredSince *time.Time `json:"deferred_since,omitempty"`
+}
+
+// reconfigureStatusLocked reports the reconfigurations for Status. Callers
+// must hold c.mu.
+func (c *Client) reconfigureStatusLocked() ReconfigureStatus {
+	status := ReconfigureStatus{
+		Interval:         c.reconfigureInterval.String(),
+		Reconfigurations: c.reconfigurations,
+		Coalesced:        c.coalescedReconfigs,
+		Pending:          c.reconfigureTimer != nil,
+	}
+	if !c.lastReconfigure.IsZero() {
+		last := c.lastReconfigure
+		status.Last = &last
+	}
+	if c.maintenance != nil {
+		now := time.Now()
+		status.Maintenance = &MaintenanceStatus{
+			Window:   c.maintenance.String(),
+			Open:     c.maintenance.Open(now),
+			Next:     c.maintenance.Next(now),
+			Deferred: c.deferredLists,
+		}
+		if !c.deferredSince.IsZero() {
+			since := c.deferredSince
+			status.Maintenance.DeferredSince = &since
+		}
+	}
+	return status
+}
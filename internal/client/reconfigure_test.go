@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/testutil"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// addPeersCalls counts the device updates programming peer lists
+func addPeersCalls(backend *testutil.FakeBackend) int {
+	n := 0
+	for _, call := range backend.Calls() {
+		if strings.HasPrefix(call, "AddPeers ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestReconfigureCoalescesBurst(t *testing.T) {
+	const interval = 200 * time.Millisecond
+	c, backend := newStaticClient(t)
+	c.reconfigureInterval = interval
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { c.Stop() })
+
+	// A flapping peer: a hundred lists, each moving it to another endpoint
+	const updates = 100
+	before := addPeersCalls(backend)
+	for i := 0; i < updates; i++ {
+		c.mu.Lock()
+		c.peerList = &protocol.PeerListResponse{
+			Version: uint64(i + 1),
+			Peers: []protocol.PeerInfo{{
+				ID:         "peer-flapping",
+				PublicKey:  "flapping",
+				VirtualIP:  "10.200.0.3",
+				Endpoint:   fmt.Sprintf("192.0.2.1:%d", 40000+i),
+				AllowedIPs: []string{"10.200.0.3/32"},
+				Online:     true,
+			}},
+		}
+		err := c.reconfigureLocked(false)
+		c.mu.Unlock()
+		if err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+
+	// At most the first list at once and the last when the interval ends
+	deadline := time.Now().Add(10 * interval)
+	for time.Now().Before(deadline) && backend.Peers()["flapping"].Endpoint != "192.0.2.1:40099" {
+		time.Sleep(interval / 10)
+	}
+	if got := backend.Peers()["flapping"].Endpoint; got != "192.0.2.1:40099" {
+		t.Fatalf("programmed endpoint %q, want the last one", got)
+	}
+	if calls := addPeersCalls(backend) - before; calls > 2 {
+		t.Errorf("%d updates programmed %d times, want at most 2", updates, calls)
+	}
+
+	c.mu.Lock()
+	status := c.reconfigureStatusLocked()
+	c.mu.Unlock()
+	if status.Coalesced < updates-2 || status.Pending {
+		t.Errorf("status: coalesced %d pending %v, want at least %d coalesced and nothing pending",
+			status.Coalesced, status.Pending, updates-2)
+	}
+}
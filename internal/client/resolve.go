@@ -0,0 +1,140 @@
+package client
+
+import (
+	"net"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// EndpointResolveInterval is how often peer endpoints given as DNS names are
+// looked up again, unless configured
+const EndpointResolveInterval = 5 * time.Minute
+
+// dnsEndpoint is a peer endpoint given as a DNS name, such as a dynamic DNS
+// record, along with the address it last resolved to
+type dnsEndpoint struct {
+	host string // As configured or advertised, e.g. "home.example.com:51820"
+	addr string // Last good resolution; empty until one succeeds
+}
+
+// resolveEndpoint looks up a host:port endpoint
+func resolveEndpoint(endpoint string) (string, error) {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+// peerEndpoint returns the address to program for a peer's endpoint. IP
+// endpoints are used as they are. A DNS name is resolved the first time it
+// is seen and remembered, so that later syncs reuse the address and only
+// resolveRoutine looks it up again. When a lookup fails the last good
+// address is kept; with none the peer is programmed without an endpoint
+// until a lookup succeeds. Callers must hold c.mu.
+func (c *Client) peerEndpoint(publicKey, endpoint string) string {
+	if !protocol.IsHostnameEndpoint(endpoint) {
+		delete(c.dnsEndpoints, publicKey)
+		return endpoint
+	}
+
+	entry, ok := c.dnsEndpoints[publicKey]
+	if ok && entry.host == endpoint && entry.addr != "" {
+		return entry.addr
+	}
+	if !ok || entry.host != endpoint {
+		entry = &dnsEndpoint{host: endpoint}
+		c.dnsEndpoints[publicKey] = entry
+	}
+
+	addr, err := resolveEndpoint(endpoint)
+	if err != nil {
+		c.logger.Warn("Failed to resolve peer endpoint, will retry", "public_key", publicKey, "endpoint", endpoint, "error", err)
+		return ""
+	}
+	entry.addr = addr
+	return addr
+}
+
+// resolveInterval returns how often DNS endpoints are looked up again
+func (c *Client) resolveInterval() time.Duration {
+	if c.config.EndpointResolveInterval > 0 {
+		return time.Duration(c.config.EndpointResolveInterval) * time.Second
+	}
+	return EndpointResolveInterval
+}
+
+// resolveRoutine looks up DNS endpoints periodically, and as soon as a peer
+// with one loses its handshake, so that a dynamic DNS change is followed
+func (c *Client) resolveRoutine() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.resolveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.refreshEndpoints()
+		case <-c.resolveNow:
+			c.refreshEndpoints()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// requestResolve asks resolveRoutine to look up DNS endpoints now. It does
+// not block; a request already pending covers this one.
+func (c *Client) requestResolve() {
+	select {
+	case c.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// refreshEndpoints looks up every DNS endpoint without holding mu, then
+// repoints the peers whose address changed
+func (c *Client) refreshEndpoints() {
+	c.mu.Lock()
+	pending := make(map[string]string, len(c.dnsEndpoints))
+	for publicKey, entry := range c.dnsEndpoints {
+		// Forget peers that have gone from the interface
+		if _, ok := c.activePeers[publicKey]; !ok {
+			delete(c.dnsEndpoints, publicKey)
+			continue
+		}
+		pending[publicKey] = entry.host
+	}
+	c.mu.Unlock()
+
+	resolved := make(map[string]string, len(pending))
+	for publicKey, host := range pending {
+		addr, err := resolveEndpoint(host)
+		if err != nil {
+			c.logger.Warn("Failed to resolve peer endpoint, keeping the last address", "public_key", publicKey, "endpoint", host, "error", err)
+			continue
+		}
+		resolved[publicKey] = addr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wgInterface == nil {
+		return
+	}
+	for publicKey, addr := range resolved {
+		entry, ok := c.dnsEndpoints[publicKey]
+		if !ok || entry.host != pending[publicKey] || entry.addr == addr {
+			continue
+		}
+		if err := c.wgInterface.UpdatePeerEndpoint(publicKey, addr); err != nil {
+			c.logger.Warn("Failed to update peer endpoint", "public_key", publicKey, "endpoint", entry.host, "error", err)
+			continue
+		}
+		c.logger.Info("Peer endpoint moved", "public_key", publicKey, "endpoint", entry.host, "old", entry.addr, "new", addr)
+		entry.addr = addr
+	}
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// errServerKeyChanged is returned when the server presents other keys than
+// the pinned ones and neither an endorsement nor the operator vouches for
+// them
+var errServerKeyChanged = errors.New("server key changed")
+
+// serverIdentity is the pair of keys a server presents when we register
+type serverIdentity struct {
+	publicKey  string
+	signingKey string
+}
+
+// checkServerIdentity pins the server's keys on first registration (trust on
+// first use) and afterwards accepts other keys only if the pinned signing
+// key endorsed them, -accept-server-key-change was given, or the operator
+// approved them over the control socket
+func (c *Client) checkServerIdentity(resp *protocol.RegisterResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	verifySigning := !c.config.InsecureSkipPeerListVerify
+	if verifySigning && resp.ServerSigningKey == "" {
+		return fmt.Errorf("%w: server does not sign peer lists; upgrade the server or set insecure_skip_peer_list_verify", errRegistrationRejected)
+	}
+
+	pinned := serverIdentity{c.config.ServerPublicKey, c.config.ServerSigningKey}
+	presented := serverIdentity{resp.ServerPublicKey, resp.ServerSigningKey}
+	keyChanged := pinned.publicKey != "" && pinned.publicKey != presented.publicKey
+	signingChanged := verifySigning && pinned.signingKey != "" && pinned.signingKey != presented.signingKey
+
+	switch {
+	case !keyChanged && !signingChanged:
+		if pinned.publicKey == "" {
+			c.logger.Info("Pinned server key", "fingerprint", crypto.Fingerprint(presented.publicKey))
+		}
+	case c.endorsedLocked(resp.KeyEndorsement, pinned, presented):
+		c.logger.Info("Server rotated its key; the rotation is endorsed by the pinned key",
+			"old_fingerprint", crypto.Fingerprint(pinned.publicKey),
+			"new_fingerprint", crypto.Fingerprint(presented.publicKey))
+	case c.acceptKeyChange || c.approvedIdentity == presented:
+		c.logger.Warn("Accepted changed server key",
+			"old_fingerprint", crypto.Fingerprint(pinned.publicKey),
+			"new_fingerprint", crypto.Fingerprint(presented.publicKey))
+	default:
+		c.pendingIdentity = presented
+		return fmt.Errorf("%w: the coordination server presented a different key than the one pinned at the first registration\n"+
+			"  pinned:    %s (signing %s)\n"+
+			"  presented: %s (signing %s)\n"+
+			"Someone may be impersonating the server. If the key was changed on purpose, restart the client with -accept-server-key-change, "+
+			"or approve the new key while the client waits: vpn-client accept-server-key -fingerprint %s",
+			errServerKeyChanged,
+			crypto.Fingerprint(pinned.publicKey), crypto.Fingerprint(pinned.signingKey),
+			crypto.Fingerprint(presented.publicKey), crypto.Fingerprint(presented.signingKey),
+			crypto.Fingerprint(presented.publicKey))
+	}
+
+	c.config.ServerPublicKey = presented.publicKey
+	if verifySigning {
+		c.config.ServerSigningKey = presented.signingKey
+	}
+	c.acceptKeyChange = false
+	c.pendingIdentity = serverIdentity{}
+	c.approvedIdentity = serverIdentity{}
+	return nil
+}
+
+// endorsedLocked reports whether the pinned signing key endorsed the move
+// from the pinned keys to the presented ones. Callers must hold c.mu.
+func (c *Client) endorsedLocked(endorsement *protocol.KeyEndorsement, pinned, presented serverIdentity) bool {
+	if endorsement == nil || pinned.signingKey == "" {
+		return false
+	}
+	if pinned.publicKey != "" && endorsement.OldPublicKey != pinned.publicKey {
+		return false
+	}
+	if endorsement.NewPublicKey != presented.publicKey || endorsement.NewSigningKey != presented.signingKey {
+		return false
+	}
+
+	payload, err := endorsement.SigningPayload()
+	if err != nil {
+		return false
+	}
+	return crypto.Verify(pinned.signingKey, payload, endorsement.Signature) == nil
+}
+
+// followRotation moves the pins to the keys an endorsement vouches for, if
+// the pinned signing key made it, and returns the new signing key. It lets
+// a running client follow a rotation that happened after it registered.
+func (c *Client) followRotation(endorsement *protocol.KeyEndorsement) (string, bool) {
+	if endorsement == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	pinned := serverIdentity{c.config.ServerPublicKey, c.config.ServerSigningKey}
+	presented := serverIdentity{endorsement.NewPublicKey, endorsement.NewSigningKey}
+	if !c.endorsedLocked(endorsement, pinned, presented) {
+		c.mu.Unlock()
+		return "", false
+	}
+	c.config.ServerPublicKey = presented.publicKey
+	c.config.ServerSigningKey = presented.signingKey
+	c.mu.Unlock()
+
+	c.logger.Info("Server rotated its key; the rotation is endorsed by the pinned key",
+		"old_fingerprint", crypto.Fingerprint(pinned.publicKey),
+		"new_fingerprint", crypto.Fingerprint(presented.publicKey))
+	c.saveConfig()
+	return presented.signingKey, true
+}
+
+// AcceptServerKey approves the changed server key the client is waiting on,
+// identified by its fingerprint, and lets registration continue
+func (c *Client) AcceptServerKey(fingerprint string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pendingIdentity.publicKey == "" {
+		return errors.New("no changed server key is waiting for approval")
+	}
+	if fingerprint != crypto.Fingerprint(c.pendingIdentity.publicKey) {
+		return fmt.Errorf("fingerprint %s does not match the presented key %s", fingerprint, crypto.Fingerprint(c.pendingIdentity.publicKey))
+	}
+
+	c.approvedIdentity = c.pendingIdentity
+	select {
+	case c.keyApproved <- struct{}{}:
+	default:
+	}
+	return nil
+}
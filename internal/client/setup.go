@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// SetupStep is the outcome of one step of the last interface setup,
+// reported in Status so a half-configured node shows which step broke
+type SetupStep struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// strictSetup reports whether any failed setup step fails Start
+func (c *Client) strictSetup() bool {
+	return c.config.SetupPolicy == config.SetupPolicyStrict
+}
+
+// setupStep records a step of the interface setup and reports whether
+// setup must stop: when a required step failed, or any step under the
+// strict policy
+func (c *Client) setupStep(name string, err error, required bool) bool {
+	step := SetupStep{Name: name, OK: err == nil}
+	if err != nil {
+		step.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	c.setupSteps = append(c.setupSteps, step)
+	c.mu.Unlock()
+
+	return err != nil && (required || c.strictSetup())
+}
+
+// backendSetupSteps records the steps a backend carried on past during
+// Create. It returns the first failure that stops setup, or nil.
+func (c *Client) backendSetupSteps(wgInterface wireguard.Backend) error {
+	s, ok := wgInterface.(interface{ SetupSteps() []wireguard.SetupStep })
+	if !ok {
+		return nil
+	}
+	var stop error
+	for _, step := range s.SetupSteps() {
+		if c.setupStep(step.Name, step.Err, false) && stop == nil {
+			stop = fmt.Errorf("step %s: %w", step.Name, step.Err)
+		}
+	}
+	return stop
+}
+
+// abandonInterface gives up on an interface whose setup failed. Under the
+// lenient policy the device is only closed, and the state file lets the
+// next start clean up. Under the strict policy everything set up so far is
+// removed, in reverse order of creation: the ACL, the device, and its
+// record in the state file.
+func (c *Client) abandonInterface(wgInterface wireguard.Backend) {
+	if !c.strictSetup() {
+		wgInterface.Close()
+		return
+	}
+
+	c.mu.Lock()
+	if c.wgInterface == wgInterface {
+		c.wgInterface = nil
+	}
+	c.mu.Unlock()
+
+	removed := c.removeACL()
+	if err := wgInterface.Destroy(); err != nil {
+		c.logger.Warn("Failed to destroy interface after failed setup", "error", err)
+		removed = false
+	}
+	wgInterface.Close()
+	if !removed {
+		// Left recorded for the next start to clean up
+		return
+	}
+
+	var empty bool
+	err := c.state.Update(func(s *State) {
+		s.InterfaceName = ""
+		s.Backend = ""
+		s.Addresses = nil
+		s.ProcessPIDs = nil
+		empty = s.Firewall == nil && s.ACL == nil && len(s.Routes) == 0 && !s.DNSModified
+	})
+	if err == nil && empty {
+		err = c.state.Remove()
+	}
+	if err != nil {
+		c.logger.Warn("Failed to record client state", "error", err)
+	}
+}
+
+// setupStatusLocked returns the steps of the last setup. Callers must hold
+// c.mu.
+func (c *Client) setupStatusLocked() []SetupStep {
+	return append([]SetupStep(nil), c.setupSteps...)
+}
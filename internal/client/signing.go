@@ -0,0 +1,129 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// DefaultPeerListMaxAge is how old a signed peer list may be by default
+const DefaultPeerListMaxAge = 5 * time.Minute
+
+// peerListClockSkew is how far in the future a peer list may be signed,
+// allowing for the server's clock running ahead of ours
+const peerListClockSkew = time.Minute
+
+// verifyPeerList checks that a peer list is signed with the pinned key, for
+// us, and recently
+func (c *Client) verifyPeerList(list *protocol.PeerListResponse, now time.Time) error {
+	c.mu.Lock()
+	key := c.config.ServerSigningKey
+	skip := c.config.InsecureSkipPeerListVerify
+	c.mu.Unlock()
+
+	if skip {
+		return nil
+	}
+	if key == "" {
+		return errors.New("no server signing key pinned")
+	}
+	if list.Signature == "" {
+		return errors.New("peer list is not signed")
+	}
+
+	payload, err := list.SigningPayload(c.peerID)
+	if err != nil {
+		return err
+	}
+	if err := crypto.Verify(key, payload, list.Signature); err != nil {
+		// The server may have rotated its key since we registered
+		rotated, ok := c.followRotation(list.KeyEndorsement)
+		if !ok {
+			return fmt.Errorf("peer list signature: %w", err)
+		}
+		if err := crypto.Verify(rotated, payload, list.Signature); err != nil {
+			return fmt.Errorf("peer list signature: %w", err)
+		}
+	}
+
+	return c.checkPeerListAge(list.SignedAt, now)
+}
+
+// verifyNotModified checks that a 304 answering our request for the list
+// with the given ETag is signed with the pinned key, for us, and recently.
+// It returns when the server signed it, or 0 if verification is disabled.
+func (c *Client) verifyNotModified(header http.Header, etag string, now time.Time) (int64, error) {
+	c.mu.Lock()
+	key := c.config.ServerSigningKey
+	skip := c.config.InsecureSkipPeerListVerify
+	c.mu.Unlock()
+
+	if skip {
+		return 0, nil
+	}
+	if key == "" {
+		return 0, errors.New("no server signing key pinned")
+	}
+	// With a key pinned, an unsigned 304 is no better than a forged one
+	signature := header.Get(protocol.PeerListSignatureHeader)
+	if signature == "" {
+		return 0, errors.New("304 response is not signed")
+	}
+
+	signedAt, err := strconv.ParseInt(header.Get(protocol.PeerListSignedAtHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header: %w", protocol.PeerListSignedAtHeader, err)
+	}
+	version, err := strconv.ParseUint(header.Get(protocol.PeerListVersionHeader), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header: %w", protocol.PeerListVersionHeader, err)
+	}
+	payload, err := protocol.NotModifiedSigningPayload(c.peerID, etag, version, signedAt)
+	if err != nil {
+		return 0, err
+	}
+	if err := crypto.Verify(key, payload, signature); err != nil {
+		return 0, fmt.Errorf("304 signature: %w", err)
+	}
+	if err := c.checkPeerListAge(signedAt, now); err != nil {
+		return 0, err
+	}
+	return signedAt, nil
+}
+
+// checkPeerListAge checks that a peer list signed at signedAt (Unix seconds)
+// is neither older than the maximum age nor signed in the future
+func (c *Client) checkPeerListAge(signedAt int64, now time.Time) error {
+	at := time.Unix(signedAt, 0)
+	if age := now.Sub(at); age > c.peerListMaxAge() {
+		return fmt.Errorf("peer list is stale: signed %s ago", age.Round(time.Second))
+	}
+	if at.Sub(now) > peerListClockSkew {
+		return fmt.Errorf("peer list is signed in the future (%s); check the clocks", at.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cachedPeerListStaleLocked returns why the peer list we hold may no longer
+// be used, or nil. The list is held to the same age limit as a fetched one,
+// counted from when it was signed or a signed 304 last vouched for it.
+// Callers must hold c.mu.
+func (c *Client) cachedPeerListStaleLocked(now time.Time) error {
+	if c.peerList == nil || c.config.InsecureSkipPeerListVerify {
+		return nil
+	}
+	return c.checkPeerListAge(max(c.peerList.SignedAt, c.peerListVouchedAt), now)
+}
+
+// peerListMaxAge returns how old a signed peer list may be
+func (c *Client) peerListMaxAge() time.Duration {
+	if c.config.PeerListMaxAge > 0 {
+		return time.Duration(c.config.PeerListMaxAge) * time.Second
+	}
+	return DefaultPeerListMaxAge
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// testSigningKey returns a fresh server signing key
+func testSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// notModifiedServer answers every peer list request with 304, signed with
+// key when set, and records the If-None-Match header of each request
+type notModifiedServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newNotModifiedServer(t *testing.T, key ed25519.PrivateKey) *notModifiedServer {
+	s := &notModifiedServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := r.Header.Get("If-None-Match")
+		s.mu.Lock()
+		s.requests = append(s.requests, etag)
+		s.mu.Unlock()
+
+		signedAt := time.Now().Unix()
+		w.Header().Set(protocol.PeerListVersionHeader, "7")
+		if key != nil {
+			payload, _ := protocol.NotModifiedSigningPayload(r.URL.Query().Get("peer_id"), etag, 7, signedAt)
+			w.Header().Set(protocol.PeerListSignedAtHeader, strconv.FormatInt(signedAt, 10))
+			w.Header().Set(protocol.PeerListSignatureHeader, crypto.Sign(key, payload))
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *notModifiedServer) conditions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// cachedListClient returns a client holding a peer list from the server,
+// with the signing key pinned, as after a verified fetch signedAt
+func cachedListClient(t *testing.T, srv *httptest.Server, pinned string, signedAt time.Time) *Client {
+	c, _ := newServerClient(t, srv, func(cfg *config.ClientConfig) { cfg.ServerSigningKey = pinned })
+	c.peerID = "peer-a"
+	c.peerList = &protocol.PeerListResponse{Version: 6, SignedAt: signedAt.Unix()}
+	c.peerListETag = `"cached"`
+	return c
+}
+
+func TestSignedNotModifiedKeepsList(t *testing.T) {
+	key := testSigningKey(t)
+	srv := newNotModifiedServer(t, key)
+	c := cachedListClient(t, srv.Server, crypto.SigningPublicKeyString(key), time.Now().Add(-time.Minute))
+
+	if err := c.syncPeers(context.Background()); err != nil {
+		t.Fatalf("syncPeers: %v", err)
+	}
+	if got := srv.conditions(); len(got) != 1 || got[0] != `"cached"` {
+		t.Errorf("requests with If-None-Match %q, want one with the cached ETag", got)
+	}
+	if c.version != 7 || c.peerListVouchedAt == 0 {
+		t.Errorf("version %d vouched at %d, want version 7 vouched for", c.version, c.peerListVouchedAt)
+	}
+}
+
+func TestUntrustedNotModifiedRefetchedOnce(t *testing.T) {
+	pinned := testSigningKey(t)
+	tests := []struct {
+		name string
+		key  ed25519.PrivateKey // Signs the 304s, if set
+	}{
+		{"unsigned", nil},
+		{"signed with another key", testSigningKey(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A server that answers 304 even to the request for the whole
+			// list must not keep the client asking
+			srv := newNotModifiedServer(t, tt.key)
+			c := cachedListClient(t, srv.Server, crypto.SigningPublicKeyString(pinned), time.Now())
+
+			if err := c.syncPeers(context.Background()); err == nil {
+				t.Error("syncPeers accepted a 304 to an unconditional request")
+			}
+			if got := srv.conditions(); len(got) != 2 || got[0] != `"cached"` || got[1] != "" {
+				t.Errorf("requests with If-None-Match %q, want the cached ETag and then none", got)
+			}
+			if c.peerListVouchedAt != 0 {
+				t.Errorf("list vouched for at %d by an untrusted 304", c.peerListVouchedAt)
+			}
+		})
+	}
+}
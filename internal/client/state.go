@@ -0,0 +1,230 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/vpn/wireguard-mesh/internal/lockfile"
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// State records the system artifacts created by a running client. It is
+// rewritten as each artifact is created so that a client killed at any point
+// during setup can be cleaned up on the next start.
+type State struct {
+	PID           int      `json:"pid"`
+	InterfaceName string   `json:"interface_name,omitempty"` // Actual device name, e.g. the utun picked on macOS
+	Backend       string   `json:"backend,omitempty"`        // wireguard.KindKernel, KindUserspace or KindInProcess
+	Addresses     []string `json:"addresses,omitempty"`
+	Routes        []string `json:"routes,omitempty"`
+	DNSModified   bool     `json:"dns_modified,omitempty"`
+	ProcessPIDs   []int    `json:"process_pids,omitempty"`
+
+	// Firewall is the inbound rule opened for the listen port, if any
+	Firewall *wireguard.FirewallRule `json:"firewall,omitempty"`
+	// ACL is the source filter installed with enforce_acls, if any
+	ACL *wireguard.ACLRules `json:"acl,omitempty"`
+}
+
+// stateFile persists State to disk
+type stateFile struct {
+	path  string
+	mu    sync.Mutex
+	state State
+}
+
+// StateDir returns the directory holding the client's runtime state
+func StateDir(cfg *config.ClientConfig) string {
+	if cfg.StateDir != "" {
+		return cfg.StateDir
+	}
+	return config.GetDefaultConfigDir()
+}
+
+// StatePath returns the file recording what a client running with the given
+// configuration changed on the host
+func StatePath(cfg *config.ClientConfig) string {
+	return filepath.Join(StateDir(cfg), "state.json")
+}
+
+// LockPath returns the lock file held by a running client on its state
+// directory
+func LockPath(cfg *config.ClientConfig) string {
+	return filepath.Join(StateDir(cfg), "client.lock")
+}
+
+// acquireLock takes the lock on the state directory. The operating system
+// drops the lock of a client that crashed, so only a live client blocks.
+func (c *Client) acquireLock() error {
+	lock, err := lockfile.Acquire(LockPath(c.config))
+	var locked *lockfile.LockedError
+	if errors.As(err, &locked) {
+		holder := "another client"
+		if locked.PID != 0 {
+			holder = fmt.Sprintf("another client (process %d)", locked.PID)
+		}
+		return fmt.Errorf("%s is already running with state directory %s", holder, StateDir(c.config))
+	}
+	if err != nil {
+		return err
+	}
+	c.lock = lock
+	return nil
+}
+
+// releaseLock gives up the lock on the state directory
+func (c *Client) releaseLock() {
+	if err := c.lock.Release(); err != nil {
+		c.logger.Warn("Failed to release state directory lock", "error", err)
+	}
+	c.lock = nil
+}
+
+// Update applies fn to the recorded state and writes it out atomically
+func (f *stateFile) Update(fn func(*State)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn(&f.state)
+
+	data, err := json.MarshalIndent(f.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// InterfaceName returns the interface recorded as this client's
+func (f *stateFile) InterfaceName() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state.InterfaceName
+}
+
+// Remove deletes the state file after a clean teardown
+func (f *stateFile) Remove() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.state = State{}
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove state file: %w", err)
+	}
+	return nil
+}
+
+// loadState reads a state file written by a previous run
+func loadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	return &state, nil
+}
+
+// cleaner undoes system changes recorded in a stale state file
+type cleaner interface {
+	DestroyInterface(name string) error
+	StopProcess(pid int) error
+	RemoveRoute(route, iface string) error
+	RevertDNS(iface string) error
+	CloseFirewallPort(rule wireguard.FirewallRule) error
+	RemoveACL(rules wireguard.ACLRules) error
+}
+
+// systemCleaner performs cleanup against the real operating system
+type systemCleaner struct{}
+
+func (systemCleaner) DestroyInterface(name string) error { return wireguard.DestroyInterface(name) }
+func (systemCleaner) StopProcess(pid int) error          { return wireguard.StopProcess(pid) }
+func (systemCleaner) RemoveRoute(route, iface string) error {
+	return wireguard.RemoveRoute(route, iface)
+}
+func (systemCleaner) RevertDNS(iface string) error { return wireguard.RevertDNS(iface) }
+func (systemCleaner) CloseFirewallPort(rule wireguard.FirewallRule) error {
+	return wireguard.CloseFirewallPort(rule)
+}
+func (systemCleaner) RemoveACL(rules wireguard.ACLRules) error { return wireguard.RemoveACL(rules) }
+
+// recoverState cleans up after a previous client that exited without tearing
+// down its interface. It refuses to run while another client is alive.
+func (c *Client) recoverState() error {
+	socketPath := ControlSocketPath(c.config)
+	if controlSocketLive(socketPath) {
+		return fmt.Errorf("another client is already running (control socket %s)", socketPath)
+	}
+
+	state, err := loadState(c.state.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		c.logger.Warn("Discarding unreadable state file", "path", c.state.path, "error", err)
+		return c.state.Remove()
+	}
+
+	c.logger.Warn("Cleaning up after unclean shutdown", "pid", state.PID, "interface", state.InterfaceName, "backend", state.Backend)
+
+	// Unwind in reverse order of creation
+	var errs []error
+	if state.ACL != nil {
+		if err := c.cleaner.RemoveACL(*state.ACL); err != nil {
+			c.logger.Warn("Failed to remove stale ACL", "interface", state.ACL.Interface, "error", err)
+		}
+	}
+	if state.Firewall != nil {
+		if err := c.cleaner.CloseFirewallPort(*state.Firewall); err != nil {
+			c.logger.Warn("Failed to remove stale firewall rule", "rule", state.Firewall.Name, "error", err)
+		}
+	}
+	if state.DNSModified {
+		if err := c.cleaner.RevertDNS(state.InterfaceName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := len(state.Routes) - 1; i >= 0; i-- {
+		if err := c.cleaner.RemoveRoute(state.Routes[i], state.InterfaceName); err != nil {
+			c.logger.Warn("Failed to remove stale route", "route", state.Routes[i], "error", err)
+		}
+	}
+	for _, pid := range state.ProcessPIDs {
+		if err := c.cleaner.StopProcess(pid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if state.InterfaceName != "" {
+		if err := c.cleaner.DestroyInterface(state.InterfaceName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to clean up stale state from %s: %w", c.state.path, err)
+	}
+
+	return c.state.Remove()
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+// recordingCleaner records the cleanup calls made for a stale state file
+type recordingCleaner struct {
+	calls []string
+}
+
+func (r *recordingCleaner) record(format string, args ...any) error {
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (r *recordingCleaner) DestroyInterface(name string) error {
+	return r.record("destroy %s", name)
+}
+func (r *recordingCleaner) StopProcess(pid int) error { return r.record("stop %d", pid) }
+func (r *recordingCleaner) RemoveRoute(route, iface string) error {
+	return r.record("route %s dev %s", route, iface)
+}
+func (r *recordingCleaner) RevertDNS(iface string) error { return r.record("dns %s", iface) }
+func (r *recordingCleaner) CloseFirewallPort(rule wireguard.FirewallRule) error {
+	return r.record("firewall %s", rule.Name)
+}
+func (r *recordingCleaner) RemoveACL(rules wireguard.ACLRules) error {
+	return r.record("acl %s", rules.Interface)
+}
+
+func TestRecoverStaleState(t *testing.T) {
+	c, _ := newStaticClient(t)
+	cleaner := &recordingCleaner{}
+	c.cleaner = cleaner
+
+	// What a client killed after setting everything up leaves behind
+	stale := State{
+		PID:           4242,
+		InterfaceName: "wgtest0",
+		Backend:       wireguard.KindUserspace,
+		Addresses:     []string{"10.200.0.2/24"},
+		Routes:        []string{"10.200.0.0/24", "10.201.0.0/24"},
+		DNSModified:   true,
+		ProcessPIDs:   []int{4243},
+		Firewall:      &wireguard.FirewallRule{Name: "wg-mesh-wgtest0"},
+		ACL:           &wireguard.ACLRules{Interface: "wgtest0"},
+	}
+	data, err := json.Marshal(stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.state.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.recoverState(); err != nil {
+		t.Fatalf("recoverState: %v", err)
+	}
+
+	// Unwound in reverse order of creation
+	want := []string{
+		"acl wgtest0",
+		"firewall wg-mesh-wgtest0",
+		"dns wgtest0",
+		"route 10.201.0.0/24 dev wgtest0",
+		"route 10.200.0.0/24 dev wgtest0",
+		"stop 4243",
+		"destroy wgtest0",
+	}
+	if !reflect.DeepEqual(cleaner.calls, want) {
+		t.Errorf("cleanup calls:\n got %q\nwant %q", cleaner.calls, want)
+	}
+	if _, err := os.Stat(c.state.path); !os.IsNotExist(err) {
+		t.Errorf("state file still present: %v", err)
+	}
+}
+
+func TestRecoverPartialState(t *testing.T) {
+	c, _ := newStaticClient(t)
+	cleaner := &recordingCleaner{}
+	c.cleaner = cleaner
+
+	// Killed right after creating the interface
+	if err := c.state.Update(func(s *State) {
+		s.PID = 4242
+		s.InterfaceName = "wgtest0"
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.recoverState(); err != nil {
+		t.Fatalf("recoverState: %v", err)
+	}
+	if want := []string{"destroy wgtest0"}; !reflect.DeepEqual(cleaner.calls, want) {
+		t.Errorf("cleanup calls: got %q, want %q", cleaner.calls, want)
+	}
+}
+
+func TestRecoverWithoutState(t *testing.T) {
+	c, _ := newStaticClient(t)
+	cleaner := &recordingCleaner{}
+	c.cleaner = cleaner
+
+	if err := c.recoverState(); err != nil {
+		t.Fatalf("recoverState: %v", err)
+	}
+	if len(cleaner.calls) != 0 {
+		t.Errorf("cleanup without a state file: %q", cleaner.calls)
+	}
+}
+
+func TestRecoverUnreadableState(t *testing.T) {
+	c, _ := newStaticClient(t)
+	cleaner := &recordingCleaner{}
+	c.cleaner = cleaner
+
+	if err := os.WriteFile(c.state.path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.recoverState(); err != nil {
+		t.Fatalf("recoverState: %v", err)
+	}
+	if len(cleaner.calls) != 0 {
+		t.Errorf("cleanup from an unreadable state file: %q", cleaner.calls)
+	}
+	if _, err := os.Stat(c.state.path); !os.IsNotExist(err) {
+		t.Errorf("unreadable state file kept: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// useStaticAddress takes the interface address from the config in static
+// mode, where no server assigns one
+func (c *Client) useStaticAddress() error {
+	ip, network, err := net.ParseCIDR(c.config.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", c.config.Address, err)
+	}
+
+	c.mu.Lock()
+	c.assignedIP = ip.String()
+	c.networkCIDR = network.String()
+	c.mu.Unlock()
+	return nil
+}
+
+// staticPeerInfo describes a locally configured peer the way the server
+// describes managed ones, so that status, ping and events treat them alike
+func staticPeerInfo(peer config.StaticPeer, id string) protocol.PeerInfo {
+	info := protocol.PeerInfo{
+		ID:         id,
+		Hostname:   peer.Name,
+		PublicKey:  peer.PublicKey,
+		Endpoint:   peer.Endpoint,
+		AllowedIPs: append([]string(nil), peer.AllowedIPs...),
+		Online:     true,
+	}
+	if len(peer.AllowedIPs) > 0 {
+		if ip, _, err := net.ParseCIDR(peer.AllowedIPs[0]); err == nil {
+			info.VirtualIP = ip.String()
+		}
+	}
+	return info
+}
+
+// staticPeerConfig returns the WireGuard configuration of a locally
+// configured peer
+func staticPeerConfig(peer config.StaticPeer) wireguard.PeerConfig {
+	return wireguard.PeerConfig{
+		PublicKey:  peer.PublicKey,
+		Endpoint:   peer.Endpoint,
+		AllowedIPs: peer.AllowedIPs,
+		KeepAlive:  time.Duration(peer.PersistentKeepalive) * time.Second,
+	}
+}
+
+// applyStaticPeers programs the configured static peers onto the interface
+func (c *Client) applyStaticPeers() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wgInterface == nil {
+		return fmt.Errorf("interface is not available")
+	}
+
+	peers := make([]protocol.PeerInfo, 0, len(c.config.StaticPeers))
+	for i, peer := range c.config.StaticPeers {
+		info := staticPeerInfo(peer, fmt.Sprintf("static-%d", i+1))
+		peers = append(peers, info)
+
+		peerConfig := staticPeerConfig(peer)
+		peerConfig.Endpoint = c.peerEndpoint(peer.PublicKey, peer.Endpoint)
+		if err := c.wgInterface.AddPeer(peerConfig); err != nil {
+			c.logger.Warn("Failed to add static peer", "peer_id", info.ID, "name", peer.Name, "error", err)
+			continue
+		}
+		if _, known := c.activePeers[peer.PublicKey]; !known {
+			c.logger.Info("Added static peer", "peer_id", info.ID, "name", peer.Name, "endpoint", peer.Endpoint)
+			c.emit(Event{Type: EventPeerAdded, PeerID: info.ID, Hostname: info.Hostname, PublicKey: info.PublicKey, VirtualIP: info.VirtualIP, Endpoint: info.Endpoint})
+		}
+		c.activePeers[peer.PublicKey] = info
+		c.localPeers[peer.PublicKey] = true
+	}
+	c.peers = peers
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+// Connection reuse towards the coordination server
+const (
+	idleConnsPerHost    = 4
+	idleConnTimeout     = 90 * time.Second // Longer than HeartbeatInterval
+	tlsSessionCacheSize = 4
+
+	// Bytes of an unread response body read off before closing it, so the
+	// connection can be reused
+	maxDrainBytes = 64 << 10
+)
+
+// newHTTPClient builds the HTTP client used for the control channel. It
+// honors ProxyURL from the configuration, falling back to the standard
+// HTTPS_PROXY/HTTP_PROXY/NO_PROXY environment variables. It has no overall
+// timeout: each call carries its own deadline in its context.
+func newHTTPClient(cfg *config.ClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	// All calls go to one server: keep a few connections open across
+	// heartbeats and resume TLS sessions when one has to be redialled
+	transport.MaxIdleConns = idleConnsPerHost
+	transport.MaxIdleConnsPerHost = idleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(tlsSessionCacheSize)
+
+	if cfg.ProxyURL != "" {
+		proxyURL, err := parseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		// Credentials in the URL are sent as Proxy-Authorization (HTTP) or
+		// used for SOCKS5 authentication; https targets are tunnelled with
+		// CONNECT
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: userAgentTransport{next: transport},
+	}, nil
+}
+
+// userAgentTransport identifies the client and its version to the server and
+// to any proxy in between
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", version.UserAgent("wireguard-mesh-client"))
+	}
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections passes on http.Client.CloseIdleConnections, which
+// would otherwise stop at the wrapper and leave the connections of a stopped
+// client open
+func (t userAgentTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// parseProxyURL validates a proxy URL from the configuration
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy_url %q: scheme must be http, https, socks5 or socks5h; "+
+			"the proxy only carries the coordination channel, WireGuard UDP traffic cannot be proxied", proxyURL.Redacted())
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy_url %q: missing host", proxyURL.Redacted())
+	}
+
+	return proxyURL, nil
+}
+
+// parseServerURL parses the coordination server address once, so every call
+// is built from the same base
+func parseServerURL(raw string) (*url.URL, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("server_addr is not set")
+	}
+	serverURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server_addr: %w", err)
+	}
+	if (serverURL.Scheme != "http" && serverURL.Scheme != "https") || serverURL.Host == "" {
+		return nil, fmt.Errorf("invalid server_addr %q: want http:// or https:// followed by a host, e.g. \"https://vpn.example.com:8080\"", raw)
+	}
+	serverURL.Path = strings.TrimSuffix(serverURL.Path, "/")
+	return serverURL, nil
+}
+
+// drainAndClose reads off what is left of a response body and closes it,
+// returning the connection to the pool
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
@@ -0,0 +1,459 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// DefaultUDPCheckWindow is how long the client goes without a handshake
+// with any peer, while the server answers, before it checks whether the
+// network blocks UDP, unless configured
+const DefaultUDPCheckWindow = 2 * time.Minute
+
+// DefaultCaptivePortalURL answers 204 No Content unless something on the
+// network intercepts plain HTTP
+const DefaultCaptivePortalURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+const (
+	// udpProbeTimeout bounds each STUN probe and the captive portal check
+	udpProbeTimeout = 3 * time.Second
+
+	// udpFallbackAfter is how many checks in a row must find UDP blocked
+	// before the client moves to another listen port
+	udpFallbackAfter = 2
+)
+
+// Verdicts of the UDP check
+const (
+	UDPUnknown       = "unknown"        // Not checked yet, or nothing to probe
+	UDPOK            = "ok"             // Handshakes succeed, or STUN gets through on the WireGuard ports
+	UDPPortBlocked   = "port_blocked"   // STUN gets through on other ports but not the WireGuard ones
+	UDPBlocked       = "blocked"        // No STUN server answers on any port
+	UDPCaptivePortal = "captive_portal" // Plain HTTP is intercepted; sign in to the network
+)
+
+// Events for the UDP check
+const (
+	EventUDPBlocked    EventType = "udp_blocked"
+	EventCaptivePortal EventType = "captive_portal"
+)
+
+// STUNProbe is the outcome of one STUN Binding request
+type STUNProbe struct {
+	Server        string `json:"server"`                   // host:port
+	WireGuardPort bool   `json:"wireguard_port,omitempty"` // The port is one WireGuard listens on
+	OK            bool   `json:"ok"`
+	Mapped        string `json:"mapped,omitempty"` // Address the server saw the probe come from
+	Error         string `json:"error,omitempty"`
+}
+
+// UDPStatus reports the UDP check in Status and netcheck
+type UDPStatus struct {
+	Verdict     string      `json:"verdict"`
+	Detail      string      `json:"detail,omitempty"`
+	CheckedAt   *time.Time  `json:"checked_at,omitempty"`
+	Probes      []STUNProbe `json:"probes,omitempty"`
+	ListenPort  int         `json:"listen_port,omitempty"`  // In use
+	WorkingPort int         `json:"working_port,omitempty"` // Listen port of the last successful handshake
+	Failures    int         `json:"failures,omitempty"`     // Checks in a row that found UDP blocked
+}
+
+// blocked reports whether the verdict means peers cannot be reached over
+// this network
+func (s UDPStatus) blocked() bool {
+	return s.Verdict == UDPBlocked || s.Verdict == UDPPortBlocked
+}
+
+// listenPortOf returns the listen port a client with cfg starts on: the
+// fallback port that last worked, or else listen_port
+func listenPortOf(cfg *config.ClientConfig) int {
+	if cfg.ChosenListenPort != 0 {
+		return cfg.ChosenListenPort
+	}
+	return cfg.ListenPort
+}
+
+// listenPort returns the port the interface listens on
+func (c *Client) listenPort() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.activePort
+}
+
+// udpCheckWindow returns how long to wait for a handshake before checking
+func (c *Client) udpCheckWindow() time.Duration {
+	if c.config.UDPCheckWindow > 0 {
+		return time.Duration(c.config.UDPCheckWindow) * time.Second
+	}
+	return DefaultUDPCheckWindow
+}
+
+// udpCheckEnabled reports whether udpCheckRoutine runs: it needs the server
+// to tell a blocked network from an outage
+func (c *Client) udpCheckEnabled() bool {
+	return !c.config.Static() && c.config.UDPCheckWindow >= 0
+}
+
+// udpCheckRoutine watches for a network that lets the control channel
+// through but not WireGuard. While the server cannot be reached, it checks
+// for a captive portal. While it can, but no peer handshake succeeds for a
+// whole window though online peers are programmed, it probes the server's
+// stun_ports and stun_servers: no answer at all means UDP is blocked, and
+// answers on other ports but none on the WireGuard ports mean those ports
+// are. After udpFallbackAfter such checks in a row it moves to the next of
+// fallback_listen_ports. The port a handshake then succeeds on is saved as
+// chosen_listen_port.
+func (c *Client) udpCheckRoutine() {
+	defer c.wg.Done()
+
+	window := c.udpCheckWindow()
+	ticker := time.NewTicker(window / 4)
+	defer ticker.Stop()
+
+	c.mu.Lock()
+	c.udp = UDPStatus{Verdict: UDPUnknown}
+	c.mu.Unlock()
+
+	waitingSince := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return
+		}
+		now := time.Now()
+
+		if c.disconnected() {
+			// Peers are not told about us either, so no handshake is expected
+			waitingSince = now
+			if detail, captive := c.checkCaptivePortal(c.ctx); captive {
+				c.recordUDPCheck(UDPStatus{Verdict: UDPCaptivePortal, Detail: detail}, now)
+			}
+			continue
+		}
+
+		connected, expected := c.handshakeState()
+		switch {
+		case connected:
+			waitingSince = now
+			c.udpWorking(now)
+			continue
+		case !expected:
+			waitingSince = now
+			c.clearCaptivePortal()
+			continue
+		case now.Sub(waitingSince) < window:
+			c.clearCaptivePortal()
+			continue
+		}
+
+		// A whole window without a handshake; the next check waits for
+		// another
+		waitingSince = now
+		probes := c.probeSTUN(c.ctx)
+		verdict, detail := udpVerdict(probes)
+		c.recordUDPCheck(UDPStatus{Verdict: verdict, Detail: detail, Probes: probes}, now)
+
+		c.mu.Lock()
+		failures := c.udp.Failures
+		c.mu.Unlock()
+		if failures >= udpFallbackAfter {
+			c.switchListenPort(probes)
+		}
+	}
+}
+
+// handshakeState reports whether any peer has a recent handshake, and
+// whether one is expected: some online peer is programmed
+func (c *Client) handshakeState() (connected, expected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ok := range c.connected {
+		if ok {
+			return true, true
+		}
+	}
+	for _, peer := range c.activePeers {
+		if peer.Online {
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// udpWorking records a successful handshake on the listen port in use, and
+// saves the port as chosen_listen_port when it is a fallback one
+func (c *Client) udpWorking(now time.Time) {
+	c.mu.Lock()
+	port := c.activePort
+	previous := c.udp
+	c.udp = UDPStatus{Verdict: UDPOK, Detail: "peer handshakes succeed", CheckedAt: &now, WorkingPort: port}
+	c.udpTried = nil
+	c.mu.Unlock()
+
+	if previous.Verdict != UDPOK && previous.Verdict != UDPUnknown {
+		c.logger.Info("UDP gets through again", "listen_port", port)
+	}
+
+	chosen := port
+	if chosen == c.config.ListenPort {
+		chosen = 0
+	}
+	if chosen != c.config.ChosenListenPort {
+		c.config.ChosenListenPort = chosen
+		c.saveConfig()
+		c.logger.Info("Recorded the listen port that works", "listen_port", port)
+	}
+}
+
+// clearCaptivePortal drops a captive portal verdict once the server answers
+// again
+func (c *Client) clearCaptivePortal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.udp.Verdict == UDPCaptivePortal {
+		c.udp = UDPStatus{Verdict: UDPUnknown}
+		c.logger.Info("Coordination server reachable, captive portal passed")
+	}
+}
+
+// recordUDPCheck stores the outcome of a check, counting the checks in a
+// row that found UDP blocked, and logs and emits a changed bad verdict
+func (c *Client) recordUDPCheck(status UDPStatus, now time.Time) {
+	status.CheckedAt = &now
+
+	c.mu.Lock()
+	previous := c.udp
+	status.WorkingPort = previous.WorkingPort
+	if status.blocked() {
+		status.Failures = previous.Failures + 1
+	}
+	c.udp = status
+	c.mu.Unlock()
+
+	if status.Verdict == previous.Verdict && status.Detail == previous.Detail {
+		return
+	}
+	switch {
+	case status.Verdict == UDPCaptivePortal:
+		c.logger.Warn("Captive portal detected; sign in to the network to reach the mesh", "detail", status.Detail)
+		c.emit(Event{Type: EventCaptivePortal, Time: now, Error: status.Detail})
+	case status.blocked():
+		c.logger.Warn("UDP likely blocked: the server answers but no peer handshake succeeds", "verdict", status.Verdict, "detail", status.Detail)
+		c.emit(Event{Type: EventUDPBlocked, Time: now, Error: status.Detail})
+	default:
+		c.logger.Info("UDP check", "verdict", status.Verdict, "detail", status.Detail)
+	}
+}
+
+// checkCaptivePortal fetches captive_portal_url without following
+// redirects. Anything but 204 No Content means something on the network
+// intercepts HTTP. A failed fetch is no verdict: the network may just be
+// down.
+func (c *Client) checkCaptivePortal(ctx context.Context) (string, bool) {
+	target := c.config.CaptivePortalURL
+	switch target {
+	case "off":
+		return "", false
+	case "":
+		target = DefaultCaptivePortalURL
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, udpProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", false
+	}
+	httpClient := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+
+	if resp.StatusCode == http.StatusNoContent {
+		return "", false
+	}
+	if location := resp.Header.Get("Location"); location != "" {
+		return fmt.Sprintf("%s redirected to %s", target, location), true
+	}
+	return fmt.Sprintf("%s answered %s instead of 204", target, resp.Status), true
+}
+
+// probeSTUN sends a Binding request to each of the server's stun_ports and
+// each of stun_servers at once. A probe is to a WireGuard port when its
+// port is the listen port in use or one a peer listens on.
+func (c *Client) probeSTUN(ctx context.Context) []STUNProbe {
+	wireGuardPorts := make(map[int]bool)
+	c.mu.Lock()
+	wireGuardPorts[c.activePort] = true
+	for _, peer := range c.activePeers {
+		for _, endpoint := range append([]string{peer.Endpoint}, peer.Endpoints...) {
+			if _, port, err := net.SplitHostPort(endpoint); err == nil {
+				n, _ := strconv.Atoi(port)
+				wireGuardPorts[n] = true
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	var servers []string
+	if host := c.servers.activeHost(); host != "" {
+		for _, port := range c.stunPorts {
+			servers = append(servers, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+	}
+	servers = append(servers, c.config.STUNServers...)
+	return runSTUNProbes(ctx, servers, wireGuardPorts)
+}
+
+// runSTUNProbes probes each of servers at once, marking the probes to
+// wireGuardPorts
+func runSTUNProbes(ctx context.Context, servers []string, wireGuardPorts map[int]bool) []STUNProbe {
+	probes := make([]STUNProbe, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		probes[i].Server = server
+		if _, port, err := net.SplitHostPort(server); err == nil {
+			n, _ := strconv.Atoi(port)
+			probes[i].WireGuardPort = wireGuardPorts[n]
+		}
+
+		wg.Add(1)
+		go func(probe *STUNProbe) {
+			defer wg.Done()
+			mapped, err := network.ProbeSTUN(ctx, probe.Server, udpProbeTimeout)
+			if err != nil {
+				probe.Error = err.Error()
+				return
+			}
+			probe.OK = true
+			probe.Mapped = mapped.String()
+		}(&probes[i])
+	}
+	wg.Wait()
+	return probes
+}
+
+// udpVerdict concludes from STUN probes made while no handshake succeeds
+func udpVerdict(probes []STUNProbe) (string, string) {
+	if len(probes) == 0 {
+		return UDPUnknown, "no STUN servers to probe; set stun_ports on the server or stun_servers"
+	}
+
+	var answered, silent []string
+	wireGuardAnswered, wireGuardProbed := false, false
+	for _, probe := range probes {
+		if probe.OK {
+			answered = append(answered, probe.Server)
+		}
+		if probe.WireGuardPort {
+			wireGuardProbed = true
+			wireGuardAnswered = wireGuardAnswered || probe.OK
+			if !probe.OK {
+				silent = append(silent, probe.Server)
+			}
+		}
+	}
+
+	switch {
+	case len(answered) == 0:
+		return UDPBlocked, "no STUN server answered; UDP is likely blocked on this network"
+	case wireGuardProbed && !wireGuardAnswered:
+		return UDPPortBlocked, fmt.Sprintf("STUN answered on %s but not on the WireGuard ports (%s); UDP to them is likely blocked",
+			strings.Join(answered, ", "), strings.Join(silent, ", "))
+	}
+	if wireGuardProbed {
+		return UDPOK, "UDP gets through on the WireGuard ports"
+	}
+	return UDPOK, "UDP gets through"
+}
+
+// switchListenPort moves the interface to the next listen port to try:
+// listen_port and fallback_listen_ports, in order, those a STUN probe to
+// the same port got an answer on first. Ports tried since one last worked
+// are skipped until all have been.
+func (c *Client) switchListenPort(probes []STUNProbe) {
+	answered := make(map[int]bool)
+	for _, probe := range probes {
+		if _, port, err := net.SplitHostPort(probe.Server); err == nil && probe.OK {
+			n, _ := strconv.Atoi(port)
+			answered[n] = true
+		}
+	}
+
+	var ports []int
+	seen := make(map[int]bool)
+	for _, port := range append([]int{c.config.ListenPort}, c.config.FallbackListenPorts...) {
+		if port != 0 && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	sort.SliceStable(ports, func(i, j int) bool { return answered[ports[i]] && !answered[ports[j]] })
+
+	c.mu.Lock()
+	current := c.activePort
+	tried := make(map[int]bool)
+	for _, port := range c.udpTried {
+		tried[port] = true
+	}
+	next := 0
+	for _, port := range ports {
+		if port != current && !tried[port] {
+			next = port
+			break
+		}
+	}
+	if next == 0 {
+		// Every port has had its turn; start over
+		c.udpTried = nil
+		for _, port := range ports {
+			if port != current {
+				next = port
+				break
+			}
+		}
+	}
+	if next == 0 {
+		c.mu.Unlock()
+		return
+	}
+	c.udpTried = append(c.udpTried, current)
+	c.activePort = next
+	c.udp.Failures = 0
+	c.mu.Unlock()
+
+	c.logger.Warn("UDP stays blocked, moving to another listen port", "from", current, "to", next)
+	c.closeFirewall()
+	err := c.restartInterface()
+	c.openFirewall()
+	if err != nil {
+		// The watchdog retries on the new port
+		c.logger.Warn("Failed to move the interface to the new listen port", "listen_port", next, "error", err)
+		return
+	}
+
+	// Advertise the new port at once rather than at the next heartbeat
+	select {
+	case c.heartbeatNow <- struct{}{}:
+	default:
+	}
+}
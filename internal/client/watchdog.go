@@ -0,0 +1,127 @@
+package client
+
+import (
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+const (
+	// WatchdogInterval is the default time between interface health checks
+	WatchdogInterval = 10 * time.Second
+
+	// watchdogMaxBackoff caps the delay between failed recovery attempts
+	watchdogMaxBackoff = 5 * time.Minute
+)
+
+// WatchdogStatus reports interface self-healing activity
+type WatchdogStatus struct {
+	Restarts            int       `json:"restarts"`
+	LastRestart         time.Time `json:"last_restart,omitempty"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// watchdogInterval returns the configured health check interval
+func (c *Client) watchdogInterval() time.Duration {
+	if c.config.WatchdogInterval > 0 {
+		return time.Duration(c.config.WatchdogInterval) * time.Second
+	}
+	return WatchdogInterval
+}
+
+// watchdogRoutine periodically verifies that the WireGuard interface still
+// exists and matches its configuration, recreating it when it does not
+func (c *Client) watchdogRoutine() {
+	defer c.wg.Done()
+
+	interval := c.watchdogInterval()
+	delay := interval
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return
+		}
+
+		c.mu.Lock()
+		backend := c.wgInterface
+		c.mu.Unlock()
+
+		var err error
+		if backend != nil {
+			err = backend.Check()
+		}
+		if backend != nil && err == nil {
+			delay = interval
+			continue
+		}
+
+		if err != nil {
+			c.logger.Warn("Interface health check failed, recreating interface", "error", err)
+		}
+
+		if err := c.restartInterface(); err != nil {
+			c.mu.Lock()
+			c.watchdog.ConsecutiveFailures++
+			c.watchdog.LastError = err.Error()
+			failures := c.watchdog.ConsecutiveFailures
+			c.mu.Unlock()
+
+			// Back off exponentially so a persistently failing create does
+			// not spin
+			delay = interval << min(failures, 16)
+			if delay > watchdogMaxBackoff {
+				delay = watchdogMaxBackoff
+			}
+			c.logger.Warn("Failed to recreate interface", "error", err, "retry_in", delay)
+			continue
+		}
+
+		c.mu.Lock()
+		c.watchdog.Restarts++
+		c.watchdog.LastRestart = time.Now()
+		c.watchdog.ConsecutiveFailures = 0
+		restarts := c.watchdog.Restarts
+		c.mu.Unlock()
+
+		c.logger.Info("Interface recreated by watchdog", "restarts", restarts)
+		delay = interval
+	}
+}
+
+// Healthy reports whether the interface is up and passed its last health
+// check
+func (c *Client) Healthy() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.wgInterface != nil && c.watchdog.ConsecutiveFailures == 0
+}
+
+// restartInterface tears down whatever is left of the interface and sets it
+// up again from scratch, including a full peer sync
+func (c *Client) restartInterface() error {
+	c.rebuild.Lock()
+	defer c.rebuild.Unlock()
+
+	c.mu.Lock()
+	old := c.wgInterface
+	c.wgInterface = nil
+	c.activePeers = make(map[string]protocol.PeerInfo)
+	c.localPeers = make(map[string]bool)
+	// The new interface gets the full peer list at once, not a 304
+	c.lastReconfigure = time.Time{}
+	c.peerListETag = ""
+	c.mu.Unlock()
+
+	if old != nil {
+		if err := old.Destroy(); err != nil {
+			c.logger.Debug("Destroying broken interface failed", "error", err)
+		}
+		old.Close()
+	}
+
+	return c.setupInterface()
+}
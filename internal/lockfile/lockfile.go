@@ -0,0 +1,90 @@
+// Package lockfile provides advisory locks that keep two processes from
+// using the same files at once. Locks are taken with flock on Unix and
+// LockFileEx on Windows, so the operating system releases them when the
+// holder exits, even when it crashes: a lock file left behind by a dead
+// process never blocks the next one.
+package lockfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// LockedError is returned by Acquire when another process holds the lock
+type LockedError struct {
+	Path string
+	PID  int // Zero when the holder did not record its PID
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by process %d", e.Path, e.PID)
+}
+
+// Lock is a held lock
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the lock at path without waiting, creating the file if
+// needed, and records the caller's PID in it. It fails with a *LockedError
+// when another process holds the lock.
+func Acquire(path string) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	locked, err := tryLock(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if !locked {
+		f.Close()
+		return nil, &LockedError{Path: path, PID: readPID(path)}
+	}
+
+	// Whatever PID is in the file belongs to a process that has exited
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{file: f}, nil
+}
+
+// Release gives up the lock. The file is left in place; removing it could
+// let two processes lock different files of the same name.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	f := l.file
+	l.file = nil
+
+	f.Truncate(0)
+	unlock(f)
+	return f.Close()
+}
+
+// readPID returns the PID recorded in a lock file, or zero
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// AddressConflict is a virtual IP held by more than one peer, as reported by
+// a client and confirmed against the peer table
+type AddressConflict struct {
+	IP         string    `json:"ip"`
+	PeerIDs    []string  `json:"peer_ids"`
+	ReportedBy string    `json:"reported_by"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// addressHolders returns the IDs of the peers holding ip, ordered. Callers
+// must hold s.mu.
+func (s *Server) addressHolders(ip string) []string {
+	var holders []string
+	for id, peer := range s.peers {
+		if peer.VirtualIP == ip {
+			holders = append(holders, id)
+		}
+	}
+	sort.Strings(holders)
+	return holders
+}
+
+// recordAddressConflicts records the conflicts a peer reports. Reports the
+// peer table does not bear out, e.g. from a client with a stale peer list,
+// are ignored. Callers must hold s.mu.
+func (s *Server) recordAddressConflicts(reporter string, reported []protocol.AddressConflict) {
+	now := time.Now()
+	for _, conflict := range reported {
+		parsed := net.ParseIP(conflict.IP)
+		if parsed == nil {
+			continue
+		}
+		ip := parsed.String()
+
+		holders := s.addressHolders(ip)
+		if len(holders) < 2 {
+			continue
+		}
+		if _, known := s.addressConflicts[ip]; !known {
+			log.Printf("Warning: peer %s reports address %s held by peers %v", reporter, ip, holders)
+		}
+		s.addressConflicts[ip] = AddressConflict{IP: ip, PeerIDs: holders, ReportedBy: reporter, ReportedAt: now}
+	}
+}
+
+// pruneAddressConflicts forgets conflicts that have been resolved, by
+// removing or moving peers, and refreshes the holders of the rest. Callers
+// must hold s.mu.
+func (s *Server) pruneAddressConflicts() {
+	for ip, conflict := range s.addressConflicts {
+		holders := s.addressHolders(ip)
+		if len(holders) < 2 {
+			delete(s.addressConflicts, ip)
+			log.Printf("Address conflict on %s resolved", ip)
+			continue
+		}
+		conflict.PeerIDs = holders
+		s.addressConflicts[ip] = conflict
+	}
+}
+
+// listAddressConflicts returns the current conflicts ordered by address.
+// Callers must hold s.mu.
+func (s *Server) listAddressConflicts() []AddressConflict {
+	conflicts := make([]AddressConflict, 0, len(s.addressConflicts))
+	for _, conflict := range s.addressConflicts {
+		conflicts = append(conflicts, conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(conflicts[i].IP).To16(), net.ParseIP(conflicts[j].IP).To16()) < 0
+	})
+	return conflicts
+}
+
+// releaseIP frees an address peerID no longer holds. While another peer
+// still holds it, as with an address conflict, it stays allocated and passes
+// to that peer so that it is not handed out again. Callers must hold s.mu,
+// and peerID must no longer hold ip in s.peers.
+func (s *Server) releaseIP(ip, peerID string) {
+	allocation, owned := s.allocations.Get(ip)
+	owned = owned && allocation.Owner == peerID
+
+	if holders := s.addressHolders(ip); len(holders) > 0 {
+		if owned {
+			allocation.Owner = holders[0]
+			s.allocations.Set(allocation)
+		}
+		return
+	}
+
+	s.ipAllocator.ReleaseIP(ip)
+	if owned {
+		s.allocations.Delete(ip)
+	}
+}
@@ -0,0 +1,379 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+//go:embed ui/index.html
+var adminUI []byte
+
+// requireAdmin guards an admin handler with the configured admin token. The
+// admin API is disabled entirely when no token is configured. It is also
+// the only place that answers cross-origin requests.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			http.Error(w, "Admin API disabled", http.StatusNotFound)
+			return
+		}
+		if s.adminCORS(w, r) {
+			return
+		}
+
+		if !crypto.ConstantTimeEqualString(adminToken(r), s.config.AdminToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// A replica's state belongs to the primary; it can only be viewed
+		if s.readOnly() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeReadOnly(w)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// handleAdminConflicts lists AllowedIPs prefixes claimed by more than one peer
+// and virtual IPs reported as held by more than one
+func (s *Server) handleAdminConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	conflicts := s.conflicts
+	addressConflicts := s.listAddressConflicts()
+	s.mu.RUnlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"conflicts":         conflicts,
+		"address_conflicts": addressConflicts,
+	})
+}
+
+// handleAdminUI serves the embedded dashboard. The page itself is public; it
+// asks for the admin token and presents it on every API call.
+func (s *Server) handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	if s.config.AdminToken == "" {
+		http.Error(w, "Admin API disabled", http.StatusNotFound)
+		return
+	}
+	if r.URL.Path != "/admin/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(adminUI)
+}
+
+// AdminPeer is a peer as listed by the admin API, with the fingerprint of
+// its public key for an administrator to compare with the device's
+type AdminPeer struct {
+	Peer
+	Fingerprint string `json:"fingerprint"`
+}
+
+// handleAdminPeers lists every peer, including pending and suspended ones
+func (s *Server) handleAdminPeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	peers := make([]AdminPeer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, AdminPeer{Peer: *peer, Fingerprint: crypto.Fingerprint(peer.PublicKey)})
+	}
+	addressConflicts := s.listAddressConflicts()
+	counts := s.countPeers()
+	s.mu.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peers":             peers,
+		"counts":            counts,
+		"address_conflicts": addressConflicts,
+	})
+}
+
+// handleAdminPeer handles DELETE and PATCH /admin/peers/{id},
+// POST /admin/peers/{id}/approve|suspend and GET /admin/peers/{id}/history
+func (s *Server) handleAdminPeer(w http.ResponseWriter, r *http.Request) {
+	peerID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/peers/"), "/")
+	if peerID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := protocol.ValidateID(peerID); err != nil {
+		writeInvalidParameter(w, "peer ID", err)
+		return
+	}
+
+	if peerID == ServerPeerID && s.config.JoinMesh && r.Method != http.MethodGet {
+		http.Error(w, errServerPeer.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+		err = s.removePeer(peerID)
+	case action == "" && r.Method == http.MethodPatch:
+		s.handleAdminPeerPatch(w, r, peerID)
+		return
+	case action == "approve" && r.Method == http.MethodPost:
+		err = s.setPeerStatus(peerID, PeerStatusActive)
+	case action == "suspend" && r.Method == http.MethodPost:
+		err = s.setPeerStatus(peerID, PeerStatusSuspended)
+	case action == "history" && r.Method == http.MethodGet:
+		s.handleAdminPeerHistory(w, r, peerID)
+		return
+	case action == "usage" && r.Method == http.MethodGet:
+		s.handleAdminPeerUsage(w, r, peerID)
+		return
+	case action == "usage" && r.Method == http.MethodPut:
+		var req struct {
+			MonthlyQuota int64 `json:"monthly_quota"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		err = s.setPeerQuota(peerID, req.MonthlyQuota)
+	case action == "" || action == "approve" || action == "suspend" || action == "history" || action == "usage":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleAdminPeerPatch changes a peer's settings: virtual_ip moves the peer
+// to that address and hidden hides it from, or shows it to, other peers
+func (s *Server) handleAdminPeerPatch(w http.ResponseWriter, r *http.Request, peerID string) {
+	var req struct {
+		VirtualIP string `json:"virtual_ip"`
+		Hidden    *bool  `json:"hidden"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.VirtualIP != "" {
+		err := s.moveVirtualIP(peerID, req.VirtualIP)
+		switch {
+		case errors.Is(err, errPeerNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	if req.Hidden != nil {
+		if err := s.setPeerHidden(peerID, *req.Hidden); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// handleAdminEvents streams peer changes as server-sent events until the
+// client disconnects
+func (s *Server) handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// removePeer deletes a peer and releases its address
+func (s *Server) removePeer(peerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[peerID]
+	if !exists {
+		return fmt.Errorf("peer %s not found", peerID)
+	}
+
+	s.removePeerLocked(peer, OfflineBanned)
+	return nil
+}
+
+// removePeerLocked deletes a peer that is known to exist. The reason, one
+// of the offline reasons or "", goes out with the peer_removed event.
+// Callers must hold s.mu.
+func (s *Server) removePeerLocked(peer *Peer, reason string) {
+	if reason != "" {
+		setOffline(peer, reason, time.Now())
+		peer.OfflineReason = reason
+	}
+	delete(s.peers, peer.ID)
+	delete(s.peersByKey, peer.PublicKey)
+	s.releaseIP(peer.VirtualIP, peer.ID)
+	s.pruneAddressConflicts()
+	s.conflicts = findConflicts(s.peers)
+
+	if err := s.store.DeletePeer(peer.ID); err != nil {
+		log.Printf("Failed to delete peer from store: %v", err)
+	}
+
+	s.publishPeer(EventPeerRemoved, peer)
+	log.Printf("Removed peer %s (%s)", peer.ID, peer.Hostname)
+}
+
+// setPeerStatus approves or suspends a peer. Approving a suspended peer
+// reinstates it.
+func (s *Server) setPeerStatus(peerID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[peerID]
+	if !exists {
+		return fmt.Errorf("peer %s not found", peerID)
+	}
+
+	if status == PeerStatusActive && peer.Status == PeerStatusPending {
+		now := time.Now()
+		peer.ApprovedAt = &now
+	}
+	peer.Status = status
+	if status == PeerStatusSuspended {
+		setOffline(peer, OfflineSuspended, time.Now())
+	}
+	s.conflicts = findConflicts(s.peers)
+
+	if err := s.store.SavePeer(peer); err != nil {
+		log.Printf("Failed to save peer to store: %v", err)
+	}
+
+	eventType := EventPeerApproved
+	if status == PeerStatusSuspended {
+		eventType = EventPeerSuspended
+	}
+	s.publishPeer(eventType, peer)
+	log.Printf("Peer %s (%s): %s", peerID, peer.Hostname, eventType)
+	if eventType == EventPeerApproved {
+		s.removeDuplicatesLocked(peer)
+	}
+	return nil
+}
+
+// moveVirtualIP reassigns a peer to the given address. Everything that can
+// fail is checked before the old address is released, so on error both
+// addresses keep their previous owners. The peer learns its new address from
+// its next heartbeat; the version bump makes the other peers resync.
+func (s *Server) moveVirtualIP(peerID, ip string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	ip = parsed.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[peerID]
+	if !exists {
+		return fmt.Errorf("%w: %s", errPeerNotFound, peerID)
+	}
+	oldIP := peer.VirtualIP
+	if ip == oldIP {
+		return nil
+	}
+
+	if existing, ok := s.allocations.Get(ip); ok {
+		return fmt.Errorf("%s is already allocated to %s", ip, existing.Owner)
+	}
+	allowedIPs := readdressAllowedIPs(peer.AllowedIPs, oldIP, ip)
+	if err := s.checkRouteConflicts(peer.ID, allowedIPs); err != nil {
+		return err
+	}
+	if err := s.ipAllocator.AllocateSpecificIP(ip); err != nil {
+		return err
+	}
+
+	s.allocations.Set(Allocation{IP: ip, Owner: peerID, Note: "reassigned", AllocatedAt: time.Now()})
+	peer.VirtualIP = ip
+	peer.AllowedIPs = allowedIPs
+	s.releaseIP(oldIP, peerID)
+	s.pruneAddressConflicts()
+	s.refreshConflicts(peer)
+
+	s.store.SavePeer(peer)
+	s.publishPeer(EventPeerUpdated, peer)
+
+	log.Printf("Moved peer %s (%s) from %s to %s", peerID, peer.Hostname, oldIP, ip)
+	return nil
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+const (
+	// OwnerReserved marks an address held back from automatic allocation
+	OwnerReserved = "reserved"
+
+	// maxReservedRange bounds how many addresses a single reserved_ips CIDR
+	// may expand to
+	maxReservedRange = 1 << 16
+)
+
+// Allocation records who holds an address in the mesh network
+type Allocation struct {
+	IP          string    `json:"ip"`
+	Owner       string    `json:"owner"` // Peer ID or OwnerReserved
+	Note        string    `json:"note,omitempty"`
+	AllocatedAt time.Time `json:"allocated_at"`
+}
+
+// AllocationStore persists the allocation table alongside the peer store
+type AllocationStore struct {
+	mu          sync.Mutex
+	allocations map[string]Allocation
+	dirty       bool
+	writer      *backgroundWriter
+}
+
+// NewAllocationStore opens the allocation table at path and starts its
+// background writer. Call Close to flush and stop it.
+func NewAllocationStore(path string) (*AllocationStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	var allocations []Allocation
+	if err := readJSONFile(path, &allocations); err != nil {
+		return nil, fmt.Errorf("failed to load allocation table: %w", err)
+	}
+
+	s := &AllocationStore{
+		allocations: make(map[string]Allocation, len(allocations)),
+	}
+	for _, allocation := range allocations {
+		s.allocations[allocation.IP] = allocation
+	}
+	s.writer = newBackgroundWriter(path, s.collect)
+
+	return s, nil
+}
+
+// Get returns the allocation of an address
+func (s *AllocationStore) Get(ip string) (Allocation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	allocation, ok := s.allocations[ip]
+	return allocation, ok
+}
+
+// Set records an allocation and schedules a write
+func (s *AllocationStore) Set(allocation Allocation) {
+	s.mu.Lock()
+	s.allocations[allocation.IP] = allocation
+	s.dirty = true
+	s.mu.Unlock()
+
+	s.writer.schedule()
+}
+
+// Delete forgets an allocation and schedules a write
+func (s *AllocationStore) Delete(ip string) {
+	s.mu.Lock()
+	delete(s.allocations, ip)
+	s.dirty = true
+	s.mu.Unlock()
+
+	s.writer.schedule()
+}
+
+// List returns all allocations ordered by address
+func (s *AllocationStore) List() []Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedLocked()
+}
+
+// Replace replaces the whole table and writes it out before returning
+func (s *AllocationStore) Replace(allocations []Allocation) error {
+	s.mu.Lock()
+	s.allocations = make(map[string]Allocation, len(allocations))
+	for _, allocation := range allocations {
+		s.allocations[allocation.IP] = allocation
+	}
+	s.dirty = true
+	s.mu.Unlock()
+
+	return s.writer.flush()
+}
+
+// Close stops the background writer and flushes pending changes
+func (s *AllocationStore) Close() error {
+	return s.writer.close()
+}
+
+// collect hands pending changes to the background writer
+func (s *AllocationStore) collect() (interface{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil, nil
+	}
+	s.dirty = false
+
+	return s.sortedLocked(), func() {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+// sortedLocked returns the allocations in address order. Callers must hold
+// s.mu.
+func (s *AllocationStore) sortedLocked() []Allocation {
+	allocations := make([]Allocation, 0, len(s.allocations))
+	for _, allocation := range s.allocations {
+		allocations = append(allocations, allocation)
+	}
+	sort.Slice(allocations, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(allocations[i].IP).To16(), net.ParseIP(allocations[j].IP).To16()) < 0
+	})
+	return allocations
+}
+
+// AllocationsPath returns the configured allocation table path or the
+// default next to the peer store
+func AllocationsPath(cfg *config.ServerConfig) string {
+	if cfg.AllocationsPath != "" {
+		return cfg.AllocationsPath
+	}
+	return filepath.Join(filepath.Dir(cfg.DBPath), "allocations.json")
+}
+
+// buildAllocations loads the allocation table and configured reservations
+// into a fresh allocator and reconciles them with the peers: peers missing
+// from the table are added, and addresses held by peers that no longer
+// exist are released. It returns the allocator and the reconciled table.
+func buildAllocations(networkCIDR string, groups map[string]string, table []Allocation, reserved []string, peers map[string]*Peer) (*network.IPAllocator, []Allocation, error) {
+	ipAllocator, err := newIPAllocator(networkCIDR, groups)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := make(map[string]Allocation, len(table)+len(peers))
+	for _, allocation := range table {
+		if allocation.Owner != OwnerReserved {
+			if _, exists := peers[allocation.Owner]; !exists {
+				log.Printf("Released address %s of missing peer %s", allocation.IP, allocation.Owner)
+				continue
+			}
+		}
+		if err := ipAllocator.AllocateSpecificIP(allocation.IP); err != nil {
+			log.Printf("Warning: dropping allocation of %s to %s: %v", allocation.IP, allocation.Owner, err)
+			continue
+		}
+		result[allocation.IP] = allocation
+	}
+
+	now := time.Now()
+	for _, ip := range expandReserved(reserved) {
+		if existing, ok := result[ip]; ok {
+			if existing.Owner != OwnerReserved {
+				log.Printf("Warning: reserved address %s is held by peer %s", ip, existing.Owner)
+			}
+			continue
+		}
+		if err := ipAllocator.AllocateSpecificIP(ip); err != nil {
+			log.Printf("Warning: cannot reserve %s: %v", ip, err)
+			continue
+		}
+		result[ip] = Allocation{IP: ip, Owner: OwnerReserved, Note: "reserved_ips", AllocatedAt: now}
+	}
+
+	for _, peer := range peers {
+		if existing, ok := result[peer.VirtualIP]; ok {
+			if existing.Owner != peer.ID {
+				log.Printf("Warning: IP %s of peer %s is allocated to %s", peer.VirtualIP, peer.ID, existing.Owner)
+			}
+			continue
+		}
+		if err := ipAllocator.AllocateSpecificIP(peer.VirtualIP); err != nil {
+			log.Printf("Warning: failed to re-allocate IP %s for peer %s: %v", peer.VirtualIP, peer.ID, err)
+			continue
+		}
+		allocatedAt := now
+		if peer.CreatedAt != nil {
+			allocatedAt = *peer.CreatedAt
+		}
+		result[peer.VirtualIP] = Allocation{IP: peer.VirtualIP, Owner: peer.ID, AllocatedAt: allocatedAt}
+	}
+
+	allocations := make([]Allocation, 0, len(result))
+	for _, allocation := range result {
+		allocations = append(allocations, allocation)
+	}
+	return ipAllocator, allocations, nil
+}
+
+// expandReserved turns reserved_ips entries, addresses or CIDRs, into
+// individual addresses
+func expandReserved(entries []string) []string {
+	var ips []string
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				ips = append(ips, ip.String())
+			} else {
+				log.Printf("Warning: ignoring invalid reserved address %q", entry)
+			}
+			continue
+		}
+
+		_, prefix, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid reserved range %q", entry)
+			continue
+		}
+		if ones, bits := prefix.Mask.Size(); bits-ones > 16 {
+			log.Printf("Warning: ignoring reserved range %q larger than %d addresses", entry, maxReservedRange)
+			continue
+		}
+		for ip := prefix.IP.Mask(prefix.Mask); prefix.Contains(ip); ip = nextIP(ip) {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] > 0 {
+			break
+		}
+	}
+	return next
+}
+
+// handleAdminAllocations shows pool utilization and per-address ownership
+// (GET) or reserves an address (POST)
+func (s *Server) handleAdminAllocations(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		// Listing the waitlist drops clients that stopped waiting
+		s.mu.Lock()
+		size := s.ipAllocator.Size()
+		used := s.ipAllocator.Count()
+		cidr := s.ipAllocator.GetNetworkCIDR()
+		groups := s.groupUsage()
+		waiting := s.waitlist.list(time.Now())
+		s.mu.Unlock()
+
+		allocations := s.allocations.List()
+		reserved := 0
+		for _, allocation := range allocations {
+			if allocation.Owner == OwnerReserved {
+				reserved++
+			}
+		}
+
+		utilization := 0.0
+		if size > 0 {
+			utilization = float64(used) / float64(size)
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"network_cidr": cidr,
+			"size":         size,
+			"allocated":    used,
+			"reserved":     reserved,
+			"free":         size - used,
+			"utilization":  utilization,
+			"groups":       groups,
+			"allocations":  allocations,
+			"waitlist":     waiting,
+		})
+	case http.MethodPost:
+		var req struct {
+			IP   string `json:"ip"`
+			Note string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if err := s.reserveIP(req.IP, req.Note); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAllocation releases a reservation: DELETE /admin/allocations/{ip}
+func (s *Server) handleAdminAllocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ip := strings.TrimPrefix(r.URL.Path, "/admin/allocations/")
+	if net.ParseIP(ip) == nil {
+		writeInvalidParameter(w, "IP address", fmt.Errorf("%q is not an IP address", ip))
+		return
+	}
+	if err := s.unreserveIP(ip); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// reserveIP holds an address back from automatic allocation
+func (s *Server) reserveIP(ip, note string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("invalid IP address %q", ip)
+	}
+	ip = parsed.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.allocations.Get(ip); ok {
+		return fmt.Errorf("%s is already allocated to %s", ip, existing.Owner)
+	}
+	if err := s.ipAllocator.AllocateSpecificIP(ip); err != nil {
+		return err
+	}
+
+	s.allocations.Set(Allocation{IP: ip, Owner: OwnerReserved, Note: note, AllocatedAt: time.Now()})
+	log.Printf("Reserved address %s", ip)
+	return nil
+}
+
+// unreserveIP releases a reserved address. Peer addresses are released by
+// removing the peer.
+func (s *Server) unreserveIP(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.allocations.Get(ip)
+	if !ok {
+		return fmt.Errorf("%s is not allocated", ip)
+	}
+	if existing.Owner != OwnerReserved {
+		return fmt.Errorf("%s belongs to peer %s; remove the peer instead", ip, existing.Owner)
+	}
+
+	s.ipAllocator.ReleaseIP(ip)
+	s.allocations.Delete(ip)
+	log.Printf("Released reserved address %s", ip)
+	return nil
+}
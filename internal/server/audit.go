@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditDuplicateRemoved = "duplicate_removed" // An offline peer was replaced by a new one, see duplicate_peers
+	AuditLogRotated       = "log_rotated"       // Opens every file after the first, see PrevDigest
+)
+
+// auditRotatedTimeFormat names rotated audit log files so that they sort
+// chronologically
+const auditRotatedTimeFormat = "20060102T150405.000000000Z"
+
+// AuditEntry is one line of the audit log: an action the server took on a
+// peer without an administrator asking for it
+type AuditEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"`
+	PeerID      string    `json:"peer_id"`
+	Hostname    string    `json:"hostname,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	VirtualIP   string    `json:"virtual_ip,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+
+	// PrevDigest is the hex SHA-256 of the file before this one, recorded
+	// by the log_rotated entry that opens a file, so that the files form a
+	// hash chain
+	PrevDigest string `json:"prev_digest,omitempty"`
+}
+
+// AuditLog appends entries to a file as JSON lines. The file is only created
+// once there is something to record. It is rotated once it would grow past
+// maxSize or its first entry is maxAge old: the file is renamed with the
+// time, e.g. audit-20260102T150405.000000000Z.jsonl, and the new file starts
+// with a log_rotated entry holding the old one's digest. Rotated files whose
+// last entry is older than maxAge are deleted. Zero limits are unlimited.
+type AuditLog struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	file    *os.File
+	size    int64
+	started time.Time // Time of the file's first entry
+}
+
+// NewAuditLog returns an audit log appending to path. Call Close to release
+// the file.
+func NewAuditLog(path string, maxSize int64, maxAge time.Duration) *AuditLog {
+	return &AuditLog{path: path, maxSize: maxSize, maxAge: maxAge}
+}
+
+// Record appends an entry and syncs it to disk
+func (a *AuditLog) Record(entry AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		if err := a.openLocked(); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	if a.rotateDueLocked(int64(len(data)+1), now) {
+		if err := a.rotateLocked(now); err != nil {
+			return err
+		}
+	}
+	return a.writeLocked(data, entry.Time)
+}
+
+// Maintain rotates the file once its first entry is max age old and
+// deletes the rotated files past it, for a log nothing is recorded in
+func (a *AuditLog) Maintain(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.maxAge <= 0 {
+		return nil
+	}
+	if a.file == nil {
+		if _, err := os.Stat(a.path); os.IsNotExist(err) {
+			return a.pruneLocked(now)
+		}
+		if err := a.openLocked(); err != nil {
+			return err
+		}
+	}
+	if a.rotateDueLocked(0, now) {
+		return a.rotateLocked(now)
+	}
+	return a.pruneLocked(now)
+}
+
+// openLocked opens the file for appending, reading its size and the time
+// of its first entry. Callers must hold a.mu.
+func (a *AuditLog) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(a.path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	a.file = file
+	a.size = info.Size()
+	a.started = time.Time{}
+	if a.size > 0 {
+		a.started = firstAuditEntryTime(a.path)
+	}
+	return nil
+}
+
+// writeLocked appends one line. Callers must hold a.mu.
+func (a *AuditLog) writeLocked(data []byte, at time.Time) error {
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	a.size += int64(len(data) + 1)
+	if a.started.IsZero() {
+		a.started = at
+	}
+	return a.file.Sync()
+}
+
+// rotateDueLocked reports whether the file must be rotated before n more
+// bytes are written. An empty file is never rotated. Callers must hold
+// a.mu.
+func (a *AuditLog) rotateDueLocked(n int64, now time.Time) bool {
+	if a.size == 0 {
+		return false
+	}
+	if a.maxSize > 0 && a.size+n > a.maxSize {
+		return true
+	}
+	return a.maxAge > 0 && !a.started.IsZero() && now.Sub(a.started) >= a.maxAge
+}
+
+// rotateLocked renames the file, starts a new one chained to it, and prunes
+// the rotated files past max age. If the rename fails the file is reopened
+// on the next write. Callers must hold a.mu.
+func (a *AuditLog) rotateLocked(now time.Time) error {
+	err := a.file.Close()
+	a.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+
+	digest, err := crypto.FileDigest(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	stem, ext := auditLogStem(a.path)
+	rotated := stem + "-" + now.UTC().Format(auditRotatedTimeFormat) + ext
+	if err := os.Rename(a.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+
+	if err := a.openLocked(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(AuditEntry{
+		Time:       now,
+		Action:     AuditLogRotated,
+		Detail:     filepath.Base(rotated),
+		PrevDigest: digest,
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.writeLocked(data, now); err != nil {
+		return err
+	}
+	return a.pruneLocked(now)
+}
+
+// pruneLocked deletes the rotated files whose last entry, going by the
+// modification time, is older than max age. Callers must hold a.mu.
+func (a *AuditLog) pruneLocked(now time.Time) error {
+	if a.maxAge <= 0 {
+		return nil
+	}
+	files, err := AuditLogFiles(a.path)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if path == a.path {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || now.Sub(info.ModTime()) < a.maxAge {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to prune audit log: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the file, if it was opened
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// AuditLogPath returns the configured audit log path or the default next to
+// the peer store
+func AuditLogPath(cfg *config.ServerConfig) string {
+	if cfg.AuditLogPath != "" {
+		return cfg.AuditLogPath
+	}
+	return filepath.Join(filepath.Dir(cfg.DBPath), "audit.jsonl")
+}
+
+// AuditLogFiles returns the files of the audit log at path, oldest first:
+// the rotated ones, then path itself if it exists
+func AuditLogFiles(path string) ([]string, error) {
+	stem, ext := auditLogStem(path)
+	matches, err := filepath.Glob(stem + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(match, stem+"-"), ext)
+		if _, err := time.Parse(auditRotatedTimeFormat, timestamp); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// auditLogStem splits path into the part rotated files are named after
+// and its extension
+func auditLogStem(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext), ext
+}
+
+// firstAuditEntryTime returns the time of the first entry of an audit log
+// file, or the zero time if it cannot be read
+func firstAuditEntryTime(path string) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return time.Time{}
+	}
+	var entry AuditEntry
+	if json.Unmarshal(line, &entry) != nil {
+		return time.Time{}
+	}
+	return entry.Time
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// auditExportPurpose separates audit export signatures from the server's
+// other signatures
+const auditExportPurpose = "wireguard-mesh audit export v1"
+
+// AuditManifest is the last line of an audit export. It holds the SHA-256
+// of the entry lines before it, signed with the server's signing key.
+type AuditManifest struct {
+	Manifest   bool       `json:"manifest"` // Tells the manifest from the entries
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	Entries    int        `json:"entries"`
+	SHA256     string     `json:"sha256"`
+	SigningKey string     `json:"signing_key"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Signature  string     `json:"signature,omitempty"`
+}
+
+// signingDigest returns the digest of the manifest with its signature left
+// out, which is what the signature covers
+func (m AuditManifest) signingDigest() (string, error) {
+	m.Signature = ""
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return crypto.Digest(data), nil
+}
+
+// auditRoutine periodically rotates and prunes the audit log when it has a
+// maximum age
+func (s *Server) auditRoutine() {
+	defer s.routines.Done()
+
+	interval := time.Hour
+	if maxAge := time.Duration(s.config.AuditLogMaxAge) * time.Second; maxAge < interval {
+		interval = maxAge
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.audit.Maintain(time.Now()); err != nil {
+			log.Printf("Audit log maintenance failed: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// SigningPublicKey returns the base64 public key the server with cfg signs
+// with
+func SigningPublicKey(cfg *config.ServerConfig) (string, error) {
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		return "", err
+	}
+	return crypto.SigningPublicKeyString(key), nil
+}
+
+// ExportAudit writes the audit log entries from from up to to as gzipped
+// JSON lines followed by a signed manifest. A zero from or to leaves that
+// end open. Every rotated file must begin with the digest of the one before
+// it, so a file changed or removed from the middle of the log is reported
+// rather than exported; files deleted for retention only shorten the
+// chain. The log is read from disk, so the server may be running.
+func ExportAudit(cfg *config.ServerConfig, w io.Writer, from, to time.Time) (*AuditManifest, error) {
+	key, err := loadSigningKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	files, err := AuditLogFiles(AuditLogPath(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit log files: %w", err)
+	}
+
+	manifest := &AuditManifest{
+		Manifest:   true,
+		SigningKey: crypto.SigningPublicKeyString(key),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if !from.IsZero() {
+		manifest.From = &from
+	}
+	if !to.IsZero() {
+		manifest.To = &to
+	}
+
+	zw := gzip.NewWriter(w)
+	content := sha256.New()
+	out := io.MultiWriter(zw, content)
+	prevDigest := ""
+	for i, path := range files {
+		digest, err := exportAuditFile(path, i > 0, prevDigest, func(entry *AuditEntry, line []byte) error {
+			if (!from.IsZero() && entry.Time.Before(from)) || (!to.IsZero() && !entry.Time.Before(to)) {
+				return nil
+			}
+			manifest.Entries++
+			_, err := out.Write(line)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		prevDigest = digest
+	}
+
+	manifest.SHA256 = hex.EncodeToString(content.Sum(nil))
+	digest, err := manifest.signingDigest()
+	if err != nil {
+		return nil, err
+	}
+	manifest.Signature = crypto.SignDigest(key, auditExportPurpose, digest)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportAuditFile passes each entry of an audit log file to fn with its
+// line, and returns the file's digest. A chained file must start with a
+// log_rotated entry holding prevDigest. A line cut short at the end of the
+// file, one being written, is left out.
+func exportAuditFile(path string, chained bool, prevDigest string, fn func(*AuditEntry, []byte) error) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	reader := bufio.NewReader(io.TeeReader(file, h))
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return "", fmt.Errorf("%s line %d: invalid audit entry: %v", filepath.Base(path), n, err)
+		}
+		if chained && n == 1 && (entry.Action != AuditLogRotated || entry.PrevDigest != prevDigest) {
+			return "", fmt.Errorf("audit log chain broken: %s does not follow the file before it", filepath.Base(path))
+		}
+		if err := fn(&entry, line); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyAuditExport checks an export written by ExportAudit against the
+// server's base64 signing public key: the manifest's signature, and that the
+// entries are the ones it was made over. It returns the manifest.
+func VerifyAuditExport(r io.Reader, signingKey string) (*AuditManifest, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a gzipped audit export: %w", err)
+	}
+	defer zr.Close()
+
+	content := sha256.New()
+	reader := bufio.NewReader(zr)
+	var last []byte
+	entries := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return nil, fmt.Errorf("audit export ends in a partial line")
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit export: %w", err)
+		}
+		if last != nil {
+			content.Write(last)
+			entries++
+		}
+		last = line
+	}
+	if last == nil {
+		return nil, fmt.Errorf("audit export is empty")
+	}
+
+	var manifest AuditManifest
+	if err := json.Unmarshal(bytes.TrimSpace(last), &manifest); err != nil || !manifest.Manifest {
+		return nil, fmt.Errorf("audit export has no manifest")
+	}
+	if manifest.SigningKey != signingKey {
+		return nil, fmt.Errorf("audit export was signed with key %s, not %s", manifest.SigningKey, signingKey)
+	}
+	digest, err := manifest.signingDigest()
+	if err != nil {
+		return nil, err
+	}
+	if err := crypto.VerifyDigest(signingKey, auditExportPurpose, digest, manifest.Signature); err != nil {
+		return nil, fmt.Errorf("audit export manifest: %w", err)
+	}
+	if hex.EncodeToString(content.Sum(nil)) != manifest.SHA256 || entries != manifest.Entries {
+		return nil, fmt.Errorf("audit export entries do not match the manifest")
+	}
+	return &manifest, nil
+}
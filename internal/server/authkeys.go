@@ -0,0 +1,436 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// authKeyPrefix starts every pre-auth key, so that leaked keys are easy to
+// recognize. The rest is "<id>-<secret>".
+const authKeyPrefix = "wgmesh-auth-"
+
+var (
+	errAuthKeyInvalid  = errors.New("invalid auth key")
+	errAuthKeyRequired = errors.New("an auth key is required to register")
+	errAuthKeyNotFound = errors.New("auth key not found")
+
+	tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+// AuthKey is a pre-auth key that lets new peers register without approval.
+// Only a hash of the key is kept; the key itself is shown once on creation.
+type AuthKey struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Ephemeral bool       `json:"ephemeral,omitempty"` // Peers registering with it are ephemeral
+	MaxUses   int        `json:"max_uses,omitempty"`  // Zero means unlimited
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	// MaxActivePeers caps the peers registered with the key that exist at
+	// once; removing one frees its place. Zero means unlimited.
+	MaxActivePeers int `json:"max_active_peers,omitempty"`
+
+	// Guest makes the key an invite: its terms are stamped onto every peer
+	// registering with it
+	Guest *GuestAccess `json:"guest,omitempty"`
+}
+
+// AuthKeyOptions are the constraints of a new pre-auth key
+type AuthKeyOptions struct {
+	Expires   time.Duration // Zero means the key never expires
+	MaxUses   int           // Zero means unlimited
+	Tags      []string
+	Ephemeral bool
+	Guest     *GuestAccess // Makes the key an invite
+
+	MaxActivePeers int // Zero means unlimited
+}
+
+// usable returns why the key may not be used at now, or nil
+func (k *AuthKey) usable(now time.Time) error {
+	switch {
+	case k.RevokedAt != nil:
+		return fmt.Errorf("auth key %s has been revoked", k.ID)
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return fmt.Errorf("auth key %s has expired", k.ID)
+	case k.MaxUses > 0 && k.Uses >= k.MaxUses:
+		return fmt.Errorf("%w: auth key %s has been used up", errLimitExceeded, k.ID)
+	}
+	return nil
+}
+
+// AuthKeyStore persists pre-auth keys alongside the peer store
+type AuthKeyStore struct {
+	mu     sync.Mutex
+	keys   map[string]AuthKey
+	dirty  bool
+	writer *backgroundWriter
+}
+
+// NewAuthKeyStore opens the key table at path and starts its background
+// writer. Call Close to flush and stop it.
+func NewAuthKeyStore(path string) (*AuthKeyStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	var keys []AuthKey
+	if err := readJSONFile(path, &keys); err != nil {
+		return nil, fmt.Errorf("failed to load auth keys: %w", err)
+	}
+
+	s := &AuthKeyStore{
+		keys: make(map[string]AuthKey, len(keys)),
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	s.writer = newBackgroundWriter(path, s.collect)
+
+	return s, nil
+}
+
+// Create issues a new key and returns it with its record. The key cannot be
+// recovered later.
+func (s *AuthKeyStore) Create(opts AuthKeyOptions) (string, AuthKey, error) {
+	if opts.Expires < 0 {
+		return "", AuthKey{}, fmt.Errorf("invalid expiry %s", opts.Expires)
+	}
+	if opts.MaxUses < 0 {
+		return "", AuthKey{}, fmt.Errorf("invalid max uses %d", opts.MaxUses)
+	}
+	if opts.MaxActivePeers < 0 {
+		return "", AuthKey{}, fmt.Errorf("invalid max active peers %d", opts.MaxActivePeers)
+	}
+	tags, err := normalizeTags(opts.Tags)
+	if err != nil {
+		return "", AuthKey{}, err
+	}
+	var guest *GuestAccess
+	if opts.Guest != nil {
+		if guest, err = opts.Guest.normalize(); err != nil {
+			return "", AuthKey{}, err
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", AuthKey{}, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", AuthKey{}, err
+	}
+	key := authKeyPrefix + id + "-" + secret
+
+	now := time.Now().UTC()
+	record := AuthKey{
+		ID:        id,
+		Hash:      hashAuthKey(key),
+		Tags:      tags,
+		Ephemeral: opts.Ephemeral,
+		MaxUses:   opts.MaxUses,
+		CreatedAt: now,
+		Guest:     guest,
+
+		MaxActivePeers: opts.MaxActivePeers,
+	}
+	if opts.Expires > 0 {
+		expiresAt := now.Add(opts.Expires)
+		record.ExpiresAt = &expiresAt
+	}
+
+	s.mu.Lock()
+	s.keys[id] = record
+	s.dirty = true
+	s.mu.Unlock()
+
+	s.writer.schedule()
+	return key, record, nil
+}
+
+// Validate looks up a presented key and checks that it may still be used.
+// It does not count as a use; call Use once the peer is registered.
+func (s *AuthKeyStore) Validate(key string, now time.Time) (AuthKey, error) {
+	rest, ok := strings.CutPrefix(key, authKeyPrefix)
+	if !ok {
+		return AuthKey{}, errAuthKeyInvalid
+	}
+	id, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return AuthKey{}, errAuthKeyInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.keys[id]
+	if !exists || !crypto.ConstantTimeEqualString(record.Hash, hashAuthKey(key)) {
+		return AuthKey{}, errAuthKeyInvalid
+	}
+	if err := record.usable(now); err != nil {
+		return AuthKey{}, err
+	}
+	return record, nil
+}
+
+// Get returns the record of a key
+func (s *AuthKeyStore) Get(id string) (AuthKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	return key, ok
+}
+
+// Use counts a registration against a key and schedules a write
+func (s *AuthKeyStore) Use(id string) {
+	s.mu.Lock()
+	if record, exists := s.keys[id]; exists {
+		record.Uses++
+		s.keys[id] = record
+		s.dirty = true
+	}
+	s.mu.Unlock()
+
+	s.writer.schedule()
+}
+
+// Revoke stops a key from being used. The record is kept for the audit
+// trail of the peers registered with it.
+func (s *AuthKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	record, exists := s.keys[id]
+	if !exists {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s", errAuthKeyNotFound, id)
+	}
+	if record.RevokedAt == nil {
+		now := time.Now().UTC()
+		record.RevokedAt = &now
+		s.keys[id] = record
+		s.dirty = true
+	}
+	s.mu.Unlock()
+
+	s.writer.schedule()
+	return nil
+}
+
+// List returns all keys ordered by creation time, hashes included
+func (s *AuthKeyStore) List() []AuthKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedLocked()
+}
+
+// Replace replaces the whole table and writes it out before returning
+func (s *AuthKeyStore) Replace(keys []AuthKey) error {
+	s.mu.Lock()
+	s.keys = make(map[string]AuthKey, len(keys))
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	s.dirty = true
+	s.mu.Unlock()
+
+	return s.writer.flush()
+}
+
+// Close stops the background writer and flushes pending changes
+func (s *AuthKeyStore) Close() error {
+	return s.writer.close()
+}
+
+// collect hands pending changes to the background writer
+func (s *AuthKeyStore) collect() (interface{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil, nil
+	}
+	s.dirty = false
+
+	return s.sortedLocked(), func() {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+// sortedLocked returns copies of the keys, oldest first. Callers must hold
+// s.mu.
+func (s *AuthKeyStore) sortedLocked() []AuthKey {
+	keys := make([]AuthKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		key.Tags = append([]string(nil), key.Tags...)
+		if key.Guest != nil {
+			guest := *key.Guest
+			guest.Destinations = append([]string(nil), guest.Destinations...)
+			key.Guest = &guest
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// AuthKeysPath returns the configured auth key table path or the default
+// next to the peer store
+func AuthKeysPath(cfg *config.ServerConfig) string {
+	if cfg.AuthKeysPath != "" {
+		return cfg.AuthKeysPath
+	}
+	return filepath.Join(filepath.Dir(cfg.DBPath), "authkeys.json")
+}
+
+// hashAuthKey returns the stored form of a key. Keys are long random
+// strings, so a plain SHA-256 is enough to make a leaked table useless.
+func hashAuthKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate auth key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// normalizeTags checks tag names and drops duplicates, keeping their order
+func normalizeTags(tags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: use lowercase letters, digits and hyphens", tag)
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result, nil
+}
+
+// authorizeRegistration checks the auth key presented by a new peer. It
+// returns nil, nil when no key was presented and none is required. Callers
+// must hold s.mu.
+func (s *Server) authorizeRegistration(key string) (*AuthKey, error) {
+	if key == "" {
+		if s.config.RequireAuthKey {
+			return nil, errAuthKeyRequired
+		}
+		return nil, nil
+	}
+
+	record, err := s.authKeys.Validate(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// handleAdminAuthKeys lists pre-auth keys (GET) or issues one (POST). The
+// new key is only ever returned by the POST.
+func (s *Server) handleAdminAuthKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys := s.authKeys.List()
+		for i := range keys {
+			keys[i].Hash = ""
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth_keys": keys,
+		})
+	case http.MethodPost:
+		var req struct {
+			ExpiresIn int      `json:"expires_in"` // Seconds; zero never expires
+			MaxUses   int      `json:"max_uses"`
+			Tags      []string `json:"tags"`
+			Ephemeral bool     `json:"ephemeral"`
+
+			MaxActivePeers int `json:"max_active_peers"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+
+		key, record, err := s.authKeys.Create(AuthKeyOptions{
+			Expires:   time.Duration(req.ExpiresIn) * time.Second,
+			MaxUses:   req.MaxUses,
+			Tags:      req.Tags,
+			Ephemeral: req.Ephemeral,
+
+			MaxActivePeers: req.MaxActivePeers,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record.Hash = ""
+
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":      key,
+			"auth_key": record,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminAuthKey revokes a pre-auth key: DELETE /admin/authkeys/{id}.
+// Peers already registered with it are not affected.
+func (s *Server) handleAdminAuthKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/authkeys/")
+	if err := protocol.ValidateID(id); err != nil {
+		writeInvalidParameter(w, "auth key ID", err)
+		return
+	}
+	if err := s.authKeys.Revoke(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// Files making up a state archive
+const (
+	archiveManifest    = "manifest.json"
+	archiveConfig      = "config.json"
+	archivePeers       = "peers.json"
+	archiveAllocations = "allocations.json"
+	archiveAuthKeys    = "authkeys.json"
+
+	// archiveVersion is bumped when the archive layout changes incompatibly
+	archiveVersion = 1
+
+	// maxArchiveEntry bounds how much of a single archive file is read
+	maxArchiveEntry = 64 << 20
+)
+
+// StateManifest describes a state archive
+type StateManifest struct {
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	NetworkCIDR string    `json:"network_cidr"`
+	Peers       int       `json:"peers"`
+	IncludeKeys bool      `json:"include_keys"`
+}
+
+// ExportState writes a gzipped tar archive of the server configuration,
+// peers, allocation table and pre-auth keys to w. Pre-auth keys are only
+// stored hashed and always included. Without includeKeys the server key pair
+// and admin token are left out; the caller is responsible for protecting
+// archives that include them.
+func ExportState(w io.Writer, cfg *config.ServerConfig, peers []*Peer, allocations []Allocation, authKeys []AuthKey, includeKeys bool) error {
+	exported := *cfg
+	if !includeKeys {
+		exported.PrivateKey = ""
+		exported.PublicKey = ""
+		exported.AdminToken = ""
+	}
+
+	manifest := StateManifest{
+		Version:     archiveVersion,
+		Created:     time.Now().UTC(),
+		NetworkCIDR: cfg.NetworkCIDR,
+		Peers:       len(peers),
+		IncludeKeys: includeKeys,
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{archiveManifest, manifest},
+		{archiveConfig, exported},
+		{archivePeers, newPeerFile(peers)},
+		{archiveAllocations, allocations},
+		{archiveAuthKeys, authKeys},
+	}
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", file.name, err)
+		}
+
+		header := &tar.Header{
+			Name:    file.name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: manifest.Created,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return gz.Close()
+}
+
+// ImportState restores a state archive into the config file at configPath
+// and the peer store it names. The store path of an existing config is kept;
+// so are its keys and admin token when the archive carries none. A non-empty
+// existing store is only replaced with force.
+func ImportState(r io.Reader, configPath string, force bool) (*StateManifest, error) {
+	files, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest StateManifest
+	var imported config.ServerConfig
+	for name, target := range map[string]interface{}{
+		archiveManifest: &manifest,
+		archiveConfig:   &imported,
+	} {
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("archive is missing %s", name)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+	}
+
+	if manifest.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	// Peers carry their own version, so archives of older servers migrate
+	// like their peer stores do
+	data, ok := files[archivePeers]
+	if !ok {
+		return nil, fmt.Errorf("archive is missing %s", archivePeers)
+	}
+	peers, _, err := decodePeers(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", archivePeers, err)
+	}
+	if err := validateAllocations(imported.NetworkCIDR, peers); err != nil {
+		return nil, err
+	}
+
+	// Keep the destination's paths and, unless the archive has its own,
+	// its identity
+	existing, err := config.LoadServerConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	imported.DBPath = existing.DBPath
+	imported.AllocationsPath = existing.AllocationsPath
+	imported.AuthKeysPath = existing.AuthKeysPath
+	imported.AuditLogPath = existing.AuditLogPath
+	if imported.PrivateKey == "" {
+		imported.PrivateKey = existing.PrivateKey
+		imported.PublicKey = existing.PublicKey
+	}
+	if imported.AdminToken == "" {
+		imported.AdminToken = existing.AdminToken
+	}
+
+	// A running server would overwrite the imported files with its own
+	lock, err := LockStore(&imported)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	store, err := NewPeerStore(imported.DBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open peer store: %w", err)
+	}
+	defer store.Close()
+	current, err := store.LoadPeers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer store: %w", err)
+	}
+	if len(current) > 0 && !force {
+		return nil, fmt.Errorf("peer store %s already holds %d peers; use --force to replace it", imported.DBPath, len(current))
+	}
+
+	if err := store.ReplacePeers(peers); err != nil {
+		return nil, err
+	}
+
+	// Archives from before the allocation table have none; it is rebuilt
+	// from the peers on startup
+	if data, ok := files[archiveAllocations]; ok {
+		var allocations []Allocation
+		if err := json.Unmarshal(data, &allocations); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", archiveAllocations, err)
+		}
+		allocationStore, err := NewAllocationStore(AllocationsPath(&imported))
+		if err != nil {
+			return nil, err
+		}
+		err = allocationStore.Replace(allocations)
+		allocationStore.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data, ok := files[archiveAuthKeys]; ok {
+		var authKeys []AuthKey
+		if err := json.Unmarshal(data, &authKeys); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", archiveAuthKeys, err)
+		}
+		authKeyStore, err := NewAuthKeyStore(AuthKeysPath(&imported))
+		if err != nil {
+			return nil, err
+		}
+		err = authKeyStore.Replace(authKeys)
+		authKeyStore.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := config.SaveServerConfig(configPath, &imported); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// readArchive reads the regular files of a gzipped tar archive into memory
+func readArchive(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if header.Size > maxArchiveEntry {
+			return nil, fmt.Errorf("archive entry %s is too large", header.Name)
+		}
+
+		data, err := io.ReadAll(io.LimitReader(tr, maxArchiveEntry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		files[header.Name] = data
+	}
+
+	return files, nil
+}
+
+// validateAllocations checks that every peer address lies within the
+// network and that no two peers share one
+func validateAllocations(networkCIDR string, peers []*Peer) error {
+	allocator, err := network.NewIPAllocator(networkCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid network CIDR in archive: %w", err)
+	}
+
+	for _, peer := range peers {
+		if err := allocator.AllocateSpecificIP(peer.VirtualIP); err != nil {
+			return fmt.Errorf("peer %s does not fit network %s: %w", peer.ID, networkCIDR, err)
+		}
+	}
+	return nil
+}
+
+// handleAdminExport streams a state archive for automated backups. Keys are
+// included only when include_keys=true is passed.
+func (s *Server) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	includeKeys := r.URL.Query().Get("include_keys") == "true"
+
+	s.mu.RLock()
+	cfg := *s.config
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, copyPeer(peer))
+	}
+	s.mu.RUnlock()
+
+	filename := fmt.Sprintf("wireguard-mesh-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := ExportState(w, &cfg, peers, s.allocations.List(), s.authKeys.List(), includeKeys); err != nil {
+		log.Printf("State export failed: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// registerPeers registers n peers with s and returns their IDs
+func registerPeers(b *testing.B, s *Server, n int) []string {
+	b.Helper()
+
+	handler := s.Handler()
+	ids := make([]string, n)
+	for i := range ids {
+		code, resp, err := postRegister(handler, newRegisterRequest(b, fmt.Sprintf("peer-%d", i)))
+		if err != nil || code != http.StatusOK || !resp.Success {
+			b.Fatalf("register: status %d: %v %s", code, err, resp.Error)
+		}
+		ids[i] = resp.PeerID
+	}
+	return ids
+}
+
+func BenchmarkPeerList(b *testing.B) {
+	for _, peers := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("peers=%d", peers), func(b *testing.B) {
+			s := newTestServer(b)
+			ids := registerPeers(b, s, peers)
+			handler := s.Handler()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				r := httptest.NewRequest(http.MethodGet, "/peers?peer_id="+ids[i%len(ids)], nil)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					b.Fatalf("GET /peers: status %d", w.Code)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPeerListNotModified measures the common case of a client whose
+// list is current
+func BenchmarkPeerListNotModified(b *testing.B) {
+	s := newTestServer(b)
+	ids := registerPeers(b, s, 100)
+	handler := s.Handler()
+
+	etags := make([]string, len(ids))
+	for i, id := range ids {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/peers?peer_id="+id, nil))
+		etags[i] = w.Header().Get("ETag")
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/peers?peer_id="+ids[i%len(ids)], nil)
+		r.Header.Set("If-None-Match", etags[i%len(ids)])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusNotModified {
+			b.Fatalf("conditional GET /peers: status %d", w.Code)
+		}
+	}
+}
+
+func BenchmarkHeartbeat(b *testing.B) {
+	s := newTestServer(b)
+	ids := registerPeers(b, s, 100)
+	handler := s.Handler()
+
+	bodies := make([][]byte, len(ids))
+	for i, id := range ids {
+		body, err := json.Marshal(protocol.HeartbeatRequest{
+			PeerID:    id,
+			Endpoint:  fmt.Sprintf("192.0.2.%d:51820", i%250+1),
+			Endpoints: []string{fmt.Sprintf("192.0.2.%d:51820", i%250+1)},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		bodies[i] = body
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/heartbeat", bytes.NewReader(bodies[i%len(bodies)]))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("POST /heartbeat: status %d", w.Code)
+		}
+	}
+}
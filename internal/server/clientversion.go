@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+// checkClientVersion refuses clients older than the configured minimum.
+// Clients from before version reporting send none and are refused too;
+// unstamped development builds are let through.
+func (s *Server) checkClientVersion(clientVersion string) error {
+	minimum := s.config.MinimumClientVersion
+	if minimum == "" || version.IsDev(clientVersion) {
+		return nil
+	}
+	if clientVersion == "" {
+		return fmt.Errorf("client version unknown, minimum is %s; upgrade the client", minimum)
+	}
+
+	cmp, err := version.Compare(clientVersion, minimum)
+	if err != nil {
+		return fmt.Errorf("client version %q not recognized, minimum is %s; upgrade the client", clientVersion, minimum)
+	}
+	if cmp < 0 {
+		return fmt.Errorf("client version %s is older than the minimum %s; upgrade the client", clientVersion, minimum)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+func TestCheckClientVersion(t *testing.T) {
+	tests := []struct {
+		minimum string
+		client  string
+		ok      bool
+	}{
+		{"", "", true},
+		{"", "garbage", true},
+		{"1.2.0", "1.2.0", true},
+		{"1.2.0", "v1.3.0", true},
+		{"1.2.0", "1.2.0-5-gabcdef0", true},
+		{"1.2.0", "1.1.9", false},
+		{"1.2.0", "1.2.0-rc.1", false},
+		{"1.2.0-rc.2", "1.2.0-rc.1", false},
+		{"1.2.0-rc.2", "1.2.0-rc.10", true},
+		{"1.2.0", "", false},
+		{"1.2.0", "1.2.x", false},
+		{"1.2.0", version.Dev, true},
+		{"1.2.0", "(devel)", true},
+	}
+
+	for _, tt := range tests {
+		cfg := config.DefaultServerConfig()
+		cfg.MinimumClientVersion = tt.minimum
+		s := &Server{config: cfg}
+		err := s.checkClientVersion(tt.client)
+		if tt.ok && err != nil {
+			t.Errorf("minimum %q, client %q: %v", tt.minimum, tt.client, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("minimum %q, client %q accepted", tt.minimum, tt.client)
+		}
+	}
+}
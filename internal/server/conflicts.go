@@ -0,0 +1,180 @@
+package server
+
+import (
+	"net"
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// AllowedIPs conflict policies
+const (
+	// ConflictPolicyFlag accepts conflicting routes and reports them
+	ConflictPolicyFlag = "flag"
+	// ConflictPolicyReject refuses registrations whose routes conflict
+	ConflictPolicyReject = "reject"
+)
+
+// routeClaim is a single AllowedIPs prefix claimed by a peer
+type routeClaim struct {
+	peerID string
+	prefix *net.IPNet
+}
+
+// findConflicts returns every prefix claimed by more than one peer. Exact
+// duplicates and partial overlaps are both reported, keyed by the narrower
+// (contested) prefix. A default route only conflicts with another default
+// route, since every more specific prefix legitimately overlaps it.
+func findConflicts(peers map[string]*Peer) []protocol.AllowedIPsConflict {
+	var claims []routeClaim
+	for id, peer := range peers {
+		for _, entry := range peer.AllowedIPs {
+			if _, prefix, err := net.ParseCIDR(entry); err == nil {
+				claims = append(claims, routeClaim{peerID: id, prefix: prefix})
+			}
+		}
+	}
+
+	contested := make(map[string]map[string]bool)
+	for i := range claims {
+		for j := i + 1; j < len(claims); j++ {
+			a, b := claims[i], claims[j]
+			if a.peerID == b.peerID {
+				continue
+			}
+			narrower, ok := contestedPrefix(a.prefix, b.prefix)
+			if !ok {
+				continue
+			}
+			key := narrower.String()
+			if contested[key] == nil {
+				contested[key] = make(map[string]bool)
+			}
+			contested[key][a.peerID] = true
+			contested[key][b.peerID] = true
+		}
+	}
+
+	conflicts := make([]protocol.AllowedIPsConflict, 0, len(contested))
+	for prefix, owners := range contested {
+		ids := make([]string, 0, len(owners))
+		for id := range owners {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		conflicts = append(conflicts, protocol.AllowedIPsConflict{
+			Prefix:    prefix,
+			PeerIDs:   ids,
+			Preferred: preferredOwner(ids, peers),
+		})
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Prefix < conflicts[j].Prefix
+	})
+
+	return conflicts
+}
+
+// conflictsFor returns the conflicts a peer would take part in if it claimed
+// the given AllowedIPs
+func conflictsFor(peerID string, allowedIPs []string, peers map[string]*Peer) []protocol.AllowedIPsConflict {
+	// The full computation is quadratic in the number of routes; most peers
+	// overlap nobody and can skip it
+	if !overlapsAny(peerID, allowedIPs, peers) {
+		return nil
+	}
+
+	candidate := make(map[string]*Peer, len(peers)+1)
+	for id, peer := range peers {
+		candidate[id] = peer
+	}
+	candidate[peerID] = &Peer{ID: peerID, AllowedIPs: allowedIPs, Online: true}
+
+	var involved []protocol.AllowedIPsConflict
+	for _, conflict := range findConflicts(candidate) {
+		for _, id := range conflict.PeerIDs {
+			if id == peerID {
+				involved = append(involved, conflict)
+				break
+			}
+		}
+	}
+
+	return involved
+}
+
+// overlapsAny reports whether any of the given AllowedIPs contest a prefix
+// claimed by another peer
+func overlapsAny(peerID string, allowedIPs []string, peers map[string]*Peer) bool {
+	var prefixes []*net.IPNet
+	for _, entry := range allowedIPs {
+		if _, prefix, err := net.ParseCIDR(entry); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	for id, peer := range peers {
+		if id == peerID {
+			continue
+		}
+		for _, entry := range peer.AllowedIPs {
+			_, other, err := net.ParseCIDR(entry)
+			if err != nil {
+				continue
+			}
+			for _, prefix := range prefixes {
+				if _, ok := contestedPrefix(prefix, other); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// involvedIn reports whether a peer owns any of the conflicts
+func involvedIn(peerID string, conflicts []protocol.AllowedIPsConflict) bool {
+	for _, conflict := range conflicts {
+		for _, id := range conflict.PeerIDs {
+			if id == peerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contestedPrefix returns the narrower of two overlapping prefixes
+func contestedPrefix(a, b *net.IPNet) (*net.IPNet, bool) {
+	if (a.IP.To4() == nil) != (b.IP.To4() == nil) {
+		return nil, false
+	}
+	if !a.Contains(b.IP) && !b.Contains(a.IP) {
+		return nil, false
+	}
+
+	aOnes, _ := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+	if (aOnes == 0) != (bOnes == 0) {
+		return nil, false
+	}
+
+	if aOnes >= bOnes {
+		return a, true
+	}
+	return b, true
+}
+
+// preferredOwner picks a deterministic owner for a contested prefix so that
+// every client applies the same choice: online active peers win, then the
+// lowest peer ID (the oldest registration)
+func preferredOwner(ids []string, peers map[string]*Peer) string {
+	for _, id := range ids {
+		if peer, ok := peers[id]; ok && peer.Online && peer.Status == PeerStatusActive {
+			return id
+		}
+	}
+	return ids[0]
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestFindConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes map[string][]string // Peer ID to AllowedIPs
+		want   []protocol.AllowedIPsConflict
+	}{
+		{
+			name:   "disjoint",
+			routes: map[string][]string{"a": {"192.168.1.0/24"}, "b": {"192.168.2.0/24"}},
+			want:   []protocol.AllowedIPsConflict{},
+		},
+		{
+			name:   "exact duplicate",
+			routes: map[string][]string{"a": {"192.168.1.0/24"}, "b": {"192.168.1.0/24"}},
+			want: []protocol.AllowedIPsConflict{
+				{Prefix: "192.168.1.0/24", PeerIDs: []string{"a", "b"}, Preferred: "a"},
+			},
+		},
+		{
+			name:   "partial overlap keyed by the narrower prefix",
+			routes: map[string][]string{"a": {"192.168.0.0/16"}, "b": {"192.168.5.0/24"}},
+			want: []protocol.AllowedIPsConflict{
+				{Prefix: "192.168.5.0/24", PeerIDs: []string{"a", "b"}, Preferred: "a"},
+			},
+		},
+		{
+			name:   "ipv6 overlap",
+			routes: map[string][]string{"a": {"2001:db8::/32"}, "b": {"2001:db8:1::/48"}},
+			want: []protocol.AllowedIPsConflict{
+				{Prefix: "2001:db8:1::/48", PeerIDs: []string{"a", "b"}, Preferred: "a"},
+			},
+		},
+		{
+			name:   "ipv4 and ipv6 never conflict",
+			routes: map[string][]string{"a": {"0.0.0.0/0"}, "b": {"::/0"}},
+			want:   []protocol.AllowedIPsConflict{},
+		},
+		{
+			name:   "default route only conflicts with a default route",
+			routes: map[string][]string{"a": {"0.0.0.0/0"}, "b": {"192.168.1.0/24"}, "c": {"0.0.0.0/0"}},
+			want: []protocol.AllowedIPsConflict{
+				{Prefix: "0.0.0.0/0", PeerIDs: []string{"a", "c"}, Preferred: "a"},
+			},
+		},
+		{
+			name:   "a peer does not conflict with itself",
+			routes: map[string][]string{"a": {"192.168.0.0/16", "192.168.1.0/24"}},
+			want:   []protocol.AllowedIPsConflict{},
+		},
+		{
+			name:   "three claimants",
+			routes: map[string][]string{"c": {"10.8.0.0/24"}, "b": {"10.8.0.0/24"}, "a": {"10.8.0.0/16"}},
+			want: []protocol.AllowedIPsConflict{
+				{Prefix: "10.8.0.0/24", PeerIDs: []string{"a", "b", "c"}, Preferred: "a"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers := make(map[string]*Peer)
+			for id, routes := range tt.routes {
+				peers[id] = &Peer{ID: id, AllowedIPs: routes, Online: true, Status: PeerStatusActive}
+			}
+			if got := findConflicts(peers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("findConflicts:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferredOwnerIsOnline(t *testing.T) {
+	peers := map[string]*Peer{
+		"a": {ID: "a", AllowedIPs: []string{"192.168.1.0/24"}, Status: PeerStatusActive},
+		"b": {ID: "b", AllowedIPs: []string{"192.168.1.0/24"}, Status: PeerStatusActive, Online: true},
+	}
+	conflicts := findConflicts(peers)
+	if len(conflicts) != 1 || conflicts[0].Preferred != "b" {
+		t.Errorf("conflicts = %+v, want b preferred as the online owner", conflicts)
+	}
+}
+
+func TestConflictsFor(t *testing.T) {
+	peers := map[string]*Peer{
+		"a": {ID: "a", AllowedIPs: []string{"192.168.0.0/16"}},
+		"b": {ID: "b", AllowedIPs: []string{"2001:db8::/32"}},
+	}
+	if got := conflictsFor("c", []string{"172.16.0.0/12"}, peers); got != nil {
+		t.Errorf("disjoint routes: %+v", got)
+	}
+	got := conflictsFor("c", []string{"192.168.7.0/24", "2001:db8:7::/48"}, peers)
+	if len(got) != 2 || !involvedIn("c", got) {
+		t.Errorf("conflictsFor = %+v, want one IPv4 and one IPv6 conflict", got)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	s := &Server{config: config.DefaultServerConfig()}
+
+	tests := []struct {
+		routes []string
+		err    string // Substring of the error, or "" for valid
+	}{
+		{[]string{"192.168.1.0/24", "2001:db8::/32"}, ""},
+		{[]string{"192.168.1.0/24", "192.168.1.0/24"}, ""}, // Duplicates only conflict between peers
+		{[]string{"192.168.1.5/24"}, "host bits"},
+		{[]string{"2001:db8::1/32"}, "host bits"},
+		{[]string{"192.168.1.0"}, "not a CIDR"},
+		{[]string{"0.0.0.0/0"}, "exit_node"},
+		{[]string{"::/0"}, "exit_node"},
+		{[]string{"127.0.0.0/8"}, "not a routable"},
+		{[]string{"fe80::/64"}, "not a routable"},
+		{[]string{"ff00::/8"}, "not a routable"},
+		{[]string{"10.100.5.0/24"}, "overlaps the mesh"}, // Inside the mesh
+		{[]string{"10.0.0.0/8"}, "overlaps the mesh"},    // Around the mesh
+		{make([]string, maxAdvertisedRoutes+1), "at most"},
+	}
+	for _, tt := range tests {
+		err := s.validateRoutes(tt.routes)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("validateRoutes(%q) = %v, want nil", tt.routes, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("validateRoutes(%q) = %v, want an error containing %q", tt.routes, err, tt.err)
+		}
+	}
+}
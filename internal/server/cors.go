@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// corsMaxAge is how long, in seconds, a browser may cache a preflight
+	corsMaxAge = 600
+
+	corsAllowMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowHeaders = "Authorization, Content-Type, X-Admin-Token"
+)
+
+// adminOriginAllowed reports whether a browser page from origin may call
+// the admin API
+func (s *Server) adminOriginAllowed(origin string) bool {
+	for _, allowed := range s.config.AdminAllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminCORS adds CORS headers for an allowed origin to an admin response.
+// It answers preflight requests itself, before any token check, since
+// browsers send them without credentials, and reports whether it did.
+// Cookies and other credentials are never allowed: the API authenticates
+// with the admin token alone.
+func (s *Server) adminCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(s.config.AdminAllowedOrigins) == 0 {
+		return false
+	}
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	w.Header().Add("Vary", "Origin")
+	if !s.adminOriginAllowed(origin) {
+		if preflight {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if !preflight {
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Retry-After")
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+	w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+	w.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// adminToken returns the token a request presents, from X-Admin-Token or an
+// Authorization: Bearer header
+func adminToken(r *http.Request) string {
+	if token := r.Header.Get("X-Admin-Token"); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+const (
+	testAdminToken = "admin-secret"
+	allowedOrigin  = "https://ops.example.com"
+)
+
+// newCORSServer returns the handler of a server whose admin API accepts
+// calls from pages on allowedOrigin
+func newCORSServer(t *testing.T) http.Handler {
+	return newTestServer(t, func(cfg *config.ServerConfig) {
+		cfg.AdminToken = testAdminToken
+		cfg.AdminAllowedOrigins = []string{allowedOrigin}
+	}).Handler()
+}
+
+// preflight sends the OPTIONS request a browser sends before a cross-origin
+// PATCH from origin
+func preflight(handler http.Handler, path, origin string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodOptions, path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodPatch)
+	r.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := newCORSServer(t)
+
+	// Answered before the token check: browsers send no credentials
+	w := preflight(handler, "/admin/peers", "HTTPS://OPS.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: status %d, want 204", w.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "HTTPS://OPS.example.com",
+		"Access-Control-Allow-Methods": corsAllowMethods,
+		"Access-Control-Allow-Headers": corsAllowHeaders,
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	}
+	for header, value := range want {
+		if got := w.Header().Get(header); got != value {
+			t.Errorf("%s: got %q, want %q", header, got, value)
+		}
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("credentials allowed: %q", got)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler := newCORSServer(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+	r.Header.Set("Origin", allowedOrigin)
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/peers: status %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != allowedOrigin {
+		t.Errorf("Access-Control-Allow-Origin: got %q, want %q", got, allowedOrigin)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got == "" {
+		t.Error("no exposed headers")
+	}
+
+	// The origin opens nothing up: the token is still needed
+	r = httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+	r.Header.Set("Origin", allowedOrigin)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET without a token: status %d, want 401", w.Code)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	handler := newCORSServer(t)
+
+	w := preflight(handler, "/admin/peers", "https://evil.example.com")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight: status %d, want 403", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight allowed origin %q", got)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/peers", nil)
+	r.Header.Set("Origin", "https://evil.example.com")
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("GET allowed origin %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Origin" {
+		t.Errorf("Vary: got %q, want Origin", got)
+	}
+}
+
+func TestCORSOnlyOnAdminAPI(t *testing.T) {
+	handler := newCORSServer(t)
+
+	// The endpoints clients call are not meant for browsers
+	for _, path := range []string{"/register", "/heartbeat", "/peers", "/version", "/healthz"} {
+		w := preflight(handler, path, allowedOrigin)
+		for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+			if got := w.Header().Get(header); got != "" {
+				t.Errorf("OPTIONS %s: %s %q", path, header, got)
+			}
+		}
+
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Origin", allowedOrigin)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("GET %s: Access-Control-Allow-Origin %q", path, got)
+		}
+	}
+}
+
+func TestCORSWithoutAllowedOrigins(t *testing.T) {
+	handler := newTestServer(t, func(cfg *config.ServerConfig) { cfg.AdminToken = testAdminToken }).Handler()
+
+	w := preflight(handler, "/admin/peers", allowedOrigin)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight allowed origin %q", got)
+	}
+	if w.Code == http.StatusNoContent {
+		t.Error("preflight answered without admin_allowed_origins")
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// DrainRetryAfter is how long a draining server tells refused peers to wait
+// before registering again
+const DrainRetryAfter = 30 * time.Second
+
+// Drain stops the server from accepting new peers ahead of maintenance.
+// Peers already registered keep registering, heartbeating and syncing; new
+// ones are told to retry later, and /healthz reports the server as not
+// ready so that load balancers send them elsewhere. The peer store is
+// flushed so that the server can be stopped or copied at any point after.
+func (s *Server) Drain() error {
+	s.mu.Lock()
+	wasDraining := s.draining
+	s.draining = true
+	s.mu.Unlock()
+
+	if !wasDraining {
+		log.Println("Draining: refusing new peers")
+	}
+	if err := s.store.Flush(); err != nil {
+		return fmt.Errorf("failed to flush peer store: %w", err)
+	}
+	return nil
+}
+
+// Undrain accepts new peers again
+func (s *Server) Undrain() {
+	s.mu.Lock()
+	wasDraining := s.draining
+	s.draining = false
+	s.mu.Unlock()
+
+	if wasDraining {
+		log.Println("Drain ended: accepting new peers")
+	}
+}
+
+// Draining reports whether the server is refusing new peers
+func (s *Server) Draining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+// refusesNewPeer reports whether a registration must be refused because the
+// server is draining and the key is not a known peer's. Callers must hold
+// s.mu.
+func (s *Server) refusesNewPeer(publicKey string) bool {
+	if !s.draining {
+		return false
+	}
+	_, known := s.peersByKey[publicKey]
+	return !known
+}
+
+// writeRetryLater answers a registration refused while draining. The status
+// is 503 so that clients with several server addresses try the next one.
+func writeRetryLater(w http.ResponseWriter, resp protocol.RegisterResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(DrainRetryAfter/time.Second)))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleHealthz reports readiness: 200 while the server accepts new peers,
+// 503 while it drains. It takes the server mutex, so a wedged server does
+// not answer at all.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, code := "ok", http.StatusOK
+	if s.Draining() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+	})
+}
+
+// handleAdminDrain reports drain mode, enters it on POST and leaves it on
+// DELETE
+func (s *Server) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.Drain(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case http.MethodDelete:
+		s.Undrain()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"draining": s.Draining(),
+	})
+}
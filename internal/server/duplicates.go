@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// Policies for peers left behind by a machine that registered again with a
+// new key, see duplicate_peers
+const (
+	DuplicatePeersKeep               = ""                     // Leave them to expire as usual
+	DuplicatePeersHostname           = "hostname"             // Remove offline peers with the same hostname
+	DuplicatePeersHostnameAndAddress = "hostname_and_address" // Also require the same observed public IP
+)
+
+// duplicateOf reports whether old is a stale earlier registration of the
+// machine behind peer under the duplicate_peers policy. Only offline peers
+// can be: an online peer with the same hostname is another machine. Suspended
+// peers and the server's own peer are never duplicates, so that removing
+// one cannot undo an administrator's decision.
+func (s *Server) duplicateOf(peer, old *Peer) bool {
+	if old.ID == peer.ID || old.PublicKey == peer.PublicKey || old.ID == ServerPeerID {
+		return false
+	}
+	if old.Online || old.Status == PeerStatusSuspended {
+		return false
+	}
+	if peer.Hostname == "" || !strings.EqualFold(old.Hostname, peer.Hostname) {
+		return false
+	}
+	if s.config.DuplicatePeers == DuplicatePeersHostnameAndAddress {
+		observed := observedHost(peer.ObservedAddr)
+		return observed != "" && observed == observedHost(old.ObservedAddr)
+	}
+	return true
+}
+
+// removeDuplicatesLocked removes the peers that peer replaces under the
+// duplicate_peers policy, releasing their addresses and recording each in the
+// audit log first. Pending peers replace nothing until they are approved. Callers
+// must hold s.mu.
+func (s *Server) removeDuplicatesLocked(peer *Peer) {
+	if s.config.DuplicatePeers == DuplicatePeersKeep || peer.Status != PeerStatusActive {
+		return
+	}
+
+	now := time.Now()
+	for _, old := range s.peers {
+		if !s.duplicateOf(peer, old) {
+			continue
+		}
+
+		detail := fmt.Sprintf("replaced by peer %s (%s) registered with a new key", peer.ID, crypto.Fingerprint(peer.PublicKey))
+		if old.LastSeen != nil {
+			detail += fmt.Sprintf("; last seen %s", old.LastSeen.UTC().Format(time.RFC3339))
+		}
+		if err := s.audit.Record(AuditEntry{
+			Time:        now,
+			Action:      AuditDuplicateRemoved,
+			PeerID:      old.ID,
+			Hostname:    old.Hostname,
+			Fingerprint: crypto.Fingerprint(old.PublicKey),
+			VirtualIP:   old.VirtualIP,
+			Detail:      detail,
+		}); err != nil {
+			// Every removal must be accounted for, so without the
+			// audit log the peer is left to expire as usual
+			log.Printf("Keeping duplicate peer %s: %v", old.ID, err)
+			continue
+		}
+		log.Printf("Peer %s (%s) at %s is a duplicate: %s", old.ID, old.Hostname, old.VirtualIP, detail)
+		s.removePeerLocked(old, OfflineDuplicate)
+	}
+}
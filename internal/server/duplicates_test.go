@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+func TestDuplicateOf(t *testing.T) {
+	peer := &Peer{ID: "new", PublicKey: "new-key", Hostname: "Laptop", ObservedAddr: "203.0.113.7:40000"}
+
+	tests := []struct {
+		name   string
+		policy string
+		old    Peer
+		want   bool
+	}{
+		{"same hostname offline", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop"}, true},
+		{"online", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop", Online: true}, false},
+		{"other hostname", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "desktop"}, false},
+		{"hostname prefix", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop2"}, false},
+		{"suspended", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop", Status: PeerStatusSuspended}, false},
+		{"pending", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop", Status: PeerStatusPending}, true},
+		{"server peer", DuplicatePeersHostname,
+			Peer{ID: ServerPeerID, PublicKey: "old-key", Hostname: "laptop"}, false},
+		{"itself", DuplicatePeersHostname,
+			Peer{ID: "new", PublicKey: "new-key", Hostname: "laptop"}, false},
+		{"same key", DuplicatePeersHostname,
+			Peer{ID: "old", PublicKey: "new-key", Hostname: "laptop"}, false},
+		{"same address", DuplicatePeersHostnameAndAddress,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop", ObservedAddr: "203.0.113.7:51820"}, true},
+		{"other address", DuplicatePeersHostnameAndAddress,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop", ObservedAddr: "198.51.100.1:51820"}, false},
+		{"no address", DuplicatePeersHostnameAndAddress,
+			Peer{ID: "old", PublicKey: "old-key", Hostname: "laptop"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultServerConfig()
+			cfg.DuplicatePeers = tt.policy
+			s := &Server{config: cfg}
+			old := tt.old
+			if got := s.duplicateOf(peer, &old); got != tt.want {
+				t.Errorf("duplicateOf = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A peer without a hostname matches nothing
+	s := &Server{config: config.DefaultServerConfig()}
+	if s.duplicateOf(&Peer{ID: "new", PublicKey: "new-key"}, &Peer{ID: "old", PublicKey: "old-key"}) {
+		t.Error("peers without hostnames are duplicates")
+	}
+}
+
+func TestRegisterRemovesDuplicates(t *testing.T) {
+	s := newTestServer(t)
+	s.config.DuplicatePeers = DuplicatePeersHostname
+	handler := s.Handler()
+
+	register := func(hostname string) string {
+		t.Helper()
+		code, resp, err := postRegister(handler, newRegisterRequest(t, hostname))
+		if err != nil || code != http.StatusOK || !resp.Success {
+			t.Fatalf("register %s: status %d: %v %s", hostname, code, err, resp.Error)
+		}
+		return resp.PeerID
+	}
+	setOnline := func(id string, online bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.peers[id].Online = online
+	}
+	registered := func(id string) bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, ok := s.peers[id]
+		return ok
+	}
+
+	stale := register("laptop")
+	live := register("desktop")
+	other := register("phone")
+	setOnline(stale, false)
+	setOnline(other, false)
+
+	// The laptop reinstalled: a new key with the old hostname
+	register("LAPTOP")
+	if registered(stale) {
+		t.Error("stale registration of the laptop was kept")
+	}
+	if !registered(other) {
+		t.Error("offline peer with another hostname was removed")
+	}
+
+	// An online peer with the same hostname is another machine
+	register("desktop")
+	if !registered(live) {
+		t.Error("online peer with the same hostname was removed")
+	}
+	if err := s.CheckInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"log"
+	"net"
+	"slices"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Values of observed_endpoint
+const (
+	ObservedEndpointPrefer = "prefer" // Default
+	ObservedEndpointIgnore = "ignore"
+)
+
+// maxHandshakeReports bounds the handshakes one heartbeat may report
+const maxHandshakeReports = 1024
+
+// RejectedEndpoint is an endpoint a peer advertised that the server does not
+// pass on to other peers, and why
+type RejectedEndpoint struct {
+	Endpoint string `json:"endpoint"`
+	Reason   string `json:"reason"`
+}
+
+// endpointRejection returns why no other peer could reach an endpoint, or
+// "" if one might. DNS names are left to the peers to resolve.
+func endpointRejection(endpoint string, mesh *net.IPNet) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsUnspecified():
+		return "unspecified address"
+	case ip.IsLoopback():
+		return "loopback address"
+	case ip.IsLinkLocalUnicast():
+		return "link-local address"
+	case ip.IsMulticast():
+		return "multicast address"
+	case mesh != nil && mesh.Contains(ip):
+		return "inside the mesh network " + mesh.String()
+	}
+	return ""
+}
+
+// screenEndpoints returns the canonical endpoints a peer advertised that
+// other peers could use, and the ones it drops. With observed_endpoint
+// "prefer", the default, a public endpoint that disagrees with the public
+// address the request came from is taken to be a stale NAT mapping: when
+// none of the peer's endpoints of that family has the observed IP, the
+// first one is replaced with the observed IP and its port. Private
+// endpoints are kept, since peers behind the same NAT use them.
+func (s *Server) screenEndpoints(endpoints []string, observedAddr string) ([]string, []RejectedEndpoint) {
+	var mesh *net.IPNet
+	if _, network, err := net.ParseCIDR(s.config.NetworkCIDR); err == nil {
+		mesh = network
+	}
+
+	var kept []string
+	var rejected []RejectedEndpoint
+	for _, endpoint := range endpoints {
+		if reason := endpointRejection(endpoint, mesh); reason != "" {
+			rejected = append(rejected, RejectedEndpoint{Endpoint: endpoint, Reason: reason})
+			continue
+		}
+		kept = append(kept, endpoint)
+	}
+
+	observed := net.ParseIP(observedHost(observedAddr))
+	if s.config.ObservedEndpoint == ObservedEndpointIgnore || observed == nil || !publicIP(observed) {
+		return kept, rejected
+	}
+
+	stale := -1
+	for i, endpoint := range kept {
+		host, _, _ := net.SplitHostPort(endpoint)
+		ip := net.ParseIP(host)
+		if ip == nil || !publicIP(ip) || (ip.To4() == nil) != (observed.To4() == nil) {
+			continue
+		}
+		if ip.Equal(observed) {
+			return kept, rejected
+		}
+		if stale < 0 {
+			stale = i
+		}
+	}
+	if stale < 0 {
+		return kept, rejected
+	}
+
+	_, port, _ := net.SplitHostPort(kept[stale])
+	replacement := net.JoinHostPort(observed.String(), port)
+	rejected = append(rejected, RejectedEndpoint{Endpoint: kept[stale], Reason: "request came from " + observed.String()})
+	if slices.Contains(kept, replacement) {
+		kept = slices.Delete(kept, stale, stale+1)
+	} else {
+		kept[stale] = replacement
+	}
+	return kept, rejected
+}
+
+// publicIP reports whether ip is a global address outside the private
+// ranges
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// recordRejectedEndpoints stores the endpoints the server dropped from a
+// peer's last advertisement, logging them when they change so that clients
+// with broken endpoint detection can be found without flooding the log on
+// every heartbeat
+func recordRejectedEndpoints(peer *Peer, rejected []RejectedEndpoint) {
+	if slices.Equal(rejected, peer.RejectedEndpoints) {
+		return
+	}
+	for _, entry := range rejected {
+		log.Printf("Rejected endpoint %s of peer %s (%s): %s", entry.Endpoint, peer.ID, peer.Hostname, entry.Reason)
+	}
+	peer.RejectedEndpoints = rejected
+}
+
+// updateEndpointsLocked applies the endpoints a heartbeat advertised and
+// reports whether other peers' lists change. A change only takes effect once
+// the next heartbeat advertises the same endpoints, or another peer reports
+// a handshake with one of them; until then it is pending, and other peers
+// keep the endpoints they have. A peer without endpoints takes new ones at
+// once. Callers must hold s.mu.
+func (s *Server) updateEndpointsLocked(peer *Peer, endpoints []string) bool {
+	switch {
+	case len(endpoints) == 0:
+		return false
+	case sameEndpoints(endpoints, peer.Endpoints):
+		peer.PendingEndpoints = nil
+		return false
+	case len(peer.Endpoints) > 0 && !sameEndpoints(endpoints, peer.PendingEndpoints):
+		peer.PendingEndpoints = endpoints
+		return false
+	}
+
+	if len(peer.Endpoints) > 0 {
+		log.Printf("Peer %s (%s) moved from %s to %s", peer.ID, peer.Hostname, peer.Endpoint, endpoints[0])
+	}
+	peer.Endpoint, peer.Endpoints = endpoints[0], endpoints
+	peer.PendingEndpoints = nil
+	return true
+}
+
+// confirmEndpointsLocked applies the pending endpoints of the peers a
+// reporter had handshakes with at one of them: the handshake shows the peer
+// is reachable there. It reports whether any peer's endpoints changed.
+// Callers must hold s.mu.
+func (s *Server) confirmEndpointsLocked(reporter *Peer, handshakes []protocol.HandshakeReport) bool {
+	changed := false
+	for _, report := range handshakes {
+		peerID, ok := s.peersByKey[report.PublicKey]
+		if !ok || peerID == reporter.ID {
+			continue
+		}
+		peer := s.peers[peerID]
+		endpoint, err := protocol.CanonicalEndpoint(report.Endpoint)
+		if err != nil || !slices.Contains(peer.PendingEndpoints, endpoint) {
+			continue
+		}
+
+		log.Printf("Peer %s (%s) moved from %s to %s, confirmed by a handshake from %s", peer.ID, peer.Hostname, peer.Endpoint, peer.PendingEndpoints[0], reporter.ID)
+		peer.Endpoint, peer.Endpoints = peer.PendingEndpoints[0], peer.PendingEndpoints
+		peer.PendingEndpoints = nil
+		s.store.SavePeer(peer)
+		s.publishPeer(EventPeerUpdated, peer)
+		changed = true
+	}
+	return changed
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// DefaultEphemeralTimeout is how long an ephemeral peer may stay silent
+// before it is removed, unless ServerConfig.EphemeralTimeout says otherwise
+const DefaultEphemeralTimeout = 5 * time.Minute
+
+// PeerCounts summarizes the peer table for the admin API
+type PeerCounts struct {
+	Total      int `json:"total"`
+	Online     int `json:"online"`
+	Ephemeral  int `json:"ephemeral"`
+	Persistent int `json:"persistent"`
+}
+
+// ephemeralTimeout returns the offline grace period of ephemeral peers
+func (s *Server) ephemeralTimeout() time.Duration {
+	if s.config.EphemeralTimeout > 0 {
+		return time.Duration(s.config.EphemeralTimeout) * time.Second
+	}
+	return DefaultEphemeralTimeout
+}
+
+// cleanupInterval returns how often stale peers are looked for. It is
+// shortened when the ephemeral grace period or the heartbeat timeout is
+// shorter than two minutes, so that peers do not linger much past them.
+func (s *Server) cleanupInterval() time.Duration {
+	interval := CleanupInterval
+	if half := s.ephemeralTimeout() / 2; half < interval {
+		interval = half
+	}
+	if half := s.heartbeatTimeout() / 2; half < interval {
+		interval = half
+	}
+	return interval
+}
+
+// heartbeatTimeout returns how long a peer may go without a heartbeat
+// before it is marked offline
+func (s *Server) heartbeatTimeout() time.Duration {
+	if s.config.HeartbeatTimeout > 0 {
+		return time.Duration(s.config.HeartbeatTimeout) * time.Second
+	}
+	return HeartbeatTimeout
+}
+
+// forcedEphemeral reports whether the auth key a peer registered with makes
+// it ephemeral, in which case the client cannot turn the flag off
+func (s *Server) forcedEphemeral(peer *Peer) bool {
+	if peer.AuthKeyID == "" {
+		return false
+	}
+	key, ok := s.authKeys.Get(peer.AuthKeyID)
+	return ok && key.Ephemeral
+}
+
+// countPeers summarizes the peer table. Callers must hold s.mu.
+func (s *Server) countPeers() PeerCounts {
+	counts := PeerCounts{Total: len(s.peers)}
+	for _, peer := range s.peers {
+		if peer.Online {
+			counts.Online++
+		}
+		if peer.Ephemeral {
+			counts.Ephemeral++
+		} else {
+			counts.Persistent++
+		}
+	}
+	return counts
+}
+
+// handleUnregister handles a client leaving the mesh
+func (s *Server) handleUnregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req protocol.UnregisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := protocol.ValidateID(req.PeerID); err != nil {
+		writeInvalidParameter(w, "peer_id", err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.unregister(&req))
+}
+
+// unregister removes an ephemeral peer at once. A persistent peer is only
+// marked offline; it keeps its identity and address for its return.
+func (s *Server) unregister(req *protocol.UnregisterRequest) protocol.UnregisterResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The public key stops anyone who merely learned a peer ID from
+	// disconnecting it
+	peer, exists := s.peers[req.PeerID]
+	if !exists || peer.PublicKey != req.PublicKey || peer.ID == ServerPeerID {
+		return protocol.UnregisterResponse{
+			Success: false,
+			Error:   "Peer not found",
+		}
+	}
+
+	if peer.Ephemeral {
+		log.Printf("Ephemeral peer %s (%s) left", peer.ID, peer.Hostname)
+		s.removePeerLocked(peer, OfflineUnregistered)
+		return protocol.UnregisterResponse{Success: true}
+	}
+
+	if setOffline(peer, OfflineUnregistered, time.Now()) {
+		s.refreshConflicts(peer)
+		s.publishPeer(EventPeerOffline, peer)
+		log.Printf("Peer %s (%s) left", peer.ID, peer.Hostname)
+	}
+	// An offline peer still records that it left, so that a late heartbeat
+	// does not bring it back
+	s.store.SavePeer(peer)
+	return protocol.UnregisterResponse{Success: true}
+}
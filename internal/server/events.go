@@ -0,0 +1,130 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// Admin event types streamed to /admin/events subscribers
+const (
+	EventPeerRegistered = "peer_registered"
+	EventPeerUpdated    = "peer_updated"
+	EventPeerHeartbeat  = "peer_heartbeat"
+	EventPeerOffline    = "peer_offline"
+	EventPeerRemoved    = "peer_removed"
+	EventPeerApproved   = "peer_approved"
+	EventPeerSuspended  = "peer_suspended"
+)
+
+// adminSubscriberBuffer bounds how many events are queued for a slow admin
+// subscriber before new ones are dropped
+const adminSubscriberBuffer = 32
+
+// AdminEvent describes a change to the peer table
+type AdminEvent struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	PeerID      string    `json:"peer_id"`
+	Fingerprint string    `json:"fingerprint,omitempty"` // Of the peer's public key, for people to compare
+	Peer        *Peer     `json:"peer,omitempty"`
+	Version     uint64    `json:"version,omitempty"` // Peer list version after the change
+	Reason      string    `json:"reason,omitempty"`  // Why the peer is offline or was removed
+}
+
+// eventBroker fans admin events out to subscribers without blocking the
+// publisher
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan AdminEvent]bool // Whether the subscriber is lossless
+	closed      bool
+}
+
+// subscribe registers a new subscriber. The returned function unregisters it.
+func (b *eventBroker) subscribe() (<-chan AdminEvent, func()) {
+	return b.subscribeWith(adminSubscriberBuffer, false)
+}
+
+// subscribeLossless registers a subscriber that must see every event, such
+// as a replica. Instead of dropping events for it once its buffer is full,
+// the subscription is ended, so that it starts over from a full snapshot.
+func (b *eventBroker) subscribeLossless(buffer int) (<-chan AdminEvent, func()) {
+	return b.subscribeWith(buffer, true)
+}
+
+func (b *eventBroker) subscribeWith(buffer int, lossless bool) (<-chan AdminEvent, func()) {
+	ch := make(chan AdminEvent, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan AdminEvent]bool)
+	}
+	b.subscribers[ch] = lossless
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every subscription so that streaming handlers return and the
+// HTTP server can shut down
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish delivers an event to every subscriber that has room for it
+func (b *eventBroker) publish(event AdminEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch, lossless := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			if lossless {
+				log.Printf("Event subscriber fell behind by %d events; ending its subscription", cap(ch))
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// publishPeer publishes an event carrying a snapshot of the peer and, for
+// anything but a heartbeat, bumps the peer list version. Callers must hold
+// s.mu.
+func (s *Server) publishPeer(eventType string, peer *Peer) {
+	if eventType != EventPeerHeartbeat {
+		s.version++
+	}
+	s.events.publish(AdminEvent{
+		Type:        eventType,
+		Time:        time.Now(),
+		PeerID:      peer.ID,
+		Fingerprint: crypto.Fingerprint(peer.PublicKey),
+		Peer:        copyPeer(peer),
+		Version:     s.version,
+		Reason:      peer.OfflineReason,
+	})
+}
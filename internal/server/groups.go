@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// GroupUsage is the address utilization of one group's segment, or of the
+// space outside every segment for peers without a group
+type GroupUsage struct {
+	CIDR        string  `json:"cidr"`
+	Size        int     `json:"size"`
+	Allocated   int     `json:"allocated"`
+	Free        int     `json:"free"`
+	Utilization float64 `json:"utilization"`
+}
+
+// newIPAllocator creates the allocator for the network with a segment for
+// every configured group
+func newIPAllocator(networkCIDR string, groups map[string]string) (*network.IPAllocator, error) {
+	ipAllocator, err := network.NewIPAllocator(networkCIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted, so that a bad configuration is reported the same way each time
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !tagPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid group name %q: groups are matched against peer tags, so use lowercase letters, digits and hyphens", name)
+		}
+		if err := ipAllocator.AddSegment(name, groups[name]); err != nil {
+			return nil, fmt.Errorf("invalid group %s: %w", name, err)
+		}
+	}
+	return ipAllocator, nil
+}
+
+// peerGroup returns the group a new peer joins: the first of its tags that
+// names a group, or "" for the remaining space
+func (s *Server) peerGroup(tags []string) string {
+	for _, tag := range tags {
+		if _, ok := s.config.Groups[tag]; ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// allocatePeerIP allocates an address in the peer's group segment, or
+// outside every segment for peers without a group. Callers must hold s.mu.
+func (s *Server) allocatePeerIP(group string) (string, error) {
+	if group == "" {
+		return s.ipAllocator.AllocateIP()
+	}
+	return s.ipAllocator.AllocateIPIn(group)
+}
+
+// groupUsage reports the utilization of every group and of the remaining
+// space under "". Callers must hold s.mu.
+func (s *Server) groupUsage() map[string]GroupUsage {
+	usage := make(map[string]GroupUsage, len(s.config.Groups)+1)
+	for _, name := range append(s.ipAllocator.Segments(), "") {
+		size, used := s.ipAllocator.Usage(name)
+		group := GroupUsage{
+			CIDR:      s.ipAllocator.SegmentCIDR(name),
+			Size:      size,
+			Allocated: used,
+			Free:      size - used,
+		}
+		if size > 0 {
+			group.Utilization = float64(used) / float64(size)
+		}
+		usage[name] = group
+	}
+	return usage
+}
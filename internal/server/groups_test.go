@@ -0,0 +1,99 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+func TestNewIPAllocatorRejectsNestedGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups map[string]string
+	}{
+		{"nested", map[string]string{"servers": "10.100.1.0/24", "databases": "10.100.1.64/26"}},
+		{"same range", map[string]string{"servers": "10.100.1.0/24", "web": "10.100.1.0/24"}},
+		{"outside the network", map[string]string{"servers": "10.200.0.0/24"}},
+		{"bigger than the network", map[string]string{"servers": "10.0.0.0/8"}},
+		{"bad name", map[string]string{"Servers": "10.100.1.0/24"}},
+		{"bad range", map[string]string{"servers": "10.100.1.0/33"}},
+	}
+	for _, tt := range tests {
+		if _, err := newIPAllocator("10.100.0.0/16", tt.groups); err == nil {
+			t.Errorf("%s: groups %v accepted", tt.name, tt.groups)
+		}
+	}
+}
+
+func TestGroupExhaustion(t *testing.T) {
+	cfg := config.DefaultServerConfig()
+	cfg.NetworkCIDR = "10.100.0.0/24"
+	cfg.Groups = map[string]string{
+		"servers": "10.100.0.16/29",
+		"laptops": "10.100.0.32/28",
+	}
+	ipAllocator, err := newIPAllocator(cfg.NetworkCIDR, cfg.Groups)
+	if err != nil {
+		t.Fatalf("newIPAllocator: %v", err)
+	}
+	s := &Server{config: cfg, ipAllocator: ipAllocator}
+
+	if got := s.peerGroup([]string{"admin", "servers", "laptops"}); got != "servers" {
+		t.Errorf("peerGroup picked %q, want the first tag naming a group", got)
+	}
+	if got := s.peerGroup([]string{"admin"}); got != "" {
+		t.Errorf("peerGroup picked %q for tags naming no group", got)
+	}
+
+	// Exhaust the servers group: a /29 without its network and broadcast
+	// addresses holds six peers, all inside its range
+	_, servers, _ := net.ParseCIDR(cfg.Groups["servers"])
+	seen := make(map[string]bool)
+	for i := 0; i < 6; i++ {
+		ip, err := s.allocatePeerIP("servers")
+		if err != nil {
+			t.Fatalf("allocation %d in servers: %v", i+1, err)
+		}
+		if !servers.Contains(net.ParseIP(ip)) || ip == "10.100.0.16" || ip == "10.100.0.23" || seen[ip] {
+			t.Fatalf("servers got %s", ip)
+		}
+		seen[ip] = true
+	}
+	if ip, err := s.allocatePeerIP("servers"); err == nil {
+		t.Fatalf("exhausted servers group handed out %s", ip)
+	}
+
+	// The other group and the space outside the groups are unaffected
+	_, laptops, _ := net.ParseCIDR(cfg.Groups["laptops"])
+	ip, err := s.allocatePeerIP("laptops")
+	if err != nil || !laptops.Contains(net.ParseIP(ip)) {
+		t.Fatalf("laptops after servers ran out: %s %v", ip, err)
+	}
+	for i := 0; i < 20; i++ {
+		ip, err := s.allocatePeerIP("")
+		if err != nil {
+			t.Fatalf("ungrouped after servers ran out: %v", err)
+		}
+		if servers.Contains(net.ParseIP(ip)) || laptops.Contains(net.ParseIP(ip)) {
+			t.Fatalf("ungrouped peer got %s inside a group", ip)
+		}
+	}
+
+	usage := s.groupUsage()
+	if u := usage["servers"]; u.Size != 6 || u.Allocated != 6 || u.Free != 0 || u.Utilization != 1 {
+		t.Errorf("servers usage: %+v", u)
+	}
+	if u := usage["laptops"]; u.Size != 14 || u.Allocated != 1 || u.Free != 13 {
+		t.Errorf("laptops usage: %+v", u)
+	}
+	if u := usage[""]; u.Allocated != 20 || u.Free != u.Size-20 {
+		t.Errorf("ungrouped usage: %+v", u)
+	}
+
+	// Freeing an address in the group makes it available to the group again
+	ipAllocator.ReleaseIP("10.100.0.18")
+	if ip, err := s.allocatePeerIP("servers"); err != nil || ip != "10.100.0.18" {
+		t.Errorf("servers after a release: %s %v, want 10.100.0.18", ip, err)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import "log"
+
+// seesHiddenPeers reports whether hidden peers are in a peer's list. Hidden
+// peers themselves see everyone; other peers only when
+// hidden_peers_visible_to names their hostname or one of their tags. Callers
+// must hold s.mu.
+func (s *Server) seesHiddenPeers(peer *Peer) bool {
+	if peer.Hidden {
+		return true
+	}
+	return matchesAnySelector(s.config.HiddenPeersVisibleTo, peer)
+}
+
+// setPeerHidden hides a peer from, or shows it to, the rest of the mesh.
+// The version bump makes the other peers drop or add it on their next sync.
+func (s *Server) setPeerHidden(peerID string, hidden bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[peerID]
+	if !exists {
+		return errPeerNotFound
+	}
+	if peer.Hidden == hidden {
+		return nil
+	}
+
+	peer.Hidden = hidden
+	s.version++
+	s.store.SavePeer(peer)
+	s.publishPeer(EventPeerUpdated, peer)
+
+	if hidden {
+		log.Printf("Peer %s (%s) is now hidden", peer.ID, peer.Hostname)
+	} else {
+		log.Printf("Peer %s (%s) is no longer hidden", peer.ID, peer.Hostname)
+	}
+	return nil
+}
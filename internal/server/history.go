@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxPeerHistory bounds how many online/offline transitions are kept per peer
+const maxPeerHistory = 100
+
+// PeerHistory is the admin view of a peer's lifetime
+type PeerHistory struct {
+	PeerID        string           `json:"peer_id"`
+	CreatedAt     *time.Time       `json:"created_at,omitempty"`
+	ApprovedAt    *time.Time       `json:"approved_at,omitempty"`
+	LastSeen      *time.Time       `json:"last_seen,omitempty"`
+	Online        bool             `json:"online"`
+	OfflineReason string           `json:"offline_reason,omitempty"`
+	LastOnlineAt  *time.Time       `json:"last_online_at,omitempty"`
+	FlapsToday    int              `json:"flaps_today"` // Times the peer went offline in the last 24 hours
+	Transitions   []PeerTransition `json:"transitions"`
+}
+
+// Reasons a peer is offline, or was removed. Removed peers only carry
+// theirs in the peer_removed event.
+const (
+	OfflineHeartbeatTimeout = "HEARTBEAT_TIMEOUT" // Stopped sending heartbeats
+	OfflineUnregistered     = "UNREGISTERED"      // Left the network; only registering again brings it back
+	OfflineExpired          = "EXPIRED"           // Its ephemeral grace period or guest access ran out
+	OfflineSuspended        = "SUSPENDED"         // Suspended by an administrator or for exceeding its quota
+	OfflineBanned           = "BANNED"            // Removed by an administrator
+	OfflineDuplicate        = "DUPLICATE"         // Replaced by a new peer registering under its hostname
+)
+
+// markSeen records that a peer was heard from, noting a transition if it
+// was offline. Callers must hold s.mu.
+func markSeen(peer *Peer, now time.Time) {
+	peer.LastHeartbeat = now
+	peer.LastSeen = &now
+	peer.OfflineReason = ""
+	setOnline(peer, true, "", now)
+}
+
+// setOffline takes a peer offline for a reason and reports whether it was
+// online. A peer that is already offline keeps its reason, except that a
+// timeout is explained by a more specific one: a peer that left or was
+// suspended after it stopped answering. A timeout never replaces the reason
+// of a peer that left, was suspended or expired. Callers must hold s.mu.
+func setOffline(peer *Peer, reason string, now time.Time) bool {
+	if !peer.Online {
+		if reason != OfflineHeartbeatTimeout && (peer.OfflineReason == "" || peer.OfflineReason == OfflineHeartbeatTimeout) {
+			peer.OfflineReason = reason
+		}
+		return false
+	}
+
+	lastOnline := now
+	if reason == OfflineHeartbeatTimeout && !peer.LastHeartbeat.IsZero() {
+		lastOnline = peer.LastHeartbeat
+	}
+	peer.LastOnlineAt = &lastOnline
+	peer.OfflineReason = reason
+	setOnline(peer, false, reason, now)
+	return true
+}
+
+// setOnline updates a peer's online state, recording a transition when it
+// changes. Callers must hold s.mu.
+func setOnline(peer *Peer, online bool, reason string, now time.Time) {
+	if peer.Online == online && len(peer.History) > 0 {
+		return
+	}
+	peer.Online = online
+
+	peer.History = append(peer.History, PeerTransition{Online: online, Time: now, Reason: reason})
+	if len(peer.History) > maxPeerHistory {
+		peer.History = append([]PeerTransition(nil), peer.History[len(peer.History)-maxPeerHistory:]...)
+	}
+}
+
+// peerHistory builds the admin history view of a peer
+func peerHistory(peer *Peer, now time.Time) PeerHistory {
+	history := PeerHistory{
+		PeerID:        peer.ID,
+		CreatedAt:     peer.CreatedAt,
+		ApprovedAt:    peer.ApprovedAt,
+		LastSeen:      peer.LastSeen,
+		Online:        peer.Online,
+		OfflineReason: peer.OfflineReason,
+		LastOnlineAt:  peer.LastOnlineAt,
+		Transitions:   append([]PeerTransition{}, peer.History...),
+	}
+
+	for _, transition := range peer.History {
+		if !transition.Online && now.Sub(transition.Time) < 24*time.Hour {
+			history.FlapsToday++
+		}
+	}
+
+	return history
+}
+
+// handleAdminPeerHistory serves GET /admin/peers/{id}/history
+func (s *Server) handleAdminPeerHistory(w http.ResponseWriter, r *http.Request, peerID string) {
+	s.mu.RLock()
+	peer, exists := s.peers[peerID]
+	var history PeerHistory
+	if exists {
+		history = peerHistory(peer, time.Now())
+	}
+	s.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Peer not found", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
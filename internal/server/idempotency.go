@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+const (
+	// idempotencyTTL is how long a registration response is replayed to
+	// retries carrying the same idempotency key
+	idempotencyTTL = 5 * time.Minute
+
+	// maxIdempotencyEntries bounds the cache; the oldest entries are
+	// dropped first
+	maxIdempotencyEntries = 10000
+)
+
+// errIdempotencyKeyReused is returned for a request whose idempotency key
+// was first used with a different request
+var errIdempotencyKeyReused = errors.New("idempotency key was used with a different request")
+
+// idempotencyEntry is a cached registration response
+type idempotencyEntry struct {
+	digest   string // registrationDigest of the request
+	response protocol.RegisterResponse
+	expires  time.Time
+}
+
+// idempotencySlot is a place in the cache's expiry order. A slot whose
+// expiry no longer matches its entry's was left behind when the entry was
+// overwritten, and is skipped.
+type idempotencySlot struct {
+	key     string
+	expires time.Time
+}
+
+// registrationDigest identifies what a registration request asks for. The
+// endpoints are left out: a retry detects them afresh, and heartbeats keep
+// them current.
+func registrationDigest(req *protocol.RegisterRequest) string {
+	r := *req
+	r.Endpoint, r.Endpoints = "", nil
+	r.IdempotencyKey = ""
+	data, _ := json.Marshal(r)
+	return crypto.Digest(data)
+}
+
+// idempotencyCache remembers recent successful registrations by idempotency
+// key, so that a client retrying a request whose response it never received
+// gets the original answer instead of registering twice. Keys are scoped to
+// the public key that registered, so one client can neither replay nor
+// displace another's entry. Entries share one TTL, so insertion order is
+// expiry order. Callers must hold s.mu.
+type idempotencyCache struct {
+	entries map[string]idempotencyEntry // By scoped key
+	order   []idempotencySlot           // Oldest first
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{entries: make(map[string]idempotencyEntry)}
+}
+
+// scopedKey returns the cache key for an idempotency key sent by publicKey
+func scopedKey(key, publicKey string) string {
+	return publicKey + "\x00" + key
+}
+
+// get returns the cached response for key. A request with a cached key that
+// asks for something else than the first, by its digest, gets
+// errIdempotencyKeyReused.
+func (c *idempotencyCache) get(key, publicKey, digest string, now time.Time) (protocol.RegisterResponse, bool, error) {
+	if key == "" {
+		return protocol.RegisterResponse{}, false, nil
+	}
+	c.expire(now)
+
+	entry, ok := c.entries[scopedKey(key, publicKey)]
+	if !ok {
+		return protocol.RegisterResponse{}, false, nil
+	}
+	if entry.digest != digest {
+		return protocol.RegisterResponse{}, false, errIdempotencyKeyReused
+	}
+	return entry.response, true, nil
+}
+
+// put caches a response under key. Overwriting an entry restarts its TTL
+// and moves it to the back of the expiry order.
+func (c *idempotencyCache) put(key, publicKey, digest string, response protocol.RegisterResponse, now time.Time) {
+	if key == "" {
+		return
+	}
+	c.expire(now)
+
+	scoped := scopedKey(key, publicKey)
+	expires := now.Add(idempotencyTTL)
+	c.entries[scoped] = idempotencyEntry{digest: digest, response: response, expires: expires}
+	c.order = append(c.order, idempotencySlot{key: scoped, expires: expires})
+
+	for len(c.entries) > maxIdempotencyEntries {
+		c.drop()
+	}
+}
+
+// expire drops entries that have outlived their TTL
+func (c *idempotencyCache) expire(now time.Time) {
+	for len(c.order) > 0 {
+		slot := c.order[0]
+		entry, ok := c.entries[slot.key]
+		if ok && entry.expires.Equal(slot.expires) && now.Before(entry.expires) {
+			return
+		}
+		c.drop()
+	}
+}
+
+// drop removes the oldest slot, and its entry unless the entry was since
+// overwritten
+func (c *idempotencyCache) drop() {
+	slot := c.order[0]
+	if entry, ok := c.entries[slot.key]; ok && entry.expires.Equal(slot.expires) {
+		delete(c.entries, slot.key)
+	}
+	c.order[0] = idempotencySlot{}
+	c.order = c.order[1:]
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestIdempotencyCacheScopedByPublicKey(t *testing.T) {
+	c := newIdempotencyCache()
+	now := time.Now()
+
+	c.put("retry-1", "key-a", "digest-a", protocol.RegisterResponse{PeerID: "peer-a"}, now)
+	c.put("retry-1", "key-b", "digest-b", protocol.RegisterResponse{PeerID: "peer-b"}, now)
+
+	// Another client using the same key neither overwrote nor evicted the first
+	resp, ok, err := c.get("retry-1", "key-a", "digest-a", now)
+	if err != nil || !ok || resp.PeerID != "peer-a" {
+		t.Errorf("first client: %+v %v %v, want peer-a", resp, ok, err)
+	}
+	resp, ok, err = c.get("retry-1", "key-b", "digest-b", now)
+	if err != nil || !ok || resp.PeerID != "peer-b" {
+		t.Errorf("second client: %+v %v %v, want peer-b", resp, ok, err)
+	}
+
+	// Nor can it replay the other's response
+	if _, ok, err := c.get("retry-1", "key-c", "digest-a", now); ok || err != nil {
+		t.Errorf("third client got a cached response: %v %v", ok, err)
+	}
+	if _, _, err := c.get("retry-1", "key-a", "digest-b", now); err != errIdempotencyKeyReused {
+		t.Errorf("reuse with another digest: %v, want errIdempotencyKeyReused", err)
+	}
+}
+
+func TestIdempotencyCacheOverwriteRefreshesOrder(t *testing.T) {
+	c := newIdempotencyCache()
+	start := time.Now()
+
+	c.put("retry-1", "key-a", "digest", protocol.RegisterResponse{PeerID: "first"}, start)
+	c.put("retry-2", "key-a", "digest", protocol.RegisterResponse{PeerID: "other"}, start.Add(time.Minute))
+	overwritten := start.Add(2 * time.Minute)
+	c.put("retry-1", "key-a", "digest", protocol.RegisterResponse{PeerID: "second"}, overwritten)
+
+	// Past the original TTL the overwritten entry is still there, and the
+	// entry put after the original has gone
+	later := start.Add(idempotencyTTL + 90*time.Second)
+	if resp, ok, _ := c.get("retry-1", "key-a", "digest", later); !ok || resp.PeerID != "second" {
+		t.Errorf("overwritten entry: %+v %v, want second", resp, ok)
+	}
+	if _, ok, _ := c.get("retry-2", "key-a", "digest", later); ok {
+		t.Error("entry outlived its TTL")
+	}
+
+	if _, ok, _ := c.get("retry-1", "key-a", "digest", overwritten.Add(idempotencyTTL)); ok {
+		t.Error("overwritten entry outlived its own TTL")
+	}
+	if len(c.entries) != 0 || len(c.order) != 0 {
+		t.Errorf("%d entries and %d slots left after expiry", len(c.entries), len(c.order))
+	}
+}
+
+func TestIdempotencyCacheBounded(t *testing.T) {
+	c := newIdempotencyCache()
+	now := time.Now()
+
+	c.put("retry", "key-0", "digest", protocol.RegisterResponse{}, now)
+	// Overwriting an entry repeatedly does not grow the cache or evict others
+	for i := 0; i < 10; i++ {
+		c.put("retry", "key-0", "digest", protocol.RegisterResponse{}, now.Add(time.Duration(i)*time.Second))
+	}
+	for i := 1; i <= maxIdempotencyEntries; i++ {
+		c.put("retry", fmt.Sprintf("key-%d", i), "digest", protocol.RegisterResponse{}, now.Add(time.Minute))
+	}
+
+	if len(c.entries) != maxIdempotencyEntries {
+		t.Errorf("%d entries, want %d", len(c.entries), maxIdempotencyEntries)
+	}
+	if _, ok, _ := c.get("retry", "key-0", "digest", now.Add(time.Minute)); ok {
+		t.Error("oldest entry was not dropped")
+	}
+	if _, ok, _ := c.get("retry", "key-1", "digest", now.Add(time.Minute)); !ok {
+		t.Error("entry dropped before the oldest")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CheckInvariants verifies that the server's state is consistent: every
+// peer is indexed by its public key, no two peers share an address, each
+// address is allocated to its peer, and the peer store holds the same peers
+// as memory. A failure is a bug; simulations call it after each phase.
+func (s *Server) CheckInvariants() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var errs []error
+	addresses := make(map[string]string, len(s.peers))
+	for id, peer := range s.peers {
+		if peer.ID != id {
+			errs = append(errs, fmt.Errorf("peer %s is stored under ID %s", peer.ID, id))
+		}
+		if owner := s.peersByKey[peer.PublicKey]; owner != id {
+			errs = append(errs, fmt.Errorf("public key of peer %s is indexed to %q", id, owner))
+		}
+		if other, ok := addresses[peer.VirtualIP]; ok {
+			errs = append(errs, fmt.Errorf("peers %s and %s share address %s", other, id, peer.VirtualIP))
+		}
+		addresses[peer.VirtualIP] = id
+		if !s.ipAllocator.IsAllocated(peer.VirtualIP) {
+			errs = append(errs, fmt.Errorf("address %s of peer %s is not allocated", peer.VirtualIP, id))
+		}
+		if allocation, ok := s.allocations.Get(peer.VirtualIP); !ok || allocation.Owner != id {
+			errs = append(errs, fmt.Errorf("allocation table does not give %s to peer %s", peer.VirtualIP, id))
+		}
+	}
+	for key, id := range s.peersByKey {
+		if peer, ok := s.peers[id]; !ok || peer.PublicKey != key {
+			errs = append(errs, fmt.Errorf("public key %s is indexed to missing peer %s", key, id))
+		}
+	}
+
+	// The store is updated under s.mu along with memory
+	stored, err := s.store.LoadPeers()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to read peer store: %w", err))
+	}
+	if len(stored) != len(s.peers) {
+		errs = append(errs, fmt.Errorf("peer store holds %d peers, memory %d", len(stored), len(s.peers)))
+	}
+	for _, peer := range stored {
+		current, ok := s.peers[peer.ID]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("stored peer %s is not in memory", peer.ID))
+		case current.PublicKey != peer.PublicKey || current.VirtualIP != peer.VirtualIP || current.Status != peer.Status:
+			errs = append(errs, fmt.Errorf("stored peer %s differs from memory", peer.ID))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// GuestTag is the tag invites give their peers unless told otherwise
+const GuestTag = "guest"
+
+// GuestAccess are the terms of an invite: time-limited access for a guest
+// device, stamped onto each peer registering with the invite's key
+type GuestAccess struct {
+	Duration     int      `json:"duration"`                 // Seconds each peer keeps access after registering
+	UseExitNodes bool     `json:"use_exit_nodes,omitempty"` // Give peers the routes through exit nodes
+	Destinations []string `json:"destinations,omitempty"`   // Selectors of the peers guests may connect with; empty is any
+	Note         string   `json:"note,omitempty"`           // Who the invite is for
+}
+
+// Invite is an invite as listed by the admin API: its key's record and the
+// peers that joined with it and still have access
+type Invite struct {
+	AuthKey
+	Peers []string `json:"peers"`
+}
+
+// normalize checks the terms and returns a copy with trimmed destinations
+func (g *GuestAccess) normalize() (*GuestAccess, error) {
+	if g.Duration <= 0 {
+		return nil, fmt.Errorf("invalid guest access duration %d", g.Duration)
+	}
+	normalized := *g
+	normalized.Destinations = nil
+	for _, selector := range g.Destinations {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		if tag, ok := strings.CutPrefix(selector, "tag:"); ok && !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid destination %q", selector)
+		}
+		normalized.Destinations = append(normalized.Destinations, selector)
+	}
+	return &normalized, nil
+}
+
+// grantGuestAccess stamps an invite's terms onto a peer registering with it
+func grantGuestAccess(peer *Peer, guest *GuestAccess, now time.Time) {
+	expiresAt := now.Add(time.Duration(guest.Duration) * time.Second)
+	peer.ExpiresAt = &expiresAt
+	peer.Destinations = append([]string(nil), guest.Destinations...)
+	peer.ExitRoutesDenied = !guest.UseExitNodes
+}
+
+// guestAccessExpired reports whether a guest peer's access has run out
+func guestAccessExpired(peer *Peer, now time.Time) bool {
+	return peer.ExpiresAt != nil && !now.Before(*peer.ExpiresAt)
+}
+
+// reachable reports whether guest destinations let two peers connect. A
+// peer limited to destinations connects only with the peers they select,
+// in both directions, so the others do not accept its traffic either.
+func reachable(a, b *Peer) bool {
+	return (len(a.Destinations) == 0 || matchesAnySelector(a.Destinations, b)) &&
+		(len(b.Destinations) == 0 || matchesAnySelector(b.Destinations, a))
+}
+
+// invites returns the invites with the peers registered from each. Callers
+// must hold s.mu.
+func (s *Server) invites() []Invite {
+	invites := []Invite{}
+	for _, key := range s.authKeys.List() {
+		if key.Guest == nil {
+			continue
+		}
+		key.Hash = ""
+		invite := Invite{AuthKey: key, Peers: []string{}}
+		for _, peer := range s.peers {
+			if peer.AuthKeyID == key.ID {
+				invite.Peers = append(invite.Peers, peer.ID)
+			}
+		}
+		sort.Strings(invite.Peers)
+		invites = append(invites, invite)
+	}
+	return invites
+}
+
+// revokeInvite revokes an invite's key and removes the peers that joined
+// with it, ending their access before it would expire
+func (s *Server) revokeInvite(id string) error {
+	key, ok := s.authKeys.Get(id)
+	if !ok || key.Guest == nil {
+		return fmt.Errorf("%w: %s", errAuthKeyNotFound, id)
+	}
+	if err := s.authKeys.Revoke(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, peer := range s.peers {
+		if peer.AuthKeyID == id {
+			log.Printf("Guest access of peer %s (%s) revoked with invite %s", peer.ID, peer.Hostname, id)
+			s.removePeerLocked(peer, OfflineBanned)
+		}
+	}
+	return nil
+}
+
+// handleAdminInvites lists invites (GET) or creates one (POST). The key is
+// only ever returned by the POST.
+func (s *Server) handleAdminInvites(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		invites := s.invites()
+		s.mu.RUnlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"invites": invites,
+		})
+	case http.MethodPost:
+		var req struct {
+			ExpiresIn    int      `json:"expires_in"` // Seconds of access for each peer
+			ValidFor     int      `json:"valid_for"`  // Seconds the key may be used; defaults to expires_in
+			MaxUses      int      `json:"max_uses"`
+			Tags         []string `json:"tags"` // Defaults to GuestTag
+			UseExitNodes bool     `json:"use_exit_nodes"`
+			Destinations []string `json:"destinations"`
+			Note         string   `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresIn <= 0 {
+			writeInvalidParameter(w, "expires_in", fmt.Errorf("must be positive"))
+			return
+		}
+		if req.ValidFor == 0 {
+			req.ValidFor = req.ExpiresIn
+		}
+		if len(req.Tags) == 0 {
+			req.Tags = []string{GuestTag}
+		}
+
+		key, record, err := s.authKeys.Create(AuthKeyOptions{
+			Expires: time.Duration(req.ValidFor) * time.Second,
+			MaxUses: req.MaxUses,
+			Tags:    req.Tags,
+			Guest: &GuestAccess{
+				Duration:     req.ExpiresIn,
+				UseExitNodes: req.UseExitNodes,
+				Destinations: req.Destinations,
+				Note:         req.Note,
+			},
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		record.Hash = ""
+		log.Printf("Created invite %s for %s of access", record.ID, time.Duration(req.ExpiresIn)*time.Second)
+
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"key":    key,
+			"invite": Invite{AuthKey: record, Peers: []string{}},
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminInvite revokes an invite: DELETE /admin/invites/{id}. Unlike
+// revoking a plain auth key, the peers that joined with it are removed.
+func (s *Server) handleAdminInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/invites/")
+	if err := protocol.ValidateID(id); err != nil {
+		writeInvalidParameter(w, "invite ID", err)
+		return
+	}
+	if err := s.revokeInvite(id); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// errLimitExceeded refuses a new peer beyond max_peers or an auth key's
+// limits
+var errLimitExceeded = errors.New("limit exceeded")
+
+// PeerLimits reports the peer limits with the peers counted against them
+type PeerLimits struct {
+	MaxPeers    int            `json:"max_peers"` // Zero means unlimited
+	ActivePeers int            `json:"active_peers"`
+	AuthKeys    []AuthKeyLimit `json:"auth_keys"`
+}
+
+// AuthKeyLimit is the usage of an auth key that has limits
+type AuthKeyLimit struct {
+	ID             string `json:"id"`
+	MaxUses        int    `json:"max_uses"`
+	Uses           int    `json:"uses"`
+	MaxActivePeers int    `json:"max_active_peers"`
+	ActivePeers    int    `json:"active_peers"`
+}
+
+// countsTowardLimits reports whether a peer holds a place under the peer
+// limits. Every registered peer does, online or not, pending or suspended,
+// since it keeps its address: only removing it frees the place. Ephemeral
+// peers count until their grace period ends and they are removed. A guest
+// whose access ran out stops counting at once, before the cleanup removes
+// it, and the server's own peer never counts.
+func countsTowardLimits(peer *Peer, now time.Time) bool {
+	return peer.ID != ServerPeerID && !guestAccessExpired(peer, now)
+}
+
+// checkPeerLimitsLocked refuses a new peer when the network or the auth key
+// it registers with is at its limit. Peers the new one replaces under the
+// duplicate_peers policy are not counted, since registering removes them.
+// It runs in the same critical section as the address allocation, so that
+// concurrent registrations cannot overshoot. Callers must hold s.mu.
+func (s *Server) checkPeerLimitsLocked(peer *Peer, authKey *AuthKey, now time.Time) error {
+	maxPeers := s.config.MaxPeers
+	maxKeyPeers := 0
+	if authKey != nil {
+		maxKeyPeers = authKey.MaxActivePeers
+	}
+	if maxPeers <= 0 && maxKeyPeers <= 0 {
+		return nil
+	}
+
+	replaces := s.config.DuplicatePeers != DuplicatePeersKeep && peer.Status == PeerStatusActive
+	var total, withKey int
+	for _, other := range s.peers {
+		if !countsTowardLimits(other, now) || (replaces && s.duplicateOf(peer, other)) {
+			continue
+		}
+		total++
+		if authKey != nil && other.AuthKeyID == authKey.ID {
+			withKey++
+		}
+	}
+
+	if maxPeers > 0 && total >= maxPeers {
+		return fmt.Errorf("%w: the network has reached its maximum of %d peers", errLimitExceeded, maxPeers)
+	}
+	if maxKeyPeers > 0 && withKey >= maxKeyPeers {
+		return fmt.Errorf("%w: auth key %s has reached its maximum of %d active peers", errLimitExceeded, authKey.ID, maxKeyPeers)
+	}
+	return nil
+}
+
+// peerLimitsLocked counts the peers against the limits. Auth keys without
+// limits and revoked ones are left out. Callers must hold s.mu.
+func (s *Server) peerLimitsLocked(now time.Time) PeerLimits {
+	limits := PeerLimits{MaxPeers: s.config.MaxPeers, AuthKeys: []AuthKeyLimit{}}
+	byKey := make(map[string]int)
+	for _, peer := range s.peers {
+		if !countsTowardLimits(peer, now) {
+			continue
+		}
+		limits.ActivePeers++
+		if peer.AuthKeyID != "" {
+			byKey[peer.AuthKeyID]++
+		}
+	}
+
+	for _, key := range s.authKeys.List() {
+		if key.RevokedAt != nil || (key.MaxUses <= 0 && key.MaxActivePeers <= 0) {
+			continue
+		}
+		limits.AuthKeys = append(limits.AuthKeys, AuthKeyLimit{
+			ID:             key.ID,
+			MaxUses:        key.MaxUses,
+			Uses:           key.Uses,
+			MaxActivePeers: key.MaxActivePeers,
+			ActivePeers:    byKey[key.ID],
+		})
+	}
+	sort.Slice(limits.AuthKeys, func(i, j int) bool { return limits.AuthKeys[i].ID < limits.AuthKeys[j].ID })
+	return limits
+}
+
+// handleAdminLimits reports the peer limits and how much of each is used
+func (s *Server) handleAdminLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	limits := s.peerLimitsLocked(time.Now())
+	s.mu.RUnlock()
+
+	json.NewEncoder(w).Encode(limits)
+}
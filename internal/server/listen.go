@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// socketMode lets a reverse proxy in the server's group reach the socket
+const socketMode = 0660
+
+// listen opens the configured listener: a TCP address or a unix socket path
+func (s *Server) listen() (net.Listener, error) {
+	switch s.config.ListenNetwork {
+	case "", "tcp":
+		return net.Listen("tcp", s.config.ListenAddr)
+	case "unix":
+		path := s.config.ListenAddr
+
+		// Remove a socket left behind by an unclean exit, but never clobber
+		// a regular file that happens to live at the path
+		if info, err := os.Lstat(path); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("listen address %s exists and is not a socket", path)
+			}
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("socket %s is in use by another process", path)
+			}
+			os.Remove(path)
+		}
+
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, socketMode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+		return listener, nil
+	default:
+		return nil, fmt.Errorf("unsupported listen network %q (want tcp or unix)", s.config.ListenNetwork)
+	}
+}
+
+// parseTrustedProxies parses the configured trusted proxy CIDRs. Bare
+// addresses are accepted as single-host prefixes.
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, prefix, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies, nil
+}
+
+// isTrustedProxy reports whether ip belongs to a configured trusted proxy
+func (s *Server) isTrustedProxy(ip net.IP) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent a request. Forwarding
+// headers are honored only when the direct peer is a trusted proxy, so they
+// cannot be spoofed by clients connecting directly. Requests arriving over a
+// unix socket always come from the local reverse proxy.
+func (s *Server) clientIP(r *http.Request) string {
+	remote := remoteIP(r)
+	fromProxy := remote == nil && s.config.ListenNetwork == "unix"
+	if remote != nil {
+		fromProxy = s.isTrustedProxy(remote)
+	}
+
+	if !fromProxy {
+		if remote == nil {
+			return ""
+		}
+		return remote.String()
+	}
+
+	// Walk X-Forwarded-For from the right, skipping our own proxies; the
+	// first untrusted hop is the client
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			if i == 0 || !s.isTrustedProxy(ip) {
+				return ip.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	if remote == nil {
+		return ""
+	}
+	return remote.String()
+}
+
+// remoteIP returns the address of the directly connected peer, or nil for
+// unix socket connections
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		network   string // Listen network, "tcp" when empty
+		remote    string
+		forwarded []string // X-Forwarded-For headers
+		realIP    string
+		want      string
+	}{
+		{name: "direct client", remote: "203.0.113.7:4000", want: "203.0.113.7"},
+		{name: "spoofed forwarded-for from untrusted remote", remote: "203.0.113.7:4000",
+			forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "spoofed real-ip from untrusted remote", remote: "203.0.113.7:4000",
+			realIP: "198.51.100.1", want: "203.0.113.7"},
+		{name: "untrusted remote next to a trusted one", remote: "192.0.2.11:4000",
+			forwarded: []string{"198.51.100.1"}, want: "192.0.2.11"},
+		{name: "trusted proxy", remote: "10.1.2.3:4000",
+			forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "trusted single-host proxy", remote: "192.0.2.10:4000",
+			realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted ipv6 proxy", remote: "[2001:db8::1]:4000",
+			forwarded: []string{"2001:db8:ffff::5"}, want: "2001:db8:ffff::5"},
+		{name: "client prepends a spoofed hop", remote: "10.1.2.3:4000",
+			forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remote: "10.1.2.3:4000",
+			forwarded: []string{"198.51.100.1, 10.9.9.9", "10.5.5.5"}, want: "198.51.100.1"},
+		{name: "only trusted hops", remote: "10.1.2.3:4000",
+			forwarded: []string{"10.9.9.9"}, want: "10.9.9.9"},
+		{name: "garbage hop falls back to real-ip", remote: "10.1.2.3:4000",
+			forwarded: []string{"not-an-ip"}, realIP: "198.51.100.1", want: "198.51.100.1"},
+		{name: "trusted proxy without headers", remote: "10.1.2.3:4000", want: "10.1.2.3"},
+		{name: "unix socket", network: "unix", remote: "@",
+			forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "unix socket without headers", network: "unix", remote: "@", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultServerConfig()
+			cfg.ListenNetwork = tt.network
+			s := &Server{config: cfg, trustedProxies: proxies}
+
+			r := httptest.NewRequest(http.MethodGet, "/peers", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	for _, entry := range []string{"proxy.example.com", "10.0.0.0/33", ""} {
+		if _, err := parseTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", entry)
+		}
+	}
+}
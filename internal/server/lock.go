@@ -0,0 +1,30 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vpn/wireguard-mesh/internal/lockfile"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// StoreLockPath returns the lock file guarding the peer store and the
+// tables kept next to it
+func StoreLockPath(cfg *config.ServerConfig) string {
+	return cfg.DBPath + ".lock"
+}
+
+// LockStore takes the lock on the server's state files, so that a second
+// server, or an import, cannot overwrite the files of a running one
+func LockStore(cfg *config.ServerConfig) (*lockfile.Lock, error) {
+	lock, err := lockfile.Acquire(StoreLockPath(cfg))
+	var locked *lockfile.LockedError
+	if errors.As(err, &locked) {
+		holder := "another process"
+		if locked.PID != 0 {
+			holder = fmt.Sprintf("process %d", locked.PID)
+		}
+		return nil, fmt.Errorf("peer store %s is in use by %s; stop that server first or set a different db_path", cfg.DBPath, holder)
+	}
+	return lock, err
+}
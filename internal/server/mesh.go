@@ -0,0 +1,289 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// ServerPeerID is the peer ID of the server itself when it joins the mesh
+const ServerPeerID = "server"
+
+// Defaults for the server's own WireGuard interface
+const (
+	DefaultMeshInterface  = "wgmesh0"
+	DefaultMeshListenPort = 51820
+
+	// meshSyncInterval is how often the server checks whether its own
+	// interface needs new peers
+	meshSyncInterval = 2 * time.Second
+)
+
+// errServerPeer refuses changes to the server's own peer from outside
+var errServerPeer = errors.New("the coordination server's own peer is managed by the server; disable join_mesh instead")
+
+// meshMember is the server's own membership of the mesh: its interface and
+// what it last programmed there
+type meshMember struct {
+	backend wireguard.Backend
+	peers   map[string]bool // Public keys on the interface
+	version uint64          // Peer list version last applied
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// joinMesh brings up the server's own WireGuard interface with the server's
+// key pair and registers the server as a peer, so that the server host can
+// reach the mesh like any node. Peers are kept in sync from the local peer
+// table by meshRoutine.
+func (s *Server) joinMesh() error {
+	if !s.config.JoinMesh {
+		return nil
+	}
+
+	s.mu.Lock()
+	self, err := s.ensureServerPeerLocked(time.Now())
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to register the server as a peer: %w", err)
+	}
+
+	_, network, err := net.ParseCIDR(s.config.NetworkCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	ones, _ := network.Mask.Size()
+
+	// The mesh prefix length gives the host a route to every peer
+	backend, err := wireguard.NewBackend(wireguard.Config{
+		InterfaceName: s.meshInterface(),
+		PrivateKey:    s.privateKey,
+		ListenPort:    s.meshListenPort(),
+		Address:       fmt.Sprintf("%s/%d", self.VirtualIP, ones),
+		FirewallMark:  s.config.MeshFirewallMark,
+		BindInterface: s.config.MeshBindInterface,
+	})
+	if err != nil {
+		return err
+	}
+	if err := backend.Create(); err != nil {
+		backend.Close()
+		return fmt.Errorf("failed to create mesh interface: %w", err)
+	}
+	if err := backend.Configure(); err != nil {
+		backend.Destroy()
+		return fmt.Errorf("failed to configure mesh interface: %w", err)
+	}
+
+	s.mesh = &meshMember{
+		backend: backend,
+		peers:   make(map[string]bool),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.syncMesh()
+	go s.meshRoutine()
+
+	log.Printf("Joined the mesh as %s on %s with IP %s", ServerPeerID, s.meshInterface(), self.VirtualIP)
+	return nil
+}
+
+// leaveMesh stops syncing and tears down the server's interface. The server
+// peer stays registered, so the server keeps its address across restarts.
+func (s *Server) leaveMesh() error {
+	if s.mesh == nil {
+		return nil
+	}
+	close(s.mesh.stop)
+	<-s.mesh.done
+
+	err := s.mesh.backend.Destroy()
+	s.mesh.backend.Close()
+	s.mesh = nil
+	if err != nil {
+		return fmt.Errorf("failed to destroy mesh interface: %w", err)
+	}
+	return nil
+}
+
+// ensureServerPeerLocked registers the server as a peer, or refreshes the
+// record from an earlier run. A new record gets the first host address of
+// the network when it is free. Callers must hold s.mu.
+func (s *Server) ensureServerPeerLocked(now time.Time) (*Peer, error) {
+	peer := s.peers[ServerPeerID]
+	if peer != nil && peer.PublicKey != s.publicKey {
+		// Left over from before a key rotation
+		s.removePeerLocked(peer, "")
+		peer = nil
+	}
+	if id, ok := s.peersByKey[s.publicKey]; ok && id != ServerPeerID {
+		return nil, fmt.Errorf("peer %s already uses the server's public key", id)
+	}
+
+	if peer == nil {
+		ip, err := s.allocateServerIP()
+		if err != nil {
+			return nil, err
+		}
+		s.allocations.Set(Allocation{IP: ip, Owner: ServerPeerID, Note: "coordination server", AllocatedAt: now})
+
+		peer = &Peer{
+			ID:         ServerPeerID,
+			PublicKey:  s.publicKey,
+			VirtualIP:  ip,
+			AllowedIPs: peerAllowedIPs(ip, nil, false),
+			CreatedAt:  &now,
+		}
+		s.peers[ServerPeerID] = peer
+		s.peersByKey[s.publicKey] = ServerPeerID
+	}
+
+	hostname, _ := os.Hostname()
+	if protocol.ValidateName(hostname) != nil {
+		hostname = "coordination-server"
+	}
+	peer.Hostname = hostname
+	peer.OS = runtime.GOOS
+	peer.Status = PeerStatusActive
+	peer.Endpoint = ""
+	peer.Endpoints = nil
+	if s.config.MeshEndpoint != "" {
+		if endpoint, err := protocol.CanonicalEndpoint(s.config.MeshEndpoint); err == nil {
+			peer.Endpoint = endpoint
+			peer.Endpoints = []string{endpoint}
+		}
+	}
+	markSeen(peer, now)
+	s.refreshConflicts(peer)
+	s.version++
+
+	s.store.SavePeer(peer)
+	s.publishPeer(EventPeerRegistered, peer)
+	return peer, nil
+}
+
+// allocateServerIP reserves the first host address of the network for the
+// server, or the next free one when a peer already holds it
+func (s *Server) allocateServerIP() (string, error) {
+	_, network, err := net.ParseCIDR(s.config.NetworkCIDR)
+	if err != nil {
+		return "", err
+	}
+	first := nextIP(network.IP).String()
+	if s.ipAllocator.Segment(first) == "" {
+		if err := s.ipAllocator.AllocateSpecificIP(first); err == nil {
+			return first, nil
+		}
+	}
+	log.Printf("First host address %s is taken; the server gets the next free address", first)
+	return s.ipAllocator.AllocateIP()
+}
+
+// meshRoutine reprograms the server's interface whenever the peer list
+// version moves
+func (s *Server) meshRoutine() {
+	mesh := s.mesh
+	defer close(mesh.done)
+
+	ticker := time.NewTicker(meshSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.RLock()
+			version := s.version
+			s.mu.RUnlock()
+			if version != mesh.version {
+				s.syncMesh()
+			}
+		case <-mesh.stop:
+			return
+		}
+	}
+}
+
+// syncMesh programs the peers the server's own peer list holds, exactly as
+// a client would, and removes the rest. Only meshRoutine and joinMesh call
+// it, so mesh needs no lock of its own.
+func (s *Server) syncMesh() {
+	mesh := s.mesh
+
+	list, err := s.peerList(ServerPeerID)
+	if err != nil {
+		log.Printf("Failed to compute the server's peer list: %v", err)
+		return
+	}
+
+	keepAlive := wireguard.DefaultKeepAlive
+	if s.config.RecommendedKeepalive > 0 {
+		keepAlive = time.Duration(s.config.RecommendedKeepalive) * time.Second
+	}
+
+	var peers []wireguard.PeerConfig
+	for _, peer := range list.Peers {
+		if !peer.Online {
+			continue
+		}
+		peers = append(peers, wireguard.PeerConfig{
+			PublicKey:  peer.PublicKey,
+			Endpoint:   peer.Endpoint,
+			AllowedIPs: peer.AllowedIPs,
+			KeepAlive:  keepAlive,
+		})
+	}
+
+	failed := make(map[string]error)
+	if err := mesh.backend.AddPeers(peers); err != nil {
+		var batchErr *wireguard.PeerBatchError
+		if !errors.As(err, &batchErr) {
+			log.Printf("Failed to program mesh peers: %v", err)
+			return
+		}
+		failed = batchErr.Failed
+	}
+
+	seen := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		if err, ok := failed[peer.PublicKey]; ok {
+			log.Printf("Failed to add mesh peer %s: %v", peer.PublicKey, err)
+			continue
+		}
+		seen[peer.PublicKey] = true
+	}
+	for publicKey := range mesh.peers {
+		if seen[publicKey] {
+			continue
+		}
+		if err := mesh.backend.RemovePeer(publicKey); err != nil {
+			log.Printf("Failed to remove mesh peer %s: %v", publicKey, err)
+			seen[publicKey] = true
+		}
+	}
+
+	mesh.peers = seen
+	mesh.version = list.Version
+}
+
+// meshInterface returns the name of the server's own interface
+func (s *Server) meshInterface() string {
+	if s.config.MeshInterface != "" {
+		return s.config.MeshInterface
+	}
+	return DefaultMeshInterface
+}
+
+// meshListenPort returns the UDP port of the server's own interface
+func (s *Server) meshListenPort() int {
+	if s.config.MeshListenPort > 0 {
+		return s.config.MeshListenPort
+	}
+	return DefaultMeshListenPort
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histograms
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// scanBuckets are the upper bounds of the allocator scan length histogram
+var scanBuckets = []float64{1, 2, 4, 16, 64, 256, 1024, 4096, 65536}
+
+// sizeBuckets are the upper bounds, in bytes, of the store file size histogram
+var sizeBuckets = []float64{1 << 10, 16 << 10, 128 << 10, 1 << 20, 8 << 20, 64 << 20}
+
+// streamingPatterns are the routes that hold their request open for as long
+// as the client listens, so their duration says nothing about load
+var streamingPatterns = map[string]bool{
+	"/admin/events": true,
+	"/replication":  true,
+}
+
+// histogram counts observations into cumulative buckets, in the form the
+// Prometheus text format expects
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write prints the histogram's series, with labels such as `op="save"`
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// histogramVec is a family of histograms told apart by one label
+type histogramVec struct {
+	mu      sync.Mutex
+	label   string
+	buckets []float64
+	values  map[string]*histogram
+}
+
+func newHistogramVec(label string, buckets []float64) *histogramVec {
+	return &histogramVec{label: label, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (v *histogramVec) with(value string) *histogram {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.values[value]
+	if !ok {
+		h = newHistogram(v.buckets)
+		v.values[value] = h
+	}
+	return h
+}
+
+func (v *histogramVec) write(w io.Writer, name string) {
+	v.mu.Lock()
+	values := make([]string, 0, len(v.values))
+	for value := range v.values {
+		values = append(values, value)
+	}
+	v.mu.Unlock()
+
+	sort.Strings(values)
+	for _, value := range values {
+		v.with(value).write(w, name, fmt.Sprintf("%s=%q", v.label, value))
+	}
+}
+
+// serverMetrics instruments the control plane: request latency, contention
+// on the server mutex, peer store operations and allocator scans. With a
+// slow threshold, anything taking longer is also logged.
+type serverMetrics struct {
+	slow time.Duration
+
+	requests   *histogramVec // By route pattern
+	lockWait   *histogram    // Waiting for the server mutex
+	lockHold   *histogram    // Holding it
+	storeOps   *histogramVec // By operation
+	storeSizes *histogram    // Bytes of each peer store write
+	scans      *histogramVec // Candidates examined per allocation, by segment
+}
+
+func newServerMetrics(slow time.Duration) *serverMetrics {
+	return &serverMetrics{
+		slow:       slow,
+		requests:   newHistogramVec("handler", latencyBuckets),
+		lockWait:   newHistogram(latencyBuckets),
+		lockHold:   newHistogram(latencyBuckets),
+		storeOps:   newHistogramVec("op", latencyBuckets),
+		storeSizes: newHistogram(sizeBuckets),
+		scans:      newHistogramVec("segment", scanBuckets),
+	}
+}
+
+// isSlow reports whether d is above the slow threshold
+func (m *serverMetrics) isSlow(d time.Duration) bool {
+	return m.slow > 0 && d > m.slow
+}
+
+// instrument times every request handled by next by its route pattern.
+// The ServeMux records the matched pattern on the request as it routes it.
+func (m *serverMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		took := time.Since(start)
+
+		pattern := r.Pattern
+		if pattern == "" {
+			pattern = "other"
+		}
+		if streamingPatterns[pattern] {
+			return
+		}
+		m.requests.with(pattern).observe(took.Seconds())
+		if m.isSlow(took) {
+			peer := ""
+			if id := r.URL.Query().Get("peer_id"); id != "" {
+				peer = " for peer " + id
+			}
+			log.Printf("Slow request: %s %s from %s took %s%s", r.Method, r.URL.Path, r.RemoteAddr, took, peer)
+		}
+	})
+}
+
+// storeOp records a peer store operation begun at start. peerID names the
+// peer involved, if there is one.
+func (m *serverMetrics) storeOp(op, peerID string, start time.Time) {
+	m.storeDuration(op, peerID, time.Since(start))
+}
+
+func (m *serverMetrics) storeDuration(op, peerID string, took time.Duration) {
+	m.storeOps.with(op).observe(took.Seconds())
+	if m.isSlow(took) {
+		if peerID != "" {
+			log.Printf("Slow peer store %s of peer %s took %s", op, peerID, took)
+		} else {
+			log.Printf("Slow peer store %s took %s", op, took)
+		}
+	}
+}
+
+// observeWrite records a write of the peer store file
+func (m *serverMetrics) observeWrite(bytes int, took time.Duration) {
+	m.storeSizes.observe(float64(bytes))
+	m.storeDuration("write", "", took)
+}
+
+// observeScan records the length of an allocator scan
+func (m *serverMetrics) observeScan(segment string, scanned int) {
+	if segment == "" {
+		segment = "default"
+	}
+	m.scans.with(segment).observe(float64(scanned))
+}
+
+// timedMutex is the server mutex, recording how long writers wait for it
+// and hold it. Read locks are not timed: many are held at once.
+type timedMutex struct {
+	sync.RWMutex
+	metrics  *serverMetrics
+	acquired time.Time // When the current writer took the lock
+}
+
+func (m *timedMutex) Lock() {
+	if m.metrics == nil {
+		m.RWMutex.Lock()
+		return
+	}
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.acquired = time.Now()
+	m.metrics.lockWait.observe(m.acquired.Sub(start).Seconds())
+}
+
+func (m *timedMutex) Unlock() {
+	if m.metrics == nil || m.acquired.IsZero() {
+		m.RWMutex.Unlock()
+		return
+	}
+	held := time.Since(m.acquired)
+	m.acquired = time.Time{}
+	m.RWMutex.Unlock()
+
+	m.metrics.lockHold.observe(held.Seconds())
+	if m.metrics.isSlow(held) {
+		// Usually deferred, so the caller is the function that locked
+		holder := "unknown"
+		if pc, _, _, ok := runtime.Caller(1); ok {
+			if fn := runtime.FuncForPC(pc); fn != nil {
+				holder = strings.TrimPrefix(fn.Name(), "github.com/vpn/wireguard-mesh/pkg/")
+			}
+		}
+		log.Printf("Slow: server lock held for %s by %s", held, holder)
+	}
+}
+
+// peerStore is the persistence the server needs from a PeerStore
+type peerStore interface {
+	SavePeer(peer *Peer) error
+	LoadPeers() ([]*Peer, error)
+	DeletePeer(peerID string) error
+	ReplacePeers(peers []*Peer) error
+	Flush() error
+	Close() error
+}
+
+// timedStore is a peerStore recording how long each operation takes
+type timedStore struct {
+	peerStore
+	metrics *serverMetrics
+}
+
+func (t timedStore) SavePeer(peer *Peer) error {
+	defer t.metrics.storeOp("save", peer.ID, time.Now())
+	return t.peerStore.SavePeer(peer)
+}
+
+func (t timedStore) LoadPeers() ([]*Peer, error) {
+	defer t.metrics.storeOp("load", "", time.Now())
+	return t.peerStore.LoadPeers()
+}
+
+func (t timedStore) DeletePeer(peerID string) error {
+	defer t.metrics.storeOp("delete", peerID, time.Now())
+	return t.peerStore.DeletePeer(peerID)
+}
+
+func (t timedStore) ReplacePeers(peers []*Peer) error {
+	defer t.metrics.storeOp("replace", "", time.Now())
+	return t.peerStore.ReplacePeers(peers)
+}
+
+func (t timedStore) Flush() error {
+	defer t.metrics.storeOp("flush", "", time.Now())
+	return t.peerStore.Flush()
+}
+
+// handleAdminMetrics serves the metrics in the Prometheus text format
+func (s *Server) handleAdminMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	peers := len(s.peers)
+	version := s.version
+	size, used := s.ipAllocator.Size(), s.ipAllocator.Count()
+	limits := s.peerLimitsLocked(time.Now())
+	s.mu.RUnlock()
+
+	m := s.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP wgmesh_http_request_duration_seconds Time taken to handle API requests, by route.")
+	fmt.Fprintln(w, "# TYPE wgmesh_http_request_duration_seconds histogram")
+	m.requests.write(w, "wgmesh_http_request_duration_seconds")
+
+	fmt.Fprintln(w, "# HELP wgmesh_lock_wait_seconds Time spent waiting to take the server lock for writing.")
+	fmt.Fprintln(w, "# TYPE wgmesh_lock_wait_seconds histogram")
+	m.lockWait.write(w, "wgmesh_lock_wait_seconds", "")
+	fmt.Fprintln(w, "# HELP wgmesh_lock_hold_seconds Time the server lock was held for writing.")
+	fmt.Fprintln(w, "# TYPE wgmesh_lock_hold_seconds histogram")
+	m.lockHold.write(w, "wgmesh_lock_hold_seconds", "")
+
+	fmt.Fprintln(w, "# HELP wgmesh_store_operation_seconds Time taken by peer store operations, by operation.")
+	fmt.Fprintln(w, "# TYPE wgmesh_store_operation_seconds histogram")
+	m.storeOps.write(w, "wgmesh_store_operation_seconds")
+	fmt.Fprintln(w, "# HELP wgmesh_store_write_bytes Size of each peer store file written.")
+	fmt.Fprintln(w, "# TYPE wgmesh_store_write_bytes histogram")
+	m.storeSizes.write(w, "wgmesh_store_write_bytes", "")
+
+	fmt.Fprintln(w, "# HELP wgmesh_allocator_scan_length Candidate addresses examined per allocation, by group.")
+	fmt.Fprintln(w, "# TYPE wgmesh_allocator_scan_length histogram")
+	m.scans.write(w, "wgmesh_allocator_scan_length")
+
+	fmt.Fprintln(w, "# HELP wgmesh_peers Registered peers.")
+	fmt.Fprintln(w, "# TYPE wgmesh_peers gauge")
+	fmt.Fprintf(w, "wgmesh_peers %d\n", peers)
+	fmt.Fprintln(w, "# HELP wgmesh_peers_active Peers counted against max_peers.")
+	fmt.Fprintln(w, "# TYPE wgmesh_peers_active gauge")
+	fmt.Fprintf(w, "wgmesh_peers_active %d\n", limits.ActivePeers)
+	if limits.MaxPeers > 0 {
+		fmt.Fprintln(w, "# HELP wgmesh_peers_max Most peers the network may have.")
+		fmt.Fprintln(w, "# TYPE wgmesh_peers_max gauge")
+		fmt.Fprintf(w, "wgmesh_peers_max %d\n", limits.MaxPeers)
+	}
+	if len(limits.AuthKeys) > 0 {
+		fmt.Fprintln(w, "# HELP wgmesh_auth_key_uses Registrations made with each auth key that has limits.")
+		fmt.Fprintln(w, "# TYPE wgmesh_auth_key_uses gauge")
+		for _, key := range limits.AuthKeys {
+			fmt.Fprintf(w, "wgmesh_auth_key_uses{key=%q} %d\n", key.ID, key.Uses)
+		}
+		fmt.Fprintln(w, "# HELP wgmesh_auth_key_max_uses Registrations each auth key allows, 0 for unlimited.")
+		fmt.Fprintln(w, "# TYPE wgmesh_auth_key_max_uses gauge")
+		for _, key := range limits.AuthKeys {
+			fmt.Fprintf(w, "wgmesh_auth_key_max_uses{key=%q} %d\n", key.ID, key.MaxUses)
+		}
+		fmt.Fprintln(w, "# HELP wgmesh_auth_key_active_peers Peers registered with each auth key that has limits.")
+		fmt.Fprintln(w, "# TYPE wgmesh_auth_key_active_peers gauge")
+		for _, key := range limits.AuthKeys {
+			fmt.Fprintf(w, "wgmesh_auth_key_active_peers{key=%q} %d\n", key.ID, key.ActivePeers)
+		}
+		fmt.Fprintln(w, "# HELP wgmesh_auth_key_max_active_peers Peers each auth key allows at once, 0 for unlimited.")
+		fmt.Fprintln(w, "# TYPE wgmesh_auth_key_max_active_peers gauge")
+		for _, key := range limits.AuthKeys {
+			fmt.Fprintf(w, "wgmesh_auth_key_max_active_peers{key=%q} %d\n", key.ID, key.MaxActivePeers)
+		}
+	}
+	fmt.Fprintln(w, "# HELP wgmesh_allocator_addresses Addresses the network can hand out.")
+	fmt.Fprintln(w, "# TYPE wgmesh_allocator_addresses gauge")
+	fmt.Fprintf(w, "wgmesh_allocator_addresses %d\n", size)
+	fmt.Fprintln(w, "# HELP wgmesh_allocator_allocated Addresses allocated or reserved.")
+	fmt.Fprintln(w, "# TYPE wgmesh_allocator_allocated gauge")
+	fmt.Fprintf(w, "wgmesh_allocator_allocated %d\n", used)
+	fmt.Fprintln(w, "# HELP wgmesh_peer_list_version Current peer list version.")
+	fmt.Fprintln(w, "# TYPE wgmesh_peer_list_version gauge")
+	fmt.Fprintf(w, "wgmesh_peer_list_version %d\n", version)
+}
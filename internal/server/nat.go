@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// clientAddr returns the source address a request came from, with its port
+// when the client connected directly. Behind a trusted proxy only the
+// forwarded IP is known.
+func (s *Server) clientAddr(r *http.Request) string {
+	ip := s.clientIP(r)
+	if ip == "" {
+		return ""
+	}
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if remote := net.ParseIP(host); remote != nil && remote.String() == ip {
+			return net.JoinHostPort(ip, port)
+		}
+	}
+	return ip
+}
+
+// observedHost returns the IP of an observed address, with or without port
+func observedHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// recordObserved stores the address a peer's request came from and reports
+// whether its IP changed, which changes which peers share its NAT
+func recordObserved(peer *Peer, addr string) bool {
+	if addr == "" {
+		return false
+	}
+	changed := observedHost(addr) != observedHost(peer.ObservedAddr)
+	peer.ObservedAddr = addr
+	return changed
+}
+
+// sameNAT reports whether two peers reached the server from the same public
+// IP, and so most likely sit behind the same NAT. Peers whose source is not
+// known are never considered behind the same NAT.
+func sameNAT(a, b *Peer) bool {
+	hostA, hostB := observedHost(a.ObservedAddr), observedHost(b.ObservedAddr)
+	return hostA != "" && hostA == hostB
+}
+
+// personalizeEndpoints orders a listed peer's endpoints for the requester.
+// A peer behind the same NAT is reached over the LAN, so its private
+// endpoints come first; the public mapping they share would hand the
+// handshake to whichever peer answers. Any other requester gets the public
+// endpoints first, since it cannot reach the private ones. The relative
+// order within each kind is kept, and a peer advertising only one kind is
+// left as it is.
+func personalizeEndpoints(info *protocol.PeerInfo, requester, peer *Peer) {
+	if len(info.Endpoints) < 2 || observedHost(requester.ObservedAddr) == "" || observedHost(peer.ObservedAddr) == "" {
+		return
+	}
+	preferPrivate := sameNAT(requester, peer)
+	rank := func(endpoint string) int {
+		if privateEndpoint(endpoint) == preferPrivate {
+			return 0
+		}
+		return 1
+	}
+	slices.SortStableFunc(info.Endpoints, func(a, b string) int {
+		return rank(a) - rank(b)
+	})
+	info.Endpoint = info.Endpoints[0]
+}
+
+// privateEndpoint reports whether an endpoint is a LAN address: RFC 1918 or
+// unique local IPv6. Hostnames count as public.
+func privateEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsPrivate()
+}
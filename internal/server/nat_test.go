@@ -0,0 +1,121 @@
+package server
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestSameNAT(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want bool
+	}{
+		{"same IP, other ports", "203.0.113.7:40000", "203.0.113.7:40001", true},
+		{"same IP behind a proxy", "203.0.113.7", "203.0.113.7:40001", true},
+		{"same IPv6", "[2001:db8::1]:40000", "[2001:db8::1]:40001", true},
+		{"different IPs", "203.0.113.7:40000", "198.51.100.9:40000", false},
+		{"unknown source", "", "", false},
+		{"one unknown", "203.0.113.7:40000", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := &Peer{ObservedAddr: tt.a}, &Peer{ObservedAddr: tt.b}
+			if got := sameNAT(a, b); got != tt.want {
+				t.Errorf("sameNAT(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := sameNAT(b, a); got != tt.want {
+				t.Errorf("sameNAT(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordObserved(t *testing.T) {
+	peer := &Peer{}
+	steps := []struct {
+		addr    string
+		changed bool
+		want    string
+	}{
+		{"203.0.113.7:40000", true, "203.0.113.7:40000"},
+		{"203.0.113.7:40001", false, "203.0.113.7:40001"}, // New mapping, same NAT
+		{"", false, "203.0.113.7:40001"},                  // Source unknown, kept
+		{"198.51.100.9:40000", true, "198.51.100.9:40000"},
+	}
+	for _, step := range steps {
+		if changed := recordObserved(peer, step.addr); changed != step.changed {
+			t.Errorf("recordObserved(%q) = %v, want %v", step.addr, changed, step.changed)
+		}
+		if peer.ObservedAddr != step.want {
+			t.Errorf("after %q observed %q, want %q", step.addr, peer.ObservedAddr, step.want)
+		}
+	}
+}
+
+func TestPersonalizeEndpoints(t *testing.T) {
+	const (
+		public  = "203.0.113.7:51820"
+		private = "192.168.1.10:51820"
+		ula     = "[fd00::10]:51820"
+	)
+	tests := []struct {
+		name      string
+		requester string // Observed address of the requester
+		peer      string // Observed address of the listed peer
+		endpoints []string
+		want      []string
+	}{
+		{
+			name:      "same NAT prefers LAN",
+			requester: "203.0.113.7:40000",
+			peer:      "203.0.113.7:40001",
+			endpoints: []string{public, private, ula},
+			want:      []string{private, ula, public},
+		},
+		{
+			name:      "different NAT prefers public",
+			requester: "198.51.100.9:40000",
+			peer:      "203.0.113.7:40001",
+			endpoints: []string{private, public, ula},
+			want:      []string{public, private, ula},
+		},
+		{
+			name:      "only private endpoints",
+			requester: "198.51.100.9:40000",
+			peer:      "203.0.113.7:40001",
+			endpoints: []string{private, ula},
+			want:      []string{private, ula},
+		},
+		{
+			name:      "single endpoint",
+			requester: "203.0.113.7:40000",
+			peer:      "203.0.113.7:40001",
+			endpoints: []string{public},
+			want:      []string{public},
+		},
+		{
+			name:      "requester source unknown",
+			requester: "",
+			peer:      "203.0.113.7:40001",
+			endpoints: []string{public, private},
+			want:      []string{public, private},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &Peer{ObservedAddr: tt.peer, Endpoint: tt.endpoints[0], Endpoints: tt.endpoints}
+			info := protocol.PeerInfo{Endpoint: peer.Endpoint, Endpoints: slices.Clone(peer.Endpoints)}
+
+			personalizeEndpoints(&info, &Peer{ObservedAddr: tt.requester}, peer)
+			if !slices.Equal(info.Endpoints, tt.want) || info.Endpoint != tt.want[0] {
+				t.Errorf("got %q first of %q, want %q", info.Endpoint, info.Endpoints, tt.want)
+			}
+			if !slices.Equal(peer.Endpoints, tt.endpoints) {
+				t.Errorf("stored endpoints changed to %q", peer.Endpoints)
+			}
+		})
+	}
+}
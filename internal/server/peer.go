@@ -0,0 +1,118 @@
+package server
+
+import (
+	"slices"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Peer is the server's record of a peer, as kept in memory and in the store.
+// Clients only ever see the protocol.PeerInfo built by Info.
+type Peer struct {
+	ID            string    `json:"id"`
+	PublicKey     string    `json:"public_key"`
+	VirtualIP     string    `json:"virtual_ip"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	Endpoints     []string  `json:"endpoints,omitempty"`     // Every advertised endpoint, Endpoint first
+	ObservedAddr  string    `json:"observed_addr,omitempty"` // Source address of the peer's last request, with port when known
+	Hostname      string    `json:"hostname"`
+	OS            string    `json:"os"`
+	ClientVersion string    `json:"client_version,omitempty"`
+	Backend       string    `json:"backend,omitempty"` // WireGuard implementation the client reported
+	AllowedIPs    []string  `json:"allowed_ips"`
+	ExitNode      bool      `json:"exit_node"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Online        bool      `json:"online"`
+	OfflineReason string    `json:"offline_reason,omitempty"` // Why the peer is offline: OfflineHeartbeatTimeout, OfflineUnregistered, ...
+	Status        string    `json:"status,omitempty"`         // PeerStatusActive, PeerStatusPending or PeerStatusSuspended
+	Tags          []string  `json:"tags,omitempty"`           // From the auth key the peer registered with
+	Group         string    `json:"group,omitempty"`          // Group whose address range the peer's address came from
+	Ephemeral     bool      `json:"ephemeral,omitempty"`      // Removed when it leaves or stays offline past the grace period
+	AuthKeyID     string    `json:"auth_key_id,omitempty"`    // Auth key the peer registered with
+	MonthlyQuota  int64     `json:"monthly_quota,omitempty"`  // Bytes per month overriding the configured quota; negative for unlimited
+	QuotaExceeded bool      `json:"quota_exceeded,omitempty"` // Over its monthly quota, with the quota action applied
+	Hidden        bool      `json:"hidden,omitempty"`         // Left out of the peer lists of peers not allowed to see it
+
+	// Guest access, stamped from the invite the peer registered with
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`         // Removed at this time
+	Destinations     []string   `json:"destinations,omitempty"`       // Selectors of the only peers it connects with
+	ExitRoutesDenied bool       `json:"exit_routes_denied,omitempty"` // Given no routes through exit nodes
+
+	// Endpoints the peer advertised that other peers do not get yet
+	PendingEndpoints  []string           `json:"pending_endpoints,omitempty"`  // A change waiting to be confirmed
+	RejectedEndpoints []RejectedEndpoint `json:"rejected_endpoints,omitempty"` // Dropped from its last advertisement
+
+	CreatedAt    *time.Time       `json:"created_at,omitempty"`
+	ApprovedAt   *time.Time       `json:"approved_at,omitempty"`
+	LastSeen     *time.Time       `json:"last_seen,omitempty"`
+	LastOnlineAt *time.Time       `json:"last_online_at,omitempty"` // Last time the peer was known online, once it went offline
+	History      []PeerTransition `json:"history,omitempty"`        // Most recent online/offline transitions, oldest first
+}
+
+// mergePeer applies a repeated registration to an existing peer and returns
+// the result, leaving peer untouched. Fields the client owns follow the
+// request: hostname, OS, client version, backend, endpoints and advertised
+// routes. A registration changes the endpoints at once, without the
+// confirmation heartbeats need. Fields the administrator owns are kept:
+// status and approval, tags, group, address, quota and auth key. The peer is
+// an exit node only while it asks to be and exitAllowed; a request may hide
+// the peer but not unhide it, which only the admin API does. forcedEphemeral
+// keeps a peer whose auth key demands it ephemeral.
+func mergePeer(peer Peer, req *protocol.RegisterRequest, exitAllowed, forcedEphemeral bool) Peer {
+	peer.Hostname = req.Hostname
+	peer.OS = req.OS
+	peer.ClientVersion = req.ClientVersion
+	if req.Backend != "" {
+		peer.Backend = req.Backend
+	}
+	peer.Endpoint = req.Endpoint
+	peer.Endpoints = append([]string(nil), req.Endpoints...)
+	peer.PendingEndpoints = nil
+
+	peer.ExitNode = req.ExitNode && exitAllowed
+	peer.AllowedIPs = peerAllowedIPs(peer.VirtualIP, req.AllowedIPs, peer.ExitNode)
+	peer.Ephemeral = req.Ephemeral || forcedEphemeral
+	peer.Hidden = peer.Hidden || req.Hidden
+	return peer
+}
+
+// visiblyChanged reports whether other peers' lists differ between two
+// versions of a peer
+func visiblyChanged(before, after *Peer) bool {
+	return before.Hostname != after.Hostname ||
+		before.Endpoint != after.Endpoint ||
+		!sameEndpoints(before.Endpoints, after.Endpoints) ||
+		!slices.Equal(before.AllowedIPs, after.AllowedIPs) ||
+		before.ExitNode != after.ExitNode ||
+		before.Hidden != after.Hidden
+}
+
+// PeerTransition records a peer going online or offline
+type PeerTransition struct {
+	Online bool      `json:"online"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"` // Why it went offline
+}
+
+// Administrative peer states. Only active peers are part of the mesh.
+const (
+	PeerStatusActive    = ""
+	PeerStatusPending   = "pending"
+	PeerStatusSuspended = "suspended"
+)
+
+// Info returns the view of the peer sent to other peers
+func (p *Peer) Info() protocol.PeerInfo {
+	return protocol.PeerInfo{
+		ID:         p.ID,
+		Hostname:   p.Hostname,
+		PublicKey:  p.PublicKey,
+		VirtualIP:  p.VirtualIP,
+		Endpoint:   p.Endpoint,
+		Endpoints:  append([]string(nil), p.Endpoints...),
+		AllowedIPs: append([]string(nil), p.AllowedIPs...),
+		Online:     p.Online,
+		ExitNode:   p.ExitNode,
+	}
+}
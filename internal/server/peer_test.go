@@ -0,0 +1,314 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestPeerInfoLeavesOutServerRecords(t *testing.T) {
+	now := time.Now()
+	p := &Peer{
+		ID:            "peer-a",
+		PublicKey:     "a2V5",
+		VirtualIP:     "10.100.0.2",
+		Endpoint:      "192.0.2.1:51820",
+		ObservedAddr:  "198.51.100.9:40000",
+		Hostname:      "laptop",
+		OS:            "linux",
+		ClientVersion: "1.2.3",
+		AllowedIPs:    []string{"10.100.0.2/32"},
+		LastHeartbeat: now,
+		Online:        true,
+		Status:        PeerStatusPending,
+		Tags:          []string{"tag:secret"},
+		AuthKeyID:     "key-1",
+		ExpiresAt:     &now,
+	}
+
+	data, err := json.Marshal(p.Info())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, field := range []string{"observed_addr", "os", "client_version", "last_heartbeat", "status", "tags", "auth_key_id", "expires_at"} {
+		if strings.Contains(string(data), `"`+field+`"`) {
+			t.Errorf("peer info carries %q: %s", field, data)
+		}
+	}
+
+	// The info does not share slices with the record it came from
+	info := p.Info()
+	info.AllowedIPs[0] = "0.0.0.0/0"
+	if p.AllowedIPs[0] != "10.100.0.2/32" {
+		t.Errorf("changing the info changed the record: %v", p.AllowedIPs)
+	}
+}
+
+func TestMergePeer(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := Peer{
+		ID:               "peer-a",
+		PublicKey:        "a2V5",
+		VirtualIP:        "10.100.0.2",
+		Endpoint:         "192.0.2.1:51820",
+		Endpoints:        []string{"192.0.2.1:51820", "10.0.0.5:51820"},
+		PendingEndpoints: []string{"192.0.2.9:51820"},
+		Hostname:         "laptop",
+		OS:               "linux",
+		ClientVersion:    "1.0.0",
+		Backend:          "kernel",
+		AllowedIPs:       []string{"10.100.0.2/32", "192.168.1.0/24"},
+		Status:           PeerStatusPending,
+		Tags:             []string{"tag:ci"},
+		Group:            "office",
+		AuthKeyID:        "key-1",
+		MonthlyQuota:     1 << 30,
+		CreatedAt:        &created,
+		History:          []PeerTransition{{Online: true, Time: created}},
+	}
+	// request returns a registration repeating what the peer stored
+	request := func() *protocol.RegisterRequest {
+		return &protocol.RegisterRequest{
+			PublicKey:     stored.PublicKey,
+			Hostname:      stored.Hostname,
+			OS:            stored.OS,
+			ClientVersion: stored.ClientVersion,
+			Backend:       stored.Backend,
+			Endpoint:      stored.Endpoint,
+			Endpoints:     append([]string(nil), stored.Endpoints...),
+			AllowedIPs:    []string{"192.168.1.0/24"},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		change          func(*protocol.RegisterRequest)
+		exitAllowed     bool
+		forcedEphemeral bool
+		want            func(*Peer) // Applied to a copy of the stored peer
+		visible         bool
+	}{
+		{
+			// Only the unconfirmed heartbeat endpoint goes away
+			name: "unchanged",
+			want: func(p *Peer) { p.PendingEndpoints = nil },
+		},
+		{
+			name: "newer endpoint",
+			change: func(r *protocol.RegisterRequest) {
+				r.Endpoint = "198.51.100.7:4500"
+				r.Endpoints = []string{"198.51.100.7:4500"}
+			},
+			want: func(p *Peer) {
+				p.Endpoint = "198.51.100.7:4500"
+				p.Endpoints = []string{"198.51.100.7:4500"}
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			// A registration is the client's current view, so an older or
+			// missing endpoint replaces the stored one too
+			name: "endpoint dropped",
+			change: func(r *protocol.RegisterRequest) {
+				r.Endpoint = ""
+				r.Endpoints = nil
+			},
+			want: func(p *Peer) {
+				p.Endpoint = ""
+				p.Endpoints = nil
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			name:   "route added",
+			change: func(r *protocol.RegisterRequest) { r.AllowedIPs = append(r.AllowedIPs, "172.16.0.0/12") },
+			want: func(p *Peer) {
+				p.AllowedIPs = []string{"10.100.0.2/32", "192.168.1.0/24", "172.16.0.0/12"}
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			name:   "routes removed",
+			change: func(r *protocol.RegisterRequest) { r.AllowedIPs = nil },
+			want: func(p *Peer) {
+				p.AllowedIPs = []string{"10.100.0.2/32"}
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			name:        "exit node allowed",
+			change:      func(r *protocol.RegisterRequest) { r.ExitNode = true },
+			exitAllowed: true,
+			want: func(p *Peer) {
+				p.ExitNode = true
+				p.AllowedIPs = []string{"10.100.0.2/32", "192.168.1.0/24", "0.0.0.0/0"}
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			name:   "exit node refused",
+			change: func(r *protocol.RegisterRequest) { r.ExitNode = true },
+			want:   func(p *Peer) { p.PendingEndpoints = nil },
+		},
+		{
+			// Client details other peers do not see
+			name: "client details",
+			change: func(r *protocol.RegisterRequest) {
+				r.OS = "darwin"
+				r.ClientVersion = "1.1.0"
+				r.Backend = ""
+			},
+			want: func(p *Peer) {
+				p.OS = "darwin"
+				p.ClientVersion = "1.1.0"
+				p.PendingEndpoints = nil
+			},
+		},
+		{
+			name:   "hostname",
+			change: func(r *protocol.RegisterRequest) { r.Hostname = "desktop" },
+			want: func(p *Peer) {
+				p.Hostname = "desktop"
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+		{
+			name:            "ephemeral forced",
+			forcedEphemeral: true,
+			want: func(p *Peer) {
+				p.Ephemeral = true
+				p.PendingEndpoints = nil
+			},
+		},
+		{
+			name:   "hidden",
+			change: func(r *protocol.RegisterRequest) { r.Hidden = true },
+			want: func(p *Peer) {
+				p.Hidden = true
+				p.PendingEndpoints = nil
+			},
+			visible: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := clonePeer(stored)
+			req := request()
+			if tt.change != nil {
+				tt.change(req)
+			}
+
+			got := mergePeer(before, req, tt.exitAllowed, tt.forcedEphemeral)
+
+			want := clonePeer(stored)
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("merged:\n got %+v\nwant %+v", got, want)
+			}
+			if !reflect.DeepEqual(before, stored) {
+				t.Errorf("merging changed the stored peer:\n got %+v\nwant %+v", before, stored)
+			}
+			if changed := visiblyChanged(&stored, &got); changed != tt.visible {
+				t.Errorf("visiblyChanged = %v, want %v", changed, tt.visible)
+			}
+		})
+	}
+}
+
+func TestMergePeerKeepsHidden(t *testing.T) {
+	// Only the admin API unhides a peer
+	got := mergePeer(Peer{VirtualIP: "10.100.0.2", Hidden: true}, &protocol.RegisterRequest{}, false, false)
+	if !got.Hidden {
+		t.Error("a registration unhid the peer")
+	}
+}
+
+// clonePeer returns a copy of p sharing none of its slices
+func clonePeer(p Peer) Peer {
+	p.Endpoints = slices.Clone(p.Endpoints)
+	p.PendingEndpoints = slices.Clone(p.PendingEndpoints)
+	p.AllowedIPs = slices.Clone(p.AllowedIPs)
+	p.Tags = slices.Clone(p.Tags)
+	p.History = slices.Clone(p.History)
+	return p
+}
+
+func TestReregistrationKeepsKeepaliveAndAddress(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.ServerConfig) { cfg.RecommendedKeepalive = 15 })
+	handler := s.Handler()
+
+	req := newRegisterRequest(t, "laptop")
+	_, first, err := postRegister(handler, req)
+	if err != nil || !first.Success {
+		t.Fatalf("register: %v %s", err, first.Error)
+	}
+
+	req.Endpoint = "198.51.100.7:4500"
+	req.AllowedIPs = []string{"192.168.1.0/24"}
+	_, again, err := postRegister(handler, req)
+	if err != nil || !again.Success {
+		t.Fatalf("re-register: %v %s", err, again.Error)
+	}
+	if again.PeerID != first.PeerID || again.AssignedIP != first.AssignedIP {
+		t.Errorf("re-register got %s at %s, want %s at %s",
+			again.PeerID, again.AssignedIP, first.PeerID, first.AssignedIP)
+	}
+	if again.Keepalive != 15 {
+		t.Errorf("re-register keepalive = %d, want 15", again.Keepalive)
+	}
+}
+
+func TestRegistrationAfterRemovalStartsOver(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	req := newRegisterRequest(t, "laptop")
+	req.AllowedIPs = []string{"192.168.1.0/24"}
+	_, first, err := postRegister(handler, req)
+	if err != nil || !first.Success {
+		t.Fatalf("register: %v %s", err, first.Error)
+	}
+	if err := s.removePeer(first.PeerID); err != nil {
+		t.Fatalf("removePeer: %v", err)
+	}
+
+	// Nothing of the removed record is merged into the new one
+	req.AllowedIPs = nil
+	_, again, err := postRegister(handler, req)
+	if err != nil || !again.Success {
+		t.Fatalf("register after removal: %v %s", err, again.Error)
+	}
+	s.mu.RLock()
+	peer, ok := s.peers[again.PeerID]
+	var allowed []string
+	if ok {
+		allowed = slices.Clone(peer.AllowedIPs)
+	}
+	_, stale := s.peers[first.PeerID]
+	s.mu.RUnlock()
+	if !ok {
+		t.Fatalf("peer %s not stored", again.PeerID)
+	}
+	if stale {
+		t.Errorf("removed peer %s is still stored", first.PeerID)
+	}
+	if want := []string{again.AssignedIP + "/32"}; !slices.Equal(allowed, want) {
+		t.Errorf("allowed IPs after removal: got %v, want %v", allowed, want)
+	}
+	if err := s.CheckInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
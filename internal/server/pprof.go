@@ -0,0 +1,59 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves the profiling endpoints on the configured pprof_addr.
+// The address must be loopback: profiles reveal memory contents, and the
+// endpoints take no token.
+func (s *Server) startPprof() error {
+	if s.config.PprofAddr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.config.PprofAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on pprof_addr: %w", err)
+	}
+	if addr, ok := listener.Addr().(*net.TCPAddr); !ok || !addr.IP.IsLoopback() {
+		listener.Close()
+		return fmt.Errorf("pprof_addr %s is not a loopback address", s.config.PprofAddr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+
+	s.mu.Lock()
+	s.pprofServer = server
+	s.mu.Unlock()
+
+	log.Printf("Serving pprof on %s", listener.Addr())
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("pprof server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopPprof closes the profiling listener, if there is one
+func (s *Server) stopPprof() {
+	s.mu.Lock()
+	server := s.pprofServer
+	s.pprofServer = nil
+	s.mu.Unlock()
+
+	if server != nil {
+		server.Close()
+	}
+}
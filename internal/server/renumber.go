@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// peersOutsideNetwork returns the peers whose virtual IP does not lie within
+// networkCIDR, ordered by ID
+func peersOutsideNetwork(networkCIDR string, peers map[string]*Peer) ([]*Peer, error) {
+	_, prefix, err := net.ParseCIDR(networkCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR: %w", err)
+	}
+
+	var outside []*Peer
+	for _, peer := range peers {
+		ip := net.ParseIP(peer.VirtualIP)
+		if ip == nil || !prefix.Contains(ip) {
+			outside = append(outside, peer)
+		}
+	}
+	sort.Slice(outside, func(i, j int) bool { return outside[i].ID < outside[j].ID })
+	return outside, nil
+}
+
+// renumberPeers moves the given peers into the network managed by
+// ipAllocator. Each peer keeps its host offset, the bits of its old address
+// below the new prefix length, when that address is free; otherwise it gets
+// the next free address. Peers already inside the network must have been
+// allocated beforehand so that they keep their addresses.
+func renumberPeers(ipAllocator *network.IPAllocator, peers []*Peer) error {
+	_, prefix, err := net.ParseCIDR(ipAllocator.GetNetworkCIDR())
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range peers {
+		oldIP := peer.VirtualIP
+
+		// Peers stay within their group's range, if it still exists
+		group := peer.Group
+		if !hasSegment(ipAllocator, group) {
+			group = ""
+		}
+
+		newIP := ""
+		if candidate := sameHostOffset(prefix, oldIP); candidate != "" && ipAllocator.Segment(candidate) == group {
+			if err := ipAllocator.AllocateSpecificIP(candidate); err == nil {
+				newIP = candidate
+			}
+		}
+		if newIP == "" {
+			if group != "" {
+				newIP, err = ipAllocator.AllocateIPIn(group)
+			} else {
+				newIP, err = ipAllocator.AllocateIP()
+			}
+			if err != nil {
+				return fmt.Errorf("cannot renumber peer %s: %w", peer.ID, err)
+			}
+		}
+
+		peer.VirtualIP = newIP
+		peer.Group = group
+		peer.AllowedIPs = readdressAllowedIPs(peer.AllowedIPs, oldIP, newIP)
+		log.Printf("Renumbered peer %s (%s) from %s to %s", peer.ID, peer.Hostname, oldIP, newIP)
+	}
+	return nil
+}
+
+// readdressAllowedIPs returns a copy of allowedIPs with the host route of
+// oldIP replaced by one for newIP
+func readdressAllowedIPs(allowedIPs []string, oldIP, newIP string) []string {
+	result := make([]string, len(allowedIPs))
+	for i, allowedIP := range allowedIPs {
+		if allowedIP == oldIP+"/32" {
+			allowedIP = newIP + "/32"
+		}
+		result[i] = allowedIP
+	}
+	return result
+}
+
+// sameHostOffset returns the address in prefix with the same host bits as
+// ip, or "" when ip is of another family or would land on the network or
+// broadcast address
+func sameHostOffset(prefix *net.IPNet, ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil && len(prefix.IP) == net.IPv4len {
+		parsed = v4
+	} else {
+		parsed = parsed.To16()
+	}
+	if len(parsed) != len(prefix.IP) {
+		return ""
+	}
+
+	candidate := make(net.IP, len(parsed))
+	allHost, noHost := true, true
+	for i := range candidate {
+		host := parsed[i] &^ prefix.Mask[i]
+		candidate[i] = prefix.IP[i] | host
+		if host != ^prefix.Mask[i] {
+			allHost = false
+		}
+		if host != 0 {
+			noHost = false
+		}
+	}
+	if allHost || noHost {
+		return ""
+	}
+	return candidate.String()
+}
+
+// hasSegment reports whether the allocator has a segment for group
+func hasSegment(ipAllocator *network.IPAllocator, group string) bool {
+	for _, name := range ipAllocator.Segments() {
+		if name == group {
+			return true
+		}
+	}
+	return false
+}
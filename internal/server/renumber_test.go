@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+func TestRenumberPartialOverlap(t *testing.T) {
+	// The network shrinks from 10.100.0.0/16 to 10.100.0.0/24: peers in the
+	// first /24 stay put and the others move in
+	peers := map[string]*Peer{
+		"inside":    {ID: "inside", VirtualIP: "10.100.0.5", AllowedIPs: []string{"10.100.0.5/32"}},
+		"offset":    {ID: "offset", VirtualIP: "10.100.7.9", AllowedIPs: []string{"10.100.7.9/32", "192.168.1.0/24"}},
+		"collides":  {ID: "collides", VirtualIP: "10.100.3.5", AllowedIPs: []string{"10.100.3.5/32"}},
+		"broadcast": {ID: "broadcast", VirtualIP: "10.100.2.255", AllowedIPs: []string{"10.100.2.255/32"}},
+		"network":   {ID: "network", VirtualIP: "10.100.4.0", AllowedIPs: []string{"10.100.4.0/32"}},
+	}
+
+	outside, err := peersOutsideNetwork("10.100.0.0/24", peers)
+	if err != nil {
+		t.Fatalf("peersOutsideNetwork: %v", err)
+	}
+	var ids []string
+	for _, peer := range outside {
+		ids = append(ids, peer.ID)
+	}
+	if want := []string{"broadcast", "collides", "network", "offset"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("outside: got %v, want %v", ids, want)
+	}
+
+	ipAllocator, err := network.NewIPAllocator("10.100.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipAllocator.AllocateSpecificIP("10.100.0.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := renumberPeers(ipAllocator, outside); err != nil {
+		t.Fatalf("renumberPeers: %v", err)
+	}
+
+	// Peers whose host offset is taken, or is the network or broadcast
+	// address, get any free address
+	want := map[string]string{
+		"inside": "10.100.0.5",
+		"offset": "10.100.0.9",
+	}
+	seen := make(map[string]string)
+	for id, peer := range peers {
+		if w := want[id]; w != "" && peer.VirtualIP != w {
+			t.Errorf("%s renumbered to %s, want %s", id, peer.VirtualIP, w)
+		}
+		if other, ok := seen[peer.VirtualIP]; ok {
+			t.Errorf("%s and %s both have %s", other, id, peer.VirtualIP)
+		}
+		seen[peer.VirtualIP] = id
+		if !ipAllocator.IsAllocated(peer.VirtualIP) {
+			t.Errorf("%s's address %s is not allocated", id, peer.VirtualIP)
+		}
+		if peer.AllowedIPs[0] != peer.VirtualIP+"/32" {
+			t.Errorf("%s's host route is %s, want %s/32", id, peer.AllowedIPs[0], peer.VirtualIP)
+		}
+	}
+	if got, err := peersOutsideNetwork("10.100.0.0/24", peers); err != nil || len(got) != 0 {
+		t.Errorf("peers still outside after renumbering: %v %v", len(got), err)
+	}
+
+	// Routes other than the host route are kept
+	if routes := peers["offset"].AllowedIPs; !reflect.DeepEqual(routes, []string{"10.100.0.9/32", "192.168.1.0/24"}) {
+		t.Errorf("offset's allowed IPs: %v", routes)
+	}
+}
+
+func TestRenumberKeepsGroup(t *testing.T) {
+	ipAllocator, err := network.NewIPAllocator("10.200.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ipAllocator.AddSegment("servers", "10.200.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+
+	peers := []*Peer{
+		// Its host offset lands in the group's range
+		{ID: "kept", VirtualIP: "10.100.1.7", Group: "servers", AllowedIPs: []string{"10.100.1.7/32"}},
+		// Its host offset lands outside the group's range
+		{ID: "moved", VirtualIP: "10.100.9.7", Group: "servers", AllowedIPs: []string{"10.100.9.7/32"}},
+		// Its group no longer exists
+		{ID: "orphan", VirtualIP: "10.100.1.8", Group: "gone", AllowedIPs: []string{"10.100.1.8/32"}},
+	}
+	if err := renumberPeers(ipAllocator, peers); err != nil {
+		t.Fatalf("renumberPeers: %v", err)
+	}
+
+	if got := peers[0].VirtualIP; got != "10.200.1.7" {
+		t.Errorf("kept renumbered to %s, want 10.200.1.7", got)
+	}
+	if seg := ipAllocator.Segment(peers[1].VirtualIP); seg != "servers" {
+		t.Errorf("moved renumbered to %s in segment %q, want one in servers", peers[1].VirtualIP, seg)
+	}
+	if peers[2].Group != "" || ipAllocator.Segment(peers[2].VirtualIP) != "" {
+		t.Errorf("orphan renumbered to %s in group %q, want one outside every group", peers[2].VirtualIP, peers[2].Group)
+	}
+}
+
+func TestRenumberFullNetwork(t *testing.T) {
+	ipAllocator, err := network.NewIPAllocator("10.100.0.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := []*Peer{
+		{ID: "a", VirtualIP: "10.99.0.1"},
+		{ID: "b", VirtualIP: "10.99.0.2"},
+		{ID: "c", VirtualIP: "10.99.0.3"},
+	}
+	if err := renumberPeers(ipAllocator, peers); err == nil {
+		t.Error("renumbering three peers into a /30 succeeded")
+	}
+}
+
+func TestSameHostOffset(t *testing.T) {
+	tests := []struct {
+		cidr string
+		ip   string
+		want string
+	}{
+		{"10.200.0.0/16", "10.100.3.4", "10.200.3.4"},
+		{"10.200.0.0/24", "10.100.3.4", "10.200.0.4"},
+		{"10.200.0.0/24", "10.100.3.0", ""},
+		{"10.200.0.0/24", "10.100.3.255", ""},
+		{"10.200.0.0/24", "fd00::4", ""},
+		{"fd00:1::/64", "fd00:2::4", "fd00:1::4"},
+		{"10.200.0.0/24", "garbage", ""},
+	}
+	for _, tt := range tests {
+		_, prefix, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sameHostOffset(prefix, tt.ip); got != tt.want {
+			t.Errorf("sameHostOffset(%s, %s) = %q, want %q", tt.cidr, tt.ip, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Replication stream tuning
+const (
+	// replicationKeepalive is how often the primary writes to an idle
+	// stream, so that a replica notices a dead primary
+	replicationKeepalive = 15 * time.Second
+	// replicationIdleTimeout is how long a replica waits for a line before
+	// it gives up on the stream and reconnects
+	replicationIdleTimeout = 3 * replicationKeepalive
+	// replicationRetry is the pause before a replica reconnects
+	replicationRetry = 5 * time.Second
+	// replicationBuffer is how many events may queue for a replica before
+	// it is cut off and has to start over from a snapshot
+	replicationBuffer = 1024
+	// maxReplicationLine bounds one line of the stream; the snapshot of the
+	// whole peer table is sent as a single line
+	maxReplicationLine = 64 << 20
+)
+
+// EventReplicationSnapshot is the first event of a replication stream,
+// carrying the whole peer table
+const EventReplicationSnapshot = "snapshot"
+
+// ReplicationSnapshot is the peer table at a peer list version
+type ReplicationSnapshot struct {
+	Version uint64  `json:"version"`
+	Peers   []*Peer `json:"peers"`
+}
+
+// errReplicaReadOnly refuses writes on a replica
+var errReplicaReadOnly = errors.New("this server is a read-only replica; send writes to the primary")
+
+// readOnly reports whether the server is a replica
+func (s *Server) readOnly() bool {
+	return s.config.ReplicaOf != ""
+}
+
+// primaryOnly refuses a write handler on a replica with ErrorCodeReadOnly
+func (s *Server) primaryOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.readOnly() {
+			writeReadOnly(w)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeReadOnly answers a write sent to a replica. The status is 503 so
+// that clients with several server addresses try the next one.
+func writeReadOnly(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": false,
+		"error":   errReplicaReadOnly.Error(),
+		"code":    protocol.ErrorCodeReadOnly,
+	})
+}
+
+// handleReplication streams the peer table to a replica as server-sent
+// events: a snapshot first, then every change as it happens
+func (s *Server) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if s.config.ReplicationToken == "" {
+		http.Error(w, "Replication disabled", http.StatusNotFound)
+		return
+	}
+	if !crypto.ConstantTimeEqualString(r.Header.Get("X-Replication-Token"), s.config.ReplicationToken) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before taking the snapshot so that no change falls between
+	// the two; changes already in the snapshot are replayed harmlessly
+	events, unsubscribe := s.events.subscribeLossless(replicationBuffer)
+	defer unsubscribe()
+
+	s.mu.RLock()
+	snapshot := ReplicationSnapshot{Version: s.version, Peers: make([]*Peer, 0, len(s.peers))}
+	for _, peer := range s.peers {
+		snapshot.Peers = append(snapshot.Peers, copyPeer(peer))
+	}
+	s.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		http.Error(w, "Failed to encode snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", EventReplicationSnapshot, data)
+	flusher.Flush()
+	log.Printf("Replica %s connected; sent %d peers at version %d", s.clientIP(r), len(snapshot.Peers), snapshot.Version)
+
+	keepalive := time.NewTicker(replicationKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// startReplica follows the primary until Shutdown
+func (s *Server) startReplica() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.replicaCancel = cancel
+	s.replicaDone = make(chan struct{})
+	go s.replicationRoutine(ctx)
+}
+
+// stopReplica stops following the primary
+func (s *Server) stopReplica() {
+	if s.replicaCancel == nil {
+		return
+	}
+	s.replicaCancel()
+	<-s.replicaDone
+}
+
+// replicationRoutine keeps a replication stream open to the primary,
+// reconnecting whenever it drops. While the primary is unreachable the
+// replica keeps serving the last state it received.
+func (s *Server) replicationRoutine(ctx context.Context) {
+	defer close(s.replicaDone)
+
+	for {
+		err := s.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Replication from %s interrupted: %v; retrying in %s", s.config.ReplicaOf, err, replicationRetry)
+
+		select {
+		case <-time.After(replicationRetry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replicate follows one replication stream until it ends
+func (s *Server) replicate(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	primary, err := url.JoinPath(s.config.ReplicaOf, "replication")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, primary, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Replication-Token", s.config.ReplicationToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary returned status %d", resp.StatusCode)
+	}
+
+	// A primary that stops sending, even keepalives, is treated as gone
+	idle := time.AfterFunc(replicationIdleTimeout, cancel)
+	defer idle.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), maxReplicationLine)
+	var eventType, data string
+	for scanner.Scan() {
+		idle.Reset(replicationIdleTimeout)
+
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data != "" {
+				if err := s.applyReplicated(eventType, data); err != nil {
+					return err
+				}
+			}
+			eventType, data = "", ""
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream closed by the primary")
+}
+
+// applyReplicated applies one event of the replication stream
+func (s *Server) applyReplicated(eventType, data string) error {
+	if eventType == EventReplicationSnapshot {
+		var snapshot ReplicationSnapshot
+		if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+			return fmt.Errorf("invalid snapshot: %w", err)
+		}
+		return s.applySnapshot(&snapshot)
+	}
+
+	var event AdminEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return fmt.Errorf("invalid %s event: %w", eventType, err)
+	}
+	if event.Peer != nil {
+		s.applyPeerEvent(&event)
+	}
+	return nil
+}
+
+// applySnapshot replaces the peer table with the primary's
+func (s *Server) applySnapshot(snapshot *ReplicationSnapshot) error {
+	byID := make(map[string]*Peer, len(snapshot.Peers))
+	byKey := make(map[string]string, len(snapshot.Peers))
+	for _, peer := range snapshot.Peers {
+		byID[peer.ID] = peer
+		byKey[peer.PublicKey] = peer.ID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.ReplacePeers(snapshot.Peers); err != nil {
+		return fmt.Errorf("failed to write peer store: %w", err)
+	}
+	s.peers = byID
+	s.peersByKey = byKey
+	s.conflicts = findConflicts(s.peers)
+	s.version = snapshot.Version
+
+	log.Printf("Replicated %d peers at version %d from %s", len(snapshot.Peers), snapshot.Version, s.config.ReplicaOf)
+	return nil
+}
+
+// applyPeerEvent applies a change made on the primary. Events older than
+// the state already held, which were queued while the snapshot was taken,
+// are skipped. The version follows the primary's, so that clients moving
+// between the two see no spurious change.
+func (s *Server) applyPeerEvent(event *AdminEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.Version < s.version {
+		return
+	}
+
+	peer := event.Peer
+	if previous, ok := s.peers[peer.ID]; ok && previous.PublicKey != peer.PublicKey {
+		delete(s.peersByKey, previous.PublicKey)
+	}
+	if event.Type == EventPeerRemoved {
+		delete(s.peers, peer.ID)
+		delete(s.peersByKey, peer.PublicKey)
+		s.store.DeletePeer(peer.ID)
+		s.conflicts = findConflicts(s.peers)
+	} else {
+		s.peers[peer.ID] = peer
+		s.peersByKey[peer.PublicKey] = peer.ID
+		s.store.SavePeer(peer)
+		s.refreshConflicts(peer)
+	}
+	s.version = event.Version
+
+	// Admin subscribers and chained replicas see the change too
+	event.Peer = copyPeer(peer)
+	s.events.publish(*event)
+}
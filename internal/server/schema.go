@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// PeerStoreVersion is the version of the peer file format written by this
+// server. Bump it, and add a migration from the previous version, whenever
+// stored peers change in a way that old data must be converted for.
+const PeerStoreVersion = 1
+
+// peerFile is the versioned envelope peers are stored in, used by the peer
+// store, snapshots and state archives alike
+type peerFile struct {
+	Version int     `json:"version"`
+	Peers   []*Peer `json:"peers"`
+}
+
+// peerMigration upgrades a peer file from one version to the next. It works
+// on the raw document, so that it can see fields the current Peer type no
+// longer has.
+type peerMigration func(data []byte) ([]byte, error)
+
+// peerMigrations holds the migration from each version to the one after it
+var peerMigrations = map[int]peerMigration{
+	0: migratePeersV0,
+}
+
+// migratePeersV0 wraps the bare peer array written before the file was
+// versioned in the version 1 envelope
+func migratePeersV0(data []byte) ([]byte, error) {
+	var peers []json.RawMessage
+	if err := json.Unmarshal(data, &peers); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"version": 1,
+		"peers":   peers,
+	})
+}
+
+// newPeerFile wraps peers in the current envelope
+func newPeerFile(peers []*Peer) peerFile {
+	if peers == nil {
+		peers = []*Peer{}
+	}
+	return peerFile{Version: PeerStoreVersion, Peers: peers}
+}
+
+// peerFileVersion reports the version of a peer file. Files from before
+// versioning are a bare array and count as version 0.
+func peerFileVersion(data []byte) (int, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return 0, nil
+	}
+
+	var header struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	if header.Version == nil {
+		return 0, fmt.Errorf("peer file has no version")
+	}
+	return *header.Version, nil
+}
+
+// decodePeers reads a peer file of any version this server understands,
+// migrating older versions on the way, and returns the peers along with the
+// version the file was in. A file written by a newer server is refused
+// rather than loaded with its new fields silently dropped.
+func decodePeers(data []byte) ([]*Peer, int, error) {
+	version, err := peerFileVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version > PeerStoreVersion {
+		return nil, version, fmt.Errorf("peer file version %d is newer than this server supports (%d); upgrade the server", version, PeerStoreVersion)
+	}
+	if version < 0 {
+		return nil, version, fmt.Errorf("invalid peer file version %d", version)
+	}
+
+	for from := version; from < PeerStoreVersion; from++ {
+		migrate, ok := peerMigrations[from]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from peer file version %d", from)
+		}
+		if data, err = migrate(data); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate peer file from version %d: %w", from, err)
+		}
+	}
+
+	var file peerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, version, err
+	}
+	return file.Peers, version, nil
+}
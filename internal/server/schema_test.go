@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMigrateUnversionedStore(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "peers-v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "peers.json")
+	if err := os.WriteFile(path, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewPeerStore(path)
+	if err != nil {
+		t.Fatalf("NewPeerStore: %v", err)
+	}
+	peers, err := store.LoadPeers()
+	if err != nil {
+		t.Fatalf("LoadPeers: %v", err)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	if len(peers) != 2 {
+		t.Fatalf("loaded %d peers, want 2", len(peers))
+	}
+
+	gateway, laptop := peers[0], peers[1]
+	if laptop.ID != "peer-laptop" || laptop.VirtualIP != "10.100.0.2" || laptop.Endpoint != "192.0.2.10:51820" || !laptop.Online {
+		t.Errorf("laptop: %+v", laptop)
+	}
+	if want := time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC); laptop.CreatedAt == nil || !laptop.CreatedAt.Equal(want) {
+		t.Errorf("laptop created at %v, want %v", laptop.CreatedAt, want)
+	}
+	if len(laptop.History) != 1 || !laptop.History[0].Online {
+		t.Errorf("laptop history: %+v", laptop.History)
+	}
+	if gateway.Status != PeerStatusSuspended || !gateway.ExitNode || !reflect.DeepEqual(gateway.AllowedIPs, []string{"10.100.0.3/32", "192.168.10.0/24"}) {
+		t.Errorf("gateway: %+v", gateway)
+	}
+
+	// The original is kept for a downgrade, byte for byte
+	if backup, err := os.ReadFile(path + ".v0"); err != nil || !bytes.Equal(backup, original) {
+		t.Errorf("backup: %v, equal %v", err, bytes.Equal(backup, original))
+	}
+
+	// and the store is rewritten in the current format
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header struct {
+		Version int               `json:"version"`
+		Peers   []json.RawMessage `json:"peers"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		t.Fatalf("migrated store: %v\n%s", err, data)
+	}
+	if header.Version != PeerStoreVersion || len(header.Peers) != 2 {
+		t.Errorf("migrated store has version %d and %d peers", header.Version, len(header.Peers))
+	}
+
+	// Loading the migrated store needs no migration and gives the same peers
+	reloaded, version, err := decodePeers(data)
+	if err != nil || version != PeerStoreVersion {
+		t.Fatalf("decodePeers: version %d: %v", version, err)
+	}
+	sort.Slice(reloaded, func(i, j int) bool { return reloaded[i].ID < reloaded[j].ID })
+	if !reflect.DeepEqual(reloaded, peers) {
+		t.Errorf("reloaded peers differ:\n got %+v\nwant %+v", reloaded, peers)
+	}
+}
+
+func TestDecodePeersVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		peers   int
+		version int
+		ok      bool
+	}{
+		{"unversioned", `[{"id":"a"}]`, 1, 0, true},
+		{"unversioned empty", ` [] `, 0, 0, true},
+		{"current", `{"version":1,"peers":[{"id":"a"},{"id":"b"}]}`, 2, 1, true},
+		{"newer", `{"version":2,"peers":[]}`, 0, 2, false},
+		{"negative", `{"version":-1,"peers":[]}`, 0, -1, false},
+		{"no version", `{"peers":[]}`, 0, 0, false},
+		{"garbage", `not json`, 0, 0, false},
+		{"bad unversioned", `[1, 2`, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peers, version, err := decodePeers([]byte(tt.data))
+			if tt.ok != (err == nil) {
+				t.Fatalf("decodePeers: %v, want ok %v", err, tt.ok)
+			}
+			if len(peers) != tt.peers || version != tt.version {
+				t.Errorf("got %d peers at version %d, want %d at %d", len(peers), version, tt.peers, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,1158 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/lockfile"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+	"github.com/vpn/wireguard-mesh/pkg/version"
+)
+
+const (
+	HeartbeatTimeout = 2 * time.Minute
+	CleanupInterval  = 1 * time.Minute
+
+	// Idle keep-alive connections outlive the client heartbeat and sync
+	// intervals, so each client keeps reusing one connection
+	readHeaderTimeout = 10 * time.Second
+	idleTimeout       = 2 * time.Minute
+)
+
+var (
+	errPeerNotFound  = errors.New("peer not found")
+	errPeerSuspended = errors.New("peer suspended")
+)
+
+// Server represents the VPN coordination server
+type Server struct {
+	config           *config.ServerConfig
+	ipAllocator      *network.IPAllocator
+	peers            map[string]*Peer
+	peersByKey       map[string]string
+	mu               timedMutex
+	metrics          *serverMetrics
+	privateKey       string
+	publicKey        string
+	signingKey       ed25519.PrivateKey       // Signs peer lists
+	keyEndorsement   *protocol.KeyEndorsement // Vouches for the keys after a rotation
+	lock             *lockfile.Lock           // Held on the peer store while running
+	store            peerStore
+	allocations      *AllocationStore
+	authKeys         *AuthKeyStore
+	usage            *UsageStore
+	audit            *AuditLog
+	conflicts        []protocol.AllowedIPsConflict
+	addressConflicts map[string]AddressConflict // Reported duplicate virtual IPs, keyed by address
+	idempotency      *idempotencyCache          // Recent registrations, replayed to retries
+	waitlist         waitlist                   // New peers waiting for an address
+	events           eventBroker
+	lastPeerID       int64
+	version          uint64 // Peer list version, bumped whenever what peers see changes
+
+	mesh *meshMember // The server's own interface, with join_mesh
+
+	// Replica mode: following the primary's replication stream
+	replicaCancel context.CancelFunc
+	replicaDone   chan struct{}
+
+	trustedProxies []*net.IPNet
+	httpServer     *http.Server
+	pprofServer    *http.Server     // With pprof_addr
+	stunConns      []net.PacketConn // With stun_ports
+	ready          chan struct{}
+	draining       bool // Refusing new peers, see Drain
+
+	// Background routines started by StartBackground, stopped by Shutdown
+	stop     chan struct{}
+	stopOnce sync.Once
+	routines sync.WaitGroup
+}
+
+// NewServer creates a new VPN coordination server
+func NewServer(cfg *config.ServerConfig) (*Server, error) {
+	ipAllocator, err := newIPAllocator(cfg.NetworkCIDR, cfg.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IP allocator: %w", err)
+	}
+
+	// Generate or load server keys
+	var privateKey, publicKey string
+	if cfg.PrivateKey == "" {
+		keyPair, err := crypto.GenerateKeyPair()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate server keys: %w", err)
+		}
+		privateKey = keyPair.PrivateKeyToString()
+		publicKey = keyPair.PublicKeyToString()
+
+		// Save keys to config
+		cfg.PrivateKey = privateKey
+		cfg.PublicKey = publicKey
+		if err := config.SaveServerConfig(config.GetDefaultServerConfigPath(), cfg); err != nil {
+			log.Printf("Warning: failed to save server config: %v", err)
+		}
+	} else {
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid server keys: %w", err)
+		}
+		privateKey = cfg.PrivateKey
+		publicKey = cfg.PublicKey
+		if publicKey == "" {
+			if publicKey, err = crypto.DerivePublicKeyString(privateKey); err != nil {
+				return nil, fmt.Errorf("invalid server private key: %w", err)
+			}
+			cfg.PublicKey = publicKey
+		}
+	}
+
+	signingKey, err := loadSigningKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MinimumClientVersion != "" {
+		if _, err := version.Parse(cfg.MinimumClientVersion); err != nil {
+			return nil, fmt.Errorf("invalid minimum_client_version: %w", err)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := LockStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewPeerStore(cfg.DBPath)
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to create peer store: %w", err)
+	}
+
+	allocations, err := NewAllocationStore(AllocationsPath(cfg))
+	if err != nil {
+		store.Close()
+		lock.Release()
+		return nil, fmt.Errorf("failed to create allocation store: %w", err)
+	}
+
+	authKeys, err := NewAuthKeyStore(AuthKeysPath(cfg))
+	if err != nil {
+		store.Close()
+		allocations.Close()
+		lock.Release()
+		return nil, fmt.Errorf("failed to create auth key store: %w", err)
+	}
+
+	usage, err := NewUsageStore(UsagePath(cfg))
+	if err != nil {
+		store.Close()
+		allocations.Close()
+		authKeys.Close()
+		lock.Release()
+		return nil, fmt.Errorf("failed to create usage store: %w", err)
+	}
+
+	metrics := newServerMetrics(time.Duration(cfg.SlowThreshold) * time.Millisecond)
+	store.ObserveWrites(metrics.observeWrite)
+	ipAllocator.SetScanObserver(metrics.observeScan)
+
+	s := &Server{
+		config:           cfg,
+		metrics:          metrics,
+		ipAllocator:      ipAllocator,
+		peers:            make(map[string]*Peer),
+		peersByKey:       make(map[string]string),
+		addressConflicts: make(map[string]AddressConflict),
+		idempotency:      newIdempotencyCache(),
+		privateKey:       privateKey,
+		publicKey:        publicKey,
+		signingKey:       signingKey,
+		keyEndorsement:   currentEndorsement(cfg, publicKey, signingKey),
+		lock:             lock,
+		store:            timedStore{peerStore: store, metrics: metrics},
+		allocations:      allocations,
+		authKeys:         authKeys,
+		usage:            usage,
+		audit:            NewAuditLog(AuditLogPath(cfg), cfg.AuditLogMaxSize, time.Duration(cfg.AuditLogMaxAge)*time.Second),
+		// Start from the clock so that clients resync after a restart
+		version: uint64(time.Now().UnixNano()),
+
+		trustedProxies: trustedProxies,
+		ready:          make(chan struct{}),
+		stop:           make(chan struct{}),
+	}
+	s.mu.metrics = metrics
+
+	// Load existing peers from store
+	if err := s.loadPeersFromStore(); err != nil {
+		store.Close()
+		allocations.Close()
+		authKeys.Close()
+		usage.Close()
+		lock.Release()
+		return nil, fmt.Errorf("failed to load peers from store: %w", err)
+	}
+
+	return s, nil
+}
+
+// Start starts the server
+func (s *Server) Start() error {
+	if err := s.StartBackground(); err != nil {
+		return err
+	}
+
+	listener, err := s.listen()
+	if err != nil {
+		s.leaveMesh()
+		s.stopReplica()
+		s.stopPprof()
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s.mu.Lock()
+	s.httpServer = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	s.httpServer.RegisterOnShutdown(s.events.close)
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	log.Printf("Server starting on %s", listener.Addr())
+	log.Printf("Server public key: %s", s.publicKey)
+	log.Printf("Network CIDR: %s", s.config.NetworkCIDR)
+
+	// Peers were loaded in NewServer and the listener is bound
+	close(s.ready)
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// StartBackground starts the server's background work without listening:
+// the cleanup or replication routine, snapshots and joining the mesh. Start
+// calls it; use it directly to serve Handler from another HTTP server, such
+// as an httptest server in a simulation.
+func (s *Server) StartBackground() error {
+	// A replica takes peer state, online status included, from the primary
+	if s.readOnly() {
+		s.startReplica()
+	} else {
+		s.routines.Add(1)
+		go s.cleanupRoutine()
+	}
+
+	if s.config.SnapshotInterval > 0 {
+		s.routines.Add(1)
+		go s.snapshotRoutine()
+	}
+
+	if s.config.AuditLogMaxAge > 0 {
+		s.routines.Add(1)
+		go s.auditRoutine()
+	}
+
+	if err := s.startPprof(); err != nil {
+		return err
+	}
+	if err := s.startSTUN(); err != nil {
+		return err
+	}
+	return s.joinMesh()
+}
+
+// Ready returns a channel that is closed once the server is accepting
+// requests
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Healthy reports whether the server is responsive. It blocks if the server
+// mutex is wedged, which is exactly what a watchdog should notice.
+func (s *Server) Healthy() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return true
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones to finish,
+// then flushes the peer store, allocation table and auth keys. A unix socket
+// listener is removed from the filesystem.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.RLock()
+	httpServer := s.httpServer
+	s.mu.RUnlock()
+
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+	}
+	// Also ends event streams served through Handler from another server
+	s.events.close()
+	s.stopPprof()
+	s.stopSTUN()
+
+	// The cleanup routine writes to the store, so it must stop first
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.routines.Wait()
+
+	if meshErr := s.leaveMesh(); meshErr != nil && err == nil {
+		err = meshErr
+	}
+	s.stopReplica()
+
+	if closeErr := s.store.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to flush peer store: %w", closeErr)
+	}
+	if closeErr := s.allocations.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to flush allocation table: %w", closeErr)
+	}
+	if closeErr := s.authKeys.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to flush auth keys: %w", closeErr)
+	}
+	if closeErr := s.usage.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to flush usage table: %w", closeErr)
+	}
+	if closeErr := s.audit.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close audit log: %w", closeErr)
+	}
+
+	// Only once everything is on disk may another server take over
+	s.lock.Release()
+	return err
+}
+
+// Handler returns the HTTP handler serving the server's API, mounted under
+// the configured base path
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", s.primaryOnly(s.handleRegister))
+	mux.HandleFunc("/unregister", s.primaryOnly(s.handleUnregister))
+	mux.HandleFunc("/heartbeat", s.primaryOnly(s.handleHeartbeat))
+	mux.HandleFunc("/peers", s.handlePeerList)
+	mux.HandleFunc("/version", s.handleVersion)
+	mux.HandleFunc("/replication", s.handleReplication)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+
+	mux.HandleFunc("/admin/conflicts", s.requireAdmin(s.handleAdminConflicts))
+	mux.HandleFunc("/admin/peers", s.requireAdmin(s.handleAdminPeers))
+	mux.HandleFunc("/admin/peers/", s.requireAdmin(s.handleAdminPeer))
+	mux.HandleFunc("/admin/events", s.requireAdmin(s.handleAdminEvents))
+	mux.HandleFunc("/admin/export", s.requireAdmin(s.handleAdminExport))
+	mux.HandleFunc("/admin/snapshots", s.requireAdmin(s.handleAdminSnapshots))
+	mux.HandleFunc("/admin/restore", s.requireAdmin(s.handleAdminRestore))
+	mux.HandleFunc("/admin/allocations", s.requireAdmin(s.handleAdminAllocations))
+	mux.HandleFunc("/admin/allocations/", s.requireAdmin(s.handleAdminAllocation))
+	mux.HandleFunc("/admin/authkeys", s.requireAdmin(s.handleAdminAuthKeys))
+	mux.HandleFunc("/admin/authkeys/", s.requireAdmin(s.handleAdminAuthKey))
+	mux.HandleFunc("/admin/limits", s.requireAdmin(s.handleAdminLimits))
+	mux.HandleFunc("/admin/invites", s.requireAdmin(s.handleAdminInvites))
+	mux.HandleFunc("/admin/invites/", s.requireAdmin(s.handleAdminInvite))
+	mux.HandleFunc("/admin/usage", s.requireAdmin(s.handleAdminUsage))
+	mux.HandleFunc("/admin/drain", s.requireAdmin(s.handleAdminDrain))
+	mux.HandleFunc("/admin/metrics", s.requireAdmin(s.handleAdminMetrics))
+	if !s.config.DisableAdminUI {
+		mux.HandleFunc("/admin/", s.handleAdminUI)
+	}
+
+	handler := s.metrics.instrument(mux)
+	if base := s.config.URLBasePath(); base != "" {
+		// The routes see their paths as if the server were at the root
+		root := http.NewServeMux()
+		root.Handle(base+"/", http.StripPrefix(base, handler))
+		return root
+	}
+	return handler
+}
+
+// handleRegister handles peer registration requests
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req protocol.RegisterRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxRegisterBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if param, err := s.validateRegistration(&req); err != nil {
+		log.Printf("Refused registration from %s: invalid %s: %v", s.clientIP(r), param, err)
+		writeInvalidParameter(w, param, err)
+		return
+	}
+
+	observedAddr := s.clientAddr(r)
+	observedIP := observedHost(observedAddr)
+	if err := s.checkClientVersion(req.ClientVersion); err != nil {
+		log.Printf("Refused registration of %s from %s: %v", req.Hostname, observedIP, err)
+		json.NewEncoder(w).Encode(protocol.RegisterResponse{
+			Success:        false,
+			Error:          err.Error(),
+			Code:           protocol.ErrorCodeVersionTooOld,
+			MinimumVersion: s.config.MinimumClientVersion,
+		})
+		return
+	}
+	if req.DryRun {
+		json.NewEncoder(w).Encode(s.checkRegistration(req.PublicKey))
+		return
+	}
+	resp := s.register(&req, observedAddr)
+	if resp.Code == protocol.ErrorCodeRetryLater {
+		log.Printf("Refused registration of %s from %s: draining", req.Hostname, observedIP)
+		writeRetryLater(w, resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// register adds a peer or refreshes an existing one. Only the peer table and
+// IP allocator are touched under the mutex; the store copies the peer and
+// writes it out in the background. A retry carrying the idempotency key of
+// a recent successful registration gets that registration's response, or
+// ErrorCodeIdempotencyKeyReused if it asks for something else. While
+// the server drains, new peers are told to retry later. observedAddr is
+// where the request came from.
+func (s *Server) register(req *protocol.RegisterRequest, observedAddr string) protocol.RegisterResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	digest := registrationDigest(req)
+	resp, ok, err := s.idempotency.get(req.IdempotencyKey, req.PublicKey, digest, now)
+	if err != nil {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   err.Error(),
+			Code:    protocol.ErrorCodeIdempotencyKeyReused,
+		}
+	}
+	if ok {
+		log.Printf("Replaying registration of %s for retried request", resp.PeerID)
+		return resp
+	}
+	if s.refusesNewPeer(req.PublicKey) {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   "Server is draining; retry later",
+			Code:    protocol.ErrorCodeRetryLater,
+		}
+	}
+
+	resp = s.registerLocked(req, observedAddr)
+	if resp.Success {
+		s.idempotency.put(req.IdempotencyKey, req.PublicKey, digest, resp, now)
+	}
+	return resp
+}
+
+// checkRegistration answers a dry-run registration: whether the public key
+// still belongs to a peer the server would take back. Anyone may ask, so the
+// answer is only that verdict and the server's own keys, never the peer's ID
+// or address. Nothing is changed, so the peer is not marked as seen.
+func (s *Server) checkRegistration(publicKey string) protocol.RegisterResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peerID, exists := s.peersByKey[publicKey]
+	if !exists || publicKey == s.publicKey {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   "No peer has this public key",
+			Code:    protocol.ErrorCodeUnknownPeer,
+		}
+	}
+	peer := s.peers[peerID]
+	if peer.Status == PeerStatusSuspended {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   "Peer suspended",
+			Code:    protocol.ErrorCodePeerSuspended,
+		}
+	}
+	return protocol.RegisterResponse{
+		Success:          true,
+		ServerPublicKey:  s.publicKey,
+		ServerSigningKey: crypto.SigningPublicKeyString(s.signingKey),
+		KeyEndorsement:   s.keyEndorsement,
+	}
+}
+
+// registerLocked performs a registration. Callers must hold s.mu.
+func (s *Server) registerLocked(req *protocol.RegisterRequest, observedAddr string) protocol.RegisterResponse {
+	observedIP := observedHost(observedAddr)
+	if req.PublicKey == s.publicKey {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   "Public key belongs to the server",
+		}
+	}
+
+	// Only endpoints other peers could use are stored
+	endpoints, rejected := s.screenEndpoints(req.Endpoints, observedAddr)
+	req.Endpoint, req.Endpoints = "", endpoints
+	if len(endpoints) > 0 {
+		req.Endpoint = endpoints[0]
+	}
+
+	// Check if peer already exists
+	if peerID, exists := s.peersByKey[req.PublicKey]; exists {
+		peer := s.peers[peerID]
+
+		merged := mergePeer(*peer, req, s.exitNodeAllowed(peer, req), s.forcedEphemeral(peer))
+		if err := s.checkRouteConflicts(peer.ID, merged.AllowedIPs); err != nil {
+			return protocol.RegisterResponse{
+				Success: false,
+				Error:   err.Error(),
+			}
+		}
+		if recordObserved(&merged, observedAddr) || visiblyChanged(peer, &merged) {
+			s.version++
+		}
+		*peer = merged
+		recordRejectedEndpoints(peer, rejected)
+		if now := time.Now(); peer.Status == PeerStatusSuspended {
+			// Heard from, but a suspended peer stays offline until it is
+			// reinstated
+			peer.LastSeen = &now
+		} else {
+			markSeen(peer, now)
+		}
+		s.refreshConflicts(peer)
+
+		s.store.SavePeer(peer)
+		s.publishPeer(EventPeerUpdated, peer)
+
+		return protocol.RegisterResponse{
+			Success:          true,
+			AssignedIP:       peer.VirtualIP,
+			NetworkCIDR:      s.ipAllocator.GetNetworkCIDR(),
+			PeerID:           peer.ID,
+			ServerPublicKey:  s.publicKey,
+			ServerSigningKey: crypto.SigningPublicKeyString(s.signingKey),
+			KeyEndorsement:   s.keyEndorsement,
+			ObservedIP:       observedIP,
+			Keepalive:        s.config.RecommendedKeepalive,
+			Topology:         s.topology(),
+			DNSServers:       s.config.DNSServers,
+			MTU:              s.config.MTU,
+			STUNPorts:        s.config.STUNPorts,
+		}
+	}
+
+	// New peers must present a usable auth key if one is required
+	authKey, err := s.authorizeRegistration(req.AuthKey)
+	if err == nil {
+		// Checked here, under the same lock as the address allocation
+		candidate := &Peer{PublicKey: req.PublicKey, Hostname: req.Hostname, ObservedAddr: observedAddr}
+		if authKey == nil && s.config.RequireApproval {
+			candidate.Status = PeerStatusPending
+		}
+		err = s.checkPeerLimitsLocked(candidate, authKey, time.Now())
+	}
+	if err != nil {
+		log.Printf("Refused registration of %s: %v", req.Hostname, err)
+		resp := protocol.RegisterResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+		if errors.Is(err, errLimitExceeded) {
+			resp.Code = protocol.ErrorCodeLimitExceeded
+		}
+		return resp
+	}
+
+	// The auth key's tags decide which group's range the address comes
+	// from, and may allow the peer to be an exit node
+	var tags []string
+	if authKey != nil {
+		tags = authKey.Tags
+	}
+	guest := authKey != nil && authKey.Guest != nil
+	exitNode := req.ExitNode && !guest && s.exitNodeAllowed(&Peer{Hostname: req.Hostname, Tags: tags}, req)
+
+	// Check advertised routes before spending an address on the peer
+	now := time.Now()
+	peerID := s.generatePeerID()
+	if err := s.checkRouteConflicts(peerID, peerAllowedIPs("", req.AllowedIPs, exitNode)); err != nil {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
+	group := s.peerGroup(tags)
+
+	// Freed addresses go to waiting clients first, in order
+	if s.waitlist.ahead(req.PublicKey, group, now) >= s.freeAddresses(group) {
+		return s.poolExhausted(req, group, now)
+	}
+
+	// Allocate new IP
+	ip, err := s.allocatePeerIP(group)
+	if errors.Is(err, network.ErrPoolExhausted) {
+		return s.poolExhausted(req, group, now)
+	}
+	if err != nil {
+		return protocol.RegisterResponse{
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+	s.waitlist.leave(req.PublicKey)
+	s.allocations.Set(Allocation{IP: ip, Owner: peerID, AllocatedAt: now})
+
+	// Create new peer
+	peer := &Peer{
+		ID:            peerID,
+		PublicKey:     req.PublicKey,
+		VirtualIP:     ip,
+		Endpoint:      req.Endpoint,
+		Endpoints:     req.Endpoints,
+		Hostname:      req.Hostname,
+		OS:            req.OS,
+		ClientVersion: req.ClientVersion,
+		Backend:       req.Backend,
+		AllowedIPs:    peerAllowedIPs(ip, req.AllowedIPs, exitNode),
+		ExitNode:      exitNode,
+		Ephemeral:     req.Ephemeral,
+		Hidden:        req.Hidden,
+		Group:         group,
+		ObservedAddr:  observedAddr,
+		CreatedAt:     &now,
+	}
+	markSeen(peer, now)
+	recordRejectedEndpoints(peer, rejected)
+	if authKey != nil {
+		// The key stands in for an administrator's approval
+		peer.Tags = append([]string(nil), authKey.Tags...)
+		peer.Ephemeral = peer.Ephemeral || authKey.Ephemeral
+		peer.AuthKeyID = authKey.ID
+		s.authKeys.Use(authKey.ID)
+		if guest {
+			grantGuestAccess(peer, authKey.Guest, now)
+		}
+	} else if s.config.RequireApproval {
+		peer.Status = PeerStatusPending
+	}
+
+	s.peers[peerID] = peer
+	s.peersByKey[req.PublicKey] = peerID
+	s.refreshConflicts(peer)
+
+	s.store.SavePeer(peer)
+	s.publishPeer(EventPeerRegistered, peer)
+	s.removeDuplicatesLocked(peer)
+
+	kind := "peer"
+	if peer.Ephemeral {
+		kind = "ephemeral peer"
+	}
+	log.Printf("Registered new %s: %s (%s) with IP %s from %s", kind, peerID, req.Hostname, ip, observedIP)
+	if peer.Status == PeerStatusPending {
+		log.Printf("Peer %s is awaiting approval; key fingerprint %s", peerID, crypto.Fingerprint(peer.PublicKey))
+	}
+
+	return protocol.RegisterResponse{
+		Success:          true,
+		AssignedIP:       ip,
+		NetworkCIDR:      s.ipAllocator.GetNetworkCIDR(),
+		PeerID:           peerID,
+		ServerPublicKey:  s.publicKey,
+		ServerSigningKey: crypto.SigningPublicKeyString(s.signingKey),
+		KeyEndorsement:   s.keyEndorsement,
+		ObservedIP:       observedIP,
+		Keepalive:        s.config.RecommendedKeepalive,
+		Topology:         s.topology(),
+		DNSServers:       s.config.DNSServers,
+		MTU:              s.config.MTU,
+		STUNPorts:        s.config.STUNPorts,
+	}
+}
+
+// handleHeartbeat handles heartbeat requests
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req protocol.HeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := protocol.ValidateID(req.PeerID); err != nil {
+		writeInvalidParameter(w, "peer_id", err)
+		return
+	}
+	endpoint, endpoints, err := canonicalEndpoints(req.Endpoint, req.Endpoints)
+	if err != nil {
+		writeInvalidParameter(w, "endpoint", err)
+		return
+	}
+	req.Endpoint, req.Endpoints = endpoint, endpoints
+	if len(req.Handshakes) > maxHandshakeReports {
+		writeInvalidParameter(w, "handshakes", fmt.Errorf("at most %d handshakes may be reported", maxHandshakeReports))
+		return
+	}
+
+	if err := s.checkClientVersion(req.ClientVersion); err != nil {
+		json.NewEncoder(w).Encode(protocol.HeartbeatResponse{
+			Success:        false,
+			Error:          err.Error(),
+			Code:           protocol.ErrorCodeVersionTooOld,
+			MinimumVersion: s.config.MinimumClientVersion,
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.heartbeat(&req, s.clientAddr(r)))
+}
+
+// heartbeat marks a peer as alive and records where it was heard from
+func (s *Server) heartbeat(req *protocol.HeartbeatRequest, observedAddr string) protocol.HeartbeatResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[req.PeerID]
+	if !exists || peer.ID == ServerPeerID {
+		return protocol.HeartbeatResponse{
+			Success: false,
+			Error:   "Peer not found",
+		}
+	}
+
+	if peer.Status == PeerStatusSuspended {
+		return protocol.HeartbeatResponse{
+			Success: false,
+			Error:   "Peer suspended",
+		}
+	}
+	// A peer that left comes back by registering; a heartbeat sent as it
+	// left must not bring it back online
+	if !peer.Online && peer.OfflineReason == OfflineUnregistered {
+		return protocol.HeartbeatResponse{
+			Success: false,
+			Error:   "Peer left the network; register again",
+		}
+	}
+
+	// Coming back online or moving changes what other peers see, and so
+	// does confirming where another peer moved to
+	endpoints, rejected := s.screenEndpoints(req.Endpoints, observedAddr)
+	recordRejectedEndpoints(peer, rejected)
+	moved := s.updateEndpointsLocked(peer, endpoints)
+	if recordObserved(peer, observedAddr) {
+		moved = true
+	}
+	if s.confirmEndpointsLocked(peer, req.Handshakes) {
+		moved = true
+	}
+	if !peer.Online || moved {
+		s.version++
+	}
+	if req.MissedHeartbeats > 0 {
+		log.Printf("Peer %s (%s) is back after %d missed heartbeats", peer.ID, peer.Hostname, req.MissedHeartbeats)
+	}
+	now := time.Now()
+	markSeen(peer, now)
+	if req.ClientVersion != "" {
+		peer.ClientVersion = req.ClientVersion
+	}
+	if req.Backend != "" {
+		peer.Backend = req.Backend
+	}
+
+	s.recordAddressConflicts(peer.ID, req.AddressConflicts)
+	if len(s.addressConflicts) > 0 {
+		s.pruneAddressConflicts()
+	}
+
+	if req.CounterSession != "" {
+		s.usage.Record(peer.ID, req.CounterSession, req.ReceiveBytes, req.TransmitBytes, now)
+	}
+	s.enforceQuota(peer, now)
+
+	s.store.SavePeer(peer)
+	s.publishPeer(EventPeerHeartbeat, peer)
+
+	return protocol.HeartbeatResponse{
+		Success:     true,
+		AssignedIP:  peer.VirtualIP,
+		NetworkCIDR: s.ipAllocator.GetNetworkCIDR(),
+		Version:     s.version,
+	}
+}
+
+// handleVersion reports the server's build
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// handlePeerList handles peer list requests
+func (s *Server) handlePeerList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	peerID := r.URL.Query().Get("peer_id")
+	if peerID == "" {
+		http.Error(w, "Missing peer_id", http.StatusBadRequest)
+		return
+	}
+	if err := protocol.ValidateID(peerID); err != nil {
+		writeInvalidParameter(w, "peer_id", err)
+		return
+	}
+
+	resp, err := s.peerList(peerID)
+	switch {
+	case errors.Is(err, errPeerNotFound):
+		http.Error(w, "Peer not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errPeerSuspended):
+		http.Error(w, "Peer suspended", http.StatusForbidden)
+		return
+	}
+
+	// Clients holding this content already are only told the version
+	resp.KeyEndorsement = s.keyEndorsement
+	hash, err := resp.ContentHash()
+	if err != nil {
+		http.Error(w, "Failed to hash peer list", http.StatusInternalServerError)
+		return
+	}
+	etag := `"` + hash + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set(protocol.PeerListVersionHeader, strconv.FormatUint(resp.Version, 10))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		// Signed so that the client can trust its list is still current
+		if err := s.signNotModified(w.Header(), peerID, etag, resp.Version, time.Now()); err != nil {
+			http.Error(w, "Failed to sign peer list", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := s.signPeerList(&resp, peerID, time.Now()); err != nil {
+		http.Error(w, "Failed to sign peer list", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// etagMatches reports whether an If-None-Match header names etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// peerList returns the peers visible to the given peer
+func (s *Server) peerList(peerID string) (protocol.PeerListResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Verify peer exists
+	requester, exists := s.peers[peerID]
+	if !exists {
+		return protocol.PeerListResponse{}, errPeerNotFound
+	}
+	if requester.Status == PeerStatusSuspended {
+		return protocol.PeerListResponse{}, errPeerSuspended
+	}
+
+	// Consider all other active peers, leaving out hidden ones unless the
+	// requester may see them, and let the topology pick from those; pending
+	// peers see an empty mesh until they are approved
+	peers := []protocol.PeerInfo{}
+	if requester.Status == PeerStatusActive {
+		seesHidden := s.seesHiddenPeers(requester)
+		candidates := make([]*Peer, 0, len(s.peers)-1)
+		for id, peer := range s.peers {
+			if id != peerID && peer.Status == PeerStatusActive && (!peer.Hidden || seesHidden) && reachable(requester, peer) {
+				candidates = append(candidates, peer)
+			}
+		}
+		peers = s.topologyPeers(requester, candidates)
+
+		// Each peer's endpoints are ordered for where the requester sits
+		for i := range peers {
+			if peer, ok := s.peers[peers[i].ID]; ok {
+				personalizeEndpoints(&peers[i], requester, peer)
+			}
+		}
+
+		if requester.ExitRoutesDenied || requester.QuotaExceeded && s.quotaAction() == QuotaActionRevokeExit {
+			for i := range peers {
+				withoutExitRoutes(&peers[i])
+			}
+		}
+	}
+
+	resp := protocol.PeerListResponse{
+		Peers:          peers,
+		Conflicts:      append([]protocol.AllowedIPsConflict(nil), s.conflicts...),
+		Version:        s.version,
+		AllowedSources: allowedSources(peers),
+	}
+	resp.Canonicalize()
+	return resp, nil
+}
+
+// cleanupRoutine periodically cleans up stale peers until Shutdown
+func (s *Server) cleanupRoutine() {
+	defer s.routines.Done()
+
+	ticker := time.NewTicker(s.cleanupInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+
+		s.mu.Lock()
+		now := time.Now()
+		changed := false
+
+		// The server's own peer is alive as long as the server is
+		if s.config.JoinMesh {
+			if self, ok := s.peers[ServerPeerID]; ok {
+				markSeen(self, now)
+			}
+		}
+
+		for id, peer := range s.peers {
+			// Guests lose access when their invite's time runs out
+			if guestAccessExpired(peer, now) {
+				log.Printf("Guest access of peer %s (%s) expired", id, peer.Hostname)
+				s.removePeerLocked(peer, OfflineExpired)
+				continue
+			}
+			// Ephemeral peers get a short grace period to reconnect and
+			// are then removed entirely
+			if peer.Ephemeral && now.Sub(peer.LastHeartbeat) > s.ephemeralTimeout() {
+				log.Printf("Ephemeral peer %s (%s) expired", id, peer.Hostname)
+				s.removePeerLocked(peer, OfflineExpired)
+				continue
+			}
+			if now.Sub(peer.LastHeartbeat) > s.heartbeatTimeout() {
+				if setOffline(peer, OfflineHeartbeatTimeout, now) {
+					changed = true
+					log.Printf("Peer %s (%s) went offline", id, peer.Hostname)
+					s.store.SavePeer(peer)
+					s.publishPeer(EventPeerOffline, peer)
+				}
+			}
+		}
+
+		// Preferred owners of contested prefixes depend on online status
+		if changed {
+			s.conflicts = findConflicts(s.peers)
+		}
+
+		s.recheckQuotas(now)
+
+		s.mu.Unlock()
+	}
+}
+
+// loadPeersFromStore loads peers from persistent storage
+func (s *Server) loadPeersFromStore() error {
+	peers, err := s.store.LoadPeers()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, peer := range peers {
+		s.peers[peer.ID] = peer
+		s.peersByKey[peer.PublicKey] = peer.ID
+	}
+
+	// Peers left behind by a change of network CIDR must be renumbered,
+	// which changes their addresses under them, so only do it on request
+	outside, err := peersOutsideNetwork(s.config.NetworkCIDR, s.peers)
+	if err != nil {
+		return err
+	}
+	if len(outside) > 0 && !s.config.MigrateNetwork {
+		return fmt.Errorf("%d stored peers (e.g. %s at %s) lie outside network %s; restart with --migrate-network to renumber them",
+			len(outside), outside[0].ID, outside[0].VirtualIP, s.config.NetworkCIDR)
+	}
+	inside := make(map[string]*Peer, len(s.peers))
+	for id, peer := range s.peers {
+		inside[id] = peer
+	}
+	for _, peer := range outside {
+		delete(inside, peer.ID)
+	}
+	var table []Allocation
+	for _, allocation := range s.allocations.List() {
+		if peer, ok := s.peers[allocation.Owner]; ok && inside[peer.ID] == nil {
+			continue
+		}
+		table = append(table, allocation)
+	}
+
+	// Load the allocation table, filling in peers it does not know about
+	ipAllocator, allocations, err := buildAllocations(s.config.NetworkCIDR, s.config.Groups, table, s.config.ReservedIPs, inside)
+	if err != nil {
+		return err
+	}
+	if len(outside) > 0 {
+		if err := renumberPeers(ipAllocator, outside); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, peer := range outside {
+			allocations = append(allocations, Allocation{IP: peer.VirtualIP, Owner: peer.ID, Note: "renumbered", AllocatedAt: now})
+			s.store.SavePeer(peer)
+		}
+		if err := s.store.Flush(); err != nil {
+			return fmt.Errorf("failed to write renumbered peers: %w", err)
+		}
+		log.Printf("Renumbered %d peers into %s", len(outside), s.config.NetworkCIDR)
+	}
+	ipAllocator.SetScanObserver(s.metrics.observeScan)
+	s.ipAllocator = ipAllocator
+	if err := s.allocations.Replace(allocations); err != nil {
+		log.Printf("Warning: failed to write allocation table: %v", err)
+	}
+
+	s.conflicts = findConflicts(s.peers)
+
+	log.Printf("Loaded %d peers from store", len(peers))
+	return nil
+}
+
+// peerAllowedIPs builds the AllowedIPs other peers route to a peer: its
+// virtual IP, any subnets it advertises and a default route for exit nodes
+func peerAllowedIPs(virtualIP string, routes []string, exitNode bool) []string {
+	var allowedIPs []string
+	if virtualIP != "" {
+		allowedIPs = append(allowedIPs, virtualIP+"/32")
+	}
+	allowedIPs = append(allowedIPs, routes...)
+	if exitNode {
+		allowedIPs = append(allowedIPs, "0.0.0.0/0")
+	}
+	return allowedIPs
+}
+
+// exitNodeAllowed reports whether a peer registering as an exit node may be
+// one under exit_nodes_allowed, logging a refusal. The peer then registers
+// as an ordinary peer. Callers must hold s.mu.
+func (s *Server) exitNodeAllowed(peer *Peer, req *protocol.RegisterRequest) bool {
+	if !req.ExitNode {
+		return true
+	}
+	if peer.ExpiresAt != nil {
+		log.Printf("Guest peer %s may not be an exit node; registering it without the default route", peer.Hostname)
+		return false
+	}
+	if len(s.config.ExitNodesAllowed) == 0 {
+		return true
+	}
+	if matchesAnySelector(s.config.ExitNodesAllowed, peer) {
+		return true
+	}
+	log.Printf("Peer %s is not allowed to be an exit node; registering it without the default route", peer.Hostname)
+	return false
+}
+
+// refreshConflicts recomputes the conflict list after a peer's routes or
+// online state changed. The computation is skipped when the peer neither was
+// nor is part of a conflict, since the list cannot have changed. Callers must
+// hold s.mu.
+func (s *Server) refreshConflicts(peer *Peer) {
+	if !involvedIn(peer.ID, s.conflicts) && !overlapsAny(peer.ID, peer.AllowedIPs, s.peers) {
+		return
+	}
+	s.conflicts = findConflicts(s.peers)
+}
+
+// checkRouteConflicts checks a peer's prospective AllowedIPs against the
+// rest of the mesh, returning an error if the conflict policy rejects them.
+// Callers must hold s.mu.
+func (s *Server) checkRouteConflicts(peerID string, allowedIPs []string) error {
+	conflicts := conflictsFor(peerID, allowedIPs, s.peers)
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		descriptions = append(descriptions, fmt.Sprintf("%s (%s)", conflict.Prefix, strings.Join(conflict.PeerIDs, ", ")))
+	}
+
+	if s.config.AllowedIPsConflicts == ConflictPolicyReject {
+		return fmt.Errorf("AllowedIPs conflict with existing peers: %s", strings.Join(descriptions, "; "))
+	}
+
+	log.Printf("Warning: AllowedIPs of peer %s conflict with existing peers: %s", peerID, strings.Join(descriptions, "; "))
+	return nil
+}
+
+// generatePeerID generates a unique peer ID. IDs stay time-ordered, but
+// never repeat even when the clock is too coarse to tell concurrent
+// registrations apart. Callers must hold s.mu.
+func (s *Server) generatePeerID() string {
+	n := time.Now().UnixNano()
+	if n <= s.lastPeerID {
+		n = s.lastPeerID + 1
+	}
+	for {
+		id := fmt.Sprintf("peer-%d", n)
+		if _, exists := s.peers[id]; !exists {
+			s.lastPeerID = n
+			return id
+		}
+		n++
+	}
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// newTestServer returns a server keeping its stores in a temporary directory,
+// with its configuration adjusted by configure. Its background routines are
+// not started: tests drive it through Handler.
+func newTestServer(t testing.TB, configure ...func(*config.ServerConfig)) *Server {
+	t.Helper()
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	cfg := config.DefaultServerConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "peers.json")
+	cfg.PrivateKey = keyPair.PrivateKeyToString()
+	cfg.PublicKey = keyPair.PublicKeyToString()
+	for _, f := range configure {
+		f(cfg)
+	}
+
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s
+}
+
+// newRegisterRequest returns a request registering a new peer
+func newRegisterRequest(t testing.TB, hostname string) *protocol.RegisterRequest {
+	t.Helper()
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	return &protocol.RegisterRequest{
+		PublicKey: keyPair.PublicKeyToString(),
+		Hostname:  hostname,
+		OS:        "linux",
+		RequestIP: true,
+	}
+}
+
+// postRegister sends req to the server's /register endpoint and decodes the
+// response, whatever its status
+func postRegister(handler http.Handler, req *protocol.RegisterRequest) (int, protocol.RegisterResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, protocol.RegisterResponse{}, err
+	}
+	r := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var resp protocol.RegisterResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		return w.Code, resp, fmt.Errorf("decoding %d response: %w", w.Code, err)
+	}
+	return w.Code, resp, nil
+}
+
+func TestConcurrentRegistrationsGetDistinctIPs(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	const peers = 100
+	reqs := make([]*protocol.RegisterRequest, peers)
+	for i := range reqs {
+		reqs[i] = newRegisterRequest(t, fmt.Sprintf("peer-%d", i))
+	}
+
+	resps := make([]protocol.RegisterResponse, peers)
+	errs := make([]error, peers)
+	var wg sync.WaitGroup
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			code, resp, err := postRegister(handler, reqs[i])
+			if err == nil && (code != http.StatusOK || !resp.Success) {
+				err = fmt.Errorf("status %d: %s", code, resp.Error)
+			}
+			resps[i], errs[i] = resp, err
+		}(i)
+	}
+	wg.Wait()
+
+	byIP := make(map[string]string, peers)
+	for i, resp := range resps {
+		if errs[i] != nil {
+			t.Errorf("registering %s: %v", reqs[i].Hostname, errs[i])
+			continue
+		}
+		if other, ok := byIP[resp.AssignedIP]; ok {
+			t.Errorf("%s and %s were both assigned %s", other, reqs[i].Hostname, resp.AssignedIP)
+		}
+		byIP[resp.AssignedIP] = reqs[i].Hostname
+	}
+	if err := s.CheckInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	req := newRegisterRequest(t, "laptop")
+	req.IdempotencyKey = "attempt-1"
+	_, first, err := postRegister(handler, req)
+	if err != nil || !first.Success {
+		t.Fatalf("register: %v %s", err, first.Error)
+	}
+
+	// The retry comes from a new endpoint, which a retry may well detect
+	req.Endpoint = "203.0.113.7:51820"
+	code, replay, err := postRegister(handler, req)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if code != http.StatusOK || !replay.Success {
+		t.Fatalf("retry: status %d: %s", code, replay.Error)
+	}
+	if replay.PeerID != first.PeerID || replay.AssignedIP != first.AssignedIP {
+		t.Errorf("retry got %s at %s, want %s at %s",
+			replay.PeerID, replay.AssignedIP, first.PeerID, first.AssignedIP)
+	}
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	req := newRegisterRequest(t, "laptop")
+	req.IdempotencyKey = "attempt-1"
+	if _, resp, err := postRegister(handler, req); err != nil || !resp.Success {
+		t.Fatalf("register: %v %s", err, resp.Error)
+	}
+
+	req.Hostname = "desktop"
+	code, resp, err := postRegister(handler, req)
+	if err != nil {
+		t.Fatalf("reuse: %v", err)
+	}
+	if code != http.StatusOK || resp.Success || resp.Code != protocol.ErrorCodeIdempotencyKeyReused {
+		t.Errorf("reuse got status %d success %v code %q, want a rejection with %q",
+			code, resp.Success, resp.Code, protocol.ErrorCodeIdempotencyKeyReused)
+	}
+
+	// The key is scoped to the public key, so another peer may use it
+	other := newRegisterRequest(t, "phone")
+	other.IdempotencyKey = "attempt-1"
+	if code, resp, err := postRegister(handler, other); err != nil || code != http.StatusOK || !resp.Success {
+		t.Errorf("other peer with the same key: status %d: %v %s", code, err, resp.Error)
+	}
+}
+
+func TestNotModifiedIsSigned(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	_, reg, err := postRegister(handler, newRegisterRequest(t, "laptop"))
+	if err != nil || !reg.Success {
+		t.Fatalf("register: %v %s", err, reg.Error)
+	}
+	getPeers := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/peers?peer_id="+reg.PeerID, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	etag := getPeers("").Header().Get("ETag")
+	w := getPeers(etag)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("conditional GET: status %d, want 304", w.Code)
+	}
+
+	signedAt, err := strconv.ParseInt(w.Header().Get(protocol.PeerListSignedAtHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("signed-at header: %v", err)
+	}
+	version, err := strconv.ParseUint(w.Header().Get(protocol.PeerListVersionHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("version header: %v", err)
+	}
+	signature := w.Header().Get(protocol.PeerListSignatureHeader)
+	payload, err := protocol.NotModifiedSigningPayload(reg.PeerID, etag, version, signedAt)
+	if err != nil {
+		t.Fatalf("NotModifiedSigningPayload: %v", err)
+	}
+	if err := crypto.Verify(reg.ServerSigningKey, payload, signature); err != nil {
+		t.Errorf("304 signature: %v", err)
+	}
+
+	// The signature is bound to the list the client holds
+	other, _ := protocol.NotModifiedSigningPayload(reg.PeerID, `"other"`, version, signedAt)
+	if crypto.Verify(reg.ServerSigningKey, other, signature) == nil {
+		t.Error("304 signature verifies for another list")
+	}
+}
+
+func TestDryRunRevealsOnlyVerdict(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.Handler()
+
+	req := newRegisterRequest(t, "laptop")
+	_, reg, err := postRegister(handler, req)
+	if err != nil || !reg.Success {
+		t.Fatalf("register: %v %s", err, reg.Error)
+	}
+	check := func(publicKey string) protocol.RegisterResponse {
+		t.Helper()
+		_, resp, err := postRegister(handler, &protocol.RegisterRequest{PublicKey: publicKey, OS: "linux", DryRun: true})
+		if err != nil {
+			t.Fatalf("dry run: %v", err)
+		}
+		if resp.PeerID != "" || resp.AssignedIP != "" || resp.NetworkCIDR != "" {
+			t.Errorf("dry run reveals peer %q at %q in %q", resp.PeerID, resp.AssignedIP, resp.NetworkCIDR)
+		}
+		return resp
+	}
+
+	if resp := check(req.PublicKey); !resp.Success || resp.ServerSigningKey != reg.ServerSigningKey {
+		t.Errorf("known key: success %v signing key %q: %s", resp.Success, resp.ServerSigningKey, resp.Error)
+	}
+	if resp := check(newRegisterRequest(t, "other").PublicKey); resp.Code != protocol.ErrorCodeUnknownPeer {
+		t.Errorf("unknown key: code %q, want %q", resp.Code, protocol.ErrorCodeUnknownPeer)
+	}
+	if err := s.setPeerStatus(reg.PeerID, PeerStatusSuspended); err != nil {
+		t.Fatalf("setPeerStatus: %v", err)
+	}
+	if resp := check(req.PublicKey); resp.Code != protocol.ErrorCodePeerSuspended {
+		t.Errorf("suspended peer: code %q, want %q", resp.Code, protocol.ErrorCodePeerSuspended)
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	for _, base := range []string{"/vpn", "/vpn/", "vpn"} {
+		t.Run(base, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *config.ServerConfig) { cfg.BasePath = base })
+			handler := s.Handler()
+
+			get := func(path string) int {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				return w.Code
+			}
+			if code := get("/vpn/version"); code != http.StatusOK {
+				t.Errorf("/vpn/version: status %d, want 200", code)
+			}
+			if code := get("/version"); code != http.StatusNotFound {
+				t.Errorf("/version outside the base path: status %d, want 404", code)
+			}
+			if code := get("/vpnversion"); code != http.StatusNotFound {
+				t.Errorf("/vpnversion: status %d, want 404", code)
+			}
+		})
+	}
+}
+
+func TestBasePathValidation(t *testing.T) {
+	tests := []struct {
+		base  string
+		valid bool
+	}{
+		{"", true},
+		{"/", true},
+		{"/vpn", true},
+		{"/vpn/", true},
+		{"/tools/vpn", true},
+		{"/tools/../vpn", false},
+		{"/tools//vpn", false},
+		{"/vpn?x=1", false},
+		{"/vpn%2f", false},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultServerConfig()
+		cfg.BasePath = tt.base
+		err := cfg.Validate()
+		if got := err == nil || !strings.Contains(err.Error(), "base_path"); got != tt.valid {
+			t.Errorf("base path %q: valid %v, want %v (%v)", tt.base, got, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// loadSigningKey returns the configured peer list signing key, or derives
+// one from the server's WireGuard private key
+func loadSigningKey(cfg *config.ServerConfig) (ed25519.PrivateKey, error) {
+	if cfg.SigningKey != "" {
+		key, err := crypto.ParseSigningKey(cfg.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing_key: %w", err)
+		}
+		return key, nil
+	}
+
+	key, err := crypto.DeriveSigningKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive signing key: %w", err)
+	}
+	return key, nil
+}
+
+// signPeerList timestamps and signs a peer list for the peer peerID, so the
+// client can tell it came unaltered from this server
+func (s *Server) signPeerList(resp *protocol.PeerListResponse, peerID string, now time.Time) error {
+	resp.KeyEndorsement = s.keyEndorsement
+	resp.SignedAt = now.Unix()
+	payload, err := resp.SigningPayload(peerID)
+	if err != nil {
+		return err
+	}
+	resp.Signature = crypto.Sign(s.signingKey, payload)
+	return nil
+}
+
+// signNotModified timestamps and signs, in the headers of a 304, that the
+// list with the given ETag is still current for the peer peerID
+func (s *Server) signNotModified(header http.Header, peerID, etag string, version uint64, now time.Time) error {
+	signedAt := now.Unix()
+	payload, err := protocol.NotModifiedSigningPayload(peerID, etag, version, signedAt)
+	if err != nil {
+		return err
+	}
+	header.Set(protocol.PeerListSignedAtHeader, strconv.FormatInt(signedAt, 10))
+	header.Set(protocol.PeerListSignatureHeader, crypto.Sign(s.signingKey, payload))
+	return nil
+}
+
+// RotateKey gives the server a new WireGuard key pair and records in cfg an
+// endorsement of the new keys signed with the old signing key. Clients that
+// pinned the old keys verify the endorsement and move to the new ones. With
+// a dedicated signing_key only the WireGuard key changes.
+func RotateKey(cfg *config.ServerConfig, now time.Time) error {
+	oldSigningKey, err := loadSigningKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to generate server keys: %w", err)
+	}
+	oldPublicKey := cfg.PublicKey
+	if oldPublicKey == "" {
+		if oldPublicKey, err = crypto.DerivePublicKeyString(cfg.PrivateKey); err != nil {
+			return fmt.Errorf("invalid server private key: %w", err)
+		}
+	}
+	cfg.PrivateKey = keyPair.PrivateKeyToString()
+	cfg.PublicKey = keyPair.PublicKeyToString()
+
+	newSigningKey, err := loadSigningKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	endorsement := &protocol.KeyEndorsement{
+		OldPublicKey:  oldPublicKey,
+		NewPublicKey:  cfg.PublicKey,
+		NewSigningKey: crypto.SigningPublicKeyString(newSigningKey),
+		SignedAt:      now.Unix(),
+	}
+	payload, err := endorsement.SigningPayload()
+	if err != nil {
+		return err
+	}
+	endorsement.Signature = crypto.Sign(oldSigningKey, payload)
+	cfg.KeyEndorsement = endorsement
+	return nil
+}
+
+// currentEndorsement returns the configured key endorsement if it vouches
+// for the server's current keys
+func currentEndorsement(cfg *config.ServerConfig, publicKey string, signingKey ed25519.PrivateKey) *protocol.KeyEndorsement {
+	endorsement := cfg.KeyEndorsement
+	if endorsement == nil {
+		return nil
+	}
+	if endorsement.NewPublicKey != publicKey || endorsement.NewSigningKey != crypto.SigningPublicKeyString(signingKey) {
+		log.Printf("Warning: ignoring key_endorsement, which is for other keys than the server's")
+		return nil
+	}
+	return endorsement
+}
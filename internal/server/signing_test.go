@@ -0,0 +1,149 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestSignPeerList(t *testing.T) {
+	s := newTestServer(t)
+	publicKey := crypto.SigningPublicKeyString(s.signingKey)
+	now := time.Unix(1700000000, 0)
+
+	list := &protocol.PeerListResponse{
+		Peers:   []protocol.PeerInfo{{ID: "peer-b", PublicKey: "a2V5", VirtualIP: "10.100.0.3", AllowedIPs: []string{"10.100.0.3/32"}}},
+		Version: 7,
+	}
+	if err := s.signPeerList(list, "peer-a", now); err != nil {
+		t.Fatalf("signPeerList: %v", err)
+	}
+	if list.SignedAt != now.Unix() {
+		t.Errorf("signed at %d, want %d", list.SignedAt, now.Unix())
+	}
+	verify := func(list *protocol.PeerListResponse, peerID, key string) error {
+		payload, err := list.SigningPayload(peerID)
+		if err != nil {
+			t.Fatalf("SigningPayload: %v", err)
+		}
+		return crypto.Verify(key, payload, list.Signature)
+	}
+
+	if err := verify(list, "peer-a", publicKey); err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	tampered := *list
+	tampered.Peers = []protocol.PeerInfo{list.Peers[0]}
+	tampered.Peers[0].AllowedIPs = []string{"0.0.0.0/0"}
+	if verify(&tampered, "peer-a", publicKey) == nil {
+		t.Error("list with changed AllowedIPs verifies")
+	}
+	replayed := *list
+	replayed.Version = 6
+	if verify(&replayed, "peer-a", publicKey) == nil {
+		t.Error("list with changed version verifies")
+	}
+	if verify(list, "peer-b", publicKey) == nil {
+		t.Error("list verifies for another peer")
+	}
+
+	other := newTestServer(t)
+	if verify(list, "peer-a", crypto.SigningPublicKeyString(other.signingKey)) == nil {
+		t.Error("list verifies with another server's key")
+	}
+}
+
+func TestSignNotModified(t *testing.T) {
+	s := newTestServer(t)
+	publicKey := crypto.SigningPublicKeyString(s.signingKey)
+	header := http.Header{}
+	if err := s.signNotModified(header, "peer-a", `"etag"`, 7, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("signNotModified: %v", err)
+	}
+	signedAt, err := strconv.ParseInt(header.Get(protocol.PeerListSignedAtHeader), 10, 64)
+	if err != nil || signedAt != 1700000000 {
+		t.Fatalf("signed-at header %q: %v", header.Get(protocol.PeerListSignedAtHeader), err)
+	}
+	signature := header.Get(protocol.PeerListSignatureHeader)
+
+	tests := []struct {
+		name     string
+		peerID   string
+		etag     string
+		version  uint64
+		signedAt int64
+		valid    bool
+	}{
+		{"as signed", "peer-a", `"etag"`, 7, 1700000000, true},
+		{"other peer", "peer-b", `"etag"`, 7, 1700000000, false},
+		{"other list", "peer-a", `"other"`, 7, 1700000000, false},
+		{"other version", "peer-a", `"etag"`, 8, 1700000000, false},
+		{"later time", "peer-a", `"etag"`, 7, 1700000600, false},
+	}
+	for _, tt := range tests {
+		payload, err := protocol.NotModifiedSigningPayload(tt.peerID, tt.etag, tt.version, tt.signedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crypto.Verify(publicKey, payload, signature); (err == nil) != tt.valid {
+			t.Errorf("%s: verify = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestRotateKeyEndorsement(t *testing.T) {
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultServerConfig()
+	cfg.PrivateKey = keyPair.PrivateKeyToString()
+	cfg.PublicKey = keyPair.PublicKeyToString()
+	oldSigningKey, err := loadSigningKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RotateKey(cfg, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("RotateKey: %v", err)
+	}
+	newSigningKey, err := loadSigningKey(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endorsement := cfg.KeyEndorsement
+	if endorsement == nil || endorsement.OldPublicKey != keyPair.PublicKeyToString() || endorsement.NewPublicKey != cfg.PublicKey ||
+		endorsement.NewSigningKey != crypto.SigningPublicKeyString(newSigningKey) {
+		t.Fatalf("endorsement %+v does not name the old and new keys", endorsement)
+	}
+
+	// Clients that pinned the old signing key check the endorsement with it
+	payload, err := endorsement.SigningPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crypto.Verify(crypto.SigningPublicKeyString(oldSigningKey), payload, endorsement.Signature); err != nil {
+		t.Errorf("endorsement with the old key: %v", err)
+	}
+	if crypto.Verify(endorsement.NewSigningKey, payload, endorsement.Signature) == nil {
+		t.Error("endorsement verifies with the new key")
+	}
+	forged := *endorsement
+	forged.NewSigningKey = crypto.SigningPublicKeyString(oldSigningKey)
+	if forged, _ := forged.SigningPayload(); crypto.Verify(crypto.SigningPublicKeyString(oldSigningKey), forged, endorsement.Signature) == nil {
+		t.Error("endorsement verifies for another signing key")
+	}
+
+	if currentEndorsement(cfg, cfg.PublicKey, newSigningKey) != endorsement {
+		t.Error("endorsement of the current keys ignored")
+	}
+	if currentEndorsement(cfg, keyPair.PublicKeyToString(), oldSigningKey) != nil {
+		t.Error("endorsement used for other keys")
+	}
+}
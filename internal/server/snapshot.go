@@ -0,0 +1,233 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultSnapshotRetention is how many snapshots are kept when the
+	// configuration does not say
+	DefaultSnapshotRetention = 24
+
+	// snapshotTimeFormat names snapshots so that they sort chronologically
+	snapshotTimeFormat = "20060102T150405Z"
+
+	snapshotPrefix = "peers-"
+	snapshotSuffix = ".json"
+)
+
+// snapshotDir returns the configured snapshot directory or the default next
+// to the peer store
+func (s *Server) snapshotDir() string {
+	if s.config.SnapshotDir != "" {
+		return s.config.SnapshotDir
+	}
+	return filepath.Join(filepath.Dir(s.config.DBPath), "snapshots")
+}
+
+// snapshotRoutine periodically snapshots the peer store
+func (s *Server) snapshotRoutine() {
+	defer s.routines.Done()
+
+	ticker := time.NewTicker(time.Duration(s.config.SnapshotInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+
+		if _, err := s.Snapshot(); err != nil {
+			log.Printf("Snapshot failed: %v", err)
+		}
+	}
+}
+
+// Snapshot writes a timestamped copy of the peers to the snapshot directory
+// and prunes old snapshots. The server mutex is only held while the peers
+// are copied, not while the file is written. It returns the snapshot's
+// timestamp.
+func (s *Server) Snapshot() (string, error) {
+	s.mu.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, copyPeer(peer))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+
+	data, err := json.MarshalIndent(newPeerFile(peers), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal peers: %w", err)
+	}
+
+	dir := s.snapshotDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	timestamp := time.Now().UTC().Format(snapshotTimeFormat)
+	if err := writeFileAtomic(filepath.Join(dir, snapshotPrefix+timestamp+snapshotSuffix), data, 0600); err != nil {
+		return "", err
+	}
+
+	retention := s.config.SnapshotRetention
+	if retention <= 0 {
+		retention = DefaultSnapshotRetention
+	}
+	if err := pruneSnapshots(dir, retention); err != nil {
+		log.Printf("Warning: failed to prune snapshots: %v", err)
+	}
+
+	return timestamp, nil
+}
+
+// ListSnapshots returns the timestamps of the snapshots in dir, oldest first
+func ListSnapshots(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"+snapshotSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps := make([]string, 0, len(matches))
+	for _, match := range matches {
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), snapshotPrefix), snapshotSuffix)
+		if _, err := time.Parse(snapshotTimeFormat, timestamp); err == nil {
+			timestamps = append(timestamps, timestamp)
+		}
+	}
+	sort.Strings(timestamps)
+	return timestamps, nil
+}
+
+// RestoreSnapshot replaces the peer table with a snapshot. Writes are blocked
+// while the snapshot is loaded, persisted and swapped in along with a
+// rebuilt IP allocator; on any error the current state is left untouched.
+func (s *Server) RestoreSnapshot(timestamp string) error {
+	if _, err := time.Parse(snapshotTimeFormat, timestamp); err != nil {
+		return fmt.Errorf("invalid snapshot %q", timestamp)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.snapshotDir(), snapshotPrefix+timestamp+snapshotSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	peers, _, err := decodePeers(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+
+	if err := validateAllocations(s.config.NetworkCIDR, peers); err != nil {
+		return err
+	}
+	byID := make(map[string]*Peer, len(peers))
+	byKey := make(map[string]string, len(peers))
+	for _, peer := range peers {
+		byID[peer.ID] = peer
+		byKey[peer.PublicKey] = peer.ID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reservations outlive the restore; peer allocations follow the snapshot
+	var reserved []Allocation
+	for _, allocation := range s.allocations.List() {
+		if allocation.Owner == OwnerReserved {
+			reserved = append(reserved, allocation)
+		}
+	}
+	ipAllocator, allocations, err := buildAllocations(s.config.NetworkCIDR, s.config.Groups, reserved, s.config.ReservedIPs, byID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.ReplacePeers(peers); err != nil {
+		return fmt.Errorf("failed to write peer store: %w", err)
+	}
+	if err := s.allocations.Replace(allocations); err != nil {
+		return fmt.Errorf("failed to write allocation table: %w", err)
+	}
+
+	s.peers = byID
+	s.peersByKey = byKey
+	ipAllocator.SetScanObserver(s.metrics.observeScan)
+	s.ipAllocator = ipAllocator
+	s.conflicts = findConflicts(s.peers)
+	s.addressConflicts = make(map[string]AddressConflict)
+	s.version++
+
+	log.Printf("Restored %d peers from snapshot %s", len(peers), timestamp)
+	return nil
+}
+
+// handleAdminSnapshots lists snapshots (GET) or takes one now (POST)
+func (s *Server) handleAdminSnapshots(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		timestamps, err := ListSnapshots(s.snapshotDir())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"snapshots": timestamps,
+		})
+	case http.MethodPost:
+		timestamp, err := s.Snapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"snapshot": timestamp,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminRestore restores the snapshot named by the snapshot parameter
+func (s *Server) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.RestoreSnapshot(r.URL.Query().Get("snapshot")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+	})
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir
+func pruneSnapshots(dir string, keep int) error {
+	timestamps, err := ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+
+	for len(timestamps) > keep {
+		if err := os.Remove(filepath.Join(dir, snapshotPrefix+timestamps[0]+snapshotSuffix)); err != nil {
+			return err
+		}
+		timestamps = timestamps[1:]
+	}
+	return nil
+}
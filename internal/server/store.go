@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// PeerStore handles persistent storage of peer information. Writes update an
+// in-memory copy and are flushed to disk by a background writer, so a burst
+// of changes costs one file write rather than one per peer and callers never
+// wait on the disk.
+type PeerStore struct {
+	mu     sync.Mutex
+	peers  map[string]*Peer
+	dirty  bool
+	writer *backgroundWriter
+}
+
+// NewPeerStore opens the store at path, loading any existing peers, and
+// starts its background writer. Call Close to flush and stop it.
+func NewPeerStore(path string) (*PeerStore, error) {
+	// Ensure directory exists
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to load peer store: %w", err)
+	}
+
+	var peers []*Peer
+	version := PeerStoreVersion
+	if err == nil {
+		if peers, version, err = decodePeers(data); err != nil {
+			return nil, fmt.Errorf("failed to load peer store %s: %w", path, err)
+		}
+	}
+
+	s := &PeerStore{
+		peers: make(map[string]*Peer, len(peers)),
+	}
+	for _, peer := range peers {
+		s.peers[peer.ID] = peer
+	}
+	s.writer = newBackgroundWriter(path, s.collect)
+
+	// Rewrite a migrated store in the current format, keeping the original
+	// next to it in case the server has to be downgraded
+	if version < PeerStoreVersion {
+		backup := fmt.Sprintf("%s.v%d", path, version)
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			s.writer.close()
+			return nil, fmt.Errorf("failed to back up peer store before migrating: %w", err)
+		}
+		log.Printf("Migrated peer store from version %d to %d; the original is kept in %s", version, PeerStoreVersion, backup)
+		s.dirty = true
+		s.writer.schedule()
+	}
+
+	return s, nil
+}
+
+// SavePeer records a copy of the peer and schedules a write. The copy is
+// taken immediately, so the caller may keep modifying the peer.
+func (s *PeerStore) SavePeer(peer *Peer) error {
+	saved := copyPeer(peer)
+
+	s.mu.Lock()
+	s.peers[peer.ID] = saved
+	s.dirty = true
+	s.mu.Unlock()
+
+	s.writer.schedule()
+	return nil
+}
+
+// LoadPeers returns copies of all stored peers
+func (s *PeerStore) LoadPeers() ([]*Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedPeersLocked(), nil
+}
+
+// DeletePeer deletes a peer from the store
+func (s *PeerStore) DeletePeer(peerID string) error {
+	s.mu.Lock()
+	delete(s.peers, peerID)
+	s.dirty = true
+	s.mu.Unlock()
+
+	s.writer.schedule()
+	return nil
+}
+
+// ReplacePeers replaces the entire contents of the store and writes it out
+// before returning
+func (s *PeerStore) ReplacePeers(peers []*Peer) error {
+	s.mu.Lock()
+	s.peers = make(map[string]*Peer, len(peers))
+	for _, peer := range peers {
+		s.peers[peer.ID] = copyPeer(peer)
+	}
+	s.dirty = true
+	s.mu.Unlock()
+
+	return s.Flush()
+}
+
+// Flush writes pending changes to disk
+func (s *PeerStore) Flush() error {
+	return s.writer.flush()
+}
+
+// ObserveWrites registers a function told the size of each file the store
+// writes and how long writing it took
+func (s *PeerStore) ObserveWrites(observe func(bytes int, took time.Duration)) {
+	s.writer.setObserver(observe)
+}
+
+// Close stops the background writer and flushes pending changes
+func (s *PeerStore) Close() error {
+	return s.writer.close()
+}
+
+// collect hands pending changes to the background writer
+func (s *PeerStore) collect() (interface{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil, nil
+	}
+	s.dirty = false
+
+	return newPeerFile(s.sortedPeersLocked()), func() {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+// sortedPeersLocked returns copies of the stored peers ordered by ID.
+// Callers must hold s.mu.
+func (s *PeerStore) sortedPeersLocked() []*Peer {
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		peers = append(peers, copyPeer(peer))
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	return peers
+}
+
+// copyPeer returns a copy of the peer that shares no mutable state with it
+func copyPeer(peer *Peer) *Peer {
+	copied := *peer
+	copied.AllowedIPs = append([]string(nil), peer.AllowedIPs...)
+	copied.Endpoints = append([]string(nil), peer.Endpoints...)
+	copied.PendingEndpoints = append([]string(nil), peer.PendingEndpoints...)
+	copied.RejectedEndpoints = append([]RejectedEndpoint(nil), peer.RejectedEndpoints...)
+	copied.Tags = append([]string(nil), peer.Tags...)
+	copied.Destinations = append([]string(nil), peer.Destinations...)
+	copied.History = append([]PeerTransition(nil), peer.History...)
+	return &copied
+}
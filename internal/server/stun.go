@@ -0,0 +1,76 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// startSTUN answers STUN Binding requests on each of the stun_ports, on
+// every address of the host, so that clients can tell which UDP ports the
+// network they are on lets through
+func (s *Server) startSTUN() error {
+	var conns []net.PacketConn
+	for _, port := range s.config.STUNPorts {
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			for _, open := range conns {
+				open.Close()
+			}
+			return fmt.Errorf("failed to listen for STUN on UDP port %d: %w", port, err)
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.stunConns = conns
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		log.Printf("Answering STUN on %s", conn.LocalAddr())
+		go serveSTUN(conn)
+	}
+	return nil
+}
+
+// serveSTUN answers Binding requests on conn until it is closed. Anything
+// else is ignored.
+func serveSTUN(conn net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		id, err := network.ParseSTUNRequest(buf[:n])
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(network.STUNResponse(id, addr), addr)
+	}
+}
+
+// stopSTUN closes the STUN listeners, if there are any
+func (s *Server) stopSTUN() {
+	s.mu.Lock()
+	conns := s.stunConns
+	s.stunConns = nil
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
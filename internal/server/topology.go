@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// Topologies
+const (
+	TopologyMesh   = "mesh"   // Every peer gets every other peer
+	TopologyHub    = "hub"    // Ordinary peers get only the hubs
+	TopologyCustom = "custom" // Peers get those topology_rules connect them to
+)
+
+// topology returns the configured topology
+func (s *Server) topology() string {
+	if s.config.Topology == "" {
+		return TopologyMesh
+	}
+	return s.config.Topology
+}
+
+// matchesSelector reports whether a selector names peer: "*" names every
+// peer, "tag:<name>" the peers with that tag, anything else a hostname
+func matchesSelector(selector string, peer *Peer) bool {
+	if selector == "*" {
+		return true
+	}
+	if tag, ok := strings.CutPrefix(selector, "tag:"); ok {
+		for _, peerTag := range peer.Tags {
+			if peerTag == tag {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.EqualFold(selector, peer.Hostname)
+}
+
+// matchesAnySelector reports whether any of selectors names peer
+func matchesAnySelector(selectors []string, peer *Peer) bool {
+	for _, selector := range selectors {
+		if matchesSelector(selector, peer) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHub reports whether a peer is a hub of the hub topology
+func (s *Server) isHub(peer *Peer) bool {
+	if len(s.config.HubPeers) == 0 {
+		return peer.ExitNode
+	}
+	return matchesAnySelector(s.config.HubPeers, peer)
+}
+
+// primaryHub returns the hub that the rest of the network is routed
+// through: the online hub with the lowest ID, or the lowest offline one.
+// WireGuard gives a prefix to a single peer, so only one hub can carry it.
+// Callers must hold s.mu.
+func (s *Server) primaryHub() *Peer {
+	var primary *Peer
+	for _, peer := range s.peers {
+		if peer.Status != PeerStatusActive || !s.isHub(peer) {
+			continue
+		}
+		if primary == nil || (peer.Online && !primary.Online) ||
+			(peer.Online == primary.Online && peer.ID < primary.ID) {
+			primary = peer
+		}
+	}
+	return primary
+}
+
+// connected reports whether the custom topology connects two peers
+func (s *Server) connected(a, b *Peer) bool {
+	for _, rule := range s.config.TopologyRules {
+		if matchesAnySelector(rule.Peers, a) && matchesAnySelector(rule.Connect, b) ||
+			matchesAnySelector(rule.Peers, b) && matchesAnySelector(rule.Connect, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// topologyPeers computes requester's personalized peer list from the peers
+// it may see, with the AllowedIPs each is given under the topology. Callers
+// must hold s.mu.
+func (s *Server) topologyPeers(requester *Peer, candidates []*Peer) []protocol.PeerInfo {
+	peers := make([]protocol.PeerInfo, 0, len(candidates))
+
+	switch s.topology() {
+	case TopologyHub:
+		// Hubs route for everyone, so they need the full mesh
+		if s.isHub(requester) {
+			for _, peer := range candidates {
+				peers = append(peers, peer.Info())
+			}
+			return peers
+		}
+
+		primary := s.primaryHub()
+		for _, peer := range candidates {
+			if !s.isHub(peer) {
+				continue
+			}
+			info := peer.Info()
+			if peer == primary {
+				info.AllowedIPs = append(info.AllowedIPs, s.ipAllocator.GetNetworkCIDR())
+			}
+			peers = append(peers, info)
+		}
+
+	case TopologyCustom:
+		for _, peer := range candidates {
+			if s.connected(requester, peer) {
+				peers = append(peers, peer.Info())
+			}
+		}
+
+	default:
+		for _, peer := range candidates {
+			peers = append(peers, peer.Info())
+		}
+	}
+
+	return peers
+}
+
+// allowedSources returns the source prefixes a peer may accept traffic from:
+// everything the peers in its list route through the mesh. Default routes
+// are left out, since an exit node forwards replies rather than originating
+// traffic from the whole internet.
+func allowedSources(peers []protocol.PeerInfo) []string {
+	seen := make(map[string]bool)
+	var sources []string
+	for _, peer := range peers {
+		for _, entry := range peer.AllowedIPs {
+			_, prefix, err := net.ParseCIDR(entry)
+			if err != nil {
+				continue
+			}
+			if ones, _ := prefix.Mask.Size(); ones == 0 {
+				continue
+			}
+			if source := prefix.String(); !seen[source] {
+				seen[source] = true
+				sources = append(sources, source)
+			}
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+func TestTopologyAllowedIPs(t *testing.T) {
+	newPeers := func() map[string]*Peer {
+		return map[string]*Peer{
+			"hub":     {ID: "hub", Hostname: "hub", VirtualIP: "10.100.0.1", AllowedIPs: []string{"10.100.0.1/32", "0.0.0.0/0"}, ExitNode: true, Online: true},
+			"spoke-a": {ID: "spoke-a", Hostname: "spoke-a", VirtualIP: "10.100.0.2", AllowedIPs: []string{"10.100.0.2/32", "192.168.5.0/24"}, Online: true},
+			"spoke-b": {ID: "spoke-b", Hostname: "spoke-b", VirtualIP: "10.100.0.3", AllowedIPs: []string{"10.100.0.3/32"}, Online: true},
+		}
+	}
+
+	// What each requester gets under each topology, by peer ID
+	full := map[string][]string{
+		"hub":     {"10.100.0.1/32", "0.0.0.0/0"},
+		"spoke-a": {"10.100.0.2/32", "192.168.5.0/24"},
+		"spoke-b": {"10.100.0.3/32"},
+	}
+	except := func(id string) map[string][]string {
+		out := make(map[string][]string)
+		for peer, allowedIPs := range full {
+			if peer != id {
+				out[peer] = allowedIPs
+			}
+		}
+		return out
+	}
+	// The hub carries the rest of the network for the spokes
+	viaHub := map[string][]string{"hub": {"10.100.0.1/32", "0.0.0.0/0", "10.100.0.0/16"}}
+
+	tests := []struct {
+		topology string
+		want     map[string]map[string][]string // requester -> peer -> AllowedIPs
+	}{
+		{TopologyMesh, map[string]map[string][]string{
+			"hub":     except("hub"),
+			"spoke-a": except("spoke-a"),
+			"spoke-b": except("spoke-b"),
+		}},
+		{TopologyHub, map[string]map[string][]string{
+			"hub":     except("hub"),
+			"spoke-a": viaHub,
+			"spoke-b": viaHub,
+		}},
+		{TopologyCustom, map[string]map[string][]string{
+			"hub":     {},
+			"spoke-a": {"spoke-b": full["spoke-b"]},
+			"spoke-b": {"spoke-a": full["spoke-a"]},
+		}},
+	}
+
+	for _, tt := range tests {
+		for requester, want := range tt.want {
+			t.Run(tt.topology+"/"+requester, func(t *testing.T) {
+				cfg := config.DefaultServerConfig()
+				cfg.Topology = tt.topology
+				cfg.TopologyRules = []config.TopologyRule{{Peers: []string{"spoke-a"}, Connect: []string{"spoke-b"}}}
+				ipAllocator, err := network.NewIPAllocator(cfg.NetworkCIDR)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s := &Server{config: cfg, peers: newPeers(), ipAllocator: ipAllocator}
+
+				var candidates []*Peer
+				for id, peer := range s.peers {
+					if id != requester {
+						candidates = append(candidates, peer)
+					}
+				}
+				sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+
+				got := make(map[string][]string)
+				for _, info := range s.topologyPeers(s.peers[requester], candidates) {
+					got[info.ID] = info.AllowedIPs
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+
+				// The records themselves are never changed
+				if !reflect.DeepEqual(s.peers["hub"].AllowedIPs, full["hub"]) {
+					t.Errorf("hub's record changed to %v", s.peers["hub"].AllowedIPs)
+				}
+			})
+		}
+	}
+}
+
+func TestPrimaryHubPrefersOnline(t *testing.T) {
+	s := &Server{config: config.DefaultServerConfig(), peers: map[string]*Peer{
+		"hub-a": {ID: "hub-a", ExitNode: true},
+		"hub-b": {ID: "hub-b", ExitNode: true, Online: true},
+		"hub-c": {ID: "hub-c", ExitNode: true, Online: true},
+		"spoke": {ID: "spoke", Hostname: "spoke", Online: true},
+	}}
+	if primary := s.primaryHub(); primary == nil || primary.ID != "hub-b" {
+		t.Errorf("primary hub %v, want hub-b", primary)
+	}
+
+	s.peers["hub-b"].Online = false
+	s.peers["hub-c"].Online = false
+	if primary := s.primaryHub(); primary == nil || primary.ID != "hub-a" {
+		t.Errorf("primary hub with every hub offline %v, want hub-a", primary)
+	}
+
+	// Hub selectors override the exit nodes
+	s.config.HubPeers = []string{"spoke"}
+	if primary := s.primaryHub(); primary == nil || primary.ID != "spoke" {
+		t.Errorf("primary hub with hub_peers %v, want spoke", primary)
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+const (
+	// usageRetention is how many daily rollups are kept per peer
+	usageRetention = 400
+
+	// usageDateFormat names the UTC day of a rollup
+	usageDateFormat = "2006-01-02"
+)
+
+// Actions taken when a peer exceeds its monthly quota
+const (
+	QuotaActionSuspend    = "suspend"     // Suspend the peer until the next month
+	QuotaActionRevokeExit = "revoke_exit" // Stop offering it exit node routes
+)
+
+// DailyUsage is a peer's traffic on one UTC day
+type DailyUsage struct {
+	Date          string `json:"date"`
+	ReceiveBytes  uint64 `json:"receive_bytes"`
+	TransmitBytes uint64 `json:"transmit_bytes"`
+}
+
+// PeerUsage is the traffic accounting of one peer
+type PeerUsage struct {
+	PeerID string `json:"peer_id"`
+
+	// Session and the counters are the last totals the client reported.
+	// Clients report totals since they started, so a new session starts
+	// counting from zero again.
+	Session         string `json:"session,omitempty"`
+	ReceiveCounter  uint64 `json:"receive_counter"`
+	TransmitCounter uint64 `json:"transmit_counter"`
+
+	Days []DailyUsage `json:"days"` // Oldest first
+}
+
+// UsageStore accumulates the traffic reported in heartbeats into daily
+// rollups, persisted alongside the peer store
+type UsageStore struct {
+	mu     sync.Mutex
+	peers  map[string]*PeerUsage
+	dirty  bool
+	writer *backgroundWriter
+}
+
+// NewUsageStore opens the usage table at path and starts its background
+// writer. Call Close to flush and stop it.
+func NewUsageStore(path string) (*UsageStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	var usages []*PeerUsage
+	if err := readJSONFile(path, &usages); err != nil {
+		return nil, fmt.Errorf("failed to load usage table: %w", err)
+	}
+
+	s := &UsageStore{
+		peers: make(map[string]*PeerUsage, len(usages)),
+	}
+	for _, usage := range usages {
+		s.peers[usage.PeerID] = usage
+	}
+	s.writer = newBackgroundWriter(path, s.collect)
+
+	return s, nil
+}
+
+// Record adds the traffic behind a peer's reported totals to today's
+// rollup. Only the growth since the previous report is counted. Totals from
+// a new session, or totals that went backwards, are counted in full, as
+// they started from zero.
+func (s *UsageStore) Record(peerID, session string, receive, transmit uint64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, ok := s.peers[peerID]
+	if !ok {
+		usage = &PeerUsage{PeerID: peerID}
+		s.peers[peerID] = usage
+	}
+
+	deltaReceive, deltaTransmit := receive, transmit
+	if usage.Session == session {
+		if receive >= usage.ReceiveCounter {
+			deltaReceive = receive - usage.ReceiveCounter
+		}
+		if transmit >= usage.TransmitCounter {
+			deltaTransmit = transmit - usage.TransmitCounter
+		}
+	}
+	usage.Session = session
+	usage.ReceiveCounter = receive
+	usage.TransmitCounter = transmit
+
+	date := now.UTC().Format(usageDateFormat)
+	if n := len(usage.Days); n == 0 || usage.Days[n-1].Date != date {
+		usage.Days = append(usage.Days, DailyUsage{Date: date})
+		if len(usage.Days) > usageRetention {
+			usage.Days = append([]DailyUsage(nil), usage.Days[len(usage.Days)-usageRetention:]...)
+		}
+	}
+	day := &usage.Days[len(usage.Days)-1]
+	day.ReceiveBytes += deltaReceive
+	day.TransmitBytes += deltaTransmit
+
+	s.dirty = true
+	s.writer.schedule()
+}
+
+// Days returns a peer's daily rollups, oldest first
+func (s *UsageStore) Days(peerID string) []DailyUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, ok := s.peers[peerID]
+	if !ok {
+		return []DailyUsage{}
+	}
+	return append([]DailyUsage{}, usage.Days...)
+}
+
+// MonthTotal returns a peer's traffic, both directions, in the UTC month of
+// now
+func (s *UsageStore) MonthTotal(peerID string, now time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, ok := s.peers[peerID]
+	if !ok {
+		return 0
+	}
+
+	month := now.UTC().Format("2006-01")
+	var total uint64
+	for i := len(usage.Days) - 1; i >= 0 && usage.Days[i].Date[:7] == month; i-- {
+		total += usage.Days[i].ReceiveBytes + usage.Days[i].TransmitBytes
+	}
+	return total
+}
+
+// List returns the accounting of all peers ordered by peer ID
+func (s *UsageStore) List() []PeerUsage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedLocked()
+}
+
+// Close stops the background writer and flushes pending changes
+func (s *UsageStore) Close() error {
+	return s.writer.close()
+}
+
+// collect hands pending changes to the background writer
+func (s *UsageStore) collect() (interface{}, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil, nil
+	}
+	s.dirty = false
+
+	return s.sortedLocked(), func() {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+}
+
+// sortedLocked returns copies of the usages ordered by peer ID. Callers must
+// hold s.mu.
+func (s *UsageStore) sortedLocked() []PeerUsage {
+	usages := make([]PeerUsage, 0, len(s.peers))
+	for _, usage := range s.peers {
+		copied := *usage
+		copied.Days = append([]DailyUsage{}, usage.Days...)
+		usages = append(usages, copied)
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].PeerID < usages[j].PeerID })
+	return usages
+}
+
+// UsagePath returns the configured usage table path or the default next to
+// the peer store
+func UsagePath(cfg *config.ServerConfig) string {
+	if cfg.UsagePath != "" {
+		return cfg.UsagePath
+	}
+	return filepath.Join(filepath.Dir(cfg.DBPath), "usage.json")
+}
+
+// peerQuota returns the monthly quota of a peer in bytes, zero meaning
+// unlimited. A peer's own quota overrides the configured one; a negative
+// one exempts it.
+func (s *Server) peerQuota(peer *Peer) uint64 {
+	quota := s.config.MonthlyQuota
+	if peer.MonthlyQuota != 0 {
+		quota = peer.MonthlyQuota
+	}
+	if quota < 0 {
+		return 0
+	}
+	return uint64(quota)
+}
+
+// quotaAction returns what happens to peers over their quota
+func (s *Server) quotaAction() string {
+	if s.config.QuotaAction == "" {
+		return QuotaActionSuspend
+	}
+	return s.config.QuotaAction
+}
+
+// enforceQuota compares a peer's traffic this month with its quota and
+// applies or lifts the quota action when that changes. It reports whether
+// the peer changed. Callers must hold s.mu and save the peer.
+func (s *Server) enforceQuota(peer *Peer, now time.Time) bool {
+	quota := s.peerQuota(peer)
+	exceeded := quota > 0 && s.usage.MonthTotal(peer.ID, now) >= quota
+	if exceeded == peer.QuotaExceeded {
+		return false
+	}
+	peer.QuotaExceeded = exceeded
+
+	eventType := EventPeerUpdated
+	if s.quotaAction() == QuotaActionSuspend {
+		switch {
+		case exceeded && peer.Status == PeerStatusActive:
+			peer.Status = PeerStatusSuspended
+			setOffline(peer, OfflineSuspended, now)
+			eventType = EventPeerSuspended
+		case !exceeded && peer.Status == PeerStatusSuspended:
+			peer.Status = PeerStatusActive
+			eventType = EventPeerApproved
+		}
+		s.conflicts = findConflicts(s.peers)
+	}
+
+	if exceeded {
+		log.Printf("Peer %s (%s) exceeded its monthly quota of %d bytes: %s", peer.ID, peer.Hostname, quota, s.quotaAction())
+	} else {
+		log.Printf("Peer %s (%s) is within its monthly quota again", peer.ID, peer.Hostname)
+	}
+
+	// Other peers drop or restore a suspended peer, and a peer losing exit
+	// routes learns of it, on their next sync
+	s.version++
+	s.publishPeer(eventType, peer)
+	return true
+}
+
+// recheckQuotas lifts the quota action from peers whose month has rolled
+// over. Suspended peers send no heartbeats to trigger it. Callers must hold
+// s.mu.
+func (s *Server) recheckQuotas(now time.Time) {
+	for _, peer := range s.peers {
+		if peer.QuotaExceeded && s.enforceQuota(peer, now) {
+			s.store.SavePeer(peer)
+		}
+	}
+}
+
+// withoutExitRoutes stops a peer being offered as an exit node
+func withoutExitRoutes(info *protocol.PeerInfo) {
+	info.ExitNode = false
+	allowedIPs := info.AllowedIPs[:0]
+	for _, prefix := range info.AllowedIPs {
+		if prefix != "0.0.0.0/0" && prefix != "::/0" {
+			allowedIPs = append(allowedIPs, prefix)
+		}
+	}
+	info.AllowedIPs = allowedIPs
+}
+
+// handleAdminPeerUsage serves a peer's traffic accounting, as JSON or, with
+// ?format=csv, as CSV
+func (s *Server) handleAdminPeerUsage(w http.ResponseWriter, r *http.Request, peerID string) {
+	now := time.Now()
+	s.mu.RLock()
+	peer, exists := s.peers[peerID]
+	var quota uint64
+	var exceeded bool
+	if exists {
+		quota = s.peerQuota(peer)
+		exceeded = peer.QuotaExceeded
+	}
+	s.mu.RUnlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("peer %s not found", peerID), http.StatusNotFound)
+		return
+	}
+
+	days := s.usage.Days(peerID)
+	if r.URL.Query().Get("format") == "csv" {
+		writeUsageCSV(w, []PeerUsage{{PeerID: peerID, Days: days}}, fmt.Sprintf("usage-%s.csv", peerID))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"peer_id":        peerID,
+		"monthly_quota":  quota,
+		"month_total":    s.usage.MonthTotal(peerID, now),
+		"quota_exceeded": exceeded,
+		"days":           days,
+	})
+}
+
+// handleAdminUsage exports the traffic accounting of all peers, as JSON or,
+// with ?format=csv, as CSV
+func (s *Server) handleAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	usages := s.usage.List()
+	if r.URL.Query().Get("format") == "csv" {
+		writeUsageCSV(w, usages, "usage.csv")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"usage": usages,
+	})
+}
+
+// setPeerQuota sets a peer's own monthly quota and applies it at once. Zero
+// falls back to the configured quota and a negative quota exempts the peer.
+func (s *Server) setPeerQuota(peerID string, quota int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, exists := s.peers[peerID]
+	if !exists {
+		return fmt.Errorf("peer %s not found", peerID)
+	}
+
+	peer.MonthlyQuota = quota
+	s.enforceQuota(peer, time.Now())
+	s.store.SavePeer(peer)
+	return nil
+}
+
+// writeUsageCSV writes daily rollups as CSV, one row per peer and day
+func writeUsageCSV(w http.ResponseWriter, usages []PeerUsage, filename string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"peer_id", "date", "receive_bytes", "transmit_bytes"})
+	for _, usage := range usages {
+		for _, day := range usage.Days {
+			cw.Write([]string{
+				usage.PeerID,
+				day.Date,
+				strconv.FormatUint(day.ReceiveBytes, 10),
+				strconv.FormatUint(day.TransmitBytes, 10),
+			})
+		}
+	}
+	cw.Flush()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// writeInvalidParameter refuses a request whose parameter failed validation
+func writeInvalidParameter(w http.ResponseWriter, param string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(protocol.ErrorResponse{
+		Success:   false,
+		Error:     fmt.Sprintf("invalid %s: %v", param, err),
+		Code:      protocol.ErrorCodeInvalidParameter,
+		Parameter: param,
+	})
+}
+
+// maxEndpoints bounds the endpoints one peer may advertise
+const maxEndpoints = 4
+
+// canonicalEndpoints validates the endpoints a peer advertised and returns
+// them in canonical form, without duplicates. The primary endpoint is
+// always the first of the list, which older clients that send only an
+// endpoint get as a list of one.
+func canonicalEndpoints(endpoint string, endpoints []string) (string, []string, error) {
+	if len(endpoints) > maxEndpoints {
+		return "", nil, fmt.Errorf("at most %d endpoints may be advertised", maxEndpoints)
+	}
+
+	var result []string
+	seen := make(map[string]bool, len(endpoints)+1)
+	for _, entry := range append([]string{endpoint}, endpoints...) {
+		if entry == "" {
+			continue
+		}
+		canonical, err := protocol.CanonicalEndpoint(entry)
+		if err != nil {
+			return "", nil, err
+		}
+		if !seen[canonical] {
+			seen[canonical] = true
+			result = append(result, canonical)
+		}
+	}
+	if len(result) == 0 {
+		return "", nil, nil
+	}
+	return result[0], result, nil
+}
+
+// sameEndpoints reports whether two canonical endpoint lists are equal
+func sameEndpoints(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Limits on the fields of a registration
+const (
+	maxRegisterBodyBytes = 64 << 10
+	maxLabelLength       = 64 // OS, client version, backend and idempotency key
+	maxAdvertisedRoutes  = 64
+)
+
+// validateRegistration checks every field of a registration before any of
+// it is stored, since whatever a peer registers is shipped to the rest of
+// the mesh. Endpoints are canonicalized in place. It returns the name of
+// the parameter that failed, for the response.
+func (s *Server) validateRegistration(req *protocol.RegisterRequest) (string, error) {
+	if _, err := crypto.ParsePublicKey(req.PublicKey); err != nil {
+		return "public_key", err
+	}
+	// A client that cannot tell its hostname registers without one
+	if req.Hostname != "" {
+		if err := protocol.ValidateName(req.Hostname); err != nil {
+			return "hostname", err
+		}
+	}
+	labels := []struct {
+		param, value string
+	}{
+		{"os", req.OS},
+		{"client_version", req.ClientVersion},
+		{"backend", req.Backend},
+		{"idempotency_key", req.IdempotencyKey},
+	}
+	for _, label := range labels {
+		if err := validateLabel(label.value); err != nil {
+			return label.param, err
+		}
+	}
+
+	endpoint, endpoints, err := canonicalEndpoints(req.Endpoint, req.Endpoints)
+	if err != nil {
+		return "endpoint", err
+	}
+	req.Endpoint, req.Endpoints = endpoint, endpoints
+
+	if err := s.validateRoutes(req.AllowedIPs); err != nil {
+		return "allowed_ips", err
+	}
+	return "", nil
+}
+
+// validateLabel checks a free-form label such as the OS a peer reports: it
+// may be empty, but is bounded and holds only printable characters, so it
+// shows safely in logs, the admin UI and other peers' lists
+func validateLabel(value string) error {
+	if len(value) > maxLabelLength {
+		return fmt.Errorf("is longer than %d characters", maxLabelLength)
+	}
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("is not valid UTF-8")
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("contains unprintable character %q", r)
+		}
+	}
+	return nil
+}
+
+// validateRoutes checks the routes a peer advertises. They must be CIDRs
+// naming their network address, may not be default routes, which come only
+// from exit nodes, and may not reach into the mesh network, where they
+// would capture other peers' addresses.
+func (s *Server) validateRoutes(routes []string) error {
+	if len(routes) > maxAdvertisedRoutes {
+		return fmt.Errorf("at most %d routes may be advertised", maxAdvertisedRoutes)
+	}
+	_, mesh, err := net.ParseCIDR(s.config.NetworkCIDR)
+	if err != nil {
+		return err
+	}
+	for _, entry := range routes {
+		ip, prefix, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("%q is not a CIDR", entry)
+		}
+		if !ip.Equal(prefix.IP) {
+			return fmt.Errorf("%q has host bits set; use %s", entry, prefix)
+		}
+		if ones, _ := prefix.Mask.Size(); ones == 0 {
+			return fmt.Errorf("default routes may only be advertised with exit_node")
+		}
+		if ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+			return fmt.Errorf("%q is not a routable network", entry)
+		}
+		if prefix.Contains(mesh.IP) || mesh.Contains(prefix.IP) {
+			return fmt.Errorf("%q overlaps the mesh network %s", entry, mesh)
+		}
+	}
+	return nil
+}
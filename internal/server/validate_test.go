@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// storedRequest rebuilds the registration a stored peer stands for, so that
+// it can be checked with validateRegistration
+func storedRequest(peer *Peer) *protocol.RegisterRequest {
+	routes := slices.DeleteFunc(slices.Clone(peer.AllowedIPs), func(route string) bool {
+		return route == peer.VirtualIP+"/32" || peer.ExitNode && route == "0.0.0.0/0"
+	})
+	return &protocol.RegisterRequest{
+		PublicKey:     peer.PublicKey,
+		Hostname:      peer.Hostname,
+		OS:            peer.OS,
+		ClientVersion: peer.ClientVersion,
+		Backend:       peer.Backend,
+		Endpoint:      peer.Endpoint,
+		Endpoints:     slices.Clone(peer.Endpoints),
+		AllowedIPs:    routes,
+	}
+}
+
+func FuzzRegister(f *testing.F) {
+	// A fixed key, so that seeds reach past the key check
+	const key = `"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="`
+	f.Add(`{"public_key":` + key + `,"hostname":"laptop","os":"linux","request_ip":true}`)
+	f.Add(`{"public_key":` + key + `,"hostname":"laptop","endpoint":"192.0.2.1:51820","endpoints":["192.0.2.1:51820","[2001:db8::1]:51820"],"allowed_ips":["192.168.1.0/24"],"exit_node":true}`)
+	f.Add(`{"public_key":` + key + `,"hostname":"-bad-","os":"\u0000","allowed_ips":["0.0.0.0/0","10.100.0.0/24","192.168.1.1/24"]}`)
+	f.Add(`{"public_key":` + key + `,"dry_run":true,"idempotency_key":"attempt-1"}`)
+	f.Add(`{"public_key":"a2V5","hostname":1,"endpoints":null}`)
+	f.Add(`[]`)
+	f.Add(``)
+
+	s := newTestServer(f)
+	handler := s.Handler()
+
+	f.Fuzz(func(t *testing.T, body string) {
+		r := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader([]byte(body)))
+		r.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if err := s.CheckInvariants(); err != nil {
+			t.Fatalf("invariants after %q: %v", body, err)
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, peer := range s.peers {
+			if peer.ID == ServerPeerID {
+				continue
+			}
+			if param, err := s.validateRegistration(storedRequest(peer)); err != nil {
+				t.Fatalf("after %q, peer %s is stored with invalid %s: %v", body, peer.ID, param, err)
+			}
+		}
+	})
+}
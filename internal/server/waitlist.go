@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// waitlistTimeout is how long a waiting client may go without retrying its
+// registration before it loses its place
+const waitlistTimeout = 15 * time.Minute
+
+// WaitlistEntry is a client waiting for an address
+type WaitlistEntry struct {
+	PublicKey string    `json:"public_key"`
+	Hostname  string    `json:"hostname"`
+	Group     string    `json:"group,omitempty"` // Waiting for an address in this group's range
+	Since     time.Time `json:"since"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// waitlist queues new peers that found the address pool exhausted. Waiting
+// clients keep retrying their registration; as addresses are freed they go
+// to the clients at the front, in order. Callers must hold s.mu.
+type waitlist struct {
+	entries []*WaitlistEntry // Oldest first
+}
+
+// ahead returns how many clients waiting for an address in group are in
+// front of publicKey, which is all of them for a client that is not waiting.
+// Groups draw from separate ranges, so they queue separately.
+func (w *waitlist) ahead(publicKey, group string, now time.Time) int {
+	w.expire(now)
+
+	ahead := 0
+	for _, entry := range w.entries {
+		if entry.PublicKey == publicKey {
+			return ahead
+		}
+		if entry.Group == group {
+			ahead++
+		}
+	}
+	return ahead
+}
+
+// join puts publicKey on the waitlist for group, or refreshes its place, and
+// returns its position in that group's queue counting from one
+func (w *waitlist) join(publicKey, hostname, group string, now time.Time) int {
+	position := w.ahead(publicKey, group, now) + 1
+
+	for _, entry := range w.entries {
+		if entry.PublicKey == publicKey {
+			entry.Hostname = hostname
+			entry.Group = group
+			entry.LastSeen = now
+			return position
+		}
+	}
+
+	w.entries = append(w.entries, &WaitlistEntry{
+		PublicKey: publicKey,
+		Hostname:  hostname,
+		Group:     group,
+		Since:     now,
+		LastSeen:  now,
+	})
+	return position
+}
+
+// leave takes publicKey off the waitlist
+func (w *waitlist) leave(publicKey string) {
+	for i, entry := range w.entries {
+		if entry.PublicKey == publicKey {
+			w.entries = append(w.entries[:i], w.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// list returns copies of the waiting clients, oldest first
+func (w *waitlist) list(now time.Time) []WaitlistEntry {
+	w.expire(now)
+
+	entries := make([]WaitlistEntry, len(w.entries))
+	for i, entry := range w.entries {
+		entries[i] = *entry
+	}
+	return entries
+}
+
+// expire drops clients that stopped retrying
+func (w *waitlist) expire(now time.Time) {
+	kept := w.entries[:0]
+	for _, entry := range w.entries {
+		if now.Sub(entry.LastSeen) <= waitlistTimeout {
+			kept = append(kept, entry)
+		} else {
+			log.Printf("Dropped %s (%s) from the address waitlist: stopped retrying", entry.PublicKey, entry.Hostname)
+		}
+	}
+	w.entries = kept
+}
+
+// freeAddresses returns how many addresses the pool of group has left, ""
+// being the space outside every group. Callers must hold s.mu.
+func (s *Server) freeAddresses(group string) int {
+	if len(s.config.Groups) == 0 {
+		return s.ipAllocator.Size() - s.ipAllocator.Count()
+	}
+	size, used := s.ipAllocator.Usage(group)
+	return size - used
+}
+
+// poolExhausted answers a new peer that could not be given an address. With
+// the waitlist enabled the peer joins it and learns its position. Callers
+// must hold s.mu.
+func (s *Server) poolExhausted(req *protocol.RegisterRequest, group string, now time.Time) protocol.RegisterResponse {
+	pool := s.ipAllocator.GetNetworkCIDR()
+	if group != "" {
+		pool = fmt.Sprintf("%s of group %s", s.ipAllocator.SegmentCIDR(group), group)
+	}
+	resp := protocol.RegisterResponse{
+		Success: false,
+		Error:   fmt.Sprintf("IP pool %s exhausted", pool),
+		Code:    protocol.ErrorCodePoolExhausted,
+	}
+
+	if !s.config.RegistrationWaitlist {
+		log.Printf("Refused registration of %s: IP pool %s exhausted", req.Hostname, pool)
+		return resp
+	}
+
+	resp.WaitlistPosition = s.waitlist.join(req.PublicKey, req.Hostname, group, now)
+	resp.Error = fmt.Sprintf("%s; waiting for an address at position %d", resp.Error, resp.WaitlistPosition)
+	log.Printf("IP pool %s exhausted: %s waits for an address at position %d", pool, req.Hostname, resp.WaitlistPosition)
+	return resp
+}
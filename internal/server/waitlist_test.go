@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+func TestWaitlistPromotesInOrder(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.ServerConfig) {
+		cfg.NetworkCIDR = "10.100.0.0/29"
+		cfg.RegistrationWaitlist = true
+	})
+	handler := s.Handler()
+
+	free := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.freeAddresses("")
+	}
+
+	// Fill the /29
+	var registered []string
+	for i := 0; free() > 0; i++ {
+		_, resp, err := postRegister(handler, newRegisterRequest(t, fmt.Sprintf("peer-%d", i)))
+		if err != nil || !resp.Success {
+			t.Fatalf("register with %d addresses free: %v %s", free(), err, resp.Error)
+		}
+		registered = append(registered, resp.PeerID)
+	}
+	if len(registered) == 0 || len(registered) > 6 {
+		t.Fatalf("%d peers fit in a /29", len(registered))
+	}
+
+	// Further registrations queue up behind one another
+	waiters := []*protocol.RegisterRequest{
+		newRegisterRequest(t, "first"),
+		newRegisterRequest(t, "second"),
+		newRegisterRequest(t, "third"),
+	}
+	for i, req := range waiters {
+		_, resp, err := postRegister(handler, req)
+		if err != nil {
+			t.Fatalf("register %s: %v", req.Hostname, err)
+		}
+		if resp.Success || resp.Code != protocol.ErrorCodePoolExhausted || resp.WaitlistPosition != i+1 {
+			t.Fatalf("%s: success %v code %q position %d, want waitlisted at %d",
+				req.Hostname, resp.Success, resp.Code, resp.WaitlistPosition, i+1)
+		}
+	}
+	// Retrying keeps a client's place
+	if _, resp, _ := postRegister(handler, waiters[1]); resp.WaitlistPosition != 2 {
+		t.Errorf("second waiter moved to position %d on retry, want 2", resp.WaitlistPosition)
+	}
+
+	// Free one address: it is held for the client at the front, so the
+	// others are still refused when they retry first
+	if err := s.removePeer(registered[0]); err != nil {
+		t.Fatalf("removePeer: %v", err)
+	}
+	for _, req := range waiters[1:] {
+		if _, resp, _ := postRegister(handler, req); resp.Success {
+			t.Fatalf("%s took the address held for the front of the waitlist", req.Hostname)
+		}
+	}
+	_, resp, err := postRegister(handler, waiters[0])
+	if err != nil || !resp.Success {
+		t.Fatalf("front of the waitlist: %v %s", err, resp.Error)
+	}
+
+	// The promoted client left the waitlist and the others moved up
+	s.mu.Lock()
+	entries := s.waitlist.list(time.Now())
+	s.mu.Unlock()
+	var waiting []string
+	for _, entry := range entries {
+		waiting = append(waiting, entry.Hostname)
+	}
+	if len(waiting) != 2 || waiting[0] != "second" || waiting[1] != "third" {
+		t.Errorf("waitlist after promotion: %v, want [second third]", waiting)
+	}
+	if _, resp, _ := postRegister(handler, waiters[1]); resp.Success || resp.WaitlistPosition != 1 {
+		t.Errorf("second waiter: success %v position %d, want waiting at 1", resp.Success, resp.WaitlistPosition)
+	}
+	if err := s.CheckInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
+
+func TestWaitlistExpires(t *testing.T) {
+	var w waitlist
+	now := time.Now()
+
+	w.join("key-a", "a", "", now)
+	w.join("key-b", "b", "servers", now)
+	if got := w.join("key-c", "c", "", now.Add(time.Minute)); got != 2 {
+		t.Errorf("c joined at %d, want 2: groups queue separately", got)
+	}
+
+	// a stops retrying; c keeps going and moves to the front
+	w.join("key-c", "c", "", now.Add(waitlistTimeout))
+	if got := w.ahead("key-c", "", now.Add(waitlistTimeout+time.Second)); got != 0 {
+		t.Errorf("%d ahead of c once a expired, want 0", got)
+	}
+	if got := len(w.list(now.Add(waitlistTimeout + time.Second))); got != 1 {
+		t.Errorf("%d clients waiting, want 1", got)
+	}
+}
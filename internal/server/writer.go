@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// storeFlushDelay lets a burst of changes accumulate into a single write
+	storeFlushDelay = 100 * time.Millisecond
+
+	// storeRetryInterval is how long a store waits before retrying a failed
+	// write
+	storeRetryInterval = time.Second
+)
+
+// backgroundWriter writes a store's contents to a JSON file off the request
+// path. The store's collect function returns its pending contents, or nil if
+// nothing changed, along with a function that marks them pending again should
+// the write fail.
+type backgroundWriter struct {
+	path    string
+	collect func() (interface{}, func())
+
+	flushMu   sync.Mutex                          // serialises file writes
+	observe   func(bytes int, took time.Duration) // Told of each write, under flushMu
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// newBackgroundWriter starts a writer for the file at path
+func newBackgroundWriter(path string, collect func() (interface{}, func())) *backgroundWriter {
+	w := &backgroundWriter{
+		path:    path,
+		collect: collect,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// schedule wakes the writer without blocking
+func (w *backgroundWriter) schedule() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush writes pending changes now
+func (w *backgroundWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	contents, restore := w.collect()
+	if contents == nil {
+		return nil
+	}
+
+	start := time.Now()
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(w.path, data, 0600)
+	}
+	if err != nil {
+		// Leave the changes pending for the next attempt
+		restore()
+		return fmt.Errorf("failed to write %s: %w", w.path, err)
+	}
+	if w.observe != nil {
+		w.observe(len(data), time.Since(start))
+	}
+	return nil
+}
+
+// setObserver registers a function told the size of each file written and
+// how long encoding and writing it took
+func (w *backgroundWriter) setObserver(observe func(bytes int, took time.Duration)) {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.observe = observe
+}
+
+// close stops the writer and flushes pending changes
+func (w *backgroundWriter) close() error {
+	w.closeOnce.Do(func() {
+		close(w.done)
+	})
+	<-w.stopped
+	return w.flush()
+}
+
+// run flushes changes as they are scheduled. Changes made while a write is
+// in progress are picked up by the next one.
+func (w *backgroundWriter) run() {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.wake:
+		}
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(storeFlushDelay):
+		}
+
+		if err := w.flush(); err != nil {
+			log.Printf("Store write failed: %v", err)
+			select {
+			case <-w.done:
+				return
+			case <-time.After(storeRetryInterval):
+				w.schedule()
+			}
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it into place, so readers never observe a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", path, err)
+	}
+	return nil
+}
+
+// readJSONFile decodes the file at path into v. A missing file leaves v
+// untouched.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,88 @@
+// Package systemd implements the small parts of systemd integration the
+// binaries need: the sd_notify protocol and unit file installation. Every
+// function is a no-op when not running under systemd.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notify sends a state string such as "READY=1" to the service manager. It
+// reports false without error when NOTIFY_SOCKET is not set.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// Abstract namespace sockets are announced with a leading '@'
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("failed to notify service manager: %w", err)
+	}
+
+	return true, nil
+}
+
+// Ready tells the service manager that startup has completed
+func Ready() (bool, error) {
+	return Notify("READY=1")
+}
+
+// Stopping tells the service manager that shutdown has begun
+func Stopping() (bool, error) {
+	return Notify("STOPPING=1")
+}
+
+// WatchdogInterval returns how often the service should ping the watchdog,
+// which is half of the configured WatchdogSec. It reports false when the
+// watchdog is not enabled for this process.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
+
+// RunWatchdog pings the service manager watchdog for as long as healthy
+// reports true, until stop is closed. Pings are withheld while unhealthy so
+// that systemd restarts a wedged service.
+func RunWatchdog(healthy func() bool, stop <-chan struct{}) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if healthy() {
+				Notify("WATCHDOG=1")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// UnitDir is where installed unit files are written
+const UnitDir = "/etc/systemd/system"
+
+// Unit describes a service unit to generate
+type Unit struct {
+	Name        string // Unit name without the .service suffix
+	Description string
+	ExecStart   []string // Absolute binary path followed by its arguments
+	User        string
+
+	// Capabilities, e.g. CAP_NET_ADMIN, are both the bounding set and
+	// granted as ambient capabilities to a non-root User
+	Capabilities []string
+
+	WatchdogSec int
+}
+
+// Render returns the unit file contents
+func (u Unit) Render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", u.Description)
+	fmt.Fprintf(&b, "Wants=network-online.target\n")
+	fmt.Fprintf(&b, "After=network-online.target\n")
+	fmt.Fprintf(&b, "\n[Service]\n")
+	fmt.Fprintf(&b, "Type=notify\n")
+	fmt.Fprintf(&b, "NotifyAccess=main\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", quoteArgs(u.ExecStart))
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=5\n")
+	if u.WatchdogSec > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", u.WatchdogSec)
+	}
+	if u.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", u.User)
+	}
+	if len(u.Capabilities) > 0 {
+		caps := strings.Join(u.Capabilities, " ")
+		fmt.Fprintf(&b, "CapabilityBoundingSet=%s\n", caps)
+		fmt.Fprintf(&b, "AmbientCapabilities=%s\n", caps)
+	}
+	fmt.Fprintf(&b, "\n[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=multi-user.target\n")
+
+	return b.String()
+}
+
+// Install writes the unit file, reloads systemd and enables the unit
+func (u Unit) Install() (string, error) {
+	if err := checkSystemd(); err != nil {
+		return "", err
+	}
+
+	path := unitPath(u.Name)
+	if err := os.WriteFile(path, []byte(u.Render()), 0644); err != nil {
+		return "", fmt.Errorf("failed to write unit file: %w", err)
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return path, err
+	}
+	if err := systemctl("enable", u.Name+".service"); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+// Uninstall stops and disables the named unit and removes its unit file
+func Uninstall(name string) error {
+	if err := checkSystemd(); err != nil {
+		return err
+	}
+
+	if err := systemctl("disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(unitPath(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove unit file: %w", err)
+	}
+
+	return systemctl("daemon-reload")
+}
+
+// Start starts the named unit
+func Start(name string) error {
+	if err := checkSystemd(); err != nil {
+		return err
+	}
+	return systemctl("start", name+".service")
+}
+
+// Stop stops the named unit
+func Stop(name string) error {
+	if err := checkSystemd(); err != nil {
+		return err
+	}
+	return systemctl("stop", name+".service")
+}
+
+// checkSystemd fails unless systemctl is available
+func checkSystemd() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("systemd services are only supported on Linux")
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("systemctl not found; is this a systemd system?")
+	}
+	return nil
+}
+
+// unitPath returns the path of the named unit file
+func unitPath(name string) string {
+	return filepath.Join(UnitDir, name+".service")
+}
+
+// systemctl runs systemctl with the given arguments
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s failed: %w, output: %s", strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
+
+// quoteArgs renders a command line using systemd's quoting rules
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		// Percent signs introduce unit specifiers
+		arg = strings.ReplaceAll(arg, "%", "%%")
+		if arg == "" || strings.ContainsAny(arg, " \t\"'\\") {
+			arg = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,428 @@
+// Package e2e runs a coordination server and simulated clients in one
+// process, the clients on fake WireGuard backends, so that the register,
+// sync and heartbeat loop can be exercised end to end without root
+// privileges or real interfaces.
+package e2e
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+	"github.com/vpn/wireguard-mesh/internal/server"
+	"github.com/vpn/wireguard-mesh/internal/testutil"
+	"github.com/vpn/wireguard-mesh/pkg/config"
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// Timings of a simulated mesh unless overridden in Options. A peer is
+// marked offline after HeartbeatTimeout seconds without a heartbeat.
+const (
+	DefaultHeartbeatInterval   = 100 * time.Millisecond
+	DefaultPeerSyncInterval    = 100 * time.Millisecond
+	DefaultReconfigureInterval = 100 * time.Millisecond
+	DefaultHeartbeatTimeout    = 1 // Seconds
+)
+
+// pollInterval is how often WaitFor checks its condition
+const pollInterval = 10 * time.Millisecond
+
+// Options adjust a simulated mesh
+type Options struct {
+	// Server customizes the server configuration before the server is
+	// created
+	Server func(cfg *config.ServerConfig)
+	// Client customizes the configuration of the i-th client before it is
+	// created
+	Client func(i int, cfg *config.ClientConfig)
+
+	HeartbeatInterval time.Duration
+	PeerSyncInterval  time.Duration
+	// ReconfigureInterval is the clients' least time between programmings
+	// of their peer lists; negative programs every list as it arrives
+	ReconfigureInterval time.Duration
+
+	// Logger receives the clients' logs; they are discarded when nil
+	Logger *slog.Logger
+}
+
+// Mesh is a coordination server behind an httptest listener and the
+// simulated clients registered with it
+type Mesh struct {
+	URL string
+
+	opts         Options
+	dir          string
+	serverConfig *config.ServerConfig
+	httpServer   *httptest.Server
+
+	mu      sync.Mutex
+	server  *server.Server // nil while restarting
+	handler http.Handler   // The server's, built once per server
+	clients []*Client
+}
+
+// Client is a simulated client: a real client.Client on a fake backend,
+// advertising an endpoint the test controls
+type Client struct {
+	*client.Client
+	Backend *testutil.FakeBackend
+	Config  *config.ClientConfig
+
+	mu       sync.Mutex
+	endpoint string
+}
+
+// NewMesh starts a server with its state in a temporary directory. Close
+// stops it and every client, and removes the directory.
+func NewMesh(opts Options) (*Mesh, error) {
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if opts.PeerSyncInterval == 0 {
+		opts.PeerSyncInterval = DefaultPeerSyncInterval
+	}
+	if opts.ReconfigureInterval == 0 {
+		opts.ReconfigureInterval = DefaultReconfigureInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	dir, err := os.MkdirTemp("", "wgmesh-e2e")
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cfg := config.DefaultServerConfig()
+	cfg.DBPath = filepath.Join(dir, "peers.json")
+	cfg.PrivateKey = keyPair.PrivateKeyToString()
+	cfg.PublicKey = keyPair.PublicKeyToString()
+	cfg.HeartbeatTimeout = DefaultHeartbeatTimeout
+	if opts.Server != nil {
+		opts.Server(cfg)
+	}
+
+	m := &Mesh{
+		opts:         opts,
+		dir:          dir,
+		serverConfig: cfg,
+	}
+	if m.server, err = startServer(cfg); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	m.handler = m.server.Handler()
+	m.httpServer = httptest.NewServer(http.HandlerFunc(m.serveHTTP))
+	m.URL = m.httpServer.URL
+
+	return m, nil
+}
+
+// startServer creates a server from its configuration, loading the stores
+// a previous one left, and starts its background routines
+func startServer(cfg *config.ServerConfig) (*server.Server, error) {
+	srv, err := server.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := srv.StartBackground(); err != nil {
+		srv.Shutdown(context.Background())
+		return nil, err
+	}
+	return srv, nil
+}
+
+// serveHTTP hands requests to the current server. While it restarts,
+// clients get the 503 a proxy in front of a restarting server would give.
+func (m *Mesh) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	handler := m.handler
+	m.mu.Unlock()
+
+	if handler == nil {
+		http.Error(w, "Server restarting", http.StatusServiceUnavailable)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// Server returns the current server, or nil while it restarts
+func (m *Mesh) Server() *server.Server {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.server
+}
+
+// RestartServer shuts the server down, flushing its stores, and starts a
+// new one that loads them again, behind the same URL
+func (m *Mesh) RestartServer() error {
+	m.mu.Lock()
+	old := m.server
+	m.server, m.handler = nil, nil
+	m.mu.Unlock()
+
+	if err := old.Shutdown(context.Background()); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	srv, err := startServer(m.serverConfig)
+	if err != nil {
+		return fmt.Errorf("failed to restart server: %w", err)
+	}
+
+	m.mu.Lock()
+	m.server, m.handler = srv, srv.Handler()
+	m.mu.Unlock()
+	return nil
+}
+
+// AddClient creates and starts one simulated client, with its own keys and
+// state directory
+func (m *Mesh) AddClient(ctx context.Context) (*Client, error) {
+	m.mu.Lock()
+	i := len(m.clients)
+	m.clients = append(m.clients, nil) // Reserve the index
+	m.mu.Unlock()
+
+	c, err := m.newClient(i)
+	if err == nil {
+		err = c.Start(ctx)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("client %d: %w", i, err)
+	}
+	m.clients[i] = c
+	return c, nil
+}
+
+// AddClients starts n simulated clients concurrently, as a burst of joins
+// would. It returns the clients that started and the errors of the others.
+func (m *Mesh) AddClients(ctx context.Context, n int) ([]*Client, error) {
+	clients := make([]*Client, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], errs[i] = m.AddClient(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	started := clients[:0]
+	for _, c := range clients {
+		if c != nil {
+			started = append(started, c)
+		}
+	}
+	return started, errors.Join(errs...)
+}
+
+// newClient creates the i-th client without starting it
+func (m *Mesh) newClient(i int) (*Client, error) {
+	cfg := config.DefaultClientConfig()
+	cfg.ServerAddr = m.URL
+	cfg.StateDir = filepath.Join(m.dir, fmt.Sprintf("client%d", i))
+	cfg.InterfaceName = fmt.Sprintf("wgsim%d", i)
+	if m.opts.Client != nil {
+		m.opts.Client(i, cfg)
+	}
+
+	backend := testutil.NewFakeBackend()
+	c := &Client{
+		Backend: backend,
+		Config:  cfg,
+		// Documentation addresses, one port per client
+		endpoint: fmt.Sprintf("192.0.2.1:%d", 20000+i),
+	}
+
+	var err error
+	c.Client, err = client.NewClient(cfg,
+		client.WithBackend(backend.Factory()),
+		client.WithLogger(m.opts.Logger.With("client", i)),
+		client.WithIntervals(m.opts.HeartbeatInterval, m.opts.PeerSyncInterval),
+		client.WithReconfigureInterval(max(m.opts.ReconfigureInterval, 0)),
+		client.WithEndpointDetector(c.detectEndpoints),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RemoveClient stops a client and forgets it. An ephemeral client leaves
+// the mesh at once; any other goes offline after the heartbeat timeout.
+func (m *Mesh) RemoveClient(c *Client) error {
+	m.mu.Lock()
+	for i, other := range m.clients {
+		if other == c {
+			m.clients[i] = nil
+		}
+	}
+	m.mu.Unlock()
+
+	return c.Stop()
+}
+
+// Clients returns the running clients, in the order they were added
+func (m *Mesh) Clients() []*Client {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	clients := make([]*Client, 0, len(m.clients))
+	for _, c := range m.clients {
+		if c != nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// WaitFor polls cond until it holds or timeout passes
+func (m *Mesh) WaitFor(timeout time.Duration, cond func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("condition not met within %s", timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+	return nil
+}
+
+// SyncWindow is how long a change takes to reach every client at the
+// latest: a heartbeat to report it, a peer sync to fetch it and the
+// reconfigure interval to program it, plus slack for scheduling
+func (m *Mesh) SyncWindow() time.Duration {
+	return 2*(m.opts.HeartbeatInterval+m.opts.PeerSyncInterval) + max(m.opts.ReconfigureInterval, 0) + time.Second
+}
+
+// CheckInvariants verifies the server's invariants, and that no two running
+// clients configured the same address
+func (m *Mesh) CheckInvariants() error {
+	var errs []error
+	if srv := m.Server(); srv != nil {
+		if err := srv.CheckInvariants(); err != nil {
+			errs = append(errs, fmt.Errorf("server: %w", err))
+		}
+	}
+
+	owners := make(map[string]string)
+	for _, c := range m.Clients() {
+		address := c.Address()
+		if other, ok := owners[address]; ok {
+			errs = append(errs, fmt.Errorf("clients %s and %s both use %s", other, c.PublicKey(), address))
+		}
+		owners[address] = c.PublicKey()
+	}
+	return errors.Join(errs...)
+}
+
+// Converged reports whether every running client has exactly the other
+// running clients programmed as peers
+func (m *Mesh) Converged() bool {
+	clients := m.Clients()
+	for _, c := range clients {
+		peers := c.Backend.Peers()
+		if len(peers) != len(clients)-1 {
+			return false
+		}
+		for _, other := range clients {
+			if other != c && !c.Sees(other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Close stops every client and the server, and removes the state directory
+func (m *Mesh) Close() error {
+	var errs []error
+	for _, c := range m.Clients() {
+		if err := c.Stop(); err != nil && !errors.Is(err, client.ErrNotRunning) {
+			errs = append(errs, err)
+		}
+	}
+	m.httpServer.Close()
+	if srv := m.Server(); srv != nil {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := os.RemoveAll(m.dir); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// PublicKey returns the client's WireGuard public key
+func (c *Client) PublicKey() string {
+	return c.Config.PublicKey
+}
+
+// PeerID returns the ID the server assigned the client
+func (c *Client) PeerID() string {
+	status, err := c.Status()
+	if err != nil {
+		return ""
+	}
+	return status.PeerID
+}
+
+// Address returns the interface address the client configured, with its
+// prefix length
+func (c *Client) Address() string {
+	return c.Backend.Config().Address
+}
+
+// SetEndpoint changes the endpoint the client advertises from its next
+// heartbeat on, as if the host had moved
+func (c *Client) SetEndpoint(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpoint = endpoint
+}
+
+// Endpoint returns the endpoint the client advertises
+func (c *Client) Endpoint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoint
+}
+
+// detectEndpoints stands in for the scan of local interfaces
+func (c *Client) detectEndpoints() ([]string, error) {
+	return []string{c.Endpoint()}, nil
+}
+
+// Sees reports whether other is programmed on the client's interface
+func (c *Client) Sees(other *Client) bool {
+	_, ok := c.Backend.Peers()[other.PublicKey()]
+	return ok
+}
+
+// PeerEndpoint returns the endpoint programmed for other on the client's
+// interface, or "" if other is not programmed
+func (c *Client) PeerEndpoint(other *Client) string {
+	return c.Backend.Peers()[other.PublicKey()].Endpoint
+}
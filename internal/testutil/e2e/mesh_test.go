@@ -0,0 +1,162 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/protocol"
+)
+
+// newMesh returns a mesh of n converged clients, closed with the test
+func newMesh(t *testing.T, n int) (*Mesh, []*Client) {
+	t.Helper()
+
+	m, err := NewMesh(Options{})
+	if err != nil {
+		t.Fatalf("NewMesh: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := m.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+
+	clients, err := m.AddClients(context.Background(), n)
+	if err != nil {
+		t.Fatalf("AddClients: %v", err)
+	}
+	if err := m.WaitFor(m.SyncWindow(), m.Converged); err != nil {
+		t.Fatalf("initial mesh: %v", err)
+	}
+	return m, clients
+}
+
+// serverView returns what the server tells viewer about other, and whether
+// other is in viewer's peer list at all
+func serverView(t *testing.T, m *Mesh, viewer, other *Client) (protocol.PeerInfo, bool) {
+	t.Helper()
+
+	query := url.Values{"peer_id": {viewer.PeerID()}}
+	resp, err := http.Get(m.URL + "/peers?" + query.Encode())
+	if err != nil {
+		t.Fatalf("GET /peers: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /peers: status %d", resp.StatusCode)
+	}
+
+	var list protocol.PeerListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding peer list: %v", err)
+	}
+	for _, peer := range list.Peers {
+		if peer.PublicKey == other.PublicKey() {
+			return peer, true
+		}
+	}
+	return protocol.PeerInfo{}, false
+}
+
+func TestNewPeerVisibleWithinOneSync(t *testing.T) {
+	m, clients := newMesh(t, 3)
+
+	joined, err := m.AddClient(context.Background())
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	err = m.WaitFor(m.SyncWindow(), func() bool {
+		for _, c := range clients {
+			if !c.Sees(joined) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("new peer not visible to every client: %v", err)
+	}
+	if err := m.WaitFor(m.SyncWindow(), m.Converged); err != nil {
+		t.Fatalf("new peer does not see the mesh: %v", err)
+	}
+}
+
+func TestOfflineTransition(t *testing.T) {
+	m, clients := newMesh(t, 3)
+	gone, survivors := clients[0], clients[1:]
+
+	// Stopping a client that is not ephemeral leaves it registered; it only
+	// goes offline once the server misses its heartbeats
+	if err := m.RemoveClient(gone); err != nil {
+		t.Fatalf("RemoveClient: %v", err)
+	}
+	timeout := 2*time.Duration(DefaultHeartbeatTimeout)*time.Second + m.SyncWindow()
+	err := m.WaitFor(timeout, func() bool {
+		for _, c := range survivors {
+			if c.Sees(gone) {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatalf("offline peer still programmed: %v", err)
+	}
+
+	peer, listed := serverView(t, m, survivors[0], gone)
+	if !listed {
+		t.Fatal("offline peer was removed from the peer list")
+	}
+	if peer.Online {
+		t.Error("offline peer is listed as online")
+	}
+	if !m.Converged() {
+		t.Error("survivors lost each other")
+	}
+}
+
+func TestEndpointPropagation(t *testing.T) {
+	m, clients := newMesh(t, 3)
+	moved := clients[0]
+
+	const endpoint = "198.51.100.7:4500"
+	moved.SetEndpoint(endpoint)
+	err := m.WaitFor(m.SyncWindow(), func() bool {
+		for _, c := range clients[1:] {
+			if c.PeerEndpoint(moved) != endpoint {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		for _, c := range clients[1:] {
+			t.Logf("%s has %s at %q", c.PublicKey(), moved.PublicKey(), c.PeerEndpoint(moved))
+		}
+		t.Fatalf("new endpoint did not propagate: %v", err)
+	}
+}
+
+func TestConcurrentJoinsGetUniqueAddresses(t *testing.T) {
+	m, clients := newMesh(t, 30)
+
+	owners := make(map[string]string, len(clients))
+	for _, c := range clients {
+		address := c.Address()
+		if address == "" {
+			t.Errorf("%s has no address", c.PublicKey())
+			continue
+		}
+		if other, ok := owners[address]; ok {
+			t.Errorf("%s and %s both got %s", other, c.PublicKey(), address)
+		}
+		owners[address] = c.PublicKey()
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Errorf("invariants: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// Defaults of a soak run unless overridden in SoakOptions. The intervals and
+// timeout are longer than a plain mesh's so that hundreds of clients do not
+// saturate the server into marking them offline.
+const (
+	DefaultSoakClients          = 200
+	DefaultSoakDuration         = time.Minute
+	DefaultSoakInterval         = time.Second
+	DefaultSoakHeartbeatTimeout = 5 // Seconds
+)
+
+// goroutineSlack is how many goroutines above the expected count a soak
+// tolerates, for runtime and net/http background work
+const goroutineSlack = 50
+
+// SoakOptions adjust a soak run
+type SoakOptions struct {
+	// Clients is the size the mesh churns around
+	Clients int
+	// Duration is how long to keep churning once the mesh is up
+	Duration time.Duration
+	// Seed makes the sequence of phases reproducible; the current time
+	// seeds it when 0
+	Seed int64
+
+	HeartbeatInterval time.Duration
+	PeerSyncInterval  time.Duration
+	// HeartbeatTimeout is in seconds, as in the server configuration. It
+	// also bounds how long ephemeral clients that left linger.
+	HeartbeatTimeout int
+
+	// Logf receives progress, one line per phase; it is discarded when nil
+	Logf func(format string, args ...any)
+}
+
+// soak is the state of a soak run
+type soak struct {
+	opts   SoakOptions
+	mesh   *Mesh
+	rng    *rand.Rand
+	settle time.Duration
+
+	baseGoroutines int // Before the mesh was created
+	perClient      int // Goroutines a running client accounts for
+}
+
+// Soak churns a simulated mesh for a while: clients join and leave, half of
+// them ephemeral, move to new endpoints, and the server restarts and reloads
+// its stores. After each phase it waits for the mesh to converge and checks
+// the invariants of the server and clients, and that goroutines grow with
+// the mesh only. Once the mesh is closed, the goroutines it started must
+// have ended. It returns the first failure, prefixed with the seed.
+func Soak(ctx context.Context, opts SoakOptions) error {
+	if opts.Clients == 0 {
+		opts.Clients = DefaultSoakClients
+	}
+	if opts.Duration == 0 {
+		opts.Duration = DefaultSoakDuration
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	if opts.HeartbeatInterval == 0 {
+		opts.HeartbeatInterval = DefaultSoakInterval
+	}
+	if opts.PeerSyncInterval == 0 {
+		opts.PeerSyncInterval = DefaultSoakInterval
+	}
+	if opts.HeartbeatTimeout == 0 {
+		opts.HeartbeatTimeout = DefaultSoakHeartbeatTimeout
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+	opts.Logf("soak: %d clients for %s, seed %d", opts.Clients, opts.Duration, opts.Seed)
+
+	s := &soak{
+		opts:           opts,
+		rng:            rand.New(rand.NewSource(opts.Seed)),
+		baseGoroutines: runtime.NumGoroutine(),
+	}
+	mesh, err := NewMesh(Options{
+		Server: func(cfg *config.ServerConfig) {
+			// Ephemeral clients that leave are removed within a phase
+			cfg.HeartbeatTimeout = opts.HeartbeatTimeout
+			cfg.EphemeralTimeout = opts.HeartbeatTimeout
+		},
+		Client: func(i int, cfg *config.ClientConfig) {
+			cfg.Ephemeral = i%2 == 1
+		},
+		HeartbeatInterval: opts.HeartbeatInterval,
+		PeerSyncInterval:  opts.PeerSyncInterval,
+	})
+	if err != nil {
+		return err
+	}
+	s.mesh = mesh
+	// A departed client is marked offline, or removed if ephemeral, after
+	// the heartbeat timeout and the cleanup that notices it
+	s.settle = mesh.SyncWindow() + 2*time.Duration(opts.HeartbeatTimeout)*time.Second
+
+	err = s.run(ctx)
+	if closeErr := mesh.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = s.checkNoLeaks()
+	}
+	if err != nil {
+		// The seed replays the run
+		return fmt.Errorf("seed %d: %w", opts.Seed, err)
+	}
+	return nil
+}
+
+// run brings the mesh up and churns it until the duration passes
+func (s *soak) run(ctx context.Context) error {
+	if _, err := s.mesh.AddClients(ctx, s.opts.Clients); err != nil {
+		return fmt.Errorf("initial join: %w", err)
+	}
+	if err := s.check("initial join", nil); err != nil {
+		return err
+	}
+	// Measured once the mesh is up, with a quarter on top for the
+	// connections of requests in flight
+	running := len(s.mesh.Clients())
+	s.perClient = (runtime.NumGoroutine()-s.baseGoroutines)*5/4/running + 1
+
+	deadline := time.Now().Add(s.opts.Duration)
+	for phase := 1; time.Now().Before(deadline); phase++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.phase(ctx, phase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// phase runs one step of churn and checks the mesh afterwards. Every fifth
+// phase restarts the server; the others join, leave or move clients, keeping
+// the mesh between half and one and a half times its nominal size.
+func (s *soak) phase(ctx context.Context, n int) error {
+	clients := s.mesh.Clients()
+	size := len(clients)
+	count := 1 + s.rng.Intn(s.opts.Clients/10+1)
+
+	kind := s.rng.Intn(3)
+	switch {
+	case n%5 == 0:
+		kind = 3
+	case size-count < s.opts.Clients/2:
+		kind = 0
+	case size+count > s.opts.Clients*3/2:
+		kind = 1
+	}
+
+	var name string
+	var moved []*Client
+	switch kind {
+	case 0:
+		name = fmt.Sprintf("join %d", count)
+		if _, err := s.mesh.AddClients(ctx, count); err != nil {
+			return fmt.Errorf("phase %d (%s): %w", n, name, err)
+		}
+	case 1:
+		name = fmt.Sprintf("leave %d", count)
+		for _, i := range s.rng.Perm(size)[:count] {
+			if err := s.mesh.RemoveClient(clients[i]); err != nil {
+				return fmt.Errorf("phase %d (%s): %w", n, name, err)
+			}
+		}
+	case 2:
+		name = fmt.Sprintf("move %d", count)
+		for _, i := range s.rng.Perm(size)[:count] {
+			c := clients[i]
+			c.SetEndpoint(fmt.Sprintf("198.51.100.%d:%d", 1+s.rng.Intn(254), 1024+s.rng.Intn(60000)))
+			moved = append(moved, c)
+		}
+	case 3:
+		name = "restart server"
+		if err := s.mesh.RestartServer(); err != nil {
+			return fmt.Errorf("phase %d (%s): %w", n, name, err)
+		}
+	}
+
+	if err := s.check(fmt.Sprintf("phase %d (%s)", n, name), moved); err != nil {
+		return err
+	}
+	s.opts.Logf("soak: phase %d (%s): %d clients, %d goroutines", n, name, len(s.mesh.Clients()), runtime.NumGoroutine())
+	return nil
+}
+
+// check waits for the mesh to converge, with every moved client seen at its
+// new endpoint, then verifies the invariants and the goroutine count
+func (s *soak) check(phase string, moved []*Client) error {
+	err := s.mesh.WaitFor(s.settle, func() bool {
+		return s.mesh.Converged() && s.endpointsSeen(moved)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: mesh did not converge: %w", phase, err)
+	}
+	if err := s.mesh.CheckInvariants(); err != nil {
+		return fmt.Errorf("%s: %w", phase, err)
+	}
+
+	if s.perClient > 0 {
+		limit := s.baseGoroutines + s.perClient*len(s.mesh.Clients()) + goroutineSlack
+		if err := s.waitGoroutines(limit); err != nil {
+			return fmt.Errorf("%s: %w", phase, err)
+		}
+	}
+	return nil
+}
+
+// waitGoroutines waits for the goroutine count to drop to limit, giving the
+// goroutines of finished requests and stopped clients time to end
+func (s *soak) waitGoroutines(limit int) error {
+	err := s.mesh.WaitFor(s.settle, func() bool {
+		return runtime.NumGoroutine() <= limit
+	})
+	if err != nil {
+		return fmt.Errorf("%d goroutines, expected at most %d", runtime.NumGoroutine(), limit)
+	}
+	return nil
+}
+
+// endpointsSeen reports whether every running client has the moved clients
+// programmed at their current endpoints
+func (s *soak) endpointsSeen(moved []*Client) bool {
+	clients := s.mesh.Clients()
+	for _, m := range moved {
+		for _, c := range clients {
+			if c != m && c.PeerEndpoint(m) != m.Endpoint() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkNoLeaks waits for the goroutines of the closed mesh to end
+func (s *soak) checkNoLeaks() error {
+	if err := s.waitGoroutines(s.baseGoroutines + goroutineSlack); err != nil {
+		return fmt.Errorf("after close: %w", err)
+	}
+	return nil
+}
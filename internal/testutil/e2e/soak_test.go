@@ -0,0 +1,43 @@
+// +build soak
+
+package e2e
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"testing"
+)
+
+// The soak is left out of normal test runs:
+//
+//	go test -tags soak -run Soak -timeout 0 -v ./internal/testutil/e2e -soak.duration 5m
+//	go test -race -tags soak -run Soak -timeout 0 ./internal/testutil/e2e -soak.clients 30 -soak.duration 1m
+var (
+	soakClients   = flag.Int("soak.clients", DefaultSoakClients, "Number of clients the mesh churns around")
+	soakDuration  = flag.Duration("soak.duration", DefaultSoakDuration, "How long to churn once the mesh is up")
+	soakSeed      = flag.Int64("soak.seed", 0, "Seed of the churn, to replay a failure (random when 0)")
+	soakServerLog = flag.Bool("soak.serverlog", false, "Also print the server's log")
+)
+
+// TestSoak churns a simulated mesh of hundreds of clients to catch leaks,
+// races and inconsistent state. Progress is logged per phase, shown with -v.
+func TestSoak(t *testing.T) {
+	// The server logs through the standard logger
+	if !*soakServerLog {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	err := Soak(context.Background(), SoakOptions{
+		Clients:  *soakClients,
+		Duration: *soakDuration,
+		Seed:     *soakSeed,
+		Logf:     t.Logf,
+	})
+	if err != nil {
+		t.Fatalf("soak failed: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+// Package testutil provides in-memory stand-ins for platform dependencies so
+// that client and server logic can be exercised without root privileges.
+package testutil
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vpn/wireguard-mesh/internal/wireguard"
+)
+
+var _ wireguard.Backend = (*FakeBackend)(nil)
+
+// FakeBackend is an in-memory wireguard.Backend that records every call
+type FakeBackend struct {
+	mu         sync.Mutex
+	config     wireguard.Config
+	peers      map[string]wireguard.PeerConfig
+	calls      []string
+	created    bool
+	configured bool
+	destroyed  bool
+
+	// Errors returned by the corresponding methods when set
+	CreateErr     error
+	ConfigureErr  error
+	AddPeerErr    error
+	RemovePeerErr error
+	DestroyErr    error
+	CheckErr      error
+	SetAddressErr error
+}
+
+// NewFakeBackend creates an empty fake backend
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		peers: make(map[string]wireguard.PeerConfig),
+	}
+}
+
+// Factory returns a wireguard.BackendFactory that always yields this backend,
+// recording the configuration it was created with
+func (f *FakeBackend) Factory() wireguard.BackendFactory {
+	return func(config wireguard.Config) (wireguard.Backend, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.config = config
+		return f, nil
+	}
+}
+
+// Create marks the interface as created
+func (f *FakeBackend) Create() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "Create")
+	if f.CreateErr != nil {
+		return f.CreateErr
+	}
+	f.created = true
+	f.destroyed = false
+	return nil
+}
+
+// Configure marks the interface as configured
+func (f *FakeBackend) Configure() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "Configure")
+	if f.ConfigureErr != nil {
+		return f.ConfigureErr
+	}
+	if !f.created {
+		return fmt.Errorf("interface %s does not exist", f.config.InterfaceName)
+	}
+	f.configured = true
+	return nil
+}
+
+// AddPeer adds or replaces a peer
+func (f *FakeBackend) AddPeer(peer wireguard.PeerConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "AddPeer "+peer.PublicKey)
+	if f.AddPeerErr != nil {
+		return f.AddPeerErr
+	}
+	f.peers[peer.PublicKey] = peer
+	return nil
+}
+
+// AddPeers adds or replaces peers in one call. AddPeerErr fails every
+// peer, reported as a *wireguard.PeerBatchError.
+func (f *FakeBackend) AddPeers(peers []wireguard.PeerConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, fmt.Sprintf("AddPeers %d", len(peers)))
+	if f.AddPeerErr != nil {
+		failed := make(map[string]error, len(peers))
+		for _, peer := range peers {
+			failed[peer.PublicKey] = f.AddPeerErr
+		}
+		return &wireguard.PeerBatchError{Total: len(peers), Failed: failed}
+	}
+	for _, peer := range peers {
+		f.peers[peer.PublicKey] = peer
+	}
+	return nil
+}
+
+// UpdatePeerEndpoint changes the endpoint of a configured peer
+func (f *FakeBackend) UpdatePeerEndpoint(publicKey, endpoint string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "UpdatePeerEndpoint "+publicKey+" "+endpoint)
+	peer, ok := f.peers[publicKey]
+	if !ok {
+		return fmt.Errorf("peer %s is not configured", publicKey)
+	}
+	peer.Endpoint = endpoint
+	f.peers[publicKey] = peer
+	return nil
+}
+
+// RemovePeer removes a peer
+func (f *FakeBackend) RemovePeer(publicKey string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "RemovePeer "+publicKey)
+	if f.RemovePeerErr != nil {
+		return f.RemovePeerErr
+	}
+	delete(f.peers, publicKey)
+	return nil
+}
+
+// Destroy tears the interface down and forgets all peers
+func (f *FakeBackend) Destroy() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "Destroy")
+	if f.DestroyErr != nil {
+		return f.DestroyErr
+	}
+	f.created = false
+	f.configured = false
+	f.destroyed = true
+	f.peers = make(map[string]wireguard.PeerConfig)
+	return nil
+}
+
+// Close records the call; the fake holds no handles
+func (f *FakeBackend) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "Close")
+	return nil
+}
+
+// SetAddress changes the interface address, keeping its peers
+func (f *FakeBackend) SetAddress(address string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "SetAddress "+address)
+	if f.SetAddressErr != nil {
+		return f.SetAddressErr
+	}
+	if !f.created {
+		return fmt.Errorf("interface %s does not exist", f.config.InterfaceName)
+	}
+	f.config.Address = address
+	return nil
+}
+
+// GetStats returns statistics shaped like those of the real interface
+func (f *FakeBackend) GetStats() (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.created {
+		return nil, fmt.Errorf("interface %s does not exist", f.config.InterfaceName)
+	}
+
+	peers := []map[string]interface{}{}
+	for _, peer := range f.peers {
+		peers = append(peers, map[string]interface{}{
+			"public_key":  peer.PublicKey,
+			"endpoint":    peer.Endpoint,
+			"allowed_ips": peer.AllowedIPs,
+		})
+	}
+
+	return map[string]interface{}{
+		"name":        f.config.InterfaceName,
+		"listen_port": f.config.ListenPort,
+		"num_peers":   len(f.peers),
+		"peers":       peers,
+	}, nil
+}
+
+// Check fails once the interface is gone, e.g. after SimulateDeletion
+func (f *FakeBackend) Check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, "Check")
+	if f.CheckErr != nil {
+		return f.CheckErr
+	}
+	if !f.created {
+		return fmt.Errorf("device %s not found", f.config.InterfaceName)
+	}
+	return nil
+}
+
+// SimulateDeletion mimics the interface being deleted behind the client's
+// back, as with `ip link del`
+func (f *FakeBackend) SimulateDeletion() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.created = false
+	f.configured = false
+	f.peers = make(map[string]wireguard.PeerConfig)
+}
+
+// Config returns the configuration the backend was created with
+func (f *FakeBackend) Config() wireguard.Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.config
+}
+
+// Peers returns a snapshot of the currently configured peers
+func (f *FakeBackend) Peers() map[string]wireguard.PeerConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	peers := make(map[string]wireguard.PeerConfig, len(f.peers))
+	for k, v := range f.peers {
+		peers[k] = v
+	}
+	return peers
+}
+
+// Calls returns the ordered list of recorded calls
+func (f *FakeBackend) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.calls...)
+}
+
+// State reports whether the interface is created, configured and destroyed
+func (f *FakeBackend) State() (created, configured, destroyed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.created, f.configured, f.destroyed
+}
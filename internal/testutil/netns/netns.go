@@ -0,0 +1,157 @@
+// +build linux
+
+// Package netns sets up Linux network namespaces joined by veth pairs, for
+// running real servers and clients side by side on one machine. It drives
+// the ip tool and needs root.
+package netns
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Namespace is a named network namespace
+type Namespace struct {
+	Name string
+}
+
+// New creates a network namespace with its loopback interface up. A
+// namespace of the same name left behind by an earlier run is replaced.
+func New(name string) (*Namespace, error) {
+	_ = exec.Command("ip", "netns", "del", name).Run()
+	if err := run("ip", "netns", "add", name); err != nil {
+		return nil, err
+	}
+	ns := &Namespace{Name: name}
+	if err := ns.Run("ip", "link", "set", "lo", "up"); err != nil {
+		ns.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Command returns a command that runs inside the namespace
+func (ns *Namespace) Command(name string, args ...string) *exec.Cmd {
+	return exec.Command("ip", append([]string{"netns", "exec", ns.Name, name}, args...)...)
+}
+
+// Run runs a command inside the namespace, returning its output in the
+// error when it fails
+func (ns *Namespace) Run(name string, args ...string) error {
+	output, err := ns.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s in %s: %w, output: %s", name, strings.Join(args, " "), ns.Name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Output runs a command inside the namespace and returns its output
+func (ns *Namespace) Output(name string, args ...string) (string, error) {
+	output, err := ns.Command(name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s in %s: %w", name, strings.Join(args, " "), ns.Name, err)
+	}
+	return string(output), nil
+}
+
+// Links returns the names of the namespace's network interfaces
+func (ns *Namespace) Links() ([]string, error) {
+	output, err := ns.Output("ip", "-o", "link", "show")
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		// "3: wg0: <POINTOPOINT,...>" or "4: veth0@if5: <...>"
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
+		links = append(links, name)
+	}
+	return links, nil
+}
+
+// Routes returns the namespace's IPv4 and IPv6 routes in every table, one
+// per line
+func (ns *Namespace) Routes() ([]string, error) {
+	var routes []string
+	for _, family := range []string{"-4", "-6"} {
+		output, err := ns.Output("ip", family, "route", "show", "table", "all")
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if line != "" {
+				routes = append(routes, line)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// EnableForwarding makes the namespace route IPv4 packets between its
+// interfaces
+func (ns *Namespace) EnableForwarding() error {
+	return ns.Run("sysctl", "-qw", "net.ipv4.ip_forward=1")
+}
+
+// Close deletes the namespace and with it every interface inside
+func (ns *Namespace) Close() error {
+	return run("ip", "netns", "del", ns.Name)
+}
+
+// Link is one end of a veth pair
+type Link struct {
+	Namespace *Namespace
+	Name      string
+	Address   string // With prefix length, e.g. "10.201.1.1/24"
+}
+
+// IP returns the address without its prefix length
+func (l Link) IP() string {
+	ip, _, _ := strings.Cut(l.Address, "/")
+	return ip
+}
+
+// Connect joins two namespaces with a veth pair, gives each end its address
+// and brings both up
+func Connect(a, b Link) error {
+	if err := run("ip", "link", "add", a.Name, "netns", a.Namespace.Name, "type", "veth",
+		"peer", "name", b.Name, "netns", b.Namespace.Name); err != nil {
+		return err
+	}
+	for _, end := range []Link{a, b} {
+		if err := end.Namespace.Run("ip", "addr", "add", end.Address, "dev", end.Name); err != nil {
+			return err
+		}
+		if err := end.Namespace.Run("ip", "link", "set", end.Name, "up"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SupportsWireGuard reports whether the kernel can create WireGuard
+// interfaces, trying one in a scratch namespace
+func SupportsWireGuard() error {
+	ns, err := New("wgmesh-probe")
+	if err != nil {
+		return err
+	}
+	defer ns.Close()
+	if err := ns.Run("ip", "link", "add", "wgprobe", "type", "wireguard"); err != nil {
+		return fmt.Errorf("kernel WireGuard is not available: %w", err)
+	}
+	return nil
+}
+
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w, output: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
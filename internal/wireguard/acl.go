@@ -0,0 +1,178 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ACLRules identifies the rules ApplyACL installed for an interface, with
+// what RemoveACL needs to take them out again
+type ACLRules struct {
+	Interface string `json:"interface"`
+	Tool      string `json:"tool"` // FirewallNft, FirewallIptables, FirewallPF or FirewallNetsh
+}
+
+// aclName names the nft table, iptables chain, pf anchor or Windows rule
+// holding the ACL of an interface. Interface names are at most 15
+// characters, which keeps this within the 28 allowed for iptables chains.
+func aclName(iface string) string {
+	return "wgmesh-acl-" + iface
+}
+
+// aclPrefixes parses source prefixes into canonical IPv4 and IPv6 lists,
+// sorted, without duplicates and without prefixes inside another one, which
+// nft refuses in a set. Entries that do not parse are skipped; a bare
+// address counts as a single host.
+func aclPrefixes(sources []string) (v4, v6 []netip.Prefix) {
+	seen := make(map[netip.Prefix]bool, len(sources))
+	for _, source := range sources {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			addr, err := netip.ParseAddr(source)
+			if err != nil {
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		if prefix.Addr().Is4() {
+			v4 = append(v4, prefix)
+		} else {
+			v6 = append(v6, prefix)
+		}
+	}
+
+	less := func(list []netip.Prefix) func(i, j int) bool {
+		return func(i, j int) bool {
+			if c := list[i].Addr().Compare(list[j].Addr()); c != 0 {
+				return c < 0
+			}
+			return list[i].Bits() < list[j].Bits()
+		}
+	}
+	sort.Slice(v4, less(v4))
+	sort.Slice(v6, less(v6))
+	return outermost(v4), outermost(v6)
+}
+
+// outermost drops the prefixes of a sorted list that lie inside an earlier
+// one. Prefixes either nest or are disjoint, so comparing with the last one
+// kept is enough.
+func outermost(prefixes []netip.Prefix) []netip.Prefix {
+	var kept []netip.Prefix
+	for _, prefix := range prefixes {
+		if n := len(kept); n > 0 && kept[n-1].Contains(prefix.Addr()) {
+			continue
+		}
+		kept = append(kept, prefix)
+	}
+	return kept
+}
+
+// joinPrefixes formats prefixes as a comma-separated list
+func joinPrefixes(prefixes []netip.Prefix) string {
+	parts := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		parts[i] = prefix.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// nftACLScript returns an nft script replacing the ACL table of iface. The
+// table has its own input chain, since a drop is final in whichever chain
+// it happens. Replies to connections this node opened are let through.
+func nftACLScript(iface string, sources []string) string {
+	v4, v6 := aclPrefixes(sources)
+	name := aclName(iface)
+
+	var b strings.Builder
+	// Declaring the table first makes the delete succeed when it is new
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n", name, name)
+	fmt.Fprintf(&b, "table inet %s {\n", name)
+	b.WriteString("\tchain input {\n")
+	b.WriteString("\t\ttype filter hook input priority 0; policy accept;\n")
+	fmt.Fprintf(&b, "\t\tiifname %q ct state established,related accept\n", iface)
+	if len(v4) > 0 {
+		fmt.Fprintf(&b, "\t\tiifname %q ip saddr { %s } accept\n", iface, joinPrefixes(v4))
+	}
+	if len(v6) > 0 {
+		fmt.Fprintf(&b, "\t\tiifname %q ip6 saddr { %s } accept\n", iface, joinPrefixes(v6))
+	}
+	fmt.Fprintf(&b, "\t\tiifname %q drop\n", iface)
+	b.WriteString("\t}\n}\n")
+	return b.String()
+}
+
+// iptablesACLRules returns the iptables (ipv6 false) or ip6tables rules
+// filling the ACL chain: replies and listed sources return to INPUT,
+// everything else arriving on the interface is dropped
+func iptablesACLRules(chain string, sources []string, ipv6 bool) [][]string {
+	v4, v6 := aclPrefixes(sources)
+	prefixes := v4
+	if ipv6 {
+		prefixes = v6
+	}
+
+	rules := [][]string{{"-A", chain, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"}}
+	for _, prefix := range prefixes {
+		rules = append(rules, []string{"-A", chain, "-s", prefix.String(), "-j", "RETURN"})
+	}
+	return append(rules, []string{"-A", chain, "-j", "DROP"})
+}
+
+// pfACLRules returns the pf rules of the ACL anchor of iface. pf keeps
+// state, so replies to connections this node opened still pass.
+func pfACLRules(iface string, sources []string) string {
+	v4, v6 := aclPrefixes(sources)
+	all := append(v4, v6...)
+
+	var b strings.Builder
+	if len(all) > 0 {
+		fmt.Fprintf(&b, "pass in quick on %s from { %s } to any\n", iface, joinPrefixes(all))
+	}
+	fmt.Fprintf(&b, "block drop in quick on %s all\n", iface)
+	return b.String()
+}
+
+// windowsACLBlockRanges returns the IPv4 address ranges not covered by
+// sources, as netsh "remoteip" takes them. Windows Firewall scopes rules by
+// address rather than interface, so the ACL is a block rule for traffic to
+// the interface address from everywhere else.
+func windowsACLBlockRanges(sources []string) []string {
+	v4, _ := aclPrefixes(sources)
+
+	var ranges []string
+	next := netip.IPv4Unspecified()
+	for _, prefix := range v4 {
+		first, last := prefix.Addr(), lastAddr(prefix)
+		if next.Less(first) {
+			ranges = append(ranges, next.String()+"-"+first.Prev().String())
+		}
+		if !last.Next().IsValid() {
+			// Covered up to the last address
+			return ranges
+		}
+		next = last.Next()
+	}
+	return append(ranges, next.String()+"-255.255.255.255")
+}
+
+// lastAddr returns the highest address in prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Masked().Addr()
+	raw := addr.AsSlice()
+	for bit := prefix.Bits(); bit < len(raw)*8; bit++ {
+		raw[bit/8] |= 0x80 >> (bit % 8)
+	}
+	last, _ := netip.AddrFromSlice(raw)
+	return last
+}
@@ -0,0 +1,133 @@
+package wireguard
+
+import (
+	"reflect"
+	"testing"
+)
+
+// aclSources has duplicates, nested prefixes, a bare address, an
+// IPv4-mapped prefix, unmasked bits and garbage, in no order
+var aclSources = []string{
+	"10.100.0.0/16",
+	"192.168.1.7",
+	"10.100.5.0/24",
+	"fd00::/64",
+	"::ffff:172.16.0.0/108",
+	"10.100.0.0/16",
+	"2001:db8::1",
+	"192.168.1.7/32",
+	"10.200.3.9/24",
+	"not-a-prefix",
+}
+
+func TestACLPrefixes(t *testing.T) {
+	v4, v6 := aclPrefixes(aclSources)
+	if got, want := joinPrefixes(v4), "10.100.0.0/16, 10.200.3.0/24, 172.16.0.0/12, 192.168.1.7/32"; got != want {
+		t.Errorf("IPv4: got %s, want %s", got, want)
+	}
+	if got, want := joinPrefixes(v6), "2001:db8::1/128, fd00::/64"; got != want {
+		t.Errorf("IPv6: got %s, want %s", got, want)
+	}
+}
+
+func TestNftACLScript(t *testing.T) {
+	want := `table inet wgmesh-acl-wg0
+delete table inet wgmesh-acl-wg0
+table inet wgmesh-acl-wg0 {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname "wg0" ct state established,related accept
+		iifname "wg0" ip saddr { 10.100.0.0/16, 10.200.3.0/24, 172.16.0.0/12, 192.168.1.7/32 } accept
+		iifname "wg0" ip6 saddr { 2001:db8::1/128, fd00::/64 } accept
+		iifname "wg0" drop
+	}
+}
+`
+	if got := nftACLScript("wg0", aclSources); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Without sources only replies are let in
+	want = `table inet wgmesh-acl-wg0
+delete table inet wgmesh-acl-wg0
+table inet wgmesh-acl-wg0 {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname "wg0" ct state established,related accept
+		iifname "wg0" drop
+	}
+}
+`
+	if got := nftACLScript("wg0", nil); got != want {
+		t.Errorf("no sources, got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestIptablesACLRules(t *testing.T) {
+	chain := aclName("wg0")
+	established := []string{"-A", chain, "-m", "conntrack", "--ctstate", "ESTABLISHED,RELATED", "-j", "RETURN"}
+	drop := []string{"-A", chain, "-j", "DROP"}
+
+	want := [][]string{
+		established,
+		{"-A", chain, "-s", "10.100.0.0/16", "-j", "RETURN"},
+		{"-A", chain, "-s", "10.200.3.0/24", "-j", "RETURN"},
+		{"-A", chain, "-s", "172.16.0.0/12", "-j", "RETURN"},
+		{"-A", chain, "-s", "192.168.1.7/32", "-j", "RETURN"},
+		drop,
+	}
+	if got := iptablesACLRules(chain, aclSources, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("iptables:\n got %q\nwant %q", got, want)
+	}
+
+	want = [][]string{
+		established,
+		{"-A", chain, "-s", "2001:db8::1/128", "-j", "RETURN"},
+		{"-A", chain, "-s", "fd00::/64", "-j", "RETURN"},
+		drop,
+	}
+	if got := iptablesACLRules(chain, aclSources, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("ip6tables:\n got %q\nwant %q", got, want)
+	}
+	if len(chain) > 28 {
+		t.Errorf("chain name %s is longer than iptables allows", chain)
+	}
+}
+
+func TestPfACLRules(t *testing.T) {
+	want := "pass in quick on wg0 from { 10.100.0.0/16, 10.200.3.0/24, 172.16.0.0/12, 192.168.1.7/32, 2001:db8::1/128, fd00::/64 } to any\n" +
+		"block drop in quick on wg0 all\n"
+	if got := pfACLRules("wg0", aclSources); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := pfACLRules("wg0", nil), "block drop in quick on wg0 all\n"; got != want {
+		t.Errorf("no sources, got %q, want %q", got, want)
+	}
+}
+
+func TestWindowsACLBlockRanges(t *testing.T) {
+	tests := []struct {
+		sources []string
+		want    []string
+	}{
+		{nil, []string{"0.0.0.0-255.255.255.255"}},
+		{[]string{"10.100.0.0/16"}, []string{"0.0.0.0-10.99.255.255", "10.101.0.0-255.255.255.255"}},
+		{aclSources, []string{
+			"0.0.0.0-10.99.255.255",
+			"10.101.0.0-10.200.2.255",
+			"10.200.4.0-172.15.255.255",
+			"172.32.0.0-192.168.1.6",
+			"192.168.1.8-255.255.255.255",
+		}},
+		{[]string{"0.0.0.0/1", "128.0.0.0/1"}, nil},
+		{[]string{"0.0.0.0/0"}, nil},
+		{[]string{"255.255.255.255"}, []string{"0.0.0.0-255.255.255.254"}},
+		{[]string{"0.0.0.0/8"}, []string{"1.0.0.0-255.255.255.255"}},
+		{[]string{"fd00::/64"}, []string{"0.0.0.0-255.255.255.255"}},
+	}
+	for _, tt := range tests {
+		if got := windowsACLBlockRanges(tt.sources); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("windowsACLBlockRanges(%v):\n got %q\nwant %q", tt.sources, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ApplyACL lets only the given source prefixes, and replies to connections
+// this node opened, in on the interface. Calling it again replaces the
+// previous set. nftables is used when available, otherwise iptables, on
+// Linux; a pf anchor on macOS. localIP is used on Windows only.
+func ApplyACL(iface, localIP string, sources []string) (*ACLRules, error) {
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := exec.LookPath("nft"); err == nil {
+			cmd := exec.Command("nft", "-f", "-")
+			cmd.Stdin = strings.NewReader(nftACLScript(iface, sources))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to load nft ACL: %w, output: %s", err, string(output))
+			}
+			return &ACLRules{Interface: iface, Tool: FirewallNft}, nil
+		}
+		if err := applyIptablesACL("iptables", iface, sources, false); err != nil {
+			return nil, err
+		}
+		if _, err := exec.LookPath("ip6tables"); err == nil {
+			if err := applyIptablesACL("ip6tables", iface, sources, true); err != nil {
+				return nil, err
+			}
+		}
+		return &ACLRules{Interface: iface, Tool: FirewallIptables}, nil
+	case "darwin":
+		cmd := exec.Command("pfctl", "-a", pfAnchor(aclName(iface)), "-f", "-")
+		cmd.Stdin = strings.NewReader(pfACLRules(iface, sources))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to load pf ACL anchor: %w, output: %s", err, string(output))
+		}
+		return &ACLRules{Interface: iface, Tool: FirewallPF}, nil
+	default:
+		return nil, fmt.Errorf("ACL enforcement is not supported on %s", runtime.GOOS)
+	}
+}
+
+// RemoveACL takes out the rules installed by ApplyACL
+func RemoveACL(rules ACLRules) error {
+	name := aclName(rules.Interface)
+
+	var output []byte
+	var err error
+	switch rules.Tool {
+	case FirewallNft:
+		output, err = exec.Command("nft", "delete", "table", "inet", name).CombinedOutput()
+	case FirewallIptables:
+		if _, lookErr := exec.LookPath("ip6tables"); lookErr == nil {
+			removeIptablesACL("ip6tables", rules.Interface)
+		}
+		output, err = removeIptablesACL("iptables", rules.Interface)
+	case FirewallPF:
+		output, err = exec.Command("pfctl", "-a", pfAnchor(name), "-F", "rules").CombinedOutput()
+	default:
+		return fmt.Errorf("unknown firewall tool %q", rules.Tool)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove ACL of %s: %w, output: %s", rules.Interface, err, string(output))
+	}
+	return nil
+}
+
+// applyIptablesACL fills the ACL chain of iface and jumps to it from INPUT
+// for traffic arriving on the interface
+func applyIptablesACL(tool, iface string, sources []string, ipv6 bool) error {
+	chain := aclName(iface)
+
+	// Creating fails harmlessly when the chain exists; flushing empties it
+	_ = exec.Command(tool, "-N", chain).Run()
+	if output, err := exec.Command(tool, "-F", chain).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to flush %s chain: %w, output: %s", tool, err, string(output))
+	}
+	for _, rule := range iptablesACLRules(chain, sources, ipv6) {
+		if output, err := exec.Command(tool, rule...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add %s ACL rule: %w, output: %s", tool, err, string(output))
+		}
+	}
+
+	jump := []string{"INPUT", "-i", iface, "-j", chain}
+	if exec.Command(tool, append([]string{"-C"}, jump...)...).Run() != nil {
+		if output, err := exec.Command(tool, append([]string{"-I"}, jump...)...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add %s ACL jump: %w, output: %s", tool, err, string(output))
+		}
+	}
+	return nil
+}
+
+// removeIptablesACL deletes the jump to the ACL chain of iface, then the
+// chain itself
+func removeIptablesACL(tool, iface string) ([]byte, error) {
+	chain := aclName(iface)
+	_ = exec.Command(tool, "-D", "INPUT", "-i", iface, "-j", chain).Run()
+	_ = exec.Command(tool, "-F", chain).Run()
+	return exec.Command(tool, "-X", chain).CombinedOutput()
+}
@@ -0,0 +1,48 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ApplyACL blocks inbound traffic to localIP, the interface address, from
+// every IPv4 address outside sources. Replies to connections this node
+// opened are not affected by inbound rules. Calling it again replaces the
+// previous rule.
+func ApplyACL(iface, localIP string, sources []string) (*ACLRules, error) {
+	if localIP == "" {
+		return nil, fmt.Errorf("interface %s has no address to scope the ACL to", iface)
+	}
+
+	name := aclName(iface)
+	_ = exec.Command("netsh", "advfirewall", "firewall", "delete", "rule", "name="+name).Run()
+
+	remote := "any"
+	if ranges := windowsACLBlockRanges(sources); len(ranges) != 1 || ranges[0] != "0.0.0.0-255.255.255.255" {
+		if len(ranges) == 0 {
+			// Every address is allowed; nothing to block
+			return &ACLRules{Interface: iface, Tool: FirewallNetsh}, nil
+		}
+		remote = strings.Join(ranges, ",")
+	}
+
+	cmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule",
+		"name="+name, "dir=in", "action=block", "localip="+localIP, "remoteip="+remote)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to add ACL rule: %w, output: %s", err, string(output))
+	}
+	return &ACLRules{Interface: iface, Tool: FirewallNetsh}, nil
+}
+
+// RemoveACL deletes the rule installed by ApplyACL. A missing rule is not
+// an error.
+func RemoveACL(rules ACLRules) error {
+	cmd := exec.Command("netsh", "advfirewall", "firewall", "delete", "rule", "name="+aclName(rules.Interface))
+	if output, err := cmd.CombinedOutput(); err != nil && !strings.Contains(string(output), "No rules match") {
+		return fmt.Errorf("failed to remove ACL of %s: %w, output: %s", rules.Interface, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package wireguard
+
+import "golang.zx2c4.com/wireguard/device"
+
+// DefaultMTU is the MTU of the interfaces the client creates, wireguard-go's
+// default, which the kernel module uses too
+const DefaultMTU = device.DefaultMTU
+
+// Backend abstracts the WireGuard device operations needed by the client so
+// that alternative implementations (mobile platforms, tests) can be plugged in
+// in place of the OS-level Interface.
+type Backend interface {
+	Create() error
+	Configure() error
+	AddPeer(peer PeerConfig) error
+	// AddPeers applies many peers at once; a partial failure is reported
+	// as a *PeerBatchError naming the peers that were not applied
+	AddPeers(peers []PeerConfig) error
+	RemovePeer(publicKey string) error
+	// UpdatePeerEndpoint changes only the endpoint of a configured peer
+	UpdatePeerEndpoint(publicKey, endpoint string) error
+	Destroy() error
+	GetStats() (map[string]interface{}, error)
+
+	// Check verifies that the device still exists and carries the
+	// configured private key and listen port
+	Check() error
+
+	// Close releases the handles used to control the device, leaving the
+	// device itself alone. It is safe to call more than once, and after
+	// Destroy.
+	Close() error
+}
+
+var _ Backend = (*Interface)(nil)
+
+// Windows drivers selectable through Config.WindowsDriver
+const (
+	DriverAuto        = ""
+	DriverWireGuardNT = "wireguard-nt"
+	DriverUserspace   = "userspace"
+)
+
+// Kinds of WireGuard implementation behind an interface, as reported by Kind
+const (
+	KindKernel    = "kernel"     // Kernel module or driver (Linux, FreeBSD if_wg, WireGuardNT)
+	KindUserspace = "userspace"  // External wireguard-go process
+	KindInProcess = "in-process" // wireguard-go running inside the client
+	KindNetstack  = "netstack"   // wireguard-go on a userspace TCP/IP stack, see Netstack
+)
+
+// BackendFactory creates a Backend for the given interface configuration
+type BackendFactory func(config Config) (Backend, error)
+
+// NewBackend is the default BackendFactory, backed by the OS interface
+func NewBackend(config Config) (Backend, error) {
+	iface, err := NewInterface(config)
+	if err != nil {
+		return nil, err
+	}
+	return iface, nil
+}
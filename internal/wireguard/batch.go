@@ -0,0 +1,90 @@
+package wireguard
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultPeerBatchSize is the number of peers AddPeers programs per
+// ConfigureDevice call. A peer with a handful of allowed IPs takes a few
+// hundred bytes of netlink attributes, so this stays well under the message
+// size limit.
+const DefaultPeerBatchSize = 100
+
+// PeerBatchError reports the peers an AddPeers call could not apply. Every
+// other peer was applied.
+type PeerBatchError struct {
+	Total  int
+	Failed map[string]error // Keyed by public key
+}
+
+func (e *PeerBatchError) Error() string {
+	// Peers in the same chunk share an error; report each one once
+	reasons := make([]string, 0, len(e.Failed))
+	seen := make(map[string]bool, len(e.Failed))
+	for _, err := range e.Failed {
+		if reason := err.Error(); !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("failed to add %d of %d peers: %s", len(e.Failed), e.Total, strings.Join(reasons, "; "))
+}
+
+// AddPeers adds or updates peers with one ConfigureDevice call per chunk of
+// the configured batch size, instead of one call per peer. A peer whose
+// configuration is invalid, or whose chunk the device rejects, is reported
+// in a *PeerBatchError; the rest are still applied.
+func (i *Interface) AddPeers(peers []PeerConfig) error {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	failed := make(map[string]error)
+	configs := make([]wgtypes.PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		peerConfig, err := buildPeerConfig(peer)
+		if err != nil {
+			failed[peer.PublicKey] = err
+			continue
+		}
+		configs = append(configs, peerConfig)
+	}
+
+	if len(configs) > 0 {
+		client, err := i.controller()
+		if err != nil {
+			return err
+		}
+
+		size := i.peerBatchSize
+		if size <= 0 {
+			size = DefaultPeerBatchSize
+		}
+		chunks := (len(configs) + size - 1) / size
+		for n := 0; n < chunks; n++ {
+			start := n * size
+			end := start + size
+			if end > len(configs) {
+				end = len(configs)
+			}
+
+			chunk := configs[start:end]
+			if err := client.ConfigureDevice(i.Name, wgtypes.Config{Peers: chunk}); err != nil {
+				err = fmt.Errorf("chunk %d/%d (%d peers): %w", n+1, chunks, len(chunk), err)
+				for _, peerConfig := range chunk {
+					failed[peerConfig.PublicKey.String()] = err
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PeerBatchError{Total: len(peers), Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// benchPeers returns n valid peer configurations
+func benchPeers(b *testing.B, n int) []PeerConfig {
+	b.Helper()
+
+	peers := make([]PeerConfig, n)
+	for i := range peers {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			b.Fatal(err)
+		}
+		peers[i] = PeerConfig{
+			PublicKey:  key.PublicKey().String(),
+			Endpoint:   fmt.Sprintf("192.0.2.%d:51820", i%250+1),
+			AllowedIPs: []string{fmt.Sprintf("10.100.%d.%d/32", i/250, i%250+1), fmt.Sprintf("192.168.%d.0/24", i%250)},
+			KeepAlive:  DefaultKeepAlive,
+		}
+	}
+	return peers
+}
+
+func BenchmarkBuildPeerConfig(b *testing.B) {
+	peers := benchPeers(b, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := buildPeerConfig(peers[i%len(peers)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAddPeers programs peers on a device that does not exist, so that
+// it runs without root: each chunk costs one ConfigureDevice round trip to
+// the kernel or the userspace socket, which is what batching saves. The
+// calls metric is the number of those round trips per AddPeers.
+func BenchmarkAddPeers(b *testing.B) {
+	const peers = 1000
+	configs := benchPeers(b, peers)
+
+	for _, size := range []int{1, 10, DefaultPeerBatchSize, peers} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			iface := &Interface{Name: "wgbench-absent", peerBatchSize: size}
+			if _, err := iface.controller(); err != nil {
+				b.Skipf("no wgctrl client: %v", err)
+			}
+			b.Cleanup(func() { iface.Close() })
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var batchErr *PeerBatchError
+				if err := iface.AddPeers(configs); !errors.As(err, &batchErr) || len(batchErr.Failed) != peers {
+					b.Fatalf("AddPeers on a missing device: %v", err)
+				}
+			}
+			b.ReportMetric(float64((peers+size-1)/size), "calls/op")
+		})
+	}
+}
+
+func BenchmarkPeerBatchError(b *testing.B) {
+	// Chunks of a hundred peers sharing an error each
+	err := &PeerBatchError{Total: 1000, Failed: make(map[string]error, 1000)}
+	for i := 0; i < 1000; i++ {
+		err.Failed[fmt.Sprintf("peer-%d", i)] = fmt.Errorf("chunk %d/10 (100 peers): device busy", i/100+1)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = err.Error()
+	}
+}
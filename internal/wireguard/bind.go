@@ -0,0 +1,105 @@
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// DefaultFirewallMark marks the packets of the WireGuard socket when
+// Config.BindInterface is set without a Config.FirewallMark. As with
+// wg-quick, the mark doubles as the number of the routing table marked
+// packets are looked up in.
+const DefaultFirewallMark = 51820
+
+// bindMark returns the mark the socket's packets carry: the configured one,
+// or DefaultFirewallMark when they must be steered to an interface
+func bindMark(mark int, bindInterface string) int {
+	if mark == 0 && bindInterface != "" {
+		return DefaultFirewallMark
+	}
+	return mark
+}
+
+// bindRuleCommands returns the ip commands that send packets carrying mark
+// out of iface: a routing table of that number holding only a default route
+// through the interface, via its gateway when it has one, and a rule looking
+// marked packets up in it. IPv6 is steered only when the interface has an
+// IPv6 gateway.
+func bindRuleCommands(iface string, mark int, gateway4, gateway6 string) [][]string {
+	table := strconv.Itoa(mark)
+	route := func(family, gateway string) []string {
+		args := []string{"ip", family, "route", "replace", "default"}
+		if gateway != "" {
+			args = append(args, "via", gateway)
+		}
+		return append(args, "dev", iface, "table", table)
+	}
+	rule := func(family string) []string {
+		return []string{"ip", family, "rule", "add", "fwmark", table, "lookup", table}
+	}
+
+	commands := [][]string{route("-4", gateway4), rule("-4")}
+	if gateway6 != "" {
+		commands = append(commands, route("-6", gateway6), rule("-6"))
+	}
+	return commands
+}
+
+// unbindRuleCommands returns the ip commands that undo bindRuleCommands
+func unbindRuleCommands(mark int) [][]string {
+	table := strconv.Itoa(mark)
+	var commands [][]string
+	for _, family := range []string{"-4", "-6"} {
+		commands = append(commands,
+			[]string{"ip", family, "rule", "del", "fwmark", table, "lookup", table},
+			[]string{"ip", family, "route", "flush", "table", table})
+	}
+	return commands
+}
+
+// defaultGateway returns the gateway of the default route through iface for
+// an address family ("-4" or "-6"), or "" when there is none
+func defaultGateway(family, iface string) string {
+	output, err := exec.Command("ip", family, "route", "show", "default", "dev", iface).Output()
+	if err != nil {
+		return ""
+	}
+	return parseGateway(string(output))
+}
+
+// parseGateway returns the address after "via" in the first line of ip
+// route output
+func parseGateway(output string) string {
+	line, _, _ := strings.Cut(output, "\n")
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "via" {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// bindLinux steers the socket's marked packets out of iface with policy
+// routing. Rules left by a previous run are removed first so they are not
+// duplicated.
+func bindLinux(iface string, mark int) error {
+	unbindLinux(mark)
+	for _, args := range bindRuleCommands(iface, mark, defaultGateway("-4", iface), defaultGateway("-6", iface)) {
+		if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			unbindLinux(mark)
+			return fmt.Errorf("failed to bind to %s: %s: %w, output: %s", iface, strings.Join(args, " "), err, string(output))
+		}
+	}
+	return nil
+}
+
+// unbindLinux removes the rules and routes added by bindLinux. Missing ones
+// are not an error.
+func unbindLinux(mark int) {
+	for _, args := range unbindRuleCommands(mark) {
+		_ = exec.Command(args[0], args[1:]...).Run()
+	}
+}
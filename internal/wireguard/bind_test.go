@@ -0,0 +1,119 @@
+package wireguard
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBindMark(t *testing.T) {
+	tests := []struct {
+		mark  int
+		iface string
+		want  int
+	}{
+		{0, "", 0},
+		{0, "eth1", DefaultFirewallMark},
+		{100, "", 100},
+		{100, "eth1", 100},
+	}
+	for _, tt := range tests {
+		if got := bindMark(tt.mark, tt.iface); got != tt.want {
+			t.Errorf("bindMark(%d, %q) = %d, want %d", tt.mark, tt.iface, got, tt.want)
+		}
+	}
+}
+
+// joinCommands renders commands one per line, as they would be typed
+func joinCommands(commands [][]string) string {
+	lines := make([]string, len(commands))
+	for i, args := range commands {
+		lines[i] = strings.Join(args, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestBindRuleCommands(t *testing.T) {
+	tests := []struct {
+		name               string
+		gateway4, gateway6 string
+		want               []string
+	}{
+		{
+			name:     "IPv4 gateway",
+			gateway4: "192.0.2.1",
+			want: []string{
+				"ip -4 route replace default via 192.0.2.1 dev eth1 table 51820",
+				"ip -4 rule add fwmark 51820 lookup 51820",
+			},
+		},
+		{
+			// A point-to-point link has no gateway
+			name: "no gateway",
+			want: []string{
+				"ip -4 route replace default dev eth1 table 51820",
+				"ip -4 rule add fwmark 51820 lookup 51820",
+			},
+		},
+		{
+			name:     "dual stack",
+			gateway4: "192.0.2.1",
+			gateway6: "fe80::1",
+			want: []string{
+				"ip -4 route replace default via 192.0.2.1 dev eth1 table 51820",
+				"ip -4 rule add fwmark 51820 lookup 51820",
+				"ip -6 route replace default via fe80::1 dev eth1 table 51820",
+				"ip -6 rule add fwmark 51820 lookup 51820",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := joinCommands(bindRuleCommands("eth1", DefaultFirewallMark, tt.gateway4, tt.gateway6))
+			if want := strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("commands:\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestUnbindRuleCommands(t *testing.T) {
+	want := []string{
+		"ip -4 rule del fwmark 100 lookup 100",
+		"ip -4 route flush table 100",
+		"ip -6 rule del fwmark 100 lookup 100",
+		"ip -6 route flush table 100",
+	}
+	if got := joinCommands(unbindRuleCommands(100)); got != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestParseGateway(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"default via 192.0.2.1 proto dhcp src 192.0.2.10 metric 100 \n", "192.0.2.1"},
+		{"default via fe80::1 proto ra metric 1024 pref medium\n", "fe80::1"},
+		{"default via 192.0.2.1 metric 100\ndefault via 198.51.100.1 metric 200\n", "192.0.2.1"},
+		{"default scope link \n", ""},
+		{"", ""},
+		{"default via", ""},
+	}
+	for _, tt := range tests {
+		if got := parseGateway(tt.output); got != tt.want {
+			t.Errorf("parseGateway(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestRecorderReportsBindMark(t *testing.T) {
+	r := NewRecorder(Config{InterfaceName: "wg0", ListenPort: 51820, BindInterface: "eth1"})
+	if err := r.Configure(); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	ops := r.Operations()
+	if len(ops) != 1 || ops[0].Op != OpConfigure || ops[0].FirewallMark != DefaultFirewallMark {
+		t.Errorf("operations: got %+v, want one %s with mark %d", ops, OpConfigure, DefaultFirewallMark)
+	}
+}
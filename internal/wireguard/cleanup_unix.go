@@ -0,0 +1,96 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DestroyInterface removes an interface left behind by a previous run. It is
+// not an error if the interface no longer exists.
+func DestroyInterface(name string) error {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return nil
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		cmd := exec.Command("ip", "link", "del", "dev", name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to destroy interface: %w, output: %s", err, string(output))
+		}
+	case "darwin":
+		// An in-process utun device dies with its process; one run by an
+		// external wireguard-go disappears when that process is killed
+		cmd := exec.Command("pkill", "-f", "wireguard-go (-f )?"+name+"$")
+		_ = cmd.Run()
+	case "freebsd":
+		cmd := exec.Command("ifconfig", name, "destroy")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to destroy interface: %w, output: %s", err, string(output))
+		}
+	}
+
+	return nil
+}
+
+// StopProcess kills a helper process left behind by a previous run, after
+// checking that the PID still belongs to wireguard-go rather than an
+// unrelated process that reused it
+func StopProcess(pid int) error {
+	output, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "command=").Output()
+	if err != nil {
+		// Process no longer exists
+		return nil
+	}
+	if !strings.Contains(string(output), "wireguard-go") {
+		return nil
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return nil
+	}
+	if err := process.Kill(); err != nil {
+		return fmt.Errorf("failed to kill process %d: %w", pid, err)
+	}
+
+	return nil
+}
+
+// RemoveRoute deletes a route that was added through the given interface
+func RemoveRoute(route, iface string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.Command("ip", "route", "del", route, "dev", iface)
+	case "darwin", "freebsd":
+		cmd = exec.Command("route", "-n", "delete", "-net", route, "-interface", iface)
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove route %s: %w, output: %s", route, err, string(output))
+	}
+	return nil
+}
+
+// RevertDNS reverts DNS settings that were applied to the given interface
+func RevertDNS(iface string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	cmd := exec.Command("resolvectl", "revert", iface)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to revert DNS: %w, output: %s", err, string(output))
+	}
+	return nil
+}
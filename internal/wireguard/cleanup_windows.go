@@ -0,0 +1,33 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// DestroyInterface removes an interface left behind by a previous run. On
+// Windows the device lives inside the client process, so nothing survives it.
+func DestroyInterface(name string) error {
+	return nil
+}
+
+// StopProcess is a no-op on Windows where no helper processes are spawned
+func StopProcess(pid int) error {
+	return nil
+}
+
+// RemoveRoute deletes a route that was added through the interface
+func RemoveRoute(route, iface string) error {
+	cmd := exec.Command("route", "delete", route)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove route %s: %w, output: %s", route, err, string(output))
+	}
+	return nil
+}
+
+// RevertDNS is a no-op on Windows; adapter DNS settings vanish with the adapter
+func RevertDNS(iface string) error {
+	return nil
+}
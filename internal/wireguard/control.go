@@ -0,0 +1,39 @@
+package wireguard
+
+import (
+	"fmt"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+// controller returns the wgctrl client that talks to the device, opening it
+// on first use. Keeping it closed until then means an interface that is
+// never created holds no handle.
+func (i *Interface) controller() (*wgctrl.Client, error) {
+	i.clientMu.Lock()
+	defer i.clientMu.Unlock()
+
+	if i.client == nil {
+		client, err := wgctrl.New()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create wgctrl client: %w", err)
+		}
+		i.client = client
+	}
+	return i.client, nil
+}
+
+// Close releases the wgctrl client without touching the device. It is
+// safe to call more than once, and a later call that needs the client
+// opens a new one.
+func (i *Interface) Close() error {
+	i.clientMu.Lock()
+	defer i.clientMu.Unlock()
+
+	if i.client == nil {
+		return nil
+	}
+	err := i.client.Close()
+	i.client = nil
+	return err
+}
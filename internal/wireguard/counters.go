@@ -0,0 +1,18 @@
+package wireguard
+
+// TrafficCounters are the packet counters of the network interface itself.
+// They count packets crossing the interface, not WireGuard's own keepalives
+// and handshakes, so they only move when something uses the mesh.
+type TrafficCounters struct {
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	// Packets routed to the interface but not sent, e.g. because no peer
+	// has matching AllowedIPs
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// Total returns the number of packets that reached the interface in either
+// direction, sent or not
+func (c TrafficCounters) Total() uint64 {
+	return c.RxPackets + c.TxPackets + c.TxDropped
+}
@@ -0,0 +1,99 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ReadTrafficCounters returns the packet counters of the named interface:
+// from sysfs on Linux, where the kernel module counts packets without a
+// matching peer as tx errors, and from netstat elsewhere
+func ReadTrafficCounters(name string) (TrafficCounters, error) {
+	if runtime.GOOS == "linux" {
+		return readSysfsCounters(name)
+	}
+	return readNetstatCounters(name)
+}
+
+func readSysfsCounters(name string) (TrafficCounters, error) {
+	dir := filepath.Join("/sys/class/net", name, "statistics")
+	read := func(counter string) (uint64, error) {
+		data, err := os.ReadFile(filepath.Join(dir, counter))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	var counters TrafficCounters
+	var errs, dropped uint64
+	var err error
+	for _, field := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"rx_packets", &counters.RxPackets},
+		{"tx_packets", &counters.TxPackets},
+		{"tx_errors", &errs},
+		{"tx_dropped", &dropped},
+	} {
+		if *field.value, err = read(field.name); err != nil {
+			return TrafficCounters{}, fmt.Errorf("failed to read %s of %s: %w", field.name, name, err)
+		}
+	}
+	counters.TxDropped = errs + dropped
+	return counters, nil
+}
+
+// readNetstatCounters parses "netstat -I name -n". The columns differ
+// between macOS and FreeBSD and the address column may be empty, but the
+// counters are always the last columns, so they are located from the right.
+func readNetstatCounters(name string) (TrafficCounters, error) {
+	output, err := exec.Command("netstat", "-I", name, "-n").Output()
+	if err != nil {
+		return TrafficCounters{}, fmt.Errorf("netstat failed: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) < 2 {
+		return TrafficCounters{}, fmt.Errorf("no counters for %s", name)
+	}
+	header := strings.Fields(lines[0])
+	fromEnd := func(column string) int {
+		for i, field := range header {
+			if field == column {
+				return len(header) - i
+			}
+		}
+		return 0
+	}
+
+	// The link-level row counts every packet of the interface
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < len(header)-1 || fields[0] != name || !strings.HasPrefix(fields[2], "<Link") {
+			continue
+		}
+		value := func(column string) uint64 {
+			offset := fromEnd(column)
+			if offset == 0 || offset > len(fields) {
+				return 0
+			}
+			n, _ := strconv.ParseUint(fields[len(fields)-offset], 10, 64)
+			return n
+		}
+		return TrafficCounters{
+			RxPackets: value("Ipkts"),
+			TxPackets: value("Opkts"),
+			TxDropped: value("Oerrs"),
+		}, nil
+	}
+	return TrafficCounters{}, fmt.Errorf("no counters for %s", name)
+}
@@ -0,0 +1,29 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// ReadTrafficCounters returns the packet counters of the named interface
+// from the IP Helper interface table
+func ReadTrafficCounters(name string) (TrafficCounters, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return TrafficCounters{}, err
+	}
+
+	row := windows.MibIfRow2{InterfaceIndex: uint32(iface.Index)}
+	if err := windows.GetIfEntry2Ex(windows.MibIfEntryNormal, &row); err != nil {
+		return TrafficCounters{}, fmt.Errorf("failed to read counters of %s: %w", name, err)
+	}
+	return TrafficCounters{
+		RxPackets: row.InUcastPkts + row.InNUcastPkts,
+		TxPackets: row.OutUcastPkts + row.OutNUcastPkts,
+		TxDropped: row.OutDiscards + row.OutErrors,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package wireguard
+
+import "fmt"
+
+// Firewall tools that OpenFirewallPort may use
+const (
+	FirewallNetsh    = "netsh"
+	FirewallUFW      = "ufw"
+	FirewallNft      = "nft"
+	FirewallIptables = "iptables"
+	FirewallPF       = "pf"
+)
+
+// FirewallRule identifies an inbound allow rule added by OpenFirewallPort,
+// with what CloseFirewallPort needs to remove exactly that rule
+type FirewallRule struct {
+	Name string `json:"name"` // Unique per interface, see FirewallRuleName
+	Port int    `json:"port"`
+	Tool string `json:"tool"` // FirewallNetsh, FirewallUFW, ...
+}
+
+// FirewallRuleName names the rule for an interface and port. Profiles run
+// with different interfaces, so their rules never collide.
+func FirewallRuleName(iface string, port int) string {
+	return fmt.Sprintf("wgmesh-%s-%d", iface, port)
+}
@@ -0,0 +1,146 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// OpenFirewallPort adds an inbound allow rule for UDP to port with the
+// firewall in use: ufw when it is active, otherwise the nftables inet filter
+// input chain, otherwise iptables on Linux, and a pf anchor on macOS when pf
+// is enabled. It returns nil without error when no firewall is filtering,
+// or when an identical rule already exists, since then there is nothing of
+// ours to remove later.
+func OpenFirewallPort(name string, port int) (*FirewallRule, error) {
+	rule := &FirewallRule{Name: name, Port: port}
+
+	switch runtime.GOOS {
+	case "linux":
+		switch {
+		case ufwActive():
+			rule.Tool = FirewallUFW
+			output, err := exec.Command("ufw", "allow", strconv.Itoa(port)+"/udp", "comment", name).CombinedOutput()
+			if err != nil {
+				return nil, fmt.Errorf("failed to add ufw rule: %w, output: %s", err, string(output))
+			}
+			if strings.Contains(string(output), "Skipping") {
+				// The rule predates us and is not ours to remove
+				return nil, nil
+			}
+		case nftInputChain():
+			rule.Tool = FirewallNft
+			cmd := exec.Command("nft", "insert", "rule", "inet", "filter", "input",
+				"udp", "dport", strconv.Itoa(port), "accept", "comment", strconv.Quote(name))
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to add nft rule: %w, output: %s", err, string(output))
+			}
+		default:
+			if _, err := exec.LookPath("iptables"); err != nil {
+				return nil, nil
+			}
+			rule.Tool = FirewallIptables
+			if output, err := exec.Command("iptables", iptablesRule("-I", rule)...).CombinedOutput(); err != nil {
+				return nil, fmt.Errorf("failed to add iptables rule: %w, output: %s", err, string(output))
+			}
+			// IPv6 endpoints need the same; not every host has ip6tables
+			_ = exec.Command("ip6tables", iptablesRule("-I", rule)...).Run()
+		}
+	case "darwin":
+		output, err := exec.Command("pfctl", "-s", "info").CombinedOutput()
+		if err != nil || !strings.Contains(string(output), "Status: Enabled") {
+			return nil, nil
+		}
+		rule.Tool = FirewallPF
+		cmd := exec.Command("pfctl", "-a", pfAnchor(name), "-f", "-")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("pass in quick proto udp from any to any port %d\n", port))
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to load pf anchor: %w, output: %s", err, string(output))
+		}
+	default:
+		return nil, fmt.Errorf("firewall management is not supported on %s", runtime.GOOS)
+	}
+
+	return rule, nil
+}
+
+// CloseFirewallPort removes a rule added by OpenFirewallPort
+func CloseFirewallPort(rule FirewallRule) error {
+	var output []byte
+	var err error
+	switch rule.Tool {
+	case FirewallUFW:
+		output, err = exec.Command("ufw", "delete", "allow", strconv.Itoa(rule.Port)+"/udp").CombinedOutput()
+	case FirewallNft:
+		var handle string
+		handle, err = nftRuleHandle(rule.Name)
+		if err != nil || handle == "" {
+			return err
+		}
+		output, err = exec.Command("nft", "delete", "rule", "inet", "filter", "input", "handle", handle).CombinedOutput()
+	case FirewallIptables:
+		_ = exec.Command("ip6tables", iptablesRule("-D", &rule)...).Run()
+		output, err = exec.Command("iptables", iptablesRule("-D", &rule)...).CombinedOutput()
+	case FirewallPF:
+		output, err = exec.Command("pfctl", "-a", pfAnchor(rule.Name), "-F", "rules").CombinedOutput()
+	default:
+		return fmt.Errorf("unknown firewall tool %q", rule.Tool)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove firewall rule %s: %w, output: %s", rule.Name, err, string(output))
+	}
+	return nil
+}
+
+// ufwActive reports whether ufw is installed and enabled
+func ufwActive() bool {
+	output, err := exec.Command("ufw", "status").Output()
+	return err == nil && strings.HasPrefix(string(output), "Status: active")
+}
+
+// nftInputChain reports whether nftables has the conventional inet filter
+// input chain. An accept in a table of our own would not override a drop
+// there, so the rule has to go into that chain.
+func nftInputChain() bool {
+	return exec.Command("nft", "list", "chain", "inet", "filter", "input").Run() == nil
+}
+
+// nftHandlePattern finds the handle nft -a prints after a rule
+var nftHandlePattern = regexp.MustCompile(`# handle (\d+)`)
+
+// nftRuleHandle finds the handle of the rule commented with name; an empty
+// handle means the rule is already gone
+func nftRuleHandle(name string) (string, error) {
+	output, err := exec.Command("nft", "-a", "list", "chain", "inet", "filter", "input").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to list nft rules: %w, output: %s", err, string(output))
+	}
+	comment := "comment " + strconv.Quote(name)
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.Contains(line, comment) {
+			continue
+		}
+		if match := nftHandlePattern.FindStringSubmatch(line); match != nil {
+			return match[1], nil
+		}
+	}
+	return "", nil
+}
+
+// iptablesRule returns the arguments inserting (-I) or deleting (-D) the
+// rule; the comment keeps it apart from identical rules of other origin
+func iptablesRule(op string, rule *FirewallRule) []string {
+	return []string{op, "INPUT", "-p", "udp", "--dport", strconv.Itoa(rule.Port),
+		"-m", "comment", "--comment", rule.Name, "-j", "ACCEPT"}
+}
+
+// pfAnchor returns the anchor holding the rule. macOS loads com.apple/*
+// from its default pf.conf, so rules there take effect without editing it.
+func pfAnchor(name string) string {
+	return "com.apple/" + name
+}
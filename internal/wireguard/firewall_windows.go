@@ -0,0 +1,31 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// OpenFirewallPort adds a Windows Firewall rule allowing inbound UDP to
+// port. A rule of the same name left by a previous run is replaced.
+func OpenFirewallPort(name string, port int) (*FirewallRule, error) {
+	_ = exec.Command("netsh", "advfirewall", "firewall", "delete", "rule", "name="+name).Run()
+
+	cmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule",
+		"name="+name, "dir=in", "action=allow", "protocol=UDP", "localport="+strconv.Itoa(port))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to add firewall rule: %w, output: %s", err, string(output))
+	}
+	return &FirewallRule{Name: name, Port: port, Tool: FirewallNetsh}, nil
+}
+
+// CloseFirewallPort removes a rule added by OpenFirewallPort
+func CloseFirewallPort(rule FirewallRule) error {
+	cmd := exec.Command("netsh", "advfirewall", "firewall", "delete", "rule", "name="+rule.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove firewall rule %s: %w, output: %s", rule.Name, err, string(output))
+	}
+	return nil
+}
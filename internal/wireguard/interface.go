@@ -0,0 +1,373 @@
+// +build !windows
+
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+const (
+	DefaultInterfaceName = "wg0"
+	DefaultListenPort    = 51820
+
+	// DefaultKeepAlive is the persistent keepalive historically applied to
+	// every peer; a zero PeerConfig.KeepAlive disables keepalive
+	DefaultKeepAlive = 25 * time.Second
+)
+
+// Interface represents a WireGuard network interface
+type Interface struct {
+	Name       string
+	PrivateKey string
+	ListenPort int
+	Address    string
+	client     *wgctrl.Client // Opened on first use; see controller
+	clientMu   sync.Mutex
+
+	peerBatchSize int
+	firewallMark  int    // Effective mark, zero for none
+	bindInterface string // Interface marked packets are routed out of
+
+	// In-process userspace device and its UAPI socket (macOS)
+	device *device.Device
+	uapi   net.Listener
+
+	// External wireguard-go process spawned for the interface (macOS)
+	useWireGuardGo bool
+	wireguardGo    string
+	process        *os.Process
+	exited         <-chan error
+}
+
+// Config holds the configuration for a WireGuard interface
+type Config struct {
+	InterfaceName string
+	PrivateKey    string
+	ListenPort    int
+	Address       string
+
+	// UseSystemWireGuardGo runs the external wireguard-go binary instead of
+	// the in-process device (macOS)
+	UseSystemWireGuardGo bool
+	// WireGuardGoPath overrides the lookup of that binary
+	WireGuardGoPath string
+
+	// WindowsDriver selects the WireGuardNT kernel driver or the userspace
+	// device; the default tries WireGuardNT first (Windows)
+	WindowsDriver string
+
+	// MTU is set on the adapter unless zero, which leaves DefaultMTU
+	// (Windows)
+	MTU int
+	// DNSServers are set on the adapter as its resolvers (Windows)
+	DNSServers []string
+
+	// PeerBatchSize caps the peers applied per ConfigureDevice call by
+	// AddPeers; zero means DefaultPeerBatchSize
+	PeerBatchSize int
+
+	// FirewallMark marks the packets of the WireGuard socket (Linux)
+	FirewallMark int
+	// BindInterface sends the socket's packets out of this interface, with
+	// the mark and a policy routing rule; the mark defaults to
+	// DefaultFirewallMark (Linux)
+	BindInterface string
+}
+
+// PeerConfig represents the configuration for a WireGuard peer
+type PeerConfig struct {
+	PublicKey  string
+	Endpoint   string
+	AllowedIPs []string
+	KeepAlive  time.Duration // Persistent keepalive interval; zero disables it
+}
+
+// NewInterface creates a new WireGuard interface
+func NewInterface(config Config) (*Interface, error) {
+	if err := network.ValidateInterfaceName(config.InterfaceName); err != nil {
+		return nil, err
+	}
+	if err := network.ValidateAddress(config.Address); err != nil {
+		return nil, err
+	}
+
+	iface := &Interface{
+		Name:       config.InterfaceName,
+		PrivateKey: config.PrivateKey,
+		ListenPort: config.ListenPort,
+		Address:    config.Address,
+
+		peerBatchSize: config.PeerBatchSize,
+		firewallMark:  bindMark(config.FirewallMark, config.BindInterface),
+		bindInterface: config.BindInterface,
+
+		useWireGuardGo: config.UseSystemWireGuardGo,
+		wireguardGo:    config.WireGuardGoPath,
+	}
+
+	return iface, nil
+}
+
+// Create creates the WireGuard interface
+func (i *Interface) Create() error {
+	switch runtime.GOOS {
+	case "linux":
+		return i.createLinux()
+	case "darwin":
+		return i.createDarwin()
+	case "freebsd":
+		return i.createFreeBSD()
+	case "windows":
+		return i.createWindows()
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// Configure configures the WireGuard interface
+func (i *Interface) Configure() error {
+	privateKey, err := wgtypes.ParseKey(i.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+	defer crypto.Zero(privateKey[:])
+
+	port := i.ListenPort
+	config := wgtypes.Config{
+		PrivateKey: &privateKey,
+		ListenPort: &port,
+	}
+	// Marks and policy routing are Linux features; elsewhere the interface
+	// to bind to only decides which address is advertised
+	linux := runtime.GOOS == "linux"
+	if linux && i.firewallMark != 0 {
+		config.FirewallMark = &i.firewallMark
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to configure device: %w", err)
+	}
+
+	if linux && i.bindInterface != "" {
+		return bindLinux(i.bindInterface, i.firewallMark)
+	}
+	return nil
+}
+
+// AddPeer adds a peer to the WireGuard interface
+func (i *Interface) AddPeer(peer PeerConfig) error {
+	peerConfig, err := buildPeerConfig(peer)
+	if err != nil {
+		return err
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerConfig},
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to add peer: %w", err)
+	}
+
+	return nil
+}
+
+// RemovePeer removes a peer from the WireGuard interface
+func (i *Interface) RemovePeer(publicKey string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	peerConfig := wgtypes.PeerConfig{
+		PublicKey: key,
+		Remove:    true,
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerConfig},
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to remove peer: %w", err)
+	}
+
+	return nil
+}
+
+// Destroy destroys the WireGuard interface
+func (i *Interface) Destroy() error {
+	defer i.Close()
+
+	switch runtime.GOOS {
+	case "linux":
+		return i.destroyLinux()
+	case "darwin":
+		return i.destroyDarwin()
+	case "freebsd":
+		return i.destroyFreeBSD()
+	case "windows":
+		return i.destroyWindows()
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// SetAddress replaces the interface address without recreating the device,
+// so peers and their sessions are kept
+func (i *Interface) SetAddress(address string) error {
+	if err := network.ValidateAddress(address); err != nil {
+		return err
+	}
+
+	var err error
+	switch runtime.GOOS {
+	case "linux":
+		err = i.setAddressLinux(address)
+	case "darwin":
+		err = i.setAddressDarwin(address)
+	case "freebsd":
+		err = i.setAddressFreeBSD(address)
+	default:
+		err = fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+	if err != nil {
+		return err
+	}
+
+	i.Address = address
+	return nil
+}
+
+// Platform-specific implementations are in interface_unix.go and interface_windows.go
+
+// Check verifies that the device still exists and carries the configured
+// private key and listen port
+func (i *Interface) Check() error {
+	// A userspace device or process that died takes the interface with it
+	if i.device != nil {
+		select {
+		case <-i.device.Wait():
+			return fmt.Errorf("device %s was closed", i.Name)
+		default:
+		}
+	}
+	if i.process != nil {
+		select {
+		case err := <-i.exited:
+			i.process = nil
+			return fmt.Errorf("wireguard-go process for %s exited: %v", i.Name, err)
+		default:
+		}
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	device, err := client.Device(i.Name)
+	if err != nil {
+		return fmt.Errorf("device %s not found: %w", i.Name, err)
+	}
+
+	privateKey, err := wgtypes.ParseKey(i.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+	defer crypto.Zero(privateKey[:])
+	if !crypto.ConstantTimeEqual(device.PrivateKey[:], privateKey[:]) {
+		return fmt.Errorf("device %s has an unexpected private key", i.Name)
+	}
+	if i.ListenPort != 0 && device.ListenPort != i.ListenPort {
+		return fmt.Errorf("device %s listens on port %d, expected %d", i.Name, device.ListenPort, i.ListenPort)
+	}
+
+	return nil
+}
+
+// DeviceName returns the name of the created device, which on macOS is the
+// utun name picked by the kernel rather than the configured one
+func (i *Interface) DeviceName() string {
+	return i.Name
+}
+
+// Kind reports which WireGuard implementation runs the interface
+func (i *Interface) Kind() string {
+	switch {
+	case i.process != nil:
+		return KindUserspace
+	case i.device != nil:
+		return KindInProcess
+	default:
+		return KindKernel
+	}
+}
+
+// Processes returns the PIDs of helper processes spawned for the interface
+func (i *Interface) Processes() []int {
+	if i.process == nil {
+		return nil
+	}
+	return []int{i.process.Pid}
+}
+
+// GetStats returns statistics for the interface
+func (i *Interface) GetStats() (map[string]interface{}, error) {
+	client, err := i.controller()
+	if err != nil {
+		return nil, err
+	}
+	device, err := client.Device(i.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+
+	stats := map[string]interface{}{
+		"name":        device.Name,
+		"public_key":  device.PublicKey.String(),
+		"listen_port": device.ListenPort,
+		"num_peers":   len(device.Peers),
+		"peers":       []map[string]interface{}{},
+	}
+
+	peers := []map[string]interface{}{}
+	for _, peer := range device.Peers {
+		peerStats := map[string]interface{}{
+			"public_key":            peer.PublicKey.String(),
+			"endpoint":              peer.Endpoint,
+			"last_handshake":        peer.LastHandshakeTime,
+			"receive_bytes":         peer.ReceiveBytes,
+			"transmit_bytes":        peer.TransmitBytes,
+			"allowed_ips":           peer.AllowedIPs,
+			"persistent_keepalive":  peer.PersistentKeepaliveInterval,
+		}
+		peers = append(peers, peerStats)
+	}
+	stats["peers"] = peers
+
+	return stats, nil
+}
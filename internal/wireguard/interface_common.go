@@ -0,0 +1,253 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+const (
+	DefaultInterfaceName = "wg0"
+	DefaultListenPort    = 51820
+
+	// DefaultKeepAlive is the persistent keepalive historically applied to
+	// every peer; a zero PeerConfig.KeepAlive disables keepalive
+	DefaultKeepAlive = 25 * time.Second
+)
+
+// Interface represents a WireGuard network interface
+type Interface struct {
+	Name       string
+	PrivateKey string
+	ListenPort int
+	Address    string
+	client     *wgctrl.Client // Opened on first use; see controller
+	clientMu   sync.Mutex
+
+	peerBatchSize int
+
+	driver  string
+	adapter uintptr      // WireGuardNT adapter handle
+	uapi    net.Listener // UAPI pipe of the userspace device
+
+	mtu        int
+	dnsServers []string
+
+	adapterName string      // Name Windows knows the adapter by
+	setupSteps  []SetupStep // Outcome of the netsh steps of the last Create
+	mtuSet      bool        // The MTU was changed and is restored on Destroy
+	dnsSet      bool        // DNS servers were set and are removed on Destroy
+}
+
+// Config holds the configuration for a WireGuard interface
+type Config struct {
+	InterfaceName string
+	PrivateKey    string
+	ListenPort    int
+	Address       string
+
+	// UseSystemWireGuardGo runs the external wireguard-go binary instead of
+	// the in-process device (macOS)
+	UseSystemWireGuardGo bool
+	// WireGuardGoPath overrides the lookup of that binary
+	WireGuardGoPath string
+
+	// WindowsDriver selects the WireGuardNT kernel driver or the userspace
+	// device; the default tries WireGuardNT first (Windows)
+	WindowsDriver string
+
+	// MTU is set on the adapter unless zero, which leaves DefaultMTU
+	// (Windows)
+	MTU int
+	// DNSServers are set on the adapter as its resolvers (Windows)
+	DNSServers []string
+
+	// PeerBatchSize caps the peers applied per ConfigureDevice call by
+	// AddPeers; zero means DefaultPeerBatchSize
+	PeerBatchSize int
+
+	// FirewallMark marks the packets of the WireGuard socket (Linux)
+	FirewallMark int
+	// BindInterface sends the socket's packets out of this interface, with
+	// the mark and a policy routing rule; the mark defaults to
+	// DefaultFirewallMark (Linux)
+	BindInterface string
+}
+
+// PeerConfig represents the configuration for a WireGuard peer
+type PeerConfig struct {
+	PublicKey  string
+	Endpoint   string
+	AllowedIPs []string
+	KeepAlive  time.Duration // Persistent keepalive interval; zero disables it
+}
+
+// NewInterface creates a new WireGuard interface
+func NewInterface(config Config) (*Interface, error) {
+	if err := network.ValidateInterfaceName(config.InterfaceName); err != nil {
+		return nil, err
+	}
+	if err := network.ValidateAddress(config.Address); err != nil {
+		return nil, err
+	}
+
+	iface := &Interface{
+		Name:       config.InterfaceName,
+		PrivateKey: config.PrivateKey,
+		ListenPort: config.ListenPort,
+		Address:    config.Address,
+		driver:     config.WindowsDriver,
+
+		peerBatchSize: config.PeerBatchSize,
+
+		mtu:        config.MTU,
+		dnsServers: append([]string(nil), config.DNSServers...),
+	}
+
+	return iface, nil
+}
+
+// Create creates the WireGuard interface
+func (i *Interface) Create() error {
+	switch runtime.GOOS {
+	case "windows":
+		return i.createWindows()
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// Configure is implemented in configure_windows.go
+
+// AddPeer adds a peer to the WireGuard interface
+func (i *Interface) AddPeer(peer PeerConfig) error {
+	peerConfig, err := buildPeerConfig(peer)
+	if err != nil {
+		return err
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerConfig},
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to add peer: %w", err)
+	}
+
+	return nil
+}
+
+// RemovePeer removes a peer from the WireGuard interface
+func (i *Interface) RemovePeer(publicKey string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	peerConfig := wgtypes.PeerConfig{
+		PublicKey: key,
+		Remove:    true,
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{peerConfig},
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to remove peer: %w", err)
+	}
+
+	return nil
+}
+
+// Destroy destroys the WireGuard interface
+func (i *Interface) Destroy() error {
+	defer i.Close()
+
+	switch runtime.GOOS {
+	case "windows":
+		return i.destroyWindows()
+	default:
+		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
+	}
+}
+
+// SetAddress replaces the interface address without recreating the device,
+// so peers and their sessions are kept
+func (i *Interface) SetAddress(address string) error {
+	if err := network.ValidateAddress(address); err != nil {
+		return err
+	}
+
+	// A static netsh address replaces the existing one
+	ip := strings.Split(address, "/")[0]
+	cmd := exec.Command("netsh", "interface", "ip", "set", "address",
+		"name="+i.Name, "static", ip, netmask(address))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set IP address: %w, output: %s", err, string(output))
+	}
+
+	i.Address = address
+	return nil
+}
+
+// GetStats returns statistics for the interface
+func (i *Interface) GetStats() (map[string]interface{}, error) {
+	// wgctrl cannot always reach the in-process device; ask it directly
+	if wgDevice, ok := runningDevices[i.Name]; ok && i.adapter == 0 {
+		return ipcStats(i.Name, wgDevice)
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return nil, err
+	}
+	device, err := client.Device(i.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+
+	stats := map[string]interface{}{
+		"name":        device.Name,
+		"public_key":  device.PublicKey.String(),
+		"listen_port": device.ListenPort,
+		"num_peers":   len(device.Peers),
+		"peers":       []map[string]interface{}{},
+	}
+
+	peers := []map[string]interface{}{}
+	for _, peer := range device.Peers {
+		peerStats := map[string]interface{}{
+			"public_key":            peer.PublicKey.String(),
+			"endpoint":              peer.Endpoint,
+			"last_handshake":        peer.LastHandshakeTime,
+			"receive_bytes":         peer.ReceiveBytes,
+			"transmit_bytes":        peer.TransmitBytes,
+			"allowed_ips":           peer.AllowedIPs,
+			"persistent_keepalive":  peer.PersistentKeepaliveInterval,
+		}
+		peers = append(peers, peerStats)
+	}
+	stats["peers"] = peers
+
+	return stats, nil
+}
@@ -0,0 +1,358 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// darwinStartupTimeout bounds how long we wait for wireguard-go to create
+// the utun device
+const darwinStartupTimeout = 5 * time.Second
+
+// wireguardGoDirs are searched for wireguard-go after PATH, which is
+// minimal when running under launchd
+var wireguardGoDirs = []string{"/opt/homebrew/bin", "/usr/local/bin"}
+
+func (i *Interface) createLinux() error {
+	// Create interface using ip link
+	cmd := exec.Command("ip", "link", "add", "dev", i.Name, "type", "wireguard")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create interface: %w, output: %s", err, string(output))
+	}
+
+	// Set IP address
+	cmd = exec.Command("ip", "addr", "add", i.Address, "dev", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set IP address: %w, output: %s", err, string(output))
+	}
+
+	// Bring interface up
+	cmd = exec.Command("ip", "link", "set", "up", "dev", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to bring up interface: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (i *Interface) createDarwin() error {
+	// macOS has no kernel WireGuard, so run the userspace implementation
+	// in-process unless the system wireguard-go was requested
+	if i.useWireGuardGo {
+		if err := i.startWireGuardGo(); err != nil {
+			return err
+		}
+	} else if err := i.startDevice(); err != nil {
+		return err
+	}
+
+	// Set IP address
+	cmd := exec.Command("ifconfig", i.Name, "inet", i.Address, i.Address)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set IP address: %w, output: %s", err, string(output))
+	}
+
+	// Bring interface up
+	cmd = exec.Command("ifconfig", i.Name, "up")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to bring up interface: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (i *Interface) createFreeBSD() error {
+	// Prefer the kernel if_wg driver; ifconfig loads the module on demand
+	cmd := exec.Command("ifconfig", "wg", "create", "name", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Kernel WireGuard not available (%v, output: %s), using userspace device", err, strings.TrimSpace(string(output)))
+		if err := i.startDevice(); err != nil {
+			return err
+		}
+	}
+
+	// Set IP address; the userspace tun device is point-to-point
+	args := []string{i.Name, "inet", i.Address}
+	if i.device != nil {
+		args = append(args, strings.Split(i.Address, "/")[0])
+	}
+	cmd = exec.Command("ifconfig", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		i.destroyFreeBSD()
+		return fmt.Errorf("failed to set IP address: %w, output: %s", err, string(output))
+	}
+
+	// Bring interface up
+	cmd = exec.Command("ifconfig", i.Name, "up")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		i.destroyFreeBSD()
+		return fmt.Errorf("failed to bring up interface: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// setAddressLinux adds the new address before removing the old one so the
+// interface is never left without an address
+func (i *Interface) setAddressLinux(address string) error {
+	cmd := exec.Command("ip", "addr", "add", address, "dev", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add IP address: %w, output: %s", err, string(output))
+	}
+
+	cmd = exec.Command("ip", "addr", "del", i.Address, "dev", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove old IP address: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (i *Interface) setAddressDarwin(address string) error {
+	ip := strings.Split(address, "/")[0]
+	cmd := exec.Command("ifconfig", i.Name, "inet", address, ip, "alias")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add IP address: %w, output: %s", err, string(output))
+	}
+
+	cmd = exec.Command("ifconfig", i.Name, "inet", strings.Split(i.Address, "/")[0], "-alias")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove old IP address: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+func (i *Interface) setAddressFreeBSD(address string) error {
+	// The userspace tun device is point-to-point
+	args := []string{i.Name, "inet", address}
+	if i.device != nil {
+		args = append(args, strings.Split(address, "/")[0])
+	}
+	cmd := exec.Command("ifconfig", append(args, "alias")...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to add IP address: %w, output: %s", err, string(output))
+	}
+
+	cmd = exec.Command("ifconfig", i.Name, "inet", strings.Split(i.Address, "/")[0], "-alias")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to remove old IP address: %w, output: %s", err, string(output))
+	}
+
+	return nil
+}
+
+// startDevice creates a TUN device and runs WireGuard on it in-process. On
+// macOS the kernel picks the utun number, so i.Name is updated to the real
+// name. The standard UAPI socket is served so that wgctrl and wg(8) can
+// manage it.
+func (i *Interface) startDevice() error {
+	name := i.Name
+	if runtime.GOOS == "darwin" && !strings.HasPrefix(name, "utun") {
+		name = "utun"
+	}
+
+	tunDevice, err := tun.CreateTUN(name, DefaultMTU)
+	if err != nil {
+		return fmt.Errorf("failed to create TUN device: %w", err)
+	}
+
+	realName, err := tunDevice.Name()
+	if err != nil {
+		tunDevice.Close()
+		return fmt.Errorf("failed to get interface name: %w", err)
+	}
+
+	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("[%s] ", realName))
+	wgDevice := device.NewDevice(tunDevice, conn.NewDefaultBind(), logger)
+
+	if err := ipcConfigure(wgDevice, i.PrivateKey, i.ListenPort); err != nil {
+		wgDevice.Close()
+		return fmt.Errorf("failed to configure device: %w", err)
+	}
+
+	uapiFile, err := ipc.UAPIOpen(realName)
+	if err != nil {
+		wgDevice.Close()
+		return fmt.Errorf("failed to open UAPI socket: %w", err)
+	}
+	uapi, err := ipc.UAPIListen(realName, uapiFile)
+	if err != nil {
+		uapiFile.Close()
+		wgDevice.Close()
+		return fmt.Errorf("failed to listen on UAPI socket: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go wgDevice.IpcHandle(conn)
+		}
+	}()
+
+	if err := wgDevice.Up(); err != nil {
+		uapi.Close()
+		wgDevice.Close()
+		return fmt.Errorf("failed to bring up device: %w", err)
+	}
+
+	i.Name = realName
+	i.device = wgDevice
+	i.uapi = uapi
+
+	return nil
+}
+
+// startWireGuardGo runs the external wireguard-go binary. It is kept in the
+// foreground so that we own the process and know its PID.
+func (i *Interface) startWireGuardGo() error {
+	if _, err := net.InterfaceByName(i.Name); err != nil {
+		wireguardGo, err := findWireGuardGo(i.wireguardGo)
+		if err != nil {
+			return err
+		}
+
+		var output strings.Builder
+		cmd := exec.Command(wireguardGo, "-f", i.Name)
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start wireguard-go: %w", err)
+		}
+		i.process = cmd.Process
+
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+		i.exited = exited
+
+		deadline := time.Now().Add(darwinStartupTimeout)
+		for {
+			if _, err := net.InterfaceByName(i.Name); err == nil {
+				break
+			}
+			select {
+			case err := <-exited:
+				i.process = nil
+				return fmt.Errorf("wireguard-go exited: %v, output: %s", err, output.String())
+			case <-time.After(100 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timed out waiting for wireguard-go to create %s", i.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *Interface) createWindows() error {
+	// This should never be called on Unix systems
+	return fmt.Errorf("Windows-specific function called on Unix system")
+}
+
+func (i *Interface) destroyLinux() error {
+	if i.bindInterface != "" {
+		unbindLinux(i.firewallMark)
+	}
+
+	cmd := exec.Command("ip", "link", "del", "dev", i.Name)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to destroy interface: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (i *Interface) destroyDarwin() error {
+	if i.device != nil {
+		i.closeDevice()
+		return nil
+	}
+
+	// Stop the wireguard-go process we spawned; the utun device goes with it
+	if i.process != nil {
+		_ = i.process.Kill()
+		<-i.exited
+		i.process = nil
+		return nil
+	}
+
+	// Kill a wireguard-go process started by someone else
+	cmd := exec.Command("pkill", "-f", "wireguard-go (-f )?"+i.Name+"$")
+	_ = cmd.Run() // Ignore errors as process might not exist
+
+	return nil
+}
+
+func (i *Interface) destroyFreeBSD() error {
+	if i.device != nil {
+		i.closeDevice()
+		return nil
+	}
+
+	cmd := exec.Command("ifconfig", i.Name, "destroy")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to destroy interface: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// closeDevice stops the in-process device, which removes the TUN device and
+// the UAPI socket
+func (i *Interface) closeDevice() {
+	i.uapi.Close()
+	i.device.Close()
+	i.device = nil
+}
+
+func (i *Interface) destroyWindows() error {
+	// This should never be called on Unix systems
+	return fmt.Errorf("Windows-specific function called on Unix system")
+}
+
+// findWireGuardGo locates the wireguard-go binary: the configured path, a
+// copy bundled next to our executable, PATH, then the Homebrew prefixes
+func findWireGuardGo(configured string) (string, error) {
+	if configured != "" {
+		if !filepath.IsAbs(configured) {
+			return "", fmt.Errorf("wireguard-go path %q must be absolute", configured)
+		}
+		return configured, nil
+	}
+
+	candidates := []string{}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), "wireguard-go"))
+	}
+	if path, err := exec.LookPath("wireguard-go"); err == nil {
+		if abs, err := filepath.Abs(path); err == nil {
+			candidates = append(candidates, abs)
+		}
+	}
+	for _, dir := range wireguardGoDirs {
+		candidates = append(candidates, filepath.Join(dir, "wireguard-go"))
+	}
+
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("wireguard-go not found; install it (brew install wireguard-go) or set wireguard_go_path")
+}
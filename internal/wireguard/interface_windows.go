@@ -0,0 +1,327 @@
+// +build windows
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+var (
+	runningDevices = make(map[string]*device.Device)
+)
+
+func (i *Interface) createWindows() error {
+	i.setupSteps = nil
+	switch i.driver {
+	case DriverWireGuardNT:
+		return i.createWireGuardNT()
+	case DriverUserspace:
+		return i.createUserspace()
+	case DriverAuto:
+		err := i.createWireGuardNT()
+		if err == nil {
+			log.Printf("Using WireGuardNT kernel driver for %s", i.Name)
+			return nil
+		}
+		log.Printf("WireGuardNT not available, falling back to userspace device: %v", err)
+		return i.createUserspace()
+	default:
+		return fmt.Errorf("unknown Windows driver %q", i.driver)
+	}
+}
+
+// createUserspace runs wireguard-go on a wintun device inside this process
+func (i *Interface) createUserspace() error {
+	// Clean up any existing device with the same name
+	if existingDevice, ok := runningDevices[i.Name]; ok {
+		log.Printf("Cleaning up existing device: %s", i.Name)
+		existingDevice.Close()
+		delete(runningDevices, i.Name)
+		time.Sleep(1 * time.Second) // Give it time to fully close
+	}
+
+	// Create TUN device using wintun (embedded in wireguard-go)
+	mtu := DefaultMTU
+	if i.mtu != 0 {
+		mtu = i.mtu
+	}
+	tunDevice, err := tun.CreateTUN(i.Name, mtu)
+	if err != nil {
+		return fmt.Errorf("failed to create TUN device: %w", err)
+	}
+
+	// Get the real interface name (wintun may change it)
+	realName, err := tunDevice.Name()
+	if err != nil {
+		tunDevice.Close()
+		return fmt.Errorf("failed to get interface name: %w", err)
+	}
+
+	log.Printf("Created TUN interface: %s", realName)
+
+	// Create WireGuard device
+	logger := device.NewLogger(
+		device.LogLevelError,
+		fmt.Sprintf("[%s] ", realName),
+	)
+
+	// Create bind for network connections
+	bind := conn.NewDefaultBind()
+
+	wgDevice := device.NewDevice(tunDevice, bind, logger)
+
+	// Configure the device with our private key and listen port
+	if err := ipcConfigure(wgDevice, i.PrivateKey, i.ListenPort); err != nil {
+		wgDevice.Close()
+		return fmt.Errorf("failed to configure device: %w", err)
+	}
+
+	// Serve the UAPI named pipe so wgctrl can manage peers and read stats
+	uapi, err := ipc.UAPIListen(realName)
+	if err != nil {
+		wgDevice.Close()
+		return fmt.Errorf("failed to listen on UAPI pipe: %w", err)
+	}
+	go func() {
+		for {
+			conn, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go wgDevice.IpcHandle(conn)
+		}
+	}()
+	i.uapi = uapi
+
+	// Bring the device up
+	wgDevice.Up()
+
+	// Store the device so we can close it later
+	runningDevices[i.Name] = wgDevice
+
+	// Wait a moment for interface to be ready
+	time.Sleep(500 * time.Millisecond)
+
+	i.configureAdapter(realName)
+
+	return nil
+}
+
+// configureAdapter assigns the tunnel address, MTU and DNS servers and
+// enables the adapter. Failures are recorded in setupSteps rather than
+// failing Create; the client decides whether they are fatal.
+func (i *Interface) configureAdapter(realName string) {
+	i.adapterName = realName
+
+	// Each value is its own argument; exec quotes it for the command line,
+	// so names with spaces need no manual quoting
+	ip := strings.Split(i.Address, "/")[0]
+	err := runNetsh("set address", "interface", "ip", "set", "address",
+		"name="+realName, "static", ip, netmask(i.Address))
+	i.setupSteps = append(i.setupSteps, SetupStep{Name: "set_address", Err: err})
+
+	if i.mtu != 0 && i.mtu != DefaultMTU {
+		err = runNetsh("set MTU", netshMTUArgs(realName, i.Address, i.mtu)...)
+		i.mtuSet = err == nil
+		i.setupSteps = append(i.setupSteps, SetupStep{Name: "set_mtu", Err: err})
+	}
+
+	if len(i.dnsServers) > 0 {
+		var errs []error
+		for _, args := range netshDNSArgs(realName, i.dnsServers) {
+			errs = append(errs, runNetsh("set DNS servers", args...))
+		}
+		err = errors.Join(errs...)
+		// Even a partial failure may have left servers set
+		i.dnsSet = true
+		i.setupSteps = append(i.setupSteps, SetupStep{Name: "set_dns", Err: err})
+	}
+
+	err = runNetsh("enable interface", "interface", "set", "interface", realName, "admin=enabled")
+	i.setupSteps = append(i.setupSteps, SetupStep{Name: "enable_interface", Err: err})
+
+	log.Printf("Windows WireGuard interface %s configured with IP %s", realName, ip)
+}
+
+// revertAdapter removes the DNS servers and restores the MTU that
+// configureAdapter set. Windows keeps these settings under the adapter's
+// GUID, which a recreated adapter of the same name gets back.
+func (i *Interface) revertAdapter() error {
+	var errs []error
+	if i.dnsSet {
+		for _, args := range netshRevertDNSArgs(i.adapterName, i.dnsServers) {
+			errs = append(errs, runNetsh("remove DNS servers", args...))
+		}
+		i.dnsSet = false
+	}
+	if i.mtuSet {
+		errs = append(errs, runNetsh("restore MTU", netshMTUArgs(i.adapterName, i.Address, DefaultMTU)...))
+		i.mtuSet = false
+	}
+	return errors.Join(errs...)
+}
+
+// runNetsh runs netsh with args. The error carries netsh's output, which
+// is all it says about what went wrong.
+func runNetsh(step string, args ...string) error {
+	output, err := exec.Command("netsh", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("netsh %s: %w: %s", step, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// netshFamily returns netsh's context for the address family of ip, or of
+// the address of a CIDR
+func netshFamily(address string) string {
+	ip := net.ParseIP(strings.Split(address, "/")[0])
+	if ip != nil && ip.To4() == nil {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// netshMTUArgs returns the netsh arguments setting the MTU of the adapter
+// for the family of its address
+func netshMTUArgs(name, address string, mtu int) []string {
+	return []string{"interface", netshFamily(address), "set", "subinterface",
+		name, "mtu=" + strconv.Itoa(mtu), "store=active"}
+}
+
+// netshDNSArgs returns the netsh commands making servers the resolvers of
+// the adapter, in order: the first of each family replaces whatever the
+// adapter had, the rest are added after it
+func netshDNSArgs(name string, servers []string) [][]string {
+	var commands [][]string
+	index := map[string]int{}
+	for _, server := range servers {
+		family := netshFamily(server)
+		index[family]++
+		if index[family] == 1 {
+			commands = append(commands, []string{"interface", family, "set", "dnsservers",
+				"name=" + name, "source=static", "address=" + server, "register=none", "validate=no"})
+			continue
+		}
+		commands = append(commands, []string{"interface", family, "add", "dnsservers",
+			"name=" + name, "address=" + server, "index=" + strconv.Itoa(index[family]), "validate=no"})
+	}
+	return commands
+}
+
+// netshRevertDNSArgs returns the netsh commands removing the resolvers set
+// by the commands of netshDNSArgs
+func netshRevertDNSArgs(name string, servers []string) [][]string {
+	var commands [][]string
+	seen := map[string]bool{}
+	for _, server := range servers {
+		family := netshFamily(server)
+		if seen[family] {
+			continue
+		}
+		seen[family] = true
+		commands = append(commands, []string{"interface", family, "delete", "dnsservers",
+			"name=" + name, "address=all", "validate=no"})
+	}
+	return commands
+}
+
+// SetupSteps reports the netsh steps of the last Create
+func (i *Interface) SetupSteps() []SetupStep {
+	return i.setupSteps
+}
+
+// Kind reports which WireGuard implementation runs the interface
+func (i *Interface) Kind() string {
+	if i.adapter != 0 {
+		return KindKernel
+	}
+	return KindInProcess
+}
+
+// Check verifies that the in-process device still exists and carries the
+// configured private key and listen port
+func (i *Interface) Check() error {
+	if i.adapter != 0 {
+		return i.checkWireGuardNT()
+	}
+
+	wgDevice, ok := runningDevices[i.Name]
+	if !ok {
+		return fmt.Errorf("device %s is not running", i.Name)
+	}
+
+	config, err := wgDevice.IpcGet()
+	if err != nil {
+		return fmt.Errorf("failed to query device %s: %w", i.Name, err)
+	}
+
+	privKeyHex, err := hexKey(i.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(config, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "private_key":
+			if !crypto.ConstantTimeEqualString(value, privKeyHex) {
+				return fmt.Errorf("device %s has an unexpected private key", i.Name)
+			}
+		case "listen_port":
+			if i.ListenPort != 0 && value != strconv.Itoa(i.ListenPort) {
+				return fmt.Errorf("device %s listens on port %s, expected %d", i.Name, value, i.ListenPort)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *Interface) destroyWindows() error {
+	revertErr := i.revertAdapter()
+
+	// Closing a WireGuardNT adapter handle removes the adapter
+	if i.adapter != 0 {
+		i.closeWireGuardNT()
+		log.Printf("Removed WireGuardNT adapter: %s", i.Name)
+		return revertErr
+	}
+
+	if i.uapi != nil {
+		i.uapi.Close()
+		i.uapi = nil
+	}
+
+	// Close the device if we have it
+	if wgDevice, ok := runningDevices[i.Name]; ok {
+		wgDevice.Close()
+		delete(runningDevices, i.Name)
+		log.Printf("Closed WireGuard device: %s", i.Name)
+	}
+
+	return revertErr
+}
+
+// netmask returns the dotted IPv4 mask of a CIDR address; Windows decides
+// which addresses are on-link from it. A bare address is a host.
+func netmask(address string) string {
+	_, network, err := net.ParseCIDR(address)
+	if err != nil || len(network.Mask) != net.IPv4len {
+		return "255.255.255.255"
+	}
+	return net.IP(network.Mask).String()
+}
@@ -0,0 +1,94 @@
+// +build windows
+
+package wireguard
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetshMTUArgs(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"10.100.0.2/16", "interface ipv4 set subinterface wg mesh mtu=1420 store=active"},
+		{"10.100.0.2", "interface ipv4 set subinterface wg mesh mtu=1420 store=active"},
+		{"fd00::2/64", "interface ipv6 set subinterface wg mesh mtu=1420 store=active"},
+		{"", "interface ipv4 set subinterface wg mesh mtu=1420 store=active"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(netshMTUArgs("wg mesh", tt.address, 1420), " "); got != tt.want {
+			t.Errorf("%q:\n got %s\nwant %s", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestNetshDNSArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []string
+		want    []string
+	}{
+		{
+			name:    "one server",
+			servers: []string{"10.100.0.1"},
+			want: []string{
+				"interface ipv4 set dnsservers name=wg0 source=static address=10.100.0.1 register=none validate=no",
+			},
+		},
+		{
+			// The first of each family replaces, the rest are added in order
+			name:    "mixed families",
+			servers: []string{"10.100.0.1", "fd00::1", "1.1.1.1", "fd00::2"},
+			want: []string{
+				"interface ipv4 set dnsservers name=wg0 source=static address=10.100.0.1 register=none validate=no",
+				"interface ipv6 set dnsservers name=wg0 source=static address=fd00::1 register=none validate=no",
+				"interface ipv4 add dnsservers name=wg0 address=1.1.1.1 index=2 validate=no",
+				"interface ipv6 add dnsservers name=wg0 address=fd00::2 index=2 validate=no",
+			},
+		},
+		{
+			name: "none",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := joinCommands(netshDNSArgs("wg0", tt.servers)), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("commands:\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestNetshRevertDNSArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		servers []string
+		want    []string
+	}{
+		{
+			name:    "one family",
+			servers: []string{"10.100.0.1", "1.1.1.1"},
+			want:    []string{"interface ipv4 delete dnsservers name=wg0 address=all validate=no"},
+		},
+		{
+			name:    "both families",
+			servers: []string{"fd00::1", "10.100.0.1", "fd00::2"},
+			want: []string{
+				"interface ipv6 delete dnsservers name=wg0 address=all validate=no",
+				"interface ipv4 delete dnsservers name=wg0 address=all validate=no",
+			},
+		},
+		{
+			name: "none",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := joinCommands(netshRevertDNSArgs("wg0", tt.servers)), strings.Join(tt.want, "\n"); got != want {
+				t.Errorf("commands:\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
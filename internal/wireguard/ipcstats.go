@@ -0,0 +1,97 @@
+package wireguard
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// ipcStats reads the statistics of an in-process userspace device straight
+// from the device, in the same shape as GetStats, without a wgctrl round
+// trip over its UAPI socket
+func ipcStats(name string, dev *device.Device) (map[string]interface{}, error) {
+	config, err := dev.IpcGet()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+
+	stats := map[string]interface{}{
+		"name":      name,
+		"num_peers": 0,
+	}
+	peers := []map[string]interface{}{}
+	var peer map[string]interface{}
+	var handshakeSec, handshakeNsec int64
+
+	// Peer sections start with public_key and run until the next one
+	flush := func() {
+		if peer == nil {
+			return
+		}
+		if handshakeSec != 0 || handshakeNsec != 0 {
+			peer["last_handshake"] = time.Unix(handshakeSec, handshakeNsec)
+		} else {
+			peer["last_handshake"] = time.Time{}
+		}
+		peers = append(peers, peer)
+	}
+
+	for _, line := range strings.Split(config, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "private_key":
+			// Never part of the stats
+		case "listen_port":
+			stats["listen_port"], _ = strconv.Atoi(value)
+		case "public_key":
+			flush()
+			peer = map[string]interface{}{
+				"public_key":  base64Key(value),
+				"allowed_ips": []string{},
+			}
+			handshakeSec, handshakeNsec = 0, 0
+		}
+		if peer == nil {
+			continue
+		}
+		switch key {
+		case "endpoint":
+			peer["endpoint"] = value
+		case "last_handshake_time_sec":
+			handshakeSec, _ = strconv.ParseInt(value, 10, 64)
+		case "last_handshake_time_nsec":
+			handshakeNsec, _ = strconv.ParseInt(value, 10, 64)
+		case "rx_bytes":
+			peer["receive_bytes"], _ = strconv.ParseInt(value, 10, 64)
+		case "tx_bytes":
+			peer["transmit_bytes"], _ = strconv.ParseInt(value, 10, 64)
+		case "persistent_keepalive_interval":
+			seconds, _ := strconv.Atoi(value)
+			peer["persistent_keepalive"] = time.Duration(seconds) * time.Second
+		case "allowed_ip":
+			peer["allowed_ips"] = append(peer["allowed_ips"].([]string), value)
+		}
+	}
+	flush()
+
+	stats["peers"] = peers
+	stats["num_peers"] = len(peers)
+	return stats, nil
+}
+
+// base64Key converts a hex UAPI key to the usual base64 form
+func base64Key(hexKey string) string {
+	raw, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,59 @@
+package wireguard
+
+import "strings"
+
+// Kinds of network link, as reported by LinkTypes
+const (
+	LinkWired    = "wired"
+	LinkWireless = "wireless"
+	LinkCellular = "cellular"
+	LinkVirtual  = "virtual" // Bridges, container and VM networks, tunnels
+	LinkUnknown  = "unknown"
+)
+
+// LinkRank orders link kinds by how good a path they are for WireGuard
+// traffic: wired first, then wireless, unknown, metered cellular, and
+// virtual links last, which other hosts can rarely reach
+func LinkRank(link string) int {
+	switch link {
+	case LinkWired:
+		return 0
+	case LinkWireless:
+		return 1
+	case LinkCellular:
+		return 3
+	case LinkVirtual:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// virtualPrefixes start the names of interfaces created by container
+// runtimes, hypervisors and VPNs
+var virtualPrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vnet", "vmnet", "vboxnet", "lxc", "lxd",
+	"cni", "flannel", "cali", "podman", "tun", "tap", "wg", "utun", "zt",
+	"tailscale", "awdl", "llw", "bridge", "anpi", "ap",
+}
+
+// linkTypeByName guesses the kind of link from the interface name, for
+// when the system does not say
+func linkTypeByName(name string) string {
+	for _, prefix := range virtualPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return LinkVirtual
+		}
+	}
+	switch {
+	case strings.HasPrefix(name, "wl"), strings.HasPrefix(name, "ath"), strings.HasPrefix(name, "iwn"):
+		return LinkWireless
+	case strings.HasPrefix(name, "ww"), strings.HasPrefix(name, "rmnet"), strings.HasPrefix(name, "pdp_ip"):
+		return LinkCellular
+	case strings.HasPrefix(name, "eth"), strings.HasPrefix(name, "en"), strings.HasPrefix(name, "em"),
+		strings.HasPrefix(name, "igb"), strings.HasPrefix(name, "ix"), strings.HasPrefix(name, "re"),
+		strings.HasPrefix(name, "bond"), strings.HasPrefix(name, "lagg"):
+		return LinkWired
+	}
+	return LinkUnknown
+}
@@ -0,0 +1,60 @@
+// +build darwin
+
+package wireguard
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+)
+
+// LinkTypes classifies the named interfaces from the hardware ports macOS
+// lists; en0 may be Ethernet on one Mac and Wi-Fi on another. Interfaces
+// without a hardware port are classified by name.
+func LinkTypes(names []string) map[string]string {
+	ports := hardwarePorts()
+	types := make(map[string]string, len(names))
+	for _, name := range names {
+		port, ok := ports[name]
+		if !ok {
+			types[name] = linkTypeByName(name)
+			continue
+		}
+		switch {
+		case port == "Wi-Fi" || port == "AirPort":
+			types[name] = LinkWireless
+		case strings.Contains(port, "iPhone") || strings.Contains(port, "iPad"):
+			types[name] = LinkCellular
+		case strings.Contains(port, "Bridge"):
+			types[name] = LinkVirtual
+		case strings.Contains(port, "Ethernet") || strings.Contains(port, "LAN"):
+			types[name] = LinkWired
+		default:
+			types[name] = linkTypeByName(name)
+		}
+	}
+	return types
+}
+
+// hardwarePorts maps devices to their hardware port names, e.g. "en0" to
+// "Wi-Fi", from networksetup
+func hardwarePorts() map[string]string {
+	ports := make(map[string]string)
+	output, err := exec.Command("networksetup", "-listallhardwareports").Output()
+	if err != nil {
+		return ports
+	}
+
+	var port string
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "Hardware Port: "); ok {
+			port = value
+		} else if value, ok := strings.CutPrefix(line, "Device: "); ok && port != "" {
+			ports[value] = port
+			port = ""
+		}
+	}
+	return ports
+}
@@ -0,0 +1,82 @@
+// +build linux
+
+package wireguard
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// arphrdEther is the ARP hardware type of Ethernet-like links in
+// /sys/class/net/*/type
+const arphrdEther = "1"
+
+// LinkTypes classifies the named interfaces from sysfs. Interfaces that
+// sysfs says nothing about are classified by name.
+func LinkTypes(names []string) map[string]string {
+	types := make(map[string]string, len(names))
+	for _, name := range names {
+		types[name] = linkType(name)
+	}
+	return types
+}
+
+func linkType(name string) string {
+	dir := filepath.Join("/sys/class/net", name)
+	if _, err := os.Stat(dir); err != nil {
+		return linkTypeByName(name)
+	}
+	if pathExists(filepath.Join(dir, "wireless")) || pathExists(filepath.Join(dir, "phy80211")) {
+		return LinkWireless
+	}
+
+	switch ueventDevType(filepath.Join(dir, "uevent")) {
+	case "wlan":
+		return LinkWireless
+	case "wwan":
+		return LinkCellular
+	case "bond", "team", "vlan":
+		// Built on other links, nearly always Ethernet ones
+		return LinkWired
+	case "bridge":
+		return LinkVirtual
+	}
+
+	// Without a device behind it, an interface is made by software:
+	// veth pairs, tunnels, dummies and the like
+	if !pathExists(filepath.Join(dir, "device")) {
+		return LinkVirtual
+	}
+	if typ, err := os.ReadFile(filepath.Join(dir, "type")); err == nil && strings.TrimSpace(string(typ)) == arphrdEther {
+		if byName := linkTypeByName(name); byName == LinkCellular {
+			// USB modems often show up as Ethernet devices
+			return byName
+		}
+		return LinkWired
+	}
+	return linkTypeByName(name)
+}
+
+// ueventDevType returns the DEVTYPE of an interface's uevent file
+func ueventDevType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "DEVTYPE="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,12 @@
+// +build !linux,!darwin,!windows
+
+package wireguard
+
+// LinkTypes classifies the named interfaces by name
+func LinkTypes(names []string) map[string]string {
+	types := make(map[string]string, len(names))
+	for _, name := range names {
+		types[name] = linkTypeByName(name)
+	}
+	return types
+}
@@ -0,0 +1,67 @@
+// +build windows
+
+package wireguard
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Interface types of WWAN and virtual adapters, missing from x/sys
+const (
+	ifTypePropVirtual = 53
+	ifTypeLAG         = 161
+	ifTypeWWANPP      = 243
+	ifTypeWWANPP2     = 244
+)
+
+// LinkTypes classifies the named adapters by the interface type Windows
+// reports for them. Adapters it does not list are classified by name.
+func LinkTypes(names []string) map[string]string {
+	adapters := adapterTypes()
+	types := make(map[string]string, len(names))
+	for _, name := range names {
+		ifType, ok := adapters[name]
+		if !ok {
+			types[name] = linkTypeByName(name)
+			continue
+		}
+		switch ifType {
+		case windows.IF_TYPE_ETHERNET_CSMACD, ifTypeLAG:
+			types[name] = LinkWired
+		case windows.IF_TYPE_IEEE80211:
+			types[name] = LinkWireless
+		case ifTypeWWANPP, ifTypeWWANPP2, windows.IF_TYPE_PPP:
+			types[name] = LinkCellular
+		case windows.IF_TYPE_TUNNEL, ifTypePropVirtual:
+			types[name] = LinkVirtual
+		default:
+			types[name] = LinkUnknown
+		}
+	}
+	return types
+}
+
+// adapterTypes maps adapter names, as the net package reports them, to
+// their interface types
+func adapterTypes() map[string]uint32 {
+	types := make(map[string]uint32)
+	size := uint32(15000)
+	for attempt := 0; attempt < 3; attempt++ {
+		buf := make([]byte, size)
+		first := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_SKIP_ANYCAST|windows.GAA_FLAG_SKIP_MULTICAST|windows.GAA_FLAG_SKIP_DNS_SERVER, 0, first, &size)
+		if err == windows.ERROR_BUFFER_OVERFLOW {
+			continue
+		}
+		if err != nil {
+			return types
+		}
+		for adapter := first; adapter != nil; adapter = adapter.Next {
+			types[windows.UTF16PtrToString(adapter.FriendlyName)] = adapter.IfType
+		}
+		return types
+	}
+	return types
+}
@@ -0,0 +1,324 @@
+// +build netstack
+
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+
+	"github.com/vpn/wireguard-mesh/pkg/network"
+)
+
+// NetstackSupported reports whether NewNetstackBackend is built in
+const NetstackSupported = true
+
+var _ Backend = (*Netstack)(nil)
+
+// errNetstackDown is returned by calls made before Create or after Destroy
+var errNetstackDown = errors.New("netstack device is not running")
+
+// Netstack is a Backend running wireguard-go inside the process on top of
+// gVisor's userspace TCP/IP stack. No OS interface, address or route is
+// created, so it needs no privileges; mesh addresses are only reachable
+// through DialContext.
+type Netstack struct {
+	Name       string
+	PrivateKey string
+	ListenPort int
+	Address    string
+
+	peerBatchSize int
+
+	mu     sync.Mutex
+	device *device.Device
+	net    *netstack.Net
+}
+
+// NewNetstack creates a netstack device for the given configuration. The
+// interface name only labels the device in logs and stats.
+func NewNetstack(config Config) (*Netstack, error) {
+	if err := network.ValidateAddress(config.Address); err != nil {
+		return nil, err
+	}
+
+	return &Netstack{
+		Name:       config.InterfaceName,
+		PrivateKey: config.PrivateKey,
+		ListenPort: config.ListenPort,
+		Address:    config.Address,
+
+		peerBatchSize: config.PeerBatchSize,
+	}, nil
+}
+
+// NewNetstackBackend is a BackendFactory for netstack devices
+func NewNetstackBackend(config Config) (Backend, error) {
+	ns, err := NewNetstack(config)
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// Create starts the userspace stack and the device on top of it
+func (n *Netstack) Create() error {
+	// The stack owns one address; the mesh is reached through peers'
+	// allowed IPs, so the mask does not matter
+	prefix, err := netip.ParsePrefix(n.Address)
+	if err != nil {
+		return fmt.Errorf("invalid interface address %q", n.Address)
+	}
+
+	tunDevice, tnet, err := netstack.CreateNetTUN([]netip.Addr{prefix.Addr()}, nil, DefaultMTU)
+	if err != nil {
+		return fmt.Errorf("failed to create netstack: %w", err)
+	}
+	logger := device.NewLogger(device.LogLevelError, fmt.Sprintf("[%s] ", n.Name))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.device = device.NewDevice(tunDevice, conn.NewDefaultBind(), logger)
+	n.net = tnet
+	return nil
+}
+
+// Configure programs the private key and listen port and brings the device up
+func (n *Netstack) Configure() error {
+	dev, err := n.running()
+	if err != nil {
+		return err
+	}
+	if err := ipcConfigure(dev, n.PrivateKey, n.ListenPort); err != nil {
+		return fmt.Errorf("failed to configure device: %w", err)
+	}
+	if err := dev.Up(); err != nil {
+		return fmt.Errorf("failed to bring device up: %w", err)
+	}
+	return nil
+}
+
+// AddPeer adds or updates a peer
+func (n *Netstack) AddPeer(peer PeerConfig) error {
+	config, err := ipcPeerConfig(peer)
+	if err != nil {
+		return err
+	}
+	if err := n.ipcSet(config); err != nil {
+		return fmt.Errorf("failed to add peer: %w", err)
+	}
+	return nil
+}
+
+// AddPeers adds or updates peers, one device update per chunk of the
+// configured batch size, reporting failures as a *PeerBatchError
+func (n *Netstack) AddPeers(peers []PeerConfig) error {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	failed := make(map[string]error)
+	var chunk []string
+	var config strings.Builder
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		if err := n.ipcSet(config.String()); err != nil {
+			for _, publicKey := range chunk {
+				failed[publicKey] = fmt.Errorf("failed to add peer: %w", err)
+			}
+		}
+		chunk = chunk[:0]
+		config.Reset()
+	}
+
+	size := n.peerBatchSize
+	if size <= 0 {
+		size = DefaultPeerBatchSize
+	}
+	for _, peer := range peers {
+		peerConfig, err := ipcPeerConfig(peer)
+		if err != nil {
+			failed[peer.PublicKey] = err
+			continue
+		}
+		config.WriteString(peerConfig)
+		chunk = append(chunk, peer.PublicKey)
+		if len(chunk) == size {
+			flush()
+		}
+	}
+	flush()
+
+	if len(failed) > 0 {
+		return &PeerBatchError{Total: len(peers), Failed: failed}
+	}
+	return nil
+}
+
+// RemovePeer removes a peer
+func (n *Netstack) RemovePeer(publicKey string) error {
+	key, err := hexKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if err := n.ipcSet("public_key=" + key + "\nremove=true\n"); err != nil {
+		return fmt.Errorf("failed to remove peer: %w", err)
+	}
+	return nil
+}
+
+// UpdatePeerEndpoint points an existing peer at a new endpoint
+func (n *Netstack) UpdatePeerEndpoint(publicKey, endpoint string) error {
+	key, err := hexKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to resolve endpoint: %w", err)
+	}
+	if err := n.ipcSet("public_key=" + key + "\nupdate_only=true\nendpoint=" + addr.String() + "\n"); err != nil {
+		return fmt.Errorf("failed to update peer endpoint: %w", err)
+	}
+	return nil
+}
+
+// Destroy closes the device and the stack, dropping every connection made
+// through it
+func (n *Netstack) Destroy() error {
+	n.mu.Lock()
+	dev := n.device
+	n.device = nil
+	n.net = nil
+	n.mu.Unlock()
+
+	if dev != nil {
+		dev.Close()
+	}
+	return nil
+}
+
+// GetStats returns statistics for the device
+func (n *Netstack) GetStats() (map[string]interface{}, error) {
+	dev, err := n.running()
+	if err != nil {
+		return nil, err
+	}
+	return ipcStats(n.Name, dev)
+}
+
+// Check verifies that the device is still running
+func (n *Netstack) Check() error {
+	dev, err := n.running()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-dev.Wait():
+		return fmt.Errorf("device %s was closed", n.Name)
+	default:
+	}
+	return nil
+}
+
+// Close does nothing: the device has no handles apart from itself
+func (n *Netstack) Close() error {
+	return nil
+}
+
+// Kind reports KindNetstack
+func (n *Netstack) Kind() string {
+	return KindNetstack
+}
+
+// DialContext connects to an address on the mesh through the userspace
+// stack. The address must be an IP and port; names are not resolved.
+func (n *Netstack) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	tnet, err := n.stack()
+	if err != nil {
+		return nil, err
+	}
+	return tnet.DialContext(ctx, network, address)
+}
+
+// running returns the device, or errNetstackDown
+func (n *Netstack) running() (*device.Device, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.device == nil {
+		return nil, errNetstackDown
+	}
+	return n.device, nil
+}
+
+// stack returns the userspace stack, or errNetstackDown
+func (n *Netstack) stack() (*netstack.Net, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.net == nil {
+		return nil, errNetstackDown
+	}
+	return n.net, nil
+}
+
+// ipcSet applies UAPI configuration to the device
+func (n *Netstack) ipcSet(config string) error {
+	dev, err := n.running()
+	if err != nil {
+		return err
+	}
+	return dev.IpcSetOperation(bytes.NewReader([]byte(config)))
+}
+
+// ipcPeerConfig renders a PeerConfig as a UAPI peer section. Like
+// buildPeerConfig, it replaces the allowed IPs and always sets keepalive.
+func ipcPeerConfig(peer PeerConfig) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(peer.PublicKey)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("failed to parse public key %q", peer.PublicKey)
+	}
+
+	var b strings.Builder
+	b.WriteString("public_key=" + hex.EncodeToString(raw) + "\n")
+	if peer.Endpoint != "" {
+		endpoint, err := net.ResolveUDPAddr("udp", peer.Endpoint)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve endpoint: %w", err)
+		}
+		b.WriteString("endpoint=" + endpoint.String() + "\n")
+	}
+
+	keepAlive := int(peer.KeepAlive.Seconds())
+	if keepAlive < 0 {
+		keepAlive = 0
+	}
+	b.WriteString("persistent_keepalive_interval=" + strconv.Itoa(keepAlive) + "\n")
+
+	b.WriteString("replace_allowed_ips=true\n")
+	for _, ip := range peer.AllowedIPs {
+		prefix, err := netip.ParsePrefix(ip)
+		if err != nil {
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				return "", fmt.Errorf("invalid IP or CIDR: %s", ip)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		b.WriteString("allowed_ip=" + prefix.String() + "\n")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,14 @@
+// +build !netstack
+
+package wireguard
+
+import "errors"
+
+// NetstackSupported reports whether NewNetstackBackend is built in. The
+// userspace stack pulls in gVisor, so it is only included with -tags netstack.
+const NetstackSupported = false
+
+// NewNetstackBackend fails: this build has no userspace network stack
+func NewNetstackBackend(config Config) (Backend, error) {
+	return nil, errors.New("netstack backend not built in; rebuild with -tags netstack")
+}
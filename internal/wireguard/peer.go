@@ -0,0 +1,132 @@
+package wireguard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// buildPeerConfig converts a PeerConfig into the wgtypes form applied to the
+// device
+func buildPeerConfig(peer PeerConfig) (wgtypes.PeerConfig, error) {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	var endpoint *net.UDPAddr
+	if peer.Endpoint != "" {
+		endpoint, err = net.ResolveUDPAddr("udp", peer.Endpoint)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("failed to resolve endpoint: %w", err)
+		}
+	}
+
+	allowedIPs := make([]net.IPNet, len(peer.AllowedIPs))
+	for j, ip := range peer.AllowedIPs {
+		_, ipNet, err := net.ParseCIDR(ip)
+		if err != nil {
+			// Try parsing as single IP
+			parsedIP := net.ParseIP(ip)
+			if parsedIP == nil {
+				return wgtypes.PeerConfig{}, fmt.Errorf("invalid IP or CIDR: %s", ip)
+			}
+			// Convert to CIDR
+			if parsedIP.To4() != nil {
+				_, ipNet, _ = net.ParseCIDR(ip + "/32")
+			} else {
+				_, ipNet, _ = net.ParseCIDR(ip + "/128")
+			}
+		}
+		allowedIPs[j] = *ipNet
+	}
+
+	// Always set the interval explicitly so that disabling keepalive also
+	// clears a previously configured value
+	keepAlive := peer.KeepAlive
+	if keepAlive < 0 {
+		keepAlive = 0
+	}
+
+	// AllowedIPs are replaced rather than appended so that entries the server
+	// (or local policy) withdrew do not linger on the device
+	return wgtypes.PeerConfig{
+		PublicKey:                   publicKey,
+		Endpoint:                    endpoint,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  allowedIPs,
+		PersistentKeepaliveInterval: &keepAlive,
+	}, nil
+}
+
+// hexKey converts a base64 key to the hex form used by the UAPI protocol
+func hexKey(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode key: %w", err)
+	}
+	defer crypto.Zero(raw)
+	return hex.EncodeToString(raw), nil
+}
+
+// ipcConfigure programs the private key and listen port of a userspace
+// device. The UAPI text is assembled in a buffer that is zeroed afterwards,
+// rather than in a string that would keep the key until collected.
+func ipcConfigure(dev *device.Device, privateKey string, listenPort int) error {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode key: %w", err)
+	}
+	defer crypto.Zero(raw)
+
+	// Sized up front so that appending never leaves a copy behind
+	config := make([]byte, 0, 128)
+	defer func() { crypto.Zero(config) }()
+	config = append(config, "private_key="...)
+	config = hex.AppendEncode(config, raw)
+	config = append(config, "\nlisten_port="...)
+	config = strconv.AppendInt(config, int64(listenPort), 10)
+	config = append(config, '\n')
+
+	return dev.IpcSetOperation(bytes.NewReader(config))
+}
+
+// UpdatePeerEndpoint points an existing peer at a new endpoint, leaving its
+// keys, allowed IPs and keepalive alone. The peer is not created if it is
+// not configured.
+func (i *Interface) UpdatePeerEndpoint(publicKey, endpoint string) error {
+	key, err := wgtypes.ParseKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to resolve endpoint: %w", err)
+	}
+
+	config := wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:  key,
+			UpdateOnly: true,
+			Endpoint:   addr,
+		}},
+	}
+
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, config); err != nil {
+		return fmt.Errorf("failed to update peer endpoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package wireguard
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// TestBuildPeerConfigKeepalive pins the device configuration each keepalive
+// policy produces. The interval is always set, so that disabling keepalive
+// clears one programmed before.
+func TestBuildPeerConfigKeepalive(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.PublicKey()
+	_, allowed, _ := net.ParseCIDR("10.100.0.2/32")
+
+	tests := []struct {
+		name      string
+		keepAlive time.Duration
+		want      time.Duration
+	}{
+		{"disabled", 0, 0},
+		{"default", DefaultKeepAlive, 25 * time.Second},
+		{"configured", 15 * time.Second, 15 * time.Second},
+		{"negative", -time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildPeerConfig(PeerConfig{
+				PublicKey:  publicKey.String(),
+				Endpoint:   "192.0.2.1:51820",
+				AllowedIPs: []string{"10.100.0.2"},
+				KeepAlive:  tt.keepAlive,
+			})
+			if err != nil {
+				t.Fatalf("buildPeerConfig: %v", err)
+			}
+
+			want := tt.want
+			expected := wgtypes.PeerConfig{
+				PublicKey:                   publicKey,
+				Endpoint:                    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+				ReplaceAllowedIPs:           true,
+				AllowedIPs:                  []net.IPNet{*allowed},
+				PersistentKeepaliveInterval: &want,
+			}
+			if got.Endpoint != nil {
+				// ResolveUDPAddr may return the 4-byte form
+				got.Endpoint.IP = got.Endpoint.IP.To16()
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("buildPeerConfig:\n got %+v\nwant %+v", got, expected)
+			}
+		})
+	}
+}
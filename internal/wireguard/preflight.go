@@ -0,0 +1,30 @@
+package wireguard
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// PreflightCheck is the outcome of one pre-flight check
+type PreflightCheck struct {
+	Name   string
+	Err    error  // nil if the check passed
+	Detail string // Extra information, also for passing checks
+	Hint   string // One-line remediation shown when the check fails
+}
+
+// Passed reports whether the check succeeded
+func (c PreflightCheck) Passed() bool {
+	return c.Err == nil
+}
+
+// toolCheck verifies that an external command is available
+func toolCheck(tool, hint string) PreflightCheck {
+	check := PreflightCheck{Name: "tool " + tool, Hint: hint}
+	if path, err := exec.LookPath(tool); err != nil {
+		check.Err = fmt.Errorf("%s not found on PATH", tool)
+	} else {
+		check.Detail = path
+	}
+	return check
+}
@@ -0,0 +1,139 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in the Linux capability sets
+const capNetAdmin = 12
+
+// Preflight checks the privileges and external dependencies needed to create
+// the interface described by config on this platform
+func Preflight(config Config) []PreflightCheck {
+	checks := []PreflightCheck{privilegeCheck()}
+
+	switch runtime.GOOS {
+	case "linux":
+		checks = append(checks,
+			toolCheck("ip", "install iproute2, e.g. apt-get install iproute2"),
+			kernelModuleCheck(),
+		)
+	case "darwin":
+		checks = append(checks,
+			toolCheck("ifconfig", "ifconfig is part of the base system; check PATH"),
+			toolCheck("route", "route is part of the base system; check PATH"),
+		)
+		if config.UseSystemWireGuardGo {
+			check := PreflightCheck{
+				Name: "wireguard-go",
+				Hint: "brew install wireguard-go, set wireguard_go_path, or disable use_system_wireguard_go",
+			}
+			check.Detail, check.Err = findWireGuardGo(config.WireGuardGoPath)
+			checks = append(checks, check)
+		}
+	case "freebsd":
+		checks = append(checks,
+			toolCheck("ifconfig", "ifconfig is part of the base system; check PATH"),
+			toolCheck("route", "route is part of the base system; check PATH"),
+			kernelDriverCheck(),
+		)
+	}
+
+	return checks
+}
+
+// privilegeCheck verifies that we may create and configure interfaces
+func privilegeCheck() PreflightCheck {
+	check := PreflightCheck{Name: "privileges"}
+
+	if runtime.GOOS != "linux" {
+		if os.Geteuid() != 0 {
+			check.Err = fmt.Errorf("not running as root")
+			check.Hint = "run with sudo or install as a service"
+		}
+		return check
+	}
+
+	// Root inside a container may still lack CAP_NET_ADMIN, and a non-root
+	// user may have been granted it, so look at the effective set
+	effective, err := effectiveCapabilities()
+	if err != nil {
+		check.Err = err
+		check.Hint = "run as root"
+		return check
+	}
+	if effective&(1<<capNetAdmin) == 0 {
+		check.Err = fmt.Errorf("missing CAP_NET_ADMIN")
+		check.Hint = "run as root, or grant it with: setcap cap_net_admin+ep <binary>"
+	}
+	return check
+}
+
+// effectiveCapabilities returns the effective capability set of this process
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read capabilities: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("no CapEff line in /proc/self/status")
+}
+
+// kernelModuleCheck verifies that kernel WireGuard is loaded or loadable
+func kernelModuleCheck() PreflightCheck {
+	check := PreflightCheck{
+		Name: "kernel wireguard",
+		Hint: "install the WireGuard kernel module (Linux 5.6+ includes it) and run: modprobe wireguard",
+	}
+
+	if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+		check.Detail = "loaded"
+		return check
+	}
+
+	// Built into the kernel rather than a module
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		builtin, _ := os.ReadFile("/lib/modules/" + strings.TrimSpace(string(release)) + "/modules.builtin")
+		if strings.Contains(string(builtin), "/wireguard.ko") {
+			check.Detail = "built in"
+			return check
+		}
+	}
+
+	// Creating the interface loads the module on demand if it is installed
+	if err := exec.Command("modprobe", "-n", "wireguard").Run(); err != nil {
+		check.Err = fmt.Errorf("wireguard module is not loaded and not available")
+		return check
+	}
+	check.Detail = "available, loaded on demand"
+	return check
+}
+
+// kernelDriverCheck reports whether FreeBSD's if_wg driver is available; the
+// userspace device is used otherwise, so this never fails
+func kernelDriverCheck() PreflightCheck {
+	check := PreflightCheck{Name: "kernel if_wg"}
+	if exec.Command("kldstat", "-q", "-m", "wg").Run() == nil {
+		check.Detail = "loaded"
+	} else if _, err := os.Stat("/boot/kernel/if_wg.ko"); err == nil {
+		check.Detail = "available, loaded on demand"
+	} else {
+		check.Detail = "not available, using the userspace device"
+	}
+	return check
+}
@@ -0,0 +1,46 @@
+// +build windows
+
+package wireguard
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// Preflight checks the privileges and external dependencies needed to create
+// the interface described by config on this platform
+func Preflight(config Config) []PreflightCheck {
+	return []PreflightCheck{
+		privilegeCheck(),
+		toolCheck("netsh", "netsh is part of Windows; check PATH"),
+		driverCheck(config.WindowsDriver),
+	}
+}
+
+// privilegeCheck verifies that the process holds an elevated token
+func privilegeCheck() PreflightCheck {
+	check := PreflightCheck{Name: "privileges"}
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		check.Err = fmt.Errorf("not running as Administrator")
+		check.Hint = "run from an elevated prompt or install as a service (runs as LocalSystem)"
+	}
+	return check
+}
+
+// driverCheck verifies that the selected driver can be used
+func driverCheck(driver string) PreflightCheck {
+	check := PreflightCheck{Name: "WireGuardNT driver"}
+
+	err := loadWireGuardNT()
+	switch {
+	case err == nil:
+		check.Detail = "available"
+	case driver == DriverWireGuardNT:
+		check.Err = err
+		check.Hint = "place wireguard.dll next to vpn-client.exe or set windows_driver to \"userspace\""
+	default:
+		check.Detail = fmt.Sprintf("not available (%v), using the userspace device", err)
+	}
+	return check
+}
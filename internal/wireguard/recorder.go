@@ -0,0 +1,135 @@
+package wireguard
+
+import (
+	"sync"
+	"time"
+)
+
+// Operations recorded by a Recorder
+const (
+	OpCreate         = "create_interface"
+	OpConfigure      = "configure_interface"
+	OpSetAddress     = "set_address"
+	OpAddPeer        = "add_peer"
+	OpRemovePeer     = "remove_peer"
+	OpUpdateEndpoint = "update_endpoint"
+	OpDestroy        = "destroy_interface"
+)
+
+// Operation is one change a Recorder was asked to make to the device
+type Operation struct {
+	Op           string   `json:"op"`
+	Interface    string   `json:"interface"`
+	Address      string   `json:"address,omitempty"`
+	ListenPort   int      `json:"listen_port,omitempty"`
+	FirewallMark int      `json:"firewall_mark,omitempty"`
+	PublicKey    string   `json:"public_key,omitempty"`
+	Endpoint     string   `json:"endpoint,omitempty"`
+	AllowedIPs   []string `json:"allowed_ips,omitempty"`
+	KeepAlive    int      `json:"keepalive,omitempty"` // Seconds
+}
+
+// Recorder is a Backend that changes nothing on the system: it runs no
+// commands and opens no devices, and only records the operations asked of
+// it, in order. A dry run puts it in place of the real backend to show
+// what the client would do.
+type Recorder struct {
+	config Config
+
+	mu         sync.Mutex
+	operations []Operation
+}
+
+var _ Backend = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder for an interface with the given
+// configuration
+func NewRecorder(config Config) *Recorder {
+	return &Recorder{config: config}
+}
+
+// Operations returns the operations recorded so far
+func (r *Recorder) Operations() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Operation(nil), r.operations...)
+}
+
+func (r *Recorder) record(op Operation) {
+	op.Interface = r.config.InterfaceName
+	r.mu.Lock()
+	r.operations = append(r.operations, op)
+	r.mu.Unlock()
+}
+
+// Create records the creation of the interface with its address
+func (r *Recorder) Create() error {
+	r.record(Operation{Op: OpCreate, Address: r.config.Address})
+	return nil
+}
+
+// Configure records the listen port and firewall mark being set
+func (r *Recorder) Configure() error {
+	r.record(Operation{Op: OpConfigure, ListenPort: r.config.ListenPort, FirewallMark: bindMark(r.config.FirewallMark, r.config.BindInterface)})
+	return nil
+}
+
+// SetAddress records the address of the interface being changed
+func (r *Recorder) SetAddress(address string) error {
+	r.config.Address = address
+	r.record(Operation{Op: OpSetAddress, Address: address})
+	return nil
+}
+
+// AddPeer records a peer being added or updated
+func (r *Recorder) AddPeer(peer PeerConfig) error {
+	r.record(Operation{
+		Op:         OpAddPeer,
+		PublicKey:  peer.PublicKey,
+		Endpoint:   peer.Endpoint,
+		AllowedIPs: append([]string(nil), peer.AllowedIPs...),
+		KeepAlive:  int(peer.KeepAlive / time.Second),
+	})
+	return nil
+}
+
+// AddPeers records each peer being added, in order
+func (r *Recorder) AddPeers(peers []PeerConfig) error {
+	for _, peer := range peers {
+		r.AddPeer(peer)
+	}
+	return nil
+}
+
+// RemovePeer records a peer being removed
+func (r *Recorder) RemovePeer(publicKey string) error {
+	r.record(Operation{Op: OpRemovePeer, PublicKey: publicKey})
+	return nil
+}
+
+// UpdatePeerEndpoint records the endpoint of a peer being changed
+func (r *Recorder) UpdatePeerEndpoint(publicKey, endpoint string) error {
+	r.record(Operation{Op: OpUpdateEndpoint, PublicKey: publicKey, Endpoint: endpoint})
+	return nil
+}
+
+// Destroy records the interface being removed
+func (r *Recorder) Destroy() error {
+	r.record(Operation{Op: OpDestroy})
+	return nil
+}
+
+// GetStats returns no statistics; nothing is running
+func (r *Recorder) GetStats() (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+// Check always passes
+func (r *Recorder) Check() error {
+	return nil
+}
+
+// Close does nothing
+func (r *Recorder) Close() error {
+	return nil
+}
@@ -0,0 +1,10 @@
+package wireguard
+
+// SetupStep is a step of bringing an interface up that a backend carried on
+// past rather than failing Create, such as assigning the address with netsh
+// on Windows. Backends that have such steps report them through a
+// SetupSteps() []SetupStep method.
+type SetupStep struct {
+	Name string
+	Err  error // nil if the step succeeded
+}
@@ -0,0 +1,29 @@
+package wireguard
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// snapshotTimeout bounds each command run by NetworkSnapshot
+const snapshotTimeout = 10 * time.Second
+
+// NetworkSnapshot captures the host's interfaces, addresses and routing
+// tables with the platform's own tools, for debug bundles. Each command's
+// output, or its error, is keyed by the command line.
+func NetworkSnapshot() map[string]string {
+	snapshot := make(map[string]string)
+	for _, args := range snapshotCommands() {
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+		output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+		cancel()
+		text := string(output)
+		if err != nil {
+			text += "\n(" + err.Error() + ")\n"
+		}
+		snapshot[strings.Join(args, " ")] = text
+	}
+	return snapshot
+}
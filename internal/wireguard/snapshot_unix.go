@@ -0,0 +1,25 @@
+// +build linux darwin freebsd
+
+package wireguard
+
+import "runtime"
+
+// snapshotCommands lists the commands NetworkSnapshot runs
+func snapshotCommands() [][]string {
+	switch runtime.GOOS {
+	case "linux":
+		return [][]string{
+			{"ip", "addr", "show"},
+			{"ip", "-4", "route", "show", "table", "all"},
+			{"ip", "-6", "route", "show", "table", "all"},
+			{"ip", "rule", "show"},
+			{"cat", "/etc/resolv.conf"},
+		}
+	default:
+		return [][]string{
+			{"ifconfig", "-a"},
+			{"netstat", "-rn"},
+			{"cat", "/etc/resolv.conf"},
+		}
+	}
+}
@@ -0,0 +1,12 @@
+// +build windows
+
+package wireguard
+
+// snapshotCommands lists the commands NetworkSnapshot runs
+func snapshotCommands() [][]string {
+	return [][]string{
+		{"ipconfig", "/all"},
+		{"route", "print"},
+		{"netsh", "interface", "ipv4", "show", "subinterfaces"},
+	}
+}
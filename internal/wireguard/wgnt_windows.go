@@ -0,0 +1,157 @@
+// +build windows
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// wgntTunnelType is the tunnel type WireGuardNT adapters are created with
+const wgntTunnelType = "WireGuardMesh"
+
+// WireGuardNT adapter states
+const (
+	wgntAdapterStateDown = 0
+	wgntAdapterStateUp   = 1
+)
+
+var (
+	wgntOnce sync.Once
+	wgntErr  error
+
+	procCreateAdapter   *windows.LazyProc
+	procCloseAdapter    *windows.LazyProc
+	procSetAdapterState *windows.LazyProc
+)
+
+// loadWireGuardNT loads wireguard.dll, preferring a copy shipped next to the
+// client over one in the system directory
+func loadWireGuardNT() error {
+	wgntOnce.Do(func() {
+		var candidates []*windows.LazyDLL
+		if exe, err := os.Executable(); err == nil {
+			candidates = append(candidates, windows.NewLazyDLL(filepath.Join(filepath.Dir(exe), "wireguard.dll")))
+		}
+		candidates = append(candidates, windows.NewLazySystemDLL("wireguard.dll"))
+
+		var dll *windows.LazyDLL
+		for _, candidate := range candidates {
+			if err := candidate.Load(); err == nil {
+				dll = candidate
+				break
+			}
+		}
+		if dll == nil {
+			wgntErr = fmt.Errorf("wireguard.dll not found next to the client or in the system directory")
+			return
+		}
+
+		procCreateAdapter = dll.NewProc("WireGuardCreateAdapter")
+		procCloseAdapter = dll.NewProc("WireGuardCloseAdapter")
+		procSetAdapterState = dll.NewProc("WireGuardSetAdapterState")
+		for _, proc := range []*windows.LazyProc{procCreateAdapter, procCloseAdapter, procSetAdapterState} {
+			if err := proc.Find(); err != nil {
+				wgntErr = fmt.Errorf("wireguard.dll is missing %s: %w", proc.Name, err)
+				return
+			}
+		}
+	})
+	return wgntErr
+}
+
+// createWireGuardNT creates a kernel adapter through the WireGuardNT driver
+// and configures it via wgctrl
+func (i *Interface) createWireGuardNT() error {
+	if err := loadWireGuardNT(); err != nil {
+		return err
+	}
+
+	name, err := windows.UTF16PtrFromString(i.Name)
+	if err != nil {
+		return fmt.Errorf("invalid interface name %q: %w", i.Name, err)
+	}
+	tunnelType, err := windows.UTF16PtrFromString(wgntTunnelType)
+	if err != nil {
+		return err
+	}
+
+	adapter, _, err := procCreateAdapter.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(tunnelType)), 0)
+	if adapter == 0 {
+		if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return fmt.Errorf("failed to create WireGuardNT adapter: %w (loading the driver and creating adapters requires running as Administrator or LocalSystem)", err)
+		}
+		return fmt.Errorf("failed to create WireGuardNT adapter: %w", err)
+	}
+	i.adapter = adapter
+
+	privateKey, err := wgtypes.ParseKey(i.PrivateKey)
+	if err != nil {
+		i.closeWireGuardNT()
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+	defer crypto.Zero(privateKey[:])
+	port := i.ListenPort
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(i.Name, wgtypes.Config{PrivateKey: &privateKey, ListenPort: &port}); err != nil {
+		i.closeWireGuardNT()
+		return fmt.Errorf("failed to configure WireGuardNT adapter: %w", err)
+	}
+
+	if ok, _, err := procSetAdapterState.Call(i.adapter, wgntAdapterStateUp); ok == 0 {
+		i.closeWireGuardNT()
+		return fmt.Errorf("failed to bring up WireGuardNT adapter: %w", err)
+	}
+
+	i.configureAdapter(i.Name)
+
+	return nil
+}
+
+// closeWireGuardNT closes the adapter handle, which also removes the adapter
+func (i *Interface) closeWireGuardNT() {
+	if i.adapter == 0 {
+		return
+	}
+	procSetAdapterState.Call(i.adapter, wgntAdapterStateDown)
+	procCloseAdapter.Call(i.adapter)
+	i.adapter = 0
+}
+
+// checkWireGuardNT verifies the adapter through wgctrl
+func (i *Interface) checkWireGuardNT() error {
+	client, err := i.controller()
+	if err != nil {
+		return err
+	}
+	device, err := client.Device(i.Name)
+	if err != nil {
+		return fmt.Errorf("device %s not found: %w", i.Name, err)
+	}
+
+	privateKey, err := wgtypes.ParseKey(i.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse private key: %w", err)
+	}
+	defer crypto.Zero(privateKey[:])
+	if !crypto.ConstantTimeEqual(device.PrivateKey[:], privateKey[:]) {
+		return fmt.Errorf("device %s has an unexpected private key", i.Name)
+	}
+	if i.ListenPort != 0 && device.ListenPort != i.ListenPort {
+		return fmt.Errorf("device %s listens on port %d, expected %d", i.Name, device.ListenPort, i.ListenPort)
+	}
+
+	return nil
+}
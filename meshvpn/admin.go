@@ -0,0 +1,115 @@
+package meshvpn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+)
+
+// Peer is a peer as the server stores it
+type Peer = server.Peer
+
+// Peer statuses
+const (
+	PeerStatusActive    = server.PeerStatusActive
+	PeerStatusPending   = server.PeerStatusPending   // Waiting for an administrator's approval
+	PeerStatusSuspended = server.PeerStatusSuspended // Left out of every other peer's list
+)
+
+// AdminPeer is a peer as listed by the admin API, with the fingerprint of
+// its public key
+type AdminPeer = server.AdminPeer
+
+// PeerCounts summarizes the peers listed by the admin API
+type PeerCounts = server.PeerCounts
+
+// AdminError is a request the admin API refused
+type AdminError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *AdminError) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Admin calls a coordination server's admin API, which the server offers
+// when admin_token is set
+type Admin struct {
+	base       *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+// NewAdmin returns an admin API client for the server at serverURL, e.g.
+// "https://vpn.example.com:8080" or, with a base_path, ".../vpn". A nil
+// httpClient uses one with a 30 second timeout.
+func NewAdmin(serverURL, token string, httpClient *http.Client) (*Admin, error) {
+	base, err := url.Parse(serverURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid server URL %q", serverURL)
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Admin{base: base, token: token, httpClient: httpClient}, nil
+}
+
+// Peers lists every peer, pending and suspended ones included, sorted by ID
+func (a *Admin) Peers(ctx context.Context) ([]AdminPeer, PeerCounts, error) {
+	var resp struct {
+		Peers  []AdminPeer `json:"peers"`
+		Counts PeerCounts  `json:"counts"`
+	}
+	if err := a.do(ctx, http.MethodGet, "admin/peers", &resp); err != nil {
+		return nil, PeerCounts{}, err
+	}
+	return resp.Peers, resp.Counts, nil
+}
+
+// ApprovePeer approves a pending peer, or reinstates a suspended one
+func (a *Admin) ApprovePeer(ctx context.Context, peerID string) error {
+	return a.do(ctx, http.MethodPost, "admin/peers/"+url.PathEscape(peerID)+"/approve", nil)
+}
+
+// SuspendPeer takes a peer out of every other peer's list until it is
+// approved again
+func (a *Admin) SuspendPeer(ctx context.Context, peerID string) error {
+	return a.do(ctx, http.MethodPost, "admin/peers/"+url.PathEscape(peerID)+"/suspend", nil)
+}
+
+// RemovePeer deletes a peer and releases its address
+func (a *Admin) RemovePeer(ctx context.Context, peerID string) error {
+	return a.do(ctx, http.MethodDelete, "admin/peers/"+url.PathEscape(peerID), nil)
+}
+
+// do sends one call, decoding the JSON response into out unless it is nil
+func (a *Admin) do(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, a.base.JoinPath(path).String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Admin-Token", a.token)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("server not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &AdminError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,171 @@
+package meshvpn
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/vpn/wireguard-mesh/internal/client"
+)
+
+// Errors returned by Client
+var (
+	// ErrAlreadyStarted is returned by Start on a client that was started
+	// before. A Client cannot be started again once it has stopped.
+	ErrAlreadyStarted = client.ErrAlreadyStarted
+	// ErrNotRunning is returned by Stop on a client that was never started
+	// or has already stopped
+	ErrNotRunning = client.ErrNotRunning
+)
+
+// ClientStatus is a snapshot of a running client, the same one
+// "vpn-client -status" prints
+type ClientStatus = client.Status
+
+// Types making up ClientStatus
+type (
+	SetupStep            = client.SetupStep
+	OnDemandStatus       = client.OnDemandStatus
+	ControlChannelStatus = client.ControlChannelStatus
+	ControlPlaneStatus   = client.ControlPlaneStatus
+	WatchdogStatus       = client.WatchdogStatus
+	ReconfigureStatus    = client.ReconfigureStatus
+	MaintenanceStatus    = client.MaintenanceStatus
+	PeerRate             = client.PeerRate
+)
+
+// PeerStatus is a peer programmed on the client's interface
+type PeerStatus = client.PeerStatus
+
+// Event describes something that happened in a client
+type Event = client.Event
+
+// EventType identifies the kind of Event
+type EventType = client.EventType
+
+// Event types
+const (
+	EventRegistered      = client.EventRegistered
+	EventPeerAdded       = client.EventPeerAdded
+	EventPeerRemoved     = client.EventPeerRemoved
+	EventHeartbeatFailed = client.EventHeartbeatFailed
+	EventEndpointChanged = client.EventEndpointChanged
+	EventAddressChanged  = client.EventAddressChanged
+	EventPeerConnected   = client.EventPeerConnected
+	EventPeerLost        = client.EventPeerLost
+)
+
+// ClientOption adjusts a client created by NewClient
+type ClientOption struct {
+	apply client.Option
+}
+
+// WithLogger sends the client's logs to logger instead of slog.Default()
+func WithLogger(logger *slog.Logger) ClientOption {
+	return ClientOption{client.WithLogger(logger)}
+}
+
+// WithHTTPClient makes the client talk to the coordination server through
+// httpClient, e.g. to add TLS settings or a proxy
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return ClientOption{client.WithHTTPClient(httpClient)}
+}
+
+// WithSaveState is called with the configuration whenever the client
+// generates keys or is assigned an address, to persist them. Without it
+// they only last as long as the process.
+func WithSaveState(saveState func(*ClientConfig) error) ClientOption {
+	return ClientOption{client.WithSaveState(saveState)}
+}
+
+// WithAuthKey presents a pre-auth key when registering as a new peer
+func WithAuthKey(authKey string) ClientOption {
+	return ClientOption{client.WithAuthKey(authKey)}
+}
+
+// WithAcceptServerKeyChange accepts and pins the server's keys if they
+// changed since the first registration, instead of refusing to start
+func WithAcceptServerKeyChange() ClientOption {
+	return ClientOption{client.WithAcceptServerKeyChange()}
+}
+
+// Client joins this host to a mesh: it registers with the coordination
+// server, creates the WireGuard interface and keeps its peers in sync
+type Client struct {
+	client *client.Client
+}
+
+// NewClient creates a client. Nothing happens on the network or the host
+// until Start.
+func NewClient(cfg *ClientConfig, opts ...ClientOption) (*Client, error) {
+	options := make([]client.Option, 0, len(opts))
+	for _, opt := range opts {
+		options = append(options, opt.apply)
+	}
+	c, err := client.NewClient(cfg, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: c}, nil
+}
+
+// Start registers with the server, brings up the WireGuard interface and
+// starts the background routines, then returns. The routines run until ctx
+// is cancelled or Stop is called. A failed Start may be retried.
+func (c *Client) Start(ctx context.Context) error {
+	return c.client.Start(ctx)
+}
+
+// Stop stops the client and returns once its routines have exited and the
+// interface is gone. It is safe to call more than once and concurrently.
+func (c *Client) Stop() error {
+	return c.client.Stop()
+}
+
+// Wait blocks until the client has stopped
+func (c *Client) Wait() {
+	c.client.Wait()
+}
+
+// Healthy reports whether the interface is up and passed its last health
+// check
+func (c *Client) Healthy() bool {
+	return c.client.Healthy()
+}
+
+// Status returns the client's current state
+func (c *Client) Status() (*ClientStatus, error) {
+	return c.client.Status()
+}
+
+// Peers lists the peers currently programmed on the interface, sorted by
+// hostname
+func (c *Client) Peers() []PeerStatus {
+	return c.client.Peers()
+}
+
+// Events returns the channel on which events are delivered. It is closed
+// once the client has stopped. Events are dropped rather than blocking the
+// client when the consumer falls behind.
+func (c *Client) Events() <-chan Event {
+	return c.client.Events()
+}
+
+// RecentEvents returns up to n of the most recent events, oldest first
+func (c *Client) RecentEvents(n int) []Event {
+	return c.client.RecentEvents(n)
+}
+
+// Reload applies the parts of a changed configuration that can take effect
+// while running, currently the extra peers. Other changes need a restart.
+func (c *Client) Reload(cfg *ClientConfig) error {
+	return c.client.Reload(cfg)
+}
+
+// Dial connects to host:port on the mesh through a netstack client's own
+// network stack (backend "netstack"). The host is a virtual IP, or a peer's
+// hostname or ID.
+func (c *Client) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return c.client.Dial(ctx, network, address)
+}
@@ -0,0 +1,41 @@
+package meshvpn
+
+import (
+	"github.com/vpn/wireguard-mesh/pkg/config"
+)
+
+// ClientConfig is a client's configuration, as read from client.json
+type ClientConfig = config.ClientConfig
+
+// ServerConfig is a coordination server's configuration, as read from
+// server.json
+type ServerConfig = config.ServerConfig
+
+// DefaultClientConfig returns a client configuration with defaults for
+// everything but the server address
+func DefaultClientConfig() *ClientConfig {
+	return config.DefaultClientConfig()
+}
+
+// DefaultServerConfig returns a server configuration with defaults
+func DefaultServerConfig() *ServerConfig {
+	return config.DefaultServerConfig()
+}
+
+// LoadClientConfig reads and validates a client configuration file,
+// creating it with the defaults if it does not exist
+func LoadClientConfig(path string) (*ClientConfig, error) {
+	return config.LoadClientConfig(path)
+}
+
+// SaveClientConfig writes a client configuration file. Pass it to
+// WithSaveState to keep generated keys and assignments across restarts.
+func SaveClientConfig(path string, cfg *ClientConfig) error {
+	return config.SaveClientConfig(path, cfg)
+}
+
+// LoadServerConfig reads and validates a server configuration file,
+// creating it with the defaults if it does not exist
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	return config.LoadServerConfig(path)
+}
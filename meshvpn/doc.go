@@ -0,0 +1,19 @@
+// Package meshvpn is the supported Go API for embedding a WireGuard Mesh VPN
+// client or coordination server in another program, and for driving a
+// server's admin API.
+//
+// Everything a program needs is reachable from this package: configuration
+// (ClientConfig, ServerConfig), constructors and their options (NewClient,
+// NewServer, NewAdmin), status and event types, and the errors to compare
+// against with errors.Is. The implementation lives under internal/, where
+// the compiler keeps other modules out, so that it can be refactored freely.
+// Within a major version, exported identifiers here are only added, never
+// removed or changed incompatibly. That includes the fields of the types
+// declared as aliases of internal ones, such as ClientStatus and Event.
+//
+// The packages under pkg/ (config, crypto, network, protocol and version)
+// hold the configuration files, keys and wire protocol shared with other
+// implementations and remain importable on their own.
+//
+// See the programs under examples/ for complete uses.
+package meshvpn
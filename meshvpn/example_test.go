@@ -0,0 +1,90 @@
+package meshvpn_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/vpn/wireguard-mesh/meshvpn"
+)
+
+// Joining a mesh needs root to create the interface, so the examples are
+// compiled but not run.
+
+func ExampleClient_Start() {
+	cfg, err := meshvpn.LoadClientConfig("client.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.ServerAddr = "http://vpn.example.com:8080"
+
+	// Keep the generated keys and the assigned address across runs
+	c, err := meshvpn.NewClient(cfg, meshvpn.WithSaveState(func(cfg *meshvpn.ClientConfig) error {
+		return meshvpn.SaveClientConfig("client.json", cfg)
+	}))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The client runs until the context is cancelled or Stop is called
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := c.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	status, err := c.Status()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Joined as %s on %s\n", status.AssignedIP, status.InterfaceName)
+
+	// Events is closed once the client has stopped
+	for event := range c.Events() {
+		switch event.Type {
+		case meshvpn.EventPeerAdded:
+			fmt.Printf("+ %s %s\n", event.Hostname, event.VirtualIP)
+		case meshvpn.EventPeerRemoved:
+			fmt.Printf("- %s %s\n", event.Hostname, event.VirtualIP)
+		}
+	}
+}
+
+func ExampleServer() {
+	cfg := meshvpn.DefaultServerConfig()
+	cfg.ListenAddr = "127.0.0.1:8080"
+	cfg.NetworkCIDR = "10.100.0.0/16"
+	cfg.DBPath = "server-state/peers.json"
+
+	srv, err := meshvpn.NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Run the background work here and serve the API from an HTTP server
+	// of our own
+	if err := srv.StartBackground(); err != nil {
+		log.Fatal(err)
+	}
+	httpServer := &http.Server{Addr: cfg.ListenAddr, Handler: srv.Handler()}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+
+	// Flush the peer store before exiting
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	httpServer.Shutdown(shutdownCtx)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,75 @@
+package meshvpn
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vpn/wireguard-mesh/internal/server"
+)
+
+// Server is a coordination server: it registers peers, hands out addresses
+// and serves each peer the list of the others
+type Server struct {
+	server *server.Server
+}
+
+// NewServer creates a server, loading its peer store. It takes a lock on
+// the store, released by Shutdown, so that no other server uses it.
+func NewServer(cfg *ServerConfig) (*Server, error) {
+	s, err := server.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{server: s}, nil
+}
+
+// Start serves the API on the configured listen address and blocks until
+// the server is shut down
+func (s *Server) Start() error {
+	return s.server.Start()
+}
+
+// StartBackground starts the server's background work without listening:
+// expiring peers, snapshots, replication and joining the mesh. Use it with
+// Handler to serve the API from an HTTP server of your own.
+func (s *Server) StartBackground() error {
+	return s.server.StartBackground()
+}
+
+// Handler returns the HTTP handler of the API, admin API and dashboard
+// included, mounted under the configured base path
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler()
+}
+
+// Ready is closed once the server accepts requests
+func (s *Server) Ready() <-chan struct{} {
+	return s.server.Ready()
+}
+
+// Healthy reports whether the server is responsive
+func (s *Server) Healthy() bool {
+	return s.server.Healthy()
+}
+
+// Drain stops the server from accepting new peers ahead of maintenance.
+// Registered peers keep working, and the peer store is flushed.
+func (s *Server) Drain() error {
+	return s.server.Drain()
+}
+
+// Undrain accepts new peers again
+func (s *Server) Undrain() {
+	s.server.Undrain()
+}
+
+// Draining reports whether new peers are refused
+func (s *Server) Draining() bool {
+	return s.server.Draining()
+}
+
+// Shutdown stops accepting requests and waits for in-flight ones to finish,
+// then flushes the stores and releases the store lock
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,76 @@
+// +build darwin
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityPath is the macOS keychain command line tool
+const securityPath = "/usr/bin/security"
+
+// securityNotFound is the exit status of security(1) for a missing item
+const securityNotFound = 44
+
+// keychainStore keeps secrets in the macOS Keychain through security(1). As
+// root this is the System keychain.
+type keychainStore struct {
+	cfg *ClientConfig
+}
+
+func newKeychainStore(cfg *ClientConfig) (SecretStore, error) {
+	return keychainStore{cfg: cfg}, nil
+}
+
+func (s keychainStore) Get(name string) (string, error) {
+	cmd := exec.Command(securityPath, "find-generic-password",
+		"-s", keychainService, "-a", keychainAccount(s.cfg, name), "-w")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read %s from the keychain: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (s keychainStore) Set(name, value string) error {
+	// The secret is passed on stdin through interactive mode so that it
+	// never shows up in the process list. Keys are base64, so a value that
+	// needs quoting is not one of ours.
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"'\\") {
+		return fmt.Errorf("refusing to store %s: unexpected characters", name)
+	}
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -l %q -w %s\n",
+		keychainService, keychainAccount(s.cfg, name), "WireGuard Mesh "+keychainAccount(s.cfg, name), value)
+
+	cmd := exec.Command(securityPath, "-i")
+	cmd.Stdin = strings.NewReader(command)
+	output, err := cmd.CombinedOutput()
+	if err != nil || len(bytes.TrimSpace(output)) > 0 {
+		return fmt.Errorf("failed to store %s in the keychain: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (s keychainStore) Delete(name string) error {
+	cmd := exec.Command(securityPath, "delete-generic-password",
+		"-s", keychainService, "-a", keychainAccount(s.cfg, name))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to delete %s from the keychain: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
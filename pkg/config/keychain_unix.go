@@ -0,0 +1,70 @@
+// +build !windows,!darwin
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// keychainStore keeps secrets in the freedesktop Secret Service (GNOME
+// Keyring, KWallet) through secret-tool(1)
+type keychainStore struct {
+	cfg        *ClientConfig
+	secretTool string
+}
+
+// newKeychainStore uses the Secret Service when a session bus and
+// secret-tool are available. Headless machines and system services usually
+// have neither, so they fall back to the config file.
+func newKeychainStore(cfg *ClientConfig) (SecretStore, error) {
+	secretTool, err := exec.LookPath("secret-tool")
+	if err != nil || os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		log.Printf("Warning: Secret Service not available (needs secret-tool and a session bus), keeping keys in the config file")
+		return fileSecretStore{cfg: cfg}, nil
+	}
+	return keychainStore{cfg: cfg, secretTool: secretTool}, nil
+}
+
+func (s keychainStore) attributes(name string) []string {
+	return []string{"service", keychainService, "account", keychainAccount(s.cfg, name)}
+}
+
+func (s keychainStore) Get(name string) (string, error) {
+	cmd := exec.Command(s.secretTool, append([]string{"lookup"}, s.attributes(name)...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// A missing item exits with status 1 and says nothing
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && stderr.Len() == 0 {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read %s from the Secret Service: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (s keychainStore) Set(name, value string) error {
+	args := append([]string{"store", "--label=WireGuard Mesh " + keychainAccount(s.cfg, name)}, s.attributes(name)...)
+	cmd := exec.Command(s.secretTool, args...)
+	cmd.Stdin = strings.NewReader(value)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store %s in the Secret Service: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (s keychainStore) Delete(name string) error {
+	cmd := exec.Command(s.secretTool, append([]string{"clear"}, s.attributes(name)...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete %s from the Secret Service: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
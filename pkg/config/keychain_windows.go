@@ -0,0 +1,126 @@
+// +build windows
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Credential Manager constants from wincred.h
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	maxCredentialBlobSize   = 5 * 512
+)
+
+var (
+	advapi32        = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keychainStore keeps secrets as generic credentials in Windows Credential
+// Manager. Under the service they belong to the LocalSystem account.
+type keychainStore struct {
+	cfg *ClientConfig
+}
+
+func newKeychainStore(cfg *ClientConfig) (SecretStore, error) {
+	if err := procCredReadW.Find(); err != nil {
+		return nil, fmt.Errorf("Credential Manager not available: %w", err)
+	}
+	return keychainStore{cfg: cfg}, nil
+}
+
+// target names the credential, e.g. wireguard-mesh/wg0/private-key
+func (s keychainStore) target(name string) (*uint16, error) {
+	return windows.UTF16PtrFromString(keychainService + "/" + keychainAccount(s.cfg, name))
+}
+
+func (s keychainStore) Get(name string) (string, error) {
+	target, err := s.target(name)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ok, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return "", ErrSecretNotFound
+		}
+		return "", fmt.Errorf("failed to read %s from Credential Manager: %w", name, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	return string(blob), nil
+}
+
+func (s keychainStore) Set(name, value string) error {
+	if len(value) > maxCredentialBlobSize {
+		return fmt.Errorf("%s is too large for Credential Manager", name)
+	}
+	target, err := s.target(name)
+	if err != nil {
+		return err
+	}
+	userName, err := windows.UTF16PtrFromString(keychainService)
+	if err != nil {
+		return err
+	}
+
+	blob := []byte(value)
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	if len(blob) > 0 {
+		cred.CredentialBlob = &blob[0]
+	}
+
+	if ok, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ok == 0 {
+		return fmt.Errorf("failed to store %s in Credential Manager: %w", name, err)
+	}
+	return nil
+}
+
+func (s keychainStore) Delete(name string) error {
+	target, err := s.target(name)
+	if err != nil {
+		return err
+	}
+
+	if ok, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ok == 0 {
+		if errors.Is(err, windows.ERROR_NOT_FOUND) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete %s from Credential Manager: %w", name, err)
+	}
+	return nil
+}
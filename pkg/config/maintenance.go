@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring time of day when a client may reprogram
+// its peers for routine peer list changes
+type MaintenanceWindow struct {
+	Start    string   `json:"start"`               // Time of day the window opens, "HH:MM"
+	End      string   `json:"end"`                 // Time of day it closes; before Start when it spans midnight
+	Days     []string `json:"days,omitempty"`      // Days it opens on, "mon" to "sun"; empty is every day
+	TimeZone string   `json:"time_zone,omitempty"` // IANA name such as "Europe/Berlin"; defaults to local time
+}
+
+// MaintenanceSchedule is a parsed MaintenanceWindow
+type MaintenanceSchedule struct {
+	window   MaintenanceWindow
+	start    time.Duration // Since midnight
+	end      time.Duration
+	days     [7]bool // Indexed by time.Weekday
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Schedule parses the window
+func (w *MaintenanceWindow) Schedule() (*MaintenanceSchedule, error) {
+	schedule := &MaintenanceSchedule{window: *w, location: time.Local}
+
+	var err error
+	if schedule.start, err = parseTimeOfDay(w.Start); err != nil {
+		return nil, fmt.Errorf("invalid start: %w", err)
+	}
+	if schedule.end, err = parseTimeOfDay(w.End); err != nil {
+		return nil, fmt.Errorf("invalid end: %w", err)
+	}
+	if w.TimeZone != "" {
+		if schedule.location, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, fmt.Errorf("invalid time_zone: %w", err)
+		}
+	}
+
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q: want \"mon\" to \"sun\"", day)
+		}
+		schedule.days[weekday] = true
+	}
+	if len(w.Days) == 0 {
+		schedule.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	return schedule, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into the time since midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like \"22:30\"", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Open reports whether the window is open at t. A window opening on a day
+// stays open past midnight until its end. One whose start and end are equal
+// is open all day.
+func (s *MaintenanceSchedule) Open(t time.Time) bool {
+	t = t.In(s.location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.location)
+	offset := t.Sub(midnight)
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case s.start < s.end:
+		return s.days[today] && offset >= s.start && offset < s.end
+	case s.start > s.end:
+		return s.days[today] && offset >= s.start || s.days[yesterday] && offset < s.end
+	default:
+		return s.days[today]
+	}
+}
+
+// Next returns t when the window is open at t, and otherwise when it next
+// opens
+func (s *MaintenanceSchedule) Next(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+	local := t.In(s.location)
+	for day := 0; day <= 7; day++ {
+		date := local.AddDate(0, 0, day)
+		opens := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, s.location).Add(s.start)
+		if opens.After(t) && s.days[opens.Weekday()] {
+			return opens
+		}
+	}
+	return t
+}
+
+// String describes the window, e.g. "22:00-06:00 mon,tue Europe/Berlin"
+func (s *MaintenanceSchedule) String() string {
+	description := s.window.Start + "-" + s.window.End
+	if len(s.window.Days) > 0 {
+		description += " " + strings.ToLower(strings.Join(s.window.Days, ","))
+	}
+	return description + " " + s.location.String()
+}
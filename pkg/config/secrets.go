@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Client key storage backends selectable through ClientConfig.KeyStorage
+const (
+	// KeyStorageFile keeps secrets in the client config file
+	KeyStorageFile = "file"
+	// KeyStorageKeychain keeps secrets in the OS credential store: the
+	// macOS Keychain, Windows Credential Manager or the Secret Service
+	KeyStorageKeychain = "keychain"
+)
+
+// Names of the secrets a client keeps
+const (
+	SecretPrivateKey = "private-key"
+)
+
+// keychainService is the service name secrets are filed under in the OS
+// credential store
+const keychainService = "wireguard-mesh"
+
+// ErrSecretNotFound is returned by SecretStore.Get when the secret has never
+// been stored
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretStore keeps client secrets such as the private key. Get returns
+// ErrSecretNotFound only when the secret is known to be absent; any other
+// error means the store could not be reached and must not be mistaken for a
+// missing secret.
+type SecretStore interface {
+	Get(name string) (string, error)
+	Set(name, value string) error
+	Delete(name string) error
+}
+
+// NewSecretStore returns the secret store selected by cfg.KeyStorage
+func NewSecretStore(cfg *ClientConfig) (SecretStore, error) {
+	switch cfg.KeyStorage {
+	case "", KeyStorageFile:
+		return fileSecretStore{cfg: cfg}, nil
+	case KeyStorageKeychain:
+		store, err := newKeychainStore(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, fallback := store.(fileSecretStore); fallback {
+			return store, nil
+		}
+		return keychainGuard{SecretStore: store, cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unknown key_storage %q", cfg.KeyStorage)
+	}
+}
+
+// fileSecretStore keeps secrets in the client config itself; they reach the
+// disk when the config is saved
+type fileSecretStore struct {
+	cfg *ClientConfig
+}
+
+func (s fileSecretStore) Get(name string) (string, error) {
+	field, err := s.cfg.secretField(name)
+	if err != nil {
+		return "", err
+	}
+	if *field == "" {
+		return "", ErrSecretNotFound
+	}
+	return *field, nil
+}
+
+func (s fileSecretStore) Set(name, value string) error {
+	field, err := s.cfg.secretField(name)
+	if err != nil {
+		return err
+	}
+	*field = value
+	return nil
+}
+
+func (s fileSecretStore) Delete(name string) error {
+	return s.Set(name, "")
+}
+
+// keychainGuard refuses to read from the keychain while the config file
+// still holds secrets. Otherwise switching key_storage without migrating
+// would find no key and silently give the client a new identity.
+type keychainGuard struct {
+	SecretStore
+	cfg *ClientConfig
+}
+
+func (g keychainGuard) Get(name string) (string, error) {
+	if g.cfg.HasFileSecrets() {
+		return "", fmt.Errorf("private_key is set in the config file but key_storage is %q; run \"migrate-keys --to %s\" to move it",
+			KeyStorageKeychain, KeyStorageKeychain)
+	}
+	return g.SecretStore.Get(name)
+}
+
+// secretField returns the config field holding a secret in file storage
+func (c *ClientConfig) secretField(name string) (*string, error) {
+	switch name {
+	case SecretPrivateKey:
+		return &c.PrivateKey, nil
+	default:
+		return nil, fmt.Errorf("unknown secret %q", name)
+	}
+}
+
+// HasFileSecrets reports whether any secret is stored in the config file
+// itself
+func (c *ClientConfig) HasFileSecrets() bool {
+	return c.PrivateKey != ""
+}
+
+// keychainAccount names a secret in the OS credential store. Secrets are
+// kept per interface so that several clients on one machine do not collide.
+func keychainAccount(cfg *ClientConfig, name string) string {
+	return cfg.InterfaceName + "/" + name
+}
@@ -0,0 +1,51 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Digest returns the hex SHA-256 of data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileDigest returns the hex SHA-256 of a file's contents
+func FileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digestPayload is what SignDigest signs: the purpose keeps a signature
+// over one kind of document from being passed off as another
+func digestPayload(purpose, digest string) []byte {
+	return []byte(purpose + "\n" + digest)
+}
+
+// SignDigest signs a hex digest for purpose, returning the base64 signature
+func SignDigest(key ed25519.PrivateKey, purpose, digest string) string {
+	return Sign(key, digestPayload(purpose, digest))
+}
+
+// VerifyDigest checks a SignDigest signature against a base64 ed25519
+// public key
+func VerifyDigest(publicKey, purpose, digest, signature string) error {
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*sha256.Size {
+		return fmt.Errorf("invalid digest")
+	}
+	return Verify(publicKey, digestPayload(purpose, digest), signature)
+}
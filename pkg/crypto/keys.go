@@ -2,8 +2,11 @@ package crypto
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/curve25519"
 )
@@ -12,6 +15,10 @@ const (
 	KeySize = 32
 )
 
+// ErrKeyMismatch is returned by ValidateKeyPair when the public key does not
+// belong to the private key
+var ErrKeyMismatch = errors.New("public key does not match private key")
+
 // KeyPair represents a WireGuard key pair
 type KeyPair struct {
 	PrivateKey []byte
@@ -25,10 +32,20 @@ func GenerateKeyPair() (*KeyPair, error) {
 		return nil, fmt.Errorf("failed to generate private key: %w", err)
 	}
 
-	// Clamp the private key for Curve25519
-	privateKey[0] &= 248
-	privateKey[31] &= 127
-	privateKey[31] |= 64
+	return newKeyPair(privateKey)
+}
+
+// NewPrivateKeyFromSeed derives a key pair deterministically from seed, for
+// reproducible test fixtures. Keys derived this way are only as secret as
+// the seed and must not be used for real peers.
+func NewPrivateKeyFromSeed(seed []byte) (*KeyPair, error) {
+	privateKey := sha256.Sum256(seed)
+	return newKeyPair(privateKey[:])
+}
+
+// newKeyPair clamps privateKey in place and derives its public key
+func newKeyPair(privateKey []byte) (*KeyPair, error) {
+	clamp(privateKey)
 
 	// Generate public key from private key
 	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
@@ -42,6 +59,13 @@ func GenerateKeyPair() (*KeyPair, error) {
 	}, nil
 }
 
+// clamp applies the Curve25519 private key clamping WireGuard expects
+func clamp(key []byte) {
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+}
+
 // PrivateKeyToString encodes the private key to base64
 func (k *KeyPair) PrivateKeyToString() string {
 	return base64.StdEncoding.EncodeToString(k.PrivateKey)
@@ -52,13 +76,15 @@ func (k *KeyPair) PublicKeyToString() string {
 	return base64.StdEncoding.EncodeToString(k.PublicKey)
 }
 
-// ParsePrivateKey decodes a base64-encoded private key
+// ParsePrivateKey decodes a base64-encoded private key. Callers should Zero
+// the result once done with it.
 func ParsePrivateKey(key string) ([]byte, error) {
 	decoded, err := base64.StdEncoding.DecodeString(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode private key: %w", err)
 	}
 	if len(decoded) != KeySize {
+		Zero(decoded)
 		return nil, fmt.Errorf("invalid private key size: %d", len(decoded))
 	}
 	return decoded, nil
@@ -76,16 +102,90 @@ func ParsePublicKey(key string) ([]byte, error) {
 	return decoded, nil
 }
 
-// DerivePublicKey derives the public key from a private key
+// DerivePublicKey derives the public key from a private key. The key is
+// clamped first, as WireGuard does, so an unclamped key yields the same
+// public key WireGuard would use for it; privateKey itself is not modified.
 func DerivePublicKey(privateKey []byte) ([]byte, error) {
 	if len(privateKey) != KeySize {
 		return nil, fmt.Errorf("invalid private key size: %d", len(privateKey))
 	}
 
-	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	clamped := make([]byte, KeySize)
+	copy(clamped, privateKey)
+	defer Zero(clamped)
+	clamp(clamped)
+
+	publicKey, err := curve25519.X25519(clamped, curve25519.Basepoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to derive public key: %w", err)
 	}
 
 	return publicKey, nil
 }
+
+// DerivePublicKeyString derives the base64 public key of a base64 private key
+func DerivePublicKeyString(privateKey string) (string, error) {
+	raw, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	defer Zero(raw)
+	publicKey, err := DerivePublicKey(raw)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(publicKey), nil
+}
+
+// ValidateKeyPair checks that the base64 public key belongs to the base64
+// private key, catching hand-edited configurations whose keys were copied
+// from different pairs
+func ValidateKeyPair(privateKey, publicKey string) error {
+	private, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+	defer Zero(private)
+	public, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+	derived, err := DerivePublicKey(private)
+	if err != nil {
+		return err
+	}
+	if !ConstantTimeEqual(derived, public) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// Fingerprint returns a short, printable digest of a base64 public key, in
+// the style of SSH host key fingerprints, for people to compare keys
+func Fingerprint(publicKey string) string {
+	raw, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		raw = []byte(publicKey)
+	}
+	sum := sha256.Sum256(raw)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// MinFingerprintPrefix is the fewest digest characters a fingerprint prefix
+// must have to select a key
+const MinFingerprintPrefix = 8
+
+// fingerprintPrefix is the algorithm prefix of every fingerprint
+const fingerprintPrefix = "SHA256:"
+
+// MatchesFingerprint reports whether selector is the fingerprint of a public
+// key or a prefix of it, with or without the "SHA256:" prefix. Prefixes
+// shorter than MinFingerprintPrefix characters match nothing; a prefix
+// matching several keys must be lengthened by the caller.
+func MatchesFingerprint(publicKey, selector string) bool {
+	digest := strings.TrimPrefix(selector, fingerprintPrefix)
+	if len(digest) < MinFingerprintPrefix {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimPrefix(Fingerprint(publicKey), fingerprintPrefix), digest)
+}
@@ -0,0 +1,110 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestKeyPairMatchesWireGuard(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		pair, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateKeyPair: %v", err)
+		}
+
+		// WireGuard parses our keys and derives the same public key
+		private, err := wgtypes.ParseKey(pair.PrivateKeyToString())
+		if err != nil {
+			t.Fatalf("wgtypes.ParseKey(private): %v", err)
+		}
+		if got := private.PublicKey().String(); got != pair.PublicKeyToString() {
+			t.Fatalf("wgtypes derives %s, we derive %s", got, pair.PublicKeyToString())
+		}
+		public, err := wgtypes.ParseKey(pair.PublicKeyToString())
+		if err != nil {
+			t.Fatalf("wgtypes.ParseKey(public): %v", err)
+		}
+		if !bytes.Equal(public[:], pair.PublicKey) {
+			t.Fatalf("public key differs after parsing")
+		}
+
+		// Our generated keys are already clamped
+		generated, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var clamped wgtypes.Key
+		copy(clamped[:], pair.PrivateKey)
+		clamp(clamped[:])
+		if !bytes.Equal(clamped[:], pair.PrivateKey) {
+			t.Errorf("private key is not clamped")
+		}
+
+		// And we parse, format and derive WireGuard's keys the same way
+		raw, err := ParsePrivateKey(generated.String())
+		if err != nil {
+			t.Fatalf("ParsePrivateKey: %v", err)
+		}
+		if !bytes.Equal(raw, generated[:]) {
+			t.Fatalf("ParsePrivateKey changed the key")
+		}
+		if got := base64.StdEncoding.EncodeToString(raw); got != generated.String() {
+			t.Fatalf("formatted as %s, want %s", got, generated.String())
+		}
+		derived, err := DerivePublicKeyString(generated.String())
+		if err != nil {
+			t.Fatalf("DerivePublicKeyString: %v", err)
+		}
+		if derived != generated.PublicKey().String() {
+			t.Fatalf("we derive %s, wgtypes derives %s", derived, generated.PublicKey().String())
+		}
+		if err := ValidateKeyPair(generated.String(), generated.PublicKey().String()); err != nil {
+			t.Fatalf("ValidateKeyPair: %v", err)
+		}
+	}
+}
+
+func TestDerivePublicKeyUnclamped(t *testing.T) {
+	// WireGuard clamps private keys as it uses them, so an unclamped key
+	// has the public key of its clamped form
+	raw := bytes.Repeat([]byte{0xff}, KeySize)
+	key, err := wgtypes.NewKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := DerivePublicKey(raw)
+	if err != nil {
+		t.Fatalf("DerivePublicKey: %v", err)
+	}
+	if want := key.PublicKey(); !bytes.Equal(derived, want[:]) {
+		t.Errorf("derived %x, wgtypes derives %x", derived, want[:])
+	}
+	if !bytes.Equal(raw, bytes.Repeat([]byte{0xff}, KeySize)) {
+		t.Error("DerivePublicKey modified its argument")
+	}
+}
+
+func TestParseKeyErrors(t *testing.T) {
+	short := base64.StdEncoding.EncodeToString(make([]byte, KeySize-1))
+	for _, key := range []string{"", "not base64!", short, base64.StdEncoding.EncodeToString(make([]byte, KeySize+1))} {
+		if _, err := ParsePrivateKey(key); err == nil {
+			t.Errorf("ParsePrivateKey(%q) succeeded", key)
+		}
+		if _, err := ParsePublicKey(key); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded", key)
+		}
+		if _, err := wgtypes.ParseKey(key); err == nil {
+			t.Errorf("wgtypes.ParseKey(%q) succeeded", key)
+		}
+	}
+
+	a, _ := wgtypes.GeneratePrivateKey()
+	b, _ := wgtypes.GeneratePrivateKey()
+	if err := ValidateKeyPair(a.String(), b.PublicKey().String()); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("ValidateKeyPair with another pair's public key: %v", err)
+	}
+}
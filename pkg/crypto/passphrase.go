@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// sealedVersion is the version of the envelope written by SealWithPassphrase
+const sealedVersion = 1
+
+// kdfScrypt names the key derivation of a sealed envelope
+const kdfScrypt = "scrypt"
+
+// scrypt cost parameters for new envelopes. scrypt allocates 128*N*r bytes
+// and runs for time proportional to N*r*p, so opening caps the memory at
+// maxScryptMemory and N*p at maxScryptNP; a crafted file can cost at most
+// a few seconds and 256 MiB.
+const (
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptNP     = 1 << 20
+	maxScryptMemory = 256 << 20
+	saltSize        = 16
+)
+
+// ErrWrongPassphrase is returned by OpenWithPassphrase when the passphrase
+// is wrong or the sealed data was modified
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted data")
+
+// sealed is the JSON envelope of data sealed with a passphrase
+type sealed struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SealWithPassphrase encrypts plaintext under a key derived from passphrase
+// with scrypt, using XChaCha20-Poly1305. The result is a self-describing
+// JSON document that OpenWithPassphrase reverses.
+func SealWithPassphrase(plaintext, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase is empty")
+	}
+
+	box := sealed{
+		Version: sealedVersion,
+		KDF:     kdfScrypt,
+		N:       scryptN,
+		R:       scryptR,
+		P:       scryptP,
+		Salt:    make([]byte, saltSize),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(box.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := rand.Read(box.Nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	key, err := scrypt.Key(passphrase, box.Salt, box.N, box.R, box.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer Zero(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	box.Ciphertext = aead.Seal(nil, box.Nonce, plaintext, nil)
+
+	return json.MarshalIndent(box, "", "  ")
+}
+
+// OpenWithPassphrase decrypts data sealed by SealWithPassphrase. Callers
+// should Zero the result once done with it.
+func OpenWithPassphrase(data, passphrase []byte) ([]byte, error) {
+	var box sealed
+	if err := json.Unmarshal(data, &box); err != nil {
+		return nil, fmt.Errorf("failed to parse sealed data: %w", err)
+	}
+	if box.Version != sealedVersion {
+		return nil, fmt.Errorf("unsupported sealed data version %d", box.Version)
+	}
+	if box.KDF != kdfScrypt {
+		return nil, fmt.Errorf("unsupported key derivation %q", box.KDF)
+	}
+	if box.N <= 1 || box.N > maxScryptN || box.N&(box.N-1) != 0 || box.R <= 0 || box.R > maxScryptR || box.P <= 0 || box.P > maxScryptP {
+		return nil, fmt.Errorf("invalid scrypt parameters")
+	}
+	if 128*int64(box.N)*int64(box.R) > maxScryptMemory || box.N*box.P > maxScryptNP {
+		return nil, fmt.Errorf("scrypt parameters too costly: n=%d r=%d p=%d", box.N, box.R, box.P)
+	}
+	if len(box.Nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("invalid nonce size: %d", len(box.Nonce))
+	}
+
+	key, err := scrypt.Key(passphrase, box.Salt, box.N, box.R, box.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	defer Zero(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, box.Nonce, box.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
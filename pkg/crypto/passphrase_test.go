@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealRoundTrip(t *testing.T) {
+	plaintext := []byte("private-key-material")
+	data, err := SealWithPassphrase(plaintext, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("SealWithPassphrase: %v", err)
+	}
+
+	opened, err := OpenWithPassphrase(data, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("OpenWithPassphrase: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened %q, want %q", opened, plaintext)
+	}
+	if _, err := OpenWithPassphrase(data, []byte("battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestOpenRejectsCostlyParameters(t *testing.T) {
+	data, err := SealWithPassphrase([]byte("secret"), []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("SealWithPassphrase: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		n, r, p int
+		want    string
+	}{
+		// Each within its own limit, but together past the caps
+		{"memory", 1 << 20, 8, 1, "too costly"},                   // 1 GiB
+		{"memory at max r", 1 << 18, maxScryptR, 1, "too costly"}, // 1 GiB
+		{"work", 1 << 17, 1, maxScryptP, "too costly"},
+		// Out of range on their own
+		{"n", 1 << 21, 1, 1, "invalid scrypt parameters"},
+		{"n not a power of two", 3 << 10, 8, 1, "invalid scrypt parameters"},
+		{"r", 1 << 10, maxScryptR + 1, 1, "invalid scrypt parameters"},
+		{"p", 1 << 10, 8, 0, "invalid scrypt parameters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var box map[string]any
+			if err := json.Unmarshal(data, &box); err != nil {
+				t.Fatal(err)
+			}
+			box["n"], box["r"], box["p"] = tt.n, tt.r, tt.p
+			crafted, err := json.Marshal(box)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Rejected before deriving anything, so this returns at once
+			_, err = OpenWithPassphrase(crafted, []byte("passphrase"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("n=%d r=%d p=%d: got %v, want an error containing %q", tt.n, tt.r, tt.p, err, tt.want)
+			}
+		})
+	}
+}
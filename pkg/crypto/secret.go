@@ -0,0 +1,73 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+)
+
+// redactedText replaces secrets in formatted output
+const redactedText = "[redacted]"
+
+// ConstantTimeEqual compares two secrets in time independent of their
+// contents, so a caller probing tokens cannot learn how much of a guess
+// matched
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// ConstantTimeEqualString is ConstantTimeEqual for string secrets such as
+// tokens and base64 keys
+func ConstantTimeEqualString(a, b string) bool {
+	return ConstantTimeEqual([]byte(a), []byte(b))
+}
+
+// Zero overwrites a decoded secret once it is no longer needed, so it does
+// not linger in memory until the garbage collector reuses it
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Redacted holds a secret that prints as "[redacted]" with every fmt verb,
+// in slog records and in JSON, so that logging a struct or value holding it
+// by accident does not leak it. Reveal returns the secret itself.
+type Redacted string
+
+// Reveal returns the secret
+func (r Redacted) Reveal() string {
+	return string(r)
+}
+
+// String implements fmt.Stringer
+func (r Redacted) String() string {
+	return redactedText
+}
+
+// GoString implements fmt.GoStringer, covering %#v
+func (r Redacted) GoString() string {
+	return redactedText
+}
+
+// Format implements fmt.Formatter, covering verbs such as %s, %q and %x that
+// would otherwise print the underlying string
+func (r Redacted) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'q':
+		fmt.Fprintf(f, "%q", redactedText)
+	default:
+		fmt.Fprint(f, redactedText)
+	}
+}
+
+// LogValue implements slog.LogValuer
+func (r Redacted) LogValue() slog.Value {
+	return slog.StringValue(redactedText)
+}
+
+// MarshalText implements encoding.TextMarshaler, covering JSON and text log
+// handlers
+func (r Redacted) MarshalText() ([]byte, error) {
+	return []byte(redactedText), nil
+}
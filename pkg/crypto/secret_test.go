@@ -0,0 +1,98 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// testSecret is a private key that must never show up in output
+const testSecret = "cHJpdmF0ZS1rZXktdGhhdC1tdXN0LW5vdC1sZWFr"
+
+func TestRedactedFormat(t *testing.T) {
+	secret := Redacted(testSecret)
+	holder := struct {
+		Name string
+		Key  Redacted
+	}{"laptop", secret}
+
+	tests := []struct {
+		format string
+		arg    any
+		want   string
+	}{
+		{"%v", secret, "[redacted]"},
+		{"%+v", secret, "[redacted]"},
+		{"%#v", secret, "[redacted]"},
+		{"%s", secret, "[redacted]"},
+		{"%q", secret, `"[redacted]"`},
+		{"%x", secret, "[redacted]"},
+		{"%X", secret, "[redacted]"},
+		{"%20s", secret, "[redacted]"},
+		{"%v", &secret, "[redacted]"},
+		{"%v", holder, "{laptop [redacted]}"},
+		{"%+v", holder, "{Name:laptop Key:[redacted]}"},
+		{"%#v", []Redacted{secret}, "[]crypto.Redacted{[redacted]}"},
+		{"%v", map[string]Redacted{"key": secret}, "map[key:[redacted]]"},
+	}
+
+	for _, tt := range tests {
+		got := fmt.Sprintf(tt.format, tt.arg)
+		if got != tt.want {
+			t.Errorf("Sprintf(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+	if got := secret.String(); got != "[redacted]" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := secret.Reveal(); got != testSecret {
+		t.Errorf("Reveal() = %q, want the secret", got)
+	}
+}
+
+func TestRedactedJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Key  Redacted            `json:"key"`
+		Keys map[string]Redacted `json:"keys"`
+	}{Redacted(testSecret), map[string]Redacted{"peer": Redacted(testSecret)}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"key":"[redacted]","keys":{"peer":"[redacted]"}}`; string(data) != want {
+		t.Errorf("JSON = %s, want %s", data, want)
+	}
+}
+
+// TestRedactedNeverLogged logs a secret every way the code base logs
+// values and fails if the secret turns up in the output
+func TestRedactedNeverLogged(t *testing.T) {
+	secret := Redacted(testSecret)
+	var out bytes.Buffer
+
+	for _, handler := range []slog.Handler{
+		slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	} {
+		logger := slog.New(handler)
+		logger.Info("key", "key", secret)
+		logger.Debug("key", slog.Any("key", secret), slog.Group("peer", "key", secret))
+		logger.With("key", secret).Warn("key")
+		logger.Info("struct", "config", struct{ PrivateKey Redacted }{secret})
+		logger.Info("pointer", "key", &secret)
+	}
+
+	std := log.New(&out, "", 0)
+	std.Printf("%v %+v %#v %s %q %x", secret, secret, secret, secret, secret, secret)
+	std.Println(secret, []Redacted{secret})
+
+	if strings.Contains(out.String(), testSecret) {
+		t.Fatalf("secret logged:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "[redacted]") {
+		t.Fatalf("nothing redacted logged:\n%s", out.String())
+	}
+}
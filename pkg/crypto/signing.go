@@ -0,0 +1,74 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// signingKeyContext separates the signing key derived from a WireGuard key
+// from any other use of that key
+const signingKeyContext = "wireguard-mesh signing key v1"
+
+// ErrBadSignature is returned by Verify when a signature does not match
+var ErrBadSignature = errors.New("bad signature")
+
+// DeriveSigningKey derives an ed25519 signing key from a base64 WireGuard
+// private key, so a server's signing identity follows its WireGuard identity
+// without being stored separately
+func DeriveSigningKey(privateKey string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil || len(raw) != KeySize {
+		return nil, fmt.Errorf("invalid private key")
+	}
+	defer Zero(raw)
+
+	h := sha256.New()
+	h.Write([]byte(signingKeyContext))
+	h.Write(raw)
+	seed := h.Sum(nil)
+	defer Zero(seed)
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseSigningKey decodes a base64 ed25519 seed, as configured in
+// signing_key
+func ParseSigningKey(seed string) (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil || len(raw) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key: want %d base64-encoded bytes", ed25519.SeedSize)
+	}
+	defer Zero(raw)
+
+	return ed25519.NewKeyFromSeed(raw), nil
+}
+
+// SigningPublicKeyString encodes the public half of a signing key to base64
+func SigningPublicKeyString(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// Sign signs data, returning the base64 signature
+func Sign(key ed25519.PrivateKey, data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+}
+
+// Verify checks a base64 signature of data against a base64 ed25519 public
+// key
+func Verify(publicKey string, data []byte, signature string) error {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid signing public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// testSigningKey returns the signing key derived from a fresh WireGuard key
+func testSigningKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	pair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	key, err := DeriveSigningKey(pair.PrivateKeyToString())
+	if err != nil {
+		t.Fatalf("DeriveSigningKey: %v", err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key := testSigningKey(t)
+	publicKey := SigningPublicKeyString(key)
+	data := []byte(`{"peer_id":"peer-a","version":7}`)
+	signature := Sign(key, data)
+
+	if err := Verify(publicKey, data, signature); err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+
+	tampered := bytes.Replace(data, []byte("7"), []byte("8"), 1)
+	if err := Verify(publicKey, tampered, signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("tampered data: got %v, want ErrBadSignature", err)
+	}
+
+	other := SigningPublicKeyString(testSigningKey(t))
+	if err := Verify(other, data, signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("wrong key: got %v, want ErrBadSignature", err)
+	}
+
+	raw, _ := base64.StdEncoding.DecodeString(signature)
+	raw[0] ^= 1
+	if err := Verify(publicKey, data, base64.StdEncoding.EncodeToString(raw)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("flipped signature bit: got %v, want ErrBadSignature", err)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	key := testSigningKey(t)
+	publicKey := SigningPublicKeyString(key)
+	data := []byte("data")
+	signature := Sign(key, data)
+
+	tests := []struct {
+		name                 string
+		publicKey, signature string
+		badSignature         bool // ErrBadSignature rather than a key error
+	}{
+		{"empty signature", publicKey, "", true},
+		{"signature not base64", publicKey, "not base64!", true},
+		{"short signature", publicKey, signature[:20], true},
+		{"empty key", "", signature, false},
+		{"key not base64", "not base64!", signature, false},
+		{"short key", publicKey[:20], signature, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.publicKey, data, tt.signature)
+			if err == nil {
+				t.Fatal("verified")
+			}
+			if errors.Is(err, ErrBadSignature) != tt.badSignature {
+				t.Errorf("got %v, bad signature %v", err, tt.badSignature)
+			}
+		})
+	}
+}
+
+func TestDeriveSigningKey(t *testing.T) {
+	pair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := DeriveSigningKey(pair.PrivateKeyToString())
+	if err != nil {
+		t.Fatalf("DeriveSigningKey: %v", err)
+	}
+	second, _ := DeriveSigningKey(pair.PrivateKeyToString())
+	if !first.Equal(second) {
+		t.Error("the same WireGuard key derives different signing keys")
+	}
+
+	// The signing key is not the WireGuard key used as an ed25519 seed
+	raw, _ := base64.StdEncoding.DecodeString(pair.PrivateKeyToString())
+	if first.Equal(ed25519.NewKeyFromSeed(raw)) {
+		t.Error("signing key is the WireGuard key itself")
+	}
+
+	if _, err := DeriveSigningKey("a2V5"); err == nil {
+		t.Error("derived a signing key from a short key")
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	key, err := ParseSigningKey(base64.StdEncoding.EncodeToString(seed))
+	if err != nil {
+		t.Fatalf("ParseSigningKey: %v", err)
+	}
+	if !key.Equal(ed25519.NewKeyFromSeed(seed)) {
+		t.Error("parsed key differs from the seed's")
+	}
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(seed[:16])} {
+		if _, err := ParseSigningKey(bad); err == nil {
+			t.Errorf("ParseSigningKey(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSignDigest(t *testing.T) {
+	key := testSigningKey(t)
+	publicKey := SigningPublicKeyString(key)
+	digest := Digest([]byte("audit log"))
+	signature := SignDigest(key, "audit-export", digest)
+
+	if err := VerifyDigest(publicKey, "audit-export", digest, signature); err != nil {
+		t.Fatalf("round trip: %v", err)
+	}
+	// A signature over one kind of document does not vouch for another
+	if err := VerifyDigest(publicKey, "backup", digest, signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("other purpose: got %v, want ErrBadSignature", err)
+	}
+	if err := VerifyDigest(publicKey, "audit-export", Digest([]byte("audit log, edited")), signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("tampered digest: got %v, want ErrBadSignature", err)
+	}
+	if err := VerifyDigest(SigningPublicKeyString(testSigningKey(t)), "audit-export", digest, signature); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("wrong key: got %v, want ErrBadSignature", err)
+	}
+	if err := VerifyDigest(publicKey, "audit-export", digest[:10], signature); err == nil {
+		t.Error("verified a truncated digest")
+	}
+}
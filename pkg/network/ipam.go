@@ -1,17 +1,33 @@
 package network
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
 	"sync"
 )
 
+// ErrPoolExhausted is returned when every address in the network is allocated
+var ErrPoolExhausted = errors.New("IP pool exhausted")
+
 // IPAllocator manages IP address allocation for the VPN network
 type IPAllocator struct {
 	network    *net.IPNet
 	allocated  map[string]bool
 	nextIP     net.IP
+	segments   map[string]*segment // Ranges set aside for groups, by name
 	mu         sync.RWMutex
+
+	// scanObserver is told how many candidates each allocation examined
+	scanObserver func(segment string, scanned int)
+}
+
+// segment is a range of the network set aside for one group of peers.
+// Addresses in it are only handed out by AllocateIPIn.
+type segment struct {
+	network *net.IPNet
+	nextIP  net.IP
 }
 
 // NewIPAllocator creates a new IP allocator for the given CIDR
@@ -30,24 +46,102 @@ func NewIPAllocator(cidr string) (*IPAllocator, error) {
 		network:   network,
 		allocated: make(map[string]bool),
 		nextIP:    nextIP,
+		segments:  make(map[string]*segment),
 	}, nil
 }
 
-// AllocateIP allocates the next available IP address
+// AddSegment sets a range of the network aside for a named group. The range
+// must lie within the network and must not overlap another segment. Its own
+// network and broadcast addresses are not handed out, so that the range
+// reads like a subnet of its own.
+func (a *IPAllocator) AddSegment(name, cidr string) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR for segment %s: %w", name, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.segments[name]; exists {
+		return fmt.Errorf("segment %s already exists", name)
+	}
+	if !contains(a.network, network) {
+		return fmt.Errorf("segment %s (%s) is not within network %s", name, network, a.network)
+	}
+	for other, seg := range a.segments {
+		if seg.network.Contains(network.IP) || network.Contains(seg.network.IP) {
+			return fmt.Errorf("segment %s (%s) overlaps segment %s (%s)", name, network, other, seg.network)
+		}
+	}
+
+	a.segments[name] = &segment{network: network, nextIP: incrementIP(network.IP)}
+	return nil
+}
+
+// SetScanObserver registers a function told, for every allocation, the
+// segment allocated from ("" outside every segment) and how many candidate
+// addresses were examined. Long scans mean a crowded pool. It is called with
+// the allocator locked, so it must not call back into it.
+func (a *IPAllocator) SetScanObserver(observe func(segment string, scanned int)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.scanObserver = observe
+}
+
+// AllocateIP allocates the next available IP address outside every segment.
+// Once the end of the network is reached it starts over from the beginning,
+// so released addresses are handed out again.
 func (a *IPAllocator) AllocateIP() (string, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	return a.allocate("", a.network, &a.nextIP, func(ip net.IP) bool {
+		return a.segmentOf(ip) == ""
+	})
+}
+
+// AllocateIPIn allocates the next available IP address in the named segment
+func (a *IPAllocator) AllocateIPIn(name string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seg, ok := a.segments[name]
+	if !ok {
+		return "", fmt.Errorf("unknown segment %s", name)
+	}
+	return a.allocate(name, seg.network, &seg.nextIP, func(ip net.IP) bool {
+		return !ip.Equal(seg.network.IP) && !isBroadcast(ip, seg.network)
+	})
+}
+
+// allocate hands out the first free address of network at or after *next
+// that usable accepts, wrapping around once. name is the segment, for the
+// scan observer. Callers must hold a.mu.
+func (a *IPAllocator) allocate(name string, network *net.IPNet, next *net.IP, usable func(net.IP) bool) (string, error) {
+	wrapped := false
+	scanned := 0
+	if a.scanObserver != nil {
+		defer func() { a.scanObserver(name, scanned) }()
+	}
 	for {
-		if !a.network.Contains(a.nextIP) {
-			return "", fmt.Errorf("no more IP addresses available in network %s", a.network.String())
+		if !network.Contains(*next) {
+			if wrapped {
+				return "", fmt.Errorf("%w: no more IP addresses available in network %s", ErrPoolExhausted, network.String())
+			}
+			wrapped = true
+			*next = network.IP
+			continue
 		}
 
-		ip := a.nextIP.String()
-		a.nextIP = incrementIP(a.nextIP)
+		candidate := *next
+		ip := candidate.String()
+		*next = incrementIP(candidate)
+		scanned++
 
-		// Skip broadcast address
-		if isBroadcast(net.ParseIP(ip), a.network) {
+		// Skip the network and broadcast addresses
+		if candidate.Equal(a.network.IP) || isBroadcast(candidate, a.network) || !usable(candidate) {
 			continue
 		}
 
@@ -96,11 +190,151 @@ func (a *IPAllocator) IsAllocated(ip string) bool {
 	return a.allocated[ip]
 }
 
+// Size returns the number of assignable addresses in the network, excluding
+// the network and broadcast addresses
+func (a *IPAllocator) Size() int {
+	ones, bits := a.network.Mask.Size()
+	hostBits := bits - ones
+	if hostBits >= 62 {
+		return math.MaxInt
+	}
+	if size := 1<<hostBits - 2; size > 0 {
+		return size
+	}
+	return 0
+}
+
+// Count returns the number of allocated addresses
+func (a *IPAllocator) Count() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.allocated)
+}
+
 // GetNetworkCIDR returns the network CIDR
 func (a *IPAllocator) GetNetworkCIDR() string {
 	return a.network.String()
 }
 
+// Segment returns the name of the segment holding ip, or "" for addresses
+// in the remaining space
+func (a *IPAllocator) Segment(ip string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ""
+	}
+	return a.segmentOf(parsedIP)
+}
+
+// segmentOf returns the name of the segment holding ip. Callers must hold
+// a.mu.
+func (a *IPAllocator) segmentOf(ip net.IP) string {
+	for name, seg := range a.segments {
+		if seg.network.Contains(ip) {
+			return name
+		}
+	}
+	return ""
+}
+
+// SegmentCIDR returns the range of the named segment, or the whole network
+// for ""
+func (a *IPAllocator) SegmentCIDR(name string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if seg, ok := a.segments[name]; ok {
+		return seg.network.String()
+	}
+	return a.network.String()
+}
+
+// Segments returns the segment names
+func (a *IPAllocator) Segments() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.segments))
+	for name := range a.segments {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Usage returns how many addresses the named segment can hand out and how
+// many of them are allocated. The name "" stands for the space outside
+// every segment.
+func (a *IPAllocator) Usage(name string) (size, used int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if name != "" {
+		seg, ok := a.segments[name]
+		if !ok {
+			return 0, 0
+		}
+		for ip := range a.allocated {
+			if parsedIP := net.ParseIP(ip); parsedIP != nil && seg.network.Contains(parsedIP) {
+				used++
+			}
+		}
+		return subnetSize(seg.network, 2), used
+	}
+
+	size = a.Size()
+	for _, seg := range a.segments {
+		// Every address of a segment is out of the remaining space; the
+		// network's own network and broadcast addresses were never in it
+		excluded := 0
+		if seg.network.Contains(a.network.IP) {
+			excluded++
+		}
+		if isBroadcast(lastIP(seg.network), a.network) {
+			excluded++
+		}
+		size -= subnetSize(seg.network, excluded)
+	}
+	for ip := range a.allocated {
+		if parsedIP := net.ParseIP(ip); parsedIP != nil && a.segmentOf(parsedIP) == "" {
+			used++
+		}
+	}
+	return size, used
+}
+
+// subnetSize returns the number of addresses in network less excluded
+func subnetSize(network *net.IPNet, excluded int) int {
+	ones, bits := network.Mask.Size()
+	hostBits := bits - ones
+	if hostBits >= 62 {
+		return math.MaxInt
+	}
+	if size := 1<<hostBits - excluded; size > 0 {
+		return size
+	}
+	return 0
+}
+
+// contains reports whether inner lies entirely within outer
+func contains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// lastIP returns the last address of network
+func lastIP(network *net.IPNet) net.IP {
+	last := make(net.IP, len(network.IP))
+	for i := range network.IP {
+		last[i] = network.IP[i] | ^network.Mask[i]
+	}
+	return last
+}
+
 // incrementIP increments an IP address
 func incrementIP(ip net.IP) net.IP {
 	result := make(net.IP, len(ip))
@@ -118,9 +352,5 @@ func incrementIP(ip net.IP) net.IP {
 
 // isBroadcast checks if an IP is the broadcast address for the network
 func isBroadcast(ip net.IP, network *net.IPNet) bool {
-	broadcast := make(net.IP, len(network.IP))
-	for i := range network.IP {
-		broadcast[i] = network.IP[i] | ^network.Mask[i]
-	}
-	return ip.Equal(broadcast)
+	return ip.Equal(lastIP(network))
 }
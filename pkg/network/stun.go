@@ -0,0 +1,208 @@
+package network
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// STUN Binding messages (RFC 5389), just enough to learn the address a
+// server sees UDP packets come from
+const (
+	stunHeaderSize   = 20
+	stunMagicCookie  = 0x2112A442
+	stunBindingReq   = 0x0001
+	stunBindingOK    = 0x0101
+	stunMappedAddr   = 0x0001
+	stunXORMappedAdr = 0x0020
+	stunFamilyIPv4   = 0x01
+	stunFamilyIPv6   = 0x02
+)
+
+// ErrNotSTUN is returned for packets that are not the expected STUN message
+var ErrNotSTUN = errors.New("not a STUN message")
+
+// STUNTransactionID matches a STUN response to its request
+type STUNTransactionID [12]byte
+
+// NewSTUNRequest returns a Binding request and its transaction ID
+func NewSTUNRequest() (STUNTransactionID, []byte, error) {
+	var id STUNTransactionID
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, nil, err
+	}
+	msg := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:], stunBindingReq)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], id[:])
+	return id, msg, nil
+}
+
+// ParseSTUNRequest returns the transaction ID of a Binding request
+func ParseSTUNRequest(msg []byte) (STUNTransactionID, error) {
+	var id STUNTransactionID
+	if len(msg) < stunHeaderSize || binary.BigEndian.Uint16(msg[0:]) != stunBindingReq || binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie {
+		return id, ErrNotSTUN
+	}
+	copy(id[:], msg[8:20])
+	return id, nil
+}
+
+// STUNResponse returns a Binding success response telling the requester
+// the address its request came from
+func STUNResponse(id STUNTransactionID, addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	family := byte(stunFamilyIPv4)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = stunFamilyIPv6
+	}
+
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port)^(stunMagicCookie>>16))
+	key := stunXORKey(id)
+	for i := range ip {
+		value[4+i] = ip[i] ^ key[i]
+	}
+
+	msg := make([]byte, stunHeaderSize+4+len(value))
+	binary.BigEndian.PutUint16(msg[0:], stunBindingOK)
+	binary.BigEndian.PutUint16(msg[2:], uint16(4+len(value)))
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	copy(msg[8:], id[:])
+	binary.BigEndian.PutUint16(msg[20:], stunXORMappedAdr)
+	binary.BigEndian.PutUint16(msg[22:], uint16(len(value)))
+	copy(msg[24:], value)
+	return msg
+}
+
+// ParseSTUNResponse returns the mapped address in a Binding success
+// response to the request with id
+func ParseSTUNResponse(msg []byte, id STUNTransactionID) (*net.UDPAddr, error) {
+	if len(msg) < stunHeaderSize || binary.BigEndian.Uint16(msg[0:]) != stunBindingOK ||
+		binary.BigEndian.Uint32(msg[4:]) != stunMagicCookie || STUNTransactionID(msg[8:20]) != id {
+		return nil, ErrNotSTUN
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:]))
+	if stunHeaderSize+length > len(msg) {
+		return nil, fmt.Errorf("truncated STUN response")
+	}
+
+	var mapped *net.UDPAddr
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		kind := binary.BigEndian.Uint16(attrs[0:])
+		size := int(binary.BigEndian.Uint16(attrs[2:]))
+		if 4+size > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+size]
+		switch kind {
+		case stunXORMappedAdr:
+			if addr := parseSTUNAddress(value, stunXORKey(id)); addr != nil {
+				return addr, nil
+			}
+		case stunMappedAddr:
+			mapped = parseSTUNAddress(value, nil)
+		}
+		// Attributes are padded to four bytes
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == nil {
+		return nil, fmt.Errorf("STUN response has no mapped address")
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress decodes a (XOR-)MAPPED-ADDRESS value; key is nil for a
+// plain MAPPED-ADDRESS
+func parseSTUNAddress(value, key []byte) *net.UDPAddr {
+	if len(value) < 4 {
+		return nil
+	}
+	size := net.IPv4len
+	if value[1] == stunFamilyIPv6 {
+		size = net.IPv6len
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+
+	port := binary.BigEndian.Uint16(value[2:])
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if key != nil {
+		port ^= stunMagicCookie >> 16
+		for i := range ip {
+			ip[i] ^= key[i]
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}
+}
+
+// stunXORKey is what XOR-MAPPED-ADDRESS values are masked with: the magic
+// cookie, followed by the transaction ID for IPv6
+func stunXORKey(id STUNTransactionID) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, stunMagicCookie)
+	copy(key[4:], id[:])
+	return key
+}
+
+// ProbeSTUN sends a Binding request to server, a host:port, from an
+// ephemeral UDP port, and returns the address the server saw it come from.
+// It resends the request once a second until timeout.
+func ProbeSTUN(ctx context.Context, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	id, req, err := NewSTUNRequest()
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 1500)
+	for {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		wait := time.Now().Add(time.Second)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		conn.SetReadDeadline(wait)
+		for {
+			n, err := conn.Read(buf)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			if err != nil {
+				// Such as the port being refused
+				return nil, err
+			}
+			if addr, err := ParseSTUNResponse(buf[:n], id); err == nil {
+				return addr, nil
+			}
+		}
+		if ctx.Err() != nil || !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("no STUN response from %s", server)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+const (
+	// maxInterfaceNameLen is IFNAMSIZ minus the terminating NUL on Linux,
+	// macOS and FreeBSD
+	maxInterfaceNameLen = 15
+
+	// maxAdapterNameLen bounds Windows adapter names
+	maxAdapterNameLen = 128
+)
+
+var (
+	interfaceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	adapterNamePattern   = regexp.MustCompile(`^[A-Za-z0-9_. -]+$`)
+	utunNamePattern      = regexp.MustCompile(`^utun[0-9]*$`)
+)
+
+// ValidateInterfaceName checks that name is a safe interface name on this
+// platform. Names are passed as arguments to ip, ifconfig and netsh, so only
+// a conservative set of characters is allowed.
+func ValidateInterfaceName(name string) error {
+	return validateInterfaceName(name, runtime.GOOS)
+}
+
+func validateInterfaceName(name, goos string) error {
+	if name == "" {
+		return fmt.Errorf("interface name is empty")
+	}
+	// A leading dash would be parsed as an option by the tools
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("interface name %q must not start with '-'", name)
+	}
+
+	if goos == "windows" {
+		if len(name) > maxAdapterNameLen {
+			return fmt.Errorf("interface name %q is longer than %d characters", name, maxAdapterNameLen)
+		}
+		if !adapterNamePattern.MatchString(name) || strings.TrimSpace(name) != name {
+			return fmt.Errorf("interface name %q may only contain letters, digits, spaces, '_', '.' and '-'", name)
+		}
+		return nil
+	}
+
+	if len(name) > maxInterfaceNameLen {
+		return fmt.Errorf("interface name %q is longer than %d characters", name, maxInterfaceNameLen)
+	}
+	if !interfaceNamePattern.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("interface name %q may only contain letters, digits, '_', '.' and '-'", name)
+	}
+	// Other names are mapped to a kernel-assigned utun device on macOS
+	if goos == "darwin" && strings.HasPrefix(name, "utun") && !utunNamePattern.MatchString(name) {
+		return fmt.Errorf("interface name %q must be utun followed by a number", name)
+	}
+
+	return nil
+}
+
+// ValidateAddress checks that address is an IP address in CIDR notation
+func ValidateAddress(address string) error {
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return fmt.Errorf("invalid interface address %q: must be an IP in CIDR notation", address)
+	}
+	return nil
+}
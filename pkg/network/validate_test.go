@@ -0,0 +1,90 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateInterfaceName(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		ok   bool
+	}{
+		{"wg0", "linux", true},
+		{"wg-mesh_1.0", "linux", true},
+		{strings.Repeat("a", 15), "linux", true},
+		{"utun7", "darwin", true},
+		{"WireGuard Mesh", "windows", true},
+
+		{"", "linux", false},
+		{"-wg0", "linux", false},
+		{"--help", "windows", false},
+		{strings.Repeat("a", 16), "linux", false},
+		{strings.Repeat("a", 16), "freebsd", false},
+		{strings.Repeat("a", 129), "windows", false},
+
+		// Shell metacharacters, in case a name ever reaches a shell
+		{"wg0;reboot", "linux", false},
+		{"wg0&&id", "linux", false},
+		{"wg0|id", "linux", false},
+		{"$(id)", "linux", false},
+		{"`id`", "linux", false},
+		{"wg0>x", "linux", false},
+		{"wg'0", "linux", false},
+		{`wg"0`, "windows", false},
+		{"wg0\nid", "linux", false},
+		{"wg0\x00", "linux", false},
+		{"wg 0", "linux", false},
+		{"wg0;id", "windows", false},
+		{"wg%PATH%", "windows", false},
+
+		// Path separators and traversal
+		{"../wg0", "linux", false},
+		{"wg/0", "linux", false},
+		{`wg\0`, "windows", false},
+		{".", "linux", false},
+		{"..", "linux", false},
+
+		// Unicode: look-alikes and characters that are fine in a byte count
+		{"wg0é", "linux", false},
+		{"ωg0", "linux", false},
+		{"wg\u200b0", "linux", false},
+		{"ｗｇ０", "windows", false},
+		{"wg\u00a00", "windows", false},
+
+		// Whitespace is allowed inside Windows adapter names only
+		{" wg0", "windows", false},
+		{"wg0 ", "windows", false},
+		{"wg\t0", "windows", false},
+
+		{"utun", "darwin", true},
+		{"utunx", "darwin", false},
+		{"utun1;id", "darwin", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.name, func(t *testing.T) {
+			err := validateInterfaceName(tt.name, tt.goos)
+			if tt.ok && err != nil {
+				t.Errorf("validateInterfaceName(%q) = %v, want ok", tt.name, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("validateInterfaceName(%q) accepted", tt.name)
+			}
+		})
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	for _, address := range []string{"10.100.0.2/16", "fd00::2/64"} {
+		if err := ValidateAddress(address); err != nil {
+			t.Errorf("ValidateAddress(%q) = %v", address, err)
+		}
+	}
+	for _, address := range []string{"", "10.100.0.2", "10.100.0.2/33", "10.100.0.2/16; id", "-10.0.0.1/8"} {
+		if ValidateAddress(address) == nil {
+			t.Errorf("ValidateAddress(%q) accepted", address)
+		}
+	}
+}
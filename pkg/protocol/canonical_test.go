@@ -0,0 +1,296 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/vpn/wireguard-mesh/pkg/crypto"
+)
+
+// update rewrites the golden files instead of comparing with them
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenSigningSeed makes the signatures in testdata reproducible; ed25519
+// signs deterministically
+var goldenSigningSeed = bytes.Repeat([]byte{7}, ed25519.SeedSize)
+
+// goldenPeerList returns a list as a server builds it, out of order and
+// with nil and empty lists mixed, before canonicalizing and signing
+func goldenPeerList() *PeerListResponse {
+	return &PeerListResponse{
+		Peers: []PeerInfo{
+			{
+				ID:         "peer-c",
+				Hostname:   "gateway",
+				PublicKey:  "Y2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2NjY2M=",
+				VirtualIP:  "10.100.0.4",
+				Endpoint:   "198.51.100.4:51820",
+				Endpoints:  []string{"198.51.100.4:51820", "[2001:db8::4]:51820"},
+				AllowedIPs: []string{"10.100.0.4/32", "192.168.10.0/24", "0.0.0.0/0"},
+				Online:     true,
+				ExitNode:   true,
+			},
+			{
+				ID:         "peer-a",
+				Hostname:   "laptop",
+				PublicKey:  "YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE=",
+				VirtualIP:  "10.100.0.2",
+				Endpoints:  []string{},
+				AllowedIPs: []string{"10.100.0.2/32"},
+			},
+			{
+				ID:        "peer-b",
+				Hostname:  "phone",
+				PublicKey: "YmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmI=",
+				VirtualIP: "10.100.0.3",
+				Endpoint:  "203.0.113.3:4500",
+				Endpoints: []string{"203.0.113.3:4500"},
+				Online:    true,
+			},
+		},
+		Conflicts: []AllowedIPsConflict{
+			{Prefix: "192.168.10.0/24", PeerIDs: []string{"peer-c", "peer-b"}, Preferred: "peer-c"},
+		},
+		Version:        42,
+		AllowedSources: []string{"10.100.0.3/32", "10.100.0.4/32", "192.168.10.0/24"},
+		SignedAt:       1700000000,
+	}
+}
+
+// checkGolden compares got with testdata/name, or rewrites the file with
+// -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed; clients of older releases would reject it:\n got %s\nwant %s", name, got, want)
+	}
+}
+
+// TestPeerListGolden pins the bytes servers sign and send. A change here
+// breaks signature checks or ETags between releases, so the golden files
+// are only rewritten along with a protocol version bump.
+func TestPeerListGolden(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(goldenSigningSeed)
+	list := goldenPeerList()
+	list.Canonicalize()
+
+	payload, err := list.SigningPayload("peer-a")
+	if err != nil {
+		t.Fatalf("SigningPayload: %v", err)
+	}
+	checkGolden(t, "peer-list-signing-payload.json", append(payload, '\n'))
+
+	list.Signature = crypto.Sign(key, payload)
+	signed, err := json.Marshal(list)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	checkGolden(t, "peer-list-signed.json", append(signed, '\n'))
+
+	hash, err := list.ContentHash()
+	if err != nil {
+		t.Fatalf("ContentHash: %v", err)
+	}
+	checkGolden(t, "peer-list-content-hash.txt", []byte(hash+"\n"))
+
+	notModified, err := NotModifiedSigningPayload("peer-a", `"`+hash+`"`, list.Version, list.SignedAt)
+	if err != nil {
+		t.Fatalf("NotModifiedSigningPayload: %v", err)
+	}
+	checkGolden(t, "not-modified-signing-payload.json", append(notModified, '\n'))
+}
+
+func TestPeerListGoldenVerifies(t *testing.T) {
+	// What a client does with the golden list: decode, check the signature
+	// over its own payload, and hash it for If-None-Match
+	data, err := os.ReadFile(filepath.Join("testdata", "peer-list-signed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list PeerListResponse
+	if err := json.Unmarshal(data, &list); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	publicKey := crypto.SigningPublicKeyString(ed25519.NewKeyFromSeed(goldenSigningSeed))
+	payload, err := list.SigningPayload("peer-a")
+	if err != nil {
+		t.Fatalf("SigningPayload: %v", err)
+	}
+	if err := crypto.Verify(publicKey, payload, list.Signature); err != nil {
+		t.Errorf("golden signature: %v", err)
+	}
+	other, _ := list.SigningPayload("peer-b")
+	if crypto.Verify(publicKey, other, list.Signature) == nil {
+		t.Error("golden list verifies for another peer")
+	}
+
+	hash, err := list.ContentHash()
+	if err != nil {
+		t.Fatalf("ContentHash: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "peer-list-content-hash.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash+"\n" != string(want) {
+		t.Errorf("decoded list hashes to %s, want %s", hash, want)
+	}
+}
+
+// fuzzPeerList builds a peer list from fuzz input. Peer IDs are valid and
+// unique, and conflict prefixes unique and valid UTF-8, as they are on a
+// server; lists and fields alternate between nil and empty so that
+// Canonicalize has something to normalize.
+func fuzzPeerList(ids, prefixes, hostname, endpoint string, version uint64) *PeerListResponse {
+	list := &PeerListResponse{Version: version}
+	seen := make(map[string]bool)
+	for i, id := range strings.Split(ids, ",") {
+		if seen[id] || ValidateID(id) != nil {
+			continue
+		}
+		seen[id] = true
+
+		peer := PeerInfo{
+			ID:        id,
+			Hostname:  hostname,
+			PublicKey: id + "=",
+			Online:    i%2 == 0,
+		}
+		switch i % 3 {
+		case 0:
+			peer.Endpoint = endpoint
+			peer.Endpoints = []string{endpoint}
+			peer.AllowedIPs = []string{prefixes}
+		case 1:
+			peer.Endpoints = []string{}
+		}
+		list.Peers = append(list.Peers, peer)
+	}
+
+	seen = make(map[string]bool)
+	for _, prefix := range strings.Split(prefixes, ",") {
+		if prefix == "" || seen[prefix] || !utf8.ValidString(prefix) {
+			continue
+		}
+		seen[prefix] = true
+		list.Conflicts = append(list.Conflicts, AllowedIPsConflict{Prefix: prefix, PeerIDs: []string{ids}, Preferred: ids})
+		list.AllowedSources = append(list.AllowedSources, prefix)
+	}
+	return list
+}
+
+// reordered returns a copy of list with its peers and conflicts rotated by
+// shift, empty lists swapped for nil and the reverse, and another version,
+// timestamp and signature
+func reordered(list *PeerListResponse, shift int) *PeerListResponse {
+	out := *list
+	out.Peers = rotate(list.Peers, shift)
+	out.Conflicts = rotate(list.Conflicts, shift)
+	for i := range out.Peers {
+		if len(out.Peers[i].Endpoints) == 0 {
+			if out.Peers[i].Endpoints == nil {
+				out.Peers[i].Endpoints = []string{}
+			} else {
+				out.Peers[i].Endpoints = nil
+			}
+		}
+		if len(out.Peers[i].AllowedIPs) == 0 {
+			out.Peers[i].AllowedIPs = nil
+		}
+	}
+	if len(out.Peers) == 0 {
+		out.Peers = nil
+	}
+	if len(out.AllowedSources) == 0 {
+		out.AllowedSources = []string{}
+	}
+	out.Version++
+	out.SignedAt = 1700000000
+	out.Signature = "c2lnbmF0dXJl"
+	return &out
+}
+
+// rotate returns a copy of s rotated left by shift
+func rotate[T any](s []T, shift int) []T {
+	if len(s) == 0 {
+		return s
+	}
+	shift %= len(s)
+	out := make([]T, 0, len(s))
+	out = append(out, s[shift:]...)
+	return append(out, s[:shift]...)
+}
+
+func FuzzCanonicalize(f *testing.F) {
+	f.Add("peer-b,peer-a,peer-c", "10.1.0.0/16,192.168.0.0/24", "laptop", "192.0.2.1:51820", uint64(7), uint8(1))
+	f.Add("", "", "", "", uint64(0), uint8(0))
+	f.Add("peer-a,peer-a", "10.1.0.0/16,10.1.0.0/16", "hé", "[2001:db8::1]:51820", uint64(1), uint8(5))
+	f.Add("\xff,z,\x00", "\xfe", " ", "", uint64(1<<63), uint8(2))
+
+	f.Fuzz(func(t *testing.T, ids, prefixes, hostname, endpoint string, version uint64, shift uint8) {
+		list := fuzzPeerList(ids, prefixes, hostname, endpoint, version)
+		other := reordered(list, int(shift))
+
+		list.Canonicalize()
+		data, err := json.Marshal(list)
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+
+		// Canonicalizing a canonical list changes nothing
+		again := *list
+		again.Canonicalize()
+		if data2, _ := json.Marshal(&again); !bytes.Equal(data, data2) {
+			t.Fatalf("Canonicalize is not idempotent:\n%s\n%s", data, data2)
+		}
+
+		// A client decoding the list and canonicalizing it gets the same bytes
+		var decoded PeerListResponse
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		decoded.Canonicalize()
+		if data2, _ := json.Marshal(&decoded); !bytes.Equal(data, data2) {
+			t.Fatalf("round trip changed the encoding:\n%s\n%s", data, data2)
+		}
+
+		// The same content in another order has the same hash
+		hash, err := list.ContentHash()
+		if err != nil {
+			t.Fatalf("ContentHash: %v", err)
+		}
+		other.Canonicalize()
+		otherHash, err := other.ContentHash()
+		if err != nil {
+			t.Fatalf("ContentHash: %v", err)
+		}
+		if hash != otherHash {
+			a, _ := json.Marshal(list)
+			b, _ := json.Marshal(other)
+			t.Fatalf("reordered list hashes differently:\n%s\n%s", a, b)
+		}
+		if decodedHash, _ := decoded.ContentHash(); decodedHash != hash {
+			t.Fatalf("decoded list hashes differently")
+		}
+	})
+}
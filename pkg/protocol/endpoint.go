@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// CanonicalEndpoint checks an endpoint advertised by a peer and returns it
+// in canonical form. An IP literal is normalized: IPv4 as a dotted quad
+// (also when given IPv4-mapped), IPv6 compressed and bracketed, e.g.
+// "[2001:db8::1]:51820". A DNS name, e.g. "home.example.com:51820", is
+// returned untouched so that every peer resolves it for itself. IPv6 zones
+// are refused, since they mean nothing to other peers.
+func CanonicalEndpoint(endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %q: want host:port, with IPv6 in brackets", endpoint)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid endpoint %q: bad port", endpoint)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if err := ValidateName(host); err != nil {
+			return "", fmt.Errorf("invalid endpoint %q: host must be an IP address or DNS name", endpoint)
+		}
+		return endpoint, nil
+	}
+	return FormatEndpoint(ip, n), nil
+}
+
+// IsHostnameEndpoint reports whether endpoint names its host by DNS name
+// rather than by IP address
+func IsHostnameEndpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	return err == nil && net.ParseIP(host) == nil
+}
+
+// FormatEndpoint joins an address and port, bracketing IPv6
+func FormatEndpoint(ip net.IP, port int) string {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// IsIPv6Endpoint reports whether endpoint has an IPv6 host
+func IsIPv6Endpoint(endpoint string) bool {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
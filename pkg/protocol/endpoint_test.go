@@ -0,0 +1,98 @@
+package protocol
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCanonicalEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string // "" for an error
+	}{
+		{"192.0.2.1:51820", "192.0.2.1:51820"},
+		{"[::ffff:192.0.2.1]:51820", "192.0.2.1:51820"},
+		{"[2001:db8::1]:51820", "[2001:db8::1]:51820"},
+		{"[2001:0db8:0000:0000:0000:0000:0000:0001]:51820", "[2001:db8::1]:51820"},
+		{"[2001:DB8::A]:1", "[2001:db8::a]:1"},
+		{"[::1]:65535", "[::1]:65535"},
+		{"home.example.com:51820", "home.example.com:51820"},
+
+		// IPv6 zones only mean something on the host that has the link
+		{"[fe80::1%eth0]:51820", ""},
+		{"[fe80::1%25eth0]:51820", ""},
+		// IPv6 needs brackets, and every endpoint a port
+		{"2001:db8::1:51820", ""},
+		{"2001:db8::1", ""},
+		{"[2001:db8::1]", ""},
+		{"192.0.2.1", ""},
+		{"192.0.2.1:", ""},
+		{"[2001:db8::1]:", ""},
+		{"192.0.2.1:0", ""},
+		{"192.0.2.1:65536", ""},
+		{"192.0.2.1:-1", ""},
+		{"192.0.2.1:http", ""},
+		{"[2001:db8::1]]:51820", ""},
+		{"bad host!:51820", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := CanonicalEndpoint(tt.endpoint)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("CanonicalEndpoint(%q) = %q, want an error", tt.endpoint, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("CanonicalEndpoint(%q): %v", tt.endpoint, err)
+		case got != tt.want:
+			t.Errorf("CanonicalEndpoint(%q) = %q, want %q", tt.endpoint, got, tt.want)
+		}
+		// The canonical form is a fixed point
+		if err == nil {
+			if again, err := CanonicalEndpoint(got); err != nil || again != got {
+				t.Errorf("CanonicalEndpoint(%q) = %q, %v; want it unchanged", got, again, err)
+			}
+		}
+	}
+}
+
+func TestFormatEndpoint(t *testing.T) {
+	tests := []struct {
+		ip   string
+		port int
+		want string
+	}{
+		{"192.0.2.1", 51820, "192.0.2.1:51820"},
+		{"::ffff:192.0.2.1", 51820, "192.0.2.1:51820"},
+		{"2001:db8::1", 51820, "[2001:db8::1]:51820"},
+		{"fe80::1", 1, "[fe80::1]:1"},
+	}
+	for _, tt := range tests {
+		if got := FormatEndpoint(net.ParseIP(tt.ip), tt.port); got != tt.want {
+			t.Errorf("FormatEndpoint(%s, %d) = %q, want %q", tt.ip, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestEndpointKinds(t *testing.T) {
+	tests := []struct {
+		endpoint       string
+		ipv6, hostname bool
+	}{
+		{"192.0.2.1:51820", false, false},
+		{"[::ffff:192.0.2.1]:51820", false, false},
+		{"[2001:db8::1]:51820", true, false},
+		{"[fe80::1%eth0]:51820", false, true}, // a zone is not an IP literal
+		{"home.example.com:51820", false, true},
+		{"2001:db8::1", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := IsIPv6Endpoint(tt.endpoint); got != tt.ipv6 {
+			t.Errorf("IsIPv6Endpoint(%q) = %v, want %v", tt.endpoint, got, tt.ipv6)
+		}
+		if got := IsHostnameEndpoint(tt.endpoint); got != tt.hostname {
+			t.Errorf("IsHostnameEndpoint(%q) = %v, want %v", tt.endpoint, got, tt.hostname)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package protocol
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"sort"
 	"time"
 )
 
@@ -16,6 +19,44 @@ const (
 	MsgTypeRemovePeer MessageType = "remove_peer"
 )
 
+// ErrorCodeVersionTooOld is the Code of a register or heartbeat response
+// refusing a client older than the server's minimum client version
+const ErrorCodeVersionTooOld = "VERSION_TOO_OLD"
+
+// ErrorCodePoolExhausted is the Code of a register response refusing a new
+// peer because the server has no addresses left. Clients should keep
+// retrying; addresses are freed as peers are removed.
+const ErrorCodePoolExhausted = "IP_POOL_EXHAUSTED"
+
+// ErrorCodeLimitExceeded is the Code of a register response refusing a new
+// peer because the network or the auth key has as many peers as it may.
+// Places are freed as peers are removed; existing peers are not affected.
+const ErrorCodeLimitExceeded = "LIMIT_EXCEEDED"
+
+// ErrorCodeReadOnly is the Code of a response from a read-only replica
+// refusing a write. Clients should send the write to the primary, or retry
+// it later when no primary can be reached.
+const ErrorCodeReadOnly = "READ_ONLY"
+
+// ErrorCodeRetryLater is the Code of a register response from a draining
+// server refusing a new peer. It comes with status 503 and a Retry-After
+// header; clients should back off for that long, or try another server.
+const ErrorCodeRetryLater = "RETRY_LATER"
+
+// ErrorCodeIdempotencyKeyReused is the Code of a register response refusing
+// a request whose idempotency key was first used with a different request.
+// Each logical registration attempt needs a key of its own.
+const ErrorCodeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
+
+// ErrorCodeUnknownPeer is the Code of a dry-run register response for a
+// public key the server has no peer for, e.g. because the peer expired or
+// was removed. A real registration with the key would create a new peer.
+const ErrorCodeUnknownPeer = "UNKNOWN_PEER"
+
+// ErrorCodePeerSuspended is the Code of a dry-run register response for a
+// peer an administrator suspended
+const ErrorCodePeerSuspended = "PEER_SUSPENDED"
+
 // Message is the base protocol message structure
 type Message struct {
 	Type      MessageType     `json:"type"`
@@ -28,46 +69,135 @@ type RegisterRequest struct {
 	PublicKey  string   `json:"public_key"`
 	Hostname   string   `json:"hostname"`
 	OS         string   `json:"os"`
-	Endpoint   string   `json:"endpoint,omitempty"` // External endpoint if known
+	Endpoint   string   `json:"endpoint,omitempty"`  // External endpoint if known
+	Endpoints  []string `json:"endpoints,omitempty"` // Every endpoint, e.g. one IPv4 and one IPv6; Endpoint is the first
 	RequestIP  bool     `json:"request_ip"`
 	ExitNode   bool     `json:"exit_node"`
 	AllowedIPs []string `json:"allowed_ips,omitempty"`
+	AuthKey    string   `json:"auth_key,omitempty"`  // Pre-auth key, needed only by new peers
+	Ephemeral  bool     `json:"ephemeral,omitempty"` // Remove the peer when it leaves or stays offline
+	Hidden     bool     `json:"hidden,omitempty"`    // Leave the peer out of other peers' lists
+
+	ClientVersion string `json:"client_version,omitempty"`
+	Backend       string `json:"backend,omitempty"` // WireGuard implementation: "kernel", "userspace" or "in-process"
+
+	// IdempotencyKey identifies one logical registration attempt and is
+	// reused across its retries, so that a retry of a request the server
+	// already handled gets the original response
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	// DryRun asks whether the server still knows PublicKey without
+	// registering anything: the response only succeeds, with the server's
+	// keys, or fails with ErrorCodeUnknownPeer or ErrorCodePeerSuspended
+	DryRun bool `json:"dry_run,omitempty"`
 }
 
 // RegisterResponse is sent by server after successful registration
 type RegisterResponse struct {
 	Success    bool     `json:"success"`
 	Error      string   `json:"error,omitempty"`
+	Code       string   `json:"code,omitempty"` // Machine-readable reason for a failure, e.g. ErrorCodeVersionTooOld
+	MinimumVersion string `json:"minimum_version,omitempty"` // Minimum client version, with ErrorCodeVersionTooOld
+	WaitlistPosition int `json:"waitlist_position,omitempty"` // Place in the address queue, with ErrorCodePoolExhausted
 	AssignedIP string   `json:"assigned_ip"`
 	NetworkCIDR string  `json:"network_cidr"`
 	PeerID     string   `json:"peer_id"`
 	ServerPublicKey string `json:"server_public_key"`
+	ServerSigningKey string `json:"server_signing_key,omitempty"` // ed25519 public key signing peer lists, pinned by clients
+	KeyEndorsement *KeyEndorsement `json:"key_endorsement,omitempty"` // Present after a key rotation
+	ObservedIP      string `json:"observed_ip,omitempty"` // Source address the server saw the request from
+	Keepalive       int    `json:"keepalive,omitempty"`   // Recommended persistent keepalive in seconds
+	Topology        string `json:"topology,omitempty"`    // "mesh", "hub" or "custom"
+	DNSServers      []string `json:"dns_servers,omitempty"` // Resolvers for the mesh
+	MTU             int    `json:"mtu,omitempty"`         // Recommended tunnel MTU
+	STUNPorts       []int  `json:"stun_ports,omitempty"`  // UDP ports the server answers STUN on
+}
+
+// PeerInfo is what a client learns about another peer. It is deliberately
+// separate from the server's peer records so that server bookkeeping never
+// reaches clients and can change without breaking the wire format.
+type PeerInfo struct {
+	ID         string   `json:"id"`
+	Hostname   string   `json:"hostname"`
+	PublicKey  string   `json:"public_key"`
+	VirtualIP  string   `json:"virtual_ip"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	Endpoints  []string `json:"endpoints,omitempty"` // All advertised endpoints, IPv4 and IPv6
+	AllowedIPs []string `json:"allowed_ips"`
+	Online     bool     `json:"online"`
+	ExitNode   bool     `json:"exit_node"`
+}
+
+// UnregisterRequest is sent by a client leaving the mesh
+type UnregisterRequest struct {
+	PeerID    string `json:"peer_id"`
+	PublicKey string `json:"public_key"`
 }
 
-// Peer represents a peer in the network
-type Peer struct {
-	ID            string    `json:"id"`
-	PublicKey     string    `json:"public_key"`
-	VirtualIP     string    `json:"virtual_ip"`
-	Endpoint      string    `json:"endpoint,omitempty"`
-	Hostname      string    `json:"hostname"`
-	OS            string    `json:"os"`
-	AllowedIPs    []string  `json:"allowed_ips"`
-	ExitNode      bool      `json:"exit_node"`
-	LastHeartbeat time.Time `json:"last_heartbeat"`
-	Online        bool      `json:"online"`
+// UnregisterResponse is sent by the server in reply to an UnregisterRequest
+type UnregisterResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
 }
 
 // HeartbeatRequest is sent periodically by clients
 type HeartbeatRequest struct {
-	PeerID   string `json:"peer_id"`
-	Endpoint string `json:"endpoint,omitempty"`
+	PeerID    string   `json:"peer_id"`
+	Endpoint  string   `json:"endpoint,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"` // Every endpoint; Endpoint is the first
+
+	ClientVersion string `json:"client_version,omitempty"`
+	Backend       string `json:"backend,omitempty"` // WireGuard implementation, once the interface exists
+
+	// AddressConflicts lists virtual IPs the client saw held by more than
+	// one peer, itself included
+	AddressConflicts []AddressConflict `json:"address_conflicts,omitempty"`
+
+	// CounterSession identifies the run of the client whose traffic totals
+	// follow. Totals only grow within a session and restart from zero in a
+	// new one.
+	CounterSession string `json:"counter_session,omitempty"`
+	ReceiveBytes   uint64 `json:"receive_bytes,omitempty"`
+	TransmitBytes  uint64 `json:"transmit_bytes,omitempty"`
+
+	// MissedHeartbeats counts the heartbeats the client failed to deliver
+	// before this one while the server was unreachable
+	MissedHeartbeats int `json:"missed_heartbeats,omitempty"`
+
+	// Handshakes lists the peers the client recently completed a WireGuard
+	// handshake with, and where the handshake came from
+	Handshakes []HandshakeReport `json:"handshakes,omitempty"`
+}
+
+// HandshakeReport is a peer's endpoint as WireGuard last saw it
+type HandshakeReport struct {
+	PublicKey string `json:"public_key"`
+	Endpoint  string `json:"endpoint"`
+}
+
+// AddressConflict describes a virtual IP held by more than one peer
+type AddressConflict struct {
+	IP      string   `json:"ip"`
+	PeerIDs []string `json:"peer_ids"`
 }
 
 // HeartbeatResponse acknowledges the heartbeat
 type HeartbeatResponse struct {
 	Success bool   `json:"success"`
 	Error   string `json:"error,omitempty"`
+	Code    string `json:"code,omitempty"` // Machine-readable reason for a failure, e.g. ErrorCodeVersionTooOld
+
+	// MinimumVersion is the minimum client version, with ErrorCodeVersionTooOld
+	MinimumVersion string `json:"minimum_version,omitempty"`
+
+	// AssignedIP and NetworkCIDR echo the peer's current address, which
+	// changes when the server renumbers the network
+	AssignedIP  string `json:"assigned_ip,omitempty"`
+	NetworkCIDR string `json:"network_cidr,omitempty"`
+
+	// Version is the current peer list version; clients resync when it
+	// differs from the one they last fetched
+	Version uint64 `json:"version,omitempty"`
 }
 
 // PeerListRequest requests the current peer list
@@ -77,14 +207,142 @@ type PeerListRequest struct {
 
 // PeerListResponse contains the list of all peers
 type PeerListResponse struct {
-	Peers []Peer `json:"peers"`
+	Peers     []PeerInfo           `json:"peers"`
+	Conflicts []AllowedIPsConflict `json:"conflicts,omitempty"`
+	Version   uint64               `json:"version,omitempty"` // Bumped whenever the peer list changes
+
+	// AllowedSources are the source prefixes the requester may accept
+	// traffic from on its interface: the addresses and routes of the peers
+	// in its list. Clients enforcing ACLs drop everything else.
+	AllowedSources []string `json:"allowed_sources,omitempty"`
+
+	// KeyEndorsement lets clients that pinned the server's previous keys
+	// follow a rotation without registering again
+	KeyEndorsement *KeyEndorsement `json:"key_endorsement,omitempty"`
+
+	// SignedAt (Unix seconds) and Signature authenticate the list with the
+	// server's signing key, over SigningPayload
+	SignedAt  int64  `json:"signed_at,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// SigningPayload returns the bytes the server signs for the peer peerID:
+// the canonical JSON encoding of the list without its signature. Binding the
+// requester's ID stops a list meant for one peer being replayed to another.
+func (r *PeerListResponse) SigningPayload(peerID string) ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	return json.Marshal(struct {
+		PeerID string `json:"peer_id"`
+		*PeerListResponse
+	}{peerID, &unsigned})
+}
+
+// PeerListVersionHeader carries the peer list version on a 304 response to
+// a conditional GET /peers, which has no body to carry it in
+const PeerListVersionHeader = "X-Peer-List-Version"
+
+// PeerListSignedAtHeader (Unix seconds) and PeerListSignatureHeader
+// authenticate a 304 response to a conditional GET /peers with the server's
+// signing key, over NotModifiedSigningPayload
+const (
+	PeerListSignedAtHeader  = "X-Peer-List-Signed-At"
+	PeerListSignatureHeader = "X-Peer-List-Signature"
+)
+
+// NotModifiedSigningPayload returns the bytes the server signs when it tells
+// the peer peerID that the list with the given ETag is still current: the
+// canonical JSON encoding of the peer ID, ETag, current version and time.
+// A client holding an old list cannot be told it is current for long, and
+// the answer cannot be replayed to another peer or for another list.
+func NotModifiedSigningPayload(peerID, etag string, version uint64, signedAt int64) ([]byte, error) {
+	return json.Marshal(struct {
+		PeerID   string `json:"peer_id"`
+		ETag     string `json:"etag"`
+		Version  uint64 `json:"version"`
+		SignedAt int64  `json:"signed_at"`
+	}{peerID, etag, version, signedAt})
+}
+
+// Canonicalize puts the list in the one form servers send, so that the same
+// peers always encode to the same bytes: peers sorted by ID, conflicts by
+// prefix, and empty lists encoded alike whether or not they were allocated
+func (r *PeerListResponse) Canonicalize() {
+	if r.Peers == nil {
+		r.Peers = []PeerInfo{}
+	}
+	sort.SliceStable(r.Peers, func(i, j int) bool { return r.Peers[i].ID < r.Peers[j].ID })
+	for i := range r.Peers {
+		if len(r.Peers[i].Endpoints) == 0 {
+			r.Peers[i].Endpoints = nil
+		}
+		if r.Peers[i].AllowedIPs == nil {
+			r.Peers[i].AllowedIPs = []string{}
+		}
+	}
+	if len(r.Conflicts) == 0 {
+		r.Conflicts = nil
+	}
+	sort.SliceStable(r.Conflicts, func(i, j int) bool { return r.Conflicts[i].Prefix < r.Conflicts[j].Prefix })
+	if len(r.AllowedSources) == 0 {
+		r.AllowedSources = nil
+	}
+}
+
+// ContentHash returns the hex SHA-256 of the canonical JSON encoding of what
+// a canonical list tells its requester. The version, timestamp and signature
+// are left out, so servers serving the same peers give the same hash. It is
+// the list's ETag.
+func (r *PeerListResponse) ContentHash() (string, error) {
+	content := *r
+	content.Version = 0
+	content.SignedAt = 0
+	content.Signature = ""
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AllowedIPsConflict describes a prefix claimed by more than one peer.
+// Clients program the prefix only on the Preferred peer so that every node
+// makes the same routing choice.
+type AllowedIPsConflict struct {
+	Prefix    string   `json:"prefix"`
+	PeerIDs   []string `json:"peer_ids"`
+	Preferred string   `json:"preferred"`
+}
+
+// KeyEndorsement is the statement a server makes when it rotates its keys:
+// the previous signing key vouches for the new keys, so that clients that
+// pinned the old ones can move to them without operator approval
+type KeyEndorsement struct {
+	OldPublicKey  string `json:"old_public_key"`
+	NewPublicKey  string `json:"new_public_key"`
+	NewSigningKey string `json:"new_signing_key"`
+	SignedAt      int64  `json:"signed_at"`
+	Signature     string `json:"signature,omitempty"` // By the old signing key, over SigningPayload
+}
+
+// SigningPayload returns the bytes signed by the old signing key: the
+// canonical JSON encoding of the endorsement without its signature
+func (e *KeyEndorsement) SigningPayload() ([]byte, error) {
+	unsigned := *e
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
 }
 
 // PeerUpdate notifies about peer changes
 type PeerUpdate struct {
-	Action string `json:"action"` // "add", "update", "remove"
-	Peer   *Peer  `json:"peer,omitempty"`
-	PeerID string `json:"peer_id,omitempty"`
+	Action string    `json:"action"` // "add", "update", "remove"
+	Peer   *PeerInfo `json:"peer,omitempty"`
+	PeerID string    `json:"peer_id,omitempty"`
+
+	// Urgent asks clients to program the change at once rather than
+	// coalescing it with others, e.g. the removal of a banned peer
+	Urgent bool `json:"urgent,omitempty"`
 }
 
 // NewMessage creates a new protocol message
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPeerInfoWireFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		peer PeerInfo
+		want string
+	}{
+		{
+			name: "full",
+			peer: PeerInfo{
+				ID:         "peer-a",
+				Hostname:   "laptop",
+				PublicKey:  "cHVibGljLWtleQ==",
+				VirtualIP:  "10.100.0.2",
+				Endpoint:   "192.0.2.1:51820",
+				Endpoints:  []string{"192.0.2.1:51820", "[2001:db8::1]:51820"},
+				AllowedIPs: []string{"10.100.0.2/32", "192.168.1.0/24"},
+				Online:     true,
+				ExitNode:   true,
+			},
+			want: `{"id":"peer-a","hostname":"laptop","public_key":"cHVibGljLWtleQ==","virtual_ip":"10.100.0.2",` +
+				`"endpoint":"192.0.2.1:51820","endpoints":["192.0.2.1:51820","[2001:db8::1]:51820"],` +
+				`"allowed_ips":["10.100.0.2/32","192.168.1.0/24"],"online":true,"exit_node":true}`,
+		},
+		{
+			// Endpoints are left out when unknown; the rest is always sent
+			name: "minimal",
+			peer: PeerInfo{ID: "peer-b", PublicKey: "a2V5"},
+			want: `{"id":"peer-b","hostname":"","public_key":"a2V5","virtual_ip":"","allowed_ips":null,"online":false,"exit_node":false}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.peer)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("encoding:\n got %s\nwant %s", data, tt.want)
+			}
+
+			var decoded PeerInfo
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.peer) {
+				t.Errorf("round trip:\n got %+v\nwant %+v", decoded, tt.peer)
+			}
+		})
+	}
+}
+
+func TestPeerInfoIgnoresServerRecords(t *testing.T) {
+	// Servers from before PeerInfo sent their whole peer record; clients
+	// decode the fields they know and drop the bookkeeping
+	old := `{"id":"peer-a","public_key":"a2V5","virtual_ip":"10.100.0.2","hostname":"laptop","os":"linux",` +
+		`"allowed_ips":["10.100.0.2/32"],"exit_node":false,"last_heartbeat":"2024-01-02T03:04:05Z","online":true,` +
+		`"status":"pending","created_at":"2024-01-01T00:00:00Z","history":[{"online":true,"time":"2024-01-02T03:04:05Z"}]}`
+
+	var peer PeerInfo
+	if err := json.Unmarshal([]byte(old), &peer); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := PeerInfo{
+		ID:         "peer-a",
+		Hostname:   "laptop",
+		PublicKey:  "a2V5",
+		VirtualIP:  "10.100.0.2",
+		AllowedIPs: []string{"10.100.0.2/32"},
+		Online:     true,
+	}
+	if !reflect.DeepEqual(peer, want) {
+		t.Errorf("decoded:\n got %+v\nwant %+v", peer, want)
+	}
+}
+
+func TestPeerUpdateWireFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		update PeerUpdate
+		want   string
+	}{
+		{
+			name:   "add",
+			update: PeerUpdate{Action: "add", Peer: &PeerInfo{ID: "peer-a", PublicKey: "a2V5", AllowedIPs: []string{}}},
+			want:   `{"action":"add","peer":{"id":"peer-a","hostname":"","public_key":"a2V5","virtual_ip":"","allowed_ips":[],"online":false,"exit_node":false}}`,
+		},
+		{
+			name:   "urgent remove",
+			update: PeerUpdate{Action: "remove", PeerID: "peer-a", Urgent: true},
+			want:   `{"action":"remove","peer_id":"peer-a","urgent":true}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.update)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("encoding:\n got %s\nwant %s", data, tt.want)
+			}
+
+			var decoded PeerUpdate
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("Unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, tt.update) {
+				t.Errorf("round trip:\n got %+v\nwant %+v", decoded, tt.update)
+			}
+		})
+	}
+}
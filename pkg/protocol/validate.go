@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ErrorCodeInvalidParameter is the Code of a 400 response refusing a peer
+// ID, hostname or other parameter that is malformed
+const ErrorCodeInvalidParameter = "INVALID_PARAMETER"
+
+// Limits on identifiers and names accepted from requests
+const (
+	MaxIDLength   = 64
+	MaxNameLength = 253 // The longest DNS name
+)
+
+// identifierPattern matches IDs and names: no separators, spaces, slashes
+// or escapes that could change meaning in a URL or file path
+var identifierPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ErrorResponse is the body of a request refused before it reached the
+// endpoint's own logic
+type ErrorResponse struct {
+	Success   bool   `json:"success"`
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	Parameter string `json:"parameter,omitempty"` // The refused parameter, with ErrorCodeInvalidParameter
+}
+
+// ValidateID checks an identifier such as a peer ID or auth key ID. IDs are
+// used as map keys, in URL paths and next to file names, so they are limited
+// to letters, digits, '-', '_' and '.', and may not start with a separator.
+func ValidateID(id string) error {
+	return validateIdentifier(id, MaxIDLength)
+}
+
+// ValidateName checks a hostname-like name, as reported by a peer
+func ValidateName(name string) error {
+	return validateIdentifier(name, MaxNameLength)
+}
+
+func validateIdentifier(value string, maxLength int) error {
+	if value == "" {
+		return fmt.Errorf("is empty")
+	}
+	if len(value) > maxLength {
+		return fmt.Errorf("is longer than %d characters", maxLength)
+	}
+	if !identifierPattern.MatchString(value) {
+		return fmt.Errorf("may contain only letters, digits, '-', '_' and '.', starting with a letter or digit")
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is a parsed semantic version
+type Semver struct {
+	Major, Minor, Patch int
+	Prerelease          []string // Dot-separated identifiers after '-'
+	Commits             int      // Commits past the tag in git describe output
+}
+
+// Parse parses a semantic version such as "v1.2.3", "1.2.3-rc.1+build" or
+// "1.2". The "v" prefix, minor and patch are optional and build metadata is
+// ignored. Output of git describe, "v1.2.3-4-gabcdef0[-dirty]", parses as a
+// version 4 commits past v1.2.3.
+func Parse(v string) (Semver, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s = strings.TrimSuffix(s, "-dirty")
+
+	var parsed Semver
+	core, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		if commits, ok := describeCommits(pre); ok {
+			parsed.Commits = commits
+		} else {
+			parsed.Prerelease = strings.Split(pre, ".")
+			for _, id := range parsed.Prerelease {
+				if id == "" {
+					return Semver{}, fmt.Errorf("invalid version %q: empty prerelease identifier", v)
+				}
+			}
+		}
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return Semver{}, fmt.Errorf("invalid version %q", v)
+	}
+	numbers := []*int{&parsed.Major, &parsed.Minor, &parsed.Patch}
+	for i, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return Semver{}, fmt.Errorf("invalid version %q", v)
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return Semver{}, fmt.Errorf("invalid version %q", v)
+		}
+		*numbers[i] = n
+	}
+	return parsed, nil
+}
+
+// describeCommits recognizes the "<n>-g<hash>" suffix git describe appends
+// to the tag
+func describeCommits(suffix string) (int, bool) {
+	count, hash, ok := strings.Cut(suffix, "-g")
+	if !ok || hash == "" {
+		return 0, false
+	}
+	if _, err := strconv.ParseUint(hash, 16, 64); err != nil {
+		return 0, false
+	}
+	if count == "" || strings.Trim(count, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Compare returns -1, 0 or 1 as a is older than, the same as or newer than b,
+// following semver precedence: a prerelease comes before its release, and a
+// git describe version after its tag.
+func Compare(a, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer than o
+func (v Semver) Compare(o Semver) int {
+	for _, pair := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if c := compareInts(pair[0], pair[1]); c != 0 {
+			return c
+		}
+	}
+
+	// A release outranks its prereleases
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) > 0:
+		return 1
+	case len(v.Prerelease) > 0 && len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	if c := compareInts(len(v.Prerelease), len(o.Prerelease)); c != 0 {
+		return c
+	}
+
+	return compareInts(v.Commits, o.Commits)
+}
+
+// comparePrerelease orders two prerelease identifiers: numeric ones
+// numerically and before alphanumeric ones, which compare as strings
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "1.99.99", 1},
+
+		// Prereleases come before their release, in semver order
+		{"1.2.3-rc.1", "1.2.3", -1},
+		{"1.2.3-rc.1", "1.2.2", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1+build", 0},
+
+		// git describe output sorts after its tag and before the next one
+		{"v1.2.3-4-gabcdef0", "1.2.3", 1},
+		{"v1.2.3-4-gabcdef0", "1.2.3-5-g1234567", -1},
+		{"v1.2.3-4-gabcdef0-dirty", "v1.2.3-4-gabcdef0", 0},
+		{"v1.2.3-4-gabcdef0", "1.2.4", -1},
+		{"v1.2.3-rc.1-4-gabcdef0", "1.2.3", -1},
+	}
+
+	for _, tt := range tests {
+		got, err := Compare(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("Compare(%q, %q): %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if back, _ := Compare(tt.b, tt.a); back != -tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.b, tt.a, back, -tt.want)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"v",
+		"1.",
+		".1",
+		"1..2",
+		"1.2.3.4",
+		"1.x",
+		"x.y.z",
+		"1.2.3-",
+		"1.2.3-rc..1",
+		"1.2.3-rc.",
+		"-1.2.3",
+		"1.-2.3",
+		"1.2.3 4",
+		"1.2.+3",
+		"99999999999999999999.0.0",
+		"dev",
+	} {
+		if parsed, err := Parse(v); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", v, parsed)
+		}
+		if _, err := Compare(v, "1.0.0"); err == nil {
+			t.Errorf("Compare(%q, 1.0.0) succeeded", v)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	v, err := Parse(" v1.2.3-rc.1+build ")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if v.Major != 1 || v.Minor != 2 || v.Patch != 3 || len(v.Prerelease) != 2 || v.Prerelease[0] != "rc" || v.Prerelease[1] != "1" || v.Commits != 0 {
+		t.Errorf("Parse = %+v", v)
+	}
+
+	v, err = Parse("v0.9.0-12-g0123abc-dirty")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if v.Major != 0 || v.Minor != 9 || v.Patch != 0 || len(v.Prerelease) != 0 || v.Commits != 12 {
+		t.Errorf("Parse = %+v", v)
+	}
+}
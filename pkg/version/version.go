@@ -0,0 +1,70 @@
+// Package version describes the running binary. Release builds are stamped
+// by the linker, as the Makefile does:
+//
+//	go build -ldflags "-X github.com/vpn/wireguard-mesh/pkg/version.Version=v1.2.3
+//	    -X github.com/vpn/wireguard-mesh/pkg/version.Commit=abcdef0
+//	    -X github.com/vpn/wireguard-mesh/pkg/version.Date=2025-01-01T12:00:00Z"
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Dev is the value of anything a build was not stamped with
+const Dev = "dev"
+
+// Set at build time with -ldflags -X
+var (
+	// Version is the semantic version of this build
+	Version = Dev
+	// Commit is the git commit the build was made from
+	Commit = Dev
+	// Date is when that commit was made, in RFC 3339, so that rebuilding
+	// the same commit gives the same binary
+	Date = Dev
+)
+
+// Info describes a build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	return Info{
+		Version:   orDev(Version),
+		Commit:    orDev(Commit),
+		Date:      orDev(Date),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+}
+
+// String formats the build information for --version output
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s, %s)", i.Version, i.Commit, i.Date, i.GoVersion, i.Platform)
+}
+
+// UserAgent returns the User-Agent a program sends, e.g.
+// "wireguard-mesh-client/v1.2.3"
+func UserAgent(program string) string {
+	return program + "/" + orDev(Version)
+}
+
+// IsDev reports whether v names an unstamped development build
+func IsDev(v string) bool {
+	return v == Dev || v == "(devel)"
+}
+
+// orDev guards against a value stamped as an empty string
+func orDev(v string) string {
+	if v == "" {
+		return Dev
+	}
+	return v
+}